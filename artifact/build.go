@@ -0,0 +1,107 @@
+package artifact
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// buildDirs are the build output directories a frontend zip may wrap its
+// files in, in the order they are looked for
+var buildDirs = []string{"dist", "build", "out", "public"}
+
+// largestFiles is how many of the biggest files a BuildReport lists
+const largestFiles = 5
+
+// BuildReport describes an extracted frontend build, so a wrong or broken
+// zip shows up in the deploy response
+type BuildReport struct {
+	Size     int64       `json:"size"` // bytes on disk, uncompressed
+	Files    int         `json:"files"`
+	Largest  []BuildFile `json:"largest"`
+	HasIndex bool        `json:"has_index"`           // index.html at the top or in the build dir
+	BuildDir string      `json:"build_dir,omitempty"` // e.g. "dist" when the files are wrapped in one
+	Warnings []string    `json:"warnings,omitempty"`
+}
+
+// BuildFile is one file of a build
+type BuildFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// BuildDir returns the build output directory (dist, build, out or public)
+// holding dir's index.html, or "" if there is none
+func BuildDir(dir string) string {
+	for _, sub := range buildDirs {
+		if _, err := os.Stat(filepath.Join(dir, sub, "index.html")); err == nil {
+			return sub
+		}
+	}
+	return ""
+}
+
+// precompressed reports whether path is a .gz or .br copy of another file
+// in the build, such as those written for a site's precompress option
+func precompressed(path string) bool {
+	ext := filepath.Ext(path)
+	if ext != ".gz" && ext != ".br" {
+		return false
+	}
+	_, err := os.Stat(strings.TrimSuffix(path, ext))
+	return err == nil
+}
+
+// InspectBuild walks an extracted frontend build and reports its size, its
+// largest files and where its index.html is. Compressed copies of its files
+// are not counted.
+func InspectBuild(dir string) (*BuildReport, error) {
+	report := &BuildReport{Largest: []BuildFile{}, BuildDir: BuildDir(dir)}
+	var topDirs []string
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if d.IsDir() {
+			if filepath.Dir(rel) == "." && rel != "." {
+				topDirs = append(topDirs, rel)
+			}
+			return nil
+		}
+		if precompressed(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		report.Files++
+		report.Size += info.Size()
+		report.Largest = append(report.Largest, BuildFile{Path: filepath.ToSlash(rel), Size: info.Size()})
+		sort.Slice(report.Largest, func(a, b int) bool { return report.Largest[a].Size > report.Largest[b].Size })
+		if len(report.Largest) > largestFiles {
+			report.Largest = report.Largest[:largestFiles]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("inspect build: %w", err)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "index.html"))
+	report.HasIndex = err == nil || report.BuildDir != ""
+	switch {
+	case report.Files == 0:
+		report.Warnings = append(report.Warnings, "the build is empty")
+	case !report.HasIndex && len(topDirs) == 1:
+		report.Warnings = append(report.Warnings, fmt.Sprintf("no index.html at the top; the files are wrapped in %s/, which is not served", topDirs[0]))
+	case !report.HasIndex:
+		report.Warnings = append(report.Warnings, "no index.html at the top or in dist/, build/, out/ or public/")
+	}
+	return report, nil
+}
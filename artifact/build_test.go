@@ -0,0 +1,78 @@
+package artifact
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInspectBuild(t *testing.T) {
+	write := func(dir string, files map[string]int) {
+		t.Helper()
+		for name, size := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("site at the top", func(t *testing.T) {
+		dir := t.TempDir()
+		write(dir, map[string]int{"index.html": 10, "assets/app.js": 500, "assets/app.css": 100, "favicon.ico": 20})
+		report, err := InspectBuild(dir)
+		if err != nil {
+			t.Fatalf("InspectBuild() error = %v", err)
+		}
+		if report.Files != 4 || report.Size != 630 || !report.HasIndex || report.BuildDir != "" || len(report.Warnings) != 0 {
+			t.Errorf("report = %+v", report)
+		}
+		if report.Largest[0] != (BuildFile{Path: "assets/app.js", Size: 500}) {
+			t.Errorf("largest = %+v", report.Largest)
+		}
+	})
+
+	t.Run("build dir", func(t *testing.T) {
+		dir := t.TempDir()
+		write(dir, map[string]int{"dist/index.html": 10})
+		report, err := InspectBuild(dir)
+		if err != nil {
+			t.Fatalf("InspectBuild() error = %v", err)
+		}
+		if !report.HasIndex || report.BuildDir != "dist" || len(report.Warnings) != 0 {
+			t.Errorf("report = %+v", report)
+		}
+	})
+
+	t.Run("wrapped in a project dir", func(t *testing.T) {
+		dir := t.TempDir()
+		write(dir, map[string]int{"my-app/dist/index.html": 10, "my-app/package.json": 10})
+		report, err := InspectBuild(dir)
+		if err != nil {
+			t.Fatalf("InspectBuild() error = %v", err)
+		}
+		if report.HasIndex || len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0], "my-app/") {
+			t.Errorf("report = %+v", report)
+		}
+	})
+
+	t.Run("largest files are capped", func(t *testing.T) {
+		dir := t.TempDir()
+		files := map[string]int{"index.html": 1}
+		for i := 0; i < 10; i++ {
+			files[filepath.Join("chunks", strings.Repeat("a", i+1)+".js")] = 100 + i
+		}
+		write(dir, files)
+		report, err := InspectBuild(dir)
+		if err != nil {
+			t.Fatalf("InspectBuild() error = %v", err)
+		}
+		if len(report.Largest) != largestFiles || report.Largest[0].Size != 109 {
+			t.Errorf("largest = %+v", report.Largest)
+		}
+	})
+}
@@ -0,0 +1,260 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// Fetch errors the caller should report as a bad request
+var (
+	ErrInvalidURL        = errors.New("invalid artifact_url")
+	ErrHostNotAllowed    = errors.New("artifact_url host is not in artifacts.allowed_hosts")
+	ErrAddressNotAllowed = errors.New("artifact_url resolves to a private address, which needs its host in artifacts.allowed_hosts")
+	ErrChecksumMismatch  = errors.New("artifact checksum mismatch")
+	ErrTooLarge          = errors.New("artifact exceeds the size limit")
+)
+
+// Download is an artifact fetched to a temporary file
+type Download struct {
+	*os.File
+	URL    string
+	Size   int64
+	SHA256 string
+}
+
+// Close closes and removes the temporary file
+func (d *Download) Close() error {
+	err := d.File.Close()
+	os.Remove(d.File.Name())
+	return err
+}
+
+// Fetch downloads an https:// or s3:// artifact to a temporary file, rewound
+// and ready to read. If wantSHA256 is set the download must match it.
+// Downloads larger than maxBytes are refused.
+func Fetch(cfg config.ArtifactsConfig, rawURL, wantSHA256 string, maxBytes int64) (*Download, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if !hostAllowed(cfg.AllowedHosts, u.Host) {
+		return nil, fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Host)
+	}
+
+	var req *http.Request
+	switch u.Scheme {
+	case "https":
+		req, err = http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+		}
+	case "s3":
+		req, err = s3Request(cfg, u, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: scheme must be https or s3", ErrInvalidURL)
+	}
+
+	resp, err := newClient(cfg, u.Scheme).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// The body isn't passed on: it could be anything the URL points at
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download: %s", resp.Status)
+	}
+	if maxBytes > 0 && resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+	}
+
+	f, err := os.CreateTemp("", "shipyard-artifact-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp file: %w", err)
+	}
+	d := &Download{File: f, URL: redact(u)}
+
+	h := sha256.New()
+	var body io.Reader = resp.Body
+	if maxBytes > 0 {
+		// One extra byte distinguishes "exactly at the limit" from "over it"
+		body = io.LimitReader(resp.Body, maxBytes+1)
+	}
+	n, err := io.Copy(io.MultiWriter(f, h), body)
+	if err != nil {
+		d.Close()
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	if maxBytes > 0 && n > maxBytes {
+		d.Close()
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, maxBytes)
+	}
+
+	d.Size = n
+	d.SHA256 = hex.EncodeToString(h.Sum(nil))
+	if wantSHA256 != "" && !strings.EqualFold(wantSHA256, d.SHA256) {
+		d.Close()
+		return nil, fmt.Errorf("%w: got %s, want %s", ErrChecksumMismatch, d.SHA256, wantSHA256)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("rewind: %w", err)
+	}
+	return d, nil
+}
+
+// newClient returns the client for a download. Redirects must stay on https
+// and allowed hosts; s3 requests aren't redirected. Private, loopback and
+// link-local addresses are refused unless their host is listed in
+// allowed_hosts or is the configured s3_endpoint.
+func newClient(cfg config.ArtifactsConfig, scheme string) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := *dialer
+		if !privateAllowed(cfg, addr) {
+			d.Control = refusePrivate
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+
+	return &http.Client{
+		Timeout:   cfg.DownloadTimeoutDuration(),
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			switch {
+			case scheme == "s3":
+				return fmt.Errorf("%w: s3 requests aren't redirected", ErrInvalidURL)
+			case len(via) >= 10:
+				return errors.New("stopped after 10 redirects")
+			case req.URL.Scheme != "https":
+				return fmt.Errorf("%w: redirected to %s", ErrInvalidURL, redact(req.URL))
+			case !hostAllowed(cfg.AllowedHosts, req.URL.Host):
+				return fmt.Errorf("%w: redirected to %s", ErrHostNotAllowed, req.URL.Host)
+			}
+			return nil
+		},
+	}
+}
+
+// privateAllowed reports whether addr ("host:port") may be a private
+// address: its host is named in allowed_hosts, or is the s3_endpoint's
+func privateAllowed(cfg config.ArtifactsConfig, addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if len(cfg.AllowedHosts) > 0 && hostAllowed(cfg.AllowedHosts, addr) {
+		return true
+	}
+	if e, err := url.Parse(cfg.S3Endpoint); err == nil && cfg.S3Endpoint != "" {
+		return e.Hostname() == host
+	}
+	return false
+}
+
+// specialUse are the IANA special-purpose ranges that refusePrivate refuses
+// besides the private, loopback, link-local, multicast and unspecified ones
+// netip classifies. None of them holds a public artifact host.
+var specialUse = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // shared address space (carrier-grade NAT)
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation (TEST-NET-1)
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation (TEST-NET-2)
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation (TEST-NET-3)
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, and the broadcast address
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use IPv4/IPv6 translation
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4, which wraps any IPv4 address
+}
+
+// nat64 is the well-known NAT64 prefix. Its addresses are checked as the
+// IPv4 address they carry.
+var nat64 = netip.MustParsePrefix("64:ff9b::/96")
+
+// refusePrivate is a net.Dialer Control that refuses to connect to any
+// address that isn't public: private, loopback, link-local, multicast,
+// unspecified, or in specialUse. It runs after DNS, so a name can't resolve
+// to one of them past the check.
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !publicAddr(ip) {
+		return fmt.Errorf("%w: %s", ErrAddressNotAllowed, host)
+	}
+	return nil
+}
+
+// publicAddr reports whether ip is a globally routable unicast address
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if nat64.Contains(ip) {
+		b := ip.As16()
+		ip = netip.AddrFrom4([4]byte(b[12:]))
+	}
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range specialUse {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// hostAllowed reports whether host, with or without its port, matches the
+// allowlist (exact or glob, e.g. "*.r2.dev"). An empty allowlist allows any
+// host that isn't a private address.
+func hostAllowed(allowed []string, host string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	for _, pattern := range allowed {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redact drops the query string so presigned credentials aren't logged or returned
+func redact(u *url.URL) string {
+	r := *u
+	r.RawQuery = ""
+	r.User = nil
+	return r.String()
+}
@@ -0,0 +1,218 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Artifact kinds
+const (
+	KindFrontend = "frontend"
+	KindBackend  = "backend"
+)
+
+// Backend artifact formats
+const (
+	FormatZip    = "zip"    // a zip holding the binary
+	FormatBinary = "binary" // the executable itself
+)
+
+// ErrNotStored is returned when no artifact is kept for a site, kind and commit
+var ErrNotStored = errors.New("artifact not stored")
+
+// Meta describes a stored artifact and how it was deployed
+type Meta struct {
+	Site      string    `json:"site"`
+	Kind      string    `json:"kind"`
+	Commit    string    `json:"commit"`
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	Stored    time.Time `json:"stored"`
+	SourceURL string    `json:"source_url,omitempty"`
+
+	// Deploy parameters needed to redeploy
+	NginxConfig   string `json:"nginx_config,omitempty"`   // rendered, frontend only
+	NginxTemplate string `json:"nginx_template,omitempty"` // as uploaded, before rendering
+	BinaryName    string `json:"binary_name,omitempty"`    // backend only
+	BinaryPath    string `json:"binary_path,omitempty"`    // backend only: the binary's path in the zip
+	Format        string `json:"format,omitempty"`         // backend only: FormatBinary, or empty for a zip
+	Subdomain     string `json:"subdomain,omitempty"`      // wildcard sites only
+
+	// Build describes the frontend build once it has been extracted
+	Build *BuildReport `json:"build,omitempty"`
+}
+
+// Store keeps deployed artifacts on disk as <dir>/<site>/<commit>.<kind>.zip
+// with a .json sidecar, retaining the newest keep per site and kind
+type Store struct {
+	dir  string
+	keep int
+}
+
+// NewStore creates a Store in dir. A keep of zero or less disables storage.
+func NewStore(dir string, keep int) *Store {
+	return &Store{dir: dir, keep: keep}
+}
+
+// Enabled reports whether artifacts are kept
+func (s *Store) Enabled() bool {
+	return s != nil && s.keep > 0
+}
+
+// Put stores the artifact read from r, then prunes old artifacts for the
+// site and kind. The returned Meta has SHA256, Size and Stored filled in.
+func (s *Store) Put(meta Meta, r io.Reader) (*Meta, error) {
+	siteDir := filepath.Join(s.dir, meta.Site)
+	if err := os.MkdirAll(siteDir, 0700); err != nil {
+		return nil, fmt.Errorf("create artifact directory: %w", err)
+	}
+
+	base := s.base(meta.Site, meta.Kind, meta.Commit)
+	tmp, err := os.CreateTemp(siteDir, ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("create artifact file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err != nil {
+		return nil, fmt.Errorf("write artifact: %w", err)
+	}
+
+	meta.Size = n
+	meta.SHA256 = hex.EncodeToString(h.Sum(nil))
+	meta.Stored = time.Now().UTC()
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode artifact metadata: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), base+".zip"); err != nil {
+		return nil, fmt.Errorf("store artifact: %w", err)
+	}
+	if err := os.WriteFile(base+".json", data, 0600); err != nil {
+		return nil, fmt.Errorf("write artifact metadata: %w", err)
+	}
+
+	if err := s.prune(meta.Site, meta.Kind); err != nil {
+		return &meta, fmt.Errorf("prune artifacts: %w", err)
+	}
+	return &meta, nil
+}
+
+// Open returns a stored artifact and its metadata
+func (s *Store) Open(site, kind, commit string) (*os.File, *Meta, error) {
+	base := s.base(site, kind, commit)
+	data, err := os.ReadFile(base + ".json")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("%w: %s %s@%s", ErrNotStored, kind, site, commit)
+		}
+		return nil, nil, err
+	}
+	var meta Meta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, nil, fmt.Errorf("parse artifact metadata: %w", err)
+	}
+
+	f, err := os.Open(base + ".zip")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("%w: %s %s@%s", ErrNotStored, kind, site, commit)
+		}
+		return nil, nil, err
+	}
+	return f, &meta, nil
+}
+
+// SetBuild records the report of a stored frontend artifact's extracted build
+func (s *Store) SetBuild(site, kind, commit string, build *BuildReport) error {
+	base := s.base(site, kind, commit)
+	data, err := os.ReadFile(base + ".json")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s %s@%s", ErrNotStored, kind, site, commit)
+		}
+		return err
+	}
+	var meta Meta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("parse artifact metadata: %w", err)
+	}
+	meta.Build = build
+
+	data, err = json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode artifact metadata: %w", err)
+	}
+	tmp := base + ".json.tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write artifact metadata: %w", err)
+	}
+	return os.Rename(tmp, base+".json")
+}
+
+// List returns the stored artifacts for a site, newest first
+func (s *Store) List(site string) ([]Meta, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, site, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	metas := []Meta{}
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var meta Meta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", filepath.Base(path), err)
+		}
+		metas = append(metas, meta)
+	}
+
+	sort.Slice(metas, func(i, j int) bool { return metas[i].Stored.After(metas[j].Stored) })
+	return metas, nil
+}
+
+// prune removes all but the newest keep artifacts of a kind for a site
+func (s *Store) prune(site, kind string) error {
+	metas, err := s.List(site)
+	if err != nil {
+		return err
+	}
+
+	kept := 0
+	for _, meta := range metas {
+		if meta.Kind != kind {
+			continue
+		}
+		kept++
+		if kept <= s.keep {
+			continue
+		}
+		base := s.base(site, kind, meta.Commit)
+		os.Remove(base + ".zip")
+		os.Remove(base + ".json")
+	}
+	return nil
+}
+
+func (s *Store) base(site, kind, commit string) string {
+	return filepath.Join(s.dir, site, strings.ToLower(commit)+"."+kind)
+}
@@ -0,0 +1,56 @@
+package artifact
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStore_PutOpenPrune(t *testing.T) {
+	s := NewStore(t.TempDir(), 2)
+
+	for _, commit := range []string{"aaaaaaa", "bbbbbbb", "ccccccc"} {
+		if _, err := s.Put(Meta{Site: "example.com", Kind: KindFrontend, Commit: commit}, strings.NewReader("zip-"+commit)); err != nil {
+			t.Fatalf("Put(%s) error = %v", commit, err)
+		}
+	}
+	// A backend artifact doesn't count against the frontend retention
+	if _, err := s.Put(Meta{Site: "example.com", Kind: KindBackend, Commit: "aaaaaaa", BinaryName: "api"}, strings.NewReader("bin")); err != nil {
+		t.Fatalf("Put(backend) error = %v", err)
+	}
+
+	if _, _, err := s.Open("example.com", KindFrontend, "aaaaaaa"); !errors.Is(err, ErrNotStored) {
+		t.Errorf("oldest frontend artifact not pruned: err = %v", err)
+	}
+
+	f, meta, err := s.Open("example.com", KindFrontend, "ccccccc")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	if string(data) != "zip-ccccccc" {
+		t.Errorf("content = %q", data)
+	}
+	if meta.Size != int64(len(data)) || meta.SHA256 == "" {
+		t.Errorf("meta = %+v", meta)
+	}
+
+	f, meta, err = s.Open("example.com", KindBackend, "aaaaaaa")
+	if err != nil {
+		t.Fatalf("Open(backend) error = %v", err)
+	}
+	f.Close()
+	if meta.BinaryName != "api" {
+		t.Errorf("BinaryName = %q, want api", meta.BinaryName)
+	}
+
+	metas, err := s.List("example.com")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(metas) != 3 {
+		t.Errorf("List() returned %d artifacts, want 3", len(metas))
+	}
+}
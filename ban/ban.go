@@ -0,0 +1,365 @@
+// Package ban denies IPs that abuse the sites. nginx writes every request to
+// a log of its own; an IP whose requests trip a [ban] rule is written to a
+// deny file the http block includes, and nginx is reloaded. Bans expire on
+// their own and can be listed and cleared through the API.
+package ban
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// scanInterval is how often the log is read and expired bans are lifted
+const scanInterval = 5 * time.Second
+
+// maxLogSize is the size at which the ban log is truncated once read
+const maxLogSize = 64 << 20
+
+// RuleManual is the rule of bans added through the API
+const RuleManual = "manual"
+
+// Ban is an IP every site denies until Until
+type Ban struct {
+	IP      string    `json:"ip"`
+	Site    string    `json:"site,omitempty"` // the site whose requests tripped the rule
+	Rule    string    `json:"rule"`
+	Count   int       `json:"count"` // matching requests within the rule's window
+	Created time.Time `json:"created"`
+	Until   time.Time `json:"until"`
+}
+
+// hitKey identifies the requests counted towards a rule
+type hitKey struct {
+	rule string
+	ip   string
+}
+
+// Manager watches the ban log and keeps the deny file in step with the bans
+type Manager struct {
+	cfg    *config.Config
+	path   string       // saved bans
+	reload func() error // reloads nginx after the deny file changes
+
+	mu       sync.Mutex
+	bans     map[string]Ban
+	hits     map[hitKey][]time.Time
+	offset   int64
+	started  bool
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// StatePath returns where bans are saved within a state directory
+func StatePath(stateDir string) string {
+	return filepath.Join(stateDir, "bans.json")
+}
+
+// NewManager creates a ban manager and loads the saved bans; call Start to
+// begin watching. reload is called after the deny file changes.
+func NewManager(cfg *config.Config, reload func() error) *Manager {
+	m := &Manager{
+		cfg:    cfg,
+		path:   StatePath(cfg.StateDir()),
+		reload: reload,
+		bans:   make(map[string]Ban),
+		hits:   make(map[hitKey][]time.Time),
+		done:   make(chan struct{}),
+	}
+	if data, err := os.ReadFile(m.path); err == nil {
+		var bans []Ban
+		if err := json.Unmarshal(data, &bans); err != nil {
+			slog.Warn("ignoring unreadable bans file", "path", m.path, "error", err)
+		}
+		for _, b := range bans {
+			m.bans[b.IP] = b
+		}
+	}
+	return m
+}
+
+// Start reads the log every scanInterval, from its current end. It does
+// nothing unless [ban] is enabled.
+func (m *Manager) Start() {
+	if !m.cfg.Ban.Enabled {
+		return
+	}
+	if info, err := os.Stat(m.cfg.Ban.LogPath()); err == nil {
+		m.offset = info.Size()
+	}
+	m.started = true
+	if err := m.Expire(time.Now()); err != nil {
+		slog.Warn("apply bans", "error", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(scanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.Scan(time.Now()); err != nil {
+					slog.Warn("read ban log", "error", err)
+				}
+				if err := m.Expire(time.Now()); err != nil {
+					slog.Warn("lift expired bans", "error", err)
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops watching
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() { close(m.done) })
+}
+
+// Scan reads the lines nginx added to the log since the last scan and bans
+// the IPs that trip a rule
+func (m *Manager) Scan(now time.Time) error {
+	logPath := m.cfg.Ban.LogPath()
+	f, err := os.Open(logPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open ban log: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat ban log: %w", err)
+	}
+	m.mu.Lock()
+	if info.Size() < m.offset {
+		m.offset = 0 // truncated or rotated
+	}
+	offset := m.offset
+	m.mu.Unlock()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seek ban log: %w", err)
+	}
+
+	var banned []Ban
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break // an incomplete last line is read on the next scan
+		}
+		offset += int64(len(line))
+		if r, ok := ParseLine(strings.TrimSuffix(line, "\n")); ok {
+			if b, ok := m.Observe(r, now); ok {
+				banned = append(banned, b)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.offset = offset
+	m.mu.Unlock()
+	if offset >= maxLogSize {
+		// nginx appends, so it carries on at the start of the file
+		if err := os.Truncate(logPath, 0); err == nil {
+			m.mu.Lock()
+			m.offset = 0
+			m.mu.Unlock()
+		}
+	}
+
+	if len(banned) == 0 {
+		return nil
+	}
+	for _, b := range banned {
+		slog.Warn("banned abusive client", "ip", b.IP, "site", b.Site, "rule", b.Rule, "requests", b.Count, "until", b.Until)
+	}
+	return m.apply()
+}
+
+// Observe counts a request towards the rules it matches, and bans its IP if
+// one is tripped. It returns the new ban.
+func (m *Manager) Observe(r Request, now time.Time) (Ban, bool) {
+	if ignored(m.cfg.Ban, r.IP) {
+		return Ban{}, false
+	}
+	site := siteForHost(m.cfg, r.Host)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.bans[r.IP]; ok {
+		return Ban{}, false
+	}
+	for _, rule := range m.cfg.Ban.BanRules() {
+		if !Matches(rule, site, r) {
+			continue
+		}
+		key := hitKey{rule: rule.Name, ip: r.IP}
+		cutoff := r.Time.Add(-time.Duration(rule.Window) * time.Second)
+		hits := append(m.hits[key], r.Time)
+		for len(hits) > 0 && !hits[0].After(cutoff) {
+			hits = hits[1:]
+		}
+		m.hits[key] = hits
+		if len(hits) <= rule.Max {
+			continue
+		}
+
+		b := Ban{IP: r.IP, Site: site, Rule: rule.Name, Count: len(hits), Created: now, Until: now.Add(m.cfg.Ban.DurationFor(rule))}
+		m.bans[r.IP] = b
+		for k := range m.hits {
+			if k.ip == r.IP {
+				delete(m.hits, k)
+			}
+		}
+		return b, true
+	}
+	return Ban{}, false
+}
+
+// Expire lifts the bans that have run out and forgets counts older than
+// every rule's window. The deny file is rewritten when bans were lifted, or
+// on the first call.
+func (m *Manager) Expire(now time.Time) error {
+	m.mu.Lock()
+	lifted := 0
+	for ip, b := range m.bans {
+		if !now.Before(b.Until) {
+			delete(m.bans, ip)
+			lifted++
+		}
+	}
+	longest := 0
+	for _, rule := range m.cfg.Ban.BanRules() {
+		longest = max(longest, rule.Window)
+	}
+	for k, hits := range m.hits {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) > time.Duration(longest)*time.Second {
+			delete(m.hits, k)
+		}
+	}
+	m.mu.Unlock()
+
+	if lifted == 0 && !m.needsWrite() {
+		return nil
+	}
+	if lifted > 0 {
+		slog.Info("lifted expired bans", "count", lifted)
+	}
+	return m.apply()
+}
+
+// needsWrite reports whether the deny file is missing
+func (m *Manager) needsWrite() bool {
+	_, err := os.Stat(m.cfg.Ban.IncludePath(m.cfg.Nginx))
+	return os.IsNotExist(err)
+}
+
+// List returns the bans in force, newest first
+func (m *Manager) List() []Ban {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bans := make([]Ban, 0, len(m.bans))
+	for _, b := range m.bans {
+		bans = append(bans, b)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		if !bans[i].Created.Equal(bans[j].Created) {
+			return bans[i].Created.After(bans[j].Created)
+		}
+		return bans[i].IP < bans[j].IP
+	})
+	return bans
+}
+
+// Add bans ip by hand for d
+func (m *Manager) Add(ip string, d time.Duration) (Ban, error) {
+	if net.ParseIP(ip) == nil {
+		return Ban{}, fmt.Errorf("%q is not an IP address", ip)
+	}
+	now := time.Now().UTC()
+	b := Ban{IP: ip, Rule: RuleManual, Created: now, Until: now.Add(d)}
+	m.mu.Lock()
+	m.bans[ip] = b
+	m.mu.Unlock()
+	return b, m.apply()
+}
+
+// Clear lifts the ban on ip, or every ban when ip is "". It returns how many
+// bans were lifted.
+func (m *Manager) Clear(ip string) (int, error) {
+	m.mu.Lock()
+	cleared := 0
+	for banned := range m.bans {
+		if ip == "" || banned == ip {
+			delete(m.bans, banned)
+			cleared++
+		}
+	}
+	m.mu.Unlock()
+	if cleared == 0 {
+		return 0, nil
+	}
+	return cleared, m.apply()
+}
+
+// apply saves the bans, rewrites the deny file and reloads nginx
+func (m *Manager) apply() error {
+	bans := m.List()
+	data, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode bans: %w", err)
+	}
+	if err := writeFile(m.path, data); err != nil {
+		return fmt.Errorf("save bans: %w", err)
+	}
+	if !m.cfg.Ban.Enabled {
+		return nil
+	}
+	if err := writeFile(m.cfg.Ban.IncludePath(m.cfg.Nginx), []byte(DenyConf(bans))); err != nil {
+		return fmt.Errorf("write deny file: %w", err)
+	}
+	if m.reload == nil {
+		return nil
+	}
+	return m.reload()
+}
+
+// DenyConf returns the deny file for bans
+func DenyConf(bans []Ban) string {
+	var sb strings.Builder
+	sb.WriteString("# MANAGED BY SHIPYARD — DO NOT EDIT\n")
+	sb.WriteString("# Clients banned by [ban] rules; see GET /bans\n")
+	for _, b := range bans {
+		if net.ParseIP(b.IP) == nil {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("deny %s;  # %s until %s\n", b.IP, b.Rule, b.Until.UTC().Format(time.RFC3339)))
+	}
+	return sb.String()
+}
+
+// writeFile replaces path with data through a temporary file
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,224 @@
+package ban
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func testConfig(t *testing.T) *config.Config {
+	dir := t.TempDir()
+	return &config.Config{
+		Self:  config.SelfConfig{StateDir: dir},
+		Nginx: config.NginxConfig{OverrideConf: filepath.Join(dir, "override.conf")},
+		Ban: config.BanConfig{
+			Enabled: true,
+			Log:     filepath.Join(dir, "ban.log"),
+			Ignore:  []string{"10.0.0.0/8"},
+			Rules: []config.BanRule{
+				{Name: "404_flood", Status: []int{404}, Max: 3, Window: 60},
+				{Name: "login", Sites: []string{"*.example.com"}, Paths: []string{"/login"}, Methods: []string{"POST"}, Max: 2, Window: 60, Duration: 60},
+			},
+		},
+		Site: map[string]config.SiteConfig{
+			"example.com":     {Aliases: []string{"www.example.com"}},
+			"api.example.com": {},
+			"*.preview.net":   {},
+		},
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	r, ok := ParseLine("1700000000.123\t203.0.113.9\tWWW.example.com\t404\tGET\t/missing?x=1")
+	if !ok {
+		t.Fatal("ParseLine() should parse a well-formed line")
+	}
+	if r.IP != "203.0.113.9" || r.Host != "www.example.com" || r.Status != 404 || r.Method != "GET" || r.Path != "/missing" {
+		t.Errorf("ParseLine() = %+v", r)
+	}
+	if r.Time.UnixMilli() != 1700000000123 {
+		t.Errorf("Time = %v, want 1700000000.123", r.Time)
+	}
+
+	for _, line := range []string{"", "garbage", "x\t203.0.113.9\th\t404\tGET\t/", "1\tnot-an-ip\th\t404\tGET\t/"} {
+		if _, ok := ParseLine(line); ok {
+			t.Errorf("ParseLine(%q) should fail", line)
+		}
+	}
+}
+
+func TestSiteForHost(t *testing.T) {
+	cfg := testConfig(t)
+	for host, want := range map[string]string{
+		"example.com":      "example.com",
+		"www.example.com":  "example.com",
+		"api.example.com":  "api.example.com",
+		"pr-1.preview.net": "*.preview.net",
+		"a.b.preview.net":  "",
+		"unknown.test":     "",
+	} {
+		if got := siteForHost(cfg, host); got != want {
+			t.Errorf("siteForHost(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	rule := config.BanRule{Name: "login", Sites: []string{"*.example.com"}, Paths: []string{"/login"}, Methods: []string{"POST"}, Status: []int{401}}
+	hit := Request{Method: "post", Path: "/login/submit", Status: 401}
+	if !Matches(rule, "api.example.com", hit) {
+		t.Error("Matches() should match every filter")
+	}
+	misses := map[string]Request{
+		"status": {Method: "POST", Path: "/login", Status: 200},
+		"method": {Method: "GET", Path: "/login", Status: 401},
+		"path":   {Method: "POST", Path: "/", Status: 401},
+	}
+	for name, r := range misses {
+		if Matches(rule, "api.example.com", r) {
+			t.Errorf("Matches() should not match another %s", name)
+		}
+	}
+	if Matches(rule, "other.net", hit) {
+		t.Error("Matches() should not match another site")
+	}
+}
+
+func TestObserve_BansAfterMax(t *testing.T) {
+	m := NewManager(testConfig(t), nil)
+	now := time.Now()
+	r := Request{Time: now, IP: "203.0.113.9", Host: "example.com", Status: 404, Method: "GET", Path: "/x"}
+
+	for i := 0; i < 3; i++ {
+		if _, banned := m.Observe(r, now); banned {
+			t.Fatalf("request %d should not ban yet", i+1)
+		}
+	}
+	b, banned := m.Observe(r, now)
+	if !banned {
+		t.Fatal("the request over max should ban")
+	}
+	if b.Rule != "404_flood" || b.Site != "example.com" || b.Count != 4 || !b.Until.Equal(now.Add(config.DefaultBanDuration)) {
+		t.Errorf("ban = %+v", b)
+	}
+	if _, again := m.Observe(r, now); again {
+		t.Error("a banned IP should not be banned again")
+	}
+}
+
+func TestObserve_Window(t *testing.T) {
+	m := NewManager(testConfig(t), nil)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		// One request every 30s: never more than 2 within the 60s window
+		at := start.Add(time.Duration(i) * 30 * time.Second)
+		r := Request{Time: at, IP: "203.0.113.9", Host: "example.com", Status: 404, Method: "GET", Path: "/x"}
+		if _, banned := m.Observe(r, at); banned {
+			t.Fatalf("request %d should not ban: older requests are outside the window", i+1)
+		}
+	}
+}
+
+func TestObserve_Ignores(t *testing.T) {
+	m := NewManager(testConfig(t), nil)
+	now := time.Now()
+	for _, ip := range []string{"127.0.0.1", "::1", "10.1.2.3"} {
+		r := Request{Time: now, IP: ip, Host: "example.com", Status: 404}
+		for i := 0; i < 10; i++ {
+			if _, banned := m.Observe(r, now); banned {
+				t.Fatalf("%s should never be banned", ip)
+			}
+		}
+	}
+}
+
+func TestScan_AppliesBans(t *testing.T) {
+	cfg := testConfig(t)
+	reloads := 0
+	m := NewManager(cfg, func() error { reloads++; return nil })
+
+	var lines strings.Builder
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(&lines, "%d.000\t198.51.100.7\tapi.example.com\t200\tPOST\t/login\n", time.Now().Unix())
+	}
+	lines.WriteString("1700000000.000\t198.51.100.8\texample.com\t200\tPOST\t/login\n")
+	if err := os.WriteFile(cfg.Ban.Log, []byte(lines.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Scan(time.Now()); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	bans := m.List()
+	if len(bans) != 1 || bans[0].IP != "198.51.100.7" || bans[0].Rule != "login" {
+		t.Fatalf("bans = %+v, want 198.51.100.7 banned by login", bans)
+	}
+	if reloads != 1 {
+		t.Errorf("reloads = %d, want 1", reloads)
+	}
+	deny, _ := os.ReadFile(cfg.Ban.IncludePath(cfg.Nginx))
+	if !strings.Contains(string(deny), "deny 198.51.100.7;") {
+		t.Errorf("deny file should deny the IP:\n%s", deny)
+	}
+
+	// Bans are saved for the next start
+	if reloaded := NewManager(cfg, nil).List(); len(reloaded) != 1 {
+		t.Errorf("saved bans = %+v, want 1", reloaded)
+	}
+
+	// Only lines added since the last scan are read
+	if err := m.Scan(time.Now()); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if reloads != 1 {
+		t.Errorf("rescanning read old lines again: reloads = %d", reloads)
+	}
+}
+
+func TestExpire_LiftsBans(t *testing.T) {
+	cfg := testConfig(t)
+	m := NewManager(cfg, nil)
+	if _, err := m.Add("203.0.113.9", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Expire(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.List()) != 1 {
+		t.Fatal("an unexpired ban should stay")
+	}
+	if err := m.Expire(time.Now().Add(2 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.List()) != 0 {
+		t.Error("an expired ban should be lifted")
+	}
+	deny, _ := os.ReadFile(cfg.Ban.IncludePath(cfg.Nginx))
+	if strings.Contains(string(deny), "deny ") {
+		t.Errorf("deny file should be empty:\n%s", deny)
+	}
+}
+
+func TestClear(t *testing.T) {
+	m := NewManager(testConfig(t), nil)
+	m.Add("203.0.113.9", time.Hour)
+	m.Add("203.0.113.10", time.Hour)
+
+	if n, _ := m.Clear("198.51.100.1"); n != 0 {
+		t.Errorf("Clear() of an unbanned IP = %d, want 0", n)
+	}
+	if n, _ := m.Clear("203.0.113.9"); n != 1 {
+		t.Errorf("Clear(ip) = %d, want 1", n)
+	}
+	if n, _ := m.Clear(""); n != 1 {
+		t.Errorf("Clear(\"\") = %d, want 1", n)
+	}
+	if len(m.List()) != 0 {
+		t.Error("every ban should be cleared")
+	}
+}
@@ -0,0 +1,103 @@
+package ban
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// LogFormat is the nginx log_format of the ban log: tab-separated fields
+// nginx escapes, so no field can contain a tab
+const LogFormat = `$msec\t$remote_addr\t$host\t$status\t$request_method\t$request_uri`
+
+// Request is a line of the ban log
+type Request struct {
+	Time   time.Time
+	IP     string
+	Host   string
+	Status int
+	Method string
+	Path   string // without the query string
+}
+
+// ParseLine parses a line written with LogFormat
+func ParseLine(line string) (Request, bool) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 6 {
+		return Request{}, false
+	}
+	msec, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return Request{}, false
+	}
+	status, err := strconv.Atoi(fields[3])
+	if err != nil || net.ParseIP(fields[1]) == nil {
+		return Request{}, false
+	}
+	path, _, _ := strings.Cut(fields[5], "?")
+	return Request{
+		Time:   time.UnixMilli(int64(msec * 1000)),
+		IP:     fields[1],
+		Host:   strings.ToLower(fields[2]),
+		Status: status,
+		Method: fields[4],
+		Path:   path,
+	}, true
+}
+
+// siteForHost returns the site serving host: by name, alias or wildcard
+func siteForHost(cfg *config.Config, host string) string {
+	if _, ok := cfg.Site[host]; ok {
+		return host
+	}
+	for name, site := range cfg.Site {
+		if slices.Contains(site.Aliases, host) {
+			return name
+		}
+		if rest, ok := strings.CutPrefix(name, "*."); ok {
+			if label, found := strings.CutSuffix(host, "."+rest); found && label != "" && !strings.Contains(label, ".") {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// Matches reports whether a request to site counts towards rule
+func Matches(rule config.BanRule, site string, r Request) bool {
+	if len(rule.Sites) > 0 && !config.MatchSiteGlobs(rule.Sites, site) {
+		return false
+	}
+	if len(rule.Status) > 0 && !slices.Contains(rule.Status, r.Status) {
+		return false
+	}
+	if len(rule.Methods) > 0 && !slices.ContainsFunc(rule.Methods, func(m string) bool { return strings.EqualFold(m, r.Method) }) {
+		return false
+	}
+	if len(rule.Paths) > 0 && !slices.ContainsFunc(rule.Paths, func(p string) bool { return strings.HasPrefix(r.Path, p) }) {
+		return false
+	}
+	return true
+}
+
+// ignored reports whether ip is loopback or listed in ban.ignore
+func ignored(cfg config.BanConfig, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.IsLoopback() {
+		return true
+	}
+	for _, entry := range cfg.Ignore {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if other := net.ParseIP(entry); other != nil && other.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,367 @@
+// Package client is a Go client for the shipyard API: creating sites,
+// deploying frontends and backends, self-updates and the live log stream.
+// Deploys stream their artifact, so large uploads aren't held in memory.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request headers
+const (
+	HeaderKey       = "X-Shipyard-Key"
+	HeaderTimestamp = "X-Shipyard-Timestamp"
+	HeaderNonce     = "X-Shipyard-Nonce"
+	HeaderSignature = "X-Shipyard-Signature"
+)
+
+// Client calls one shipyard server with one key. Site deploys need that
+// site's key; creating sites, self-updates and the log stream need an admin
+// key, so tooling doing both uses two clients.
+type Client struct {
+	// BaseURL is the server's address, e.g. https://deploy.example.com
+	BaseURL string
+	// Key is sent in X-Shipyard-Key
+	Key string
+	// Sign signs deploy requests with Key (see Signature), as sites with
+	// require_signature need. Key must be the site's api_key; signed
+	// requests don't send it.
+	Sign bool
+	// HTTPClient sends the requests; http.DefaultClient when nil
+	HTTPClient *http.Client
+}
+
+// New creates a client for the server at baseURL
+func New(baseURL, key string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), Key: key}
+}
+
+// Error is an error response from the API
+type Error struct {
+	// StatusCode is the HTTP status
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Message     string `json:"message"`
+	Detail      string `json:"detail,omitempty"`
+	Remediation string `json:"remediation,omitempty"`
+	// Body is the whole response, for fields particular to an error
+	Body map[string]any `json:"-"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Code != "" {
+		msg = e.Code + ": " + msg
+	}
+	if e.Detail != "" {
+		msg += " (" + e.Detail + ")"
+	}
+	return fmt.Sprintf("shipyard: %d %s", e.StatusCode, msg)
+}
+
+// Signature computes the X-Shipyard-Signature of a request: the hex
+// HMAC-SHA256, keyed with the API key, of the timestamp, nonce, method, path
+// and hex SHA-256 of the body joined by newlines
+func Signature(key, timestamp, nonce, method, path, bodySHA256 string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", timestamp, nonce, method, path, bodySHA256)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sign sets the signature headers of a request whose body hashes to bodySHA256
+func (c *Client) sign(req *http.Request, bodySHA256 string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	n := hex.EncodeToString(nonce)
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderNonce, n)
+	req.Header.Set(HeaderSignature, Signature(c.Key, ts, n, req.Method, req.URL.Path, bodySHA256))
+	req.Header.Del(HeaderKey)
+	return nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// newRequest builds a request to path (which may carry a query) with the key
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(HeaderKey, c.Key)
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// do sends a request and decodes a 2xx JSON response into out. Other
+// statuses return an *Error.
+func (c *Client) do(req *http.Request, out any) error {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return decodeError(resp.StatusCode, data)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// decodeError builds the *Error of a failed response
+func decodeError(status int, data []byte) *Error {
+	e := &Error{StatusCode: status}
+	if json.Unmarshal(data, e) != nil || json.Unmarshal(data, &e.Body) != nil {
+		e.Message = strings.TrimSpace(string(data))
+	}
+	return e
+}
+
+// doJSON sends a JSON body, signed when the client signs requests
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out any, signed bool) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	req, err := c.newRequest(ctx, method, path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if signed && c.Sign {
+		sum := sha256.Sum256(body)
+		if err := c.sign(req, hex.EncodeToString(sum[:])); err != nil {
+			return err
+		}
+	}
+	return c.do(req, out)
+}
+
+// SiteCreateRequest is the body of POST /site/create
+type SiteCreateRequest struct {
+	Domain       string `json:"domain"`
+	FrontendRoot string `json:"frontend_root,omitempty"`
+	SSLEnabled   bool   `json:"ssl_enabled"`
+	WithBackend  bool   `json:"with_backend"`
+	BackendPort  int    `json:"backend_port,omitempty"`
+	ProxyPath    string `json:"proxy_path,omitempty"`
+	Protocol     string `json:"protocol,omitempty"`
+	WebSocket    bool   `json:"websocket,omitempty"`
+	ACMEEmail    string `json:"acme_email,omitempty"`
+	IgnoreDNS    bool   `json:"ignore_dns,omitempty"`
+	// Tags, Owner and Description organise the site in GET /sites and
+	// notifications
+	Tags        []string `json:"tags,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// DNSCheck is the DNS pre-check of a new site's certificate
+type DNSCheck struct {
+	Resolved   []string `json:"resolved"`
+	HostIPs    []string `json:"host_ips"`
+	PointsHere bool     `json:"points_here"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// SiteCreateResponse is the response of POST /site/create
+type SiteCreateResponse struct {
+	Status        string `json:"status"`
+	Domain        string `json:"domain"`
+	APIKey        string `json:"api_key"`
+	FrontendRoot  string `json:"frontend_root"`
+	SSLEnabled    bool   `json:"ssl_enabled"`
+	HasBackend    bool   `json:"has_backend"`
+	BackendOnly   bool   `json:"backend_only"`
+	NginxDeployed bool   `json:"nginx_deployed"`
+	// SSLStatus is "issued", or "dns_not_pointing_here" when the certificate
+	// was skipped (see DNS)
+	SSLStatus string    `json:"ssl_status,omitempty"`
+	DNS       *DNSCheck `json:"dns,omitempty"`
+	Warnings  []string  `json:"warnings,omitempty"`
+}
+
+// CreateSite creates a site. Needs an admin key.
+func (c *Client) CreateSite(ctx context.Context, r SiteCreateRequest) (*SiteCreateResponse, error) {
+	var resp SiteCreateResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/site/create", r, &resp, false); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SelfUpdateResult is the response of POST /deploy/self
+type SelfUpdateResult struct {
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	OldVersion string `json:"old_version"`
+	NewVersion string `json:"new_version"`
+}
+
+// SelfUpdate uploads a new shipyard binary, after which the server restarts.
+// force bypasses the downgrade, min_version and pin_version checks. Needs an
+// admin key.
+func (c *Client) SelfUpdate(ctx context.Context, binary io.Reader, force bool) (*SelfUpdateResult, error) {
+	path := "/deploy/self"
+	if force {
+		path += "?force=true"
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, path, binary)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	var resp SelfUpdateResult
+	if err := c.do(req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Job states
+const (
+	JobPendingApproval = "pending_approval"
+	JobScheduled       = "scheduled"
+	JobQueued          = "queued"
+	JobRunning         = "running"
+	JobSucceeded       = "succeeded"
+	JobFailed          = "failed"
+	JobCancelled       = "cancelled"
+)
+
+// JobEvent is a progress message logged while a job ran
+type JobEvent struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Job is an async deploy
+type Job struct {
+	ID       string     `json:"id"`
+	Kind     string     `json:"kind"`
+	Site     string     `json:"site"`
+	Commit   string     `json:"commit,omitempty"`
+	State    string     `json:"state"`
+	Created  time.Time  `json:"created"`
+	RunAt    *time.Time `json:"run_at,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Events   []JobEvent `json:"events"`
+	// Approval of deploys to sites with require_approval
+	RequestedBy     string     `json:"requested_by,omitempty"`
+	ApprovedBy      string     `json:"approved_by,omitempty"`
+	ApprovalExpires *time.Time `json:"approval_expires,omitempty"`
+	// HTTPStatus and Result are the response the deploy would have had
+	// synchronously, once it has finished
+	HTTPStatus int             `json:"http_status,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+}
+
+// Done reports whether the job has finished
+func (j *Job) Done() bool {
+	return j.State == JobSucceeded || j.State == JobFailed || j.State == JobCancelled
+}
+
+// Job fetches an async deploy of site
+func (c *Client) Job(ctx context.Context, site, id string) (*Job, error) {
+	path := "/jobs/" + url.PathEscape(id) + "?site=" + url.QueryEscape(site)
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Job Job `json:"job"`
+	}
+	if err := c.do(req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Job, nil
+}
+
+// CancelJob cancels a scheduled deploy of site before it is queued
+func (c *Client) CancelJob(ctx context.Context, site, id string) (*Job, error) {
+	path := "/jobs/" + url.PathEscape(id) + "?site=" + url.QueryEscape(site)
+	req, err := c.newRequest(ctx, http.MethodDelete, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Job Job `json:"job"`
+	}
+	if err := c.do(req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Job, nil
+}
+
+// ApproveDeploy approves a deploy waiting for approval. Needs an admin key
+// other than the one that requested the deploy.
+func (c *Client) ApproveDeploy(ctx context.Context, id string) (*Job, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, "/deploys/"+url.PathEscape(id)+"/approve", nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Job Job `json:"job"`
+	}
+	if err := c.do(req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Job, nil
+}
+
+// WaitJob polls a job every interval until it finishes or ctx is done
+func (c *Client) WaitJob(ctx context.Context, site, id string, interval time.Duration) (*Job, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		j, err := c.Job(ctx, site, id)
+		if err != nil || j.Done() {
+			return j, err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return j, ctx.Err()
+		}
+	}
+}
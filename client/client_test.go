@@ -0,0 +1,144 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+)
+
+func TestDeployFrontend(t *testing.T) {
+	tests := []struct {
+		name     string
+		sign     bool
+		artifact io.Reader
+		wantErr  bool
+	}{
+		{"unsigned stream", false, strings.NewReader("zip bytes"), false},
+		{"signed seekable", true, bytes.NewReader([]byte("zip bytes")), false},
+		{"signed stream", true, io.MultiReader(strings.NewReader("zip bytes")), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if tt.sign {
+					sum := sha256.Sum256(body)
+					want := Signature("sk-site", r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce), r.Method, r.URL.Path, hex.EncodeToString(sum[:]))
+					if r.Header.Get(HeaderSignature) != want {
+						t.Errorf("signature %q, want %q", r.Header.Get(HeaderSignature), want)
+					}
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				if err := r.ParseMultipartForm(1 << 20); err != nil {
+					t.Fatalf("parse form: %v", err)
+				}
+				// Signed requests are identified by the site, not the key
+				wantKey := "sk-site"
+				if tt.sign {
+					wantKey = ""
+				}
+				if r.Header.Get(HeaderKey) != wantKey || r.FormValue("site") != "example.com" || r.FormValue("update_latest") != "true" {
+					t.Errorf("unexpected request: key %q, form %v", r.Header.Get(HeaderKey), r.MultipartForm.Value)
+				}
+				f, _, err := r.FormFile("artifact")
+				if err != nil {
+					t.Fatalf("artifact: %v", err)
+				}
+				if data, _ := io.ReadAll(f); string(data) != "zip bytes" {
+					t.Errorf("artifact = %q", data)
+				}
+				w.Write([]byte(`{"status":"deployed","site":"example.com","commit":"abc1234","nginx_reloaded":true}`))
+			}))
+			defer srv.Close()
+
+			c := New(srv.URL, "sk-site")
+			c.Sign = tt.sign
+			res, err := c.DeployFrontend(context.Background(), FrontendDeploy{
+				Site:         "example.com",
+				Commit:       "abc1234",
+				UpdateLatest: true,
+				Artifact:     tt.artifact,
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DeployFrontend: %v", err)
+			}
+			if res.Status != "deployed" || !res.NginxReloaded {
+				t.Errorf("result = %+v", res)
+			}
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"status":"error","error":"site_not_found","message":"Site not found","detail":"x"}`))
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, "sk-admin").CreateSite(context.Background(), SiteCreateRequest{Domain: "example.com"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Code != "site_not_found" || apiErr.Detail != "x" {
+		t.Errorf("error = %+v", apiErr)
+	}
+}
+
+func TestTailLogs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws/logs" || r.URL.Query().Get("key") != "sk-admin" {
+			http.Error(w, `{"error":"invalid_key"}`, http.StatusUnauthorized)
+			return
+		}
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"time":"2026-01-02T03:04:05.000Z","level":"INFO","msg":"deploy started","site":"example.com"}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"time":"2026-01-02T03:04:06.000Z","level":"INFO","msg":"deploy finished"}`))
+		conn.ReadMessage() // until the client hangs up
+	}))
+	defer srv.Close()
+
+	var got []LogEntry
+	done := errors.New("done")
+	err := New(srv.URL, "sk-admin").TailLogs(context.Background(), func(e LogEntry) error {
+		got = append(got, e)
+		if len(got) == 2 {
+			return done
+		}
+		return nil
+	})
+	if err != done {
+		t.Fatalf("TailLogs = %v", err)
+	}
+	if got[0].Msg != "deploy started" || got[0].Attrs["site"] != "example.com" || got[0].Time.Second() != 5 {
+		t.Errorf("first entry = %+v", got[0])
+	}
+
+	err = New(srv.URL, "wrong").TailLogs(context.Background(), func(LogEntry) error { return nil })
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong key: err = %v", err)
+	}
+}
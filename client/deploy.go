@@ -0,0 +1,327 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+// FrontendDeploy is a frontend deploy. The artifact is a zip, either
+// uploaded from Artifact or fetched by the server from ArtifactURL.
+type FrontendDeploy struct {
+	Site   string
+	Commit string
+	// UpdateLatest points the site's latest symlink at this commit; without
+	// it the deploy is a preview at /<commit>/
+	UpdateLatest bool
+	// Subdomain is the subdomain of a wildcard site to deploy to
+	Subdomain string
+	// NginxConfig replaces the site's nginx server block
+	NginxConfig string
+
+	Artifact       io.Reader
+	ArtifactURL    string
+	ArtifactSHA256 string
+
+	// Async queues the deploy and returns its job (see WaitJob)
+	Async bool
+	// RunAt schedules the deploy for later; it returns a scheduled job
+	RunAt time.Time
+	// Force deploys inside a freeze window; needs an admin key
+	Force bool
+}
+
+// BackendDeploy is a backend deploy. The artifact is a zip holding the
+// binary, or with Binary set the binary itself, either uploaded from Artifact
+// or fetched from ArtifactURL.
+type BackendDeploy struct {
+	Site   string
+	Commit string
+	// BinaryName is the binary in the artifact; the site's default when empty
+	BinaryName string
+	// BinaryPath is the binary's exact path in the zip, e.g. "bin/linux/api"
+	BinaryPath string
+	// Binary marks the artifact as the executable itself rather than a zip
+	Binary bool
+
+	Artifact       io.Reader
+	ArtifactURL    string
+	ArtifactSHA256 string
+
+	Async bool
+	RunAt time.Time
+	Force bool
+}
+
+// DeployResult is the response of a deploy. An async deploy has status
+// "queued" and its Job; the rest is set once it finishes. A scheduled deploy
+// has status "scheduled", and one waiting for approval "pending_approval".
+type DeployResult struct {
+	Status         string `json:"status"`
+	Site           string `json:"site"`
+	Commit         string `json:"commit"`
+	ArtifactSHA256 string `json:"artifact_sha256,omitempty"`
+	Job            *Job   `json:"job,omitempty"`
+
+	// Frontend deploys
+	Path          string `json:"path,omitempty"`
+	NginxReloaded bool   `json:"nginx_reloaded,omitempty"`
+	LatestUpdated bool   `json:"latest_updated,omitempty"`
+	Build         *Build `json:"build,omitempty"`
+
+	// Backend deploys
+	Jail      string     `json:"jail,omitempty"`
+	Healthy   bool       `json:"healthy,omitempty"`
+	Migration *Migration `json:"migration,omitempty"`
+}
+
+// Migration is what a backend's migrate_command did during the deploy
+type Migration struct {
+	Command  string  `json:"command"`
+	Output   string  `json:"output"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Build describes a deployed frontend build: its size, its largest files,
+// and warnings when it looks like the wrong zip
+type Build struct {
+	Size     int64       `json:"size"`
+	Files    int         `json:"files"`
+	Largest  []BuildFile `json:"largest"`
+	HasIndex bool        `json:"has_index"`
+	BuildDir string      `json:"build_dir,omitempty"`
+	Warnings []string    `json:"warnings,omitempty"`
+}
+
+// BuildFile is one file of a Build
+type BuildFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// DeployFrontend deploys a frontend, streaming the artifact. A deploy whose
+// nginx config failed validation returns an *Error with status 422; the
+// commit's files are in place but nginx wasn't reloaded.
+func (c *Client) DeployFrontend(ctx context.Context, d FrontendDeploy) (*DeployResult, error) {
+	f := form{
+		{"site", d.Site},
+		{"commit", d.Commit},
+	}
+	if d.UpdateLatest {
+		f = append(f, field{"update_latest", "true"})
+	}
+	if d.Subdomain != "" {
+		f = append(f, field{"subdomain", d.Subdomain})
+	}
+	if d.NginxConfig != "" {
+		f = append(f, field{"nginx_config", d.NginxConfig})
+	}
+	f = f.withArtifact(d.ArtifactURL, d.ArtifactSHA256, d.Async)
+	f = f.withSchedule(d.RunAt, d.Force)
+	return c.deploy(ctx, "/deploy/frontend", f, d.Artifact, "frontend.zip")
+}
+
+// DeployBackend deploys a backend, streaming the artifact
+func (c *Client) DeployBackend(ctx context.Context, d BackendDeploy) (*DeployResult, error) {
+	f := form{
+		{"site", d.Site},
+		{"commit", d.Commit},
+	}
+	if d.BinaryName != "" {
+		f = append(f, field{"binary_name", d.BinaryName})
+	}
+	if d.BinaryPath != "" {
+		f = append(f, field{"binary_path", d.BinaryPath})
+	}
+	filename := "backend.zip"
+	if d.Binary {
+		f = append(f, field{"artifact_format", "binary"})
+		filename = "backend"
+	}
+	f = f.withArtifact(d.ArtifactURL, d.ArtifactSHA256, d.Async)
+	f = f.withSchedule(d.RunAt, d.Force)
+	return c.deploy(ctx, "/deploy/backend", f, d.Artifact, filename)
+}
+
+// EnvPromotion promotes a commit from a site's staging site (see
+// staging_of) to the site
+type EnvPromotion struct {
+	// Site is the production site
+	Site   string
+	Commit string
+	// Kind is "frontend" or "backend"; empty promotes both
+	Kind string
+	// KeepLatest deploys the frontend as a preview instead of updating latest
+	KeepLatest bool
+	Async      bool
+}
+
+// EnvPromotionResult is the response of an environment promotion
+type EnvPromotionResult struct {
+	Status   string        `json:"status"`
+	Site     string        `json:"site"`
+	From     string        `json:"from"`
+	Commit   string        `json:"commit"`
+	Frontend *DeployResult `json:"frontend,omitempty"`
+	Backend  *DeployResult `json:"backend,omitempty"`
+	Job      *Job          `json:"job,omitempty"`
+}
+
+// PromoteEnv deploys to a site the artifacts its staging site was deployed
+// with for a commit. Needs the production site's key.
+func (c *Client) PromoteEnv(ctx context.Context, p EnvPromotion) (*EnvPromotionResult, error) {
+	f := form{
+		{"site", p.Site},
+		{"commit", p.Commit},
+	}
+	if p.Kind != "" {
+		f = append(f, field{"kind", p.Kind})
+	}
+	if p.KeepLatest {
+		f = append(f, field{"update_latest", "false"})
+	}
+	f = f.withArtifact("", "", p.Async)
+
+	var resp EnvPromotionResult
+	if err := c.postForm(ctx, "/deploy/promote-env", f, nil, "", &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// field is a multipart form value
+type field struct {
+	name, value string
+}
+
+// form is a deploy's form values, in the order they're sent
+type form []field
+
+// withArtifact adds the artifact_url and async fields
+func (f form) withArtifact(artifactURL, sha256 string, async bool) form {
+	if artifactURL != "" {
+		f = append(f, field{"artifact_url", artifactURL})
+		if sha256 != "" {
+			f = append(f, field{"artifact_sha256", sha256})
+		}
+	}
+	if async {
+		f = append(f, field{"async", "true"})
+	}
+	return f
+}
+
+// withSchedule adds the run_at and force fields
+func (f form) withSchedule(runAt time.Time, force bool) form {
+	if !runAt.IsZero() {
+		f = append(f, field{"run_at", runAt.Format(time.RFC3339)})
+	}
+	if force {
+		f = append(f, field{"force", "true"})
+	}
+	return f
+}
+
+// write writes the multipart body, values first and then the artifact
+func (f form) write(w io.Writer, boundary string, artifact io.Reader, filename string) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for _, v := range f {
+		if err := mw.WriteField(v.name, v.value); err != nil {
+			return err
+		}
+	}
+	if artifact != nil {
+		part, err := mw.CreateFormFile("artifact", filename)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, artifact); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// deploy posts a deploy form
+func (c *Client) deploy(ctx context.Context, path string, f form, artifact io.Reader, filename string) (*DeployResult, error) {
+	var resp DeployResult
+	if err := c.postForm(ctx, path, f, artifact, filename, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// postForm posts a multipart form, with the artifact when not nil, and
+// decodes the response into out. The body is streamed through a pipe as the
+// server reads it. Signing needs the body's hash before it's sent, so a
+// signed upload reads the artifact twice and it must be an io.ReadSeeker
+// (an *os.File, say).
+func (c *Client) postForm(ctx context.Context, path string, f form, artifact io.Reader, filename string, out any) error {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	bodySHA256 := ""
+	if c.Sign {
+		sum, err := f.bodyHash(boundary, artifact, filename)
+		if err != nil {
+			return err
+		}
+		bodySHA256 = sum
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(f.write(pw, boundary, artifact, filename))
+	}()
+
+	req, err := c.newRequest(ctx, http.MethodPost, path, pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+	if c.Sign {
+		if err := c.sign(req, bodySHA256); err != nil {
+			pr.Close()
+			return err
+		}
+	}
+
+	return c.do(req, out)
+}
+
+// bodyHash returns the hex SHA-256 of the multipart body, rewinding the
+// artifact afterwards
+func (f form) bodyHash(boundary string, artifact io.Reader, filename string) (string, error) {
+	h := sha256.New()
+	if artifact == nil {
+		if err := f.write(h, boundary, nil, filename); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	seeker, ok := artifact.(io.ReadSeeker)
+	if !ok {
+		return "", errors.New("signed uploads need an io.ReadSeeker artifact")
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	if err := f.write(h, boundary, seeker, filename); err != nil {
+		return "", err
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
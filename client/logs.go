@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// LogEntry is a line of the server's log
+type LogEntry struct {
+	Time  time.Time
+	Level string
+	Msg   string
+	// Attrs are the line's other fields, such as site and request_id
+	Attrs map[string]any
+}
+
+// UnmarshalJSON decodes a line of the log stream
+func (e *LogEntry) UnmarshalJSON(data []byte) error {
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	if s, ok := m["time"].(string); ok {
+		e.Time, _ = time.Parse(time.RFC3339Nano, s)
+	}
+	e.Level, _ = m["level"].(string)
+	e.Msg, _ = m["msg"].(string)
+	delete(m, "time")
+	delete(m, "level")
+	delete(m, "msg")
+	e.Attrs = m
+	return nil
+}
+
+// TailLogs streams the server's log over /ws/logs, calling fn with each line
+// until ctx is done, the connection drops or fn returns an error. Needs an
+// admin key that isn't scoped to sites.
+func (c *Client) TailLogs(ctx context.Context, fn func(LogEntry) error) error {
+	u, err := url.Parse(c.BaseURL + "/ws/logs")
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.RawQuery = url.Values{"key": {c.Key}}.Encode()
+
+	dialer := *websocket.DefaultDialer
+	if t, ok := c.httpClient().Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = t.TLSClientConfig
+		dialer.Proxy = t.Proxy
+	}
+	conn, resp, err := dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			defer resp.Body.Close()
+			data, _ := io.ReadAll(resp.Body)
+			return decodeError(resp.StatusCode, data)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	// Closing the connection unblocks the read when ctx ends
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil
+			}
+			return err
+		}
+		var entry LogEntry
+		if err := json.Unmarshal(msg, &entry); err != nil {
+			continue // not a log line
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
@@ -1,132 +1,334 @@
 package cmd
 
 import (
+	"bytes"
+	_ "embed"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"text/template"
 
+	"github.com/lachierussell/shipyard/config"
 	"github.com/lachierussell/shipyard/nginx"
 )
 
-// Bootstrap sets up shipyard on a fresh FreeBSD system
-func Bootstrap(version, commit string) error {
-	slog.Info("starting bootstrap", "version", version, "commit", commit)
+//go:embed bootstrap_config.toml.tmpl
+var bootstrapConfigTmplStr string
 
-	// Paths (using /usr/local for macOS compatibility, would be / on FreeBSD)
-	binaryPath := "/usr/local/bin/shipyard"
-	configDir := "/usr/local/etc/shipyard"
-	nginxConfPath := "/tmp/shipyard-test/etc/nginx.conf" // For testing on macOS
+//go:embed bootstrap_rcd.sh.tmpl
+var bootstrapRcdTmplStr string
 
-	// 1. Copy binary
-	slog.Info("bootstrap: installing binary", "path", binaryPath)
+var bootstrapConfigTmpl = template.Must(template.New("bootstrap_config").Delims("<%", "%>").Parse(bootstrapConfigTmplStr))
+var bootstrapRcdTmpl = template.Must(template.New("bootstrap_rcd").Delims("<%", "%>").Parse(bootstrapRcdTmplStr))
+
+// bootstrapPackages are the FreeBSD packages shipyard relies on at runtime
+var bootstrapPackages = []string{"nginx", "pot", "py311-certbot", "ca_root_nss"}
+
+// bootstrapOptions holds the flags accepted by the bootstrap command
+type bootstrapOptions struct {
+	Prefix      string
+	ConfigPath  string
+	Force       bool
+	InstallDeps bool
+}
+
+// bootstrapReport records what bootstrap changed and what it left alone
+type bootstrapReport struct {
+	Changed  []string
+	Skipped  []string
+	Warnings []string
+}
+
+func (r *bootstrapReport) changed(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	slog.Info("bootstrap: " + msg)
+	r.Changed = append(r.Changed, msg)
+}
+
+func (r *bootstrapReport) skipped(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	slog.Info("bootstrap: skipped " + msg)
+	r.Skipped = append(r.Skipped, msg)
+}
+
+func (r *bootstrapReport) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	slog.Warn("bootstrap: " + msg)
+	r.Warnings = append(r.Warnings, msg)
+}
+
+// Bootstrap sets up shipyard on a fresh FreeBSD system.
+// It is idempotent: existing files are left untouched unless --force is given.
+func Bootstrap(cli *CLI, args []string) error {
+	fs := cli.flags("bootstrap")
+	opts := bootstrapOptions{}
+	fs.StringVar(&opts.Prefix, "prefix", "/usr/local", "installation prefix")
+	fs.BoolVar(&opts.Force, "force", false, "overwrite existing files")
+	fs.BoolVar(&opts.InstallDeps, "install-deps", false, "install nginx, pot and certbot with pkg")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	version, commit := cli.Version, cli.Commit
+	// The config is written here, so the default follows the prefix
+	opts.ConfigPath = cli.ConfigPath
+	if opts.ConfigPath == "" {
+		opts.ConfigPath = filepath.Join(opts.Prefix, "etc", "shipyard", "shipyard.toml")
+	}
+
+	slog.Info("starting bootstrap", "version", version, "commit", commit, "prefix", opts.Prefix, "force", opts.Force)
+
+	report := &bootstrapReport{}
+
+	// 1. Dependencies
+	if opts.InstallDeps {
+		installPackages(report)
+	} else {
+		report.skipped("package installation (use --install-deps)")
+	}
+
+	// 2. Binary
+	binaryPath := filepath.Join(opts.Prefix, "bin", "shipyard")
+	if err := installBinary(binaryPath, opts.Force, report); err != nil {
+		return err
+	}
+
+	// 3. Config
+	cfg, err := installConfig(opts, binaryPath, report)
+	if err != nil {
+		return err
+	}
+
+	// 4. nginx layout
+	if err := installNginx(cfg, opts.Force, report); err != nil {
+		return err
+	}
+
+	// 5. rc.d script
+	rcdPath := filepath.Join(opts.Prefix, "etc", "rc.d", "shipyard")
+	var rcd bytes.Buffer
+	if err := bootstrapRcdTmpl.Execute(&rcd, struct{ BinaryPath string }{binaryPath}); err != nil {
+		return fmt.Errorf("render rc.d script: %w", err)
+	}
+	if err := writeManagedFile(rcdPath, rcd.Bytes(), 0755, opts.Force, report); err != nil {
+		return err
+	}
+
+	printBootstrapSummary(report, opts.ConfigPath)
+	return nil
+}
+
+// installPackages installs any missing runtime packages with pkg
+func installPackages(report *bootstrapReport) {
+	var missing []string
+	for _, pkg := range bootstrapPackages {
+		if exec.Command("pkg", "info", "-e", pkg).Run() == nil {
+			report.skipped("package %s (already installed)", pkg)
+			continue
+		}
+		missing = append(missing, pkg)
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	args := append([]string{"install", "-y"}, missing...)
+	output, err := exec.Command("pkg", args...).CombinedOutput()
+	if err != nil {
+		report.warn("pkg install %s failed: %v: %s", strings.Join(missing, " "), err, strings.TrimSpace(string(output)))
+		return
+	}
+	report.changed("installed packages: %s", strings.Join(missing, " "))
+}
+
+// installBinary copies the running executable to binaryPath if it differs
+func installBinary(binaryPath string, force bool, report *bootstrapReport) error {
 	exePath, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("get executable: %w", err)
 	}
+	if resolved, err := filepath.EvalSymlinks(exePath); err == nil {
+		exePath = resolved
+	}
 
 	data, err := os.ReadFile(exePath)
 	if err != nil {
 		return fmt.Errorf("read binary: %w", err)
 	}
 
+	existing, err := os.ReadFile(binaryPath)
+	if err == nil {
+		if bytes.Equal(existing, data) {
+			report.skipped("binary %s (up to date)", binaryPath)
+			return nil
+		}
+		if !force {
+			report.skipped("binary %s (different version installed, use --force or deploy/self)", binaryPath)
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(binaryPath), 0755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(binaryPath), err)
+	}
 	if err := os.WriteFile(binaryPath, data, 0755); err != nil {
-		slog.Warn("bootstrap: skipped binary install", "error", err)
+		report.warn("binary install to %s failed: %v", binaryPath, err)
+		return nil
+	}
+	report.changed("installed binary %s", binaryPath)
+	return nil
+}
+
+// installConfig writes an example config if none exists and returns the loaded config
+func installConfig(opts bootstrapOptions, binaryPath string, report *bootstrapReport) (*config.Config, error) {
+	configDir := filepath.Dir(opts.ConfigPath)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return nil, fmt.Errorf("mkdir %s: %w", configDir, err)
+	}
+
+	_, statErr := os.Stat(opts.ConfigPath)
+	if statErr == nil && !opts.Force {
+		report.skipped("config %s (exists)", opts.ConfigPath)
 	} else {
-		slog.Info("bootstrap: binary installed")
-	}
-
-	// 2. Create config directory
-	slog.Info("bootstrap: creating config directory", "path", configDir)
-	os.MkdirAll(configDir, 0755)
-
-	// 3. Generate nginx main config
-	slog.Info("bootstrap: generating nginx main config", "path", nginxConfPath)
-	nginxMain := nginx.GenerateMainConf()
-	os.WriteFile(nginxConfPath, []byte(nginxMain), 0644)
-
-	// 4. Create example config
-	configPath := filepath.Join(configDir, "shipyard.toml")
-	slog.Info("bootstrap: creating example config", "path", configPath)
-	exampleConfig := `[server]
-listen_addr = "0.0.0.0:8443"
-log_file    = "/var/log/shipyard/shipyard.log"
-
-[nginx]
-binary_path     = "/usr/local/sbin/nginx"
-main_conf_path  = "/usr/local/etc/nginx/nginx.conf"
-sites_available = "/usr/local/etc/nginx/sites-available"
-sites_enabled   = "/usr/local/etc/nginx/sites-enabled"
-override_conf   = "/usr/local/etc/nginx/override.conf"
-
-[jail]
-base_dir       = "/var/jails"
-jail_conf_path = "/etc/jail.conf"
-freebsd_version = "14.3-RELEASE"
-tarball_cache  = "/var/cache/shipyard/base.txz"
-ip_base        = "127.0.1"
-
-[health]
-poll_interval     = "15s"
-failure_threshold = 3
-
-[self]
-binary_path = "/usr/local/bin/shipyard"
-pid_file    = "/var/run/shipyard.pid"
-config_dir  = "/usr/local/etc/shipyard"
-
-admin_keys = [
-    "sk-admin-change-me-to-a-real-key",
-]
-
-[[site]]
-domain        = "example.com"
-frontend_root = "/usr/local/www/example.com"
-api_key       = "sk-live-example-change-me"
-override_ips  = ["127.0.0.1"]
-`
-
-	os.WriteFile(configPath, []byte(exampleConfig), 0644)
-
-	// 5. Create rc.d script
-	rcdPath := "/usr/local/etc/rc.d/shipyard"
-	slog.Info("bootstrap: creating rc.d script", "path", rcdPath)
-	rcdScript := `#!/bin/sh
-# PROVIDE: shipyard
-# REQUIRE: networking syslog
-# KEYWORD: shutdown
-#
-# MANAGED BY SHIPYARD BOOTSTRAP
-
-. /etc/rc.subr
-
-name="shipyard"
-rcvar="${name}_enable"
-pidfile="/var/run/shipyard.pid"
-
-command="/usr/sbin/daemon"
-command_args="-P ${pidfile} -r -R 5 -f -l daemon -T shipyard /usr/local/bin/shipyard serve"
-
-load_rc_config $name
-: ${shipyard_enable:=no}
-
-run_rc_command "$1"
-`
-
-	os.WriteFile(rcdPath, []byte(rcdScript), 0755)
-
-	// 6. Summary
-	slog.Info("bootstrap complete",
-		"config", configPath,
-		"binary", binaryPath,
-		"rcd", rcdPath,
-	)
+		adminKey, err := config.GenerateAPIKey("sk-admin-")
+		if err != nil {
+			return nil, err
+		}
+		siteKey, err := config.GenerateAPIKey("sk-site-")
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err := bootstrapConfigTmpl.Execute(&buf, struct {
+			Prefix    string
+			ConfigDir string
+			AdminKey  string
+			SiteKey   string
+		}{opts.Prefix, configDir, adminKey, siteKey}); err != nil {
+			return nil, fmt.Errorf("render config: %w", err)
+		}
+
+		// Config holds keys, keep it private to root
+		if err := os.WriteFile(opts.ConfigPath, buf.Bytes(), 0600); err != nil {
+			return nil, fmt.Errorf("write config: %w", err)
+		}
+		report.changed("wrote config %s", opts.ConfigPath)
+		// Shown once on the terminal only: the report is logged, and the log
+		// reaches the log file, the log store and /ws/logs
+		fmt.Printf("\nAdmin key (also in %s): %s\n\n", opts.ConfigPath, adminKey)
+	}
+
+	cfg, err := config.Load(opts.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	return cfg, nil
+}
+
+// installNginx writes the managed nginx.conf and verifies the include structure shipyard relies on
+func installNginx(cfg *config.Config, force bool, report *bootstrapReport) error {
+	for _, dir := range []string{cfg.Nginx.SitesAvailable, cfg.Nginx.SitesEnabled, nginx.AcmeWebroot} {
+		if _, err := os.Stat(dir); err == nil {
+			report.skipped("directory %s (exists)", dir)
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", dir, err)
+		}
+		report.changed("created directory %s", dir)
+	}
+
+	// Managed main config: rewrite freely if we own it, otherwise only with --force
+	desired := nginx.GenerateMainConf(cfg)
+	existing, err := os.ReadFile(cfg.Nginx.MainConfPath)
+	switch {
+	case err != nil:
+		if err := os.WriteFile(cfg.Nginx.MainConfPath, []byte(desired), 0644); err != nil {
+			return fmt.Errorf("write nginx main config: %w", err)
+		}
+		report.changed("wrote nginx main config %s", cfg.Nginx.MainConfPath)
+	case string(existing) == desired:
+		report.skipped("nginx main config %s (up to date)", cfg.Nginx.MainConfPath)
+	case strings.Contains(string(existing), "MANAGED BY SHIPYARD") || force:
+		if err := os.WriteFile(cfg.Nginx.MainConfPath, []byte(desired), 0644); err != nil {
+			return fmt.Errorf("write nginx main config: %w", err)
+		}
+		report.changed("updated nginx main config %s", cfg.Nginx.MainConfPath)
+	default:
+		report.skipped("nginx main config %s (not managed by shipyard, use --force)", cfg.Nginx.MainConfPath)
+		verifyNginxIncludes(cfg, string(existing), report)
+	}
+
+	if cfg.Nginx.OverrideConf != "" {
+		if _, err := os.Stat(cfg.Nginx.OverrideConf); err == nil {
+			report.skipped("override config %s (exists)", cfg.Nginx.OverrideConf)
+		} else {
+			if err := os.WriteFile(cfg.Nginx.OverrideConf, []byte(nginx.GenerateOverrideConf(cfg)), 0644); err != nil {
+				return fmt.Errorf("write override config: %w", err)
+			}
+			report.changed("wrote override config %s", cfg.Nginx.OverrideConf)
+		}
+	}
+
+	return nil
+}
+
+// verifyNginxIncludes warns when a hand-maintained nginx.conf doesn't include shipyard's files
+func verifyNginxIncludes(cfg *config.Config, mainConf string, report *bootstrapReport) {
+	wanted := []string{filepath.Join(cfg.Nginx.SitesEnabled, "*.conf")}
+	if cfg.Nginx.OverrideConf != "" {
+		wanted = append(wanted, cfg.Nginx.OverrideConf)
+	}
+	for _, inc := range wanted {
+		if !strings.Contains(mainConf, "include "+inc) {
+			report.warn("%s does not include %s; shipyard-managed sites will not be served", cfg.Nginx.MainConfPath, inc)
+		}
+	}
+}
+
+// writeManagedFile writes content to path unless it already exists (or --force is given)
+func writeManagedFile(path string, content []byte, perm os.FileMode, force bool, report *bootstrapReport) error {
+	existing, err := os.ReadFile(path)
+	if err == nil {
+		if bytes.Equal(existing, content) {
+			report.skipped("%s (up to date)", path)
+			return nil
+		}
+		if !force {
+			report.skipped("%s (exists, use --force)", path)
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, content, perm); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	report.changed("wrote %s", path)
+	return nil
+}
+
+// printBootstrapSummary prints what bootstrap did and the next steps
+func printBootstrapSummary(report *bootstrapReport, configPath string) {
+	fmt.Printf("\nBootstrap summary: %d changed, %d skipped, %d warnings\n", len(report.Changed), len(report.Skipped), len(report.Warnings))
+	for _, msg := range report.Changed {
+		fmt.Println("  changed: " + msg)
+	}
+	for _, msg := range report.Skipped {
+		fmt.Println("  skipped: " + msg)
+	}
+	for _, msg := range report.Warnings {
+		fmt.Println("  warning: " + msg)
+	}
 
 	fmt.Println("\nNext steps:")
 	fmt.Println("1. Edit the configuration: sudo vi " + configPath)
 	fmt.Println("2. Enable shipyard: sudo sysrc shipyard_enable=YES")
 	fmt.Println("3. Start shipyard: sudo service shipyard start")
 	fmt.Println("4. Check status: sudo service shipyard status")
-
-	return nil
 }
@@ -0,0 +1,334 @@
+package cmd
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lachierussell/shipyard/client"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables ci-deploy reads its server and credentials from
+const (
+	EnvURL    = "SHIPYARD_URL"
+	EnvAPIKey = "SHIPYARD_API_KEY"
+)
+
+// ciJobPollInterval is how often ci-deploy polls an async deploy
+const ciJobPollInterval = 2 * time.Second
+
+// Manifest is a repository's shipyard.yaml: what to deploy and how
+type Manifest struct {
+	Site     string            `yaml:"site"`
+	Frontend *ManifestArtifact `yaml:"frontend"`
+	Backend  *ManifestArtifact `yaml:"backend"`
+	// Branches decide per branch where a deploy goes and whether it updates
+	// latest; the first match wins. Unmatched branches deploy previews.
+	Branches []BranchRule `yaml:"branches"`
+	Sign     bool         `yaml:"sign"`
+	Async    bool         `yaml:"async"`
+}
+
+// ManifestArtifact is the frontend or backend part of a manifest
+type ManifestArtifact struct {
+	// Artifact is a frontend's build directory or zip, or a backend's zip or binary
+	Artifact string `yaml:"artifact"`
+	// NginxConfig is a file holding the frontend's nginx server block
+	NginxConfig string `yaml:"nginx_config"`
+	// BinaryName is the backend binary in the artifact
+	BinaryName string `yaml:"binary_name"`
+	// BinaryPath is the backend binary's exact path in the zip
+	BinaryPath string `yaml:"binary_path"`
+	// Binary marks the backend artifact as the executable itself
+	Binary bool `yaml:"binary"`
+}
+
+// BranchRule is the deploy settings of branches matching a glob
+type BranchRule struct {
+	Match        string `yaml:"match"`
+	UpdateLatest bool   `yaml:"update_latest"`
+	// Site overrides the manifest's site, e.g. to deploy develop to staging
+	Site string `yaml:"site"`
+	// KeyEnv names the environment variable holding Site's key, when it isn't
+	// in SHIPYARD_API_KEY
+	KeyEnv string `yaml:"key_env"`
+	// Skip skips deploys of the branch
+	Skip bool `yaml:"skip"`
+}
+
+// loadManifest reads and checks a manifest. Artifact paths are relative to
+// its directory.
+func loadManifest(p string) (*Manifest, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m Manifest
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", p, err)
+	}
+
+	if m.Site == "" {
+		return nil, fmt.Errorf("%s: site is required", p)
+	}
+	if m.Frontend == nil && m.Backend == nil {
+		return nil, fmt.Errorf("%s: frontend or backend is required", p)
+	}
+	dir := filepath.Dir(p)
+	for _, a := range []*ManifestArtifact{m.Frontend, m.Backend} {
+		if a == nil {
+			continue
+		}
+		if a.Artifact == "" {
+			return nil, fmt.Errorf("%s: artifact is required", p)
+		}
+		a.Artifact = filepath.Join(dir, a.Artifact)
+		if a.NginxConfig != "" {
+			a.NginxConfig = filepath.Join(dir, a.NginxConfig)
+		}
+	}
+	for _, r := range m.Branches {
+		if _, err := path.Match(r.Match, ""); err != nil || r.Match == "" {
+			return nil, fmt.Errorf("%s: invalid branch pattern %q", p, r.Match)
+		}
+	}
+	return &m, nil
+}
+
+// rule returns the rule for a branch; branches no rule matches deploy a
+// preview to the manifest's site
+func (m *Manifest) rule(branch string) BranchRule {
+	for _, r := range m.Branches {
+		if ok, _ := path.Match(r.Match, branch); ok {
+			if r.Site == "" {
+				r.Site = m.Site
+			}
+			return r
+		}
+	}
+	return BranchRule{Site: m.Site}
+}
+
+// CIDeploy deploys what shipyard.yaml describes for the current branch and
+// commit, for CI. The server and key come from SHIPYARD_URL and
+// SHIPYARD_API_KEY (or the branch rule's key_env).
+func CIDeploy(cli *CLI, args []string) error {
+	fs := cli.flags("ci-deploy")
+	manifestPath := fs.String("manifest", "shipyard.yaml", "deploy manifest")
+	branch := fs.String("branch", "", "branch being deployed (default from the CI environment or git)")
+	commit := fs.String("commit", "", "commit being deployed (default from the CI environment or git)")
+	dryRun := fs.Bool("dry-run", false, "print what would be deployed without deploying")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	m, err := loadManifest(*manifestPath)
+	if err != nil {
+		return err
+	}
+	if *branch == "" {
+		*branch = ciBranch()
+	}
+	if *commit == "" {
+		*commit = ciCommit()
+	}
+	if *commit == "" {
+		return errors.New("could not tell the commit; pass --commit")
+	}
+
+	rule := m.rule(*branch)
+	if rule.Skip {
+		fmt.Printf("Branch %s is not deployed\n", *branch)
+		return nil
+	}
+	fmt.Printf("Deploying %s (%s) to %s, update_latest=%t\n", *commit, *branch, rule.Site, rule.UpdateLatest)
+	if *dryRun {
+		return nil
+	}
+
+	keyEnv := EnvAPIKey
+	if rule.KeyEnv != "" {
+		keyEnv = rule.KeyEnv
+	}
+	url, key := os.Getenv(EnvURL), os.Getenv(keyEnv)
+	if url == "" || key == "" {
+		return fmt.Errorf("%s and %s must be set", EnvURL, keyEnv)
+	}
+	c := client.New(url, key)
+	c.Sign = m.Sign
+	ctx := context.Background()
+
+	// The backend goes first so a new frontend doesn't call an old API
+	if m.Backend != nil {
+		f, err := os.Open(m.Backend.Artifact)
+		if err != nil {
+			return fmt.Errorf("backend artifact: %w", err)
+		}
+		defer f.Close()
+		res, err := c.DeployBackend(ctx, client.BackendDeploy{
+			Site:       rule.Site,
+			Commit:     *commit,
+			BinaryName: m.Backend.BinaryName,
+			BinaryPath: m.Backend.BinaryPath,
+			Binary:     m.Backend.Binary,
+			Artifact:   f,
+			Async:      m.Async,
+		})
+		if err := ciResult(ctx, c, rule.Site, "backend", res, err); err != nil {
+			return err
+		}
+	}
+
+	if m.Frontend != nil {
+		f, err := frontendArtifact(m.Frontend.Artifact)
+		if err != nil {
+			return fmt.Errorf("frontend artifact: %w", err)
+		}
+		defer f.Close()
+		nginxConfig := ""
+		if m.Frontend.NginxConfig != "" {
+			data, err := os.ReadFile(m.Frontend.NginxConfig)
+			if err != nil {
+				return fmt.Errorf("nginx config: %w", err)
+			}
+			nginxConfig = string(data)
+		}
+		res, err := c.DeployFrontend(ctx, client.FrontendDeploy{
+			Site:         rule.Site,
+			Commit:       *commit,
+			UpdateLatest: rule.UpdateLatest,
+			NginxConfig:  nginxConfig,
+			Artifact:     f,
+			Async:        m.Async,
+		})
+		if err := ciResult(ctx, c, rule.Site, "frontend", res, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ciResult reports a deploy, waiting for it first if it was queued
+func ciResult(ctx context.Context, c *client.Client, site, kind string, res *client.DeployResult, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s deploy: %w", kind, err)
+	}
+	if res.Job != nil {
+		fmt.Printf("%s deploy queued as job %s\n", kind, res.Job.ID)
+		j, err := c.WaitJob(ctx, site, res.Job.ID, ciJobPollInterval)
+		if err != nil {
+			return fmt.Errorf("%s deploy: %w", kind, err)
+		}
+		if j.State != client.JobSucceeded {
+			return fmt.Errorf("%s deploy %s (HTTP %d): %s", kind, j.State, j.HTTPStatus, j.Result)
+		}
+		fmt.Printf("%s deploy %s\n", kind, j.State)
+		return nil
+	}
+	fmt.Printf("%s deploy %s\n", kind, res.Status)
+	if res.Build != nil {
+		fmt.Printf("%s build: %d files, %d bytes\n", kind, res.Build.Files, res.Build.Size)
+		for _, w := range res.Build.Warnings {
+			fmt.Printf("warning: %s\n", w)
+		}
+	}
+	return nil
+}
+
+// ciBranch returns the branch from the CI environment, else from git
+func ciBranch() string {
+	for _, env := range []string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_COMMIT_REF_NAME"} {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	return gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+}
+
+// ciCommit returns the commit from the CI environment, else from git
+func ciCommit() string {
+	for _, env := range []string{"GITHUB_SHA", "CI_COMMIT_SHA"} {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	return gitOutput("rev-parse", "HEAD")
+}
+
+func gitOutput(args ...string) string {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// frontendArtifact opens a frontend artifact, zipping it into a temporary
+// file first when it's a build directory
+func frontendArtifact(p string) (*os.File, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return os.Open(p)
+	}
+
+	tmp, err := os.CreateTemp("", "shipyard-frontend-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(tmp.Name()) // gone once closed
+	if err := zipDir(tmp, p); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// zipDir writes the files under dir to w as a zip, with paths relative to dir
+func zipDir(w io.Writer, dir string) error {
+	zw := zip.NewWriter(w)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		dst, err := zw.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(dst, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
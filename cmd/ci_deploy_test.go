@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "shipyard.yaml")
+	os.WriteFile(p, []byte(`
+site: example.com
+frontend:
+  artifact: dist
+branches:
+  - match: main
+    update_latest: true
+  - match: develop
+    site: staging.example.com
+    key_env: SHIPYARD_STAGING_KEY
+    update_latest: true
+  - match: "dependabot/*"
+    skip: true
+`), 0644)
+
+	m, err := loadManifest(p)
+	if err != nil {
+		t.Fatalf("loadManifest: %v", err)
+	}
+	if m.Frontend.Artifact != filepath.Join(dir, "dist") {
+		t.Errorf("artifact = %q, want it relative to the manifest", m.Frontend.Artifact)
+	}
+
+	tests := []struct {
+		branch string
+		want   BranchRule
+	}{
+		{"main", BranchRule{Match: "main", UpdateLatest: true, Site: "example.com"}},
+		{"develop", BranchRule{Match: "develop", UpdateLatest: true, Site: "staging.example.com", KeyEnv: "SHIPYARD_STAGING_KEY"}},
+		{"dependabot/npm", BranchRule{Match: "dependabot/*", Skip: true, Site: "example.com"}},
+		{"feature/x", BranchRule{Site: "example.com"}},
+	}
+	for _, tt := range tests {
+		if got := m.rule(tt.branch); got != tt.want {
+			t.Errorf("rule(%q) = %+v, want %+v", tt.branch, got, tt.want)
+		}
+	}
+
+	// Unknown keys are typos, not ignored
+	os.WriteFile(p, []byte("site: example.com\nfrontend:\n  artifact: dist\nupdate_lastest: true\n"), 0644)
+	if _, err := loadManifest(p); err == nil {
+		t.Error("unknown key accepted")
+	}
+}
+
+func TestFrontendArtifact_ZipsDirectory(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "assets"), 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>"), 0644)
+	os.WriteFile(filepath.Join(dir, "assets", "app.js"), []byte("js"), 0644)
+
+	f, err := frontendArtifact(dir)
+	if err != nil {
+		t.Fatalf("frontendArtifact: %v", err)
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(f)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	var names []string
+	for _, zf := range zr.File {
+		names = append(names, zf.Name)
+	}
+	if len(names) != 2 || names[0] != "assets/app.js" || names[1] != "index.html" {
+		t.Errorf("zip entries = %v", names)
+	}
+}
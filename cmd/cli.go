@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// Environment variables standing in for flags, for runs where the command
+// line is fixed (rc.d, containers, CI)
+const (
+	EnvConfig     = "SHIPYARD_CONFIG"      // --config
+	EnvLogLevel   = "SHIPYARD_LOG_LEVEL"   // --log-level
+	EnvListenAddr = "SHIPYARD_LISTEN_ADDR" // serve --listen
+)
+
+// CLI is what every command gets: the build's version and the global flags,
+// which may come before the command or among its own flags
+type CLI struct {
+	Version string
+	Commit  string
+	// ConfigPath is --config; empty finds the default (see resolveConfigPath)
+	ConfigPath string
+	// LogLevel is --log-level; empty keeps the config's level
+	LogLevel string
+}
+
+// command is a shipyard subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(cli *CLI, args []string) error
+}
+
+// commands lists the subcommands in the order usage shows them
+var commands = []command{
+	{"serve", "Start the HTTP server", Serve},
+	{"bootstrap", "Bootstrap shipyard onto FreeBSD", Bootstrap},
+	{"doctor", "Check the runtime environment", Doctor},
+	{"status", "Show sites, health, deploys, certs and jails", Status},
+	{"rollback", "Restore the previous binary after a failed update", Rollback},
+	{"split-secrets", "Move keys into shipyard.secrets.toml", SplitSecrets},
+	{"update-jails", "Run freebsd-update in each backend jail", UpdateJails},
+	{"ci-deploy", "Deploy a build as shipyard.yaml describes (for CI)", CIDeploy},
+	{"version", "Print version info", func(cli *CLI, args []string) error {
+		if err := cli.flags("version").Parse(args); err != nil {
+			return err
+		}
+		PrintVersion(cli.Version, cli.Commit)
+		return nil
+	}},
+}
+
+// Run parses the global flags and runs the command they're followed by.
+// It returns flag.ErrHelp after printing help for -h.
+func Run(version, commit string, args []string) error {
+	cli := &CLI{Version: version, Commit: commit, ConfigPath: os.Getenv(EnvConfig)}
+	fs := cli.flags("shipyard")
+	fs.Usage = func() { printUsage(fs.Output(), fs) }
+	if level := os.Getenv(EnvLogLevel); level != "" {
+		if err := fs.Set("log-level", level); err != nil {
+			return fmt.Errorf("%s: %w", EnvLogLevel, err)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no command given")
+	}
+	name, rest := fs.Arg(0), fs.Args()[1:]
+	if name == "help" {
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		return nil
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(cli, rest)
+		}
+	}
+	fs.Usage()
+	return fmt.Errorf("unknown command: %s", name)
+}
+
+// loadConfig loads the config --config names, listening on
+// SHIPYARD_LISTEN_ADDR if set
+func (cli *CLI) loadConfig() (*config.Config, error) {
+	cfg, err := config.Load(resolveConfigPath(cli.ConfigPath))
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	if addr := os.Getenv(EnvListenAddr); addr != "" {
+		cfg.SetListenAddr(addr)
+	}
+	return cfg, nil
+}
+
+// flags returns a flag set with the global flags, so a command accepts them
+// after its name too. Parsing it sets the CLI's fields and the log level.
+func (cli *CLI) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cli.ConfigPath, "config", cli.ConfigPath, "config file path (default /usr/local/etc/shipyard/shipyard.toml, else ./shipyard.toml)")
+	fs.Func("log-level", "log level: debug, info, warn or error", func(s string) error {
+		var lvl slog.Level
+		if err := lvl.UnmarshalText([]byte(s)); err != nil {
+			return err
+		}
+		cli.LogLevel = s
+		slog.SetLogLoggerLevel(lvl)
+		return nil
+	})
+	return fs
+}
+
+// printUsage writes the global usage and the command list
+func printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: shipyard [--config PATH] [--log-level LEVEL] <command> [flags]\n\n")
+	fmt.Fprintf(w, "Commands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nGlobal flags:\n")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nEnvironment:\n")
+	fmt.Fprintf(w, "  %-21s default for --config\n", EnvConfig)
+	fmt.Fprintf(w, "  %-21s default for --log-level\n", EnvLogLevel)
+	fmt.Fprintf(w, "  %-21s listen address for serve, overriding server.listen_addr\n", EnvListenAddr)
+	fmt.Fprintf(w, "  %-21s server ci-deploy deploys to\n", EnvURL)
+	fmt.Fprintf(w, "  %-21s key ci-deploy deploys with\n", EnvAPIKey)
+	fmt.Fprintf(w, "\nRun 'shipyard <command> -h' for a command's flags.\n")
+}
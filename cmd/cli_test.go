@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestCLIFlags(t *testing.T) {
+	defer slog.SetLogLoggerLevel(slog.LevelInfo)
+
+	cli := &CLI{}
+	fs := cli.flags("shipyard")
+	if err := fs.Parse([]string{"--config", "/a.toml", "--log-level", "debug", "status", "--offline"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if cli.ConfigPath != "/a.toml" || cli.LogLevel != "debug" || fs.Arg(0) != "status" {
+		t.Errorf("global flags = %+v, command %q", cli, fs.Arg(0))
+	}
+
+	// Flags after the command override the global ones; omitted ones keep them
+	if err := cli.flags("status").Parse([]string{"--config", "/b.toml"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if cli.ConfigPath != "/b.toml" || cli.LogLevel != "debug" {
+		t.Errorf("after command flags = %+v", cli)
+	}
+
+	fs = cli.flags("status")
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse([]string{"--log-level", "loud"}); err == nil {
+		t.Error("unknown log level accepted")
+	}
+}
+
+func TestRun_UnknownCommand(t *testing.T) {
+	if err := Run("dev", "unknown", []string{"--config", "/a.toml", "frobnicate"}); err == nil || err.Error() != "unknown command: frobnicate" {
+		t.Errorf("Run = %v, want unknown command", err)
+	}
+}
+
+func TestServe_ExclusiveModes(t *testing.T) {
+	if err := Serve(&CLI{}, []string{"--foreground", "--daemon"}); err == nil {
+		t.Error("Serve accepted --foreground with --daemon")
+	}
+}
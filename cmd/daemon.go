@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// daemonEnv marks the detached copy of serve --daemon, which must not
+// detach again (including the binary a self-update starts)
+const daemonEnv = "SHIPYARD_DAEMONIZED"
+
+// daemonStartupWait is how long serve --daemon watches the detached copy for
+// an early exit, such as a locked pid file or a port in use
+const daemonStartupWait = 2 * time.Second
+
+// daemonized reports whether this process is the detached copy
+func daemonized() bool {
+	return os.Getenv(daemonEnv) != ""
+}
+
+// startDaemon re-runs this command in a new session, detached from the
+// terminal, and returns once it has survived startup. Its output goes to
+// server.log_file, or nowhere if that is unset.
+func startDaemon(cfg *config.Config) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find executable: %w", err)
+	}
+
+	out, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if cfg.Server.LogFile != "" {
+		f, err := os.OpenFile(cfg.Server.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdout, cmd.Stderr = out, out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start daemon: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return fmt.Errorf("daemon exited during startup (%v); see %s", err, logDestination(cfg))
+	case <-time.After(daemonStartupWait):
+	}
+	fmt.Printf("shipyard running in the background (pid %d, listening on %s)\n", cmd.Process.Pid, cfg.ListenAddr())
+	return nil
+}
+
+// logDestination names where the daemon's output went
+func logDestination(cfg *config.Config) string {
+	if cfg.Server.LogFile == "" {
+		return "server.log_file (unset, so output was discarded)"
+	}
+	return cfg.Server.LogFile
+}
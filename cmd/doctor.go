@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/firewall"
+	"github.com/lachierussell/shipyard/nginx"
+)
+
+// checkResult is the outcome of a single doctor check
+type checkResult struct {
+	Name   string
+	Status string // "pass", "warn", "fail"
+	Detail string
+}
+
+func checkPass(name, detail string) checkResult { return checkResult{name, "pass", detail} }
+func checkWarn(name, detail string) checkResult { return checkResult{name, "warn", detail} }
+func checkFail(name, detail string) checkResult { return checkResult{name, "fail", detail} }
+
+// Doctor checks the runtime environment and prints a pass/warn/fail report.
+// Returns an error if any check failed.
+func Doctor(cli *CLI, args []string) error {
+	fs := cli.flags("doctor")
+	skipNetwork := fs.Bool("offline", false, "skip checks that need outbound network access")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := resolveConfigPath(cli.ConfigPath)
+	var results []checkResult
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		results = append(results, checkFail("config", fmt.Sprintf("%s: %v", path, err)))
+		printDoctorReport(results)
+		return fmt.Errorf("config invalid, remaining checks skipped")
+	}
+	results = append(results, checkPass("config", path))
+
+	results = append(results, checkNginx(cfg)...)
+	if cfg.Jail.UsesContainers() {
+		results = append(results, checkContainerRuntime(cfg))
+	} else {
+		results = append(results, checkPot(cfg))
+		results = append(results, checkZFS())
+	}
+	results = append(results, checkCertbot())
+	if !*skipNetwork {
+		results = append(results, checkACMEReachable(cfg.SSL))
+	}
+	results = append(results, checkDirectories(cfg)...)
+	results = append(results, checkFirewall())
+	if usesFirewall(cfg) {
+		results = append(results, checkFirewallAnchor())
+	}
+
+	printDoctorReport(results)
+
+	for _, r := range results {
+		if r.Status == "fail" {
+			return fmt.Errorf("one or more checks failed")
+		}
+	}
+	return nil
+}
+
+// resolveConfigPath returns the explicit path if given, otherwise the standard
+// location, falling back to ./shipyard.toml for development
+func resolveConfigPath(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	configPath := "/usr/local/etc/shipyard/shipyard.toml"
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		configPath = "shipyard.toml"
+	}
+	return configPath
+}
+
+// checkNginx verifies the nginx binary, its version, and the current config
+func checkNginx(cfg *config.Config) []checkResult {
+	// nginx -v prints to stderr
+	output, err := exec.Command(cfg.Nginx.BinaryPath, "-v").CombinedOutput()
+	if err != nil {
+		return []checkResult{checkFail("nginx", fmt.Sprintf("%s not runnable: %v", cfg.Nginx.BinaryPath, err))}
+	}
+	results := []checkResult{checkPass("nginx", strings.TrimSpace(string(output)))}
+
+	if valid, errMsg := nginx.ValidateAndGetError(context.Background(), cfg); valid {
+		results = append(results, checkPass("nginx config", "nginx -t ok"))
+	} else {
+		results = append(results, checkFail("nginx config", errMsg))
+	}
+	return results
+}
+
+// checkPot verifies the pot binary is installed
+func checkPot(cfg *config.Config) checkResult {
+	potPath := cfg.Jail.BinaryPath
+	if potPath == "" {
+		potPath = "pot"
+	}
+	output, err := exec.Command(potPath, "version").CombinedOutput()
+	if err != nil {
+		return checkWarn("pot", fmt.Sprintf("%s not runnable (backends unavailable): %v", potPath, err))
+	}
+	return checkPass("pot", strings.TrimSpace(string(output)))
+}
+
+// checkContainerRuntime verifies the docker or podman binary can reach its runtime
+func checkContainerRuntime(cfg *config.Config) checkResult {
+	name := cfg.Jail.DriverName()
+	// docker reports its daemon's version; podman has no daemon
+	format := "{{.Server.Version}}"
+	if name == config.DriverPodman {
+		format = "{{.Client.Version}}"
+	}
+	output, err := exec.Command(cfg.Jail.ContainerCmd(), "version", "--format", format).CombinedOutput()
+	if err != nil {
+		return checkWarn(name, fmt.Sprintf("%s not runnable (backends unavailable): %v", cfg.Jail.ContainerCmd(), err))
+	}
+	return checkPass(name, strings.TrimSpace(string(output)))
+}
+
+// checkZFS verifies a ZFS pool is available for pot datasets
+func checkZFS() checkResult {
+	output, err := exec.Command("zpool", "list", "-H", "-o", "name,health").Output()
+	if err != nil {
+		return checkWarn("zfs", fmt.Sprintf("zpool list failed: %v", err))
+	}
+	pools := strings.TrimSpace(string(output))
+	if pools == "" {
+		return checkWarn("zfs", "no ZFS pools found")
+	}
+	if strings.Contains(pools, "DEGRADED") || strings.Contains(pools, "FAULTED") {
+		return checkFail("zfs", strings.ReplaceAll(pools, "\n", ", "))
+	}
+	return checkPass("zfs", strings.ReplaceAll(pools, "\n", ", "))
+}
+
+// checkCertbot verifies certbot is installed
+func checkCertbot() checkResult {
+	output, err := exec.Command("certbot", "--version").CombinedOutput()
+	if err != nil {
+		return checkWarn("certbot", fmt.Sprintf("certbot not runnable (SSL sites unavailable): %v", err))
+	}
+	return checkPass("certbot", strings.TrimSpace(string(output)))
+}
+
+// checkACMEReachable verifies the configured ACME directory is reachable,
+// trusting ssl.acme_ca_bundle as certbot will
+func checkACMEReachable(cfg config.SSLConfig) checkResult {
+	directory := cfg.ACMEServer()
+	client := &http.Client{Timeout: 5 * time.Second}
+	if cfg.ACMECABundle != "" {
+		pem, err := os.ReadFile(cfg.ACMECABundle)
+		if err != nil {
+			return checkFail("acme", fmt.Sprintf("ssl.acme_ca_bundle: %v", err))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return checkFail("acme", "ssl.acme_ca_bundle holds no PEM certificates")
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+
+	resp, err := client.Get(directory)
+	if err != nil {
+		return checkWarn("acme", fmt.Sprintf("%s unreachable: %v", directory, err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return checkWarn("acme", fmt.Sprintf("%s returned %d", directory, resp.StatusCode))
+	}
+	return checkPass("acme", directory+" reachable")
+}
+
+// checkDirectories verifies the directories shipyard writes to exist and are writable
+func checkDirectories(cfg *config.Config) []checkResult {
+	dirs := []string{
+		cfg.Nginx.SitesAvailable,
+		cfg.Nginx.SitesEnabled,
+		filepath.Dir(cfg.Nginx.OverrideConf),
+		filepath.Dir(cfg.Self.PidFile),
+		nginx.AcmeWebroot,
+	}
+	if cfg.Server.LogFile != "" {
+		dirs = append(dirs, filepath.Dir(cfg.Server.LogFile))
+	}
+	for _, site := range cfg.Site {
+		if site.HasFrontend() {
+			dirs = append(dirs, site.FrontendRoot)
+		}
+	}
+
+	var results []checkResult
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if dir == "" || dir == "." || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		results = append(results, checkWritableDir(dir))
+	}
+	return results
+}
+
+// checkWritableDir checks that dir exists and a file can be created in it
+func checkWritableDir(dir string) checkResult {
+	name := "dir " + dir
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return checkWarn(name, "does not exist")
+	}
+	if err != nil {
+		return checkFail(name, err.Error())
+	}
+	if !info.IsDir() {
+		return checkFail(name, "not a directory")
+	}
+
+	f, err := os.CreateTemp(dir, ".shipyard-doctor-*")
+	if err != nil {
+		return checkFail(name, fmt.Sprintf("not writable: %v", err))
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return checkPass(name, fmt.Sprintf("writable (%s)", info.Mode().Perm()))
+}
+
+// checkFirewall reports whether pf is enabled
+func checkFirewall() checkResult {
+	output, err := exec.Command("pfctl", "-s", "info").CombinedOutput()
+	if err != nil {
+		return checkWarn("firewall", fmt.Sprintf("pfctl unavailable: %v", err))
+	}
+	if strings.Contains(string(output), "Status: Enabled") {
+		return checkPass("firewall", "pf enabled")
+	}
+	return checkWarn("firewall", "pf is disabled")
+}
+
+// usesFirewall reports whether any backend has an outbound firewall
+func usesFirewall(cfg *config.Config) bool {
+	for _, site := range cfg.Site {
+		if site.Backend != nil && site.Backend.Firewall != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFirewallAnchor reports whether the pf ruleset loads the backends' anchors
+func checkFirewallAnchor() checkResult {
+	output, err := exec.Command("pfctl", "-s", "rules").CombinedOutput()
+	if err != nil {
+		return checkFail("firewall anchor", fmt.Sprintf("pfctl unavailable: %v", err))
+	}
+	anchor := fmt.Sprintf("anchor %q", firewall.AnchorRoot+"/*")
+	if strings.Contains(string(output), anchor) {
+		return checkPass("firewall anchor", anchor)
+	}
+	return checkFail("firewall anchor", fmt.Sprintf("add %s to pf.conf, or backend firewall rules have no effect", anchor))
+}
+
+// printDoctorReport prints results as an aligned table
+func printDoctorReport(results []checkResult) {
+	width := 0
+	for _, r := range results {
+		if len(r.Name) > width {
+			width = len(r.Name)
+		}
+	}
+
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Printf("[%s] %-*s  %s\n", strings.ToUpper(r.Status), width, r.Name, r.Detail)
+	}
+	fmt.Printf("\n%d passed, %d warnings, %d failed\n", counts["pass"], counts["warn"], counts["fail"])
+}
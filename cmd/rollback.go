@@ -3,23 +3,19 @@ package cmd
 import (
 	"fmt"
 	"log/slog"
-	"os"
 
 	"github.com/lachierussell/shipyard/config"
 	"github.com/lachierussell/shipyard/update"
 )
 
 // Rollback restores the previous binary from backup
-func Rollback() error {
-	// Find config file
-	configPath := "/usr/local/etc/shipyard/shipyard.toml"
-
-	// For development, also check current directory
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		configPath = "shipyard.toml"
+func Rollback(cli *CLI, args []string) error {
+	if err := cli.flags("rollback").Parse(args); err != nil {
+		return err
 	}
+	version, commit := cli.Version, cli.Commit
 
-	cfg, err := config.Load(configPath)
+	cfg, err := config.Load(resolveConfigPath(cli.ConfigPath))
 	if err != nil {
 		return fmt.Errorf("load config: %w", err)
 	}
@@ -32,8 +28,8 @@ func Rollback() error {
 
 	slog.Info("rolling back to previous binary", "path", cfg.Self.BinaryPath+".old")
 
-	if err := updater.Rollback(); err != nil {
-		return fmt.Errorf("rollback failed: %w", err)
+	if err := rollbackSelfUpdate(cfg, updater, version, commit, "cli", ""); err != nil {
+		return err
 	}
 
 	slog.Info("rollback successful")
@@ -43,3 +39,30 @@ func Rollback() error {
 
 	return nil
 }
+
+// rollbackSelfUpdate restores the .old binary and records the rollback in the update history
+func rollbackSelfUpdate(cfg *config.Config, updater *update.Updater, version, commit, initiator, reason string) error {
+	// Ask the backup for its version before it replaces us
+	prev, err := updater.BackupInfo()
+	if err != nil {
+		prev = update.BinaryInfo{Version: "unknown"}
+	}
+
+	if err := updater.Rollback(); err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+
+	history := update.NewHistory(update.HistoryPath(cfg.StateDir()))
+	if err := history.Append(update.Record{
+		Event:      update.EventRollback,
+		OldVersion: version,
+		OldCommit:  commit,
+		NewVersion: prev.Version,
+		NewCommit:  prev.Commit,
+		Initiator:  initiator,
+		Reason:     reason,
+	}); err != nil {
+		slog.Warn("failed to record rollback history", "error", err)
+	}
+	return nil
+}
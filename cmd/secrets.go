@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// SplitSecrets moves admin keys and site API keys from the config file into
+// shipyard.secrets.toml (mode 0600). Later saves keep them there.
+func SplitSecrets(cli *CLI, args []string) error {
+	if err := cli.flags("split-secrets").Parse(args); err != nil {
+		return err
+	}
+
+	path := resolveConfigPath(cli.ConfigPath)
+	cfg, err := config.Load(path)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if err := cfg.SplitSecrets(); err != nil {
+		return fmt.Errorf("split secrets: %w", err)
+	}
+
+	fmt.Printf("Secrets moved to %s\n", config.SecretsPath(path))
+	fmt.Printf("%s no longer contains keys and can be made world-readable or committed\n", path)
+	return nil
+}
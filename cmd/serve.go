@@ -1,34 +1,65 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
+	"github.com/lachierussell/shipyard/firewall"
 	"github.com/lachierussell/shipyard/logger"
+	"github.com/lachierussell/shipyard/logstore"
 	"github.com/lachierussell/shipyard/nginx"
 	"github.com/lachierussell/shipyard/pidfile"
+	"github.com/lachierussell/shipyard/runner"
 	"github.com/lachierussell/shipyard/server"
 	"github.com/lachierussell/shipyard/update"
 )
 
-// Serve starts the HTTP server
-func Serve(version, commit string) error {
-	// Find config file (look for /usr/local/etc/shipyard/shipyard.toml by default)
-	configPath := "/usr/local/etc/shipyard/shipyard.toml"
+// maxUnverifiedStarts is how many times a pending self-update may start without
+// passing verification before it is rolled back at startup
+const maxUnverifiedStarts = 3
 
-	// For development, also check current directory
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		configPath = "shipyard.toml"
+// includeDirPollInterval is how often hand edits to include_dir site files are picked up
+const includeDirPollInterval = 5 * time.Second
+
+// defaultVerifyTimeout bounds the post-update /health self-check
+const defaultVerifyTimeout = 30 * time.Second
+
+// Serve starts the HTTP server. It runs attached to the terminal, logging
+// to server.log_file; --foreground logs to stdout instead and --daemon
+// detaches into the background.
+func Serve(cli *CLI, args []string) error {
+	fs := cli.flags("serve")
+	foreground := fs.Bool("foreground", false, "log to stdout instead of server.log_file, e.g. under tmux or a container runtime")
+	daemon := fs.Bool("daemon", false, "detach from the terminal and run in the background")
+	listen := fs.String("listen", "", "listen address, overriding server.listen_addr and "+EnvListenAddr)
+	pidPath := fs.String("pidfile", "", "pid file, overriding self.pid_file")
+	noPidfile := fs.Bool("no-pidfile", false, "don't write or lock a pid file, e.g. when a supervisor or container tracks the process")
+	dryRun := fs.Bool("dry-run", false, "log nginx, pot, certbot and service commands instead of running them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *foreground && *daemon {
+		return fmt.Errorf("--foreground and --daemon are exclusive")
 	}
+	version, commit := cli.Version, cli.Commit
 
-	cfg, err := config.Load(configPath)
+	cfg, err := cli.loadConfig()
 	if err != nil {
-		return fmt.Errorf("load config: %w", err)
+		return err
+	}
+	if *listen != "" {
+		cfg.SetListenAddr(*listen)
+	}
+	if *daemon && !daemonized() {
+		return startDaemon(cfg)
 	}
 
 	// Validate that required tools are available
@@ -38,36 +69,84 @@ func Serve(version, commit string) error {
 	logHub := server.NewLogHub()
 	go logHub.Run()
 
-	// Initialize structured logging with broadcast to WebSocket clients
-	if err := logger.InitWithBroadcaster(cfg.Server.LogFile, cfg.Server.LogLevel, logHub); err != nil {
+	// Keep a searchable copy of the log
+	var logs *logstore.Store
+	broadcasters := logger.Broadcasters{logHub}
+	if cfg.Logs.Enabled() {
+		logs = logstore.New(logstore.Dir(cfg.StateDir()), cfg.Logs.Retention())
+		go logs.Run()
+		broadcasters = append(broadcasters, logs)
+	}
+
+	// Initialize structured logging with broadcast to WebSocket clients and the log store
+	logFile, logLevel := cfg.Server.LogFile, cfg.Server.LogLevel
+	if *foreground {
+		logFile = ""
+	}
+	if cli.LogLevel != "" {
+		logLevel = cli.LogLevel
+	}
+	if err := logger.InitWithBroadcaster(logFile, logLevel, broadcasters); err != nil {
 		return fmt.Errorf("init logger: %w", err)
 	}
+	if *dryRun {
+		runner.SetHook(runner.DryRun)
+		slog.Warn("dry run: host commands are logged, not run; files are still written")
+	}
 
 	// Ensure managed nginx.conf is up to date (picks up fixes from self-updates)
 	nginxMgr := nginx.NewManager(cfg)
-	if updated, err := nginxMgr.EnsureMainConf(); err != nil {
+	if updated, err := nginxMgr.EnsureMainConf(context.Background()); err != nil {
 		slog.Warn("failed to update nginx main config", "error", err)
 	} else if updated {
 		slog.Info("nginx main config updated and reloaded")
 	}
 
+	// Repair deploys interrupted by a crash or power loss before serving. After
+	// a self-update the server does it once the previous process has drained.
+	if !server.HandedOver() {
+		if _, err := deploy.Recover(cfg, deploy.Managers{Nginx: nginxMgr}); err != nil {
+			slog.Error("deploy recovery failed", "error", err)
+		}
+	}
+
+	// pf anchors don't survive a reboot or a flush of the main ruleset
+	firewall.NewManager(cfg).Sync()
+
 	// Startup safety check: warn if backup binary exists
 	checkBackupBinary(cfg.Self.BinaryPath)
 
-	// Create PID file (single-instance enforcement)
-	pf, err := pidfile.Create(cfg.Self.PidFile)
+	// A self-update that keeps failing to come up is rolled back before it can crash-loop
+	updater := update.NewUpdater(cfg.Self.BinaryPath)
+	verifyUpdate, rolledBack, err := checkPendingUpdate(cfg, updater, version, commit)
 	if err != nil {
-		return fmt.Errorf("pidfile: %w", err)
+		return err
+	}
+	if rolledBack {
+		// The inherited listener fd (if any) is still in our environment
+		return syscall.Exec(cfg.Self.BinaryPath, os.Args, os.Environ())
+	}
+
+	// Create PID file (single-instance enforcement)
+	var pf *pidfile.File
+	if !*noPidfile {
+		path := cfg.Self.PidFile
+		if *pidPath != "" {
+			path = *pidPath
+		}
+		if pf, err = pidfile.Create(path); err != nil {
+			return fmt.Errorf("pidfile: %w", err)
+		}
+		defer pf.Close()
 	}
-	defer pf.Close()
 
 	// Create server
-	srv := server.New(cfg, version, commit, logHub)
+	srv := server.New(cfg, version, commit, logHub, logs, server.Managers{Nginx: nginxMgr})
 
 	slog.Info("server starting",
 		"version", version,
 		"commit", commit,
-		"listen_addr", cfg.Server.ListenAddr,
+		"listen_addr", cfg.ListenAddr(),
 	)
 
 	// Handle shutdown signals
@@ -77,30 +156,152 @@ func Serve(version, commit string) error {
 	// Start server in background
 	errChan := make(chan error, 1)
 	go func() {
-		if err := srv.Listen(cfg.Server.ListenAddr); err != nil {
+		if err := srv.Listen(cfg.ListenAddr()); err != nil {
 			errChan <- fmt.Errorf("listen: %w", err)
 		}
 	}()
 
+	// startup_reconcile: after a host reboot, re-link site configs, bring
+	// nginx back and start every backend, dependencies first
+	go srv.StartupReconcile()
+
+	// Confirm a fresh self-update actually serves traffic, or roll it back
+	if verifyUpdate {
+		go verifySelfUpdate(cfg, srv, updater, version, commit)
+	}
+
+	// Pick up site files edited by hand or by config management
+	if cfg.IncludeDir != "" {
+		go watchIncludeDir(cfg)
+	}
+
+	// Answer ACME challenges directly if configured
+	if cfg.SSL.ACMEListenAddr != "" {
+		slog.Info("acme challenge listener starting", "listen_addr", cfg.SSL.ACMEListenAddr)
+		go func() {
+			if err := srv.ListenACME(cfg.SSL.ACMEListenAddr); err != nil {
+				errChan <- fmt.Errorf("acme listen: %w", err)
+			}
+		}()
+	}
+
 	// Wait for shutdown signal, self-update trigger, or error
+	selfUpdate := false
 	select {
 	case sig := <-sigChan:
 		slog.Info("received signal, shutting down", "signal", sig.String())
 	case <-srv.ShutdownChan():
-		slog.Info("self-update triggered, shutting down for restart")
+		slog.Info("restart triggered, draining in-flight requests")
+		selfUpdate = true
 	case err := <-errChan:
 		return err
 	}
 
-	// Graceful shutdown
-	if err := srv.Shutdown(); err != nil {
-		return fmt.Errorf("shutdown: %w", err)
+	// On a self-update, start the new binary with the listener first so it
+	// serves new connections while this process drains. Release the pidfile
+	// so it can claim it.
+	var successor *server.Successor
+	if selfUpdate {
+		if pf != nil {
+			pf.Close()
+		}
+		if successor, err = srv.Handover(cfg.Self.BinaryPath); err != nil {
+			slog.Error("listener handover failed, exiting for supervisor restart", "error", err)
+		}
+	}
+
+	// Graceful shutdown (waits for in-flight requests to finish)
+	shutdownErr := srv.Shutdown()
+	if successor != nil {
+		if shutdownErr != nil {
+			slog.Error("shutdown", "error", shutdownErr)
+		}
+		successor.Release()
+		slog.Info("drained, new binary has taken over")
+		return successor.Wait(sigChan)
+	}
+	if shutdownErr != nil {
+		return fmt.Errorf("shutdown: %w", shutdownErr)
 	}
 
 	slog.Info("shutdown complete")
 	return nil
 }
 
+// watchIncludeDir polls include_dir and applies changed site files. A file
+// that fails to parse or validate is logged and the previous config is kept.
+func watchIncludeDir(cfg *config.Config) {
+	for range time.Tick(includeDirPollInterval) {
+		changed, err := cfg.ReloadIncludeDir()
+		if err != nil {
+			slog.Error("site files not reloaded, keeping previous config", "include_dir", cfg.IncludeDir, "error", err)
+			continue
+		}
+		fw := firewall.NewManager(cfg)
+		for _, name := range changed {
+			slog.Info("site config reloaded", "site", name)
+			if err := fw.Apply(name); err != nil {
+				slog.Warn("firewall rules not loaded", "site", name, "error", err)
+			}
+		}
+	}
+}
+
+// checkPendingUpdate counts this start of an unverified self-update, and
+// reports whether it should be verified once serving. After
+// maxUnverifiedStarts failed starts it rolls the update back instead, and the
+// caller must run the restored binary.
+func checkPendingUpdate(cfg *config.Config, updater *update.Updater, version, commit string) (verify, rolledBack bool, err error) {
+	if !updater.Pending() {
+		return false, false, nil
+	}
+	attempts, err := updater.RecordStartAttempt()
+	if err != nil {
+		slog.Warn("failed to record self-update start attempt", "error", err)
+	}
+	if attempts > maxUnverifiedStarts {
+		slog.Error("updated binary failed to start repeatedly, rolling back", "attempts", attempts-1)
+		if err := rollbackSelfUpdate(cfg, updater, version, commit, "auto", "failed to start"); err != nil {
+			return false, false, err
+		}
+		return false, true, nil
+	}
+	return true, false, nil
+}
+
+// verifySelfUpdate polls the local /health endpoint after a self-update. If the new
+// binary does not answer within the verify timeout, the previous binary is restored
+// and the listener is handed back to it.
+func verifySelfUpdate(cfg *config.Config, srv *server.Server, updater *update.Updater, version, commit string) {
+	timeout := defaultVerifyTimeout
+	if cfg.Self.VerifyTimeout > 0 {
+		timeout = time.Duration(cfg.Self.VerifyTimeout) * time.Second
+	}
+
+	var lastErr error
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, lastErr = getLocalHealth(cfg, 2*time.Second); lastErr == nil {
+			if err := updater.ConfirmUpdate(); err != nil {
+				slog.Warn("failed to confirm self-update", "error", err)
+			}
+			slog.Info("self-update verified")
+			return
+		}
+		time.Sleep(time.Second)
+	}
+
+	slog.Error("self-update failed verification, rolling back",
+		"timeout", timeout.String(),
+		"error", lastErr,
+	)
+	if err := rollbackSelfUpdate(cfg, updater, version, commit, "auto", "failed /health verification"); err != nil {
+		slog.Error("self-update rollback failed", "error", err)
+		return
+	}
+	srv.TriggerShutdown()
+}
+
 // checkBackupBinary logs a notice if a backup binary exists from a previous update
 func checkBackupBinary(binaryPath string) {
 	updater := update.NewUpdater(binaryPath)
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/update"
+)
+
+// fakeShipyard returns a script that reports version like shipyard does
+func fakeShipyard(version string) string {
+	return "#!/bin/sh\necho 'shipyard version " + version + " (commit abc1234)'\n"
+}
+
+func TestCheckPendingUpdate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{Self: config.SelfConfig{
+		BinaryPath: filepath.Join(dir, "shipyard"),
+		StateDir:   filepath.Join(dir, "state"),
+	}}
+	os.WriteFile(cfg.Self.BinaryPath, []byte(fakeShipyard("1.0.0")), 0755)
+	updater := update.NewUpdater(cfg.Self.BinaryPath)
+
+	if verify, rolledBack, err := checkPendingUpdate(cfg, updater, "1.0.0", "abc1234"); verify || rolledBack || err != nil {
+		t.Fatalf("no pending update = %v, %v, %v; want nothing to do", verify, rolledBack, err)
+	}
+
+	if _, err := updater.Update(strings.NewReader(fakeShipyard("2.0.0")), update.Policy{CurrentVersion: "1.0.0"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	// Each start below the limit verifies the update
+	for i := 1; i <= maxUnverifiedStarts; i++ {
+		verify, rolledBack, err := checkPendingUpdate(cfg, updater, "2.0.0", "abc1234")
+		if !verify || rolledBack || err != nil {
+			t.Fatalf("start %d = %v, %v, %v; want verify", i, verify, rolledBack, err)
+		}
+	}
+
+	// The next one rolls back
+	verify, rolledBack, err := checkPendingUpdate(cfg, updater, "2.0.0", "abc1234")
+	if verify || !rolledBack || err != nil {
+		t.Fatalf("start past the limit = %v, %v, %v; want rolled back", verify, rolledBack, err)
+	}
+	content, _ := os.ReadFile(cfg.Self.BinaryPath)
+	if !strings.Contains(string(content), "1.0.0") {
+		t.Errorf("binary after rollback = %q, want 1.0.0", content)
+	}
+	if updater.Pending() {
+		t.Error("rollback left the update pending")
+	}
+	records, err := update.NewHistory(update.HistoryPath(cfg.StateDir())).List()
+	if err != nil || len(records) != 1 || records[0].Event != update.EventRollback || records[0].NewVersion != "1.0.0" {
+		t.Errorf("history = %+v, %v; want the rollback to 1.0.0", records, err)
+	}
+}
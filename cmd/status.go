@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
+	"github.com/lachierussell/shipyard/health"
+	"github.com/lachierussell/shipyard/jail"
+	"github.com/lachierussell/shipyard/ssl"
+)
+
+// Status prints a table of sites with health, last deploy, cert expiry and jail state.
+// State is read directly from disk, pot and the backends, so it works even when
+// the shipyard server is down.
+func Status(cli *CLI, args []string) error {
+	if err := cli.flags("status").Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := cli.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(serverStatusLine(cfg))
+	fmt.Println()
+
+	jailMgr := jail.NewDriver(cfg)
+
+	names := make([]string, 0, len(cfg.Site))
+	for name := range cfg.Site {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SITE\tHEALTH\tLATEST\tDEPLOYED\tCERT EXPIRES\tJAIL")
+	for _, name := range names {
+		site := cfg.Site[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			name,
+			siteHealth(cfg, site),
+			latestColumn(site),
+			deployedColumn(site),
+			certColumn(name, site),
+			jailColumn(jailMgr, name, site),
+		)
+	}
+	return w.Flush()
+}
+
+// serverStatusLine queries the local server's /health endpoint
+func serverStatusLine(cfg *config.Config) string {
+	body, err := getLocalHealth(cfg, 3*time.Second)
+	if err != nil {
+		return "shipyard: not responding (" + err.Error() + ")"
+	}
+	return fmt.Sprintf("shipyard: %s (version %s, commit %s)", body.Status, body.Version, body.Commit)
+}
+
+// healthResponse is the body returned by GET /health
+type healthResponse struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+}
+
+// getLocalHealth queries the local server's /health endpoint over loopback
+func getLocalHealth(cfg *config.Config, timeout time.Duration) (*healthResponse, error) {
+	_, port, err := net.SplitHostPort(cfg.ListenAddr())
+	if err != nil {
+		return nil, fmt.Errorf("unknown listen address %s", cfg.ListenAddr())
+	}
+
+	scheme := "http"
+	if cfg.Server.TLSCert != "" && cfg.Server.TLSKey != "" {
+		scheme = "https"
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		// Local loopback check; the server cert won't be issued for 127.0.0.1
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	resp, err := client.Get(fmt.Sprintf("%s://127.0.0.1:%s/health", scheme, port))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("/health returned %d", resp.StatusCode)
+	}
+
+	var body healthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode /health: %w", err)
+	}
+	return &body, nil
+}
+
+// siteHealth probes the backend health endpoint; frontend-only sites have no health check
+func siteHealth(cfg *config.Config, site config.SiteConfig) string {
+	if site.Backend == nil {
+		return "-"
+	}
+	if err := health.Probe(site.Backend, cfg.Health, 2*time.Second); err != nil {
+		return "unhealthy"
+	}
+	return "healthy"
+}
+
+func latestColumn(site config.SiteConfig) string {
+	if !site.HasFrontend() {
+		return "-"
+	}
+	commit, _, err := deploy.LatestCommit(site.FrontendRoot)
+	if err != nil {
+		return "none"
+	}
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	return commit
+}
+
+func deployedColumn(site config.SiteConfig) string {
+	if !site.HasFrontend() {
+		return "-"
+	}
+	_, at, err := deploy.LatestCommit(site.FrontendRoot)
+	if err != nil {
+		return "-"
+	}
+	return at.Local().Format("2006-01-02 15:04")
+}
+
+func certColumn(name string, site config.SiteConfig) string {
+	if !site.SSLEnabled {
+		return "-"
+	}
+	expiry, err := ssl.CertExpiry(name)
+	if err != nil {
+		return "missing"
+	}
+	days := int(time.Until(expiry).Hours() / 24)
+	if days < 0 {
+		return fmt.Sprintf("%s (EXPIRED)", expiry.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("%s (%dd)", expiry.Format("2006-01-02"), days)
+}
+
+func jailColumn(jailMgr jail.Driver, name string, site config.SiteConfig) string {
+	if site.Backend == nil {
+		return "-"
+	}
+	if jailMgr.IsRunning(name) {
+		return "running"
+	}
+	return "stopped"
+}
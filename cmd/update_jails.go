@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// UpdateJails runs freebsd-update across backend jails one at a time, in the
+// foreground, stopping at the first jail whose backend is unhealthy afterwards.
+// It works without the shipyard server; POST /jails/update does the same in
+// the background.
+func UpdateJails(cli *CLI, args []string) error {
+	fs := cli.flags("update-jails")
+	sitesFlag := fs.String("sites", "", "comma-separated sites to update (default all backends)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(resolveConfigPath(cli.ConfigPath))
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	var sites []string
+	if *sitesFlag != "" {
+		sites = strings.Split(*sitesFlag, ",")
+	}
+	u, err := deploy.NewBaseUpdate(cfg, sites)
+	if err != nil {
+		return err
+	}
+
+	for !u.Done() {
+		site := u.Current()
+		fmt.Printf("updating %s...\n", site)
+		if err := u.Step(); err != nil {
+			break
+		}
+	}
+
+	status := u.Status()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SITE\tSTATUS\tFROM\tTO\tVERIFIED\tERROR")
+	var remaining []string
+	for _, j := range status.Jails {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\n", j.Site, j.Status, j.From, j.To, j.Verified, j.Error)
+		if j.Status == deploy.JailFailed || j.Status == deploy.JailPending {
+			remaining = append(remaining, j.Site)
+		}
+	}
+	w.Flush()
+
+	if status.State == deploy.BaseUpdatePaused {
+		return fmt.Errorf("stopped at a failed jail; fix it and continue with: shipyard update-jails --sites %s", strings.Join(remaining, ","))
+	}
+	return nil
+}
@@ -4,11 +4,22 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/lachierussell/shipyard/cron"
 )
 
 type Config struct {
@@ -17,12 +28,33 @@ type Config struct {
 	Jail      JailConfig            `toml:"jail"`
 	Health    HealthConfig          `toml:"health"`
 	Self      SelfConfig            `toml:"self"`
+	SSL       SSLConfig             `toml:"ssl"`
+	Artifacts ArtifactsConfig       `toml:"artifacts"`
+	Deploy    DeployConfig          `toml:"deploy"`
+	Secrets   SecretsConfig         `toml:"secrets"`
+	Ban       BanConfig             `toml:"ban"`
 	AdminKeys []string              `toml:"admin_keys"`
-	Site      map[string]SiteConfig `toml:"site"`
+	// KeyACL limits admin keys to the sites matching any of their globs
+	// (e.g. "*.team-a.example.com"). Keys not listed may manage every site.
+	KeyACL map[string][]string   `toml:"key_acl,omitempty"`
+	OIDC   OIDCConfig            `toml:"oidc"`
+	Notify NotifyConfig          `toml:"notify"`
+	// StatusPage lists sites on a public status page
+	StatusPage StatusPageConfig `toml:"status_page"`
+	// Logs keeps a searchable copy of shipyard's and backends' logs
+	Logs LogsConfig `toml:"logs"`
+	// IncludeDir holds one <site>.toml per site, merged with [site] at load.
+	// Sites created through the API are written there when it is set.
+	IncludeDir string                `toml:"include_dir,omitempty"`
+	Site       map[string]SiteConfig `toml:"site"`
 
 	// Runtime fields (not serialized)
-	path string
-	mu   sync.RWMutex
+	path        string
+	listenAddr  string               // overrides Server.ListenAddr without saving it
+	secretsPath string               // set when secrets live in a separate file
+	siteFiles   map[string]string    // site name -> file, for sites in include_dir
+	siteStamps  map[string]fileStamp // include_dir file versions last loaded or saved
+	mu          sync.RWMutex
 }
 
 type ServerConfig struct {
@@ -31,6 +63,160 @@ type ServerConfig struct {
 	LogLevel   string `toml:"log_level"`
 	TLSCert    string `toml:"tls_cert"`
 	TLSKey     string `toml:"tls_key"`
+	// ClientCA is a PEM CA bundle; when set, API requests must present a client
+	// certificate signed by it (in addition to the API key). Requires tls_cert/tls_key.
+	ClientCA string `toml:"client_ca,omitempty"`
+
+	// Request limits. Zero values use the defaults below; timeouts of zero mean none.
+	MaxBodyMB         int `toml:"max_body_mb,omitempty"`
+	MultipartMemoryMB int `toml:"multipart_memory_mb,omitempty"`
+	ReadTimeout       int `toml:"read_timeout,omitempty"`  // seconds
+	WriteTimeout      int `toml:"write_timeout,omitempty"` // seconds
+	IdleTimeout       int `toml:"idle_timeout,omitempty"`  // seconds
+	// ShutdownTimeout is how long (seconds) shutdown waits for in-flight deploys. Default 120.
+	ShutdownTimeout int `toml:"shutdown_timeout,omitempty"`
+
+	// Log stream (/ws/logs) limits. Zero values use the defaults below.
+	WSMaxConnections int `toml:"ws_max_connections,omitempty"`
+	WSPingInterval   int `toml:"ws_ping_interval,omitempty"` // seconds between pings
+	WSPongTimeout    int `toml:"ws_pong_timeout,omitempty"`  // seconds a ping or write may go unanswered
+}
+
+// Request limit defaults
+const (
+	DefaultMaxBodyMB         = 500
+	DefaultMultipartMemoryMB = 32
+)
+
+// BodyLimit returns the maximum request body size in bytes
+func (s ServerConfig) BodyLimit() int {
+	if s.MaxBodyMB > 0 {
+		return s.MaxBodyMB << 20
+	}
+	return DefaultMaxBodyMB << 20
+}
+
+// Log stream defaults
+const (
+	DefaultWSMaxConnections = 100
+	DefaultWSPingInterval   = 30 * time.Second
+	DefaultWSPongTimeout    = 10 * time.Second
+)
+
+// WSMaxConns returns how many log stream connections may be open at once
+func (s ServerConfig) WSMaxConns() int {
+	if s.WSMaxConnections > 0 {
+		return s.WSMaxConnections
+	}
+	return DefaultWSMaxConnections
+}
+
+// WSPing returns how often log stream clients are pinged
+func (s ServerConfig) WSPing() time.Duration {
+	if s.WSPingInterval > 0 {
+		return time.Duration(s.WSPingInterval) * time.Second
+	}
+	return DefaultWSPingInterval
+}
+
+// WSPongWait returns how long a log stream client has to answer a ping or
+// take a line before it is disconnected
+func (s ServerConfig) WSPongWait() time.Duration {
+	if s.WSPongTimeout > 0 {
+		return time.Duration(s.WSPongTimeout) * time.Second
+	}
+	return DefaultWSPongTimeout
+}
+
+// MultipartMemory returns how many bytes of a multipart upload are held in
+// memory before file parts spill to temporary files
+func (s ServerConfig) MultipartMemory() int64 {
+	if s.MultipartMemoryMB > 0 {
+		return int64(s.MultipartMemoryMB) << 20
+	}
+	return DefaultMultipartMemoryMB << 20
+}
+
+// DeployConfig limits how many deploys run at once. Zero values use the
+// defaults below.
+type DeployConfig struct {
+	// Workers is how many async deploys run at once
+	Workers int `toml:"workers,omitempty"`
+	// MaxFrontend bounds frontend extractions running at once, async or not
+	MaxFrontend int `toml:"max_frontend,omitempty"`
+	// MaxBackend bounds backend deploys running at once, async or not
+	MaxBackend int `toml:"max_backend,omitempty"`
+	// ExtractWorkers is how many files of one frontend zip are written at once
+	ExtractWorkers int `toml:"extract_workers,omitempty"`
+	// VerifyInterval is how often (seconds) live frontend commits are checked
+	// against the manifests recorded when they were deployed. Default 3600;
+	// -1 turns the periodic check off.
+	VerifyInterval int `toml:"verify_interval,omitempty"`
+	// ApprovalTimeout is how long (seconds) a deploy to a site with
+	// require_approval waits to be approved. Default 86400.
+	ApprovalTimeout int `toml:"approval_timeout,omitempty"`
+}
+
+// Deploy concurrency defaults
+const (
+	DefaultDeployWorkers     = 4
+	DefaultMaxFrontendDeploy = 4
+	DefaultMaxBackendDeploy  = 2
+	DefaultExtractWorkers    = 8
+	DefaultVerifyInterval    = time.Hour
+	DefaultApprovalTimeout   = 24 * time.Hour
+)
+
+// WorkerCount returns how many async deploys run at once
+func (d DeployConfig) WorkerCount() int {
+	if d.Workers > 0 {
+		return d.Workers
+	}
+	return DefaultDeployWorkers
+}
+
+// FrontendLimit returns how many frontend deploys may extract at once
+func (d DeployConfig) FrontendLimit() int {
+	if d.MaxFrontend > 0 {
+		return d.MaxFrontend
+	}
+	return DefaultMaxFrontendDeploy
+}
+
+// ExtractWorkerCount returns how many files of a frontend zip are written at once
+func (d DeployConfig) ExtractWorkerCount() int {
+	if d.ExtractWorkers > 0 {
+		return d.ExtractWorkers
+	}
+	return DefaultExtractWorkers
+}
+
+// VerifyIntervalDuration returns how often live commits are verified; 0
+// means never
+func (d DeployConfig) VerifyIntervalDuration() time.Duration {
+	switch {
+	case d.VerifyInterval < 0:
+		return 0
+	case d.VerifyInterval == 0:
+		return DefaultVerifyInterval
+	}
+	return time.Duration(d.VerifyInterval) * time.Second
+}
+
+// BackendLimit returns how many backend deploys may run at once
+func (d DeployConfig) BackendLimit() int {
+	if d.MaxBackend > 0 {
+		return d.MaxBackend
+	}
+	return DefaultMaxBackendDeploy
+}
+
+// ApprovalTimeoutDuration returns how long a deploy waits for approval
+func (d DeployConfig) ApprovalTimeoutDuration() time.Duration {
+	if d.ApprovalTimeout > 0 {
+		return time.Duration(d.ApprovalTimeout) * time.Second
+	}
+	return DefaultApprovalTimeout
 }
 
 type NginxConfig struct {
@@ -39,53 +225,1675 @@ type NginxConfig struct {
 	SitesAvailable  string `toml:"sites_available"`
 	SitesEnabled    string `toml:"sites_enabled"`
 	OverrideConf    string `toml:"override_conf"`
+	// OverrideCookieTTL is how long (seconds) ?override= stays pinned via cookie.
+	// Default 3600; -1 disables the cookie so only the query arg works.
+	OverrideCookieTTL int `toml:"override_cookie_ttl,omitempty"`
+	// TemplateEnv lists environment variables user nginx templates may read with env
+	TemplateEnv []string `toml:"template_env,omitempty"`
+	// SnippetsDir holds the shared snippets user templates insert with snippet.
+	// Defaults to <state_dir>/snippets.
+	SnippetsDir string `toml:"snippets_dir,omitempty"`
+	// Policy restricts the directives site keys may use in nginx configs
+	Policy DirectivePolicyConfig `toml:"policy,omitempty"`
+	// GeoIPDatabase is a GeoIP2 or GeoLite2 country database (.mmdb). It
+	// enables [site.<name>.geo] and country codes in override_ips; nginx
+	// needs ngx_http_geoip2_module.
+	GeoIPDatabase string `toml:"geoip_database,omitempty"`
+	// GeoIPModule is the module's .so, for nginx builds that have it as a
+	// dynamic module. The generated nginx.conf loads it.
+	GeoIPModule string `toml:"geoip_module,omitempty"`
+	// CommandTimeout is how long (seconds) an nginx -t or reload may take
+	// before it is killed. Default 30.
+	CommandTimeout int `toml:"command_timeout,omitempty"`
+}
+
+// DefaultNginxCommandTimeout is used when nginx.command_timeout is not set
+const DefaultNginxCommandTimeout = 30 * time.Second
+
+// CommandTimeoutDuration returns how long an nginx command may run
+func (n NginxConfig) CommandTimeoutDuration() time.Duration {
+	if n.CommandTimeout > 0 {
+		return time.Duration(n.CommandTimeout) * time.Second
+	}
+	return DefaultNginxCommandTimeout
+}
+
+// Directive policy modes
+const (
+	PolicyReject = "reject" // refuse the deploy (default)
+	PolicyStrip  = "strip"  // remove the offending directives and deploy the rest
+	PolicyOff    = "off"    // no checks
+)
+
+// DirectivePolicyConfig is the [nginx.policy] section. It applies to nginx
+// configs deployed with a site key; admin keys are not restricted.
+type DirectivePolicyConfig struct {
+	Mode string `toml:"mode,omitempty"`
+	// Deny adds directive name globs to the built-in denylist
+	Deny []string `toml:"deny,omitempty"`
+	// Allow removes directive name globs from the denylist
+	Allow []string `toml:"allow,omitempty"`
+	// ProxyHosts lists extra host or host:port globs proxy_pass may target
+	ProxyHosts []string `toml:"proxy_hosts,omitempty"`
+}
+
+// PolicyMode returns the directive policy mode, defaulting to reject
+func (p DirectivePolicyConfig) PolicyMode() string {
+	if p.Mode == "" {
+		return PolicyReject
+	}
+	return p.Mode
+}
+
+// DefaultOverrideCookieTTL is used when nginx.override_cookie_ttl is not set
+const DefaultOverrideCookieTTL = 3600
+
+// OverrideCookieMaxAge returns the override cookie lifetime in seconds, or 0 if disabled
+func (n NginxConfig) OverrideCookieMaxAge() int {
+	switch {
+	case n.OverrideCookieTTL < 0:
+		return 0
+	case n.OverrideCookieTTL == 0:
+		return DefaultOverrideCookieTTL
+	}
+	return n.OverrideCookieTTL
 }
 
 type JailConfig struct {
+	// Driver is what backends run in: pot (default) jails on FreeBSD, or
+	// docker or podman containers on Linux. BinaryPath is the runtime's binary.
+	Driver         string `toml:"driver,omitempty"`
 	BinaryPath     string `toml:"binary_path"`
 	BaseDir        string `toml:"base_dir"`
 	JailConfPath   string `toml:"jail_conf_path"`
 	FreeBSDVersion string `toml:"freebsd_version"`
 	TarballCache   string `toml:"tarball_cache"`
 	IPBase         string `toml:"ip_base"`
+	// Image is the container image backends start from. Default debian:stable-slim.
+	Image string `toml:"image,omitempty"`
+	// Templates are golden pots that backends with a template are cloned from
+	Templates map[string]TemplateConfig `toml:"template,omitempty"`
+	// Init sets up DNS, the timezone and the locale in every jail
+	Init JailInitConfig `toml:"init,omitempty"`
+	// CommandTimeout is how long (seconds) a pot or container runtime
+	// command, such as creating, starting or copying into a jail, may take
+	// before it is killed. Default 300. Migrations have migrate_timeout instead.
+	CommandTimeout int `toml:"command_timeout,omitempty"`
+}
+
+// DefaultJailCommandTimeout is used when jail.command_timeout is not set
+const DefaultJailCommandTimeout = 300 * time.Second
+
+// CommandTimeoutDuration returns how long a jail command may run
+func (j JailConfig) CommandTimeoutDuration() time.Duration {
+	if j.CommandTimeout > 0 {
+		return time.Duration(j.CommandTimeout) * time.Second
+	}
+	return DefaultJailCommandTimeout
+}
+
+// JailInitConfig is what EnsureExists applies to each jail's root, so
+// backends see the host's DNS and local time rather than a fresh base's
+type JailInitConfig struct {
+	// ResolvConf is the host file copied to a pot's /etc/resolv.conf.
+	// Default /etc/resolv.conf; "none" leaves the pot's own. Containers get
+	// the runtime's copy of the host's unless it is set.
+	ResolvConf string `toml:"resolv_conf,omitempty"`
+	// Timezone is a zoneinfo name such as Australia/Perth, or "host" for the
+	// host's. Default: leave the jail on UTC.
+	Timezone string `toml:"timezone,omitempty"`
+	// Locale, such as en_AU.UTF-8, is the LANG backends start with
+	Locale string `toml:"locale,omitempty"`
+}
+
+// DefaultResolvConf is copied into pots when jail.init.resolv_conf is not set
+const DefaultResolvConf = "/etc/resolv.conf"
+
+// ResolvConfPath returns the host file copied to a pot's /etc/resolv.conf,
+// or "" for none
+func (i JailInitConfig) ResolvConfPath() string {
+	switch i.ResolvConf {
+	case "":
+		return DefaultResolvConf
+	case "none":
+		return ""
+	}
+	return i.ResolvConf
+}
+
+// ZoneinfoPath returns the host file a jail's /etc/localtime is copied
+// from, or "" to leave it alone
+func (i JailInitConfig) ZoneinfoPath() string {
+	switch i.Timezone {
+	case "":
+		return ""
+	case "host":
+		return "/etc/localtime"
+	}
+	return filepath.Join("/usr/share/zoneinfo", i.Timezone)
+}
+
+// timezoneRegex matches zoneinfo names
+var timezoneRegex = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+
+// localeRegex matches locale names such as en_AU.UTF-8 or de_DE@euro
+var localeRegex = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+
+// Jail drivers
+const (
+	DriverPot    = "pot"
+	DriverDocker = "docker"
+	DriverPodman = "podman"
+)
+
+// DefaultContainerImage is used when jail.image is not set
+const DefaultContainerImage = "debian:stable-slim"
+
+// DriverName returns the jail driver, defaulting to pot
+func (j JailConfig) DriverName() string {
+	if j.Driver == "" {
+		return DriverPot
+	}
+	return j.Driver
+}
+
+// UsesContainers reports whether backends run in Docker or Podman containers
+func (j JailConfig) UsesContainers() bool {
+	return j.Driver == DriverDocker || j.Driver == DriverPodman
+}
+
+// ContainerCmd returns the container runtime's binary
+func (j JailConfig) ContainerCmd() string {
+	if j.BinaryPath != "" {
+		return j.BinaryPath
+	}
+	return "/usr/bin/" + j.DriverName()
+}
+
+// ContainerImage returns the image backend containers start from
+func (j JailConfig) ContainerImage() string {
+	if j.Image != "" {
+		return j.Image
+	}
+	return DefaultContainerImage
+}
+
+// TemplateConfig declares a golden pot: the packages, users and pot
+// attributes every jail cloned from it starts with
+type TemplateConfig struct {
+	Packages []string `toml:"packages,omitempty"`
+	Users    []string `toml:"users,omitempty"`
+	// Attributes are set with pot set-attribute, e.g. sysvipc = "new" or
+	// mlock = "on", for the jail flags backends need
+	Attributes map[string]string `toml:"attributes,omitempty"`
+}
+
+type HealthConfig struct {
+	PollInterval     time.Duration `toml:"poll_interval"`
+	FailureThreshold int           `toml:"failure_threshold"`
+	HealthPath       string        `toml:"health_path"`
+	// StartupGrace is how long (seconds) a backend that has just started
+	// has to become ready before failed liveness checks restart it. Default
+	// 30; backends can set their own.
+	StartupGrace int `toml:"startup_grace,omitempty"`
+	// ReconcileInterval is how often (seconds) each backend's jail, process
+	// and port are checked and repaired. Default 60; -1 turns repairs off.
+	ReconcileInterval int `toml:"reconcile_interval,omitempty"`
+}
+
+// DefaultFailureThreshold is used when health.failure_threshold is not set
+const DefaultFailureThreshold = 3
+
+// FailureLimit returns how many failed probes in a row open an incident
+func (h HealthConfig) FailureLimit() int {
+	if h.FailureThreshold <= 0 {
+		return DefaultFailureThreshold
+	}
+	return h.FailureThreshold
+}
+
+// DefaultReconcileInterval is used when health.reconcile_interval is not set
+const DefaultReconcileInterval = time.Minute
+
+// ReconcileIntervalDuration returns how often backends are reconciled; 0
+// means never
+func (h HealthConfig) ReconcileIntervalDuration() time.Duration {
+	switch {
+	case h.ReconcileInterval < 0:
+		return 0
+	case h.ReconcileInterval == 0:
+		return DefaultReconcileInterval
+	}
+	return time.Duration(h.ReconcileInterval) * time.Second
+}
+
+type SelfConfig struct {
+	BinaryPath string `toml:"binary_path"`
+	PidFile    string `toml:"pid_file"`
+	ConfigDir  string `toml:"config_dir"`
+	// VerifyTimeout is how long (seconds) a freshly updated binary has to pass its
+	// own /health check before it is rolled back. Default 30.
+	VerifyTimeout int `toml:"verify_timeout,omitempty"`
+	// MinVersion refuses self-updates older than this version unless forced
+	MinVersion string `toml:"min_version,omitempty"`
+	// PinVersion refuses self-updates to any other version unless forced
+	PinVersion string `toml:"pin_version,omitempty"`
+	// StateDir holds shipyard's own runtime state. Default /var/db/shipyard.
+	StateDir string `toml:"state_dir,omitempty"`
+}
+
+// DefaultStateDir is used when self.state_dir is not set
+const DefaultStateDir = "/var/db/shipyard"
+
+// StateDir returns the directory for shipyard's runtime state
+func (c *Config) StateDir() string {
+	if c.Self.StateDir != "" {
+		return c.Self.StateDir
+	}
+	return DefaultStateDir
+}
+
+// SnippetsDir returns the directory holding shared nginx snippets
+func (c *Config) SnippetsDir() string {
+	if c.Nginx.SnippetsDir != "" {
+		return c.Nginx.SnippetsDir
+	}
+	return filepath.Join(c.StateDir(), "snippets")
+}
+
+// ArtifactsConfig controls deploys that fetch their artifact from artifact_url
+type ArtifactsConfig struct {
+	// AllowedHosts limits which hosts (or s3 buckets) artifact_url may name,
+	// and lets the hosts listed resolve to private addresses. Empty allows
+	// any public host.
+	AllowedHosts []string `toml:"allowed_hosts,omitempty"`
+	// DownloadTimeout is how long (seconds) a download may take. Default 300.
+	DownloadTimeout int `toml:"download_timeout,omitempty"`
+	// Keep is how many deployed artifacts are stored per site and kind for
+	// POST /deploy/redeploy. Default 5; -1 disables storage.
+	Keep int `toml:"keep,omitempty"`
+
+	// Frontend zips are checked against these before anything is extracted.
+	// MaxExtractedMB bounds the total uncompressed size (default 2048) and
+	// MaxCompressionRatio how far the zip may expand (default 100); -1 turns
+	// either off. DenyExtensions lists the file extensions refused, replacing
+	// DefaultDenyExtensions; ["none"] allows any. Symlinks are always refused.
+	MaxExtractedMB      int      `toml:"max_extracted_mb,omitempty"`
+	MaxCompressionRatio int      `toml:"max_compression_ratio,omitempty"`
+	DenyExtensions      []string `toml:"deny_extensions,omitempty"`
+
+	// Credentials for s3:// URLs. S3Endpoint defaults to AWS (https://s3.<region>.amazonaws.com);
+	// set it for MinIO, R2, etc. Buckets are addressed path-style.
+	S3Endpoint  string `toml:"s3_endpoint,omitempty"`
+	S3Region    string `toml:"s3_region,omitempty"`
+	S3AccessKey string `toml:"s3_access_key,omitempty"`
+	S3SecretKey string `toml:"s3_secret_key,omitempty"`
+}
+
+// DefaultArtifactDownloadTimeout is used when artifacts.download_timeout is not set
+const DefaultArtifactDownloadTimeout = 300 * time.Second
+
+// DefaultArtifactKeep is used when artifacts.keep is not set
+const DefaultArtifactKeep = 5
+
+// Frontend artifact policy defaults
+const (
+	DefaultMaxExtractedMB      = 2048
+	DefaultMaxCompressionRatio = 100
+)
+
+// DefaultDenyExtensions are the extensions refused in frontend zips unless
+// artifacts.deny_extensions is set: server-side scripts that have no place
+// in a static build
+var DefaultDenyExtensions = []string{".php", ".phtml", ".phar", ".cgi", ".fcgi", ".pl", ".asp", ".aspx", ".jsp"}
+
+// DenyExtensionsNone in artifacts.deny_extensions allows files of any extension
+const DenyExtensionsNone = "none"
+
+// KeepCount returns how many artifacts to store per site and kind; 0 means none
+func (a ArtifactsConfig) KeepCount() int {
+	switch {
+	case a.Keep < 0:
+		return 0
+	case a.Keep == 0:
+		return DefaultArtifactKeep
+	}
+	return a.Keep
+}
+
+// MaxExtractedBytes returns the largest total uncompressed size of a
+// frontend zip; 0 means no limit
+func (a ArtifactsConfig) MaxExtractedBytes() int64 {
+	switch {
+	case a.MaxExtractedMB < 0:
+		return 0
+	case a.MaxExtractedMB == 0:
+		return DefaultMaxExtractedMB << 20
+	}
+	return int64(a.MaxExtractedMB) << 20
+}
+
+// CompressionRatioLimit returns how many times its own size a frontend zip
+// may expand to; 0 means no limit
+func (a ArtifactsConfig) CompressionRatioLimit() int {
+	switch {
+	case a.MaxCompressionRatio < 0:
+		return 0
+	case a.MaxCompressionRatio == 0:
+		return DefaultMaxCompressionRatio
+	}
+	return a.MaxCompressionRatio
+}
+
+// DeniedExtension returns the extension of name if frontend zips may not
+// contain such files, or ""
+func (a ArtifactsConfig) DeniedExtension(name string) string {
+	deny := a.DenyExtensions
+	if deny == nil {
+		deny = DefaultDenyExtensions
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	for _, d := range deny {
+		if strings.ToLower(d) == ext {
+			return ext
+		}
+	}
+	return ""
+}
+
+// DownloadTimeoutDuration returns the artifact download timeout
+func (a ArtifactsConfig) DownloadTimeoutDuration() time.Duration {
+	if a.DownloadTimeout > 0 {
+		return time.Duration(a.DownloadTimeout) * time.Second
+	}
+	return DefaultArtifactDownloadTimeout
+}
+
+// SSLConfig holds global certificate and TLS settings.
+type SSLConfig struct {
+	// ACMEListenAddr enables shipyard's own HTTP-01 challenge listener (e.g. "127.0.0.1:8402").
+	// nginx forwards /.well-known/acme-challenge/ for unknown hosts to it.
+	ACMEListenAddr string    `toml:"acme_listen_addr,omitempty"`
+	TLS            TLSConfig `toml:"tls"`
+	// DNSPlugin is the certbot DNS plugin (e.g. "cloudflare", "route53") used for
+	// DNS-01 challenges. Wildcard sites with ssl_enabled need it.
+	DNSPlugin string `toml:"dns_plugin,omitempty"`
+	// DNSCredentials is the plugin's credentials file, if it takes one
+	DNSCredentials string `toml:"dns_credentials,omitempty"`
+	// DNSPropagationSeconds overrides how long certbot waits for DNS records to propagate
+	DNSPropagationSeconds int `toml:"dns_propagation_seconds,omitempty"`
+	// HostIPs are the public addresses sites' DNS should point at, checked
+	// before requesting a certificate. Defaults to the interface addresses.
+	HostIPs []string `toml:"host_ips,omitempty"`
+	// ACMEDirectory is the ACME server certificates come from: "production"
+	// (default) or "staging" for Let's Encrypt, or the directory URL of
+	// another CA such as step-ca
+	ACMEDirectory string `toml:"acme_directory,omitempty"`
+	// ACMECABundle is a PEM bundle certbot trusts when talking to an internal CA
+	ACMECABundle string `toml:"acme_ca_bundle,omitempty"`
+	// ACMEEmail is the ACME account's contact for expiry notices, unless a
+	// site sets its own. Empty registers without an email.
+	ACMEEmail string `toml:"acme_email,omitempty"`
+	// CertbotTimeout is how long (seconds) a certbot run may take before it
+	// is killed. Default 300.
+	CertbotTimeout int `toml:"certbot_timeout,omitempty"`
+}
+
+// DefaultCertbotTimeout is used when ssl.certbot_timeout is not set
+const DefaultCertbotTimeout = 300 * time.Second
+
+// CertbotTimeoutDuration returns how long a certbot run may take
+func (s SSLConfig) CertbotTimeoutDuration() time.Duration {
+	if s.CertbotTimeout > 0 {
+		return time.Duration(s.CertbotTimeout) * time.Second
+	}
+	return DefaultCertbotTimeout
+}
+
+// Let's Encrypt ACME directories
+const (
+	LetsEncryptProduction = "https://acme-v02.api.letsencrypt.org/directory"
+	LetsEncryptStaging    = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// ACMEServer returns the ACME directory URL certificates are requested from
+func (s SSLConfig) ACMEServer() string {
+	switch s.ACMEDirectory {
+	case "", "production":
+		return LetsEncryptProduction
+	case "staging":
+		return LetsEncryptStaging
+	}
+	return s.ACMEDirectory
+}
+
+// OIDCConfig lets admin endpoints accept bearer tokens from an OpenID Connect
+// issuer. Each token's groups map to the sites its holder may manage; static
+// admin keys keep working alongside it.
+type OIDCConfig struct {
+	Issuer string `toml:"issuer,omitempty"`
+	// Audience is the expected aud claim. Defaults to ClientID.
+	Audience string `toml:"audience,omitempty"`
+	// ClientID is the dashboard's client, published by GET /auth/config
+	ClientID string `toml:"client_id,omitempty"`
+	// JWKSURL overrides the signing key URL found by discovery
+	JWKSURL string `toml:"jwks_url,omitempty"`
+	// GroupsClaim names the claim listing the user's groups. Default "groups".
+	GroupsClaim string `toml:"groups_claim,omitempty"`
+	// Groups maps IdP groups to site globs. A group mapped to ["*"] is a full admin.
+	Groups map[string][]string `toml:"groups,omitempty"`
+}
+
+// Enabled reports whether bearer tokens are accepted
+func (o OIDCConfig) Enabled() bool {
+	return o.Issuer != ""
+}
+
+// ExpectedAudience returns the aud claim tokens must carry
+func (o OIDCConfig) ExpectedAudience() string {
+	if o.Audience != "" {
+		return o.Audience
+	}
+	return o.ClientID
+}
+
+// GroupsClaimName returns the claim listing the user's groups
+func (o OIDCConfig) GroupsClaimName() string {
+	if o.GroupsClaim != "" {
+		return o.GroupsClaim
+	}
+	return "groups"
+}
+
+// SiteGlobs returns the site globs granted to a user in groups. ok is false if
+// none of the groups is mapped; globs is nil if a group grants every site.
+func (o OIDCConfig) SiteGlobs(groups []string) (globs []string, ok bool) {
+	for _, group := range groups {
+		mapped, found := o.Groups[group]
+		if !found {
+			continue
+		}
+		if slices.Contains(mapped, "*") {
+			return nil, true
+		}
+		globs = append(globs, mapped...)
+		ok = true
+	}
+	return globs, ok
+}
+
+// NotifyConfig sends events such as backend crashes to an outside service
+type NotifyConfig struct {
+	// WebhookURL receives each event as a JSON POST. Events are always logged.
+	WebhookURL string `toml:"webhook_url,omitempty"`
+	// Timeout is how long (seconds) a webhook call may take. Default 10.
+	Timeout int `toml:"timeout,omitempty"`
+	// OwnerWebhooks also receive the events of the sites whose owner they
+	// are keyed by
+	OwnerWebhooks map[string]string `toml:"owner_webhooks,omitempty"`
+}
+
+// OwnerWebhook returns the webhook for a site owner's events, or ""
+func (n NotifyConfig) OwnerWebhook(owner string) string {
+	if owner == "" {
+		return ""
+	}
+	return n.OwnerWebhooks[owner]
+}
+
+// DefaultNotifyTimeout is used when notify.timeout is not set
+const DefaultNotifyTimeout = 10 * time.Second
+
+// TimeoutDuration returns the webhook timeout
+func (n NotifyConfig) TimeoutDuration() time.Duration {
+	if n.Timeout > 0 {
+		return time.Duration(n.Timeout) * time.Second
+	}
+	return DefaultNotifyTimeout
+}
+
+// SecretsConfig says where the values of backend env entries written as
+// secret://<name> come from
+type SecretsConfig struct {
+	// Provider is file (default), env or command
+	Provider string `toml:"provider,omitempty"`
+	// Dir holds one file per secret for the file provider. Default
+	// /usr/local/etc/shipyard/secrets.
+	Dir string `toml:"dir,omitempty"`
+	// EnvPrefix is put before a secret's name to find it in shipyard's own
+	// environment, for the env provider. Default SHIPYARD_SECRET_.
+	EnvPrefix string `toml:"env_prefix,omitempty"`
+	// Command is run with the secret's name as one more argument, for the
+	// command provider. Its output, less a trailing newline, is the value.
+	Command []string `toml:"command,omitempty"`
+}
+
+// Secret providers
+const (
+	SecretsFile    = "file"
+	SecretsEnv     = "env"
+	SecretsCommand = "command"
+)
+
+// Secret provider defaults
+const (
+	DefaultSecretsDir       = "/usr/local/etc/shipyard/secrets"
+	DefaultSecretsEnvPrefix = "SHIPYARD_SECRET_"
+)
+
+// SecretScheme starts a backend env value that names a secret
+const SecretScheme = "secret://"
+
+// ProviderName returns the secret provider, defaulting to file
+func (s SecretsConfig) ProviderName() string {
+	if s.Provider == "" {
+		return SecretsFile
+	}
+	return s.Provider
+}
+
+// SecretsDir returns the directory of the file provider
+func (s SecretsConfig) SecretsDir() string {
+	if s.Dir != "" {
+		return s.Dir
+	}
+	return DefaultSecretsDir
+}
+
+// Prefix returns the environment variable prefix of the env provider
+func (s SecretsConfig) Prefix() string {
+	if s.EnvPrefix != "" {
+		return s.EnvPrefix
+	}
+	return DefaultSecretsEnvPrefix
+}
+
+// secretNameRegex matches secret names: path-like, so the file provider can
+// keep them in subdirectories
+var secretNameRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*(/[A-Za-z0-9_][A-Za-z0-9_.-]*)*$`)
+
+// ValidSecretName reports whether name can be used in secret://<name>
+func ValidSecretName(name string) bool {
+	return secretNameRegex.MatchString(name) && !strings.Contains(name, "..")
+}
+
+// envNameRegex matches environment variable names
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateEnv checks a backend's env entries. PORT, HOST and SHIPYARD_*
+// are set by shipyard.
+func validateEnv(env map[string]string) error {
+	for name, value := range env {
+		if !envNameRegex.MatchString(name) || name == "PORT" || name == "HOST" || strings.HasPrefix(name, "SHIPYARD_") {
+			return fmt.Errorf("backend.env name %q must be a variable name other than PORT, HOST or SHIPYARD_*", name)
+		}
+		if strings.ContainsAny(value, "\n\x00") {
+			return fmt.Errorf("backend.env %s must be a single line", name)
+		}
+		if secret, ok := strings.CutPrefix(value, SecretScheme); ok && !ValidSecretName(secret) {
+			return fmt.Errorf("backend.env %s: %q is not a valid secret name", name, secret)
+		}
+	}
+	return nil
+}
+
+// BanConfig is the [ban] section. Shipyard reads nginx's requests from a log
+// of its own, and an IP that trips a rule is denied by every site for a
+// while.
+type BanConfig struct {
+	Enabled bool `toml:"enabled,omitempty"`
+	// Log is the access log nginx writes for shipyard to read. Default
+	// /var/log/nginx/shipyard-ban.log.
+	Log string `toml:"log,omitempty"`
+	// Include is the generated file of deny directives, included by the
+	// http block. Default bans.conf next to nginx.override_conf.
+	Include string `toml:"include,omitempty"`
+	// Duration is how long (seconds) a ban lasts unless its rule says
+	// otherwise. Default 3600.
+	Duration int `toml:"duration,omitempty"`
+	// Ignore lists IPs and CIDRs that are never banned, besides loopback
+	Ignore []string `toml:"ignore,omitempty"`
+	// Rules are the abuse patterns. Default DefaultBanRules.
+	Rules []BanRule `toml:"rule,omitempty"`
+}
+
+// BanRule bans an IP that makes more than Max matching requests within
+// Window seconds. Unset filters match every request.
+type BanRule struct {
+	Name     string   `toml:"name"`
+	Sites    []string `toml:"sites,omitempty"`   // site name globs
+	Status   []int    `toml:"status,omitempty"`  // response statuses
+	Paths    []string `toml:"paths,omitempty"`   // path prefixes
+	Methods  []string `toml:"methods,omitempty"` // e.g. "POST"
+	Max      int      `toml:"max"`
+	Window   int      `toml:"window"`
+	Duration int      `toml:"duration,omitempty"` // seconds; default ban.duration
+}
+
+// DefaultBanLog is used when ban.log is not set
+const DefaultBanLog = "/var/log/nginx/shipyard-ban.log"
+
+// DefaultBanDuration is used when ban.duration is not set
+const DefaultBanDuration = time.Hour
+
+// DefaultBanRules are used when [ban] has no rules: 404 floods, and brute
+// force against common login paths
+var DefaultBanRules = []BanRule{
+	{Name: "404_flood", Status: []int{404}, Max: 100, Window: 60},
+	{Name: "login_bruteforce", Paths: []string{"/wp-login.php", "/xmlrpc.php", "/login", "/api/login"}, Methods: []string{"POST"}, Status: []int{401, 403}, Max: 20, Window: 300},
+}
+
+// LogPath returns the access log shipyard reads
+func (b BanConfig) LogPath() string {
+	if b.Log != "" {
+		return b.Log
+	}
+	return DefaultBanLog
+}
+
+// IncludePath returns the deny file for an nginx config
+func (b BanConfig) IncludePath(n NginxConfig) string {
+	if b.Include != "" {
+		return b.Include
+	}
+	overrideConf := n.OverrideConf
+	if overrideConf == "" {
+		overrideConf = "/usr/local/etc/nginx/override.conf"
+	}
+	return filepath.Join(filepath.Dir(overrideConf), "bans.conf")
+}
+
+// BanRules returns the configured rules, or DefaultBanRules
+func (b BanConfig) BanRules() []BanRule {
+	if len(b.Rules) > 0 {
+		return b.Rules
+	}
+	return DefaultBanRules
+}
+
+// DurationFor returns how long a ban by rule lasts
+func (b BanConfig) DurationFor(rule BanRule) time.Duration {
+	switch {
+	case rule.Duration > 0:
+		return time.Duration(rule.Duration) * time.Second
+	case b.Duration > 0:
+		return time.Duration(b.Duration) * time.Second
+	}
+	return DefaultBanDuration
+}
+
+// validate checks the [ban] section
+func (b BanConfig) validate() error {
+	for _, p := range []string{b.Log, b.Include} {
+		if p != "" && !filepath.IsAbs(p) {
+			return fmt.Errorf("ban.log and ban.include must be absolute paths")
+		}
+	}
+	if b.Duration < 0 {
+		return fmt.Errorf("ban.duration must not be negative")
+	}
+	for _, entry := range b.Ignore {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return fmt.Errorf("ban.ignore entry %q is not an IP or CIDR", entry)
+		}
+	}
+	names := make(map[string]bool)
+	for _, rule := range b.Rules {
+		if rule.Name == "" || names[rule.Name] {
+			return fmt.Errorf("ban rules need unique names")
+		}
+		names[rule.Name] = true
+		if rule.Max < 1 || rule.Window < 1 || rule.Duration < 0 {
+			return fmt.Errorf("ban rule %q: max and window must be at least 1", rule.Name)
+		}
+		if err := validSiteGlobs(rule.Sites); err != nil {
+			return fmt.Errorf("ban rule %q: %w", rule.Name, err)
+		}
+		for _, p := range rule.Paths {
+			if !strings.HasPrefix(p, "/") {
+				return fmt.Errorf("ban rule %q: paths must start with /", rule.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// LogsConfig is the [logs] section: how long the searchable copy of
+// shipyard's log and backends' app.log is kept
+type LogsConfig struct {
+	// RetentionDays is how many days of logs are kept. Default
+	// DefaultLogRetentionDays; -1 turns the copy off.
+	RetentionDays int `toml:"retention_days,omitempty"`
+}
+
+// DefaultLogRetentionDays is used when logs.retention_days is not set
+const DefaultLogRetentionDays = 14
+
+// Enabled reports whether logs are copied for searching
+func (l LogsConfig) Enabled() bool {
+	return l.RetentionDays >= 0
+}
+
+// Retention returns how many days of logs are kept
+func (l LogsConfig) Retention() int {
+	if l.RetentionDays <= 0 {
+		return DefaultLogRetentionDays
+	}
+	return l.RetentionDays
+}
+
+// StatusPageConfig is the [status_page] section: a public page showing the
+// current health, uptime and recent outages of the chosen sites
+type StatusPageConfig struct {
+	// Title heads the page. Default DefaultStatusPageTitle.
+	Title string `toml:"title,omitempty"`
+	// Sites are shown on the page
+	Sites []string `toml:"sites,omitempty"`
+	// Tag shows every site with this tag as well
+	Tag string `toml:"tag,omitempty"`
+	// Site names a frontend-only site the page is published to: it is
+	// written under the site's frontend_root after every round of probes
+	Site string `toml:"site,omitempty"`
+}
+
+// DefaultStatusPageTitle is used when status_page.title is not set
+const DefaultStatusPageTitle = "Service Status"
+
+// Enabled reports whether any sites are shown on the status page
+func (p StatusPageConfig) Enabled() bool {
+	return len(p.Sites) > 0 || p.Tag != ""
+}
+
+// PageTitle returns the status page's title
+func (p StatusPageConfig) PageTitle() string {
+	if p.Title != "" {
+		return p.Title
+	}
+	return DefaultStatusPageTitle
+}
+
+// StatusPageSites returns the sites shown on the status page, sorted
+func (c *Config) StatusPageSites() []string {
+	var names []string
+	for name, site := range c.Site {
+		if slices.Contains(c.StatusPage.Sites, name) || (c.StatusPage.Tag != "" && site.HasTag(c.StatusPage.Tag)) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// validateStatusPage checks the [status_page] section
+func (c *Config) validateStatusPage() error {
+	p := c.StatusPage
+	for _, name := range p.Sites {
+		if _, ok := c.Site[name]; !ok {
+			return fmt.Errorf("status_page.sites: %q is not a configured site", name)
+		}
+	}
+	if p.Site == "" {
+		return nil
+	}
+	if !p.Enabled() {
+		return fmt.Errorf("status_page.site needs status_page.sites or status_page.tag")
+	}
+	site, ok := c.Site[p.Site]
+	switch {
+	case !ok:
+		return fmt.Errorf("status_page.site %q is not a configured site", p.Site)
+	case site.FrontendRoot == "" || site.Backend != nil || IsWildcardDomain(p.Site):
+		return fmt.Errorf("status_page.site %q must be a frontend-only site", p.Site)
+	}
+	return nil
+}
+
+// TLS policy names, following the Mozilla server-side TLS guidelines.
+const (
+	TLSPolicyModern       = "modern"
+	TLSPolicyIntermediate = "intermediate"
+	TLSPolicyOld          = "old"
+)
+
+// TLSConfig controls the TLS directives emitted into generated nginx configs.
+// It is set globally under [ssl.tls] and can be overridden per site under [site."x".tls].
+// Unset fields inherit the global value.
+type TLSConfig struct {
+	Policy         string `toml:"policy,omitempty"`          // modern, intermediate (default), old
+	Stapling       *bool  `toml:"stapling,omitempty"`        // OCSP stapling (default off)
+	SessionTickets *bool  `toml:"session_tickets,omitempty"` // TLS session tickets (default off)
+	DHParam        string `toml:"dhparam,omitempty"`         // path to a dhparam file (ignored for modern)
+	Resolver       string `toml:"resolver,omitempty"`        // DNS resolver used to reach OCSP responders
+}
+
+type SiteConfig struct {
+	FrontendRoot string         `toml:"frontend_root"`
+	APIKey       string         `toml:"api_key"`
+	OverrideIPs  []string       `toml:"override_ips"`
+	Aliases      []string       `toml:"aliases,omitempty"` // extra hostnames, available to nginx templates as .Aliases
+	Backend      *BackendConfig `toml:"backend"`
+	SSLEnabled   bool           `toml:"ssl_enabled"` // Enable HTTPS with auto-generated Let's Encrypt certs
+	TLS          *TLSConfig     `toml:"tls,omitempty"`
+	QuotaMB      int            `toml:"quota_mb,omitempty"` // disk quota for frontend commits + jail; 0 = unlimited
+	Canary       *CanaryConfig  `toml:"canary,omitempty"`   // managed by /deploy/frontend/canary
+
+	// Experiment splits clients between named variants for A/B tests. It is
+	// managed by /deploy/frontend/experiment.
+	Experiment *ExperimentConfig `toml:"experiment,omitempty"`
+
+	// Geo blocks or redirects clients by country (needs nginx.geoip_database)
+	Geo *GeoConfig `toml:"geo,omitempty"`
+
+	// RequireSignature refuses deploys without a valid X-Shipyard-Signature, so
+	// captured requests can't be replayed
+	RequireSignature bool `toml:"require_signature,omitempty"`
+
+	// ACMEEmail is the ACME account contact for this site's certificate,
+	// overriding ssl.acme_email
+	ACMEEmail string `toml:"acme_email,omitempty"`
+
+	// StagingOf makes this site the staging environment of another. That site
+	// then takes deploys only through /deploy/promote-env, which copies what
+	// was deployed here.
+	StagingOf string `toml:"staging_of,omitempty"`
+
+	// Freeze windows refuse deploys to the site while they are open, unless
+	// an admin forces them
+	Freeze []FreezeWindow `toml:"freeze,omitempty"`
+
+	// RequireApproval holds each deploy until a second admin approves it
+	// with POST /deploys/:id/approve
+	RequireApproval bool `toml:"require_approval,omitempty"`
+
+	// Generated frontend config options (ignored when a custom nginx_config is deployed)
+	SPAFallback   *bool      `toml:"spa_fallback,omitempty"`   // serve /index.html for unknown paths; default true
+	ErrorPage404  string     `toml:"error_page_404,omitempty"` // e.g. "/404.html", served from the build
+	ErrorPage50x  string     `toml:"error_page_50x,omitempty"` // e.g. "/50x.html", for 500/502/503/504
+	TrailingSlash string     `toml:"trailing_slash,omitempty"` // "add" or "remove" redirects; default leaves URLs alone
+	CSP           *CSPConfig `toml:"csp,omitempty"`            // Content-Security-Policy header
+	Precompress   []string   `toml:"precompress,omitempty"`    // "gzip" and/or "br": see Precompresses
+
+	// Robots is the robots.txt written into frontend builds that don't have one
+	Robots *RobotsConfig `toml:"robots,omitempty"`
+
+	// Tags, Owner and Description organise sites: GET /sites filters on tags
+	// and owner, and notifications carry them and go to the owner's webhook
+	Tags        []string `toml:"tags,omitempty"`
+	Owner       string   `toml:"owner,omitempty"`
+	Description string   `toml:"description,omitempty"`
+}
+
+// tagRegex matches site tags such as team-payments or env:prod
+var tagRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:/-]{0,63}$`)
+
+// ValidateMetadata checks a site's tags, owner and description
+func (s SiteConfig) ValidateMetadata() error {
+	for _, tag := range s.Tags {
+		if !tagRegex.MatchString(tag) {
+			return fmt.Errorf("tag %q must be up to 64 letters, digits and _.:/- starting with a letter or digit", tag)
+		}
+	}
+	if len(s.Owner) > 128 || strings.ContainsAny(s.Owner, ",\n\r") {
+		return fmt.Errorf("owner must be one line of up to 128 characters without commas")
+	}
+	if len(s.Description) > 1024 {
+		return fmt.Errorf("description must be at most 1024 characters")
+	}
+	return nil
+}
+
+// HasTag reports whether the site is tagged tag
+func (s SiteConfig) HasTag(tag string) bool {
+	return slices.Contains(s.Tags, tag)
+}
+
+// RobotsConfig is the robots.txt shipyard writes into a frontend build that
+// doesn't ship its own
+type RobotsConfig struct {
+	// DisallowAll asks crawlers to skip the whole site. It defaults to true for
+	// staging sites and wildcard sites, whose subdomains are previews.
+	DisallowAll *bool `toml:"disallow_all,omitempty"`
+	// Disallow lists paths crawlers should skip, e.g. "/admin/"
+	Disallow []string `toml:"disallow,omitempty"`
+	// Rules is robots.txt text used instead of the generated rules
+	Rules string `toml:"rules,omitempty"`
+	// Sitemap is announced in robots.txt: a URL, or a path on the site. By
+	// default a build's /sitemap.xml is announced if it has one; "none"
+	// announces nothing.
+	Sitemap string `toml:"sitemap,omitempty"`
+}
+
+// SitemapNone turns off the sitemap line of a generated robots.txt
+const SitemapNone = "none"
+
+// RobotsDisallowAll reports whether the generated robots.txt of the site
+// named name asks crawlers to skip everything
+func (s SiteConfig) RobotsDisallowAll(name string) bool {
+	if s.Robots != nil && s.Robots.DisallowAll != nil {
+		return *s.Robots.DisallowAll
+	}
+	return s.StagingOf != "" || IsWildcardDomain(name)
+}
+
+// CSPConfig is the Content-Security-Policy header a site's generated nginx
+// config sends
+type CSPConfig struct {
+	Policy string `toml:"policy"` // e.g. "default-src 'self'; img-src 'self' data:"
+	// ReportOnly sends Content-Security-Policy-Report-Only, so violations are
+	// reported but not blocked
+	ReportOnly bool `toml:"report_only,omitempty"`
+	// Report adds a report-uri that sends violations to shipyard, where they
+	// are aggregated for GET /site/csp-reports
+	Report bool `toml:"report,omitempty"`
+}
+
+// CSPReports reports whether the site sends its CSP violations to shipyard
+func (s SiteConfig) CSPReports() bool {
+	return s.CSP != nil && s.CSP.Report
+}
+
+// Encodings frontend builds can be pre-compressed to
+const (
+	EncodingGzip   = "gzip"
+	EncodingBrotli = "br"
+)
+
+// Precompresses reports whether deploys write a copy of the site's text
+// assets compressed with encoding (.gz for gzip, .br for br). The generated
+// config serves them with gzip_static and brotli_static; brotli_static needs
+// nginx built with ngx_brotli.
+func (s SiteConfig) Precompresses(encoding string) bool {
+	return slices.Contains(s.Precompress, encoding)
+}
+
+// Trailing slash modes
+const (
+	TrailingSlashAdd    = "add"
+	TrailingSlashRemove = "remove"
+)
+
+// SPA reports whether unknown paths fall back to /index.html
+func (s SiteConfig) SPA() bool {
+	return s.SPAFallback == nil || *s.SPAFallback
+}
+
+// CanaryConfig serves Commit to Percent of clients before it becomes latest
+type CanaryConfig struct {
+	Commit  string    `toml:"commit"`
+	Percent int       `toml:"percent"`
+	Started time.Time `toml:"started"`
+}
+
+// GeoConfig blocks or redirects a site's clients by the country of their
+// address. Countries are ISO 3166 codes such as "AU", or "--" for addresses
+// the database has no country for (private networks, localhost).
+type GeoConfig struct {
+	// Block answers 403 to clients from these countries
+	Block []string `toml:"block,omitempty"`
+	// Allow answers 403 to clients from any other country
+	Allow []string `toml:"allow,omitempty"`
+	// Redirect sends clients from a country to another URL (with the
+	// request's path appended), before Block or Allow apply
+	Redirect map[string]string `toml:"redirect,omitempty"`
+}
+
+// countryRegex matches an ISO 3166 alpha-2 country code, or "--" for none
+var countryRegex = regexp.MustCompile(`^([A-Z]{2}|--)$`)
+
+// IsCountryCode reports whether s is a country code as used by GeoConfig
+// and override_ips
+func IsCountryCode(s string) bool {
+	return countryRegex.MatchString(s)
+}
+
+// Validate checks the geo rules' countries and redirect URLs
+func (g GeoConfig) Validate() error {
+	if len(g.Block) > 0 && len(g.Allow) > 0 {
+		return fmt.Errorf("geo.block and geo.allow cannot both be set")
+	}
+	for _, country := range append(slices.Clone(g.Block), g.Allow...) {
+		if !IsCountryCode(country) {
+			return fmt.Errorf("geo: %q is not a country code like \"AU\"", country)
+		}
+	}
+	for country, target := range g.Redirect {
+		if !IsCountryCode(country) {
+			return fmt.Errorf("geo.redirect: %q is not a country code like \"AU\"", country)
+		}
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(target, "\" \t\n;{}$") {
+			return fmt.Errorf("geo.redirect.%s: %q must be an http(s) URL", country, target)
+		}
+	}
+	return nil
+}
+
+// ExperimentConfig splits a site's clients between named variants by weight.
+// A client keeps its variant (in a cookie named after the experiment) until
+// the variant's weight drops to 0 or the experiment ends.
+type ExperimentConfig struct {
+	Name     string                   `toml:"name"`
+	Variants map[string]VariantConfig `toml:"variants"`
+	Started  time.Time                `toml:"started"`
+}
+
+// VariantConfig is the version an experiment variant serves and its share
+type VariantConfig struct {
+	Commit string `toml:"commit"` // a deployed commit, or "latest"
+	Weight int    `toml:"weight"` // relative to the other variants' weights
+}
+
+// experimentNameRegex keeps experiment names usable in cookie names and
+// nginx variables
+var experimentNameRegex = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// variantNameRegex keeps variant names usable as cookie values and map keys
+var variantNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// experimentCommitRegex matches a variant's commit: a git hash or "latest"
+var experimentCommitRegex = regexp.MustCompile(`^([0-9a-f]{7,40}|latest)$`)
+
+// MaxVariants is the most variants an experiment may have
+const MaxVariants = 10
+
+// VariantNames returns the experiment's variant names, sorted
+func (e ExperimentConfig) VariantNames() []string {
+	names := make([]string, 0, len(e.Variants))
+	for name := range e.Variants {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TotalWeight returns the sum of the variants' weights
+func (e ExperimentConfig) TotalWeight() int {
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	return total
+}
+
+// Validate checks the experiment's name and variants
+func (e ExperimentConfig) Validate() error {
+	if !experimentNameRegex.MatchString(e.Name) {
+		return fmt.Errorf("experiment name %q must be 1-32 lowercase letters, digits or _", e.Name)
+	}
+	if len(e.Variants) < 2 || len(e.Variants) > MaxVariants {
+		return fmt.Errorf("experiment needs 2 to %d variants", MaxVariants)
+	}
+	for name, v := range e.Variants {
+		if !variantNameRegex.MatchString(name) {
+			return fmt.Errorf("variant name %q must be 1-32 lowercase letters, digits, _ or -", name)
+		}
+		if !experimentCommitRegex.MatchString(v.Commit) {
+			return fmt.Errorf("variant %q: commit must be a 7-40 char hex hash or \"latest\"", name)
+		}
+		if v.Weight < 0 || v.Weight > 10000 {
+			return fmt.Errorf("variant %q: weight must be between 0 and 10000", name)
+		}
+	}
+	if e.TotalWeight() == 0 {
+		return fmt.Errorf("experiment needs a variant with a weight above 0")
+	}
+	return nil
+}
+
+// FreezeWindow is a recurring period in which a site takes no deploys
+type FreezeWindow struct {
+	// Start is a cron expression (minute hour day month weekday) for when the
+	// window opens, e.g. "0 17 * * fri"
+	Start string `toml:"start"`
+	// Duration is how long the window stays open, e.g. "63h"
+	Duration time.Duration `toml:"duration"`
+	// Timezone is the IANA zone Start is read in. Default the host's.
+	Timezone string `toml:"timezone,omitempty"`
+	Reason   string `toml:"reason,omitempty"`
+}
+
+// MaxFreezeDuration bounds a freeze window, keeping the look-back for an
+// open window short
+const MaxFreezeDuration = 31 * 24 * time.Hour
+
+// Opened returns when the window containing t opened, if it is open at t
+func (w FreezeWindow) Opened(t time.Time) (time.Time, bool) {
+	sched, err := cron.Parse(w.Start)
+	if err != nil || w.Duration <= 0 {
+		return time.Time{}, false
+	}
+	loc := time.Local
+	if w.Timezone != "" {
+		if loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return time.Time{}, false
+		}
+	}
+	t = t.In(loc)
+	// Walk back a minute at a time to the latest start within Duration
+	minute := t.Truncate(time.Minute)
+	for start := minute; t.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+		if sched.Matches(start) {
+			return start, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Frozen returns the site's freeze window open at t and when it closes
+func (s SiteConfig) Frozen(t time.Time) (*FreezeWindow, time.Time, bool) {
+	for i, w := range s.Freeze {
+		if opened, ok := w.Opened(t); ok {
+			return &s.Freeze[i], opened.Add(w.Duration), true
+		}
+	}
+	return nil, time.Time{}, false
+}
+
+// QuotaBytes returns the site's disk quota in bytes, or 0 if unlimited
+func (s SiteConfig) QuotaBytes() int64 {
+	return int64(s.QuotaMB) << 20
+}
+
+// IsWildcardDomain reports whether a site name is a wildcard ("*.docs.example.com").
+// Wildcard sites serve each subdomain from its own directory under frontend_root.
+func IsWildcardDomain(name string) bool {
+	return strings.HasPrefix(name, "*.")
+}
+
+// subdomainLabelRegex matches a single DNS label
+var subdomainLabelRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidSubdomainLabel reports whether label can name a wildcard site's subdomain
+func ValidSubdomainLabel(label string) bool {
+	return subdomainLabelRegex.MatchString(label)
+}
+
+// SubdomainRoot returns the directory a wildcard site serves label.<domain> from.
+// It is laid out like a normal frontend_root, with commit directories and latest.
+func (s SiteConfig) SubdomainRoot(label string) string {
+	return filepath.Join(s.FrontendRoot, label)
+}
+
+// AssetsDirName is the directory under frontend_root holding files uploaded
+// with POST /site/assets
+const AssetsDirName = "_assets"
+
+// AssetsDir returns the directory nginx serves ad-hoc assets from, or "" for
+// backend-only sites
+func (s SiteConfig) AssetsDir() string {
+	if s.FrontendRoot == "" {
+		return ""
+	}
+	return filepath.Join(s.FrontendRoot, AssetsDirName)
+}
+
+// HasFrontend returns true if the site serves a frontend (has a frontend_root configured).
+func (s SiteConfig) HasFrontend() bool {
+	return s.FrontendRoot != ""
+}
+
+// IsBackendOnly returns true if the site is a backend-only service with no frontend.
+func (s SiteConfig) IsBackendOnly() bool {
+	return s.Backend != nil && s.FrontendRoot == ""
+}
+
+type BackendConfig struct {
+	JailName   string `toml:"jail_name"`
+	JailIP     string `toml:"jail_ip"`
+	ListenPort int    `toml:"listen_port"`
+	ProxyPath  string `toml:"proxy_path"`
+	BinaryName string `toml:"binary_name"`
+	// Arch is the architecture (GOARCH) whose binary is deployed from zips
+	// holding one per architecture. Default the host's.
+	Arch string `toml:"arch,omitempty"`
+	// ConfigDir is where the files of the artifact's config/ directory are
+	// copied inside the jail. Default /usr/local/etc/<binary_name>.
+	ConfigDir string `toml:"config_dir,omitempty"`
+	// Protocol is how nginx talks to the backend: http (default), grpc, fastcgi or uwsgi
+	Protocol string `toml:"protocol,omitempty"`
+	// ScriptRoot is the fastcgi script directory inside the jail. Default /usr/local/www.
+	ScriptRoot string `toml:"script_root,omitempty"`
+	// WebSocket adds upgrade headers and long timeouts to generated proxy configs
+	WebSocket bool `toml:"websocket,omitempty"`
+	// WebSocketTimeout is how long (seconds) an idle WebSocket stays open. Default 3600.
+	WebSocketTimeout int `toml:"websocket_timeout,omitempty"`
+	// Restart is what happens when the backend exits: always (default),
+	// on-failure (only after a non-zero exit) or never
+	Restart string `toml:"restart,omitempty"`
+	// RestartDelay is how long (seconds) to wait before restarting. Default 5.
+	RestartDelay int `toml:"restart_delay,omitempty"`
+	// MaxRestarts gives up after this many restarts within RestartWindow. 0 is unlimited.
+	MaxRestarts int `toml:"max_restarts,omitempty"`
+	// RestartWindow is the period (seconds) MaxRestarts counts over. Default 60.
+	RestartWindow int `toml:"restart_window,omitempty"`
+	// DrainTimeout is how long (seconds) a stopping backend has after SIGTERM
+	// to finish in-flight requests before it is killed. Default 10.
+	DrainTimeout int `toml:"drain_timeout,omitempty"`
+	// Env is extra environment for the backend. A value of secret://<name> is
+	// looked up with [secrets] each time shipyard starts the backend.
+	Env map[string]string `toml:"env,omitempty"`
+	// MigrateCommand is run with sh inside the jail, as run_as, after a deploy
+	// copies the new binary in and before it starts. If it fails, the deploy
+	// is aborted and the previous binary is started again.
+	MigrateCommand string `toml:"migrate_command,omitempty"`
+	// MigrateTimeout is how long (seconds) MigrateCommand may run. Default 300.
+	MigrateTimeout int `toml:"migrate_timeout,omitempty"`
+	// RestartResponse is what nginx answers for the backend while a deploy
+	// restarts it: proxy (default) keeps passing requests to the closed port,
+	// so clients get 502; 503 answers 503 with a Retry-After header
+	RestartResponse string `toml:"restart_response,omitempty"`
+	// HealthPath is the backend's health endpoint, checked by the monitor
+	// and after deploys. Default health.health_path.
+	HealthPath string `toml:"health_path,omitempty"`
+	// HealthStatus is the status a healthy backend answers with. Default 200.
+	HealthStatus int `toml:"health_status,omitempty"`
+	// HealthBody, when set, must appear in a healthy backend's response
+	HealthBody string `toml:"health_body,omitempty"`
+	// LivenessPath, when set, must answer 2xx or 3xx or the backend is
+	// restarted; otherwise it is live while its port accepts connections.
+	// Unlike the health check (readiness), failing it restarts the backend.
+	LivenessPath string `toml:"liveness_path,omitempty"`
+	// StartupGrace is how long (seconds) the backend has after starting to
+	// become ready before liveness applies. Default health.startup_grace.
+	StartupGrace int `toml:"startup_grace,omitempty"`
+	// DependsOn names sites whose backends must be ready before this one
+	// starts: the host starts them first at boot, and the reconciler and
+	// bulk actions start them in that order
+	DependsOn []string `toml:"depends_on,omitempty"`
+	// Mirror copies a share of the backend's requests to a candidate
+	// instance, for soak-testing a release with real traffic
+	Mirror *MirrorConfig `toml:"mirror,omitempty"`
+	// RunAs is the user the backend runs as inside the jail; it is created
+	// there when the jail is set up. Default root.
+	RunAs string `toml:"run_as,omitempty"`
+	// ReadOnly mounts the jail's root filesystem read-only while the backend runs
+	ReadOnly bool `toml:"read_only,omitempty"`
+	// Writable lists paths inside the jail that stay writable when ReadOnly is
+	// set, in addition to DefaultWritablePaths
+	Writable []string `toml:"writable,omitempty"`
+	// Volumes are host directories mounted into the jail whenever it starts.
+	// Their data survives destroying the site unless it is purged.
+	Volumes []VolumeConfig `toml:"volumes,omitempty"`
+	// Template names a [jail.template] the backend's pot is cloned from
+	Template string `toml:"template,omitempty"`
+	// Firewall restricts the backend's outbound connections to an allowlist.
+	// Outbound traffic is unrestricted when it is not set.
+	Firewall *FirewallConfig `toml:"firewall,omitempty"`
+}
+
+// VolumeConfig mounts a host directory into a backend's jail
+type VolumeConfig struct {
+	Host string `toml:"host"` // directory on the host, created if missing
+	Path string `toml:"path"` // mountpoint inside the jail
+	// ReadOnly mounts the volume read-only
+	ReadOnly bool `toml:"read_only,omitempty"`
+}
+
+// FirewallConfig is a backend's outbound allowlist. Each entry is "dns" for
+// the jail's resolvers, or a host, address or CIDR with an optional port:
+// "api.stripe.com:443", "10.0.0.0/8:5432", "[2001:db8::1]:443".
+type FirewallConfig struct {
+	Allow []string `toml:"allow"`
+}
+
+// OutboundRule is a parsed firewall allowlist entry
+type OutboundRule struct {
+	DNS  bool   // the jail's resolvers on port 53
+	Host string // hostname, address or CIDR; empty for DNS
+	Port int    // 0 allows every port
+}
+
+// outboundHostRegex keeps allowlist hosts to characters safe in a pf rule
+var outboundHostRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.:/_-]*$`)
+
+// Rules parses the allowlist
+func (f FirewallConfig) Rules() ([]OutboundRule, error) {
+	rules := make([]OutboundRule, 0, len(f.Allow))
+	for _, entry := range f.Allow {
+		if entry == "dns" {
+			rules = append(rules, OutboundRule{DNS: true})
+			continue
+		}
+		host, port := entry, 0
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			n, err := strconv.Atoi(p)
+			if err != nil || n < 1 || n > 65535 {
+				return nil, fmt.Errorf("firewall allow %q: invalid port", entry)
+			}
+			host, port = h, n
+		} else if strings.Count(entry, ":") == 1 {
+			return nil, fmt.Errorf("firewall allow %q: %w", entry, err)
+		}
+		if !outboundHostRegex.MatchString(host) {
+			return nil, fmt.Errorf("firewall allow %q: invalid host", entry)
+		}
+		if strings.Contains(host, "/") {
+			if _, _, err := net.ParseCIDR(host); err != nil {
+				return nil, fmt.Errorf("firewall allow %q: invalid CIDR", entry)
+			}
+		}
+		rules = append(rules, OutboundRule{Host: host, Port: port})
+	}
+	return rules, nil
+}
+
+// Backend protocols
+const (
+	ProtocolHTTP    = "http"
+	ProtocolGRPC    = "grpc"
+	ProtocolFastCGI = "fastcgi"
+	ProtocolUWSGI   = "uwsgi"
+)
+
+// DefaultScriptRoot is used when backend.script_root is not set
+const DefaultScriptRoot = "/usr/local/www"
+
+// BackendProtocol returns the backend's protocol, defaulting to http
+func (b BackendConfig) BackendProtocol() string {
+	if b.Protocol == "" {
+		return ProtocolHTTP
+	}
+	return b.Protocol
+}
+
+// FastCGIScriptRoot returns the fastcgi script directory inside the jail
+func (b BackendConfig) FastCGIScriptRoot() string {
+	if b.ScriptRoot != "" {
+		return b.ScriptRoot
+	}
+	return DefaultScriptRoot
+}
+
+// DefaultWebSocketTimeout is used when backend.websocket_timeout is not set
+const DefaultWebSocketTimeout = 3600
+
+// WebSocketTimeoutSeconds returns the idle timeout for proxied WebSockets
+func (b BackendConfig) WebSocketTimeoutSeconds() int {
+	if b.WebSocketTimeout > 0 {
+		return b.WebSocketTimeout
+	}
+	return DefaultWebSocketTimeout
+}
+
+// userNameRegex matches user names pw(8) accepts without quoting trouble
+var userNameRegex = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// templateNameRegex matches template names, which become part of pot names
+var templateNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// packageNameRegex matches pkg(8) package names and origins
+var packageNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.+/@-]*$`)
+
+// attributeRegex matches pot attribute names and values
+var attributeRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// validateTemplate checks a jail template's declaration
+func validateTemplate(name string, t TemplateConfig) error {
+	if !templateNameRegex.MatchString(name) {
+		return fmt.Errorf("jail.template %q: name must be lowercase letters, digits and hyphens", name)
+	}
+	for _, p := range t.Packages {
+		if !packageNameRegex.MatchString(p) {
+			return fmt.Errorf("jail.template %q: invalid package %q", name, p)
+		}
+	}
+	for _, u := range t.Users {
+		if !userNameRegex.MatchString(u) {
+			return fmt.Errorf("jail.template %q: invalid user name %q", name, u)
+		}
+	}
+	for k, v := range t.Attributes {
+		if !attributeRegex.MatchString(k) || !attributeRegex.MatchString(v) {
+			return fmt.Errorf("jail.template %q: invalid attribute %s = %q", name, k, v)
+		}
+	}
+	return nil
+}
+
+// runAsUIDBase offsets the UIDs given to run_as users. Jails share the host's
+// network stack, so the firewall tells backends apart by the UID that owns
+// each socket; deriving it from the jail IP keeps it unique per backend.
+const runAsUIDBase = 30000
+
+// RunAsUID returns the UID for the backend's run_as user, or 0 if the jail IP
+// doesn't give one
+func (b BackendConfig) RunAsUID() int {
+	n, err := strconv.Atoi(b.JailIP[strings.LastIndex(b.JailIP, ".")+1:])
+	if err != nil || n < 1 || n > 255 {
+		return 0
+	}
+	return runAsUIDBase + n
+}
+
+// ConfigPath returns the directory inside the jail the backend's config
+// files are copied to
+func (b BackendConfig) ConfigPath() string {
+	if b.ConfigDir != "" {
+		return path.Clean(b.ConfigDir)
+	}
+	return path.Join("/usr/local/etc", b.BinaryName)
+}
+
+// DefaultWritablePaths stay writable in a read-only jail: the backend's log,
+// exit record and core files, and scratch space
+var DefaultWritablePaths = []string{"/var/log", "/var/crash", "/tmp"}
+
+// WritablePaths returns the paths that stay writable in a read-only jail
+func (b BackendConfig) WritablePaths() []string {
+	paths := slices.Clone(DefaultWritablePaths)
+	for _, p := range b.Writable {
+		if p = path.Clean(p); !slices.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// validJailPath reports whether p is an absolute path other than / with no ".." elements
+func validJailPath(p string) bool {
+	return path.IsAbs(p) && path.Clean(p) != "/" && !strings.Contains(p, "..")
+}
+
+// Backend restart policies
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+)
+
+// Restart policy defaults
+const (
+	DefaultRestartDelay  = 5
+	DefaultRestartWindow = 60
+)
+
+// What nginx answers while a backend restarts
+const (
+	RestartResponseProxy = "proxy"
+	RestartResponse503   = "503"
+)
+
+// DefaultMigrateTimeout is how long (seconds) a backend's migrate_command may run
+const DefaultMigrateTimeout = 300
+
+// DefaultDrainTimeout is how long (seconds) a stopping backend has to exit
+// after SIGTERM
+const DefaultDrainTimeout = 10
+
+// RestartPolicy returns the backend's restart policy, defaulting to always
+func (b BackendConfig) RestartPolicy() string {
+	if b.Restart == "" {
+		return RestartAlways
+	}
+	return b.Restart
+}
+
+// RestartDelaySeconds returns how long to wait before restarting the backend
+func (b BackendConfig) RestartDelaySeconds() int {
+	if b.RestartDelay > 0 {
+		return b.RestartDelay
+	}
+	return DefaultRestartDelay
+}
+
+// RestartWindowSeconds returns the period max_restarts counts over
+func (b BackendConfig) RestartWindowSeconds() int {
+	if b.RestartWindow > 0 {
+		return b.RestartWindow
+	}
+	return DefaultRestartWindow
+}
+
+// MirrorConfig sends copies of a percentage of a backend's requests to
+// another instance. nginx discards the copies' responses.
+type MirrorConfig struct {
+	// Target is the candidate's IP:port, e.g. the staging site's backend at
+	// 127.0.0.1:<listen_port>
+	Target string `toml:"target"`
+	// Percent of requests copied, 1-100
+	Percent int `toml:"percent"`
+}
+
+// Mirrored reports whether the backend's requests are mirrored
+func (b BackendConfig) Mirrored() bool {
+	return b.Mirror != nil && b.Mirror.Percent > 0
+}
+
+// validateMirror checks a backend's mirror settings
+func validateMirror(b BackendConfig) error {
+	m := b.Mirror
+	if m == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(m.Target)
+	if err != nil || net.ParseIP(host) == nil {
+		return fmt.Errorf("backend.mirror.target %q must be an IP:port", m.Target)
+	}
+	if m.Percent < 1 || m.Percent > 100 {
+		return fmt.Errorf("backend.mirror.percent must be between 1 and 100")
+	}
+	if b.BackendProtocol() != ProtocolHTTP {
+		return fmt.Errorf("backend.mirror needs protocol http")
+	}
+	return nil
+}
+
+// UnavailableDuringRestart reports whether nginx answers 503 for the backend
+// while a deploy restarts it
+func (b BackendConfig) UnavailableDuringRestart() bool {
+	return b.RestartResponse == RestartResponse503
+}
+
+// DefaultStartupGrace is used when neither the backend nor [health] sets a
+// startup_grace
+const DefaultStartupGrace = 30 * time.Second
+
+// StartupGraceDuration returns how long the backend has after starting to
+// become ready before failed liveness checks restart it
+func (b BackendConfig) StartupGraceDuration(h HealthConfig) time.Duration {
+	switch {
+	case b.StartupGrace > 0:
+		return time.Duration(b.StartupGrace) * time.Second
+	case h.StartupGrace > 0:
+		return time.Duration(h.StartupGrace) * time.Second
+	}
+	return DefaultStartupGrace
+}
+
+// DefaultHealthPath is used when neither the backend nor [health] sets a
+// health_path
+const DefaultHealthPath = "/health"
+
+// HealthCheck is what a backend's health endpoint must answer
+type HealthCheck struct {
+	Path   string
+	Status int
+	Body   string // substring of the response; empty for any
 }
 
-type HealthConfig struct {
-	PollInterval     time.Duration `toml:"poll_interval"`
-	FailureThreshold int           `toml:"failure_threshold"`
-	HealthPath       string        `toml:"health_path"`
+// HealthCheck returns the backend's health check, falling back to
+// health.health_path
+func (b BackendConfig) HealthCheck(h HealthConfig) HealthCheck {
+	check := HealthCheck{Path: b.HealthPath, Status: b.HealthStatus, Body: b.HealthBody}
+	if check.Path == "" {
+		check.Path = h.HealthPath
+	}
+	if check.Path == "" {
+		check.Path = DefaultHealthPath
+	}
+	if check.Status == 0 {
+		check.Status = http.StatusOK
+	}
+	return check
 }
 
-type SelfConfig struct {
-	BinaryPath string `toml:"binary_path"`
-	PidFile    string `toml:"pid_file"`
-	ConfigDir  string `toml:"config_dir"`
+// validateHealthCheck checks a backend's health check, liveness_path and
+// startup_grace
+func validateHealthCheck(b BackendConfig) error {
+	if b.StartupGrace < 0 {
+		return fmt.Errorf("backend.startup_grace must not be negative")
+	}
+	if b.HealthPath == "" && b.HealthStatus == 0 && b.HealthBody == "" && b.LivenessPath == "" {
+		return nil
+	}
+	if b.BackendProtocol() != ProtocolHTTP {
+		return fmt.Errorf("backend.health_path, health_status, health_body and liveness_path need protocol http; other backends only need to accept connections")
+	}
+	for _, p := range []string{b.HealthPath, b.LivenessPath} {
+		if p != "" && (!strings.HasPrefix(p, "/") || strings.ContainsAny(p, " \t\r\n")) {
+			return fmt.Errorf("backend.health_path and liveness_path must be paths starting with /")
+		}
+	}
+	if b.HealthStatus != 0 && (b.HealthStatus < 100 || b.HealthStatus > 599) {
+		return fmt.Errorf("backend.health_status must be an HTTP status")
+	}
+	return nil
 }
 
-type SiteConfig struct {
-	FrontendRoot string         `toml:"frontend_root"`
-	APIKey       string         `toml:"api_key"`
-	OverrideIPs  []string       `toml:"override_ips"`
-	Backend      *BackendConfig `toml:"backend"`
-	SSLEnabled   bool           `toml:"ssl_enabled"` // Enable HTTPS with auto-generated Let's Encrypt certs
+// MigrateTimeoutSeconds returns how long the backend's migrate_command may run
+func (b BackendConfig) MigrateTimeoutSeconds() int {
+	if b.MigrateTimeout > 0 {
+		return b.MigrateTimeout
+	}
+	return DefaultMigrateTimeout
 }
 
-// HasFrontend returns true if the site serves a frontend (has a frontend_root configured).
-func (s SiteConfig) HasFrontend() bool {
-	return s.FrontendRoot != ""
+// DrainTimeoutSeconds returns how long a stopping backend has after SIGTERM
+// before it is killed
+func (b BackendConfig) DrainTimeoutSeconds() int {
+	if b.DrainTimeout > 0 {
+		return b.DrainTimeout
+	}
+	return DefaultDrainTimeout
 }
 
-// IsBackendOnly returns true if the site is a backend-only service with no frontend.
-func (s SiteConfig) IsBackendOnly() bool {
-	return s.Backend != nil && s.FrontendRoot == ""
+// archNames maps the names binaries are tagged with to their GOARCH
+var archNames = map[string]string{
+	"amd64": "amd64", "x86_64": "amd64", "x64": "amd64",
+	"arm64": "arm64", "aarch64": "arm64",
+	"386": "386", "i386": "386", "i686": "386",
+	"arm": "arm", "armv6": "arm", "armv7": "arm",
+	"riscv64": "riscv64",
+	"ppc64le": "ppc64le",
 }
 
-type BackendConfig struct {
-	JailName   string `toml:"jail_name"`
-	JailIP     string `toml:"jail_ip"`
-	ListenPort int    `toml:"listen_port"`
-	ProxyPath  string `toml:"proxy_path"`
-	BinaryName string `toml:"binary_name"`
+// ArchName returns the GOARCH a binary tagged name is built for, e.g. amd64
+// for x86_64, and whether name is an architecture at all
+func ArchName(name string) (string, bool) {
+	arch, ok := archNames[strings.ToLower(name)]
+	return arch, ok
+}
+
+// BinaryArch returns the architecture of the binary to deploy: arch, else
+// the host's
+func (b BackendConfig) BinaryArch() string {
+	if arch, ok := ArchName(b.Arch); ok {
+		return arch
+	}
+	return runtime.GOARCH
 }
 
 // Load reads and parses a TOML config file
@@ -101,6 +1909,12 @@ func Load(path string) (*Config, error) {
 	}
 
 	cfg.path = path
+	if err := cfg.loadIncludeDir(); err != nil {
+		return nil, err
+	}
+	if err := cfg.loadSecrets(SecretsPath(path)); err != nil {
+		return nil, err
+	}
 	return &cfg, cfg.Validate()
 }
 
@@ -125,6 +1939,89 @@ func (c *Config) GetSiteWithBackend(name string) (*SiteConfig, error) {
 	return site, nil
 }
 
+// TLSFor returns the effective TLS settings for a site: the site's [tls]
+// overrides layered on top of the global [ssl.tls] section.
+// Unknown sites get the global settings.
+func (c *Config) TLSFor(name string) TLSConfig {
+	tls := c.SSL.TLS
+	site, ok := c.Site[name]
+	if !ok || site.TLS == nil {
+		return tls
+	}
+	if site.TLS.Policy != "" {
+		tls.Policy = site.TLS.Policy
+	}
+	if site.TLS.Stapling != nil {
+		tls.Stapling = site.TLS.Stapling
+	}
+	if site.TLS.SessionTickets != nil {
+		tls.SessionTickets = site.TLS.SessionTickets
+	}
+	if site.TLS.DHParam != "" {
+		tls.DHParam = site.TLS.DHParam
+	}
+	if site.TLS.Resolver != "" {
+		tls.Resolver = site.TLS.Resolver
+	}
+	return tls
+}
+
+// StagingSite returns the site whose staging_of names site, or "" if it has
+// no staging site
+func (c *Config) StagingSite(site string) string {
+	for name, s := range c.Site {
+		if s.StagingOf == site {
+			return name
+		}
+	}
+	return ""
+}
+
+// KeyScoped reports whether an admin key is limited to some sites by key_acl
+func (c *Config) KeyScoped(key string) bool {
+	_, ok := c.KeyACL[key]
+	return ok
+}
+
+// KeyAllowsSite reports whether an admin key may manage site. Unscoped keys
+// may manage every site.
+func (c *Config) KeyAllowsSite(key, site string) bool {
+	globs, ok := c.KeyACL[key]
+	return !ok || MatchSiteGlobs(globs, site)
+}
+
+// MatchSiteGlobs reports whether site matches any of globs
+func MatchSiteGlobs(globs []string, site string) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, site); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// validSiteGlobs checks a key_acl or oidc.groups entry
+func validSiteGlobs(globs []string) error {
+	if len(globs) == 0 {
+		return fmt.Errorf("must list at least one site glob")
+	}
+	for _, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid site glob %q", glob)
+		}
+	}
+	return nil
+}
+
+// validTLSPolicy reports whether p is a known TLS policy name (empty means default)
+func validTLSPolicy(p string) bool {
+	switch p {
+	case "", TLSPolicyModern, TLSPolicyIntermediate, TLSPolicyOld:
+		return true
+	}
+	return false
+}
+
 // Validate checks that required fields are present and valid
 func (c *Config) Validate() error {
 	if c.Server.ListenAddr == "" {
@@ -133,15 +2030,138 @@ func (c *Config) Validate() error {
 	if len(c.AdminKeys) == 0 {
 		return fmt.Errorf("admin_keys must not be empty")
 	}
+	for key, globs := range c.KeyACL {
+		if !slices.Contains(c.AdminKeys, key) {
+			return fmt.Errorf("key_acl: key %s... is not in admin_keys", key[:min(len(key), 8)])
+		}
+		if err := validSiteGlobs(globs); err != nil {
+			return fmt.Errorf("key_acl: key %s...: %w", key[:min(len(key), 8)], err)
+		}
+	}
+	if c.OIDC.Enabled() {
+		if c.OIDC.ExpectedAudience() == "" {
+			return fmt.Errorf("oidc: audience or client_id is required")
+		}
+		if len(c.OIDC.Groups) == 0 {
+			return fmt.Errorf("oidc: map at least one group under [oidc.groups]")
+		}
+		for group, globs := range c.OIDC.Groups {
+			if err := validSiteGlobs(globs); err != nil {
+				return fmt.Errorf("oidc.groups %q: %w", group, err)
+			}
+		}
+	}
+	if tz := c.Jail.Init.Timezone; tz != "" && tz != "host" && !timezoneRegex.MatchString(tz) {
+		return fmt.Errorf("jail.init.timezone %q must be a zoneinfo name such as Europe/Berlin, or host", tz)
+	}
+	if l := c.Jail.Init.Locale; l != "" && !localeRegex.MatchString(l) {
+		return fmt.Errorf("jail.init.locale %q is not a locale name", l)
+	}
+	if r := c.Jail.Init.ResolvConf; r != "" && r != "none" && !filepath.IsAbs(r) {
+		return fmt.Errorf("jail.init.resolv_conf must be an absolute path or none")
+	}
+	switch c.Secrets.ProviderName() {
+	case SecretsFile, SecretsEnv:
+	case SecretsCommand:
+		if len(c.Secrets.Command) == 0 {
+			return fmt.Errorf("secrets.command is required by the command provider")
+		}
+	default:
+		return fmt.Errorf("secrets.provider %q must be one of file, env, command", c.Secrets.Provider)
+	}
+	if u := c.Notify.WebhookURL; u != "" && !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+		return fmt.Errorf("notify.webhook_url must be an http or https URL")
+	}
+	for owner, u := range c.Notify.OwnerWebhooks {
+		if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+			return fmt.Errorf("notify.owner_webhooks %q must be an http or https URL", owner)
+		}
+	}
+	switch c.Jail.DriverName() {
+	case DriverPot, DriverDocker, DriverPodman:
+	default:
+		return fmt.Errorf("jail.driver %q must be one of pot, docker, podman", c.Jail.Driver)
+	}
+	if c.Jail.UsesContainers() && len(c.Jail.Templates) > 0 {
+		return fmt.Errorf("jail.template needs the pot driver; bake packages into jail.image instead")
+	}
+	for name, t := range c.Jail.Templates {
+		if err := validateTemplate(name, t); err != nil {
+			return err
+		}
+	}
 	if c.Nginx.BinaryPath == "" || c.Nginx.MainConfPath == "" || c.Nginx.SitesAvailable == "" || c.Nginx.SitesEnabled == "" {
 		return fmt.Errorf("nginx config paths are required")
 	}
+	switch c.Nginx.Policy.PolicyMode() {
+	case PolicyReject, PolicyStrip, PolicyOff:
+	default:
+		return fmt.Errorf("nginx.policy.mode must be %q, %q or %q", PolicyReject, PolicyStrip, PolicyOff)
+	}
+	if err := c.Ban.validate(); err != nil {
+		return err
+	}
+	if c.Health.StartupGrace < 0 {
+		return fmt.Errorf("health.startup_grace must not be negative")
+	}
+	for _, p := range []string{c.Nginx.GeoIPDatabase, c.Nginx.GeoIPModule} {
+		if p != "" && !filepath.IsAbs(p) {
+			return fmt.Errorf("nginx.geoip_database and nginx.geoip_module must be absolute paths")
+		}
+	}
+	if c.Nginx.GeoIPModule != "" && c.Nginx.GeoIPDatabase == "" {
+		return fmt.Errorf("nginx.geoip_module needs nginx.geoip_database")
+	}
 	if c.Jail.BaseDir == "" || c.Jail.JailConfPath == "" {
 		return fmt.Errorf("jail config paths are required")
 	}
 	if len(c.Site) == 0 {
 		return fmt.Errorf("at least one site must be configured")
 	}
+	if c.Server.ClientCA != "" && (c.Server.TLSCert == "" || c.Server.TLSKey == "") {
+		return fmt.Errorf("server.client_ca requires server.tls_cert and server.tls_key")
+	}
+	if c.Server.MaxBodyMB < 0 || c.Server.MultipartMemoryMB < 0 {
+		return fmt.Errorf("server.max_body_mb and server.multipart_memory_mb must not be negative")
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 {
+		return fmt.Errorf("server timeouts must not be negative")
+	}
+	if c.Deploy.Workers < 0 || c.Deploy.MaxFrontend < 0 || c.Deploy.MaxBackend < 0 || c.Deploy.ExtractWorkers < 0 || c.Deploy.ApprovalTimeout < 0 {
+		return fmt.Errorf("deploy.workers, max_frontend, max_backend, extract_workers and approval_timeout must not be negative")
+	}
+	if c.Deploy.VerifyInterval < -1 {
+		return fmt.Errorf("deploy.verify_interval must be -1 (off) or more")
+	}
+	if c.Artifacts.Keep < -1 {
+		return fmt.Errorf("artifacts.keep must be -1 (disabled) or more")
+	}
+	if c.Artifacts.DownloadTimeout < 0 {
+		return fmt.Errorf("artifacts.download_timeout must not be negative")
+	}
+	if c.Artifacts.MaxExtractedMB < -1 || c.Artifacts.MaxCompressionRatio < -1 {
+		return fmt.Errorf("artifacts.max_extracted_mb and max_compression_ratio must be -1 (no limit) or more")
+	}
+	for _, ext := range c.Artifacts.DenyExtensions {
+		if ext == DenyExtensionsNone && len(c.Artifacts.DenyExtensions) == 1 {
+			continue
+		}
+		if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext[1:], "./\\ ") {
+			return fmt.Errorf("artifacts.deny_extensions entries must look like \".php\" (or be [%q] alone), got %q", DenyExtensionsNone, ext)
+		}
+	}
+	if (c.Artifacts.S3AccessKey == "") != (c.Artifacts.S3SecretKey == "") {
+		return fmt.Errorf("artifacts.s3_access_key and artifacts.s3_secret_key must be set together")
+	}
+	if !validTLSPolicy(c.SSL.TLS.Policy) {
+		return fmt.Errorf("ssl.tls.policy %q must be one of modern, intermediate, old", c.SSL.TLS.Policy)
+	}
+	if u, err := url.Parse(c.SSL.ACMEServer()); err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("ssl.acme_directory %q must be production, staging or an https:// directory URL", c.SSL.ACMEDirectory)
+	}
+	if c.SSL.ACMEEmail != "" && !strings.Contains(c.SSL.ACMEEmail, "@") {
+		return fmt.Errorf("ssl.acme_email %q is not an email address", c.SSL.ACMEEmail)
+	}
 	for domain, site := range c.Site {
 		if site.FrontendRoot == "" && site.Backend == nil {
 			return fmt.Errorf("site %q: frontend_root is required (or configure a backend for backend-only mode)", domain)
@@ -149,33 +2169,315 @@ func (c *Config) Validate() error {
 		if site.APIKey == "" {
 			return fmt.Errorf("site %q: api_key is required", domain)
 		}
+		if err := site.ValidateMetadata(); err != nil {
+			return fmt.Errorf("site %q: %w", domain, err)
+		}
+		if site.QuotaMB < 0 {
+			return fmt.Errorf("site %q: quota_mb must not be negative", domain)
+		}
+		if site.ACMEEmail != "" && !strings.Contains(site.ACMEEmail, "@") {
+			return fmt.Errorf("site %q: acme_email %q is not an email address", domain, site.ACMEEmail)
+		}
+		if site.Canary != nil && (site.Canary.Percent < 0 || site.Canary.Percent > 100) {
+			return fmt.Errorf("site %q: canary.percent must be between 0 and 100", domain)
+		}
+		for _, entry := range site.OverrideIPs {
+			if IsCountryCode(entry) {
+				if c.Nginx.GeoIPDatabase == "" {
+					return fmt.Errorf("site %q: country %s in override_ips needs nginx.geoip_database", domain, entry)
+				}
+				continue
+			}
+			if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+				return fmt.Errorf("site %q: override_ips entry %q is not an IP, CIDR or country code", domain, entry)
+			}
+		}
+		if site.Geo != nil {
+			if c.Nginx.GeoIPDatabase == "" {
+				return fmt.Errorf("site %q: geo needs nginx.geoip_database", domain)
+			}
+			if err := site.Geo.Validate(); err != nil {
+				return fmt.Errorf("site %q: %w", domain, err)
+			}
+		}
+		if site.Experiment != nil {
+			if err := site.Experiment.Validate(); err != nil {
+				return fmt.Errorf("site %q: %w", domain, err)
+			}
+			if site.Canary != nil {
+				return fmt.Errorf("site %q: a site cannot run a canary and an experiment at once", domain)
+			}
+		}
+		if site.Backend != nil {
+			switch site.Backend.BackendProtocol() {
+			case ProtocolHTTP, ProtocolGRPC, ProtocolFastCGI, ProtocolUWSGI:
+			default:
+				return fmt.Errorf("site %q: backend.protocol %q must be one of http, grpc, fastcgi, uwsgi", domain, site.Backend.Protocol)
+			}
+			switch site.Backend.RestartPolicy() {
+			case RestartAlways, RestartOnFailure, RestartNever:
+			default:
+				return fmt.Errorf("site %q: backend.restart %q must be one of always, on-failure, never", domain, site.Backend.Restart)
+			}
+			if site.Backend.RestartDelay < 0 || site.Backend.MaxRestarts < 0 || site.Backend.RestartWindow < 0 {
+				return fmt.Errorf("site %q: backend restart_delay, max_restarts and restart_window must not be negative", domain)
+			}
+			if site.Backend.DrainTimeout < 0 || site.Backend.MigrateTimeout < 0 {
+				return fmt.Errorf("site %q: backend drain_timeout and migrate_timeout must not be negative", domain)
+			}
+			if err := validateEnv(site.Backend.Env); err != nil {
+				return fmt.Errorf("site %q: %w", domain, err)
+			}
+			if err := validateHealthCheck(*site.Backend); err != nil {
+				return fmt.Errorf("site %q: %w", domain, err)
+			}
+			if err := validateMirror(*site.Backend); err != nil {
+				return fmt.Errorf("site %q: %w", domain, err)
+			}
+			if err := c.validateDependsOn(domain, *site.Backend); err != nil {
+				return fmt.Errorf("site %q: %w", domain, err)
+			}
+			switch site.Backend.RestartResponse {
+			case "", RestartResponseProxy, RestartResponse503:
+			default:
+				return fmt.Errorf("site %q: backend.restart_response %q must be one of proxy, 503", domain, site.Backend.RestartResponse)
+			}
+			if _, ok := ArchName(site.Backend.Arch); site.Backend.Arch != "" && !ok {
+				return fmt.Errorf("site %q: backend.arch %q is not an architecture such as amd64 or arm64", domain, site.Backend.Arch)
+			}
+			if site.Backend.RunAs != "" && !userNameRegex.MatchString(site.Backend.RunAs) {
+				return fmt.Errorf("site %q: backend.run_as %q is not a valid user name", domain, site.Backend.RunAs)
+			}
+			if site.Backend.ConfigDir != "" && !validJailPath(site.Backend.ConfigDir) {
+				return fmt.Errorf("site %q: backend.config_dir %q must be an absolute path below /", domain, site.Backend.ConfigDir)
+			}
+			for _, p := range site.Backend.Writable {
+				if !validJailPath(p) {
+					return fmt.Errorf("site %q: backend.writable %q must be an absolute path below /", domain, p)
+				}
+			}
+			mountpoints := make(map[string]bool)
+			for _, v := range site.Backend.Volumes {
+				if !validJailPath(v.Path) || !validJailPath(v.Host) {
+					return fmt.Errorf("site %q: backend volume host and path must be absolute paths below / (got %q -> %q)", domain, v.Host, v.Path)
+				}
+				if mountpoints[path.Clean(v.Path)] {
+					return fmt.Errorf("site %q: backend volume path %q is mounted twice", domain, v.Path)
+				}
+				mountpoints[path.Clean(v.Path)] = true
+			}
+			if t := site.Backend.Template; t != "" {
+				if _, ok := c.Jail.Templates[t]; !ok {
+					return fmt.Errorf("site %q: backend.template %q is not defined under [jail.template]", domain, t)
+				}
+			}
+			if fw := site.Backend.Firewall; fw != nil {
+				if c.Jail.UsesContainers() {
+					return fmt.Errorf("site %q: backend.firewall needs the pot driver", domain)
+				}
+				// Rules match the backend's user; root's would catch the host too
+				if site.Backend.RunAs == "" {
+					return fmt.Errorf("site %q: backend.firewall needs backend.run_as", domain)
+				}
+				if _, err := fw.Rules(); err != nil {
+					return fmt.Errorf("site %q: backend.%w", domain, err)
+				}
+			}
+		}
+		if IsWildcardDomain(domain) {
+			if site.FrontendRoot == "" || site.Backend != nil {
+				return fmt.Errorf("site %q: wildcard sites need a frontend_root and cannot have a backend", domain)
+			}
+			if site.Canary != nil || site.Experiment != nil {
+				return fmt.Errorf("site %q: wildcard sites cannot use canary rollouts or experiments", domain)
+			}
+			if site.SSLEnabled && c.SSL.DNSPlugin == "" {
+				return fmt.Errorf("site %q: wildcard certificates need ssl.dns_plugin for DNS-01 challenges", domain)
+			}
+		}
+		switch site.TrailingSlash {
+		case "", TrailingSlashAdd, TrailingSlashRemove:
+		default:
+			return fmt.Errorf("site %q: trailing_slash must be %q or %q", domain, TrailingSlashAdd, TrailingSlashRemove)
+		}
+		for _, page := range []string{site.ErrorPage404, site.ErrorPage50x} {
+			if page != "" && !strings.HasPrefix(page, "/") {
+				return fmt.Errorf("site %q: error pages must be paths starting with /, got %q", domain, page)
+			}
+		}
+		if site.CSP != nil {
+			if strings.TrimSpace(site.CSP.Policy) == "" {
+				return fmt.Errorf("site %q: csp.policy is required", domain)
+			}
+			if strings.ContainsAny(site.CSP.Policy, "\"\\\r\n") {
+				return fmt.Errorf("site %q: csp.policy must not contain quotes, backslashes or newlines", domain)
+			}
+		}
+		for _, enc := range site.Precompress {
+			if enc != EncodingGzip && enc != EncodingBrotli {
+				return fmt.Errorf("site %q: precompress entries must be %q or %q, got %q", domain, EncodingGzip, EncodingBrotli, enc)
+			}
+		}
+		if err := validateRobots(site.Robots); err != nil {
+			return fmt.Errorf("site %q: %w", domain, err)
+		}
+		if site.TLS != nil && !validTLSPolicy(site.TLS.Policy) {
+			return fmt.Errorf("site %q: tls.policy %q must be one of modern, intermediate, old", domain, site.TLS.Policy)
+		}
+		if err := c.validateStagingOf(domain, site); err != nil {
+			return fmt.Errorf("site %q: %w", domain, err)
+		}
+		if site.RequireApproval && c.Artifacts.KeepCount() == 0 {
+			return fmt.Errorf("site %q: require_approval needs artifact storage (artifacts.keep)", domain)
+		}
+		for _, w := range site.Freeze {
+			if _, err := cron.Parse(w.Start); err != nil {
+				return fmt.Errorf("site %q: freeze start: %w", domain, err)
+			}
+			if w.Duration <= 0 || w.Duration > MaxFreezeDuration {
+				return fmt.Errorf("site %q: freeze duration must be positive and at most %s", domain, MaxFreezeDuration)
+			}
+			if _, err := time.LoadLocation(w.Timezone); err != nil {
+				return fmt.Errorf("site %q: freeze timezone %q: %w", domain, w.Timezone, err)
+			}
+		}
+	}
+	if _, err := c.BackendOrder(); err != nil {
+		return err
+	}
+	if err := c.validateStatusPage(); err != nil {
+		return err
 	}
 	return nil
 }
 
-// Save writes the config back to disk
-func (c *Config) Save() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// validateDependsOn checks that a backend's depends_on names other sites
+// with backends
+func (c *Config) validateDependsOn(domain string, b BackendConfig) error {
+	for i, dep := range b.DependsOn {
+		other, ok := c.Site[dep]
+		switch {
+		case dep == domain:
+			return fmt.Errorf("backend.depends_on cannot name the site itself")
+		case !ok:
+			return fmt.Errorf("backend.depends_on %q is not a configured site", dep)
+		case other.Backend == nil:
+			return fmt.Errorf("backend.depends_on %q has no backend", dep)
+		case slices.Contains(b.DependsOn[:i], dep):
+			return fmt.Errorf("backend.depends_on names %q twice", dep)
+		}
+	}
+	return nil
+}
 
-	if c.path == "" {
-		return fmt.Errorf("config path not set")
+// BackendOrder returns the sites with backends ordered so each comes after
+// the backends it depends on, alphabetically where depends_on leaves the
+// order open. It fails if the dependencies form a cycle.
+func (c *Config) BackendOrder() ([]string, error) {
+	var pending []string
+	for name, site := range c.Site {
+		if site.Backend != nil {
+			pending = append(pending, name)
+		}
 	}
+	slices.Sort(pending)
 
-	f, err := os.Create(c.path)
-	if err != nil {
-		return fmt.Errorf("create config file: %w", err)
+	placed := make(map[string]bool, len(pending))
+	order := make([]string, 0, len(pending))
+	for len(pending) > 0 {
+		next := pending[:0]
+		for _, name := range pending {
+			ready := true
+			for _, dep := range c.Site[name].Backend.DependsOn {
+				if other, ok := c.Site[dep]; ok && other.Backend != nil && !placed[dep] {
+					ready = false
+				}
+			}
+			if ready {
+				order = append(order, name)
+				placed[name] = true
+			} else {
+				next = append(next, name)
+			}
+		}
+		if len(next) == len(pending) {
+			return nil, fmt.Errorf("backend.depends_on forms a cycle between %s", strings.Join(next, ", "))
+		}
+		pending = next
 	}
-	defer f.Close()
+	return order, nil
+}
 
-	encoder := toml.NewEncoder(f)
-	if err := encoder.Encode(c); err != nil {
-		return fmt.Errorf("encode config: %w", err)
+// validateRobots checks a site's robots section
+func validateRobots(r *RobotsConfig) error {
+	if r == nil {
+		return nil
 	}
+	if r.Rules != "" && len(r.Disallow) > 0 {
+		return fmt.Errorf("robots.rules and robots.disallow cannot both be set")
+	}
+	for _, p := range r.Disallow {
+		if !strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "*") {
+			return fmt.Errorf("robots.disallow paths must start with / or *, got %q", p)
+		}
+	}
+	switch {
+	case r.Sitemap == "", r.Sitemap == SitemapNone, strings.HasPrefix(r.Sitemap, "/"):
+	default:
+		if u, err := url.Parse(r.Sitemap); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("robots.sitemap must be %q, a path or an http(s) URL, got %q", SitemapNone, r.Sitemap)
+		}
+	}
+	return nil
+}
 
+// validateStagingOf checks a site's staging_of: it names another site,
+// which isn't a staging site itself and has no other
+func (c *Config) validateStagingOf(domain string, site SiteConfig) error {
+	if site.StagingOf == "" {
+		return nil
+	}
+	prod, ok := c.Site[site.StagingOf]
+	switch {
+	case site.StagingOf == domain:
+		return fmt.Errorf("staging_of cannot name the site itself")
+	case !ok:
+		return fmt.Errorf("staging_of %q is not a configured site", site.StagingOf)
+	case prod.StagingOf != "":
+		return fmt.Errorf("staging_of %q is itself a staging site", site.StagingOf)
+	case IsWildcardDomain(domain) || IsWildcardDomain(site.StagingOf):
+		return fmt.Errorf("wildcard sites cannot use staging_of")
+	}
+	for name, other := range c.Site {
+		if name != domain && other.StagingOf == site.StagingOf {
+			return fmt.Errorf("%q already has the staging site %q", site.StagingOf, name)
+		}
+	}
 	return nil
 }
 
+// Save writes the config back to disk
+func (c *Config) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.save()
+}
+
+// SetListenAddr makes the API listen on addr instead of server.listen_addr,
+// for this run only; the config file keeps its own value
+func (c *Config) SetListenAddr(addr string) {
+	c.listenAddr = addr
+}
+
+// ListenAddr returns the address the API listens on
+func (c *Config) ListenAddr() string {
+	if c.listenAddr != "" {
+		return c.listenAddr
+	}
+	return c.Server.ListenAddr
+}
+
 // AddSite adds a new site to the config and saves it
 func (c *Config) AddSite(name string, site SiteConfig) error {
 	c.mu.Lock()
@@ -189,24 +2491,15 @@ func (c *Config) AddSite(name string, site SiteConfig) error {
 		c.Site = make(map[string]SiteConfig)
 	}
 	c.Site[name] = site
-
-	// Save without lock (we already hold it)
-	if c.path == "" {
-		return fmt.Errorf("config path not set")
-	}
-
-	f, err := os.Create(c.path)
-	if err != nil {
-		return fmt.Errorf("create config file: %w", err)
-	}
-	defer f.Close()
-
-	encoder := toml.NewEncoder(f)
-	if err := encoder.Encode(c); err != nil {
-		return fmt.Errorf("encode config: %w", err)
+	if c.IncludeDir != "" {
+		if c.siteFiles == nil {
+			c.siteFiles = make(map[string]string)
+		}
+		c.siteFiles[name] = c.SiteFilePath(name)
 	}
 
-	return nil
+	// Save without lock (we already hold it)
+	return c.save()
 }
 
 // GenerateAPIKey generates a secure random API key with the given prefix
@@ -228,24 +2521,69 @@ func (c *Config) RemoveSite(name string) error {
 	}
 
 	delete(c.Site, name)
+	// Its staging site becomes an ordinary site
+	for other, site := range c.Site {
+		if site.StagingOf == name {
+			site.StagingOf = ""
+			c.Site[other] = site
+		}
+	}
+	if file, ok := c.siteFiles[name]; ok {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove site file: %w", err)
+		}
+		delete(c.siteFiles, name)
+		delete(c.siteStamps, file)
+	}
 
 	// Save without lock (we already hold it)
-	if c.path == "" {
-		return fmt.Errorf("config path not set")
+	return c.save()
+}
+
+// UpdateSite replaces an existing site's config and saves it
+func (c *Config) UpdateSite(name string, site SiteConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.Site[name]; !exists {
+		return fmt.Errorf("site %q does not exist", name)
 	}
+	c.Site[name] = site
 
-	f, err := os.Create(c.path)
-	if err != nil {
-		return fmt.Errorf("create config file: %w", err)
+	// Save without lock (we already hold it)
+	return c.save()
+}
+
+// SetCanary sets (or with nil, clears) a site's canary and saves the config
+func (c *Config) SetCanary(name string, canary *CanaryConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	site, exists := c.Site[name]
+	if !exists {
+		return fmt.Errorf("site %q does not exist", name)
 	}
-	defer f.Close()
+	site.Canary = canary
+	c.Site[name] = site
+
+	// Save without lock (we already hold it)
+	return c.save()
+}
+
+// SetExperiment sets (or with nil, clears) a site's experiment and saves the config
+func (c *Config) SetExperiment(name string, experiment *ExperimentConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	encoder := toml.NewEncoder(f)
-	if err := encoder.Encode(c); err != nil {
-		return fmt.Errorf("encode config: %w", err)
+	site, exists := c.Site[name]
+	if !exists {
+		return fmt.Errorf("site %q does not exist", name)
 	}
+	site.Experiment = experiment
+	c.Site[name] = site
 
-	return nil
+	// Save without lock (we already hold it)
+	return c.save()
 }
 
 // GetSiteByDomain finds a site by its domain name (domain is the key)
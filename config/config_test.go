@@ -3,7 +3,11 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestLoad_ValidConfig(t *testing.T) {
@@ -97,6 +101,59 @@ api_key = "sk-site-test-key"
 	}
 }
 
+func TestLoad_BackendVolumes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.toml")
+
+	content := `
+admin_keys = ["sk-test-admin-key"]
+
+[server]
+listen_addr = "0.0.0.0:8080"
+
+[nginx]
+binary_path = "/usr/sbin/nginx"
+main_conf_path = "/etc/nginx/nginx.conf"
+sites_available = "/etc/nginx/sites-available"
+sites_enabled = "/etc/nginx/sites-enabled"
+
+[jail]
+base_dir = "/var/jails"
+jail_conf_path = "/etc/jail.conf"
+
+[site."api.example.com"]
+api_key = "sk-site-test-key"
+
+[site."api.example.com".backend]
+listen_port = 8080
+
+[[site."api.example.com".backend.volumes]]
+host = "/data/api/db"
+path = "/var/db/api"
+
+[[site."api.example.com".backend.volumes]]
+host = "/data/shared/geoip"
+path = "/usr/local/share/geoip"
+read_only = true
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	volumes := cfg.Site["api.example.com"].Backend.Volumes
+	want := []VolumeConfig{
+		{Host: "/data/api/db", Path: "/var/db/api"},
+		{Host: "/data/shared/geoip", Path: "/usr/local/share/geoip", ReadOnly: true},
+	}
+	if !slices.Equal(volumes, want) {
+		t.Errorf("Volumes = %+v, want %+v", volumes, want)
+	}
+}
+
 func TestLoad_FileNotFound(t *testing.T) {
 	_, err := Load("/nonexistent/path/config.toml")
 	if err == nil {
@@ -324,3 +381,905 @@ func TestSiteConfig_IsBackendOnly(t *testing.T) {
 		})
 	}
 }
+
+func TestTLSFor_SiteOverridesGlobal(t *testing.T) {
+	on := true
+	cfg := &Config{
+		SSL: SSLConfig{TLS: TLSConfig{Policy: TLSPolicyIntermediate, DHParam: "/etc/ssl/dh.pem"}},
+		Site: map[string]SiteConfig{
+			"legacy.example.com": {TLS: &TLSConfig{Policy: TLSPolicyOld, Stapling: &on}},
+			"plain.example.com":  {},
+		},
+	}
+
+	tls := cfg.TLSFor("legacy.example.com")
+	if tls.Policy != TLSPolicyOld {
+		t.Errorf("Policy = %q, want %q", tls.Policy, TLSPolicyOld)
+	}
+	if tls.Stapling == nil || !*tls.Stapling {
+		t.Error("Stapling should be overridden to true")
+	}
+	if tls.DHParam != "/etc/ssl/dh.pem" {
+		t.Errorf("DHParam = %q, want inherited global value", tls.DHParam)
+	}
+
+	if got := cfg.TLSFor("plain.example.com").Policy; got != TLSPolicyIntermediate {
+		t.Errorf("Policy = %q, want global %q", got, TLSPolicyIntermediate)
+	}
+}
+
+func TestValidate_InvalidTLSPolicy(t *testing.T) {
+	cfg := &Config{
+		Server:    ServerConfig{ListenAddr: ":8080"},
+		AdminKeys: []string{"key"},
+		Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+		Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+		Site: map[string]SiteConfig{
+			"test.example.com": {FrontendRoot: "/f", APIKey: "k", TLS: &TLSConfig{Policy: "paranoid"}},
+		},
+	}
+
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject unknown tls policy")
+	}
+}
+
+func TestValidate_BackendRuntime(t *testing.T) {
+	for _, tt := range []struct {
+		backend BackendConfig
+		ok      bool
+	}{
+		{BackendConfig{}, true},
+		{BackendConfig{Restart: RestartOnFailure, RestartDelay: 1, MaxRestarts: 5, RestartWindow: 120}, true},
+		{BackendConfig{Restart: RestartNever}, true},
+		{BackendConfig{Restart: "sometimes"}, false},
+		{BackendConfig{MaxRestarts: -1}, false},
+		{BackendConfig{RunAs: "app"}, true},
+		{BackendConfig{RunAs: "app; rm -rf /"}, false},
+		{BackendConfig{ReadOnly: true, Writable: []string{"/var/db/app"}}, true},
+		{BackendConfig{ReadOnly: true, Writable: []string{"var/db"}}, false},
+		{BackendConfig{ReadOnly: true, Writable: []string{"/"}}, false},
+		{BackendConfig{ReadOnly: true, Writable: []string{"/var/../etc"}}, false},
+		{BackendConfig{Volumes: []VolumeConfig{{Host: "/data/api", Path: "/var/db/api"}}}, true},
+		{BackendConfig{Volumes: []VolumeConfig{{Host: "data/api", Path: "/var/db/api"}}}, false},
+		{BackendConfig{Volumes: []VolumeConfig{{Host: "/data/a", Path: "/srv"}, {Host: "/data/b", Path: "/srv/"}}}, false},
+		{BackendConfig{RunAs: "app", Firewall: &FirewallConfig{Allow: []string{"dns", "api.stripe.com:443"}}}, true},
+		{BackendConfig{RunAs: "app", Firewall: &FirewallConfig{}}, true},
+		{BackendConfig{Firewall: &FirewallConfig{Allow: []string{"dns"}}}, false},
+		{BackendConfig{RunAs: "app", Firewall: &FirewallConfig{Allow: []string{"db:99999"}}}, false},
+		{BackendConfig{RunAs: "app", Firewall: &FirewallConfig{Allow: []string{"any port 22"}}}, false},
+		{BackendConfig{Template: "go-runtime"}, true},
+		{BackendConfig{Template: "missing"}, false},
+	} {
+		backend := tt.backend
+		backend.ListenPort = 8080
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail: JailConfig{BaseDir: "/x", JailConfPath: "/x", Templates: map[string]TemplateConfig{
+				"go-runtime": {Packages: []string{"ca_root_nss"}},
+			}},
+			Site: map[string]SiteConfig{
+				"api.example.com": {APIKey: "k", Backend: &backend},
+			},
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v, want ok=%v", tt.backend, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_JailTemplates(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		template TemplateConfig
+		ok       bool
+	}{
+		{"go-runtime", TemplateConfig{Packages: []string{"ca_root_nss", "databases/sqlite3", "py311-pip"}, Users: []string{"app"}, Attributes: map[string]string{"sysvipc": "new", "mlock": "on"}}, true},
+		{"Go_Runtime", TemplateConfig{}, false},
+		{"base", TemplateConfig{Packages: []string{"curl; reboot"}}, false},
+		{"base", TemplateConfig{Users: []string{"Root"}}, false},
+		{"base", TemplateConfig{Attributes: map[string]string{"sysvipc": "new && reboot"}}, false},
+	} {
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x", Templates: map[string]TemplateConfig{tt.name: tt.template}},
+			Site:      map[string]SiteConfig{"test.example.com": {FrontendRoot: "/f", APIKey: "k"}},
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%s %+v) = %v, want ok=%v", tt.name, tt.template, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_JailDriver(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		jail JailConfig
+		ok   bool
+	}{
+		{"default pot", JailConfig{}, true},
+		{"docker", JailConfig{Driver: DriverDocker}, true},
+		{"podman with image", JailConfig{Driver: DriverPodman, Image: "alpine:3"}, true},
+		{"unknown driver", JailConfig{Driver: "lxc"}, false},
+		{"templates need pot", JailConfig{Driver: DriverDocker, Templates: map[string]TemplateConfig{"base": {}}}, false},
+	} {
+		tt.jail.BaseDir, tt.jail.JailConfPath = "/x", "/x"
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:      tt.jail,
+			Site:      map[string]SiteConfig{"test.example.com": {FrontendRoot: "/f", APIKey: "k"}},
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestDeployConfig_Limits(t *testing.T) {
+	var d DeployConfig
+	if d.WorkerCount() != DefaultDeployWorkers || d.FrontendLimit() != DefaultMaxFrontendDeploy || d.BackendLimit() != DefaultMaxBackendDeploy {
+		t.Errorf("zero DeployConfig limits = %d/%d/%d, want the defaults", d.WorkerCount(), d.FrontendLimit(), d.BackendLimit())
+	}
+	d = DeployConfig{Workers: 8, MaxFrontend: 1, MaxBackend: 3}
+	if d.WorkerCount() != 8 || d.FrontendLimit() != 1 || d.BackendLimit() != 3 {
+		t.Errorf("limits = %d/%d/%d, want 8/1/3", d.WorkerCount(), d.FrontendLimit(), d.BackendLimit())
+	}
+}
+
+func TestBackendConfig_RunAsUID(t *testing.T) {
+	for ip, want := range map[string]int{
+		"127.0.1.5":   30005,
+		"127.0.1.254": 30254,
+		"":            0,
+		"127.0.1.0":   0,
+		"127.0.1.x":   0,
+	} {
+		if got := (BackendConfig{JailIP: ip}).RunAsUID(); got != want {
+			t.Errorf("RunAsUID(%q) = %d, want %d", ip, got, want)
+		}
+	}
+}
+
+func TestFirewallConfig_Rules(t *testing.T) {
+	fw := FirewallConfig{Allow: []string{"dns", "api.stripe.com:443", "10.0.0.0/8:5432", "[2001:db8::1]:443", "2001:db8::/32", "192.0.2.7"}}
+	rules, err := fw.Rules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []OutboundRule{
+		{DNS: true},
+		{Host: "api.stripe.com", Port: 443},
+		{Host: "10.0.0.0/8", Port: 5432},
+		{Host: "2001:db8::1", Port: 443},
+		{Host: "2001:db8::/32"},
+		{Host: "192.0.2.7"},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("Rules() = %+v, want %+v", rules, want)
+	}
+
+	for _, entry := range []string{"host:", "host:http", "10.0.0.0/33", "{ any }", "[::1]", "-x"} {
+		if _, err := (FirewallConfig{Allow: []string{entry}}).Rules(); err == nil {
+			t.Errorf("Rules() accepted %q", entry)
+		}
+	}
+}
+
+func TestValidate_WildcardSite(t *testing.T) {
+	base := func(site SiteConfig) *Config {
+		return &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+			Site:      map[string]SiteConfig{"*.docs.example.com": site},
+		}
+	}
+
+	if err := base(SiteConfig{FrontendRoot: "/f", APIKey: "k"}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := base(SiteConfig{FrontendRoot: "/f", APIKey: "k", Backend: &BackendConfig{ListenPort: 8080}}).Validate(); err == nil {
+		t.Error("Validate() should reject a wildcard site with a backend")
+	}
+	cfg := base(SiteConfig{FrontendRoot: "/f", APIKey: "k", SSLEnabled: true})
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should require ssl.dns_plugin for a wildcard site with SSL")
+	}
+	cfg.SSL.DNSPlugin = "cloudflare"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestSiteConfig_SubdomainRoot(t *testing.T) {
+	site := SiteConfig{FrontendRoot: "/var/www/wildcard.docs.example.com"}
+	if got := site.SubdomainRoot("pr-42"); got != "/var/www/wildcard.docs.example.com/pr-42" {
+		t.Errorf("SubdomainRoot() = %q", got)
+	}
+	for label, want := range map[string]bool{"pr-42": true, "a": true, "main": true, "": false, "-a": false, "a-": false, "A": false, "a.b": false, "..": false} {
+		if got := ValidSubdomainLabel(label); got != want {
+			t.Errorf("ValidSubdomainLabel(%q) = %v, want %v", label, got, want)
+		}
+	}
+}
+
+func TestKeyAllowsSite(t *testing.T) {
+	cfg := &Config{
+		AdminKeys: []string{"sk-admin", "sk-team-a"},
+		KeyACL:    map[string][]string{"sk-team-a": {"*.team-a.example.com", "team-a.example.com"}},
+	}
+
+	tests := []struct {
+		key, site string
+		want      bool
+	}{
+		{"sk-admin", "anything.example.com", true},
+		{"sk-team-a", "team-a.example.com", true},
+		{"sk-team-a", "docs.team-a.example.com", true},
+		{"sk-team-a", "*.team-a.example.com", true},
+		{"sk-team-a", "prod.team-b.example.com", false},
+		{"sk-team-a", "team-a.example.com.evil.com", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.KeyAllowsSite(tt.key, tt.site); got != tt.want {
+			t.Errorf("KeyAllowsSite(%q, %q) = %v, want %v", tt.key, tt.site, got, tt.want)
+		}
+	}
+	if cfg.KeyScoped("sk-admin") || !cfg.KeyScoped("sk-team-a") {
+		t.Error("KeyScoped() should only report keys listed in key_acl")
+	}
+}
+
+func TestOIDCConfig_SiteGlobs(t *testing.T) {
+	o := OIDCConfig{Groups: map[string][]string{
+		"platform": {"*"},
+		"team-a":   {"*.team-a.example.com"},
+		"team-b":   {"*.team-b.example.com"},
+	}}
+
+	if globs, ok := o.SiteGlobs([]string{"team-a", "platform"}); !ok || globs != nil {
+		t.Errorf("platform should grant every site, got %v, %v", globs, ok)
+	}
+	if globs, ok := o.SiteGlobs([]string{"team-a", "team-b", "other"}); !ok || len(globs) != 2 {
+		t.Errorf("team-a + team-b globs = %v, %v", globs, ok)
+	}
+	if _, ok := o.SiteGlobs([]string{"other"}); ok {
+		t.Error("unmapped groups should grant nothing")
+	}
+	if o.GroupsClaimName() != "groups" {
+		t.Errorf("GroupsClaimName() = %q", o.GroupsClaimName())
+	}
+}
+
+func TestValidate_KeyACL(t *testing.T) {
+	cfg := &Config{
+		Server:    ServerConfig{ListenAddr: ":8080"},
+		AdminKeys: []string{"sk-admin"},
+		KeyACL:    map[string][]string{"sk-unknown": {"*.example.com"}},
+		Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+		Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+		Site:      map[string]SiteConfig{"test.example.com": {FrontendRoot: "/f", APIKey: "k"}},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject key_acl entries for unknown keys")
+	}
+
+	cfg.KeyACL = map[string][]string{"sk-admin": {"[bad"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject invalid globs")
+	}
+
+	cfg.KeyACL = map[string][]string{"sk-admin": {"*.example.com"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestServerConfig_Limits(t *testing.T) {
+	var s ServerConfig
+	if got := s.BodyLimit(); got != 500<<20 {
+		t.Errorf("default BodyLimit = %d, want %d", got, 500<<20)
+	}
+	if got := s.MultipartMemory(); got != 32<<20 {
+		t.Errorf("default MultipartMemory = %d, want %d", got, 32<<20)
+	}
+
+	s = ServerConfig{MaxBodyMB: 2048, MultipartMemoryMB: 8}
+	if got := s.BodyLimit(); got != 2048<<20 {
+		t.Errorf("BodyLimit = %d, want %d", got, 2048<<20)
+	}
+	if got := s.MultipartMemory(); got != 8<<20 {
+		t.Errorf("MultipartMemory = %d, want %d", got, 8<<20)
+	}
+}
+
+func TestValidate_ClientCARequiresTLS(t *testing.T) {
+	cfg := &Config{
+		Server:    ServerConfig{ListenAddr: ":8080", ClientCA: "/usr/local/etc/shipyard/clients.pem"},
+		AdminKeys: []string{"key"},
+		Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+		Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+		Site: map[string]SiteConfig{
+			"test.example.com": {FrontendRoot: "/f", APIKey: "k"},
+		},
+	}
+
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for client_ca without tls_cert/tls_key")
+	}
+}
+
+func TestValidate_ACMEDirectory(t *testing.T) {
+	for _, tt := range []struct {
+		ssl  SSLConfig
+		want string // "" if invalid
+	}{
+		{SSLConfig{}, LetsEncryptProduction},
+		{SSLConfig{ACMEDirectory: "staging"}, LetsEncryptStaging},
+		{SSLConfig{ACMEDirectory: "https://ca.internal:9000/acme/acme/directory", ACMEEmail: "ops@example.com"}, "https://ca.internal:9000/acme/acme/directory"},
+		{SSLConfig{ACMEDirectory: "http://ca.internal/directory"}, ""},
+		{SSLConfig{ACMEDirectory: "stage"}, ""},
+		{SSLConfig{ACMEEmail: "ops"}, ""},
+	} {
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+			SSL:       tt.ssl,
+			Site:      map[string]SiteConfig{"test.example.com": {FrontendRoot: "/f", APIKey: "k"}},
+		}
+		err := cfg.Validate()
+		if (err == nil) != (tt.want != "") {
+			t.Errorf("%+v: Validate = %v, want ok=%v", tt.ssl, err, tt.want != "")
+		}
+		if tt.want != "" && tt.ssl.ACMEServer() != tt.want {
+			t.Errorf("%+v: ACMEServer = %q, want %q", tt.ssl, tt.ssl.ACMEServer(), tt.want)
+		}
+	}
+}
+
+func TestListenAddr_Override(t *testing.T) {
+	cfg := &Config{Server: ServerConfig{ListenAddr: "127.0.0.1:8443"}}
+	if cfg.ListenAddr() != "127.0.0.1:8443" {
+		t.Errorf("ListenAddr = %q, want server.listen_addr", cfg.ListenAddr())
+	}
+	cfg.SetListenAddr("127.0.0.1:9443")
+	if cfg.ListenAddr() != "127.0.0.1:9443" || cfg.Server.ListenAddr != "127.0.0.1:8443" {
+		t.Errorf("after override ListenAddr = %q, server.listen_addr = %q", cfg.ListenAddr(), cfg.Server.ListenAddr)
+	}
+}
+
+func TestValidate_StagingOf(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		sites map[string]SiteConfig
+		ok    bool
+	}{
+		{"staging site", map[string]SiteConfig{
+			"example.com":         {FrontendRoot: "/f", APIKey: "k"},
+			"staging.example.com": {FrontendRoot: "/s", APIKey: "k", StagingOf: "example.com"},
+		}, true},
+		{"unknown site", map[string]SiteConfig{
+			"staging.example.com": {FrontendRoot: "/s", APIKey: "k", StagingOf: "example.com"},
+		}, false},
+		{"itself", map[string]SiteConfig{
+			"example.com": {FrontendRoot: "/f", APIKey: "k", StagingOf: "example.com"},
+		}, false},
+		{"chain", map[string]SiteConfig{
+			"example.com":         {FrontendRoot: "/f", APIKey: "k"},
+			"staging.example.com": {FrontendRoot: "/s", APIKey: "k", StagingOf: "example.com"},
+			"dev.example.com":     {FrontendRoot: "/d", APIKey: "k", StagingOf: "staging.example.com"},
+		}, false},
+		{"two staging sites", map[string]SiteConfig{
+			"example.com":         {FrontendRoot: "/f", APIKey: "k"},
+			"staging.example.com": {FrontendRoot: "/s", APIKey: "k", StagingOf: "example.com"},
+			"qa.example.com":      {FrontendRoot: "/q", APIKey: "k", StagingOf: "example.com"},
+		}, false},
+	} {
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+			Site:      tt.sites,
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+
+	cfg := &Config{Site: map[string]SiteConfig{
+		"example.com":         {},
+		"staging.example.com": {StagingOf: "example.com"},
+	}}
+	if got := cfg.StagingSite("example.com"); got != "staging.example.com" {
+		t.Errorf("StagingSite = %q, want staging.example.com", got)
+	}
+	if got := cfg.StagingSite("staging.example.com"); got != "" {
+		t.Errorf("StagingSite of the staging site = %q, want none", got)
+	}
+}
+
+func TestValidate_StatusPage(t *testing.T) {
+	sites := map[string]SiteConfig{
+		"example.com":        {FrontendRoot: "/f", APIKey: "k"},
+		"status.example.com": {FrontendRoot: "/s", APIKey: "k"},
+		"api.example.com":    {APIKey: "k", Backend: &BackendConfig{BinaryName: "api", JailIP: "10.0.0.2", ListenPort: 8080}},
+	}
+	for _, tt := range []struct {
+		name string
+		page StatusPageConfig
+		ok   bool
+	}{
+		{"off", StatusPageConfig{}, true},
+		{"sites", StatusPageConfig{Sites: []string{"example.com", "api.example.com"}, Site: "status.example.com"}, true},
+		{"tag", StatusPageConfig{Tag: "public"}, true},
+		{"unknown site", StatusPageConfig{Sites: []string{"missing.example.com"}}, false},
+		{"published without sites", StatusPageConfig{Site: "status.example.com"}, false},
+		{"published to a backend", StatusPageConfig{Sites: []string{"example.com"}, Site: "api.example.com"}, false},
+		{"published to an unknown site", StatusPageConfig{Sites: []string{"example.com"}, Site: "missing.example.com"}, false},
+	} {
+		cfg := &Config{
+			Server:     ServerConfig{ListenAddr: ":8080"},
+			AdminKeys:  []string{"key"},
+			Nginx:      NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:       JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+			StatusPage: tt.page,
+			Site:       sites,
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_Robots(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		robots *RobotsConfig
+		ok     bool
+	}{
+		{"paths and sitemap URL", &RobotsConfig{Disallow: []string{"/admin/", "*.pdf"}, Sitemap: "https://cdn.example.com/sitemap.xml"}, true},
+		{"sitemap path", &RobotsConfig{Rules: "User-agent: *\nAllow: /", Sitemap: "/sitemap-index.xml"}, true},
+		{"rules and disallow", &RobotsConfig{Rules: "User-agent: *", Disallow: []string{"/admin/"}}, false},
+		{"relative path", &RobotsConfig{Disallow: []string{"admin/"}}, false},
+		{"bad sitemap", &RobotsConfig{Sitemap: "ftp://example.com/sitemap.xml"}, false},
+	} {
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+			Site:      map[string]SiteConfig{"example.com": {FrontendRoot: "/f", APIKey: "k", Robots: tt.robots}},
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_ArtifactPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		artifacts ArtifactsConfig
+		ok        bool
+	}{
+		{"defaults", ArtifactsConfig{}, true},
+		{"limits off", ArtifactsConfig{MaxExtractedMB: -1, MaxCompressionRatio: -1, DenyExtensions: []string{"none"}}, true},
+		{"own denylist", ArtifactsConfig{DenyExtensions: []string{".php", ".sh"}}, true},
+		{"negative size", ArtifactsConfig{MaxExtractedMB: -2}, false},
+		{"extension without dot", ArtifactsConfig{DenyExtensions: []string{"php"}}, false},
+		{"none with others", ArtifactsConfig{DenyExtensions: []string{"none", ".php"}}, false},
+	} {
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+			Artifacts: tt.artifacts,
+			Site:      map[string]SiteConfig{"example.com": {FrontendRoot: "/f", APIKey: "k"}},
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+
+	a := ArtifactsConfig{}
+	if a.DeniedExtension("cgi-bin/form.CGI") != ".cgi" || a.DeniedExtension("assets/app.js") != "" {
+		t.Error("default denylist should match .cgi case-insensitively and allow .js")
+	}
+}
+
+func TestValidate_BackendEnv(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		env     map[string]string
+		secrets SecretsConfig
+		ok      bool
+	}{
+		{"plain and secret", map[string]string{"LOG_LEVEL": "info", "DB_PASSWORD": "secret://app/db"}, SecretsConfig{}, true},
+		{"reserved name", map[string]string{"PORT": "9000"}, SecretsConfig{}, false},
+		{"shipyard prefix", map[string]string{"SHIPYARD_LOG": "/x"}, SecretsConfig{}, false},
+		{"bad name", map[string]string{"1X": "y"}, SecretsConfig{}, false},
+		{"multi-line value", map[string]string{"X": "a\nb"}, SecretsConfig{}, false},
+		{"secret leaving dir", map[string]string{"X": "secret://../x"}, SecretsConfig{}, false},
+		{"unknown provider", nil, SecretsConfig{Provider: "vault"}, false},
+		{"command without argv", nil, SecretsConfig{Provider: SecretsCommand}, false},
+		{"command", nil, SecretsConfig{Provider: SecretsCommand, Command: []string{"/usr/local/bin/get-secret"}}, true},
+	} {
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+			Secrets:   tt.secrets,
+			Site: map[string]SiteConfig{"example.com": {APIKey: "k", Backend: &BackendConfig{
+				BinaryName: "app", JailIP: "10.0.0.2", ListenPort: 8080, Env: tt.env,
+			}}},
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_DependsOn(t *testing.T) {
+	backend := func(ip string, deps ...string) *BackendConfig {
+		return &BackendConfig{BinaryName: "app", JailIP: ip, ListenPort: 8080, DependsOn: deps}
+	}
+	for _, tt := range []struct {
+		name  string
+		sites map[string]SiteConfig
+		ok    bool
+	}{
+		{"chain", map[string]SiteConfig{
+			"api.example.com":  {APIKey: "k", Backend: backend("10.0.0.2", "auth.example.com")},
+			"auth.example.com": {APIKey: "k", Backend: backend("10.0.0.3", "db.example.com")},
+			"db.example.com":   {APIKey: "k", Backend: backend("10.0.0.4")},
+		}, true},
+		{"unknown site", map[string]SiteConfig{
+			"api.example.com": {APIKey: "k", Backend: backend("10.0.0.2", "auth.example.com")},
+		}, false},
+		{"itself", map[string]SiteConfig{
+			"api.example.com": {APIKey: "k", Backend: backend("10.0.0.2", "api.example.com")},
+		}, false},
+		{"no backend", map[string]SiteConfig{
+			"api.example.com": {APIKey: "k", Backend: backend("10.0.0.2", "example.com")},
+			"example.com":     {APIKey: "k", FrontendRoot: "/f"},
+		}, false},
+		{"cycle", map[string]SiteConfig{
+			"api.example.com":  {APIKey: "k", Backend: backend("10.0.0.2", "auth.example.com")},
+			"auth.example.com": {APIKey: "k", Backend: backend("10.0.0.3", "api.example.com")},
+		}, false},
+	} {
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+			Site:      tt.sites,
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestBackendOrder(t *testing.T) {
+	cfg := &Config{Site: map[string]SiteConfig{
+		"api.example.com":    {Backend: &BackendConfig{DependsOn: []string{"auth.example.com", "db.example.com"}}},
+		"auth.example.com":   {Backend: &BackendConfig{DependsOn: []string{"db.example.com"}}},
+		"db.example.com":     {Backend: &BackendConfig{}},
+		"jobs.example.com":   {Backend: &BackendConfig{}},
+		"static.example.com": {FrontendRoot: "/f"},
+	}}
+	order, err := cfg.BackendOrder()
+	if err != nil {
+		t.Fatalf("BackendOrder: %v", err)
+	}
+	want := []string{"db.example.com", "jobs.example.com", "auth.example.com", "api.example.com"}
+	if !slices.Equal(order, want) {
+		t.Errorf("BackendOrder = %v, want %v", order, want)
+	}
+}
+
+func TestValidate_JailInit(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		init JailInitConfig
+		ok   bool
+	}{
+		{"defaults", JailInitConfig{}, true},
+		{"all set", JailInitConfig{Timezone: "America/Argentina/Buenos_Aires", Locale: "en_AU.UTF-8", ResolvConf: "/etc/resolv.jails"}, true},
+		{"host timezone", JailInitConfig{Timezone: "host", ResolvConf: "none"}, true},
+		{"timezone outside zoneinfo", JailInitConfig{Timezone: "../../etc/passwd"}, false},
+		{"locale with space", JailInitConfig{Locale: "en AU"}, false},
+		{"relative resolv.conf", JailInitConfig{ResolvConf: "resolv.conf"}, false},
+	} {
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x", Init: tt.init},
+			Site:      map[string]SiteConfig{"example.com": {FrontendRoot: "/f", APIKey: "k"}},
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestSiteConfig_ValidateMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		site SiteConfig
+		ok   bool
+	}{
+		{"none", SiteConfig{}, true},
+		{"tags and owner", SiteConfig{Tags: []string{"team-payments", "env:prod"}, Owner: "Payments Team", Description: "Checkout"}, true},
+		{"tag with comma", SiteConfig{Tags: []string{"a,b"}}, false},
+		{"tag with space", SiteConfig{Tags: []string{"team payments"}}, false},
+		{"multi-line owner", SiteConfig{Owner: "a\nb"}, false},
+	} {
+		if err := tt.site.ValidateMetadata(); (err == nil) != tt.ok {
+			t.Errorf("%s: ValidateMetadata = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestSiteConfig_Frozen(t *testing.T) {
+	site := SiteConfig{Freeze: []FreezeWindow{
+		{Start: "0 17 * * fri", Duration: 63 * time.Hour, Timezone: "Australia/Perth", Reason: "weekend"},
+	}}
+	perth, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	for _, tt := range []struct {
+		t      time.Time
+		frozen bool
+	}{
+		{time.Date(2026, 10, 16, 16, 59, 0, 0, perth), false}, // Friday
+		{time.Date(2026, 10, 16, 17, 0, 0, 0, perth), true},
+		{time.Date(2026, 10, 18, 12, 0, 0, 0, perth), true},
+		{time.Date(2026, 10, 19, 7, 59, 0, 0, perth), true},
+		{time.Date(2026, 10, 19, 8, 0, 0, 0, perth), false}, // Monday
+		{time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC), true},
+	} {
+		w, until, frozen := site.Frozen(tt.t)
+		if frozen != tt.frozen {
+			t.Errorf("Frozen(%s) = %v, want %v", tt.t, frozen, tt.frozen)
+			continue
+		}
+		if frozen && (w.Reason != "weekend" || !until.Equal(time.Date(2026, 10, 19, 8, 0, 0, 0, perth))) {
+			t.Errorf("Frozen(%s) = %+v until %s", tt.t, w, until)
+		}
+	}
+
+	cfg := &Config{
+		Server:    ServerConfig{ListenAddr: ":8080"},
+		AdminKeys: []string{"key"},
+		Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+		Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+	}
+	for _, w := range []FreezeWindow{
+		{Start: "0 17 * *", Duration: time.Hour},
+		{Start: "0 17 * * fri"},
+		{Start: "0 17 * * fri", Duration: 32 * 24 * time.Hour},
+		{Start: "0 17 * * fri", Duration: time.Hour, Timezone: "Mars/Olympus"},
+	} {
+		cfg.Site = map[string]SiteConfig{"example.com": {FrontendRoot: "/f", APIKey: "k", Freeze: []FreezeWindow{w}}}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", w)
+		}
+	}
+}
+
+func TestBackendConfig_BinaryArch(t *testing.T) {
+	for _, tt := range []struct {
+		arch string
+		want string
+		ok   bool
+	}{
+		{"", runtime.GOARCH, true},
+		{"arm64", "arm64", true},
+		{"x86_64", "amd64", true},
+		{"AArch64", "arm64", true},
+		{"sparc", "", false},
+	} {
+		b := &BackendConfig{JailName: "api", JailIP: "127.0.1.1", ListenPort: 8080, BinaryName: "api", Arch: tt.arch}
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x"},
+			Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+			Site:      map[string]SiteConfig{"api.example.com": {APIKey: "k", Backend: b}},
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("arch %q: Validate = %v, want ok=%v", tt.arch, err, tt.ok)
+		}
+		if tt.ok && b.BinaryArch() != tt.want {
+			t.Errorf("arch %q: BinaryArch = %q, want %q", tt.arch, b.BinaryArch(), tt.want)
+		}
+	}
+}
+
+func TestValidateMirror(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		backend BackendConfig
+		ok      bool
+	}{
+		{"none", BackendConfig{}, true},
+		{"ip target", BackendConfig{Mirror: &MirrorConfig{Target: "127.0.0.1:9090", Percent: 10}}, true},
+		{"ipv6 target", BackendConfig{Mirror: &MirrorConfig{Target: "[::1]:9090", Percent: 100}}, true},
+		{"hostname target", BackendConfig{Mirror: &MirrorConfig{Target: "staging.example.com:80", Percent: 10}}, false},
+		{"no port", BackendConfig{Mirror: &MirrorConfig{Target: "127.0.0.1", Percent: 10}}, false},
+		{"zero percent", BackendConfig{Mirror: &MirrorConfig{Target: "127.0.0.1:9090"}}, false},
+		{"over 100 percent", BackendConfig{Mirror: &MirrorConfig{Target: "127.0.0.1:9090", Percent: 101}}, false},
+		{"grpc", BackendConfig{Protocol: ProtocolGRPC, Mirror: &MirrorConfig{Target: "127.0.0.1:9090", Percent: 10}}, false},
+	} {
+		if err := validateMirror(tt.backend); (err == nil) != tt.ok {
+			t.Errorf("%s: validateMirror = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestExperimentConfig_Validate(t *testing.T) {
+	two := func(a, b VariantConfig) map[string]VariantConfig {
+		return map[string]VariantConfig{"control": a, "new-flow": b}
+	}
+	for _, tt := range []struct {
+		name string
+		exp  ExperimentConfig
+		ok   bool
+	}{
+		{"valid", ExperimentConfig{Name: "checkout", Variants: two(VariantConfig{"latest", 50}, VariantConfig{"abc1234", 50})}, true},
+		{"one variant off", ExperimentConfig{Name: "checkout", Variants: two(VariantConfig{"latest", 0}, VariantConfig{"abc1234", 1})}, true},
+		{"one variant", ExperimentConfig{Name: "checkout", Variants: map[string]VariantConfig{"control": {"latest", 1}}}, false},
+		{"name with dash", ExperimentConfig{Name: "check-out", Variants: two(VariantConfig{"latest", 1}, VariantConfig{"abc1234", 1})}, false},
+		{"bad commit", ExperimentConfig{Name: "checkout", Variants: two(VariantConfig{"latest", 1}, VariantConfig{"main", 1})}, false},
+		{"no weight", ExperimentConfig{Name: "checkout", Variants: two(VariantConfig{"latest", 0}, VariantConfig{"abc1234", 0})}, false},
+		{"negative weight", ExperimentConfig{Name: "checkout", Variants: two(VariantConfig{"latest", -1}, VariantConfig{"abc1234", 2})}, false},
+		{"bad variant name", ExperimentConfig{Name: "checkout", Variants: map[string]VariantConfig{"a b": {"latest", 1}, "c": {"latest", 1}}}, false},
+	} {
+		if err := tt.exp.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_Geo(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		database string
+		site     SiteConfig
+		ok       bool
+	}{
+		{"override ips", "", SiteConfig{OverrideIPs: []string{"10.0.0.1", "192.168.0.0/16", "::1"}}, true},
+		{"bad override ip", "", SiteConfig{OverrideIPs: []string{"10.0.0.1; return 200"}}, false},
+		{"country needs database", "", SiteConfig{OverrideIPs: []string{"AU"}}, false},
+		{"country", "/geo.mmdb", SiteConfig{OverrideIPs: []string{"AU"}}, true},
+		{"geo needs database", "", SiteConfig{Geo: &GeoConfig{Block: []string{"RU"}}}, false},
+		{"block and redirect", "/geo.mmdb", SiteConfig{Geo: &GeoConfig{Block: []string{"RU", "--"}, Redirect: map[string]string{"DE": "https://de.example.com/shop"}}}, true},
+		{"block and allow", "/geo.mmdb", SiteConfig{Geo: &GeoConfig{Block: []string{"RU"}, Allow: []string{"AU"}}}, false},
+		{"lowercase country", "/geo.mmdb", SiteConfig{Geo: &GeoConfig{Allow: []string{"au"}}}, false},
+		{"relative redirect", "/geo.mmdb", SiteConfig{Geo: &GeoConfig{Redirect: map[string]string{"DE": "/de"}}}, false},
+		{"redirect with variable", "/geo.mmdb", SiteConfig{Geo: &GeoConfig{Redirect: map[string]string{"DE": "https://de.example.com/$host"}}}, false},
+	} {
+		tt.site.FrontendRoot, tt.site.APIKey = "/f", "k"
+		cfg := &Config{
+			Server:    ServerConfig{ListenAddr: ":8080"},
+			AdminKeys: []string{"key"},
+			Nginx:     NginxConfig{BinaryPath: "/x", MainConfPath: "/x", SitesAvailable: "/x", SitesEnabled: "/x", GeoIPDatabase: tt.database},
+			Jail:      JailConfig{BaseDir: "/x", JailConfPath: "/x"},
+			Site:      map[string]SiteConfig{"example.com": tt.site},
+		}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestBanConfig_Validate(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ban  BanConfig
+		ok   bool
+	}{
+		{"defaults", BanConfig{Enabled: true}, true},
+		{"relative log", BanConfig{Log: "ban.log"}, false},
+		{"ignore", BanConfig{Ignore: []string{"10.0.0.0/8", "203.0.113.9"}}, true},
+		{"bad ignore", BanConfig{Ignore: []string{"office"}}, false},
+		{"rule", BanConfig{Rules: []BanRule{{Name: "admin", Sites: []string{"*.example.com"}, Paths: []string{"/admin"}, Max: 5, Window: 60}}}, true},
+		{"unnamed rule", BanConfig{Rules: []BanRule{{Max: 5, Window: 60}}}, false},
+		{"duplicate rule", BanConfig{Rules: []BanRule{{Name: "a", Max: 5, Window: 60}, {Name: "a", Max: 5, Window: 60}}}, false},
+		{"no window", BanConfig{Rules: []BanRule{{Name: "a", Max: 5}}}, false},
+		{"relative path", BanConfig{Rules: []BanRule{{Name: "a", Paths: []string{"admin"}, Max: 5, Window: 60}}}, false},
+	} {
+		if err := tt.ban.validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestBanConfig_Defaults(t *testing.T) {
+	b := BanConfig{}
+	if b.LogPath() != DefaultBanLog {
+		t.Errorf("LogPath() = %q, want %q", b.LogPath(), DefaultBanLog)
+	}
+	if got := b.IncludePath(NginxConfig{OverrideConf: "/etc/nginx/override.conf"}); got != "/etc/nginx/bans.conf" {
+		t.Errorf("IncludePath() = %q, want bans.conf next to override.conf", got)
+	}
+	if len(b.BanRules()) != len(DefaultBanRules) {
+		t.Error("BanRules() should default to DefaultBanRules")
+	}
+	b.Duration = 600
+	if b.DurationFor(BanRule{}) != 10*time.Minute || b.DurationFor(BanRule{Duration: 60}) != time.Minute {
+		t.Error("DurationFor() should prefer the rule's duration, then ban.duration")
+	}
+}
+
+func TestBackendConfig_HealthCheck(t *testing.T) {
+	if got := (BackendConfig{}).HealthCheck(HealthConfig{}); got != (HealthCheck{Path: DefaultHealthPath, Status: 200}) {
+		t.Errorf("HealthCheck() = %+v, want the defaults", got)
+	}
+	if got := (BackendConfig{}).HealthCheck(HealthConfig{HealthPath: "/ping"}); got.Path != "/ping" {
+		t.Errorf("HealthCheck().Path = %q, want health.health_path", got.Path)
+	}
+	b := BackendConfig{HealthPath: "/api/health", HealthStatus: 204, HealthBody: "ok"}
+	if got := b.HealthCheck(HealthConfig{HealthPath: "/ping"}); got != (HealthCheck{Path: "/api/health", Status: 204, Body: "ok"}) {
+		t.Errorf("HealthCheck() = %+v, want the backend's own", got)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		backend BackendConfig
+		ok      bool
+	}{
+		{"none", BackendConfig{}, true},
+		{"path and body", BackendConfig{HealthPath: "/healthz", HealthBody: `"status":"ok"`}, true},
+		{"relative path", BackendConfig{HealthPath: "healthz"}, false},
+		{"bad status", BackendConfig{HealthStatus: 42}, false},
+		{"grpc", BackendConfig{Protocol: ProtocolGRPC, HealthPath: "/healthz"}, false},
+	} {
+		if err := validateHealthCheck(tt.backend); (err == nil) != tt.ok {
+			t.Errorf("%s: validateHealthCheck = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestBackendConfig_StartupGrace(t *testing.T) {
+	if got := (BackendConfig{}).StartupGraceDuration(HealthConfig{}); got != DefaultStartupGrace {
+		t.Errorf("StartupGraceDuration() = %v, want %v", got, DefaultStartupGrace)
+	}
+	if got := (BackendConfig{}).StartupGraceDuration(HealthConfig{StartupGrace: 90}); got != 90*time.Second {
+		t.Errorf("StartupGraceDuration() = %v, want health.startup_grace", got)
+	}
+	if got := (BackendConfig{StartupGrace: 120}).StartupGraceDuration(HealthConfig{StartupGrace: 90}); got != 2*time.Minute {
+		t.Errorf("StartupGraceDuration() = %v, want the backend's own", got)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		backend BackendConfig
+		ok      bool
+	}{
+		{"liveness path", BackendConfig{LivenessPath: "/livez", StartupGrace: 60}, true},
+		{"relative liveness path", BackendConfig{LivenessPath: "livez"}, false},
+		{"negative grace", BackendConfig{StartupGrace: -1}, false},
+		{"uwsgi liveness", BackendConfig{Protocol: ProtocolUWSGI, LivenessPath: "/livez"}, false},
+	} {
+		if err := validateHealthCheck(tt.backend); (err == nil) != tt.ok {
+			t.Errorf("%s: validateHealthCheck = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
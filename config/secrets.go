@@ -0,0 +1,212 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// secretsFile is the content of shipyard.secrets.toml: the admin keys, their
+// key_acl and each site's api_key
+type secretsFile struct {
+	AdminKeys []string              `toml:"admin_keys,omitempty"`
+	KeyACL    map[string][]string   `toml:"key_acl,omitempty"`
+	Site      map[string]siteSecret `toml:"site,omitempty"`
+}
+
+type siteSecret struct {
+	APIKey string `toml:"api_key"`
+}
+
+// SecretsPath returns the secrets file kept beside a config file:
+// shipyard.toml -> shipyard.secrets.toml
+func SecretsPath(configPath string) string {
+	base := strings.TrimSuffix(filepath.Base(configPath), ".toml")
+	return filepath.Join(filepath.Dir(configPath), base+".secrets.toml")
+}
+
+// loadSecrets merges the secrets file into c if it exists. The file must not
+// be readable by group or others.
+func (c *Config) loadSecrets(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat secrets file: %w", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("secrets file %s must not be accessible by group or others (chmod 600)", path)
+	}
+
+	var secrets secretsFile
+	if _, err := toml.DecodeFile(path, &secrets); err != nil {
+		return fmt.Errorf("parse secrets file: %w", err)
+	}
+
+	for _, key := range secrets.AdminKeys {
+		if !slices.Contains(c.AdminKeys, key) {
+			c.AdminKeys = append(c.AdminKeys, key)
+		}
+	}
+	for key, globs := range secrets.KeyACL {
+		if c.KeyACL == nil {
+			c.KeyACL = make(map[string][]string)
+		}
+		c.KeyACL[key] = globs
+	}
+	// Entries for sites no longer in the config are dropped on the next save
+	for name, secret := range secrets.Site {
+		if site, ok := c.Site[name]; ok && secret.APIKey != "" {
+			site.APIKey = secret.APIKey
+			c.Site[name] = site
+		}
+	}
+
+	c.secretsPath = path
+	return nil
+}
+
+// SplitSecrets moves admin keys, key_acl and site API keys out of the config
+// file into the secrets file and saves both
+func (c *Config) SplitSecrets() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.path == "" {
+		return fmt.Errorf("config path not set")
+	}
+	c.secretsPath = SecretsPath(c.path)
+	return c.save()
+}
+
+// save writes the config (lock held). When a secrets file is in use, secrets
+// go there with mode 0600 and are left out of the main file. Sites kept in
+// include_dir are written to their own files.
+func (c *Config) save() error {
+	if c.path == "" {
+		return fmt.Errorf("config path not set")
+	}
+	if c.secretsPath == "" && c.IncludeDir == "" {
+		return writeTOML(c.path, c, 0644)
+	}
+
+	doc, err := encodeDocument(c)
+	if err != nil {
+		return err
+	}
+	if c.secretsPath != "" {
+		if err := c.saveSecrets(); err != nil {
+			return err
+		}
+		stripSecrets(doc)
+	}
+	if err := c.saveSiteFiles(doc); err != nil {
+		return err
+	}
+	return writeTOML(c.path, doc, 0644)
+}
+
+// saveSecrets writes the secrets file (lock held)
+func (c *Config) saveSecrets() error {
+	secrets := secretsFile{AdminKeys: c.AdminKeys, KeyACL: c.KeyACL, Site: make(map[string]siteSecret, len(c.Site))}
+	for name, site := range c.Site {
+		secrets.Site[name] = siteSecret{APIKey: site.APIKey}
+	}
+	// Tighten an existing file before the secrets are written to it
+	if err := os.Chmod(c.secretsPath, 0600); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("chmod secrets file: %w", err)
+	}
+	return writeTOML(c.secretsPath, secrets, 0600)
+}
+
+// encodeDocument returns c as a generic TOML document
+func encodeDocument(c *Config) (map[string]any, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, fmt.Errorf("encode config: %w", err)
+	}
+	var doc map[string]any
+	if _, err := toml.Decode(buf.String(), &doc); err != nil {
+		return nil, fmt.Errorf("encode config: %w", err)
+	}
+	return doc, nil
+}
+
+// stripSecrets removes the keys kept in the secrets file from doc
+func stripSecrets(doc map[string]any) {
+	delete(doc, "admin_keys")
+	delete(doc, "key_acl")
+	if sites, ok := doc["site"].(map[string]any); ok {
+		for _, site := range sites {
+			if s, ok := site.(map[string]any); ok {
+				delete(s, "api_key")
+			}
+		}
+	}
+}
+
+// writeTOML replaces the content of path with v encoded as TOML. An existing
+// file is edited so operators' comments and layout survive; only the keys
+// and tables that changed are rewritten. The new content is written to a
+// temporary file and renamed over path, so a crash never leaves it half
+// written. New files get perm; existing files keep their mode.
+func writeTOML(path string, v any, perm os.FileMode) error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	content := buf.String()
+	if current, err := os.ReadFile(path); err == nil && len(current) > 0 {
+		// Anything the editor can't handle falls back to a full rewrite
+		if merged, err := mergeTOML(string(current), content); err == nil {
+			content = merged
+		}
+		if content == string(current) {
+			return nil
+		}
+	}
+
+	// Replace the file a symlink points at, not the link
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("create config file: %w", err)
+	}
+	// OpenFile's mode is masked by the umask and ignored for an existing tmp;
+	// set it before writing so secrets are never readable by others
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("write config file: %w", err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("write config file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("sync config file: %w", err)
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace config file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const secretsTestConfig = `
+admin_keys = ["sk-admin-in-main"]
+
+[server]
+listen_addr = "0.0.0.0:8080"
+
+[nginx]
+binary_path = "/usr/sbin/nginx"
+main_conf_path = "/etc/nginx/nginx.conf"
+sites_available = "/etc/nginx/sites-available"
+sites_enabled = "/etc/nginx/sites-enabled"
+
+[jail]
+base_dir = "/var/jails"
+jail_conf_path = "/etc/jail.conf"
+
+[site."test.example.com"]
+frontend_root = "/var/www/test"
+api_key = "sk-site-in-main"
+`
+
+func TestSecretsPath(t *testing.T) {
+	if got := SecretsPath("/usr/local/etc/shipyard/shipyard.toml"); got != "/usr/local/etc/shipyard/shipyard.secrets.toml" {
+		t.Errorf("SecretsPath() = %q", got)
+	}
+}
+
+func TestLoad_MergesSecrets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shipyard.toml")
+	os.WriteFile(path, []byte(secretsTestConfig), 0644)
+	os.WriteFile(SecretsPath(path), []byte(`
+admin_keys = ["sk-admin-secret"]
+
+[site."test.example.com"]
+api_key = "sk-site-secret"
+`), 0600)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.AdminKeys) != 2 || cfg.AdminKeys[1] != "sk-admin-secret" {
+		t.Errorf("AdminKeys = %v", cfg.AdminKeys)
+	}
+	if cfg.Site["test.example.com"].APIKey != "sk-site-secret" {
+		t.Errorf("APIKey = %q, want the secrets file's key", cfg.Site["test.example.com"].APIKey)
+	}
+
+	os.Chmod(SecretsPath(path), 0644)
+	if _, err := Load(path); err == nil {
+		t.Error("Load() should refuse a world-readable secrets file")
+	}
+}
+
+func TestSave_PreservesSecretsSplit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shipyard.toml")
+	os.WriteFile(path, []byte(secretsTestConfig), 0644)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := cfg.SplitSecrets(); err != nil {
+		t.Fatalf("SplitSecrets() error = %v", err)
+	}
+	if err := cfg.AddSite("new.example.com", SiteConfig{FrontendRoot: "/var/www/new", APIKey: "sk-site-new"}); err != nil {
+		t.Fatalf("AddSite() error = %v", err)
+	}
+
+	main, _ := os.ReadFile(path)
+	if strings.Contains(string(main), "sk-") {
+		t.Errorf("config file still contains secrets:\n%s", main)
+	}
+	info, err := os.Stat(SecretsPath(path))
+	if err != nil {
+		t.Fatalf("secrets file missing: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("secrets file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	reloaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() after split error = %v", err)
+	}
+	if len(reloaded.AdminKeys) != 1 || reloaded.AdminKeys[0] != "sk-admin-in-main" {
+		t.Errorf("AdminKeys = %v", reloaded.AdminKeys)
+	}
+	if reloaded.Site["new.example.com"].APIKey != "sk-site-new" || reloaded.Site["test.example.com"].APIKey != "sk-site-in-main" {
+		t.Errorf("site keys not preserved: %+v", reloaded.Site)
+	}
+	if reloaded.Site["test.example.com"].FrontendRoot != "/var/www/test" {
+		t.Errorf("non-secret fields lost: %+v", reloaded.Site["test.example.com"])
+	}
+}
+
+func TestWriteTOML_KeepsModeAndSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "real.toml")
+	os.WriteFile(target, []byte("# managed by hand\nadmin_keys = [\"a\"]\n"), 0640)
+	link := filepath.Join(dir, "shipyard.toml")
+	os.Symlink(target, link)
+
+	if err := writeTOML(link, map[string]any{"admin_keys": []string{"b"}}, 0644); err != nil {
+		t.Fatalf("writeTOML() error = %v", err)
+	}
+
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symlink replaced: %v", err)
+	}
+	info, _ := os.Stat(target)
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640 kept", info.Mode().Perm())
+	}
+	content, _ := os.ReadFile(target)
+	if !strings.Contains(string(content), "# managed by hand") || !strings.Contains(string(content), `"b"`) {
+		t.Errorf("content = %q", content)
+	}
+	if _, err := os.Stat(target + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
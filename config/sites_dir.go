@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// fileStamp identifies a version of a site file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampOf(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// SiteFilePath returns the include_dir file for a site: <include_dir>/<name>.toml
+func (c *Config) SiteFilePath(name string) string {
+	return filepath.Join(c.IncludeDir, name+".toml")
+}
+
+// siteFilesIn returns the site files in dir by site name. Hidden files
+// (editor swap files and the like) are skipped.
+func siteFilesIn(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read include_dir: %w", err)
+	}
+	files := make(map[string]string)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".toml") {
+			continue
+		}
+		files[strings.TrimSuffix(name, ".toml")] = filepath.Join(dir, name)
+	}
+	return files, nil
+}
+
+func readSiteFile(path string) (SiteConfig, error) {
+	var site SiteConfig
+	if _, err := toml.DecodeFile(path, &site); err != nil {
+		return SiteConfig{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return site, nil
+}
+
+// loadIncludeDir merges the site files from include_dir into c. A site may be
+// defined in the main config or in include_dir, not both.
+func (c *Config) loadIncludeDir() error {
+	if c.IncludeDir == "" {
+		return nil
+	}
+	files, err := siteFilesIn(c.IncludeDir)
+	if err != nil {
+		return err
+	}
+
+	c.siteFiles = make(map[string]string, len(files))
+	c.siteStamps = make(map[string]fileStamp, len(files))
+	for name, file := range files {
+		if _, exists := c.Site[name]; exists {
+			return fmt.Errorf("site %q is defined in both %s and %s", name, c.path, file)
+		}
+		site, err := readSiteFile(file)
+		if err != nil {
+			return err
+		}
+		if c.Site == nil {
+			c.Site = make(map[string]SiteConfig)
+		}
+		c.Site[name] = site
+		c.siteFiles[name] = file
+		if stamp, err := stampOf(file); err == nil {
+			c.siteStamps[file] = stamp
+		}
+	}
+	return nil
+}
+
+// ReloadIncludeDir picks up site files added, changed or removed in include_dir
+// by hand since the config was loaded or saved, and returns the names of the
+// sites that changed. If the resulting config doesn't validate nothing is
+// applied, and the offending files are retried only once they change again.
+func (c *Config) ReloadIncludeDir() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.IncludeDir == "" {
+		return nil, nil
+	}
+	files, err := siteFilesIn(c.IncludeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	sites := maps.Clone(c.Site)
+	if sites == nil {
+		sites = make(map[string]SiteConfig)
+	}
+	stamps := make(map[string]fileStamp, len(files))
+	var changed []string
+	var reloadErr error
+	for name, file := range files {
+		stamp, err := stampOf(file)
+		if err != nil {
+			continue // removed while we were looking
+		}
+		stamps[file] = stamp
+		if old, ok := c.siteStamps[file]; ok && old == stamp {
+			continue
+		}
+		if _, exists := c.Site[name]; exists && c.siteFiles[name] == "" {
+			reloadErr = fmt.Errorf("site %q is defined in both %s and %s", name, c.path, file)
+			continue
+		}
+		site, err := readSiteFile(file)
+		if err != nil {
+			reloadErr = err
+			continue
+		}
+		// The key may live in the secrets file
+		if site.APIKey == "" {
+			site.APIKey = c.Site[name].APIKey
+		}
+		sites[name] = site
+		changed = append(changed, name)
+	}
+	for name := range c.siteFiles {
+		if _, ok := files[name]; !ok {
+			delete(sites, name)
+			changed = append(changed, name)
+		}
+	}
+	if reloadErr == nil && len(changed) > 0 {
+		previous := c.Site
+		c.Site = sites
+		if err := c.Validate(); err != nil {
+			c.Site = previous
+			reloadErr = err
+		}
+	}
+	c.siteStamps = stamps
+	if reloadErr != nil {
+		return nil, reloadErr
+	}
+
+	c.siteFiles = make(map[string]string, len(files))
+	for name, file := range files {
+		if _, ok := c.Site[name]; ok {
+			c.siteFiles[name] = file
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// saveSiteFiles writes the sites kept in include_dir to their own files and
+// removes them from doc, the document for the main config file (lock held)
+func (c *Config) saveSiteFiles(doc map[string]any) error {
+	sites, _ := doc["site"].(map[string]any)
+	if c.siteStamps == nil {
+		c.siteStamps = make(map[string]fileStamp)
+	}
+	if len(c.siteFiles) > 0 {
+		if err := os.MkdirAll(c.IncludeDir, 0755); err != nil {
+			return fmt.Errorf("create include_dir: %w", err)
+		}
+	}
+	for name, file := range c.siteFiles {
+		site, ok := sites[name]
+		if !ok {
+			continue
+		}
+		delete(sites, name)
+		if err := writeTOML(file, site, 0644); err != nil {
+			return err
+		}
+		// Our own writes are not hand edits
+		if stamp, err := stampOf(file); err == nil {
+			c.siteStamps[file] = stamp
+		}
+	}
+	if sites != nil && len(sites) == 0 {
+		delete(doc, "site")
+	}
+	return nil
+}
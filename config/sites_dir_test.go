@@ -0,0 +1,162 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const includeDirTestConfig = `
+admin_keys = ["sk-admin"]
+include_dir = "%s"
+
+[server]
+listen_addr = "0.0.0.0:8080"
+
+[nginx]
+binary_path = "/usr/sbin/nginx"
+main_conf_path = "/etc/nginx/nginx.conf"
+sites_available = "/etc/nginx/sites-available"
+sites_enabled = "/etc/nginx/sites-enabled"
+
+[jail]
+base_dir = "/var/jails"
+jail_conf_path = "/etc/jail.conf"
+
+[site."main.example.com"]
+frontend_root = "/var/www/main"
+api_key = "sk-main"
+`
+
+func loadWithIncludeDir(t *testing.T, files map[string]string) (*Config, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	sitesDir := filepath.Join(dir, "sites.d")
+	os.Mkdir(sitesDir, 0755)
+	for name, content := range files {
+		os.WriteFile(filepath.Join(sitesDir, name), []byte(content), 0644)
+	}
+	path := filepath.Join(dir, "shipyard.toml")
+	os.WriteFile(path, []byte(strings.Replace(includeDirTestConfig, "%s", sitesDir, 1)), 0644)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return cfg, path, sitesDir
+}
+
+// touch rewrites a file with a later mtime so a reload sees the change
+// even on filesystems with coarse timestamps
+func touch(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(2 * time.Second)
+	os.Chtimes(path, later, later)
+}
+
+func TestLoad_IncludeDir(t *testing.T) {
+	cfg, _, _ := loadWithIncludeDir(t, map[string]string{
+		"app.example.com.toml":      "frontend_root = \"/var/www/app\"\napi_key = \"sk-app\"\n",
+		".app.example.com.toml.swp": "garbage",
+		"README":                    "not a site",
+	})
+	if len(cfg.Site) != 2 || cfg.Site["app.example.com"].FrontendRoot != "/var/www/app" {
+		t.Errorf("sites = %+v", cfg.Site)
+	}
+
+	dir := t.TempDir()
+	sitesDir := filepath.Join(dir, "sites.d")
+	os.Mkdir(sitesDir, 0755)
+	os.WriteFile(filepath.Join(sitesDir, "main.example.com.toml"), []byte("frontend_root = \"/var/www/other\"\napi_key = \"sk-x\"\n"), 0644)
+	path := filepath.Join(dir, "shipyard.toml")
+	os.WriteFile(path, []byte(strings.Replace(includeDirTestConfig, "%s", sitesDir, 1)), 0644)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "defined in both") {
+		t.Errorf("Load() error = %v, want duplicate site error", err)
+	}
+}
+
+func TestIncludeDir_AddRemoveSite(t *testing.T) {
+	cfg, path, sitesDir := loadWithIncludeDir(t, map[string]string{
+		"app.example.com.toml": "# owned by team A\nfrontend_root = \"/var/www/app\"\napi_key = \"sk-app\"\n",
+	})
+	mainBefore, _ := os.ReadFile(path)
+	appBefore, _ := os.ReadFile(filepath.Join(sitesDir, "app.example.com.toml"))
+
+	if err := cfg.AddSite("new.example.com", SiteConfig{FrontendRoot: "/var/www/new", APIKey: "sk-new"}); err != nil {
+		t.Fatalf("AddSite() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(sitesDir, "new.example.com.toml"))
+	if err != nil {
+		t.Fatalf("site file not created: %v", err)
+	}
+	if !strings.Contains(string(data), `frontend_root = "/var/www/new"`) || strings.Contains(string(data), "[site") {
+		t.Errorf("site file = %s", data)
+	}
+	if mainAfter, _ := os.ReadFile(path); string(mainAfter) != string(mainBefore) {
+		t.Errorf("main config changed:\n%s", mainAfter)
+	}
+	if appAfter, _ := os.ReadFile(filepath.Join(sitesDir, "app.example.com.toml")); string(appAfter) != string(appBefore) {
+		t.Errorf("untouched site file changed:\n%s", appAfter)
+	}
+
+	if err := cfg.RemoveSite("app.example.com"); err != nil {
+		t.Fatalf("RemoveSite() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sitesDir, "app.example.com.toml")); !os.IsNotExist(err) {
+		t.Errorf("site file not removed: %v", err)
+	}
+
+	reloaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(reloaded.Site) != 2 || reloaded.Site["new.example.com"].APIKey != "sk-new" {
+		t.Errorf("sites = %+v", reloaded.Site)
+	}
+}
+
+func TestReloadIncludeDir(t *testing.T) {
+	cfg, _, sitesDir := loadWithIncludeDir(t, map[string]string{
+		"app.example.com.toml": "frontend_root = \"/var/www/app\"\napi_key = \"sk-app\"\n",
+		"old.example.com.toml": "frontend_root = \"/var/www/old\"\napi_key = \"sk-old\"\n",
+	})
+
+	if changed, err := cfg.ReloadIncludeDir(); err != nil || len(changed) != 0 {
+		t.Fatalf("ReloadIncludeDir() = %v, %v; want no changes", changed, err)
+	}
+
+	touch(t, filepath.Join(sitesDir, "app.example.com.toml"), "frontend_root = \"/srv/app\"\napi_key = \"sk-app\"\n")
+	touch(t, filepath.Join(sitesDir, "hand.example.com.toml"), "frontend_root = \"/var/www/hand\"\napi_key = \"sk-hand\"\n")
+	os.Remove(filepath.Join(sitesDir, "old.example.com.toml"))
+
+	changed, err := cfg.ReloadIncludeDir()
+	if err != nil {
+		t.Fatalf("ReloadIncludeDir() error = %v", err)
+	}
+	if strings.Join(changed, ",") != "app.example.com,hand.example.com,old.example.com" {
+		t.Errorf("changed = %v", changed)
+	}
+	if cfg.Site["app.example.com"].FrontendRoot != "/srv/app" || cfg.Site["hand.example.com"].APIKey != "sk-hand" {
+		t.Errorf("sites = %+v", cfg.Site)
+	}
+	if _, ok := cfg.Site["old.example.com"]; ok {
+		t.Error("removed site file still loaded")
+	}
+
+	// An invalid edit is reported and the previous config stays in place
+	touch(t, filepath.Join(sitesDir, "app.example.com.toml"), "frontend_root = \"/srv/app2\"\nquota_mb = \"lots\"\n")
+	if _, err := cfg.ReloadIncludeDir(); err == nil {
+		t.Error("ReloadIncludeDir() should reject an unparseable site file")
+	}
+	if cfg.Site["app.example.com"].FrontendRoot != "/srv/app" {
+		t.Errorf("invalid edit applied: %+v", cfg.Site["app.example.com"])
+	}
+	if _, err := cfg.ReloadIncludeDir(); err != nil {
+		t.Errorf("unchanged invalid file reported again: %v", err)
+	}
+}
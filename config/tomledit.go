@@ -0,0 +1,353 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// tomlItem is a line or multi-line statement inside a table
+type tomlItem struct {
+	raw   string // original text, including the trailing newline
+	key   string // top-level key for key/value statements; "" for comments and blank lines
+	value any
+}
+
+// tomlBlock is a table header with the items up to the next header. The root
+// block holds the keys before the first header and has a nil path.
+type tomlBlock struct {
+	path   []string
+	header string // comments directly above the header, and the header line
+	items  []tomlItem
+}
+
+// mergeTOML rewrites current so that it decodes to the same document as
+// updated while keeping its comments, ordering and formatting. Unchanged keys
+// keep their original lines, changed keys are replaced in place, removed keys
+// and tables are deleted, and new ones are added next to their neighbours.
+func mergeTOML(current, updated string) (string, error) {
+	var currentDoc, updatedDoc map[string]any
+	if _, err := toml.Decode(current, &currentDoc); err != nil {
+		return "", err
+	}
+	if _, err := toml.Decode(updated, &updatedDoc); err != nil {
+		return "", err
+	}
+	oldBlocks, err := parseTOMLBlocks(current)
+	if err != nil {
+		return "", err
+	}
+	newBlocks, err := parseTOMLBlocks(updated)
+	if err != nil {
+		return "", err
+	}
+
+	newByPath := make(map[string]*tomlBlock, len(newBlocks))
+	for _, b := range newBlocks {
+		newByPath[pathKey(b.path)] = b
+	}
+
+	var out []*tomlBlock
+	seen := make(map[string]bool)
+	var covered [][]string // tables kept as inline values in the current file
+
+	for _, ob := range oldBlocks {
+		table, ok := lookupTable(updatedDoc, ob.path)
+		if !ok && ob.path != nil {
+			continue // table removed
+		}
+		seen[pathKey(ob.path)] = true
+		nb := newByPath[pathKey(ob.path)]
+
+		merged := &tomlBlock{path: ob.path, header: ob.header}
+		present := make(map[string]bool)
+		for _, it := range ob.items {
+			if it.key == "" {
+				merged.items = append(merged.items, it)
+				continue
+			}
+			newValue, ok := table[it.key]
+			switch {
+			case !ok:
+				// key removed
+			case reflect.DeepEqual(it.value, newValue):
+				merged.items = append(merged.items, it)
+				present[it.key] = true
+				if _, isTable := newValue.(map[string]any); isTable {
+					covered = append(covered, appendPath(ob.path, it.key))
+				}
+			default:
+				if replacement, ok := nb.item(it.key); ok {
+					merged.items = append(merged.items, tomlItem{raw: unindent(replacement.raw), key: it.key, value: replacement.value})
+					present[it.key] = true
+				}
+				// otherwise the value is now written as its own table
+			}
+		}
+		if nb != nil {
+			for _, it := range nb.items {
+				if it.key != "" && !present[it.key] && !isZero(it.value) {
+					merged.insertStatement(tomlItem{raw: unindent(it.raw), key: it.key, value: it.value})
+				}
+			}
+		}
+		out = append(out, merged)
+	}
+
+	for _, nb := range newBlocks {
+		if nb.path == nil || seen[pathKey(nb.path)] || isCovered(covered, nb.path) {
+			continue
+		}
+		added := &tomlBlock{path: nb.path, header: "\n" + unindent(nb.header)}
+		for _, it := range nb.items {
+			if it.key != "" && !isZero(it.value) {
+				added.items = append(added.items, tomlItem{raw: unindent(it.raw), key: it.key, value: it.value})
+			}
+		}
+		if len(added.items) == 0 {
+			continue // parent or empty table; TOML doesn't need the header
+		}
+		out = insertBlock(out, added)
+	}
+
+	var sb strings.Builder
+	for _, b := range out {
+		header := b.header
+		if strings.HasSuffix(sb.String(), "\n\n") {
+			header = strings.TrimPrefix(header, "\n") // already separated by a blank line
+		}
+		sb.WriteString(header)
+		for _, it := range b.items {
+			sb.WriteString(it.raw)
+		}
+	}
+	result := sb.String()
+
+	// Never write something that means anything other than the new config
+	var resultDoc map[string]any
+	if _, err := toml.Decode(result, &resultDoc); err != nil {
+		return "", fmt.Errorf("merged config does not parse: %w", err)
+	}
+	if !reflect.DeepEqual(pruneZero(resultDoc), pruneZero(updatedDoc)) {
+		return "", fmt.Errorf("merged config does not match")
+	}
+	return result, nil
+}
+
+// parseTOMLBlocks splits a TOML document into blocks of statements
+func parseTOMLBlocks(text string) ([]*tomlBlock, error) {
+	lines := strings.SplitAfter(text, "\n")
+	root := &tomlBlock{}
+	blocks := []*tomlBlock{root}
+	cur := root
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if line == "" {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			cur.items = append(cur.items, tomlItem{raw: line})
+
+		case strings.HasPrefix(trimmed, "[["):
+			return nil, fmt.Errorf("arrays of tables are not supported")
+
+		case strings.HasPrefix(trimmed, "["):
+			path, err := headerPath(trimmed)
+			if err != nil {
+				return nil, err
+			}
+			cur = &tomlBlock{path: path, header: cur.detachComments() + line}
+			blocks = append(blocks, cur)
+
+		default:
+			// A statement ends at the first line where it parses on its own
+			stmt := line
+			for {
+				var m map[string]any
+				if _, err := toml.Decode(stmt, &m); err == nil && len(m) == 1 {
+					for k, v := range m {
+						cur.items = append(cur.items, tomlItem{raw: stmt, key: k, value: v})
+					}
+					break
+				}
+				i++
+				if i >= len(lines) {
+					return nil, fmt.Errorf("unterminated statement: %s", strings.TrimSpace(line))
+				}
+				stmt += lines[i]
+			}
+		}
+	}
+
+	// Make sure the last line ends with a newline so blocks can be appended
+	if last := lastItem(blocks); last != nil && !strings.HasSuffix(last.raw, "\n") {
+		last.raw += "\n"
+	}
+	return blocks, nil
+}
+
+// headerPath returns the key path of a [table] header line
+func headerPath(header string) ([]string, error) {
+	var m map[string]any
+	if _, err := toml.Decode(header+"\n", &m); err != nil {
+		return nil, fmt.Errorf("invalid table header %s: %w", header, err)
+	}
+	var path []string
+	for len(m) == 1 {
+		for k, v := range m {
+			path = append(path, k)
+			m, _ = v.(map[string]any)
+		}
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("invalid table header %s", header)
+	}
+	return path, nil
+}
+
+// detachComments removes the comment lines directly above the next header
+// from the end of the block and returns them
+func (b *tomlBlock) detachComments() string {
+	i := len(b.items)
+	for i > 0 && b.items[i-1].key == "" && strings.HasPrefix(strings.TrimSpace(b.items[i-1].raw), "#") {
+		i--
+	}
+	var sb strings.Builder
+	for _, it := range b.items[i:] {
+		sb.WriteString(it.raw)
+	}
+	b.items = b.items[:i]
+	return sb.String()
+}
+
+// item returns the statement for key
+func (b *tomlBlock) item(key string) (tomlItem, bool) {
+	if b == nil {
+		return tomlItem{}, false
+	}
+	for _, it := range b.items {
+		if it.key == key {
+			return it, true
+		}
+	}
+	return tomlItem{}, false
+}
+
+// insertStatement adds a statement after the block's last statement, before
+// any trailing blank lines or comments
+func (b *tomlBlock) insertStatement(it tomlItem) {
+	pos := 0
+	for i, existing := range b.items {
+		if existing.key != "" {
+			pos = i + 1
+		}
+	}
+	b.items = append(b.items[:pos], append([]tomlItem{it}, b.items[pos:]...)...)
+}
+
+// insertBlock adds a new table after the last block sharing the longest path
+// prefix with it, so a new site lands after the existing sites
+func insertBlock(blocks []*tomlBlock, b *tomlBlock) []*tomlBlock {
+	pos, best := len(blocks), 0
+	for i, existing := range blocks {
+		if n := commonPrefix(existing.path, b.path); n > 0 && n >= best {
+			pos, best = i+1, n
+		}
+	}
+	return append(blocks[:pos], append([]*tomlBlock{b}, blocks[pos:]...)...)
+}
+
+func lookupTable(doc map[string]any, path []string) (map[string]any, bool) {
+	for _, seg := range path {
+		next, ok := doc[seg].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		doc = next
+	}
+	return doc, true
+}
+
+func lastItem(blocks []*tomlBlock) *tomlItem {
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if n := len(blocks[i].items); n > 0 {
+			return &blocks[i].items[n-1]
+		}
+		if blocks[i].header != "" {
+			if !strings.HasSuffix(blocks[i].header, "\n") {
+				blocks[i].header += "\n"
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func isCovered(covered [][]string, path []string) bool {
+	for _, c := range covered {
+		if commonPrefix(c, path) == len(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func commonPrefix(a, b []string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+func appendPath(path []string, key string) []string {
+	return append(append([]string(nil), path...), key)
+}
+
+func pathKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+// unindent strips the encoder's indentation from the first line
+func unindent(s string) string {
+	return strings.TrimLeft(s, " \t")
+}
+
+// isZero reports whether a decoded value is what an absent key decodes to
+func isZero(v any) bool {
+	switch v := v.(type) {
+	case string:
+		return v == "" || v == "0s" // "0s" is an encoded zero time.Duration
+	case int64:
+		return v == 0
+	case float64:
+		return v == 0
+	case bool:
+		return !v
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(pruneZero(v)) == 0
+	}
+	return false
+}
+
+// pruneZero returns doc without zero values, so documents that decode to the
+// same config compare equal
+func pruneZero(doc map[string]any) map[string]any {
+	out := make(map[string]any, len(doc))
+	for k, v := range doc {
+		if m, ok := v.(map[string]any); ok {
+			v = pruneZero(m)
+		}
+		if !isZero(v) {
+			out[k] = v
+		}
+	}
+	return out
+}
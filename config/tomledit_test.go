@@ -0,0 +1,168 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const commentedTestConfig = `# Shipyard on web1 - managed by hand, see the runbook
+admin_keys = ["sk-admin"] # rotated 2026-01
+
+[server]
+listen_addr = "0.0.0.0:8080"
+
+[nginx]
+binary_path = "/usr/sbin/nginx"
+main_conf_path = "/etc/nginx/nginx.conf"
+sites_available = "/etc/nginx/sites-available"
+sites_enabled = "/etc/nginx/sites-enabled"
+
+[jail]
+base_dir = "/var/jails"
+jail_conf_path = "/etc/jail.conf"
+
+# Marketing site, owned by the web team
+[site."www.example.com"]
+frontend_root = "/var/www/www" # keep on the big disk
+api_key = "sk-www"
+
+# Legacy app, to be retired
+[site."old.example.com"]
+frontend_root = "/var/www/old"
+api_key = "sk-old"
+
+  [site."old.example.com".backend]
+  jail_name = "old"
+  listen_port = 3000
+`
+
+func loadCommented(t *testing.T) (*Config, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "shipyard.toml")
+	if err := os.WriteFile(path, []byte(commentedTestConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return cfg, path
+}
+
+func TestSave_Unchanged(t *testing.T) {
+	cfg, path := loadCommented(t)
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != commentedTestConfig {
+		t.Errorf("Save() changed an unmodified config:\n%s", data)
+	}
+}
+
+func TestSave_KeepsCommentsOnAddSite(t *testing.T) {
+	cfg, path := loadCommented(t)
+	if err := cfg.AddSite("new.example.com", SiteConfig{FrontendRoot: "/var/www/new", APIKey: "sk-new"}); err != nil {
+		t.Fatalf("AddSite() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	got := string(data)
+
+	if !strings.HasPrefix(got, strings.TrimSuffix(commentedTestConfig, "\n")) {
+		t.Errorf("existing content was rewritten:\n%s", got)
+	}
+	if !strings.Contains(got, "[site.\"new.example.com\"]\nfrontend_root = \"/var/www/new\"\n") {
+		t.Errorf("new site not appended:\n%s", got)
+	}
+
+	reloaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(reloaded.Site) != 3 || reloaded.Site["new.example.com"].APIKey != "sk-new" {
+		t.Errorf("sites = %+v", reloaded.Site)
+	}
+}
+
+func TestSave_KeepsCommentsOnRemoveSite(t *testing.T) {
+	cfg, path := loadCommented(t)
+	if err := cfg.RemoveSite("old.example.com"); err != nil {
+		t.Fatalf("RemoveSite() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	got := string(data)
+
+	for _, gone := range []string{"old.example.com", "Legacy app"} {
+		if strings.Contains(got, gone) {
+			t.Errorf("removed site left %q behind:\n%s", gone, got)
+		}
+	}
+	for _, kept := range []string{"# Shipyard on web1", "# rotated 2026-01", "# Marketing site", "# keep on the big disk"} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("comment %q lost:\n%s", kept, got)
+		}
+	}
+}
+
+func TestSave_EditsChangedKeysInPlace(t *testing.T) {
+	cfg, path := loadCommented(t)
+	if err := cfg.SetCanary("www.example.com", &CanaryConfig{Commit: "abc1234", Percent: 10}); err != nil {
+		t.Fatalf("SetCanary() error = %v", err)
+	}
+	cfg.mu.Lock()
+	site := cfg.Site["www.example.com"]
+	site.FrontendRoot = "/srv/www"
+	cfg.Site["www.example.com"] = site
+	cfg.mu.Unlock()
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	got := string(data)
+	if !strings.Contains(got, "# Marketing site, owned by the web team\n[site.\"www.example.com\"]\nfrontend_root = \"/srv/www\"\napi_key = \"sk-www\"\n") {
+		t.Errorf("changed key not edited in place:\n%s", got)
+	}
+	// The canary table goes with its site, not at the end of the file
+	if strings.Index(got, "[site.\"www.example.com\".canary]") > strings.Index(got, "# Legacy app") {
+		t.Errorf("canary table not placed after its site:\n%s", got)
+	}
+
+	reloaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if c := reloaded.Site["www.example.com"].Canary; c == nil || c.Commit != "abc1234" || c.Percent != 10 {
+		t.Errorf("canary = %+v", c)
+	}
+}
+
+func TestSave_Experiment(t *testing.T) {
+	cfg, path := loadCommented(t)
+	experiment := &ExperimentConfig{Name: "checkout", Variants: map[string]VariantConfig{
+		"control":  {Commit: "latest", Weight: 50},
+		"new-flow": {Commit: "abc1234", Weight: 50},
+	}}
+	if err := cfg.SetExperiment("www.example.com", experiment); err != nil {
+		t.Fatalf("SetExperiment() error = %v", err)
+	}
+
+	reloaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	got := reloaded.Site["www.example.com"].Experiment
+	if got == nil || got.Name != "checkout" || got.Variants["new-flow"] != (VariantConfig{Commit: "abc1234", Weight: 50}) {
+		t.Errorf("experiment = %+v", got)
+	}
+
+	if err := cfg.SetExperiment("www.example.com", nil); err != nil {
+		t.Fatalf("SetExperiment(nil) error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "experiment") {
+		t.Errorf("stopped experiment left in the file:\n%s", data)
+	}
+}
@@ -0,0 +1,60 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * * funday",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) accepted", expr)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	// Friday 16 October 2026
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 30, 0, time.UTC)
+	}
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"0 17 * * fri", at(16, 17, 0), true},
+		{"0 17 * * fri", at(16, 17, 1), false},
+		{"0 17 * * FRI", at(17, 17, 0), false},
+		{"*/15 9-17 * * 1-5", at(16, 9, 45), true},
+		{"*/15 9-17 * * 1-5", at(16, 9, 50), false},
+		{"*/15 9-17 * * 1-5", at(18, 9, 45), false}, // Sunday
+		{"0 0 * * 7", at(18, 0, 0), true},           // 7 is Sunday too
+		{"0 0 * dec *", at(16, 0, 0), false},
+		{"5/20 * * * *", at(16, 3, 45), true},
+		{"0,30 12 1,16 * *", at(16, 12, 30), true},
+		// Both day fields restricted: either matches
+		{"0 12 1 * fri", at(16, 12, 0), true},
+		{"0 12 1 * mon", at(16, 12, 0), false},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		if got := s.Matches(tt.t); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.expr, tt.t.Format(time.RFC1123), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package deploy
+
+import (
+	"archive/zip"
+	"debug/elf"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// osNames are the operating systems a binary's name may be tagged with
+// alongside its architecture, e.g. api-linux-arm64
+var osNames = map[string]bool{
+	"linux": true, "freebsd": true, "openbsd": true, "netbsd": true, "darwin": true,
+}
+
+// elfArchs maps ELF machines to their GOARCH
+var elfArchs = map[elf.Machine]string{
+	elf.EM_X86_64:  "amd64",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_386:     "386",
+	elf.EM_ARM:     "arm",
+	elf.EM_RISCV:   "riscv64",
+}
+
+// archTokens splits a zip entry name into the words an architecture may be
+// one of
+func archTokens(s string) []string {
+	s = strings.NewReplacer("x86_64", "amd64", "x86-64", "amd64").Replace(strings.ToLower(s))
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == '/' || r == '-' || r == '_' || r == '.'
+	})
+}
+
+// binaryArch reports whether the zip entry name is the binary binName, and
+// the architecture it is tagged with: by a directory such as linux_arm64/api
+// or a suffix such as api-linux-arm64. An untagged binary has arch "".
+func binaryArch(name, binName string) (arch string, ok bool) {
+	base := path.Base(name)
+	if binName == "" || !strings.HasPrefix(base, binName) {
+		return "", false
+	}
+	rest := base[len(binName):]
+	if rest != "" {
+		if !strings.ContainsRune("-_.", rune(rest[0])) {
+			return "", false
+		}
+		// A suffix may only name the platform, so api-arm64.sha256 isn't a binary
+		for _, tok := range archTokens(rest) {
+			if _, isArch := config.ArchName(tok); !isArch && !osNames[tok] {
+				return "", false
+			}
+		}
+	}
+	for _, tok := range archTokens(path.Dir(name) + "/" + rest) {
+		if arch, isArch := config.ArchName(tok); isArch {
+			return arch, true
+		}
+	}
+	return "", true
+}
+
+// findBinary returns the binary's entry in a backend zip: the one at
+// bin.Path, else the one named bin.Name for bin.Arch, else an untagged one.
+// Files under config/ are never the binary.
+func findBinary(zr *zip.Reader, bin BinarySpec) (*zip.File, error) {
+	if bin.Path != "" {
+		for _, f := range zr.File {
+			if path.Clean(f.Name) == path.Clean(bin.Path) && !f.FileInfo().IsDir() {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("binary_path %s not found in zip", bin.Path)
+	}
+
+	var untagged *zip.File
+	byArch := make(map[string]*zip.File)
+	var archs []string
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(path.Clean(f.Name), configPrefix) {
+			continue
+		}
+		arch, ok := binaryArch(f.Name, bin.Name)
+		switch {
+		case !ok:
+		case arch == "":
+			if untagged == nil {
+				untagged = f
+			}
+		case byArch[arch] == nil:
+			byArch[arch] = f
+			archs = append(archs, arch)
+		}
+	}
+
+	if f := byArch[bin.Arch]; f != nil {
+		return f, nil
+	}
+	if untagged != nil {
+		return untagged, nil
+	}
+	if len(archs) > 0 {
+		sort.Strings(archs)
+		return nil, fmt.Errorf("no %s binary for %s in zip (it has %s)", bin.Name, bin.Arch, strings.Join(archs, ", "))
+	}
+	return nil, fmt.Errorf("binary %s not found in zip", bin.Name)
+}
+
+// checkBinaryArch refuses an ELF binary built for another architecture than
+// arch. Other files are not checked.
+func checkBinaryArch(binaryPath, arch string) error {
+	f, err := elf.Open(binaryPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if got, ok := elfArchs[f.Machine]; ok && arch != "" && got != arch {
+		return fmt.Errorf("the binary is built for %s, not %s", got, arch)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// Asset errors
+var (
+	ErrInvalidAssetPath = errors.New("invalid asset path")
+	ErrAssetNotFound    = errors.New("asset not found")
+)
+
+// Asset is a file uploaded with POST /site/assets
+type Asset struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// cleanAssetPath validates an asset path such as "favicon.ico" or
+// ".well-known/security.txt" and returns it relative to the assets directory.
+// Hidden files are refused except under .well-known.
+func cleanAssetPath(p string) (string, error) {
+	p = strings.TrimPrefix(p, "/")
+	if p == "" || strings.Contains(p, "\\") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAssetPath, p)
+	}
+	cleaned := path.Clean(p)
+	if cleaned != p || cleaned == "." {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAssetPath, p)
+	}
+	for i, seg := range strings.Split(cleaned, "/") {
+		if seg == ".." || (strings.HasPrefix(seg, ".") && !(i == 0 && seg == ".well-known")) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidAssetPath, p)
+		}
+	}
+	return filepath.FromSlash(cleaned), nil
+}
+
+// PutAsset writes r to the site's assets directory at assetPath, replacing any
+// existing file. nginx serves it immediately for paths the build doesn't have.
+func PutAsset(site config.SiteConfig, assetPath string, r io.Reader) (int64, error) {
+	rel, err := cleanAssetPath(assetPath)
+	if err != nil {
+		return 0, err
+	}
+	dest := filepath.Join(site.AssetsDir(), rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, fmt.Errorf("create assets directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".upload-*")
+	if err != nil {
+		return 0, fmt.Errorf("create asset: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err != nil {
+		return 0, fmt.Errorf("write asset: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return 0, fmt.Errorf("write asset: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return 0, fmt.Errorf("write asset: %w", err)
+	}
+	return n, nil
+}
+
+// DeleteAsset removes an uploaded asset
+func DeleteAsset(site config.SiteConfig, assetPath string) error {
+	rel, err := cleanAssetPath(assetPath)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(site.AssetsDir(), rel)
+	if info, err := os.Stat(dest); err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s", ErrAssetNotFound, assetPath)
+	}
+	if err := os.Remove(dest); err != nil {
+		return fmt.Errorf("remove asset: %w", err)
+	}
+	return nil
+}
+
+// ListAssets returns the site's uploaded assets, sorted by path
+func ListAssets(site config.SiteConfig) ([]Asset, error) {
+	dir := site.AssetsDir()
+	assets := []Asset{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		assets = append(assets, Asset{Path: "/" + filepath.ToSlash(rel), Size: info.Size(), Modified: info.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list assets: %w", err)
+	}
+	sort.Slice(assets, func(i, j int) bool { return assets[i].Path < assets[j].Path })
+	return assets, nil
+}
@@ -0,0 +1,70 @@
+package deploy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestPutAsset_ListDelete(t *testing.T) {
+	site := config.SiteConfig{FrontendRoot: t.TempDir()}
+
+	if _, err := PutAsset(site, "/.well-known/security.txt", strings.NewReader("Contact: a@b.c\n")); err != nil {
+		t.Fatalf("PutAsset() error = %v", err)
+	}
+	if n, err := PutAsset(site, "favicon.ico", strings.NewReader("ico")); err != nil || n != 3 {
+		t.Fatalf("PutAsset() = %d, %v", n, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(site.FrontendRoot, "_assets", ".well-known", "security.txt"))
+	if err != nil || string(data) != "Contact: a@b.c\n" {
+		t.Errorf("asset content = %q, %v", data, err)
+	}
+
+	assets, err := ListAssets(site)
+	if err != nil || len(assets) != 2 || assets[0].Path != "/.well-known/security.txt" || assets[1].Path != "/favicon.ico" {
+		t.Errorf("ListAssets() = %+v, %v", assets, err)
+	}
+
+	if err := DeleteAsset(site, "/favicon.ico"); err != nil {
+		t.Fatalf("DeleteAsset() error = %v", err)
+	}
+	if err := DeleteAsset(site, "/favicon.ico"); !errors.Is(err, ErrAssetNotFound) {
+		t.Errorf("second DeleteAsset() error = %v, want ErrAssetNotFound", err)
+	}
+}
+
+func TestPutAsset_RejectsPaths(t *testing.T) {
+	site := config.SiteConfig{FrontendRoot: t.TempDir()}
+
+	for _, p := range []string{"", "/", "../etc/passwd", "a/../../b", ".env", "img/.hidden", "a//b", `a\b`, "x/.well-known/y"} {
+		if _, err := PutAsset(site, p, strings.NewReader("x")); !errors.Is(err, ErrInvalidAssetPath) {
+			t.Errorf("PutAsset(%q) error = %v, want ErrInvalidAssetPath", p, err)
+		}
+	}
+}
+
+func TestSiteUsage_CountsAssetsSeparately(t *testing.T) {
+	cfg := usageConfig(t, 0)
+	site := cfg.Site["example.com"]
+	if _, err := PutAsset(site, "robots.txt", strings.NewReader("User-agent: *\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := SiteUsage(cfg, "example.com")
+	if err != nil {
+		t.Fatalf("SiteUsage() error = %v", err)
+	}
+	for _, c := range u.Commits {
+		if c.Commit == config.AssetsDirName {
+			t.Error("the assets directory should not be listed as a commit")
+		}
+	}
+	if u.AssetsBytes != 14 || u.TotalBytes != u.FrontendBytes+14 {
+		t.Errorf("AssetsBytes = %d, TotalBytes = %d, FrontendBytes = %d", u.AssetsBytes, u.TotalBytes, u.FrontendBytes)
+	}
+}
@@ -2,135 +2,335 @@ package deploy
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"time"
 
+	"github.com/lachierussell/shipyard/artifact"
 	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/health"
 	"github.com/lachierussell/shipyard/jail"
-	"github.com/lachierussell/shipyard/service"
+	"github.com/lachierussell/shipyard/journal"
+	"github.com/lachierussell/shipyard/logger"
+	"github.com/lachierussell/shipyard/nginx"
 )
 
 // BackendDeployer handles backend service deployment
 type BackendDeployer struct {
-	cfg *config.Config
+	cfg      *config.Config
+	jails    jail.Driver
+	services ServiceManager
+	firewall Firewall
+	slots    chan struct{} // bounds deploys running at once
 }
 
-// NewBackendDeployer creates a new backend deployer
-func NewBackendDeployer(cfg *config.Config) *BackendDeployer {
-	return &BackendDeployer{cfg: cfg}
+// NewBackendDeployer creates a new backend deployer. Nil managers in mgrs
+// get the real ones.
+func NewBackendDeployer(cfg *config.Config, mgrs Managers) *BackendDeployer {
+	mgrs = mgrs.withDefaults(cfg)
+	return &BackendDeployer{
+		cfg:      cfg,
+		jails:    mgrs.Driver,
+		services: mgrs.Service,
+		firewall: mgrs.Firewall,
+		slots:    make(chan struct{}, cfg.Deploy.BackendLimit()),
+	}
+}
+
+// BinarySpec says where the binary is in a backend artifact
+type BinarySpec struct {
+	Name   string // base name looked for anywhere in a zip
+	Path   string // exact path in a zip; overrides Name
+	Format string // artifact.FormatBinary if the artifact is the binary itself
+	Arch   string // GOARCH picked from zips with a binary per architecture; the site's when empty
+}
+
+// BackendResult is what a backend deploy did besides replacing the binary
+type BackendResult struct {
+	Migration *MigrationResult `json:"migration,omitempty"` // nil without migrate_command
+	// Healthy reports whether the new backend passed its health check within
+	// its startup grace; HealthError says why it didn't
+	Healthy     bool   `json:"healthy"`
+	HealthError string `json:"health_error,omitempty"`
 }
 
-// Deploy extracts a backend binary, deploys it into a pot, and starts the service
-func (bd *BackendDeployer) Deploy(siteName string, commitHash string, artifactReader io.Reader, binaryName string) error {
+// Deploy extracts a backend binary, deploys it into a pot, and starts the
+// service. The result is returned with the error when the migration fails.
+// It logs through ctx's logger (logger.FromContext), which should name the
+// site and commit.
+func (bd *BackendDeployer) Deploy(ctx context.Context, siteName string, commitHash string, artifactReader io.Reader, bin BinarySpec) (*BackendResult, error) {
 	site, ok := bd.cfg.Site[siteName]
 	if !ok {
-		return fmt.Errorf("site not found: %s", siteName)
+		return nil, fmt.Errorf("site not found: %s", siteName)
 	}
 
 	if site.Backend == nil {
-		return fmt.Errorf("site %s has no backend config", siteName)
+		return nil, fmt.Errorf("site %s has no backend config", siteName)
 	}
 
-	log := slog.With("site", siteName, "commit", commitHash)
-	log.Info("backend deployment starting", "binary", binaryName)
+	if bin.Arch == "" {
+		bin.Arch = site.Backend.BinaryArch()
+	}
+
+	log := logger.FromContext(ctx)
+	defer acquireSlot(bd.slots, log)()
+	log.Info("backend deployment starting", "binary", bin.Name, "binary_path", bin.Path, "format", bin.Format, "arch", bin.Arch)
 
-	jailMgr := jail.NewManager(bd.cfg)
-	svcMgr := service.NewManager(bd.cfg)
+	jailMgr, svcMgr := bd.jails, bd.services
+
+	// Journal each destructive step so an interrupted deploy can be recovered on startup
+	j := journalFor(bd.cfg)
+	entry, err := j.Begin(journal.KindBackend, siteName, commitHash)
+	if err != nil {
+		return nil, fmt.Errorf("journal: %w", err)
+	}
+	defer j.Complete(entry)
 
 	// Ensure pot exists
-	if err := jailMgr.EnsureExists(siteName); err != nil {
-		return fmt.Errorf("ensure pot: %w", err)
+	if err := jailMgr.EnsureExists(ctx, siteName); err != nil {
+		return nil, fmt.Errorf("ensure pot: %w", err)
 	}
 
 	// Extract binary to a temp file first
-	tempBinary, err := bd.extractBinaryToTemp(artifactReader, binaryName)
+	tempBinary, zr, err := bd.extractBinaryToTemp(log, artifactReader, bin)
 	if err != nil {
-		return fmt.Errorf("extract binary: %w", err)
+		return nil, fmt.Errorf("extract binary: %w", err)
 	}
 	defer os.Remove(tempBinary)
 
+	// Render config files before anything is stopped, so a broken template
+	// fails the deploy while the old backend keeps running
+	configs, err := extractConfigFiles(zr, configData(siteName, site, commitHash))
+	if err != nil {
+		return nil, fmt.Errorf("extract config files: %w", err)
+	}
+	defer removeConfigFiles(configs)
+
+	// Secrets are looked up now too, so a missing one fails the deploy
+	// before the old backend stops
+	env, err := svcMgr.WriteEnv(siteName)
+	if err != nil {
+		return nil, err
+	}
+
 	// Make it executable
 	if err := os.Chmod(tempBinary, 0755); err != nil {
-		return fmt.Errorf("chmod binary: %w", err)
+		return nil, fmt.Errorf("chmod binary: %w", err)
+	}
+
+	// With restart_response = "503", nginx answers 503 from here until the
+	// new backend listens, rather than 502 from the closed port
+	unavailable := site.Backend.UnavailableDuringRestart()
+	if unavailable {
+		if err := nginx.SetMaintenance(siteName, true); err != nil {
+			log.Warn("backend maintenance flag not set", "error", err)
+		}
+		defer nginx.SetMaintenance(siteName, false)
 	}
 
 	// Stop the service (but keep pot running so we can copy)
+	if err := j.Step(entry, journal.StepServiceStop); err != nil {
+		return nil, fmt.Errorf("journal: %w", err)
+	}
 	svcMgr.Stop(siteName)
 
 	// Ensure pot is started so we can copy the binary
-	if err := jailMgr.Start(siteName); err != nil {
-		return fmt.Errorf("start pot for copy: %w", err)
+	if err := jailMgr.Start(ctx, siteName); err != nil {
+		return nil, fmt.Errorf("start pot for copy: %w", err)
 	}
 
+	// A read_only root is opened for the copy and locked again before the
+	// backend starts, or on the way out if the deploy fails first
+	if err := jailMgr.UnlockRoot(siteName); err != nil {
+		return nil, fmt.Errorf("unlock pot root: %w", err)
+	}
+	locked := false
+	defer func() {
+		if !locked {
+			jailMgr.LockRoot(siteName)
+		}
+	}()
+
 	// Ensure /usr/local/bin exists inside the pot
-	if err := jailMgr.Exec(siteName, "mkdir", "-p", "/usr/local/bin"); err != nil {
+	if err := jailMgr.Exec(ctx, siteName, "mkdir", "-p", "/usr/local/bin"); err != nil {
 		log.Warn("mkdir in pot failed", "error", err)
 	}
 
-	// Copy binary into pot
+	// The run_as user must exist before the binary is handed to it
+	if err := jailMgr.EnsureUser(ctx, siteName); err != nil {
+		return nil, fmt.Errorf("prepare run_as user: %w", err)
+	}
+
+	// Keep the current binary so recovery can restore it
 	destPath := filepath.Join("/usr/local/bin", site.Backend.BinaryName)
-	if err := jailMgr.CopyIn(siteName, tempBinary, destPath); err != nil {
-		return fmt.Errorf("copy binary to pot: %w", err)
+	if err := jailMgr.Exec(ctx, siteName, "cp", "-p", destPath, destPath+".prev"); err == nil {
+		entry.PrevBinary = true
+	}
+	if err := j.Step(entry, journal.StepBinaryCopy); err != nil {
+		return nil, fmt.Errorf("journal: %w", err)
+	}
+
+	// Copy binary into pot
+	if err := jailMgr.CopyIn(ctx, siteName, tempBinary, destPath); err != nil {
+		return nil, fmt.Errorf("copy binary to pot: %w", err)
+	}
+
+	// Config files go next to the binary's config dir, owned by run_as too.
+	// Files dropped from config/ since the last deploy are left in place.
+	for _, cf := range configs {
+		if err := jailMgr.Exec(ctx, siteName, "mkdir", "-p", path.Dir(cf.dest)); err != nil {
+			return nil, fmt.Errorf("create config directory: %w", err)
+		}
+		if err := jailMgr.CopyIn(ctx, siteName, cf.temp, cf.dest); err != nil {
+			return nil, fmt.Errorf("copy config file to pot: %w", err)
+		}
+	}
+	if len(configs) > 0 {
+		log.Info("backend config files copied", "dir", site.Backend.ConfigPath(), "files", len(configs))
 	}
 
 	// Create rc.d script on host
 	if err := svcMgr.CreateBackendService(siteName); err != nil {
-		return fmt.Errorf("create rc.d script: %w", err)
+		return nil, fmt.Errorf("create rc.d script: %w", err)
 	}
 
 	// Enable service
 	if err := svcMgr.Enable(siteName); err != nil {
-		return fmt.Errorf("enable service: %w", err)
+		return nil, fmt.Errorf("enable service: %w", err)
 	}
 
 	// Ensure /var/log exists inside the pot for daemon output
-	if err := jailMgr.Exec(siteName, "mkdir", "-p", "/var/log"); err != nil {
+	if err := jailMgr.Exec(ctx, siteName, "mkdir", "-p", "/var/log"); err != nil {
 		log.Warn("mkdir /var/log in pot failed", "error", err)
 	}
 
-	// Start the binary directly inside the pot using daemon
-	// This is more reliable than going through the rc.d script
-	port := fmt.Sprintf("%d", site.Backend.ListenPort)
-	if err := jailMgr.Exec(siteName, "env", "PORT="+port, "HOST=0.0.0.0",
-		"/usr/sbin/daemon", "-r", "-R", "5", "-o", "/var/log/app.log", "-f", destPath); err != nil {
-		return fmt.Errorf("start service in pot: %w", err)
+	if err := jailMgr.LockRoot(siteName); err != nil {
+		return nil, fmt.Errorf("lock pot root: %w", err)
 	}
+	locked = true
 
-	// Poll health check
-	if err := bd.waitForHealth(siteName, 10, 1*time.Second); err != nil {
-		// Service is starting but not yet healthy - return success anyway
-		return nil
+	// The backend never runs without its outbound allowlist
+	if err := bd.firewall.Apply(siteName); err != nil {
+		return nil, fmt.Errorf("load firewall rules: %w", err)
 	}
 
+	// Migrations run against the new binary and config before it starts. A
+	// failed one puts the previous binary back, which starts as before.
+	res := &BackendResult{}
+	if site.Backend.MigrateCommand != "" {
+		res.Migration = runMigration(ctx, jailMgr, siteName, *site.Backend, env)
+		if res.Migration.Error != "" {
+			log.Error("migration failed", "error", res.Migration.Error)
+			if !entry.PrevBinary {
+				return res, fmt.Errorf("migration failed: %s", res.Migration.Error)
+			}
+			// Put the binary back even if ctx has ended
+			restore := func() error {
+				return jailMgr.Exec(context.WithoutCancel(ctx), siteName, "cp", "-p", destPath+".prev", destPath)
+			}
+			if err := jailMgr.Writable(siteName, restore); err != nil {
+				return res, fmt.Errorf("migration failed: %s; restore previous binary: %w", res.Migration.Error, err)
+			}
+			if err := bd.startBackend(siteName); err != nil {
+				return res, fmt.Errorf("migration failed: %s; previous binary restored but %w", res.Migration.Error, err)
+			}
+			return res, fmt.Errorf("migration failed: %s; previous binary restored", res.Migration.Error)
+		}
+		log.Info("migration finished", "duration_seconds", res.Migration.Duration)
+	}
+
+	if err := j.Step(entry, journal.StepServiceStart); err != nil {
+		return nil, fmt.Errorf("journal: %w", err)
+	}
+	if err := bd.startBackend(siteName); err != nil {
+		return res, err
+	}
+	if unavailable && !waitListening(*site.Backend, maintenanceMaxWait) {
+		log.Warn("backend not listening yet; ending maintenance anyway", "waited", maintenanceMaxWait)
+	}
+
+	// A backend that is still starting is deployed all the same; the result
+	// says it wasn't healthy yet
+	if err := health.WaitHealthy(site.Backend, bd.cfg.Health, site.Backend.StartupGraceDuration(bd.cfg.Health)); err != nil {
+		log.Warn("backend not healthy after deploy", "error", err)
+		res.HealthError = err.Error()
+		return res, nil
+	}
+	res.Healthy = true
+	return res, nil
+}
+
+// startBackend starts the backend through its rc.d script or systemd unit,
+// so that the service's stop and status act on the process that runs
+func (bd *BackendDeployer) startBackend(siteName string) error {
+	if err := bd.services.Start(siteName); err != nil {
+		return fmt.Errorf("start service: %w", err)
+	}
 	return nil
 }
 
-// extractBinaryToTemp extracts a binary from a zip to a temp file
-func (bd *BackendDeployer) extractBinaryToTemp(reader io.Reader, binaryName string) (string, error) {
+// extractBinaryToTemp writes the binary of a backend artifact to a temp
+// file, refusing one built for another architecture than bin.Arch. It also
+// returns the artifact's zip, or nil for a bare executable.
+func (bd *BackendDeployer) extractBinaryToTemp(log *slog.Logger, reader io.Reader, bin BinarySpec) (string, *zip.Reader, error) {
+	tempBinary, zr, err := bd.binaryToTemp(log, reader, bin)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkBinaryArch(tempBinary, bin.Arch); err != nil {
+		os.Remove(tempBinary)
+		return "", nil, err
+	}
+	return tempBinary, zr, nil
+}
+
+// binaryToTemp copies a bare executable, or the binary found in a zip, to a
+// temp file
+func (bd *BackendDeployer) binaryToTemp(log *slog.Logger, reader io.Reader, bin BinarySpec) (string, *zip.Reader, error) {
+	// A bare executable is copied as it is
+	if bin.Format == artifact.FormatBinary {
+		br := bufio.NewReader(reader)
+		magic, _ := br.Peek(4)
+		switch {
+		case len(magic) == 0:
+			return "", nil, fmt.Errorf("the artifact is empty")
+		case bytes.Equal(magic, []byte("PK\x03\x04")):
+			return "", nil, fmt.Errorf("the artifact is a zip; send it without artifact_format=binary")
+		}
+		tempBinary, err := copyToTempFile(br)
+		return tempBinary, nil, err
+	}
+
 	// Read zip from stream
 	data, err := io.ReadAll(reader)
 	if err != nil {
-		return "", fmt.Errorf("read artifact: %w", err)
+		return "", nil, fmt.Errorf("read artifact: %w", err)
 	}
 
 	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
-		return "", fmt.Errorf("invalid zip: %w", err)
-	}
-
-	// Find the binary in the zip
-	for _, f := range zr.File {
-		if filepath.Base(f.Name) == binaryName {
-			return bd.extractToTempFile(f)
+		if bytes.HasPrefix(data, []byte("\x7fELF")) {
+			return "", nil, fmt.Errorf("invalid zip: the artifact is an executable; send artifact_format=binary")
 		}
+		return "", nil, fmt.Errorf("invalid zip: %w", err)
 	}
 
-	return "", fmt.Errorf("binary %s not found in zip", binaryName)
+	f, err := findBinary(zr, bin)
+	if err != nil {
+		return "", nil, err
+	}
+	log.Info("backend binary found", "entry", f.Name)
+	tempBinary, err := bd.extractToTempFile(f)
+	return tempBinary, zr, err
 }
 
 // extractToTempFile extracts a single file from a zip to a temp file
@@ -141,6 +341,11 @@ func (bd *BackendDeployer) extractToTempFile(f *zip.File) (string, error) {
 	}
 	defer src.Close()
 
+	return copyToTempFile(src)
+}
+
+// copyToTempFile copies src to a new temp file and returns its path
+func copyToTempFile(src io.Reader) (string, error) {
 	// Create temp file
 	tmpFile, err := os.CreateTemp("", "shipyard-binary-*")
 	if err != nil {
@@ -156,23 +361,23 @@ func (bd *BackendDeployer) extractToTempFile(f *zip.File) (string, error) {
 	return tmpFile.Name(), nil
 }
 
-// waitForHealth polls the service's health endpoint
-func (bd *BackendDeployer) waitForHealth(siteName string, maxAttempts int, interval time.Duration) error {
-	site, ok := bd.cfg.Site[siteName]
-	if !ok {
-		return fmt.Errorf("site not found: %s", siteName)
-	}
-
-	if site.Backend == nil {
-		return nil // No backend to check
+// maintenanceMaxWait bounds how long a site stays in maintenance waiting for
+// its new backend to listen
+const maintenanceMaxWait = 30 * time.Second
+
+// waitListening reports whether the backend's port accepts connections
+// within timeout
+func waitListening(backend config.BackendConfig, timeout time.Duration) bool {
+	addr := net.JoinHostPort(backend.JailIP, strconv.Itoa(backend.ListenPort))
+	deadline := time.Now().Add(timeout)
+	for {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(250 * time.Millisecond)
 	}
-
-	// Health check would go here - for now just return success
-	// In a full implementation, this would:
-	// 1. Make HTTP request to http://{jail_ip}:{listen_port}/health
-	// 2. Check for 200 response
-	// 3. Retry on failure up to maxAttempts times
-	// 4. Return error if all attempts fail
-
-	return nil
 }
@@ -0,0 +1,131 @@
+package deploy
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// configPrefix is the directory of a backend zip holding its config files
+const configPrefix = "config/"
+
+// maxConfigFileSize bounds each config file, which is read into memory to
+// be rendered
+const maxConfigFileSize = 1 << 20
+
+// ConfigData is what a backend's .tmpl config files are rendered with,
+// using <% %> delimiters, e.g. listen = "<% .JailIP %>:<% .ListenPort %>"
+type ConfigData struct {
+	Domain     string
+	Aliases    []string
+	Commit     string
+	JailIP     string
+	ListenPort int
+	ProxyPath  string
+	BinaryName string
+	ConfigDir  string
+}
+
+// configFile is a config file of a backend zip, rendered to a temp file
+type configFile struct {
+	temp string // on the host
+	dest string // inside the jail
+}
+
+// configData returns the template data of a site's config files
+func configData(siteName string, site config.SiteConfig, commit string) ConfigData {
+	return ConfigData{
+		Domain:     siteName,
+		Aliases:    site.Aliases,
+		Commit:     commit,
+		JailIP:     site.Backend.JailIP,
+		ListenPort: site.Backend.ListenPort,
+		ProxyPath:  site.Backend.ProxyPath,
+		BinaryName: site.Backend.BinaryName,
+		ConfigDir:  site.Backend.ConfigPath(),
+	}
+}
+
+// extractConfigFiles writes the files under config/ in a backend zip to temp
+// files, rendering those ending in .tmpl (the suffix is dropped). A nil zip,
+// as for a bare executable, has none. The caller removes the temp files.
+func extractConfigFiles(zr *zip.Reader, data ConfigData) ([]configFile, error) {
+	if zr == nil {
+		return nil, nil
+	}
+
+	var files []configFile
+	fail := func(err error) ([]configFile, error) {
+		removeConfigFiles(files)
+		return nil, err
+	}
+	for _, f := range zr.File {
+		// Cleaning folds "config/../x" to "x", so nothing escapes config/
+		name := path.Clean(f.Name)
+		if !strings.HasPrefix(name, configPrefix) || f.FileInfo().IsDir() {
+			continue
+		}
+		rel := strings.TrimPrefix(name, configPrefix)
+		if !f.Mode().IsRegular() {
+			return fail(fmt.Errorf("config file %s is not a regular file", rel))
+		}
+		content, err := readZipFile(f)
+		if err != nil {
+			return fail(fmt.Errorf("read config file %s: %w", rel, err))
+		}
+		if len(content) > maxConfigFileSize {
+			return fail(fmt.Errorf("config file %s is larger than %dKB", rel, maxConfigFileSize>>10))
+		}
+		if strings.HasSuffix(rel, ".tmpl") {
+			rel = strings.TrimSuffix(rel, ".tmpl")
+			content, err = renderConfigFile(rel, content, data)
+			if err != nil {
+				return fail(err)
+			}
+		}
+
+		temp, err := copyToTempFile(bytes.NewReader(content))
+		if err != nil {
+			return fail(err)
+		}
+		files = append(files, configFile{temp: temp, dest: path.Join(data.ConfigDir, rel)})
+	}
+	return files, nil
+}
+
+// renderConfigFile renders a .tmpl config file with data
+func renderConfigFile(name string, content []byte, data ConfigData) ([]byte, error) {
+	tmpl, err := template.New(name).Delims("<%", "%>").Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("parse config template %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("render config template %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// readZipFile reads a zip entry, stopping one byte past maxConfigFileSize
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxConfigFileSize+1))
+}
+
+// removeConfigFiles removes the temp files of extracted config files
+func removeConfigFiles(files []configFile) {
+	for _, f := range files {
+		os.Remove(f.temp)
+	}
+}
@@ -0,0 +1,196 @@
+package deploy
+
+import (
+	"archive/zip"
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/artifact"
+)
+
+func TestExtractBinaryToTemp(t *testing.T) {
+	bd := &BackendDeployer{}
+	elf := "\x7fELF binary"
+	nested := createTestZip(t, map[string]string{
+		"README.md":          "readme",
+		"bin/linux/api":      "linux",
+		"bin/darwin/api":     "darwin",
+		"bin/linux/api.sha1": "sum",
+	})
+
+	tests := []struct {
+		name     string
+		artifact []byte
+		bin      BinarySpec
+		want     string
+		wantErr  string
+	}{
+		{"zip by name", nested.Bytes(), BinarySpec{Name: "README.md"}, "readme", ""},
+		{"zip by path", nested.Bytes(), BinarySpec{Name: "api", Path: "bin/darwin/api"}, "darwin", ""},
+		{"zip by unclean path", nested.Bytes(), BinarySpec{Path: "./bin//linux/api"}, "linux", ""},
+		{"path not in zip", nested.Bytes(), BinarySpec{Path: "bin/api"}, "", "binary_path bin/api not found"},
+		{"path is a directory", nested.Bytes(), BinarySpec{Path: "bin/linux/"}, "", "not found"},
+		{"name not in zip", nested.Bytes(), BinarySpec{Name: "web"}, "", "binary web not found"},
+		{"raw binary", []byte(elf), BinarySpec{Format: artifact.FormatBinary}, elf, ""},
+		{"empty binary", nil, BinarySpec{Format: artifact.FormatBinary}, "", "empty"},
+		{"zip sent as binary", nested.Bytes(), BinarySpec{Format: artifact.FormatBinary}, "", "is a zip"},
+		{"binary sent as zip", []byte(elf), BinarySpec{Name: "api"}, "", "artifact_format=binary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, _, err := bd.extractBinaryToTemp(slog.Default(), bytes.NewReader(tt.artifact), tt.bin)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractBinaryToTemp: %v", err)
+			}
+			defer os.Remove(path)
+			got, _ := os.ReadFile(path)
+			if string(got) != tt.want {
+				t.Errorf("binary = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractBinaryToTemp_Arch(t *testing.T) {
+	bd := &BackendDeployer{}
+	bySuffix := createTestZip(t, map[string]string{
+		"api-linux-amd64":        "amd64",
+		"api-linux-arm64":        "arm64",
+		"api-linux-arm64.sha256": "sum",
+	})
+	byDir := createTestZip(t, map[string]string{
+		"dist/linux_x86_64/api":  "amd64",
+		"dist/linux_aarch64/api": "arm64",
+	})
+	withFallback := createTestZip(t, map[string]string{
+		"arm64/api": "arm64",
+		"api":       "generic",
+	})
+
+	tests := []struct {
+		name     string
+		artifact *bytes.Buffer
+		arch     string
+		want     string
+		wantErr  string
+	}{
+		{"suffix amd64", bySuffix, "amd64", "amd64", ""},
+		{"suffix arm64", bySuffix, "arm64", "arm64", ""},
+		{"directory amd64", byDir, "amd64", "amd64", ""},
+		{"directory arm64", byDir, "arm64", "arm64", ""},
+		{"tagged over untagged", withFallback, "arm64", "arm64", ""},
+		{"untagged fallback", withFallback, "amd64", "generic", ""},
+		{"missing arch", bySuffix, "riscv64", "", "no api binary for riscv64 in zip (it has amd64, arm64)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, _, err := bd.extractBinaryToTemp(slog.Default(), bytes.NewReader(tt.artifact.Bytes()), BinarySpec{Name: "api", Arch: tt.arch})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractBinaryToTemp: %v", err)
+			}
+			defer os.Remove(path)
+			got, _ := os.ReadFile(path)
+			if string(got) != tt.want {
+				t.Errorf("binary = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckBinaryArch(t *testing.T) {
+	// The test binary is an ELF file for the host's architecture
+	if runtime.GOOS != "linux" && runtime.GOOS != "freebsd" {
+		t.Skip("test binary is not ELF")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBinaryArch(exe, runtime.GOARCH); err != nil {
+		t.Errorf("host binary: %v", err)
+	}
+	other := "arm64"
+	if runtime.GOARCH == "arm64" {
+		other = "amd64"
+	}
+	if err := checkBinaryArch(exe, other); err == nil {
+		t.Errorf("binary for %s accepted as %s", runtime.GOARCH, other)
+	}
+
+	script := filepath.Join(t.TempDir(), "run.sh")
+	os.WriteFile(script, []byte("#!/bin/sh\n"), 0755)
+	if err := checkBinaryArch(script, other); err != nil {
+		t.Errorf("script: %v", err)
+	}
+}
+
+func TestExtractConfigFiles(t *testing.T) {
+	data := ConfigData{Domain: "api.example.com", Commit: "abc123", JailIP: "10.0.0.5", ListenPort: 8080, ConfigDir: "/usr/local/etc/api"}
+	buf := createTestZip(t, map[string]string{
+		"api":                      "binary",
+		"config/api":               "not the binary",
+		"config/app.toml.tmpl":     `listen = "<% .JailIP %>:<% .ListenPort %>" # <% .Domain %>@<% .Commit %>`,
+		"config/certs/ca.pem":      "<% left alone %>",
+		"config/../escape.conf":    "outside",
+		"other/config/ignored.txt": "ignored",
+	})
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The binary is never picked from config/
+	bin, err := findBinary(zr, BinarySpec{Name: "api"})
+	if err != nil || bin.Name != "api" {
+		t.Fatalf("findBinary = %v, %v; want api", bin, err)
+	}
+
+	files, err := extractConfigFiles(zr, data)
+	if err != nil {
+		t.Fatalf("extractConfigFiles: %v", err)
+	}
+	defer removeConfigFiles(files)
+	got := make(map[string]string)
+	for _, f := range files {
+		content, _ := os.ReadFile(f.temp)
+		got[f.dest] = string(content)
+	}
+	want := map[string]string{
+		"/usr/local/etc/api/api":          "not the binary",
+		"/usr/local/etc/api/app.toml":     `listen = "10.0.0.5:8080" # api.example.com@abc123`,
+		"/usr/local/etc/api/certs/ca.pem": "<% left alone %>",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config files = %v, want %v", got, want)
+	}
+
+	// A broken template fails before anything is written
+	bad := createTestZip(t, map[string]string{"config/app.conf.tmpl": "<% .Secret %>"})
+	zr, _ = zip.NewReader(bytes.NewReader(bad.Bytes()), int64(bad.Len()))
+	if _, err := extractConfigFiles(zr, data); err == nil || !strings.Contains(err.Error(), "app.conf") {
+		t.Errorf("broken template: err = %v", err)
+	}
+
+	// Bare executables carry no config files
+	if files, err := extractConfigFiles(nil, data); files != nil || err != nil {
+		t.Errorf("no zip: %v, %v", files, err)
+	}
+}
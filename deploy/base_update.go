@@ -0,0 +1,249 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/health"
+	"github.com/lachierussell/shipyard/jail"
+	"github.com/lachierussell/shipyard/service"
+)
+
+// Base update rollout states
+const (
+	BaseUpdateRunning   = "running"
+	BaseUpdatePaused    = "paused"
+	BaseUpdateCompleted = "completed"
+)
+
+// Outcomes for each jail in a base update
+const (
+	JailPending = "pending"
+	JailUpdated = "updated" // updates installed and the backend restarted
+	JailCurrent = "current" // nothing to install
+	JailFailed  = "failed"
+	JailSkipped = "skipped"
+)
+
+// baseUpdateHealthTimeout is how long a restarted backend has to pass its health check
+const baseUpdateHealthTimeout = time.Minute
+
+// JailUpdate is the outcome of updating one jail's base
+type JailUpdate struct {
+	Site   string `json:"site"`
+	Status string `json:"status"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	// Verified is set when the backend passed its health check before and after
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BaseUpdateStatus is a snapshot of a base update rollout
+type BaseUpdateStatus struct {
+	State    string       `json:"state"`
+	Started  time.Time    `json:"started"`
+	Finished *time.Time   `json:"finished,omitempty"`
+	Jails    []JailUpdate `json:"jails"`
+}
+
+// BaseUpdate runs freebsd-update across backend jails one at a time. A jail
+// whose backend is unhealthy after its update pauses the rollout, leaving the
+// remaining jails untouched until it is resumed.
+type BaseUpdate struct {
+	mu       sync.Mutex
+	cfg      *config.Config
+	jails    *jail.Manager
+	services *service.Manager
+	state    string
+	started  time.Time
+	finished *time.Time
+	results  []JailUpdate
+	next     int
+}
+
+// NewBaseUpdate plans a rollout over the named sites, or every backend if
+// sites is empty. Sites without a backend are left out.
+func NewBaseUpdate(cfg *config.Config, sites []string) (*BaseUpdate, error) {
+	if len(sites) == 0 {
+		for name := range cfg.Site {
+			sites = append(sites, name)
+		}
+	}
+	sort.Strings(sites)
+	if cfg.Jail.UsesContainers() {
+		return nil, fmt.Errorf("base updates need the pot driver; rebuild jail.image instead")
+	}
+
+	u := &BaseUpdate{
+		cfg:      cfg,
+		jails:    jail.NewManager(cfg),
+		services: service.NewManager(cfg),
+		state:    BaseUpdateRunning,
+		started:  time.Now(),
+	}
+	for _, name := range sites {
+		site, ok := cfg.Site[name]
+		if !ok {
+			return nil, fmt.Errorf("site not found: %s", name)
+		}
+		if site.Backend != nil {
+			u.results = append(u.results, JailUpdate{Site: name, Status: JailPending})
+		}
+	}
+	u.finishIfDone()
+	return u, nil
+}
+
+// Done reports whether the rollout has stopped, paused or completed
+func (u *BaseUpdate) Done() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.state != BaseUpdateRunning
+}
+
+// Current returns the site Step updates next
+func (u *BaseUpdate) Current() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.state != BaseUpdateRunning {
+		return ""
+	}
+	return u.results[u.next].Site
+}
+
+// Step updates the next jail. It returns the jail's error after pausing the rollout.
+func (u *BaseUpdate) Step() error {
+	u.mu.Lock()
+	if u.state != BaseUpdateRunning {
+		u.mu.Unlock()
+		return nil
+	}
+	result := u.results[u.next]
+	u.mu.Unlock()
+
+	log := slog.With("site", result.Site)
+	err := u.updateJail(&result)
+	if err != nil {
+		result.Status = JailFailed
+		result.Error = err.Error()
+		log.Error("jail base update failed, pausing rollout", "error", err)
+	} else {
+		log.Info("jail base update finished", "status", result.Status, "from", result.From, "to", result.To)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.results[u.next] = result
+	if err != nil {
+		u.state = BaseUpdatePaused
+		return err
+	}
+	u.next++
+	u.finishIfDone()
+	return nil
+}
+
+// Resume continues a paused rollout, retrying the failed jail or skipping it
+func (u *BaseUpdate) Resume(skip bool) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.state != BaseUpdatePaused {
+		return fmt.Errorf("rollout is %s, not paused", u.state)
+	}
+	if skip {
+		u.results[u.next].Status = JailSkipped
+		u.next++
+	} else {
+		u.results[u.next] = JailUpdate{Site: u.results[u.next].Site, Status: JailPending}
+	}
+	u.state = BaseUpdateRunning
+	u.finishIfDone()
+	return nil
+}
+
+// Status returns a snapshot of the rollout
+func (u *BaseUpdate) Status() BaseUpdateStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return BaseUpdateStatus{
+		State:    u.state,
+		Started:  u.started,
+		Finished: u.finished,
+		Jails:    append([]JailUpdate(nil), u.results...),
+	}
+}
+
+// finishIfDone completes the rollout once every jail is done. Callers hold u.mu.
+func (u *BaseUpdate) finishIfDone() {
+	if u.state == BaseUpdateRunning && u.next >= len(u.results) {
+		now := time.Now()
+		u.state = BaseUpdateCompleted
+		u.finished = &now
+	}
+}
+
+// updateJail installs a jail's base updates and restarts a running backend
+// to pick them up, checking it is as healthy afterwards as it was before
+func (u *BaseUpdate) updateJail(result *JailUpdate) error {
+	name := result.Site
+	site, ok := u.cfg.Site[name]
+	if !ok || site.Backend == nil {
+		// Destroyed since the rollout was planned
+		result.Status = JailSkipped
+		return nil
+	}
+
+	from, err := u.jails.UserlandVersion(name)
+	if err != nil {
+		return err
+	}
+	result.From = from
+	running := u.jails.IsRunning(name)
+	healthy := running && health.Probe(site.Backend, u.cfg.Health, 2*time.Second) == nil
+
+	var installed bool
+	err = u.jails.Writable(name, func() error {
+		var err error
+		installed, err = u.jails.UpdateBase(name)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if result.To, err = u.jails.UserlandVersion(name); err != nil {
+		return err
+	}
+	if !installed {
+		result.Status = JailCurrent
+		result.Verified = healthy
+		return nil
+	}
+	result.Status = JailUpdated
+	if !running {
+		return nil
+	}
+
+	// The rc.d stop takes the pot down with the backend, so every process
+	// comes back on the updated userland
+	u.services.Stop(name)
+	if err := u.jails.Start(context.Background(), name); err != nil {
+		return fmt.Errorf("restart pot: %w", err)
+	}
+	if err := u.services.Start(name); err != nil {
+		return fmt.Errorf("restart backend: %w", err)
+	}
+	if !healthy {
+		return nil // nothing to compare against
+	}
+	if err := health.WaitHealthy(site.Backend, u.cfg.Health, baseUpdateHealthTimeout); err != nil {
+		return fmt.Errorf("backend unhealthy after update: %w", err)
+	}
+	result.Verified = true
+	return nil
+}
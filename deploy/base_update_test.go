@@ -0,0 +1,89 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func baseUpdateConfig() *config.Config {
+	return &config.Config{Site: map[string]config.SiteConfig{
+		"www.example.com":  {FrontendRoot: "/var/www/www"},
+		"b.example.com":    {Backend: &config.BackendConfig{ListenPort: 8081}},
+		"a.example.com":    {Backend: &config.BackendConfig{ListenPort: 8080}},
+		"docs.example.com": {FrontendRoot: "/var/www/docs"},
+	}}
+}
+
+func TestNewBaseUpdate_PlansBackendsInOrder(t *testing.T) {
+	u, err := NewBaseUpdate(baseUpdateConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := u.Status()
+	if status.State != BaseUpdateRunning || len(status.Jails) != 2 ||
+		status.Jails[0].Site != "a.example.com" || status.Jails[1].Site != "b.example.com" {
+		t.Errorf("status = %+v", status)
+	}
+	if u.Current() != "a.example.com" {
+		t.Errorf("Current() = %q", u.Current())
+	}
+
+	if _, err := NewBaseUpdate(baseUpdateConfig(), []string{"missing.example.com"}); err == nil {
+		t.Error("NewBaseUpdate accepted an unknown site")
+	}
+
+	u, err = NewBaseUpdate(baseUpdateConfig(), []string{"www.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Done() || u.Status().State != BaseUpdateCompleted || u.Status().Finished == nil {
+		t.Errorf("rollout without backends = %+v", u.Status())
+	}
+}
+
+func TestBaseUpdate_Resume(t *testing.T) {
+	u, _ := NewBaseUpdate(baseUpdateConfig(), nil)
+	if err := u.Resume(false); err == nil {
+		t.Error("Resume() of a running rollout should fail")
+	}
+
+	// As Step leaves it after the first jail fails
+	u.state = BaseUpdatePaused
+	u.results[0].Status = JailFailed
+	u.results[0].Error = "backend unhealthy after update"
+
+	if err := u.Resume(false); err != nil {
+		t.Fatal(err)
+	}
+	if u.Current() != "a.example.com" || u.Status().Jails[0].Status != JailPending || u.Status().Jails[0].Error != "" {
+		t.Errorf("retry: %+v", u.Status())
+	}
+
+	u.state = BaseUpdatePaused
+	if err := u.Resume(true); err != nil {
+		t.Fatal(err)
+	}
+	if u.Current() != "b.example.com" || u.Status().Jails[0].Status != JailSkipped {
+		t.Errorf("skip: %+v", u.Status())
+	}
+
+	u.next = 1
+	u.state = BaseUpdatePaused
+	u.Resume(true)
+	if !u.Done() || u.Status().State != BaseUpdateCompleted {
+		t.Errorf("skipping the last jail should complete the rollout: %+v", u.Status())
+	}
+}
+
+func TestBaseUpdate_StepSkipsDestroyedSite(t *testing.T) {
+	cfg := baseUpdateConfig()
+	u, _ := NewBaseUpdate(cfg, []string{"a.example.com"})
+	delete(cfg.Site, "a.example.com")
+	if err := u.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if status := u.Status(); status.State != BaseUpdateCompleted || status.Jails[0].Status != JailSkipped {
+		t.Errorf("status = %+v", status)
+	}
+}
@@ -0,0 +1,107 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/logger"
+)
+
+// ErrNoCanary is returned when finalizing or aborting a site with no canary running
+var ErrNoCanary = errors.New("no canary in progress")
+
+// StartCanary serves an already-deployed commit to percent of clients (0-100)
+// while everyone else stays on latest. Calling it again adjusts the percentage
+// or replaces the canary commit.
+func (fd *FrontendDeployer) StartCanary(siteName, commitHash string, percent int) (*config.CanaryConfig, error) {
+	site, ok := fd.cfg.Site[siteName]
+	if !ok {
+		return nil, fmt.Errorf("site not found: %s", siteName)
+	}
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("percent must be between 0 and 100")
+	}
+	if site.Experiment != nil {
+		return nil, fmt.Errorf("%w: %s is running experiment %s", ErrRolloutConflict, siteName, site.Experiment.Name)
+	}
+
+	info, err := os.Stat(filepath.Join(site.FrontendRoot, commitHash))
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s has no %s directory", ErrCommitNotDeployed, siteName, commitHash)
+	}
+
+	canary := &config.CanaryConfig{Commit: commitHash, Percent: percent, Started: time.Now().UTC()}
+	if site.Canary != nil && site.Canary.Commit == commitHash {
+		canary.Started = site.Canary.Started
+	}
+
+	if err := fd.applyCanary(siteName, site.Canary, canary); err != nil {
+		return nil, err
+	}
+	slog.Info("canary set", "site", siteName, "commit", commitHash, "percent", percent)
+	return canary, nil
+}
+
+// FinalizeCanary points latest at the canary commit and ends the canary.
+// It returns the canary and the commit latest pointed to before.
+func (fd *FrontendDeployer) FinalizeCanary(ctx context.Context, siteName string) (*config.CanaryConfig, string, error) {
+	site, ok := fd.cfg.Site[siteName]
+	if !ok {
+		return nil, "", fmt.Errorf("site not found: %s", siteName)
+	}
+	canary := site.Canary
+	if canary == nil {
+		return nil, "", fmt.Errorf("%w for %s", ErrNoCanary, siteName)
+	}
+
+	// Flip latest first so canary clients never fall back to the old version
+	log := logger.FromContext(ctx).With("commit", canary.Commit)
+	previous, err := fd.Promote(logger.NewContext(ctx, log), siteName, canary.Commit)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := fd.applyCanary(siteName, canary, nil); err != nil {
+		return nil, previous, err
+	}
+	slog.Info("canary finalized", "site", siteName, "commit", canary.Commit, "previous", previous)
+	return canary, previous, nil
+}
+
+// AbortCanary sends all clients back to latest
+func (fd *FrontendDeployer) AbortCanary(siteName string) (*config.CanaryConfig, error) {
+	site, ok := fd.cfg.Site[siteName]
+	if !ok {
+		return nil, fmt.Errorf("site not found: %s", siteName)
+	}
+	canary := site.Canary
+	if canary == nil {
+		return nil, fmt.Errorf("%w for %s", ErrNoCanary, siteName)
+	}
+
+	if err := fd.applyCanary(siteName, canary, nil); err != nil {
+		return nil, err
+	}
+	slog.Info("canary aborted", "site", siteName, "commit", canary.Commit)
+	return canary, nil
+}
+
+// applyCanary saves the new canary state and pushes it to nginx, reverting
+// the config if nginx rejects it
+func (fd *FrontendDeployer) applyCanary(siteName string, previous, canary *config.CanaryConfig) error {
+	if err := fd.cfg.SetCanary(siteName, canary); err != nil {
+		return fmt.Errorf("save canary: %w", err)
+	}
+	if err := fd.nginx.ApplyOverrides(context.Background()); err != nil {
+		if revertErr := fd.cfg.SetCanary(siteName, previous); revertErr != nil {
+			slog.Error("failed to revert canary config", "site", siteName, "error", revertErr)
+		}
+		return err
+	}
+	return nil
+}
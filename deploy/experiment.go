@@ -0,0 +1,126 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// ErrNoExperiment is returned when changing or stopping a site with no experiment running
+var ErrNoExperiment = errors.New("no experiment in progress")
+
+// ErrRolloutConflict is returned when starting a canary or an experiment on a
+// site already running the other
+var ErrRolloutConflict = errors.New("a canary and an experiment cannot run at once")
+
+// StartExperiment splits a site's clients between variants, each serving an
+// already-deployed commit (or latest). Starting it again under the same name
+// replaces the variants while clients keep their variant; a new name
+// re-splits everyone.
+func (fd *FrontendDeployer) StartExperiment(siteName, name string, variants map[string]config.VariantConfig) (*config.ExperimentConfig, error) {
+	site, ok := fd.cfg.Site[siteName]
+	if !ok {
+		return nil, fmt.Errorf("site not found: %s", siteName)
+	}
+	if site.Canary != nil {
+		return nil, fmt.Errorf("%w: %s has a canary of %s", ErrRolloutConflict, siteName, site.Canary.Commit)
+	}
+
+	experiment := &config.ExperimentConfig{Name: name, Variants: variants, Started: time.Now().UTC()}
+	if err := experiment.Validate(); err != nil {
+		return nil, err
+	}
+	for _, variant := range experiment.VariantNames() {
+		commit := variants[variant].Commit
+		if commit == "latest" {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(site.FrontendRoot, commit))
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("%w: %s has no %s directory", ErrCommitNotDeployed, siteName, commit)
+		}
+	}
+	if site.Experiment != nil && site.Experiment.Name == name {
+		experiment.Started = site.Experiment.Started
+	}
+
+	if err := fd.applyExperiment(siteName, site.Experiment, experiment); err != nil {
+		return nil, err
+	}
+	slog.Info("experiment set", "site", siteName, "experiment", name, "variants", len(variants))
+	return experiment, nil
+}
+
+// SetExperimentWeights changes the weights of a running experiment's
+// variants. Clients keep their variant unless its weight becomes 0.
+func (fd *FrontendDeployer) SetExperimentWeights(siteName string, weights map[string]int) (*config.ExperimentConfig, error) {
+	site, ok := fd.cfg.Site[siteName]
+	if !ok {
+		return nil, fmt.Errorf("site not found: %s", siteName)
+	}
+	if site.Experiment == nil {
+		return nil, fmt.Errorf("%w for %s", ErrNoExperiment, siteName)
+	}
+
+	experiment := *site.Experiment
+	experiment.Variants = make(map[string]config.VariantConfig, len(site.Experiment.Variants))
+	for name, variant := range site.Experiment.Variants {
+		experiment.Variants[name] = variant
+	}
+	for name, weight := range weights {
+		variant, ok := experiment.Variants[name]
+		if !ok {
+			return nil, fmt.Errorf("experiment %s has no variant %q", experiment.Name, name)
+		}
+		variant.Weight = weight
+		experiment.Variants[name] = variant
+	}
+	if err := experiment.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := fd.applyExperiment(siteName, site.Experiment, &experiment); err != nil {
+		return nil, err
+	}
+	slog.Info("experiment weights set", "site", siteName, "experiment", experiment.Name)
+	return &experiment, nil
+}
+
+// StopExperiment sends all clients back to latest
+func (fd *FrontendDeployer) StopExperiment(siteName string) (*config.ExperimentConfig, error) {
+	site, ok := fd.cfg.Site[siteName]
+	if !ok {
+		return nil, fmt.Errorf("site not found: %s", siteName)
+	}
+	experiment := site.Experiment
+	if experiment == nil {
+		return nil, fmt.Errorf("%w for %s", ErrNoExperiment, siteName)
+	}
+
+	if err := fd.applyExperiment(siteName, experiment, nil); err != nil {
+		return nil, err
+	}
+	slog.Info("experiment stopped", "site", siteName, "experiment", experiment.Name)
+	return experiment, nil
+}
+
+// applyExperiment saves the new experiment state and pushes it to nginx,
+// reverting the config if nginx rejects it
+func (fd *FrontendDeployer) applyExperiment(siteName string, previous, experiment *config.ExperimentConfig) error {
+	if err := fd.cfg.SetExperiment(siteName, experiment); err != nil {
+		return fmt.Errorf("save experiment: %w", err)
+	}
+	if err := fd.nginx.ApplyOverrides(context.Background()); err != nil {
+		if revertErr := fd.cfg.SetExperiment(siteName, previous); revertErr != nil {
+			slog.Error("failed to revert experiment config", "site", siteName, "error", revertErr)
+		}
+		return err
+	}
+	return nil
+}
@@ -3,86 +3,170 @@ package deploy
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/lachierussell/shipyard/artifact"
 	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/journal"
+	"github.com/lachierussell/shipyard/logger"
 	"github.com/lachierussell/shipyard/nginx"
 	"github.com/lachierussell/shipyard/ssl"
 )
 
 // FrontendDeployer handles frontend deployment
 type FrontendDeployer struct {
-	cfg *config.Config
+	cfg   *config.Config
+	nginx NginxManager
+	slots chan struct{} // bounds deploys extracting at once
 }
 
-// NewFrontendDeployer creates a new frontend deployer
-func NewFrontendDeployer(cfg *config.Config) *FrontendDeployer {
-	return &FrontendDeployer{cfg: cfg}
+// NewFrontendDeployer creates a new frontend deployer. Nil managers in mgrs
+// get the real ones.
+func NewFrontendDeployer(cfg *config.Config, mgrs Managers) *FrontendDeployer {
+	mgrs = mgrs.withDefaults(cfg)
+	return &FrontendDeployer{cfg: cfg, nginx: mgrs.Nginx, slots: make(chan struct{}, cfg.Deploy.FrontendLimit())}
 }
 
 // Deploy extracts a frontend zip, optionally updates the symlink, and deploys the nginx config.
 // Set updateLatest to true for main branch deployments, false for branch previews.
-func (fd *FrontendDeployer) Deploy(siteName string, commitHash string, artifactReader io.Reader, nginxConfig string, updateLatest bool) (bool, string, error) {
-	log := slog.With("site", siteName, "commit", commitHash)
+// It logs through ctx's logger (logger.FromContext), which should name the
+// site and commit.
+func (fd *FrontendDeployer) Deploy(ctx context.Context, siteName string, commitHash string, artifactReader io.Reader, nginxConfig string, updateLatest bool) (bool, string, error) {
+	return fd.deploy(ctx, siteName, "", commitHash, artifactReader, nginxConfig, updateLatest)
+}
+
+// DeploySubdomain deploys a frontend zip to one subdomain of a wildcard site.
+// The commit is extracted under <frontend_root>/<subdomain> and updateLatest
+// moves that subdomain's latest symlink only.
+func (fd *FrontendDeployer) DeploySubdomain(ctx context.Context, siteName string, subdomain string, commitHash string, artifactReader io.Reader, nginxConfig string, updateLatest bool) (bool, string, error) {
+	if !config.IsWildcardDomain(siteName) {
+		return false, "", fmt.Errorf("site %s is not a wildcard site", siteName)
+	}
+	if !config.ValidSubdomainLabel(subdomain) {
+		return false, "", fmt.Errorf("invalid subdomain: %q", subdomain)
+	}
+	return fd.deploy(ctx, siteName, subdomain, commitHash, artifactReader, nginxConfig, updateLatest)
+}
+
+func (fd *FrontendDeployer) deploy(ctx context.Context, siteName string, subdomain string, commitHash string, artifactReader io.Reader, nginxConfig string, updateLatest bool) (bool, string, error) {
+	log := logger.FromContext(ctx)
 
 	site, ok := fd.cfg.Site[siteName]
 	if !ok {
 		return false, "", fmt.Errorf("site not found: %s", siteName)
 	}
 
+	frontendRoot := site.FrontendRoot
+	if subdomain != "" {
+		frontendRoot = site.SubdomainRoot(subdomain)
+	}
+
+	defer acquireSlot(fd.slots, log)()
 	log.Info("frontend deployment starting", "update_latest", updateLatest)
 
+	// Journal each destructive step so an interrupted deploy can be recovered on startup
+	j := journalFor(fd.cfg)
+	entry, err := j.Begin(journal.KindFrontend, siteName, commitHash)
+	if err != nil {
+		return false, "", fmt.Errorf("journal: %w", err)
+	}
+	defer j.Complete(entry)
+	entry.Subdomain = subdomain
+
 	// Create the commit directory
-	commitDir := filepath.Join(site.FrontendRoot, commitHash)
+	commitDir := filepath.Join(frontendRoot, commitHash)
+	_, statErr := os.Stat(commitDir)
+	freshDir := os.IsNotExist(statErr)
+	entry.CommitDirCreated = freshDir
+	if err := j.Step(entry, journal.StepExtract); err != nil {
+		return false, "", fmt.Errorf("journal: %w", err)
+	}
 	if err := os.MkdirAll(commitDir, 0755); err != nil {
 		return false, "", fmt.Errorf("mkdir commit dir: %w", err)
 	}
 
 	// Extract zip into commit directory
 	if err := fd.extractZip(artifactReader, commitDir); err != nil {
+		// Don't leave a half-extracted commit behind for previews to serve
+		if freshDir {
+			os.RemoveAll(commitDir)
+		}
 		return false, "", fmt.Errorf("extract zip: %w", err)
 	}
 
-	// Write default robots.txt if not present
+	// Write the site's robots.txt if the build has none
 	robotsPath := filepath.Join(commitDir, "robots.txt")
 	if _, err := os.Stat(robotsPath); os.IsNotExist(err) {
-		if err := os.WriteFile(robotsPath, []byte(nginx.GenerateRobotsTxt()), 0644); err != nil {
+		host := siteName
+		if subdomain != "" {
+			host = subdomain + strings.TrimPrefix(siteName, "*")
+		}
+		_, sitemapErr := os.Stat(filepath.Join(commitDir, "sitemap.xml"))
+		robots := nginx.GenerateRobotsTxt(siteName, host, site, sitemapErr == nil)
+		if err := os.WriteFile(robotsPath, []byte(robots), 0644); err != nil {
 			return false, "", fmt.Errorf("write robots.txt: %w", err)
 		}
 	}
 
+	// Compressed copies for gzip_static/brotli_static
+	if len(site.Precompress) > 0 {
+		if err := fd.precompress(site, commitDir); err != nil {
+			return false, "", err
+		}
+	}
+
+	// Record what was deployed, to detect later changes on disk
+	if err := WriteManifest(fd.cfg.StateDir(), siteName, subdomain, commitDir, commitHash); err != nil {
+		log.Warn("failed to record manifest", "error", err)
+	}
+
 	// Atomically update the latest symlink (only for main branch deployments)
 	if updateLatest {
-		if err := fd.updateLatestSymlink(site.FrontendRoot, commitHash); err != nil {
+		if err := j.Step(entry, journal.StepSymlink); err != nil {
+			return false, "", fmt.Errorf("journal: %w", err)
+		}
+		if err := fd.updateLatestSymlink(frontendRoot, commitHash); err != nil {
 			return false, "", fmt.Errorf("update symlink: %w", err)
 		}
 	}
 
 	// Deploy nginx config (validate + reload)
 	// If site has a backend, use combined template to preserve backend proxy
-	nginxMgr := nginx.NewManager(fd.cfg)
+	// Keep the current site and override configs so recovery can put them back
+	if err := j.Backup(entry, filepath.Join(fd.cfg.Nginx.SitesAvailable, siteName+".conf")); err != nil {
+		return false, "", fmt.Errorf("journal: %w", err)
+	}
+	if err := j.Backup(entry, fd.cfg.Nginx.OverrideConf); err != nil {
+		return false, "", fmt.Errorf("journal: %w", err)
+	}
+	if err := j.Step(entry, journal.StepNginx); err != nil {
+		return false, "", fmt.Errorf("journal: %w", err)
+	}
+
 	var reloaded bool
 	var errMsg string
-	var err error
 
 	if site.Backend != nil {
 		// Generate combined frontend+backend config
 		var combinedConfig string
 		if site.SSLEnabled {
 			certPath, keyPath := ssl.CertPaths(siteName)
-			combinedConfig = nginx.GenerateSiteCombinedConfigHTTPS(siteName, site.FrontendRoot, site.Backend.ListenPort, site.Backend.ProxyPath, certPath, keyPath)
+			combinedConfig = nginx.GenerateSiteCombinedConfigHTTPS(siteName, site, certPath, keyPath, fd.cfg.TLSFor(siteName), fd.cfg)
 		} else {
-			combinedConfig = nginx.GenerateSiteCombinedConfig(siteName, site.FrontendRoot, site.Backend.ListenPort, site.Backend.ProxyPath)
+			combinedConfig = nginx.GenerateSiteCombinedConfig(siteName, site, fd.cfg)
 		}
-		reloaded, errMsg, err = nginxMgr.DeploySiteConfigRaw(siteName, combinedConfig)
+		reloaded, errMsg, err = fd.nginx.DeploySiteConfigRaw(ctx, siteName, combinedConfig)
 	} else {
 		// Frontend-only site, use provided config
-		reloaded, errMsg, err = nginxMgr.DeploySiteConfig(siteName, nginxConfig)
+		reloaded, errMsg, err = fd.nginx.DeploySiteConfig(ctx, siteName, nginxConfig)
 	}
 
 	if err != nil {
@@ -110,13 +194,88 @@ func (fd *FrontendDeployer) extractZip(reader io.Reader, targetDir string) error
 	if err != nil {
 		return fmt.Errorf("invalid zip: %w", err)
 	}
+	if err := fd.checkZip(zr, int64(len(data))); err != nil {
+		return err
+	}
 
+	return fd.extractEntries(zr.File, targetDir)
+}
+
+// extractEntries writes a zip's entries, deploy.extract_workers at a time.
+// Builds are mostly thousands of small files, so the time goes on creating
+// them rather than on inflating. It stops at the first entry that fails.
+func (fd *FrontendDeployer) extractEntries(files []*zip.File, targetDir string) error {
+	// An entry repeated in the zip is written once, from its last copy, as
+	// extracting in order would leave it
+	last := make(map[string]*zip.File, len(files))
+	for _, f := range files {
+		last[filepath.Clean(f.Name)] = f
+	}
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	failed := make(chan struct{})
+	sem := make(chan struct{}, fd.cfg.Deploy.ExtractWorkerCount())
+
+loop:
+	for _, f := range files {
+		if last[filepath.Clean(f.Name)] != f {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-failed:
+			break loop
+		}
+		wg.Add(1)
+		go func(f *zip.File) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fd.extractZipEntry(f, targetDir); err != nil {
+				once.Do(func() {
+					firstErr = err
+					close(failed)
+				})
+			}
+		}(f)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// ErrArtifactRejected is returned for frontend zips that break the
+// [artifacts] policy
+var ErrArtifactRejected = errors.New("artifact rejected")
+
+// checkZip enforces the [artifacts] policy on a zip of size bytes before any
+// of it is extracted. The sizes checked are the ones the zip declares;
+// archive/zip fails entries that inflate past theirs.
+func (fd *FrontendDeployer) checkZip(zr *zip.Reader, size int64) error {
+	policy := fd.cfg.Artifacts
+	var total uint64
 	for _, f := range zr.File {
-		if err := fd.extractZipEntry(f, targetDir); err != nil {
-			return err
+		switch mode := f.Mode(); {
+		case mode&os.ModeSymlink != 0:
+			return fmt.Errorf("%w: %s is a symlink", ErrArtifactRejected, f.Name)
+		case mode&os.ModeType&^os.ModeDir != 0:
+			return fmt.Errorf("%w: %s is not a regular file", ErrArtifactRejected, f.Name)
 		}
+		if !f.FileInfo().IsDir() {
+			if ext := policy.DeniedExtension(f.Name); ext != "" {
+				return fmt.Errorf("%w: %s (%s files are not allowed, see artifacts.deny_extensions)", ErrArtifactRejected, f.Name, ext)
+			}
+		}
+		total += f.UncompressedSize64
 	}
 
+	if limit := policy.MaxExtractedBytes(); limit > 0 && total > uint64(limit) {
+		return fmt.Errorf("%w: extracts to %dMB, over artifacts.max_extracted_mb (%dMB)", ErrArtifactRejected, total>>20, limit>>20)
+	}
+	if ratio := policy.CompressionRatioLimit(); ratio > 0 && size > 0 && total/uint64(size) > uint64(ratio) {
+		return fmt.Errorf("%w: expands %d times, over artifacts.max_compression_ratio (%d)", ErrArtifactRejected, total/uint64(size), ratio)
+	}
 	return nil
 }
 
@@ -157,7 +316,8 @@ func (fd *FrontendDeployer) extractZipEntry(f *zip.File, targetDir string) error
 	}
 	defer src.Close()
 
-	dst, err := os.Create(fullPath)
+	perm := extractedPerm(f.Mode())
+	dst, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 		return fmt.Errorf("create file: %w", err)
 	}
@@ -166,10 +326,31 @@ func (fd *FrontendDeployer) extractZipEntry(f *zip.File, targetDir string) error
 	if _, err := io.Copy(dst, src); err != nil {
 		return fmt.Errorf("extract file: %w", err)
 	}
+	// Set the mode past the umask, and on files a redeploy overwrites
+	if err := dst.Chmod(perm); err != nil {
+		return fmt.Errorf("chmod file: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("extract file: %w", err)
+	}
+
+	// Keep the build's mtimes so Last-Modified and caches see unchanged files as unchanged
+	if !f.Modified.IsZero() {
+		if err := os.Chtimes(fullPath, f.Modified, f.Modified); err != nil {
+			return fmt.Errorf("set mtime: %w", err)
+		}
+	}
 
 	return nil
 }
 
+// extractedPerm is the mode an extracted file gets: the entry's execute bits
+// are kept, but it is always readable by nginx and never group or world
+// writable, setuid, setgid or sticky
+func extractedPerm(mode os.FileMode) os.FileMode {
+	return mode.Perm()&0755 | 0644
+}
+
 // updateLatestSymlink atomically updates the "latest" symlink to point to the new commit
 // It auto-detects if content is in a subdirectory like "dist/" and points there instead
 func (fd *FrontendDeployer) updateLatestSymlink(frontendRoot string, commitHash string) error {
@@ -190,19 +371,10 @@ func (fd *FrontendDeployer) updateLatestSymlink(frontendRoot string, commitHash
 	}
 
 	// Determine the actual content directory
-	// If the commit folder has a single subdirectory with index.html, use that
+	// If the build is wrapped in a directory like dist/ with index.html, use that
 	symlinkTarget := commitHash
-	commitDir := filepath.Join(frontendRoot, commitHash)
-
-	// Check common build output directories
-	for _, subdir := range []string{"dist", "build", "out", "public"} {
-		subdirPath := filepath.Join(commitDir, subdir)
-		indexPath := filepath.Join(subdirPath, "index.html")
-		if _, err := os.Stat(indexPath); err == nil {
-			// Found index.html in subdirectory
-			symlinkTarget = filepath.Join(commitHash, subdir)
-			break
-		}
+	if subdir := artifact.BuildDir(filepath.Join(frontendRoot, commitHash)); subdir != "" {
+		symlinkTarget = filepath.Join(commitHash, subdir)
 	}
 
 	// Create symlink to the content directory
@@ -218,3 +390,71 @@ func (fd *FrontendDeployer) updateLatestSymlink(frontendRoot string, commitHash
 
 	return nil
 }
+
+// LatestCommit returns the commit the "latest" symlink points to and when it was flipped
+func LatestCommit(frontendRoot string) (string, time.Time, error) {
+	latestPath := filepath.Join(frontendRoot, "latest")
+	info, err := os.Lstat(latestPath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return "", time.Time{}, fmt.Errorf("%s is not a symlink", latestPath)
+	}
+
+	target, err := os.Readlink(latestPath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Target is "<commit>" or "<commit>/<build dir>"
+	commit := strings.SplitN(filepath.ToSlash(target), "/", 2)[0]
+	return commit, info.ModTime(), nil
+}
+
+// ErrCommitNotDeployed is returned when promoting a commit that has no directory under frontend_root
+var ErrCommitNotDeployed = errors.New("commit is not deployed")
+
+// Promote atomically points "latest" at an already-deployed commit and returns
+// the commit it pointed to before ("" if none). It logs through ctx's logger
+// (logger.FromContext).
+func (fd *FrontendDeployer) Promote(ctx context.Context, siteName string, commitHash string) (string, error) {
+	return fd.promote(ctx, siteName, "", commitHash)
+}
+
+// PromoteSubdomain promotes a commit of one subdomain of a wildcard site,
+// moving that subdomain's latest symlink only
+func (fd *FrontendDeployer) PromoteSubdomain(ctx context.Context, siteName string, subdomain string, commitHash string) (string, error) {
+	if !config.IsWildcardDomain(siteName) {
+		return "", fmt.Errorf("site %s is not a wildcard site", siteName)
+	}
+	if !config.ValidSubdomainLabel(subdomain) {
+		return "", fmt.Errorf("invalid subdomain: %q", subdomain)
+	}
+	return fd.promote(ctx, siteName, subdomain, commitHash)
+}
+
+func (fd *FrontendDeployer) promote(ctx context.Context, siteName string, subdomain string, commitHash string) (string, error) {
+	site, ok := fd.cfg.Site[siteName]
+	if !ok {
+		return "", fmt.Errorf("site not found: %s", siteName)
+	}
+
+	frontendRoot := site.FrontendRoot
+	if subdomain != "" {
+		frontendRoot = site.SubdomainRoot(subdomain)
+	}
+
+	info, err := os.Stat(filepath.Join(frontendRoot, commitHash))
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%w: %s has no %s directory", ErrCommitNotDeployed, siteName, filepath.Join(subdomain, commitHash))
+	}
+
+	previous, _, _ := LatestCommit(frontendRoot)
+	if err := fd.updateLatestSymlink(frontendRoot, commitHash); err != nil {
+		return previous, fmt.Errorf("update symlink: %w", err)
+	}
+
+	logger.FromContext(ctx).Info("promoted commit to latest", "previous", previous)
+	return previous, nil
+}
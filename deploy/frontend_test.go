@@ -3,9 +3,14 @@ package deploy
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/lachierussell/shipyard/config"
 )
@@ -47,7 +52,7 @@ func TestExtractZip_BasicExtraction(t *testing.T) {
 	zipBuf := createTestZip(t, files)
 
 	cfg := &config.Config{}
-	deployer := NewFrontendDeployer(cfg)
+	deployer := NewFrontendDeployer(cfg, Managers{})
 
 	err := deployer.extractZip(bytes.NewReader(zipBuf.Bytes()), targetDir)
 	if err != nil {
@@ -82,7 +87,7 @@ func TestExtractZip_ZipSlipPrevention_DotDot(t *testing.T) {
 	w.Close()
 
 	cfg := &config.Config{}
-	deployer := NewFrontendDeployer(cfg)
+	deployer := NewFrontendDeployer(cfg, Managers{})
 
 	err := deployer.extractZip(bytes.NewReader(buf.Bytes()), targetDir)
 	if err == nil {
@@ -107,7 +112,7 @@ func TestExtractZip_ZipSlipPrevention_AbsolutePath(t *testing.T) {
 	w.Close()
 
 	cfg := &config.Config{}
-	deployer := NewFrontendDeployer(cfg)
+	deployer := NewFrontendDeployer(cfg, Managers{})
 
 	err := deployer.extractZip(bytes.NewReader(buf.Bytes()), targetDir)
 	if err == nil {
@@ -126,7 +131,7 @@ func TestExtractZip_CreatesDirectories(t *testing.T) {
 	zipBuf := createTestZip(t, files)
 
 	cfg := &config.Config{}
-	deployer := NewFrontendDeployer(cfg)
+	deployer := NewFrontendDeployer(cfg, Managers{})
 
 	err := deployer.extractZip(bytes.NewReader(zipBuf.Bytes()), targetDir)
 	if err != nil {
@@ -145,7 +150,7 @@ func TestExtractZip_InvalidZip(t *testing.T) {
 	targetDir := filepath.Join(dir, "extract")
 
 	cfg := &config.Config{}
-	deployer := NewFrontendDeployer(cfg)
+	deployer := NewFrontendDeployer(cfg, Managers{})
 
 	// Pass invalid zip content
 	err := deployer.extractZip(bytes.NewReader([]byte("not a zip file")), targetDir)
@@ -154,6 +159,132 @@ func TestExtractZip_InvalidZip(t *testing.T) {
 	}
 }
 
+func TestExtractZip_ModeAndMtime(t *testing.T) {
+	modified := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for name, mode := range map[string]os.FileMode{
+		"bin/wasm-opt": 0755,
+		"index.html":   0600,
+		"setuid":       os.ModeSetuid | 0777,
+	} {
+		h := &zip.FileHeader{Name: name, Modified: modified}
+		h.SetMode(mode)
+		f, _ := w.CreateHeader(h)
+		f.Write([]byte("content"))
+	}
+	w.Close()
+
+	targetDir := t.TempDir()
+	if err := NewFrontendDeployer(&config.Config{}, Managers{}).extractZip(bytes.NewReader(buf.Bytes()), targetDir); err != nil {
+		t.Fatalf("extractZip() error = %v", err)
+	}
+
+	for name, want := range map[string]os.FileMode{
+		"bin/wasm-opt": 0755,
+		"index.html":   0644, // nginx must be able to read it
+		"setuid":       0755,
+	} {
+		info, err := os.Stat(filepath.Join(targetDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != want {
+			t.Errorf("%s mode = %v, want %v", name, info.Mode(), want)
+		}
+		if !info.ModTime().Equal(modified) {
+			t.Errorf("%s mtime = %v, want %v", name, info.ModTime(), modified)
+		}
+	}
+}
+
+func TestExtractZip_Policy(t *testing.T) {
+	symlink := new(bytes.Buffer)
+	w := zip.NewWriter(symlink)
+	h := &zip.FileHeader{Name: "passwd"}
+	h.SetMode(os.ModeSymlink | 0777)
+	f, _ := w.CreateHeader(h)
+	f.Write([]byte("/etc/passwd"))
+	w.Close()
+
+	zeros := createTestZip(t, map[string]string{"index.html": strings.Repeat("\x00", 2<<20)})
+	php := createTestZip(t, map[string]string{"index.html": "<html>", "api/Shell.PHP": "<?php"})
+
+	tests := []struct {
+		name      string
+		artifacts config.ArtifactsConfig
+		zip       []byte
+		wantErr   string
+	}{
+		{"symlink", config.ArtifactsConfig{}, symlink.Bytes(), "passwd is a symlink"},
+		{"denied extension", config.ArtifactsConfig{}, php.Bytes(), ".php files are not allowed"},
+		{"extensions allowed", config.ArtifactsConfig{DenyExtensions: []string{"none"}}, php.Bytes(), ""},
+		{"zip bomb", config.ArtifactsConfig{}, zeros.Bytes(), "over artifacts.max_compression_ratio"},
+		{"too large", config.ArtifactsConfig{MaxExtractedMB: 1, MaxCompressionRatio: -1}, zeros.Bytes(), "over artifacts.max_extracted_mb"},
+		{"limits off", config.ArtifactsConfig{MaxExtractedMB: -1, MaxCompressionRatio: -1}, zeros.Bytes(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targetDir := t.TempDir()
+			deployer := NewFrontendDeployer(&config.Config{Artifacts: tt.artifacts}, Managers{})
+			err := deployer.extractZip(bytes.NewReader(tt.zip), targetDir)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("extractZip() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrArtifactRejected) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("extractZip() error = %v, want %q", err, tt.wantErr)
+			}
+			if entries, _ := os.ReadDir(targetDir); len(entries) != 0 {
+				t.Errorf("a rejected zip should extract nothing, found %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestExtractZip_DuplicateEntries(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, content := range []string{"first", "second, and longer"} {
+		f, _ := w.Create("index.html")
+		f.Write([]byte(content))
+	}
+	w.Close()
+
+	targetDir := t.TempDir()
+	if err := NewFrontendDeployer(&config.Config{}, Managers{}).extractZip(bytes.NewReader(buf.Bytes()), targetDir); err != nil {
+		t.Fatalf("extractZip() error = %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(targetDir, "index.html")); string(content) != "second, and longer" {
+		t.Errorf("index.html = %q, want the last copy", content)
+	}
+}
+
+// BenchmarkExtractZip extracts a build of 2000 small files, one at a time
+// and with the default worker pool
+func BenchmarkExtractZip(b *testing.B) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for i := 0; i < 2000; i++ {
+		f, _ := w.Create(fmt.Sprintf("assets/chunk-%d/%d.js", i%50, i))
+		f.Write(bytes.Repeat([]byte("export const x = 1;\n"), 200))
+	}
+	w.Close()
+
+	for _, workers := range []int{1, config.DefaultExtractWorkers} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			deployer := NewFrontendDeployer(&config.Config{Deploy: config.DeployConfig{ExtractWorkers: workers}}, Managers{})
+			for i := 0; i < b.N; i++ {
+				if err := deployer.extractZip(bytes.NewReader(buf.Bytes()), b.TempDir()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func TestUpdateLatestSymlink(t *testing.T) {
 	dir := t.TempDir()
 
@@ -164,7 +295,7 @@ func TestUpdateLatestSymlink(t *testing.T) {
 	os.MkdirAll(filepath.Join(dir, commit2), 0755)
 
 	cfg := &config.Config{}
-	deployer := NewFrontendDeployer(cfg)
+	deployer := NewFrontendDeployer(cfg, Managers{})
 
 	// Create first symlink
 	err := deployer.updateLatestSymlink(dir, commit1)
@@ -197,19 +328,136 @@ func TestUpdateLatestSymlink(t *testing.T) {
 	}
 }
 
+func TestLatestCommit_BuildSubdir(t *testing.T) {
+	dir := t.TempDir()
+
+	commit := "abc1234"
+	os.MkdirAll(filepath.Join(dir, commit, "dist"), 0755)
+	os.WriteFile(filepath.Join(dir, commit, "dist", "index.html"), []byte("<html>"), 0644)
+
+	deployer := NewFrontendDeployer(&config.Config{}, Managers{})
+	if err := deployer.updateLatestSymlink(dir, commit); err != nil {
+		t.Fatalf("updateLatestSymlink() error = %v", err)
+	}
+
+	got, _, err := LatestCommit(dir)
+	if err != nil {
+		t.Fatalf("LatestCommit() error = %v", err)
+	}
+	if got != commit {
+		t.Errorf("LatestCommit() = %q, want %q", got, commit)
+	}
+}
+
+func TestLatestCommit_NoDeploy(t *testing.T) {
+	if _, _, err := LatestCommit(t.TempDir()); err == nil {
+		t.Error("LatestCommit() should fail when latest does not exist")
+	}
+}
+
 func TestDeploy_SiteNotFound(t *testing.T) {
 	cfg := &config.Config{
 		Site: map[string]config.SiteConfig{},
 	}
-	deployer := NewFrontendDeployer(cfg)
+	deployer := NewFrontendDeployer(cfg, Managers{})
 
-	_, _, err := deployer.Deploy("nonexistent", "abc1234", nil, "", false)
+	_, _, err := deployer.Deploy(context.Background(), "nonexistent", "abc1234", nil, "", false)
 	if err == nil {
 		t.Error("Deploy() should fail for nonexistent site")
 	}
 }
 
+func TestDeploySubdomain_Validation(t *testing.T) {
+	cfg := &config.Config{
+		Site: map[string]config.SiteConfig{
+			"example.com":        {FrontendRoot: "/var/www/example.com"},
+			"*.docs.example.com": {FrontendRoot: "/var/www/wildcard.docs.example.com"},
+		},
+	}
+	deployer := NewFrontendDeployer(cfg, Managers{})
+
+	if _, _, err := deployer.DeploySubdomain(context.Background(), "example.com", "pr-1", "abc1234", nil, "", false); err == nil {
+		t.Error("DeploySubdomain() should fail for a non-wildcard site")
+	}
+	for _, bad := range []string{"", "PR-1", "-pr", "a.b", "../x"} {
+		if _, _, err := deployer.DeploySubdomain(context.Background(), "*.docs.example.com", bad, "abc1234", nil, "", false); err == nil {
+			t.Errorf("DeploySubdomain(%q) should fail", bad)
+		}
+	}
+}
+
 // Helper function
 func contains(s, substr string) bool {
 	return bytes.Contains([]byte(s), []byte(substr))
 }
+
+func TestPromote(t *testing.T) {
+	root := t.TempDir()
+	for _, commit := range []string{"aaaaaaa", "bbbbbbb"} {
+		if err := os.MkdirAll(filepath.Join(root, commit), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("aaaaaaa", filepath.Join(root, "latest")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{Site: map[string]config.SiteConfig{"example.com": {FrontendRoot: root}}}
+	fd := NewFrontendDeployer(cfg, Managers{})
+
+	previous, err := fd.Promote(context.Background(), "example.com", "bbbbbbb")
+	if err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	if previous != "aaaaaaa" {
+		t.Errorf("Promote() previous = %q, want aaaaaaa", previous)
+	}
+	if got, _, _ := LatestCommit(root); got != "bbbbbbb" {
+		t.Errorf("latest = %q, want bbbbbbb", got)
+	}
+
+	if _, err := fd.Promote(context.Background(), "example.com", "ccccccc"); !errors.Is(err, ErrCommitNotDeployed) {
+		t.Errorf("Promote() of undeployed commit error = %v, want ErrCommitNotDeployed", err)
+	}
+	if got, _, _ := LatestCommit(root); got != "bbbbbbb" {
+		t.Errorf("failed promote changed latest to %q", got)
+	}
+}
+
+func TestPromoteSubdomain(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"pr-1/aaaaaaa", "pr-1/bbbbbbb", "bbbbbbb"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("aaaaaaa", filepath.Join(root, "pr-1", "latest")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"*.preview.example.com": {FrontendRoot: root},
+		"example.com":           {FrontendRoot: root},
+	}}
+	fd := NewFrontendDeployer(cfg, Managers{})
+	ctx := context.Background()
+
+	previous, err := fd.PromoteSubdomain(ctx, "*.preview.example.com", "pr-1", "bbbbbbb")
+	if err != nil || previous != "aaaaaaa" {
+		t.Fatalf("PromoteSubdomain() = %q, %v; want previous aaaaaaa", previous, err)
+	}
+	if got, _, _ := LatestCommit(filepath.Join(root, "pr-1")); got != "bbbbbbb" {
+		t.Errorf("subdomain latest = %q, want bbbbbbb", got)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "latest")); !os.IsNotExist(err) {
+		t.Errorf("PromoteSubdomain() touched the site's own latest: %v", err)
+	}
+
+	// The commit must be deployed to that subdomain
+	if _, err := fd.PromoteSubdomain(ctx, "*.preview.example.com", "pr-2", "bbbbbbb"); !errors.Is(err, ErrCommitNotDeployed) {
+		t.Errorf("PromoteSubdomain() of another subdomain's commit error = %v, want ErrCommitNotDeployed", err)
+	}
+	if _, err := fd.PromoteSubdomain(ctx, "example.com", "pr-1", "bbbbbbb"); err == nil {
+		t.Error("PromoteSubdomain() should refuse a site that is not a wildcard")
+	}
+}
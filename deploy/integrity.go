@@ -0,0 +1,305 @@
+package deploy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/notify"
+)
+
+// ErrNoManifest is returned when verifying a commit deployed without a manifest
+var ErrNoManifest = errors.New("no manifest recorded for this commit")
+
+// Manifest records the SHA-256 of every file of a deployed commit, so later
+// changes on disk can be detected
+type Manifest struct {
+	Commit    string            `json:"commit"`
+	CreatedAt time.Time         `json:"created_at"`
+	Files     map[string]string `json:"files"` // slash-separated path -> hex SHA-256
+}
+
+// Drift is how a deployed commit differs from its manifest
+type Drift struct {
+	Site      string    `json:"site"`
+	Subdomain string    `json:"subdomain,omitempty"`
+	Commit    string    `json:"commit"`
+	CheckedAt time.Time `json:"checked_at"`
+	Files     int       `json:"files"` // in the manifest
+	Modified  []string  `json:"modified"`
+	Missing   []string  `json:"missing"`
+	Added     []string  `json:"added"`
+}
+
+// Clean reports whether the commit matches its manifest
+func (d *Drift) Clean() bool {
+	return len(d.Modified) == 0 && len(d.Missing) == 0 && len(d.Added) == 0
+}
+
+// ManifestsDir holds a site's manifests: <state_dir>/manifests/<site>
+func ManifestsDir(stateDir, site string) string {
+	return filepath.Join(stateDir, "manifests", site)
+}
+
+// manifestPath is where a commit's manifest is kept. Wildcard sites keep
+// each subdomain's in a directory of its own.
+func manifestPath(stateDir, site, subdomain, commit string) string {
+	return filepath.Join(ManifestsDir(stateDir, site), subdomain, commit+".json")
+}
+
+// WriteManifest hashes the files of commitDir and records them as commit's manifest
+func WriteManifest(stateDir, site, subdomain, commitDir, commit string) error {
+	files, err := hashTree(commitDir)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(Manifest{Commit: commit, CreatedAt: time.Now().UTC(), Files: files})
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+
+	path := manifestPath(stateDir, site, subdomain, commit)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create manifest directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// VerifyCommit compares commitDir with the manifest recorded when commit was deployed
+func VerifyCommit(stateDir, site, subdomain, commitDir, commit string) (*Drift, error) {
+	data, err := os.ReadFile(manifestPath(stateDir, site, subdomain, commit))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s@%s", ErrNoManifest, site, commit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+
+	files, err := hashTree(commitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Drift{
+		Site: site, Subdomain: subdomain, Commit: commit, CheckedAt: time.Now().UTC(), Files: len(m.Files),
+		Modified: []string{}, Missing: []string{}, Added: []string{},
+	}
+	for name, sum := range m.Files {
+		got, ok := files[name]
+		switch {
+		case !ok:
+			d.Missing = append(d.Missing, name)
+		case got != sum:
+			d.Modified = append(d.Modified, name)
+		}
+	}
+	for name := range files {
+		if _, ok := m.Files[name]; !ok {
+			d.Added = append(d.Added, name)
+		}
+	}
+	sort.Strings(d.Modified)
+	sort.Strings(d.Missing)
+	sort.Strings(d.Added)
+	return d, nil
+}
+
+// hashTree returns the SHA-256 of every file under dir, by slash-separated
+// relative path. Anything that isn't a regular file or directory is recorded
+// by its type, so a file swapped for a symlink shows up too.
+func hashTree(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		rel = filepath.ToSlash(rel)
+		if !d.Type().IsRegular() {
+			target, _ := os.Readlink(path)
+			files[rel] = fmt.Sprintf("%s:%s", d.Type(), target)
+			return nil
+		}
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		files[rel] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hash %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verifier checks every site's live frontend commits against their
+// manifests every deploy.verify_interval, and sends a notification when a
+// commit drifts
+type Verifier struct {
+	cfg      *config.Config
+	notifier *notify.Notifier
+	mu       sync.RWMutex
+	results  map[string][]Drift // by site
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewVerifier creates a verifier; call Start to begin checking
+func NewVerifier(cfg *config.Config, notifier *notify.Notifier) *Verifier {
+	return &Verifier{
+		cfg:      cfg,
+		notifier: notifier,
+		results:  make(map[string][]Drift),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start checks every verify interval, beginning one interval from now. It
+// does nothing when verification is turned off.
+func (v *Verifier) Start() {
+	interval := v.cfg.Deploy.VerifyIntervalDuration()
+	if interval == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				v.Check()
+			case <-v.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops checking
+func (v *Verifier) Stop() {
+	v.stopOnce.Do(func() { close(v.done) })
+}
+
+// Check verifies the live commit of every site, and of every subdomain of
+// wildcard sites. Commits deployed before manifests were recorded are skipped.
+func (v *Verifier) Check() {
+	results := make(map[string][]Drift, len(v.cfg.Site))
+	for siteName, site := range v.cfg.Site {
+		if !site.HasFrontend() {
+			continue
+		}
+		for _, root := range liveRoots(siteName, site) {
+			commit, _, err := LatestCommit(root.dir)
+			if err != nil {
+				continue
+			}
+			d, err := VerifyCommit(v.cfg.StateDir(), siteName, root.subdomain, filepath.Join(root.dir, commit), commit)
+			if errors.Is(err, ErrNoManifest) {
+				continue
+			}
+			if err != nil {
+				slog.Warn("integrity check failed", "site", siteName, "subdomain", root.subdomain, "commit", commit, "error", err)
+				continue
+			}
+			if !d.Clean() && v.drifted(siteName, *d) {
+				v.notifier.Send(notify.Event{
+					Kind:    notify.KindIntegrityDrift,
+					Site:    siteName,
+					Time:    d.CheckedAt,
+					Message: fmt.Sprintf("%d files of commit %s changed on disk since it was deployed", len(d.Modified)+len(d.Missing)+len(d.Added), commit),
+					Details: map[string]any{"subdomain": root.subdomain, "commit": commit, "modified": d.Modified, "missing": d.Missing, "added": d.Added},
+				})
+			}
+			results[siteName] = append(results[siteName], *d)
+		}
+	}
+
+	v.mu.Lock()
+	v.results = results
+	v.mu.Unlock()
+}
+
+// drifted reports whether d is news: the last check of the same commit
+// found it clean or found different changes
+func (v *Verifier) drifted(site string, d Drift) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, prev := range v.results[site] {
+		if prev.Subdomain == d.Subdomain && prev.Commit == d.Commit {
+			return fmt.Sprint(prev.Modified, prev.Missing, prev.Added) != fmt.Sprint(d.Modified, d.Missing, d.Added)
+		}
+	}
+	return true
+}
+
+// Get returns the latest periodic results for a site
+func (v *Verifier) Get(site string) []Drift {
+	if v == nil {
+		return nil
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.results[site]
+}
+
+// liveRoot is a directory holding commits and a latest symlink
+type liveRoot struct {
+	subdomain string
+	dir       string
+}
+
+// liveRoots returns the site's frontend root, or for wildcard sites the root
+// of each deployed subdomain
+func liveRoots(siteName string, site config.SiteConfig) []liveRoot {
+	if !config.IsWildcardDomain(siteName) {
+		return []liveRoot{{dir: site.FrontendRoot}}
+	}
+	entries, err := os.ReadDir(site.FrontendRoot)
+	if err != nil {
+		return nil
+	}
+	var roots []liveRoot
+	for _, e := range entries {
+		if e.IsDir() && config.ValidSubdomainLabel(e.Name()) {
+			roots = append(roots, liveRoot{subdomain: e.Name(), dir: site.SubdomainRoot(e.Name())})
+		}
+	}
+	return roots
+}
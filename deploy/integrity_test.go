@@ -0,0 +1,94 @@
+package deploy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestVerifyCommit(t *testing.T) {
+	stateDir := t.TempDir()
+	root := t.TempDir()
+	commitDir := filepath.Join(root, "abc1234")
+	for name, content := range map[string]string{
+		"index.html":    "<html>",
+		"assets/app.js": "console.log(1)",
+		"robots.txt":    "User-agent: *",
+	} {
+		os.MkdirAll(filepath.Dir(filepath.Join(commitDir, name)), 0755)
+		os.WriteFile(filepath.Join(commitDir, name), []byte(content), 0644)
+	}
+
+	if _, err := VerifyCommit(stateDir, "example.com", "", commitDir, "abc1234"); !errors.Is(err, ErrNoManifest) {
+		t.Fatalf("VerifyCommit() without a manifest error = %v, want ErrNoManifest", err)
+	}
+	if err := WriteManifest(stateDir, "example.com", "", commitDir, "abc1234"); err != nil {
+		t.Fatalf("WriteManifest() error = %v", err)
+	}
+
+	d, err := VerifyCommit(stateDir, "example.com", "", commitDir, "abc1234")
+	if err != nil {
+		t.Fatalf("VerifyCommit() error = %v", err)
+	}
+	if !d.Clean() || d.Files != 3 {
+		t.Errorf("fresh commit drift = %+v, want clean with 3 files", d)
+	}
+
+	os.WriteFile(filepath.Join(commitDir, "index.html"), []byte("<html>defaced"), 0644)
+	os.Remove(filepath.Join(commitDir, "robots.txt"))
+	os.WriteFile(filepath.Join(commitDir, "assets/shell.php"), []byte("<?php"), 0644)
+	os.Symlink("/etc/passwd", filepath.Join(commitDir, "passwd"))
+
+	d, err = VerifyCommit(stateDir, "example.com", "", commitDir, "abc1234")
+	if err != nil {
+		t.Fatalf("VerifyCommit() error = %v", err)
+	}
+	if !reflect.DeepEqual(d.Modified, []string{"index.html"}) ||
+		!reflect.DeepEqual(d.Missing, []string{"robots.txt"}) ||
+		!reflect.DeepEqual(d.Added, []string{"assets/shell.php", "passwd"}) {
+		t.Errorf("drift = %+v", d)
+	}
+}
+
+func TestVerifier_Check(t *testing.T) {
+	stateDir := t.TempDir()
+	root := t.TempDir()
+	cfg := &config.Config{
+		Self: config.SelfConfig{StateDir: stateDir},
+		Site: map[string]config.SiteConfig{
+			"example.com":        {FrontendRoot: filepath.Join(root, "example.com")},
+			"*.docs.example.com": {FrontendRoot: filepath.Join(root, "docs")},
+		},
+	}
+	deployer := NewFrontendDeployer(cfg, Managers{})
+	deployCommit := func(site, subdomain, frontendRoot string) string {
+		t.Helper()
+		dir := filepath.Join(frontendRoot, "abc1234")
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>"), 0644)
+		if err := WriteManifest(stateDir, site, subdomain, dir, "abc1234"); err != nil {
+			t.Fatal(err)
+		}
+		if err := deployer.updateLatestSymlink(frontendRoot, "abc1234"); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	apex := deployCommit("example.com", "", cfg.Site["example.com"].FrontendRoot)
+	deployCommit("*.docs.example.com", "pr-1", cfg.Site["*.docs.example.com"].SubdomainRoot("pr-1"))
+
+	os.WriteFile(filepath.Join(apex, "index.html"), []byte("<html>defaced"), 0644)
+
+	v := NewVerifier(cfg, nil)
+	v.Check()
+	if got := v.Get("example.com"); len(got) != 1 || got[0].Clean() {
+		t.Errorf("example.com results = %+v, want one drifted commit", got)
+	}
+	if got := v.Get("*.docs.example.com"); len(got) != 1 || !got[0].Clean() || got[0].Subdomain != "pr-1" {
+		t.Errorf("wildcard results = %+v, want pr-1 clean", got)
+	}
+}
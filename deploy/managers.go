@@ -0,0 +1,69 @@
+package deploy
+
+import (
+	"context"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/firewall"
+	"github.com/lachierussell/shipyard/jail"
+	"github.com/lachierussell/shipyard/nginx"
+	"github.com/lachierussell/shipyard/service"
+)
+
+// NginxManager deploys site configs and pushes override changes to nginx.
+// *nginx.Manager is the real one.
+type NginxManager interface {
+	DeploySiteConfig(ctx context.Context, siteName, nginxConfig string) (bool, string, error)
+	DeploySiteConfigRaw(ctx context.Context, siteName, nginxConfig string) (bool, string, error)
+	ApplyOverrides(ctx context.Context) error
+	Reload(ctx context.Context) error
+}
+
+// ServiceManager writes a backend's env and runs its host service.
+// *service.Manager is the real one.
+type ServiceManager interface {
+	WriteEnv(siteName string) ([]string, error)
+	CreateBackendService(siteName string) error
+	Enable(siteName string) error
+	Start(siteName string) error
+	Stop(siteName string) error
+}
+
+// Firewall loads and flushes a backend's outbound rules.
+// *firewall.Manager is the real one.
+type Firewall interface {
+	Apply(siteName string) error
+	Remove(siteName string) error
+}
+
+var (
+	_ NginxManager   = (*nginx.Manager)(nil)
+	_ ServiceManager = (*service.Manager)(nil)
+	_ Firewall       = (*firewall.Manager)(nil)
+)
+
+// Managers are what deploys change the host with. A nil field gets the real
+// manager.
+type Managers struct {
+	Nginx    NginxManager
+	Driver   jail.Driver
+	Service  ServiceManager
+	Firewall Firewall
+}
+
+// withDefaults fills the nil fields with the real managers
+func (m Managers) withDefaults(cfg *config.Config) Managers {
+	if m.Nginx == nil {
+		m.Nginx = nginx.NewManager(cfg)
+	}
+	if m.Driver == nil {
+		m.Driver = jail.NewDriver(cfg)
+	}
+	if m.Service == nil {
+		m.Service = service.NewManager(cfg)
+	}
+	if m.Firewall == nil {
+		m.Firewall = firewall.NewManager(cfg)
+	}
+	return m
+}
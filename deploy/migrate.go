@@ -0,0 +1,53 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/jail"
+	"github.com/lachierussell/shipyard/service"
+)
+
+// migrateOutputLines is how much of the migration's output a deploy reports
+const migrateOutputLines = 200
+
+// MigrationResult is what a backend's migrate_command did during a deploy
+type MigrationResult struct {
+	Command  string  `json:"command"`
+	Output   string  `json:"output"` // the last migrateOutputLines lines
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// migrateKillGrace is how long past its migrate_timeout a migration's exec
+// is killed, should the timeout inside the jail not end it
+const migrateKillGrace = 30 * time.Second
+
+// runMigration runs a backend's migrate_command inside its jail with the
+// backend's resolved env. The result's Error is set when it fails or times out.
+func runMigration(ctx context.Context, jailMgr jail.Driver, siteName string, backend config.BackendConfig, env []string) *MigrationResult {
+	res := &MigrationResult{Command: backend.MigrateCommand}
+	start := time.Now()
+	args := service.MigrateArgs(backend, env, service.JailMigrateLog)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(backend.MigrateTimeoutSeconds())*time.Second+migrateKillGrace)
+	defer cancel()
+	err := jailMgr.Exec(ctx, siteName, args[0], args[1:]...)
+	res.Duration = time.Since(start).Seconds()
+
+	if root, rerr := jailMgr.RootPath(siteName); rerr == nil {
+		lines, _ := jail.TailFile(filepath.Join(root, service.JailMigrateLog), migrateOutputLines)
+		res.Output = strings.Join(lines, "\n")
+	}
+	switch {
+	case err == nil:
+	case strings.Contains(err.Error(), "exit status 124"):
+		res.Error = fmt.Sprintf("timed out after %ds", backend.MigrateTimeoutSeconds())
+	default:
+		res.Error = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,127 @@
+package deploy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/lachierussell/shipyard/config"
+)
+
+// precompressMinSize skips files too small for compression to pay off
+const precompressMinSize = 1024
+
+// precompressBrotliLevel is the brotli quality used. Levels 10 and 11 save
+// a few percent more but take around twenty times as long.
+const precompressBrotliLevel = 9
+
+// precompressExts are the assets worth compressing; images, woff fonts and
+// archives are compressed already
+var precompressExts = map[string]bool{
+	".html": true, ".htm": true, ".css": true, ".js": true, ".mjs": true, ".cjs": true,
+	".json": true, ".map": true, ".svg": true, ".xml": true, ".txt": true, ".md": true,
+	".webmanifest": true, ".wasm": true, ".ico": true, ".ttf": true, ".otf": true,
+}
+
+// precompressSuffixes are the file suffixes of each encoding
+var precompressSuffixes = map[string]string{
+	config.EncodingGzip:   ".gz",
+	config.EncodingBrotli: ".br",
+}
+
+// precompress writes compressed copies of the text assets under dir, next to
+// each file, for the encodings the site asks for. A copy that would not be
+// smaller is skipped, as is one the build already ships. Copies keep their
+// file's mtime, as gzip_static expects. Files are compressed
+// deploy.extract_workers at a time.
+func (fd *FrontendDeployer) precompress(site config.SiteConfig, dir string) error {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && precompressExts[strings.ToLower(filepath.Ext(path))] {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("precompress: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	sem := make(chan struct{}, fd.cfg.Deploy.ExtractWorkerCount())
+	for _, path := range paths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(path string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := precompressFile(site, path); err != nil {
+				once.Do(func() { firstErr = err })
+			}
+		}(path)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// precompressFile writes the compressed copies of one file
+func precompressFile(site config.SiteConfig, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("precompress: %w", err)
+	}
+	if info.Size() < precompressMinSize {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("precompress: %w", err)
+	}
+
+	for _, enc := range []string{config.EncodingGzip, config.EncodingBrotli} {
+		if !site.Precompresses(enc) {
+			continue
+		}
+		dest := path + precompressSuffixes[enc]
+		if _, err := os.Lstat(dest); err == nil {
+			continue
+		}
+
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		if enc == config.EncodingGzip {
+			w, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		} else {
+			w = brotli.NewWriterLevel(&buf, precompressBrotliLevel)
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("precompress %s: %w", path, err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("precompress %s: %w", path, err)
+		}
+		if int64(buf.Len()) >= info.Size() {
+			continue
+		}
+
+		if err := os.WriteFile(dest, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("precompress: %w", err)
+		}
+		if err := os.Chtimes(dest, info.ModTime(), info.ModTime()); err != nil {
+			return fmt.Errorf("precompress: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package deploy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestPrecompress(t *testing.T) {
+	dir := t.TempDir()
+	js := strings.Repeat("export const answer = 42;\n", 200)
+	for name, content := range map[string]string{
+		"assets/app.js":     js,
+		"index.html":        "<html>",                       // too small
+		"logo.png":          strings.Repeat("\x89PNG", 500), // not text
+		"assets/lib.css":    strings.Repeat("a{color:red}", 200),
+		"assets/lib.css.gz": "shipped by the build",
+	} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	site := config.SiteConfig{Precompress: []string{config.EncodingGzip, config.EncodingBrotli}}
+	if err := NewFrontendDeployer(&config.Config{}, Managers{}).precompress(site, dir); err != nil {
+		t.Fatalf("precompress() error = %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "assets/app.js.gz"))
+	if err != nil {
+		t.Fatalf("app.js.gz not written: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != js {
+		t.Error("app.js.gz does not decompress to app.js")
+	}
+
+	br, err := os.ReadFile(filepath.Join(dir, "assets/app.js.br"))
+	if err != nil {
+		t.Fatalf("app.js.br not written: %v", err)
+	}
+	if got, _ := io.ReadAll(brotli.NewReader(bytes.NewReader(br))); string(got) != js {
+		t.Error("app.js.br does not decompress to app.js")
+	}
+
+	for _, name := range []string{"index.html.gz", "logo.png.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s should not be written", name)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "assets/lib.css.gz")); string(got) != "shipped by the build" {
+		t.Error("a compressed copy the build ships should be kept")
+	}
+}
@@ -0,0 +1,51 @@
+package deploy
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/lachierussell/shipyard/jsonl"
+)
+
+// Promotion records "latest" being re-pointed at an already-deployed commit
+type Promotion struct {
+	Time           time.Time `json:"time"`
+	Site           string    `json:"site"`
+	Subdomain      string    `json:"subdomain,omitempty"` // wildcard sites only
+	Commit         string    `json:"commit"`
+	PreviousCommit string    `json:"previous_commit,omitempty"`
+	From           string    `json:"from,omitempty"` // staging site, for /deploy/promote-env
+	Initiator      string    `json:"initiator"`
+	RemoteAddr     string    `json:"remote_addr,omitempty"`
+	RequestID      string    `json:"request_id,omitempty"`
+}
+
+// PromotionsPath returns the promotion log location within a state directory
+func PromotionsPath(stateDir string) string {
+	return filepath.Join(stateDir, "promotions.jsonl")
+}
+
+// PromotionLog is an append-only JSON lines log of promotions
+type PromotionLog struct {
+	log *jsonl.Log
+}
+
+// NewPromotionLog creates a PromotionLog stored at path
+func NewPromotionLog(path string) *PromotionLog {
+	return &PromotionLog{log: jsonl.New(path, jsonl.MaxSize)}
+}
+
+// Append adds a promotion to the log
+func (l *PromotionLog) Append(p Promotion) error {
+	if p.Time.IsZero() {
+		p.Time = time.Now().UTC()
+	}
+	return l.log.Append(p)
+}
+
+// List returns the promotions for a site ("" for all), newest first
+func (l *PromotionLog) List(site string) ([]Promotion, error) {
+	return jsonl.Read(l.log, func(p Promotion) bool {
+		return site == "" || p.Site == site
+	})
+}
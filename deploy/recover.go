@@ -0,0 +1,198 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/journal"
+	"github.com/lachierussell/shipyard/nginx"
+)
+
+// Recovery actions
+const (
+	RecoveryRolledBack = "rolled_back"
+	RecoveryResumed    = "resumed"
+	RecoverySkipped    = "skipped"
+)
+
+// RecoveryResult describes what startup recovery did with an interrupted deploy
+type RecoveryResult struct {
+	Entry  journal.Entry
+	Action string
+	Err    error
+}
+
+// journalFor returns the deploy journal for cfg
+func journalFor(cfg *config.Config) *journal.Journal {
+	return journal.New(filepath.Join(cfg.StateDir(), "journal"))
+}
+
+// BackendDeployInProgress reports whether a backend deploy of site has
+// begun and not yet finished
+func BackendDeployInProgress(cfg *config.Config, site string) bool {
+	entries, err := journalFor(cfg).Pending()
+	if err != nil {
+		return true // unknown; assume the worst
+	}
+	for _, e := range entries {
+		if e.Site == site && e.Kind == journal.KindBackend {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordAbandoned journals an operation that was still running when shutdown
+// stopped waiting for it, so the next start reports it
+func RecordAbandoned(cfg *config.Config, operation, site, commit string, started time.Time) error {
+	j := journalFor(cfg)
+	e, err := j.Begin(journal.KindAbandoned, site, commit)
+	if err != nil {
+		return err
+	}
+	e.Operation = operation
+	e.Started = started.UTC()
+	return j.Save(e)
+}
+
+// Recover finds deploys interrupted by a crash or power loss and brings each
+// site back to a consistent state:
+//   - frontend, mid-extract: the partial commit directory is removed
+//   - frontend, mid-symlink: the flip is redone (the commit was fully extracted)
+//   - frontend, mid-nginx: the previous site and override configs are restored
+//   - backend, before the new binary was started: the previous binary is restored and started
+//   - backend, mid-start: the new binary is started
+//   - an operation abandoned at shutdown: logged, so the site can be checked
+//
+// Call once at startup, before serving requests. Nil managers in mgrs get the
+// real ones.
+func Recover(cfg *config.Config, mgrs Managers) ([]RecoveryResult, error) {
+	mgrs = mgrs.withDefaults(cfg)
+	j := journalFor(cfg)
+	entries, err := j.Pending()
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+
+	var results []RecoveryResult
+	for _, e := range entries {
+		log := slog.With("site", e.Site, "commit", e.Commit, "kind", e.Kind, "step", e.Step)
+		log.Warn("found interrupted deploy, recovering")
+
+		var action string
+		var err error
+		if _, ok := cfg.Site[e.Site]; !ok {
+			// Site was removed from config since; nothing left to repair
+			action = RecoverySkipped
+		} else {
+			switch e.Kind {
+			case journal.KindFrontend:
+				action, err = recoverFrontend(cfg, mgrs, j, e)
+			case journal.KindBackend:
+				action, err = recoverBackend(cfg, mgrs, e)
+			case journal.KindAbandoned:
+				log.Warn("operation was abandoned at shutdown and may not have finished; check the site", "operation", e.Operation, "started", e.Started)
+				action = RecoverySkipped
+			default:
+				action, err = RecoverySkipped, fmt.Errorf("unknown deploy kind %q", e.Kind)
+			}
+		}
+
+		if err != nil {
+			log.Error("deploy recovery failed", "action", action, "error", err)
+		} else {
+			log.Info("deploy recovered", "action", action)
+		}
+		results = append(results, RecoveryResult{Entry: *e, Action: action, Err: err})
+
+		// Failed recoveries stay journaled so they are retried on the next start
+		if err == nil {
+			j.Complete(e)
+		}
+	}
+	return results, nil
+}
+
+func recoverFrontend(cfg *config.Config, mgrs Managers, j *journal.Journal, e *journal.Entry) (string, error) {
+	site := cfg.Site[e.Site]
+	frontendRoot := site.FrontendRoot
+	if e.Subdomain != "" {
+		frontendRoot = site.SubdomainRoot(e.Subdomain)
+	}
+
+	switch e.Step {
+	case "", journal.StepExtract:
+		if e.CommitDirCreated {
+			if err := os.RemoveAll(filepath.Join(frontendRoot, e.Commit)); err != nil {
+				return RecoveryRolledBack, fmt.Errorf("remove partial commit dir: %w", err)
+			}
+		}
+		return RecoveryRolledBack, nil
+
+	case journal.StepSymlink:
+		fd := NewFrontendDeployer(cfg, mgrs)
+		if err := fd.updateLatestSymlink(frontendRoot, e.Commit); err != nil {
+			return RecoveryResumed, fmt.Errorf("update symlink: %w", err)
+		}
+		return RecoveryResumed, nil
+
+	case journal.StepNginx:
+		if err := j.Restore(e); err != nil {
+			return RecoveryRolledBack, err
+		}
+		if err := mgrs.Nginx.Reload(context.Background()); err != nil {
+			return RecoveryRolledBack, err
+		}
+		return RecoveryRolledBack, nil
+	}
+	return RecoverySkipped, fmt.Errorf("unknown step %q", e.Step)
+}
+
+func recoverBackend(cfg *config.Config, mgrs Managers, e *journal.Entry) (string, error) {
+	site := cfg.Site[e.Site]
+	if site.Backend == nil {
+		return RecoverySkipped, nil
+	}
+	// The interrupted deploy may have left nginx answering 503
+	defer nginx.SetMaintenance(e.Site, false)
+
+	bd := NewBackendDeployer(cfg, mgrs)
+	jailMgr := bd.jails
+	destPath := filepath.Join("/usr/local/bin", site.Backend.BinaryName)
+
+	switch e.Step {
+	case "":
+		// Nothing was touched yet
+		return RecoverySkipped, nil
+
+	case journal.StepServiceStop, journal.StepBinaryCopy:
+		if err := jailMgr.Start(context.Background(), e.Site); err != nil {
+			return RecoveryRolledBack, fmt.Errorf("start pot: %w", err)
+		}
+		if e.PrevBinary {
+			restore := func() error { return jailMgr.Exec(context.Background(), e.Site, "cp", "-p", destPath+".prev", destPath) }
+			if err := jailMgr.Writable(e.Site, restore); err != nil {
+				return RecoveryRolledBack, fmt.Errorf("restore previous binary: %w", err)
+			}
+		}
+		if err := bd.startBackend(e.Site); err != nil {
+			return RecoveryRolledBack, err
+		}
+		return RecoveryRolledBack, nil
+
+	case journal.StepServiceStart:
+		if err := jailMgr.Start(context.Background(), e.Site); err != nil {
+			return RecoveryResumed, fmt.Errorf("start pot: %w", err)
+		}
+		if err := bd.startBackend(e.Site); err != nil {
+			return RecoveryResumed, err
+		}
+		return RecoveryResumed, nil
+	}
+	return RecoverySkipped, fmt.Errorf("unknown step %q", e.Step)
+}
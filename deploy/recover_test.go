@@ -0,0 +1,128 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/journal"
+)
+
+func recoveryConfig(t *testing.T) *config.Config {
+	t.Helper()
+	dir := t.TempDir()
+	return &config.Config{
+		Self: config.SelfConfig{StateDir: filepath.Join(dir, "state")},
+		Site: map[string]config.SiteConfig{
+			"example.com": {FrontendRoot: filepath.Join(dir, "www")},
+		},
+	}
+}
+
+func TestRecover_RemovesPartialCommitDir(t *testing.T) {
+	cfg := recoveryConfig(t)
+	root := cfg.Site["example.com"].FrontendRoot
+	partial := filepath.Join(root, "abc1234")
+	if err := os.MkdirAll(partial, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	j := journalFor(cfg)
+	e, err := j.Begin(journal.KindFrontend, "example.com", "abc1234")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	e.CommitDirCreated = true
+	if err := j.Step(e, journal.StepExtract); err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+
+	results, err := Recover(cfg, Managers{})
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Action != RecoveryRolledBack || results[0].Err != nil {
+		t.Fatalf("Recover() = %+v, want one clean rollback", results)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("partial commit dir still exists")
+	}
+
+	pending, _ := j.Pending()
+	if len(pending) != 0 {
+		t.Errorf("journal still has %d entries after recovery", len(pending))
+	}
+}
+
+func TestRecover_ResumesSymlink(t *testing.T) {
+	cfg := recoveryConfig(t)
+	root := cfg.Site["example.com"].FrontendRoot
+	if err := os.MkdirAll(filepath.Join(root, "abc1234"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	j := journalFor(cfg)
+	e, _ := j.Begin(journal.KindFrontend, "example.com", "abc1234")
+	j.Step(e, journal.StepSymlink)
+
+	results, err := Recover(cfg, Managers{})
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Action != RecoveryResumed || results[0].Err != nil {
+		t.Fatalf("Recover() = %+v, want one clean resume", results)
+	}
+
+	target, err := os.Readlink(filepath.Join(root, "latest"))
+	if err != nil {
+		t.Fatalf("latest symlink missing: %v", err)
+	}
+	if filepath.Base(target) != "abc1234" {
+		t.Errorf("latest -> %q, want abc1234", target)
+	}
+}
+
+func TestRecover_ResumesSubdomainSymlink(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Self: config.SelfConfig{StateDir: filepath.Join(dir, "state")},
+		Site: map[string]config.SiteConfig{
+			"*.docs.example.com": {FrontendRoot: filepath.Join(dir, "www")},
+		},
+	}
+	root := cfg.Site["*.docs.example.com"].SubdomainRoot("pr-42")
+	if err := os.MkdirAll(filepath.Join(root, "abc1234"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	j := journalFor(cfg)
+	e, _ := j.Begin(journal.KindFrontend, "*.docs.example.com", "abc1234")
+	e.Subdomain = "pr-42"
+	j.Step(e, journal.StepSymlink)
+
+	results, err := Recover(cfg, Managers{})
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Action != RecoveryResumed || results[0].Err != nil {
+		t.Fatalf("Recover() = %+v, want one clean resume", results)
+	}
+	if _, err := os.Readlink(filepath.Join(root, "latest")); err != nil {
+		t.Errorf("subdomain latest symlink missing: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "www", "latest")); !os.IsNotExist(err) {
+		t.Errorf("site-level latest symlink should not be created")
+	}
+}
+
+func TestRecover_NoJournal(t *testing.T) {
+	cfg := recoveryConfig(t)
+	results, err := Recover(cfg, Managers{})
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Recover() = %+v, want nothing", results)
+	}
+}
@@ -0,0 +1,15 @@
+package deploy
+
+import "log/slog"
+
+// acquireSlot takes one of slots, logging when the deploy has to wait for
+// one. The returned func gives it back.
+func acquireSlot(slots chan struct{}, log *slog.Logger) func() {
+	select {
+	case slots <- struct{}{}:
+	default:
+		log.Info("waiting for a deploy slot", "limit", cap(slots))
+		slots <- struct{}{}
+	}
+	return func() { <-slots }
+}
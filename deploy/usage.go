@@ -0,0 +1,160 @@
+package deploy
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/jail"
+)
+
+// CommitUsage is the disk used by one deployed frontend commit
+type CommitUsage struct {
+	Commit string `json:"commit"`
+	Bytes  int64  `json:"bytes"`
+	Latest bool   `json:"latest,omitempty"`
+}
+
+// Usage is a site's disk usage
+type Usage struct {
+	Site          string        `json:"site"`
+	FrontendBytes int64         `json:"frontend_bytes"`
+	Commits       []CommitUsage `json:"commits"`
+	AssetsBytes   int64         `json:"assets_bytes"`
+	JailBytes     int64         `json:"jail_bytes"`
+	LogBytes      int64         `json:"log_bytes"` // included in jail_bytes
+	TotalBytes    int64         `json:"total_bytes"`
+	QuotaBytes    int64         `json:"quota_bytes,omitempty"`
+}
+
+// QuotaError is returned when a deploy would take a site over its quota
+type QuotaError struct {
+	Site       string
+	UsedBytes  int64
+	AddedBytes int64
+	QuotaBytes int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("site %s would use %d MB of its %d MB quota (%d MB in use, %d MB incoming); remove old commits or raise quota_mb",
+		e.Site, (e.UsedBytes+e.AddedBytes)>>20, e.QuotaBytes>>20, e.UsedBytes>>20, e.AddedBytes>>20)
+}
+
+// SiteUsage measures the disk used by a site's frontend commits and jail
+func SiteUsage(cfg *config.Config, siteName string) (*Usage, error) {
+	site, ok := cfg.Site[siteName]
+	if !ok {
+		return nil, fmt.Errorf("site not found: %s", siteName)
+	}
+
+	u := &Usage{Site: siteName, Commits: []CommitUsage{}, QuotaBytes: site.QuotaBytes()}
+
+	if site.HasFrontend() {
+		commits, err := commitUsage(site.FrontendRoot)
+		if err != nil {
+			return nil, err
+		}
+		u.Commits = commits
+		for _, c := range commits {
+			u.FrontendBytes += c.Bytes
+		}
+		assets, err := dirSize(site.AssetsDir())
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("size assets: %w", err)
+		}
+		u.AssetsBytes = assets
+	}
+
+	if site.Backend != nil {
+		jailMgr := jail.NewDriver(cfg)
+		// A pot that hasn't been created yet uses nothing
+		if used, err := jailMgr.DiskUsage(siteName); err == nil {
+			u.JailBytes = used
+		}
+		if logPath, err := jailMgr.LogPath(siteName); err == nil {
+			if info, err := os.Stat(logPath); err == nil {
+				u.LogBytes = info.Size()
+			}
+		}
+	}
+
+	u.TotalBytes = u.FrontendBytes + u.AssetsBytes + u.JailBytes
+	return u, nil
+}
+
+// CheckQuota returns a *QuotaError if adding bytes would take the site over
+// its quota. Sites without a quota always pass.
+func CheckQuota(cfg *config.Config, siteName string, bytes int64) error {
+	site, ok := cfg.Site[siteName]
+	if !ok {
+		return fmt.Errorf("site not found: %s", siteName)
+	}
+	quota := site.QuotaBytes()
+	if quota == 0 {
+		return nil
+	}
+
+	u, err := SiteUsage(cfg, siteName)
+	if err != nil {
+		return fmt.Errorf("measure usage: %w", err)
+	}
+	if u.TotalBytes+bytes > quota {
+		return &QuotaError{Site: siteName, UsedBytes: u.TotalBytes, AddedBytes: bytes, QuotaBytes: quota}
+	}
+	return nil
+}
+
+// commitUsage sizes each commit directory under frontendRoot, largest first
+func commitUsage(frontendRoot string) ([]CommitUsage, error) {
+	entries, err := os.ReadDir(frontendRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []CommitUsage{}, nil
+		}
+		return nil, fmt.Errorf("read frontend root: %w", err)
+	}
+
+	latest, _, _ := LatestCommit(frontendRoot)
+
+	commits := []CommitUsage{}
+	for _, entry := range entries {
+		// Skip the latest symlink, the assets directory and anything that isn't a commit directory
+		if !entry.IsDir() || entry.Name() == config.AssetsDirName {
+			continue
+		}
+		size, err := dirSize(filepath.Join(frontendRoot, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("size %s: %w", entry.Name(), err)
+		}
+		commits = append(commits, CommitUsage{
+			Commit: entry.Name(),
+			Bytes:  size,
+			Latest: entry.Name() == latest,
+		})
+	}
+
+	sort.Slice(commits, func(i, j int) bool { return commits[i].Bytes > commits[j].Bytes })
+	return commits, nil
+}
+
+// dirSize sums the sizes of regular files under dir
+func dirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
@@ -0,0 +1,77 @@
+package deploy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func usageConfig(t *testing.T, quotaMB int) *config.Config {
+	t.Helper()
+	root := t.TempDir()
+	for commit, size := range map[string]int{"aaaaaaa": 1 << 20, "bbbbbbb": 3 << 20} {
+		dir := filepath.Join(root, commit, "dist")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "app.js"), []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("aaaaaaa/dist", filepath.Join(root, "latest")); err != nil {
+		t.Fatal(err)
+	}
+	return &config.Config{
+		Site: map[string]config.SiteConfig{
+			"example.com": {FrontendRoot: root, QuotaMB: quotaMB},
+		},
+	}
+}
+
+func TestSiteUsage_PerCommit(t *testing.T) {
+	cfg := usageConfig(t, 0)
+
+	u, err := SiteUsage(cfg, "example.com")
+	if err != nil {
+		t.Fatalf("SiteUsage() error = %v", err)
+	}
+	if len(u.Commits) != 2 {
+		t.Fatalf("Commits = %+v, want 2 (latest symlink excluded)", u.Commits)
+	}
+	if u.Commits[0].Commit != "bbbbbbb" || u.Commits[0].Bytes != 3<<20 {
+		t.Errorf("Commits[0] = %+v, want largest commit bbbbbbb first", u.Commits[0])
+	}
+	if !u.Commits[1].Latest || u.Commits[0].Latest {
+		t.Errorf("latest not marked on aaaaaaa: %+v", u.Commits)
+	}
+	if u.FrontendBytes != 4<<20 || u.TotalBytes != 4<<20 {
+		t.Errorf("FrontendBytes = %d, TotalBytes = %d, want %d", u.FrontendBytes, u.TotalBytes, 4<<20)
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		quotaMB int
+		adding  int64
+		wantErr bool
+	}{
+		{"no quota", 0, 100 << 20, false},
+		{"under quota", 10, 1 << 20, false},
+		{"over quota", 5, 2 << 20, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := usageConfig(t, tt.quotaMB)
+			err := CheckQuota(cfg, "example.com", tt.adding)
+			var quotaErr *QuotaError
+			if got := errors.As(err, &quotaErr); got != tt.wantErr {
+				t.Fatalf("CheckQuota() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Package firewall restricts the outbound traffic of backends with per-jail pf
+// anchors. Jails share the host's network stack, so rules match the UID of the
+// backend's run_as user rather than an address.
+package firewall
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/jail"
+	"github.com/lachierussell/shipyard/runner"
+)
+
+// AnchorRoot is the pf anchor the per-jail anchors live under. The main
+// ruleset must load it with `anchor "shipyard/*"`.
+const AnchorRoot = "shipyard"
+
+// pfctl runs pfctl, which only loads or flushes one small anchor
+var pfctl = &runner.Runner{Timeout: 30 * time.Second}
+
+// Manager loads and flushes the backends' pf anchors
+type Manager struct {
+	cfg   *config.Config
+	jails *jail.Manager
+}
+
+// NewManager creates a firewall manager
+func NewManager(cfg *config.Config) *Manager {
+	return &Manager{cfg: cfg, jails: jail.NewManager(cfg)}
+}
+
+// Anchor returns the pf anchor holding a site's rules
+func Anchor(siteName string) string {
+	return AnchorRoot + "/" + strings.ReplaceAll(siteName, ".", "-")
+}
+
+// Apply loads the site's outbound allowlist into its anchor. Sites without a
+// firewall have their anchor flushed, in case they had one before.
+func (m *Manager) Apply(siteName string) error {
+	site, ok := m.cfg.Site[siteName]
+	if !ok || site.Backend == nil || site.Backend.Firewall == nil {
+		if err := m.Remove(siteName); err != nil {
+			slog.Debug("flush firewall anchor", "site", siteName, "error", err)
+		}
+		return nil
+	}
+
+	rules, err := site.Backend.Firewall.Rules()
+	if err != nil {
+		return err
+	}
+	uid, err := m.jails.UserID(siteName)
+	if err != nil {
+		return err
+	}
+	var resolvers []string
+	for _, r := range rules {
+		if r.DNS {
+			if resolvers, err = m.resolvers(siteName); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	slog.Info("loading firewall rules", "site", siteName, "anchor", Anchor(siteName), "uid", uid, "allow", len(rules))
+	ruleset := strings.NewReader(Render(siteName, uid, rules, resolvers))
+	if output, err := pfctl.RunInput(context.Background(), ruleset, "pfctl", "-a", Anchor(siteName), "-f", "-"); err != nil {
+		return fmt.Errorf("pfctl load %s: %w: %s", Anchor(siteName), err, string(output))
+	}
+	return nil
+}
+
+// Remove flushes the site's anchor, lifting its restrictions
+func (m *Manager) Remove(siteName string) error {
+	if output, err := pfctl.Run(context.Background(), "pfctl", "-a", Anchor(siteName), "-F", "rules"); err != nil {
+		return fmt.Errorf("pfctl flush %s: %w: %s", Anchor(siteName), err, string(output))
+	}
+	return nil
+}
+
+// Sync applies the rules of every backend, logging the ones that fail
+func (m *Manager) Sync() {
+	for name, site := range m.cfg.Site {
+		if site.Backend == nil {
+			continue
+		}
+		if err := m.Apply(name); err != nil {
+			slog.Warn("firewall rules not loaded", "site", name, "error", err)
+		}
+	}
+}
+
+// resolvers returns the nameservers in the jail's resolv.conf
+func (m *Manager) resolvers(siteName string) ([]string, error) {
+	root, err := m.jails.RootPath(siteName)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(root, "etc", "resolv.conf"))
+	if err != nil {
+		return nil, fmt.Errorf("read jail resolv.conf: %w", err)
+	}
+	defer f.Close()
+	servers := parseResolvConf(f)
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("firewall allows dns but the jail's resolv.conf has no nameservers")
+	}
+	return servers, nil
+}
+
+// parseResolvConf returns the nameserver addresses in resolv.conf data
+func parseResolvConf(r io.Reader) []string {
+	var servers []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// Render returns the anchor's ruleset: the allowlist passes, and every other
+// TCP or UDP connection the user makes is refused
+func Render(siteName string, uid int, rules []config.OutboundRule, resolvers []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# shipyard outbound policy for %s\n", siteName)
+	for _, r := range rules {
+		switch {
+		case r.DNS:
+			fmt.Fprintf(&b, "pass out quick proto { tcp udp } to { %s } port 53 user %d\n", strings.Join(resolvers, " "), uid)
+		case r.Port > 0:
+			fmt.Fprintf(&b, "pass out quick proto { tcp udp } to %s port %d user %d\n", r.Host, r.Port, uid)
+		default:
+			fmt.Fprintf(&b, "pass out quick proto { tcp udp } to %s user %d\n", r.Host, uid)
+		}
+	}
+	fmt.Fprintf(&b, "block return out quick proto { tcp udp } user %d\n", uid)
+	return b.String()
+}
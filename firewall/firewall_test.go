@@ -0,0 +1,70 @@
+package firewall
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/runner"
+)
+
+func TestRender(t *testing.T) {
+	rules := []config.OutboundRule{
+		{DNS: true},
+		{Host: "api.stripe.com", Port: 443},
+		{Host: "10.0.0.0/8"},
+	}
+	got := Render("api.example.com", 30005, rules, []string{"192.0.2.53", "2001:db8::53"})
+	want := `# shipyard outbound policy for api.example.com
+pass out quick proto { tcp udp } to { 192.0.2.53 2001:db8::53 } port 53 user 30005
+pass out quick proto { tcp udp } to api.stripe.com port 443 user 30005
+pass out quick proto { tcp udp } to 10.0.0.0/8 user 30005
+block return out quick proto { tcp udp } user 30005
+`
+	if got != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRender_EmptyAllowlistBlocksEverything(t *testing.T) {
+	got := Render("api.example.com", 30005, nil, nil)
+	if strings.Contains(got, "pass") || !strings.Contains(got, "block return out quick proto { tcp udp } user 30005") {
+		t.Errorf("Render() =\n%s", got)
+	}
+}
+
+func TestParseResolvConf(t *testing.T) {
+	conf := "# Generated by resolvconf\nsearch example.com\nnameserver 192.0.2.53\nnameserver fe80::1%em0\nnameserver 2001:db8::53\n"
+	got := parseResolvConf(strings.NewReader(conf))
+	if want := []string{"192.0.2.53", "2001:db8::53"}; !slices.Equal(got, want) {
+		t.Errorf("parseResolvConf = %v, want %v", got, want)
+	}
+}
+
+func TestAnchor(t *testing.T) {
+	if got := Anchor("api.example.com"); got != "shipyard/api-example-com" {
+		t.Errorf("Anchor = %q", got)
+	}
+}
+
+func TestRemove_RunsPfctlThroughRunner(t *testing.T) {
+	var got []string
+	restore := runner.SetHook(func(ctx context.Context, name string, args []string) ([]byte, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("pfctl has no timeout")
+		}
+		got = append([]string{name}, args...)
+		return nil, nil
+	})
+	defer restore()
+
+	// A site without a firewall has its anchor flushed
+	if err := NewManager(&config.Config{}).Apply("api.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"pfctl", "-a", "shipyard/api-example-com", "-F", "rules"}; !slices.Equal(got, want) {
+		t.Errorf("ran %q, want %q", got, want)
+	}
+}
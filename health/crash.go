@@ -0,0 +1,324 @@
+package health
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/jail"
+	"github.com/lachierussell/shipyard/jsonl"
+	"github.com/lachierussell/shipyard/notify"
+)
+
+// crashLogLines is how much of the application log a crash report keeps
+const crashLogLines = 200
+
+// crashCoresKept is how many core files are kept per site; older reports
+// keep their other details
+const crashCoresKept = 3
+
+// CrashReport records a backend exit that shipyard didn't ask for
+type CrashReport struct {
+	ID         string    `json:"id"`
+	Site       string    `json:"site"`
+	Time       time.Time `json:"time"`
+	ExitStatus int       `json:"exit_status"`      // as reported by sh: 128+n for signal n
+	Signal     int       `json:"signal,omitempty"` // set when the backend was killed by a signal
+	CoreFile   string    `json:"core_file,omitempty"`
+	LogTail    []string  `json:"log_tail"`
+}
+
+// CrashLog is an append-only JSON lines log of crash reports. Core files are
+// kept beside it in a crashes directory.
+type CrashLog struct {
+	log      *jsonl.Log
+	coresDir string
+}
+
+// NewCrashLog creates a CrashLog stored in stateDir
+func NewCrashLog(stateDir string) *CrashLog {
+	return &CrashLog{
+		log:      jsonl.New(filepath.Join(stateDir, "crashes.jsonl"), jsonl.MaxSize),
+		coresDir: filepath.Join(stateDir, "crashes"),
+	}
+}
+
+// Append adds a report to the log
+func (l *CrashLog) Append(r CrashReport) error {
+	return l.log.Append(r)
+}
+
+// List returns the reports for a site ("" for all), newest first. Core files
+// that have since been pruned are left out.
+func (l *CrashLog) List(site string) ([]CrashReport, error) {
+	reports, err := jsonl.Read(l.log, func(r CrashReport) bool {
+		return site == "" || r.Site == site
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range reports {
+		if r.CoreFile != "" {
+			if _, err := os.Stat(r.CoreFile); err != nil {
+				reports[i].CoreFile = ""
+			}
+		}
+	}
+	return reports, nil
+}
+
+// saveCore moves a core file out of the jail and prunes the site's older cores
+func (l *CrashLog) saveCore(site, id, src string) (string, error) {
+	if err := os.MkdirAll(l.coresDir, 0700); err != nil {
+		return "", fmt.Errorf("create crash directory: %w", err)
+	}
+	dst := filepath.Join(l.coresDir, site+"-"+id+".core")
+	if err := moveFile(src, dst); err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(l.coresDir)
+	if err != nil {
+		return dst, nil
+	}
+	type core struct {
+		path    string
+		modTime time.Time
+	}
+	var cores []core
+	for _, e := range entries {
+		// <site>-<uuid>.core; the length check keeps "a.com" off "a.com-b.org"
+		rest, ok := strings.CutPrefix(e.Name(), site+"-")
+		if id, isCore := strings.CutSuffix(rest, ".core"); !ok || !isCore || len(id) != 36 {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			cores = append(cores, core{filepath.Join(l.coresDir, e.Name()), info.ModTime()})
+		}
+	}
+	sort.Slice(cores, func(i, j int) bool { return cores[i].modTime.After(cores[j].modTime) })
+	for i := crashCoresKept; i < len(cores); i++ {
+		os.Remove(cores[i].path)
+	}
+	return dst, nil
+}
+
+// backendExit is one line of a jail's app.exit file
+type backendExit struct {
+	Time   time.Time
+	Status int
+}
+
+// parseExits reads the "<unix time> <status>" lines written by the rc script
+func parseExits(data string) []backendExit {
+	var exits []backendExit
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		sec, err1 := strconv.ParseInt(fields[0], 10, 64)
+		status, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		exits = append(exits, backendExit{Time: time.Unix(sec, 0).UTC(), Status: status})
+	}
+	return exits
+}
+
+// CrashCollector turns unexpected backend exits into crash reports on the
+// health poll interval
+type CrashCollector struct {
+	cfg      *config.Config
+	jailMgr  jail.Driver
+	log      *CrashLog
+	notifier *notify.Notifier
+	lastSeen map[string]time.Time // newest exit reported per site
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewCrashCollector creates a collector; call Start to begin watching
+func NewCrashCollector(cfg *config.Config, log *CrashLog, notifier *notify.Notifier) *CrashCollector {
+	return &CrashCollector{
+		cfg:      cfg,
+		jailMgr:  jail.NewDriver(cfg),
+		log:      log,
+		notifier: notifier,
+		lastSeen: make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start checks immediately and then every health poll interval
+func (c *CrashCollector) Start() {
+	interval := c.cfg.Health.PollInterval
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			c.Check()
+			select {
+			case <-ticker.C:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops watching
+func (c *CrashCollector) Stop() {
+	c.stopOnce.Do(func() { close(c.done) })
+}
+
+// Check reports every backend exit recorded since the last check
+func (c *CrashCollector) Check() {
+	for siteName, site := range c.cfg.Site {
+		if site.Backend == nil {
+			continue
+		}
+		root, err := c.jailMgr.RootPath(siteName)
+		if err != nil {
+			continue
+		}
+		c.checkSite(siteName, root)
+	}
+}
+
+// checkSite reports the exits recorded in the jail at root newer than the
+// last one reported
+func (c *CrashCollector) checkSite(siteName, root string) {
+	data, err := os.ReadFile(filepath.Join(root, "var", "log", "app.exit"))
+	if err != nil {
+		return
+	}
+
+	last, ok := c.lastSeen[siteName]
+	if !ok {
+		if reports, err := c.log.List(siteName); err == nil && len(reports) > 0 {
+			last = reports[0].Time
+		}
+	}
+
+	var fresh []backendExit
+	for _, exit := range parseExits(string(data)) {
+		if exit.Time.After(last) {
+			fresh = append(fresh, exit)
+		}
+	}
+	for i, exit := range fresh {
+		// A core is overwritten by the next crash, so it belongs to the newest exit
+		c.capture(siteName, root, exit, i == len(fresh)-1)
+		last = exit.Time
+	}
+	c.lastSeen[siteName] = last
+}
+
+// capture records one exit and sends a notification for it
+func (c *CrashCollector) capture(siteName, root string, exit backendExit, withCore bool) {
+	log := slog.With("site", siteName)
+	report := CrashReport{
+		ID:         uuid.NewString(),
+		Site:       siteName,
+		Time:       exit.Time,
+		ExitStatus: exit.Status,
+		LogTail:    []string{},
+	}
+	if exit.Status > 128 {
+		report.Signal = exit.Status - 128
+	}
+
+	if lines, err := jail.TailFile(filepath.Join(root, "var", "log", "app.log"), crashLogLines); err == nil {
+		report.LogTail = lines
+	}
+
+	// The rc script runs the backend in /var/crash, where the kernel writes
+	// <name>.core
+	if withCore {
+		if core := newestCore(filepath.Join(root, "var", "crash")); core != "" {
+			saved, err := c.log.saveCore(siteName, report.ID, core)
+			if err != nil {
+				log.Warn("save core file", "core", core, "error", err)
+			} else {
+				report.CoreFile = saved
+			}
+		}
+	}
+
+	if err := c.log.Append(report); err != nil {
+		log.Error("record crash", "error", err)
+	}
+
+	cause := fmt.Sprintf("exited with status %d", exit.Status)
+	if report.Signal != 0 {
+		cause = fmt.Sprintf("was killed by signal %d", report.Signal)
+	}
+	c.notifier.Send(notify.Event{
+		Kind:    notify.KindBackendCrash,
+		Site:    siteName,
+		Time:    exit.Time,
+		Message: fmt.Sprintf("backend of %s %s and was restarted", siteName, cause),
+		Details: report,
+	})
+}
+
+// newestCore returns the most recent core file in dir
+func newestCore(dir string) string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.core"))
+	newest := ""
+	var newestTime time.Time
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if newest == "" || info.ModTime().After(newestTime) {
+			newest, newestTime = path, info.ModTime()
+		}
+	}
+	return newest
+}
+
+// moveFile moves src to dst; the jail and the state directory are usually
+// different datasets, so it copies and removes
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("copy %s: %w", src, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("copy %s: %w", src, err)
+	}
+	return os.Remove(src)
+}
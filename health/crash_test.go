@@ -0,0 +1,146 @@
+package health
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// testJail lays out the parts of a pot's root the crash collector reads
+func testJail(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, dir := range []string{"var/log", "var/crash"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func appendExit(t *testing.T, root string, at time.Time, status int) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(root, "var/log/app.exit"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%d %d\n", at.Unix(), status)
+}
+
+func TestCrashCollector_CheckSite(t *testing.T) {
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"api.example.com": {Backend: &config.BackendConfig{ListenPort: 8080}},
+	}}
+	root := testJail(t)
+	log := NewCrashLog(t.TempDir())
+	c := NewCrashCollector(cfg, log, nil)
+
+	var lines []string
+	for i := 0; i < 250; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	os.WriteFile(filepath.Join(root, "var/log/app.log"), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	os.WriteFile(filepath.Join(root, "var/crash/api.core"), []byte("core"), 0644)
+
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	appendExit(t, root, start, 1)
+	appendExit(t, root, start.Add(10*time.Second), 139)
+	c.checkSite("api.example.com", root)
+
+	reports, err := log.List("api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, want 2", len(reports))
+	}
+	newest := reports[0]
+	if newest.ExitStatus != 139 || newest.Signal != 11 || !newest.Time.Equal(start.Add(10*time.Second)) {
+		t.Errorf("newest report = %+v", newest)
+	}
+	if len(newest.LogTail) != crashLogLines || newest.LogTail[crashLogLines-1] != "line 249" {
+		t.Errorf("log tail has %d lines, last %q", len(newest.LogTail), newest.LogTail[len(newest.LogTail)-1])
+	}
+	if newest.CoreFile == "" {
+		t.Error("core file not attached to the newest crash")
+	} else if data, _ := os.ReadFile(newest.CoreFile); string(data) != "core" {
+		t.Errorf("saved core = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "var/crash/api.core")); !os.IsNotExist(err) {
+		t.Error("core left in the jail")
+	}
+	if reports[1].ExitStatus != 1 || reports[1].Signal != 0 || reports[1].CoreFile != "" {
+		t.Errorf("older report = %+v", reports[1])
+	}
+
+	// Nothing new: no more reports, even from a fresh collector after a restart
+	c.checkSite("api.example.com", root)
+	NewCrashCollector(cfg, log, nil).checkSite("api.example.com", root)
+	if reports, _ := log.List("api.example.com"); len(reports) != 2 {
+		t.Errorf("got %d reports after rechecking, want 2", len(reports))
+	}
+
+	appendExit(t, root, start.Add(20*time.Second), 0)
+	c.checkSite("api.example.com", root)
+	if reports, _ := log.List(""); len(reports) != 3 || reports[0].ExitStatus != 0 {
+		t.Errorf("after another exit: %d reports", len(reports))
+	}
+}
+
+func TestCrashLog_SaveCorePrunes(t *testing.T) {
+	log := NewCrashLog(t.TempDir())
+	src := t.TempDir()
+
+	var saved []string
+	for i := 0; i < crashCoresKept+2; i++ {
+		core := filepath.Join(src, "api.core")
+		os.WriteFile(core, []byte("core"), 0644)
+		path, err := log.saveCore("a.com", fmt.Sprintf("00000000-0000-0000-0000-%012d", i), core)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Make the order unambiguous on filesystems with coarse timestamps
+		at := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(path, at, at)
+		saved = append(saved, path)
+	}
+	// Another site's core sharing the name prefix is not pruned
+	other := filepath.Join(src, "other.core")
+	os.WriteFile(other, []byte("core"), 0644)
+	otherPath, err := log.saveCore("a.com-b.org", "00000000-0000-0000-0000-000000000000", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core := filepath.Join(src, "api.core")
+	os.WriteFile(core, []byte("core"), 0644)
+	if _, err := log.saveCore("a.com", "00000000-0000-0000-0000-000000000099", core); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining := 0
+	for _, path := range saved {
+		if _, err := os.Stat(path); err == nil {
+			remaining++
+		}
+	}
+	if remaining != crashCoresKept-1 {
+		t.Errorf("%d older cores kept, want %d", remaining, crashCoresKept-1)
+	}
+	if _, err := os.Stat(otherPath); err != nil {
+		t.Errorf("other site's core pruned: %v", err)
+	}
+}
+
+func TestParseExits(t *testing.T) {
+	exits := parseExits("1700000000 1\ngarbage\n1700000005 134\n\n")
+	if len(exits) != 2 || exits[0].Status != 1 || exits[1].Status != 134 || exits[1].Time.Unix() != 1700000005 {
+		t.Errorf("parseExits = %+v", exits)
+	}
+}
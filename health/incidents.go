@@ -0,0 +1,220 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lachierussell/shipyard/notify"
+)
+
+// maxIncidents is how many incidents are kept, across all sites
+const maxIncidents = 500
+
+// Incident is a period a site failed health.failure_threshold or more
+// probes in a row
+type Incident struct {
+	ID    string     `json:"id"`
+	Site  string     `json:"site"`
+	Start time.Time  `json:"start"`         // the first failed probe
+	End   *time.Time `json:"end,omitempty"` // the probe that found it healthy again; nil while open
+	// Failures counts the failed probes in a row, up to the end
+	Failures int `json:"consecutive_failures"`
+	// Restarts counts the repairs the reconciler made to its backend
+	// while the incident was open
+	Restarts int `json:"restarts"`
+}
+
+// IncidentsPath returns the incident log location within a state directory
+func IncidentsPath(stateDir string) string {
+	return filepath.Join(stateDir, "incidents.json")
+}
+
+// failStreak is a run of failed probes that hasn't become an incident yet
+type failStreak struct {
+	start time.Time
+	count int
+}
+
+// IncidentLog turns runs of failed site probes into incidents: one opens
+// when a site fails threshold probes in a row, and closes on its next
+// healthy probe. Both are notified. It is saved to disk after every round
+// of probes that changed it.
+type IncidentLog struct {
+	path      string
+	threshold int
+	restarts  func(site string) int
+	notifier  *notify.Notifier
+	mu        sync.Mutex
+	incidents []Incident // oldest first
+	streaks   map[string]failStreak
+	base      map[string]int // restarts(site) when its open incident began
+	dirty     bool
+}
+
+// NewIncidentLog loads the incidents stored at path. restarts, which may be
+// nil, returns how many times a site's backend has been repaired so far.
+func NewIncidentLog(path string, threshold int, restarts func(site string) int, notifier *notify.Notifier) *IncidentLog {
+	l := &IncidentLog{
+		path:      path,
+		threshold: threshold,
+		restarts:  restarts,
+		notifier:  notifier,
+		incidents: []Incident{},
+		streaks:   make(map[string]failStreak),
+		base:      make(map[string]int),
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &l.incidents); err != nil {
+			slog.Warn("ignoring unreadable incident log", "path", path, "error", err)
+			l.incidents = []Incident{}
+		}
+	}
+	return l
+}
+
+// Observe adds a probe of a site at a time, opening, updating or closing
+// its incident
+func (l *IncidentLog) Observe(site string, healthy bool, at time.Time) {
+	if l == nil {
+		return
+	}
+	var event *notify.Event
+	l.mu.Lock()
+	open := l.open(site)
+	switch {
+	case healthy && open != nil:
+		end := at.UTC()
+		open.End = &end
+		open.Restarts = l.restartsSince(site, open)
+		delete(l.base, site)
+		l.dirty = true
+		event = &notify.Event{
+			Kind:    notify.KindIncidentResolved,
+			Site:    site,
+			Time:    end,
+			Message: fmt.Sprintf("%s is healthy again after %s", site, end.Sub(open.Start).Round(time.Second)),
+			Details: *open,
+		}
+	case healthy:
+		delete(l.streaks, site)
+	case open != nil:
+		open.Failures++
+		open.Restarts = l.restartsSince(site, open)
+		l.dirty = true
+	default:
+		s := l.streaks[site]
+		if s.count == 0 {
+			s.start = at.UTC()
+		}
+		s.count++
+		l.streaks[site] = s
+		if s.count < l.threshold {
+			break
+		}
+		delete(l.streaks, site)
+		inc := Incident{ID: uuid.NewString(), Site: site, Start: s.start, Failures: s.count}
+		l.incidents = append(l.incidents, inc)
+		if len(l.incidents) > maxIncidents {
+			l.incidents = l.incidents[len(l.incidents)-maxIncidents:]
+		}
+		if l.restarts != nil {
+			l.base[site] = l.restarts(site)
+		}
+		l.dirty = true
+		event = &notify.Event{
+			Kind:    notify.KindIncidentOpened,
+			Site:    site,
+			Time:    at.UTC(),
+			Message: fmt.Sprintf("%s failed %d health checks in a row", site, s.count),
+			Details: inc,
+		}
+	}
+	l.mu.Unlock()
+
+	if event != nil {
+		l.notifier.Send(*event)
+	}
+}
+
+// open returns a site's open incident, or nil. l.mu must be held.
+func (l *IncidentLog) open(site string) *Incident {
+	for i := len(l.incidents) - 1; i >= 0; i-- {
+		if l.incidents[i].Site == site {
+			if l.incidents[i].End == nil {
+				return &l.incidents[i]
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// restartsSince returns how many repairs were made to a site's backend
+// since its open incident began. An incident still open from before a
+// restart of shipyard keeps the repairs it had counted. l.mu must be held.
+func (l *IncidentLog) restartsSince(site string, open *Incident) int {
+	if l.restarts == nil {
+		return open.Restarts
+	}
+	now := l.restarts(site)
+	base, ok := l.base[site]
+	if !ok {
+		base = now - open.Restarts
+		l.base[site] = base
+	}
+	return now - base
+}
+
+// List returns the incidents of a site ("" for all), newest first; with
+// openOnly, only those still open
+func (l *IncidentLog) List(site string, openOnly bool) []Incident {
+	if l == nil {
+		return []Incident{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := []Incident{}
+	for i := len(l.incidents) - 1; i >= 0; i-- {
+		inc := l.incidents[i]
+		if (site == "" || inc.Site == site) && (!openOnly || inc.End == nil) {
+			list = append(list, inc)
+		}
+	}
+	return list
+}
+
+// Save writes the incidents to disk through a temporary file, if they
+// changed since the last save
+func (l *IncidentLog) Save() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(l.incidents)
+	l.dirty = false
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encode incidents: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("create incident directory: %w", err)
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write incidents: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("write incidents: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package health
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIncidentLog_OpensAtThresholdAndClosesOnRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "incidents.json")
+	repairs := 0
+	l := NewIncidentLog(path, 3, func(string) int { return repairs }, nil)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(min int) time.Time { return start.Add(time.Duration(min) * time.Minute) }
+
+	l.Observe("api.example.com", false, at(0))
+	l.Observe("api.example.com", false, at(1))
+	if got := l.List("", false); len(got) != 0 {
+		t.Fatalf("incident opened below threshold: %+v", got)
+	}
+	l.Observe("api.example.com", true, at(2))
+	l.Observe("api.example.com", false, at(3))
+	l.Observe("api.example.com", false, at(4))
+	if got := l.List("", false); len(got) != 0 {
+		t.Fatalf("a healthy probe should reset the run: %+v", got)
+	}
+
+	l.Observe("api.example.com", false, at(5))
+	open := l.List("api.example.com", true)
+	if len(open) != 1 || !open[0].Start.Equal(at(3)) || open[0].Failures != 3 {
+		t.Fatalf("open incidents = %+v, want one from the first failure with 3 failures", open)
+	}
+
+	repairs = 2
+	l.Observe("api.example.com", false, at(6))
+	l.Observe("api.example.com", true, at(7))
+	got := l.List("", false)
+	if len(got) != 1 || got[0].End == nil || !got[0].End.Equal(at(7)) {
+		t.Fatalf("incidents = %+v, want one closed at recovery", got)
+	}
+	if got[0].Failures != 4 || got[0].Restarts != 2 {
+		t.Errorf("failures, restarts = %d, %d; want 4, 2", got[0].Failures, got[0].Restarts)
+	}
+	if open := l.List("", true); len(open) != 0 {
+		t.Errorf("open incidents after recovery = %+v", open)
+	}
+
+	if err := l.Save(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewIncidentLog(path, 3, nil, nil).List("", false)
+	if len(reloaded) != 1 || reloaded[0].ID != got[0].ID {
+		t.Errorf("reloaded incidents = %+v, want %+v", reloaded, got)
+	}
+}
@@ -0,0 +1,185 @@
+package health
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/jail"
+)
+
+// ProcessMetrics are resource figures for a backend's processes inside its jail
+type ProcessMetrics struct {
+	Processes  int       `json:"processes"`
+	CPUPercent float64   `json:"cpu_percent"` // summed over processes; 100 = one core
+	RSSBytes   uint64    `json:"rss_bytes"`
+	OpenFDs    int       `json:"open_fds"`
+	PID        int       `json:"pid,omitempty"` // the backend binary, as run under daemon(8)
+	Restarts   int       `json:"restarts"`      // restarts after exits seen since shipyard started
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// supervisor tracks the daemon(8) process of a backend and its last child
+type supervisor struct {
+	daemonPID int
+	childPID  int
+}
+
+// MetricsCollector samples backend processes on the health poll interval
+type MetricsCollector struct {
+	cfg         *config.Config
+	jailMgr     *jail.Manager
+	openFiles   func(pid int) (int, error)
+	mu          sync.RWMutex
+	metrics     map[string]ProcessMetrics
+	supervisors map[string]supervisor
+	done        chan struct{}
+	stopOnce    sync.Once
+}
+
+// NewMetricsCollector creates a collector; call Start to begin sampling
+func NewMetricsCollector(cfg *config.Config) *MetricsCollector {
+	return &MetricsCollector{
+		cfg:         cfg,
+		jailMgr:     jail.NewManager(cfg),
+		openFiles:   jail.OpenFiles,
+		metrics:     make(map[string]ProcessMetrics),
+		supervisors: make(map[string]supervisor),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start samples immediately and then every health poll interval
+func (m *MetricsCollector) Start() {
+	interval := m.cfg.Health.PollInterval
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			m.Collect()
+			select {
+			case <-ticker.C:
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops sampling
+func (m *MetricsCollector) Stop() {
+	m.stopOnce.Do(func() { close(m.done) })
+}
+
+// Collect takes one sample of every backend
+func (m *MetricsCollector) Collect() {
+	procs, err := m.jailMgr.Processes()
+	if err != nil {
+		slog.Debug("list jail processes", "error", err)
+		return
+	}
+	m.record(procs, time.Now())
+}
+
+// record updates the metrics from a process listing
+func (m *MetricsCollector) record(procs map[string][]jail.Process, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for siteName, site := range m.cfg.Site {
+		if site.Backend == nil {
+			continue
+		}
+		seen[siteName] = true
+
+		list := procs[siteName]
+		pm := ProcessMetrics{Processes: len(list), UpdatedAt: now, Restarts: m.metrics[siteName].Restarts}
+		for _, p := range list {
+			pm.CPUPercent += p.CPU
+			pm.RSSBytes += p.RSS
+			if fds, err := m.openFiles(p.PID); err == nil {
+				pm.OpenFDs += fds
+			}
+		}
+
+		// The restart policy starts a new child when the backend exits. A new
+		// child under the same daemon is a crash restart; a new daemon is a
+		// deploy or service restart.
+		daemonPID, childPID := supervisedProcess(list)
+		prev := m.supervisors[siteName]
+		if daemonPID != 0 && daemonPID == prev.daemonPID && childPID != 0 && prev.childPID != 0 && childPID != prev.childPID {
+			pm.Restarts++
+		}
+		if childPID == 0 && daemonPID == prev.daemonPID {
+			childPID = prev.childPID // between exit and restart; keep the last child
+		}
+		m.supervisors[siteName] = supervisor{daemonPID: daemonPID, childPID: childPID}
+		pm.PID = childPID
+
+		m.metrics[siteName] = pm
+	}
+
+	for siteName := range m.metrics {
+		if !seen[siteName] {
+			delete(m.metrics, siteName)
+			delete(m.supervisors, siteName)
+		}
+	}
+}
+
+// supervisedProcess finds the daemon(8) process and the backend it
+// supervises, looking through the rc script's sh wrapper
+func supervisedProcess(procs []jail.Process) (daemonPID, childPID int) {
+	for _, p := range procs {
+		if p.Command == "daemon" {
+			daemonPID = p.PID
+			break
+		}
+	}
+	if daemonPID == 0 {
+		return 0, 0
+	}
+	child := childOf(procs, daemonPID)
+	if child != nil && child.Command == "sh" {
+		child = childOf(procs, child.PID)
+	}
+	if child == nil {
+		return daemonPID, 0
+	}
+	return daemonPID, child.PID
+}
+
+// childOf returns a child of the process ppid
+func childOf(procs []jail.Process, ppid int) *jail.Process {
+	for i := range procs {
+		if procs[i].PPID == ppid {
+			return &procs[i]
+		}
+	}
+	return nil
+}
+
+// Get returns the latest metrics for a site
+func (m *MetricsCollector) Get(siteName string) (ProcessMetrics, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pm, ok := m.metrics[siteName]
+	return pm, ok
+}
+
+// All returns the latest metrics for every backend
+func (m *MetricsCollector) All() map[string]ProcessMetrics {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make(map[string]ProcessMetrics, len(m.metrics))
+	for k, v := range m.metrics {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/jail"
+)
+
+func TestMetricsCollector_Record(t *testing.T) {
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"api.example.com": {Backend: &config.BackendConfig{ListenPort: 8080}},
+		"www.example.com": {FrontendRoot: "/var/www/www"},
+	}}
+	m := NewMetricsCollector(cfg)
+	m.openFiles = func(pid int) (int, error) { return 10, nil }
+
+	sample := func(child int) map[string][]jail.Process {
+		procs := []jail.Process{{PID: 100, PPID: 1, CPU: 0.1, RSS: 1 << 20, Command: "daemon"}}
+		if child != 0 {
+			procs = append(procs, jail.Process{PID: child, PPID: 100, CPU: 25, RSS: 64 << 20, Command: "api"})
+		}
+		return map[string][]jail.Process{"api.example.com": procs}
+	}
+
+	m.record(sample(200), time.Now())
+	pm, ok := m.Get("api.example.com")
+	if !ok {
+		t.Fatal("no metrics recorded")
+	}
+	if pm.Processes != 2 || pm.CPUPercent != 25.1 || pm.RSSBytes != 65<<20 || pm.OpenFDs != 20 || pm.PID != 200 || pm.Restarts != 0 {
+		t.Errorf("metrics = %+v", pm)
+	}
+	if _, ok := m.Get("www.example.com"); ok {
+		t.Error("frontend-only site has metrics")
+	}
+
+	// The backend exits and daemon restarts it: one restart, however many
+	// samples fall between the exit and the new child
+	m.record(sample(0), time.Now())
+	m.record(sample(0), time.Now())
+	m.record(sample(300), time.Now())
+	m.record(sample(300), time.Now())
+	if pm, _ := m.Get("api.example.com"); pm.Restarts != 1 || pm.PID != 300 {
+		t.Errorf("after crash: metrics = %+v, want 1 restart", pm)
+	}
+
+	// A new daemon (deploy or service restart) is not a crash
+	m.record(map[string][]jail.Process{"api.example.com": {
+		{PID: 400, PPID: 1, Command: "daemon"},
+		{PID: 401, PPID: 400, Command: "sh"},
+		{PID: 402, PPID: 401, Command: "api"},
+	}}, time.Now())
+	if pm, _ := m.Get("api.example.com"); pm.Restarts != 1 || pm.PID != 402 {
+		t.Errorf("after service restart: metrics = %+v, want 1 restart and the backend's PID", pm)
+	}
+}
@@ -2,8 +2,12 @@ package health
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -104,31 +108,108 @@ func (m *Monitor) checkAllServices() {
 	}
 }
 
-// checkService performs a health check on a single service
+// checkService performs a liveness check on a single service, so a backend
+// that is up but not ready yet is not restarted
 func (m *Monitor) checkService(siteName string, site *config.SiteConfig) bool {
 	if site.Backend == nil {
 		return true
 	}
 
-	// Make HTTP request to health endpoint
+	if err := ProbeLiveness(site.Backend, 5*time.Second); err != nil {
+		slog.Debug("health check failed", "site", siteName, "error", err)
+		return false
+	}
+	return true
+}
+
+// maxHealthBody is how much of a health response is searched for health_body
+const maxHealthBody = 64 << 10
+
+// Probe makes a single HTTP request to a backend's health endpoint and
+// returns an error unless it answers as the backend's health check expects
+// (by default 200 OK from health.health_path) within timeout. Backends that
+// don't speak plain HTTP (grpc, fastcgi, uwsgi) only need to accept a connection.
+func Probe(backend *config.BackendConfig, h config.HealthConfig, timeout time.Duration) error {
+	if backend.BackendProtocol() != config.ProtocolHTTP {
+		addr := net.JoinHostPort(backend.JailIP, strconv.Itoa(backend.ListenPort))
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return fmt.Errorf("connect %s: %w", addr, err)
+		}
+		return conn.Close()
+	}
+
+	check := backend.HealthCheck(h)
 	healthURL := fmt.Sprintf("http://%s:%d%s",
-		site.Backend.JailIP,
-		site.Backend.ListenPort,
-		m.cfg.Health.HealthPath,
+		backend.JailIP,
+		backend.ListenPort,
+		check.Path,
 	)
 
 	client := &http.Client{
-		Timeout: 5 * time.Second,
+		Timeout: timeout,
 	}
 
 	resp, err := client.Get(healthURL)
 	if err != nil {
-		slog.Debug("health check failed", "site", siteName, "url", healthURL, "error", err)
-		return false
+		return fmt.Errorf("GET %s: %w", healthURL, err)
 	}
 	defer resp.Body.Close()
 
-	return resp.StatusCode == http.StatusOK
+	if resp.StatusCode != check.Status {
+		return fmt.Errorf("GET %s: status %d, want %d", healthURL, resp.StatusCode, check.Status)
+	}
+	if check.Body != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBody))
+		if err != nil {
+			return fmt.Errorf("GET %s: read body: %w", healthURL, err)
+		}
+		if !strings.Contains(string(body), check.Body) {
+			return fmt.Errorf("GET %s: response does not contain %q", healthURL, check.Body)
+		}
+	}
+	return nil
+}
+
+// WaitHealthy polls a backend's health check until it passes or timeout runs out
+func WaitHealthy(backend *config.BackendConfig, h config.HealthConfig, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := Probe(backend, h, 2*time.Second)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// ProbeLiveness checks whether a backend is alive: its liveness_path answers
+// 2xx or 3xx within timeout, or without one, its port accepts a connection.
+// Unlike Probe, a backend that is up but not ready yet passes.
+func ProbeLiveness(backend *config.BackendConfig, timeout time.Duration) error {
+	addr := net.JoinHostPort(backend.JailIP, strconv.Itoa(backend.ListenPort))
+	if backend.LivenessPath == "" || backend.BackendProtocol() != config.ProtocolHTTP {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return fmt.Errorf("connect %s: %w", addr, err)
+		}
+		return conn.Close()
+	}
+
+	livenessURL := "http://" + addr + backend.LivenessPath
+	client := &http.Client{
+		Timeout:       timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get(livenessURL)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", livenessURL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("GET %s: status %d", livenessURL, resp.StatusCode)
+	}
+	return nil
 }
 
 // GetStatus returns the current status of all services
@@ -0,0 +1,81 @@
+package health
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestProbe_HealthCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte("ok"))
+		case "/healthz":
+			w.WriteHeader(http.StatusNoContent)
+		case "/ready":
+			w.Write([]byte(`{"status":"starting"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	host, portStr, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	for _, tt := range []struct {
+		name    string
+		backend config.BackendConfig
+		global  config.HealthConfig
+		ok      bool
+	}{
+		{"default path", config.BackendConfig{}, config.HealthConfig{}, true},
+		{"global path", config.BackendConfig{}, config.HealthConfig{HealthPath: "/missing"}, false},
+		{"backend path overrides global", config.BackendConfig{HealthPath: "/health"}, config.HealthConfig{HealthPath: "/missing"}, true},
+		{"unexpected status", config.BackendConfig{HealthPath: "/healthz"}, config.HealthConfig{}, false},
+		{"expected status", config.BackendConfig{HealthPath: "/healthz", HealthStatus: 204}, config.HealthConfig{}, true},
+		{"body found", config.BackendConfig{HealthBody: "ok"}, config.HealthConfig{}, true},
+		{"body missing", config.BackendConfig{HealthPath: "/ready", HealthBody: `"status":"ok"`}, config.HealthConfig{}, false},
+	} {
+		tt.backend.JailIP, tt.backend.ListenPort = host, port
+		if err := Probe(&tt.backend, tt.global, time.Second); (err == nil) != tt.ok {
+			t.Errorf("%s: Probe() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestProbeLiveness(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/livez":
+			w.WriteHeader(http.StatusNoContent)
+		case "/moved":
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		default:
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	host, portStr, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	for _, tt := range []struct {
+		path string
+		ok   bool
+	}{
+		{"", true}, // the port accepts connections
+		{"/livez", true},
+		{"/moved", true},
+		{"/ready", false},
+	} {
+		backend := config.BackendConfig{JailIP: host, ListenPort: port, LivenessPath: tt.path}
+		if err := ProbeLiveness(&backend, time.Second); (err == nil) != tt.ok {
+			t.Errorf("liveness_path %q: ProbeLiveness() = %v, want ok=%v", tt.path, err, tt.ok)
+		}
+	}
+}
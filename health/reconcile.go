@@ -0,0 +1,468 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/jail"
+	"github.com/lachierussell/shipyard/service"
+)
+
+// Reconcile actions
+const (
+	ActionStartJail      = "start_jail"      // the jail was down: start it and the backend
+	ActionStartBackend   = "start_backend"   // the supervisor was gone: start the service
+	ActionRestartBackend = "restart_backend" // the process ran but failed its liveness check
+)
+
+// Backend statuses, from BackendState.Status
+const (
+	BackendStopped  = "stopped"   // not enabled, or never deployed
+	BackendDown     = "down"      // its jail or process is not running
+	BackendStarting = "starting"  // within its startup grace and not ready yet
+	BackendReady    = "ready"     // passes its health check
+	BackendNotReady = "not_ready" // running but failing its health check
+)
+
+// reconcileStrikes is how many checks in a row must find the same drift
+// before it is repaired, so a backend a deploy or base update stops for a
+// moment is left alone
+const reconcileStrikes = 2
+
+// reconcileDialTimeout bounds the check that a backend's port is listening,
+// and its liveness and health checks
+const reconcileDialTimeout = 2 * time.Second
+
+// bootPollInterval is how often Boot checks whether a backend that others
+// depend on is ready yet
+const bootPollInterval = 2 * time.Second
+
+// giveUpPrefix starts the line the supervisor logs when the restart policy
+// leaves a backend down
+const giveUpPrefix = "shipyard: backend exited"
+
+// BackendState is what a reconcile check found for a backend
+type BackendState struct {
+	Enabled      bool      `json:"enabled"`  // its service starts at boot
+	Deployed     bool      `json:"deployed"` // its binary is in the jail
+	JailRunning  bool      `json:"jail_running"`
+	ProcessAlive bool      `json:"process_alive"`
+	Listening    bool      `json:"listening"`
+	GaveUp       bool      `json:"gave_up"` // its restart policy left it down
+	CheckedAt    time.Time `json:"checked_at"`
+	// Live is whether it passes its liveness check (liveness_path, or the
+	// port listening); a backend that isn't is restarted
+	Live bool `json:"live"`
+	// Ready is whether it passes its health check (health_path); a backend
+	// that isn't is reported, not restarted
+	Ready bool `json:"ready"`
+	// Starting is whether it started within its startup grace and isn't
+	// ready yet, so failed liveness checks are not held against it
+	Starting  bool      `json:"starting"`
+	StartedAt time.Time `json:"started_at,omitempty"` // when it last started or exited
+}
+
+// Status summarises the state as one of the Backend statuses
+func (st BackendState) Status() string {
+	switch {
+	case !st.Enabled || !st.Deployed:
+		return BackendStopped
+	case !st.JailRunning || !st.ProcessAlive:
+		return BackendDown
+	case st.Ready:
+		return BackendReady
+	case st.Starting:
+		return BackendStarting
+	}
+	return BackendNotReady
+}
+
+// repairFor returns the action that brings a backend back to its desired
+// state: running and live whenever it is enabled and deployed. A backend its
+// restart policy stopped is left down until the next deploy, and one still
+// within its startup grace is given time to come up.
+func repairFor(st BackendState) string {
+	switch {
+	case !st.Enabled || !st.Deployed:
+		return ""
+	case !st.JailRunning:
+		return ActionStartJail
+	case !st.ProcessAlive && st.GaveUp:
+		return ""
+	case !st.ProcessAlive:
+		return ActionStartBackend
+	case st.Starting:
+		return ""
+	case !st.Listening, !st.Live:
+		return ActionRestartBackend
+	}
+	return ""
+}
+
+// strike counts the checks in a row that found the same drift
+type strike struct {
+	action string
+	count  int
+}
+
+// Reconciler compares every backend's desired state with what is running
+// every health.reconcile_interval and repairs drift: it starts stopped jails,
+// starts backends whose supervisor died and restarts ones that stopped
+// listening. Backends being deployed are skipped.
+type Reconciler struct {
+	cfg      *config.Config
+	busy     func(site string) bool
+	inspect  func(siteName string, site config.SiteConfig) BackendState
+	repair   func(siteName, action string) error
+	runMu    sync.Mutex // serialises Check and Boot
+	mu       sync.RWMutex
+	strikes  map[string]strike
+	states   map[string]BackendState
+	actions  map[string]map[string]int // site -> action -> repairs
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewReconciler creates a reconciler; call Start to begin checking. busy
+// reports whether a site's backend is being deployed.
+func NewReconciler(cfg *config.Config, busy func(site string) bool) *Reconciler {
+	r := &Reconciler{
+		cfg:     cfg,
+		busy:    busy,
+		strikes: make(map[string]strike),
+		states:  make(map[string]BackendState),
+		actions: make(map[string]map[string]int),
+		done:    make(chan struct{}),
+	}
+	driver := jail.NewDriver(cfg)
+	services := service.NewManager(cfg)
+	r.inspect = func(siteName string, site config.SiteConfig) BackendState {
+		return inspectBackend(driver, services, cfg.Health, siteName, site)
+	}
+	r.repair = func(siteName, action string) error {
+		return repairBackend(driver, services, siteName, action)
+	}
+	return r
+}
+
+// Start checks every reconcile interval, beginning one interval from now so
+// startup recovery finishes first. It does nothing when reconciling is off.
+func (r *Reconciler) Start() {
+	interval := r.cfg.Health.ReconcileIntervalDuration()
+	if interval == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.Check()
+			case <-r.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops checking
+func (r *Reconciler) Stop() {
+	r.stopOnce.Do(func() { close(r.done) })
+}
+
+// Check inspects every backend once, in dependency order, and repairs those
+// that have drifted for reconcileStrikes checks in a row. A backend is not
+// repaired until the backends it depends on are ready.
+func (r *Reconciler) Check() {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	states := make(map[string]BackendState)
+	order, _ := r.cfg.BackendOrder()
+	for _, siteName := range order {
+		site := r.cfg.Site[siteName]
+		log := slog.With("site", siteName)
+		if r.busy != nil && r.busy(siteName) {
+			r.setStrike(siteName, "")
+			continue
+		}
+
+		st := r.inspect(siteName, site)
+		states[siteName] = st
+		action := repairFor(st)
+		if r.setStrike(siteName, action) < reconcileStrikes {
+			continue
+		}
+		if dep := WaitingOn(*site.Backend, states); dep != "" {
+			log.Info("backend repair waits for its dependency", "action", action, "depends_on", dep)
+			continue
+		}
+
+		log.Warn("backend drifted from its desired state, repairing", "action", action,
+			"jail_running", st.JailRunning, "process_alive", st.ProcessAlive, "listening", st.Listening, "live", st.Live)
+		if err := r.repair(siteName, action); err != nil {
+			log.Error("backend repair failed", "action", action, "error", err)
+			continue
+		}
+		r.setStrike(siteName, "")
+		r.countRepair(siteName, action)
+	}
+
+	r.mu.Lock()
+	r.states = states
+	r.mu.Unlock()
+}
+
+// BootResult is what Boot found and did for one backend
+type BootResult struct {
+	Site   string `json:"site"`
+	Action string `json:"action,omitempty"` // the repair made, if any
+	Status string `json:"status"`           // the backend's status afterwards
+	Error  string `json:"error,omitempty"`
+}
+
+// Boot brings every backend to its desired state at once, in dependency
+// order, as after a host reboot. Unlike Check it repairs drift the first
+// time it sees it. A backend others depend on is given its startup grace to
+// become ready before they are started; one whose dependency isn't ready is
+// left for Check.
+func (r *Reconciler) Boot() []BootResult {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	order, _ := r.cfg.BackendOrder()
+	dependedOn := make(map[string]bool)
+	for _, siteName := range order {
+		for _, dep := range r.cfg.Site[siteName].Backend.DependsOn {
+			dependedOn[dep] = true
+		}
+	}
+
+	states := make(map[string]BackendState)
+	results := make([]BootResult, 0, len(order))
+	for _, siteName := range order {
+		site := r.cfg.Site[siteName]
+		log := slog.With("site", siteName)
+		if r.busy != nil && r.busy(siteName) {
+			continue
+		}
+
+		res := BootResult{Site: siteName}
+		st := r.inspect(siteName, site)
+		if action := repairFor(st); action != "" {
+			if dep := WaitingOn(*site.Backend, states); dep != "" {
+				res.Error = fmt.Sprintf("not started: %s, which it depends on, is not ready", dep)
+				log.Warn("backend not started at boot", "action", action, "depends_on", dep)
+			} else {
+				res.Action = action
+				log.Info("starting backend at boot", "action", action)
+				if err := r.repair(siteName, action); err != nil {
+					res.Error = err.Error()
+					log.Error("backend repair failed", "action", action, "error", err)
+				} else {
+					r.countRepair(siteName, action)
+				}
+				st = r.inspect(siteName, site)
+			}
+		}
+		if dependedOn[siteName] && res.Error == "" {
+			st = r.waitReady(siteName, site, st)
+		}
+		states[siteName] = st
+		res.Status = st.Status()
+		results = append(results, res)
+	}
+
+	r.mu.Lock()
+	r.states = states
+	r.mu.Unlock()
+	return results
+}
+
+// waitReady inspects an enabled backend until it is ready or its startup
+// grace runs out, and returns the last state found
+func (r *Reconciler) waitReady(siteName string, site config.SiteConfig, st BackendState) BackendState {
+	deadline := time.Now().Add(site.Backend.StartupGraceDuration(r.cfg.Health))
+	for st.Status() != BackendReady && st.Status() != BackendStopped {
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(bootPollInterval)
+		st = r.inspect(siteName, site)
+	}
+	return st
+}
+
+// countRepair records a repair made to a site's backend
+func (r *Reconciler) countRepair(siteName, action string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.actions[siteName] == nil {
+		r.actions[siteName] = make(map[string]int)
+	}
+	r.actions[siteName][action]++
+}
+
+// WaitingOn returns the first backend that backend depends on which states
+// doesn't show ready, or "" when all of them are
+func WaitingOn(backend config.BackendConfig, states map[string]BackendState) string {
+	for _, dep := range backend.DependsOn {
+		if st, ok := states[dep]; !ok || st.Status() != BackendReady {
+			return dep
+		}
+	}
+	return ""
+}
+
+// setStrike records the drift a check found and returns how many checks in
+// a row found it
+func (r *Reconciler) setStrike(siteName, action string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if action == "" {
+		delete(r.strikes, siteName)
+		return 0
+	}
+	s := r.strikes[siteName]
+	if s.action != action {
+		s = strike{action: action}
+	}
+	s.count++
+	r.strikes[siteName] = s
+	return s.count
+}
+
+// Get returns the latest state found for a site's backend
+func (r *Reconciler) Get(site string) (BackendState, bool) {
+	if r == nil {
+		return BackendState{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	st, ok := r.states[site]
+	return st, ok
+}
+
+// States returns the latest state found for every backend
+func (r *Reconciler) States() map[string]BackendState {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	states := make(map[string]BackendState, len(r.states))
+	for site, st := range r.states {
+		states[site] = st
+	}
+	return states
+}
+
+// Actions returns how many repairs of each kind were made per site since
+// shipyard started
+func (r *Reconciler) Actions() map[string]map[string]int {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	all := make(map[string]map[string]int, len(r.actions))
+	for site, counts := range r.actions {
+		all[site] = make(map[string]int, len(counts))
+		for action, n := range counts {
+			all[site][action] = n
+		}
+	}
+	return all
+}
+
+// Repairs returns how many repairs of any kind were made to a site's
+// backend since shipyard started
+func (r *Reconciler) Repairs(site string) int {
+	if r == nil {
+		return 0
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n := 0
+	for _, count := range r.actions[site] {
+		n += count
+	}
+	return n
+}
+
+// inspectBackend finds the actual state of a site's backend
+func inspectBackend(driver jail.Driver, services *service.Manager, h config.HealthConfig, siteName string, site config.SiteConfig) BackendState {
+	st := BackendState{CheckedAt: time.Now(), Enabled: services.Enabled(siteName)}
+	root, err := driver.RootPath(siteName)
+	if err != nil {
+		return st
+	}
+	_, err = os.Stat(filepath.Join(root, "usr", "local", "bin", site.Backend.BinaryName))
+	st.Deployed = err == nil
+
+	st.JailRunning = driver.IsRunning(siteName)
+	if !st.JailRunning {
+		return st
+	}
+	st.ProcessAlive, _ = services.Status(siteName)
+	st.StartedAt = startedAt(root)
+	if !st.ProcessAlive {
+		if logPath, err := driver.LogPath(siteName); err == nil {
+			lines, _ := jail.TailFile(logPath, 1)
+			st.GaveUp = len(lines) == 1 && strings.HasPrefix(lines[0], giveUpPrefix)
+		}
+		return st
+	}
+
+	addr := net.JoinHostPort(site.Backend.JailIP, strconv.Itoa(site.Backend.ListenPort))
+	if conn, err := net.DialTimeout("tcp", addr, reconcileDialTimeout); err == nil {
+		conn.Close()
+		st.Listening = true
+	}
+	if st.Listening {
+		st.Live = ProbeLiveness(site.Backend, reconcileDialTimeout) == nil
+		st.Ready = Probe(site.Backend, h, reconcileDialTimeout) == nil
+	}
+	grace := site.Backend.StartupGraceDuration(h)
+	st.Starting = !st.Ready && !st.StartedAt.IsZero() && time.Since(st.StartedAt) < grace
+	return st
+}
+
+// startedAt returns when the backend in a jail root last started: its
+// supervisor writing its pidfile, or the backend exiting to be restarted
+func startedAt(root string) time.Time {
+	var latest time.Time
+	for _, p := range []string{service.JailPidFile, "/var/log/app.exit"} {
+		if info, err := os.Stat(filepath.Join(root, p)); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// repairBackend carries out a reconcile action
+func repairBackend(driver jail.Driver, services *service.Manager, siteName, action string) error {
+	switch action {
+	case ActionStartJail:
+		if err := driver.Start(context.Background(), siteName); err != nil {
+			return fmt.Errorf("start jail: %w", err)
+		}
+		return services.Start(siteName)
+	case ActionStartBackend:
+		return services.Start(siteName)
+	case ActionRestartBackend:
+		return services.Restart(siteName)
+	}
+	return fmt.Errorf("unknown action %q", action)
+}
@@ -0,0 +1,189 @@
+package health
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestRepairFor(t *testing.T) {
+	running := BackendState{Enabled: true, Deployed: true, JailRunning: true, ProcessAlive: true, Listening: true, Live: true, Ready: true}
+	tests := []struct {
+		name  string
+		state func(st *BackendState)
+		want  string
+	}{
+		{"running", func(st *BackendState) {}, ""},
+		{"disabled", func(st *BackendState) { st.Enabled, st.JailRunning = false, false }, ""},
+		{"never deployed", func(st *BackendState) { st.Deployed, st.ProcessAlive = false, false }, ""},
+		{"jail down", func(st *BackendState) { st.JailRunning, st.ProcessAlive, st.Listening = false, false, false }, ActionStartJail},
+		{"supervisor gone", func(st *BackendState) { st.ProcessAlive, st.Listening = false, false }, ActionStartBackend},
+		{"restart policy gave up", func(st *BackendState) { st.ProcessAlive, st.Listening, st.GaveUp = false, false, true }, ""},
+		{"port closed", func(st *BackendState) { st.Listening = false }, ActionRestartBackend},
+		{"liveness failing", func(st *BackendState) { st.Live = false }, ActionRestartBackend},
+		{"not ready", func(st *BackendState) { st.Ready = false }, ""},
+		{"starting", func(st *BackendState) { st.Listening, st.Live, st.Ready, st.Starting = false, false, false, true }, ""},
+		{"starting but supervisor gone", func(st *BackendState) { st.ProcessAlive, st.Starting = false, true }, ActionStartBackend},
+	}
+	for _, tt := range tests {
+		st := running
+		tt.state(&st)
+		if got := repairFor(st); got != tt.want {
+			t.Errorf("%s: repairFor = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReconciler_Check(t *testing.T) {
+	backend := &config.BackendConfig{JailName: "api", BinaryName: "api"}
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"api.example.com":    {Backend: backend},
+		"busy.example.com":   {Backend: backend},
+		"static.example.com": {FrontendRoot: "/var/www/static"},
+	}}
+	r := NewReconciler(cfg, func(site string) bool { return site == "busy.example.com" })
+	down := BackendState{Enabled: true, Deployed: true}
+	r.inspect = func(siteName string, site config.SiteConfig) BackendState {
+		if siteName == "static.example.com" {
+			t.Error("frontend-only site inspected")
+		}
+		return down
+	}
+	var repairs []string
+	var fail error
+	r.repair = func(siteName, action string) error {
+		repairs = append(repairs, siteName+" "+action)
+		return fail
+	}
+
+	// Drift is only repaired once two checks in a row find it
+	r.Check()
+	if len(repairs) != 0 {
+		t.Fatalf("first check repaired %v", repairs)
+	}
+	if st, ok := r.Get("api.example.com"); !ok || st.JailRunning {
+		t.Errorf("state = %+v, %v; want the jail down", st, ok)
+	}
+	r.Check()
+	if len(repairs) != 1 || repairs[0] != "api.example.com "+ActionStartJail {
+		t.Fatalf("repairs = %v, want one jail start for api.example.com", repairs)
+	}
+	if got := r.Actions()["api.example.com"][ActionStartJail]; got != 1 {
+		t.Errorf("start_jail count = %d, want 1", got)
+	}
+
+	// The count starts over after a repair, and a different drift too
+	r.Check()
+	down.JailRunning = true
+	r.Check()
+	if len(repairs) != 1 {
+		t.Errorf("repairs = %v, want no repair of a new drift seen once", repairs)
+	}
+
+	// Failed repairs aren't counted
+	fail = errors.New("pot start failed")
+	r.Check()
+	if len(repairs) != 2 || r.Actions()["api.example.com"][ActionStartBackend] != 0 {
+		t.Errorf("repairs = %v, actions = %v; want a failed, uncounted start", repairs, r.Actions())
+	}
+}
+
+func TestReconciler_Check_DependsOn(t *testing.T) {
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"api.example.com":  {Backend: &config.BackendConfig{BinaryName: "api", DependsOn: []string{"auth.example.com"}}},
+		"auth.example.com": {Backend: &config.BackendConfig{BinaryName: "auth"}},
+	}}
+	r := NewReconciler(cfg, nil)
+	states := map[string]BackendState{
+		"api.example.com":  {Enabled: true, Deployed: true},
+		"auth.example.com": {Enabled: true, Deployed: true},
+	}
+	r.inspect = func(siteName string, site config.SiteConfig) BackendState { return states[siteName] }
+	var repairs []string
+	r.repair = func(siteName, action string) error {
+		repairs = append(repairs, siteName+" "+action)
+		return nil
+	}
+
+	// The API waits while auth, which it depends on, is down
+	r.Check()
+	r.Check()
+	if len(repairs) != 1 || repairs[0] != "auth.example.com "+ActionStartJail {
+		t.Fatalf("repairs = %v, want only auth.example.com started", repairs)
+	}
+
+	// Once auth is ready the API's drift, already seen twice, is repaired
+	states["auth.example.com"] = BackendState{Enabled: true, Deployed: true, JailRunning: true, ProcessAlive: true, Listening: true, Live: true, Ready: true}
+	r.Check()
+	if len(repairs) != 2 || repairs[1] != "api.example.com "+ActionStartJail {
+		t.Errorf("repairs = %v, want api.example.com started after auth", repairs)
+	}
+}
+
+func TestReconciler_Boot(t *testing.T) {
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"api.example.com":  {Backend: &config.BackendConfig{BinaryName: "api", DependsOn: []string{"auth.example.com"}}},
+		"auth.example.com": {Backend: &config.BackendConfig{BinaryName: "auth"}},
+		"jobs.example.com": {Backend: &config.BackendConfig{BinaryName: "jobs"}},
+	}}
+	ready := BackendState{Enabled: true, Deployed: true, JailRunning: true, ProcessAlive: true, Listening: true, Live: true, Ready: true}
+	states := map[string]BackendState{
+		"api.example.com":  {Enabled: true, Deployed: true},
+		"auth.example.com": {Enabled: true, Deployed: true},
+		"jobs.example.com": ready,
+	}
+	r := NewReconciler(cfg, nil)
+	r.inspect = func(siteName string, site config.SiteConfig) BackendState { return states[siteName] }
+	var repairs []string
+	r.repair = func(siteName, action string) error {
+		repairs = append(repairs, siteName)
+		states[siteName] = ready
+		return nil
+	}
+
+	// Drift is repaired on sight, dependencies first
+	results := r.Boot()
+	if want := []string{"auth.example.com", "api.example.com"}; !slices.Equal(repairs, want) {
+		t.Errorf("repairs = %v, want %v", repairs, want)
+	}
+	for _, res := range results {
+		if res.Status != BackendReady || res.Error != "" {
+			t.Errorf("%s: %+v, want ready", res.Site, res)
+		}
+		if wantAction := res.Site != "jobs.example.com"; (res.Action == ActionStartJail) != wantAction {
+			t.Errorf("%s: action = %q", res.Site, res.Action)
+		}
+	}
+
+	// A backend whose dependency was stopped on purpose is left down
+	repairs = nil
+	states["auth.example.com"] = BackendState{Deployed: true}
+	states["api.example.com"] = BackendState{Enabled: true, Deployed: true}
+	results = r.Boot()
+	if len(repairs) != 0 {
+		t.Errorf("repairs = %v, want none", repairs)
+	}
+	if res := results[2]; res.Site != "api.example.com" || res.Action != "" || res.Status != BackendDown || res.Error == "" {
+		t.Errorf("results[2] = %+v, want api.example.com left down with an error", res)
+	}
+}
+
+func TestBackendState_Status(t *testing.T) {
+	tests := []struct {
+		state BackendState
+		want  string
+	}{
+		{BackendState{}, BackendStopped},
+		{BackendState{Enabled: true, Deployed: true}, BackendDown},
+		{BackendState{Enabled: true, Deployed: true, JailRunning: true, ProcessAlive: true, Starting: true}, BackendStarting},
+		{BackendState{Enabled: true, Deployed: true, JailRunning: true, ProcessAlive: true, Live: true}, BackendNotReady},
+		{BackendState{Enabled: true, Deployed: true, JailRunning: true, ProcessAlive: true, Live: true, Ready: true}, BackendReady},
+	}
+	for _, tt := range tests {
+		if got := tt.state.Status(); got != tt.want {
+			t.Errorf("%+v: Status() = %q, want %q", tt.state, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// Site health states
+const (
+	SiteHealthy   = "healthy"
+	SiteUnhealthy = "unhealthy"
+	SiteUnknown   = "unknown"
+)
+
+const (
+	// siteProbeWorkers bounds the site probes in flight at once
+	siteProbeWorkers = 8
+	// siteProbeTimeout bounds one site probe
+	siteProbeTimeout = 5 * time.Second
+)
+
+// SiteHealth is the latest probe of a site's /health through the local nginx
+type SiteHealth struct {
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// SiteChecker probes every site's public /health on the health poll interval,
+// so listings read cached results instead of probing each site inline. Each
+// round of probes is added to the uptime history and the incident log.
+type SiteChecker struct {
+	cfg       *config.Config
+	uptime    *UptimeLog
+	incidents *IncidentLog
+	probe     func(domain string, sslEnabled bool) string
+	mu        sync.RWMutex
+	results   map[string]SiteHealth
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewSiteChecker creates a checker that records to uptime and incidents,
+// either of which may be nil; call Start to begin probing
+func NewSiteChecker(cfg *config.Config, uptime *UptimeLog, incidents *IncidentLog) *SiteChecker {
+	return &SiteChecker{
+		cfg:       cfg,
+		uptime:    uptime,
+		incidents: incidents,
+		probe:     ProbeSite,
+		results:   make(map[string]SiteHealth),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start probes immediately and then every health poll interval
+func (c *SiteChecker) Start() {
+	interval := c.cfg.Health.PollInterval
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			c.Check()
+			select {
+			case <-ticker.C:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops probing
+func (c *SiteChecker) Stop() {
+	c.stopOnce.Do(func() { close(c.done) })
+}
+
+// Check probes every site, a few at a time, and forgets removed sites
+func (c *SiteChecker) Check() {
+	type target struct {
+		domain string
+		ssl    bool
+	}
+	var targets []target
+	for domain, site := range c.cfg.Site {
+		targets = append(targets, target{domain, site.SSLEnabled})
+	}
+
+	results := make(map[string]SiteHealth, len(targets))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, siteProbeWorkers)
+	for _, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(t target) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			status := c.probe(t.domain, t.ssl)
+			mu.Lock()
+			results[t.domain] = SiteHealth{Status: status, CheckedAt: time.Now()}
+			mu.Unlock()
+		}(t)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	c.results = results
+	c.mu.Unlock()
+
+	for domain, h := range results {
+		c.uptime.Record(domain, h.Status == SiteHealthy, h.CheckedAt)
+		c.incidents.Observe(domain, h.Status == SiteHealthy, h.CheckedAt)
+	}
+	if err := c.uptime.Save(); err != nil {
+		slog.Warn("failed to save uptime history", "error", err)
+	}
+	if err := c.incidents.Save(); err != nil {
+		slog.Warn("failed to save incidents", "error", err)
+	}
+}
+
+// Get returns a site's latest health, unknown until it has been probed
+func (c *SiteChecker) Get(domain string) SiteHealth {
+	if c == nil {
+		return SiteHealth{Status: SiteUnknown}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if h, ok := c.results[domain]; ok {
+		return h
+	}
+	return SiteHealth{Status: SiteUnknown}
+}
+
+// ProbeSite requests a site's /health from the nginx on this host, sending
+// the site's domain as Host and TLS server name. It connects to the loopback
+// address rather than resolving the domain, so sites whose DNS doesn't point
+// here yet are still checked.
+func ProbeSite(domain string, sslEnabled bool) string {
+	scheme, port := "http", "80"
+	if sslEnabled {
+		scheme, port = "https", "443"
+	}
+
+	dialer := &net.Dialer{Timeout: siteProbeTimeout}
+	client := &http.Client{
+		Timeout: siteProbeTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, net.JoinHostPort("127.0.0.1", port))
+			},
+			DisableKeepAlives: true,
+		},
+		// A redirect would leave the loopback connection for the public address
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	resp, err := client.Get(fmt.Sprintf("%s://%s/health", scheme, domain))
+	if err != nil {
+		slog.Debug("site health probe failed", "site", domain, "error", err)
+		return SiteUnknown
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return SiteHealthy
+	}
+	return SiteUnhealthy
+}
@@ -0,0 +1,43 @@
+package health
+
+import (
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestSiteChecker_Check(t *testing.T) {
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"up.example.com":   {SSLEnabled: true},
+		"down.example.com": {},
+	}}
+	c := NewSiteChecker(cfg, nil, nil)
+	var sslSeen bool
+	c.probe = func(domain string, sslEnabled bool) string {
+		if domain == "up.example.com" {
+			sslSeen = sslEnabled
+			return SiteHealthy
+		}
+		return SiteUnhealthy
+	}
+
+	if got := c.Get("up.example.com").Status; got != SiteUnknown {
+		t.Errorf("before Check: status = %q, want unknown", got)
+	}
+	c.Check()
+	if got := c.Get("up.example.com"); got.Status != SiteHealthy || got.CheckedAt.IsZero() {
+		t.Errorf("up.example.com = %+v, want healthy with a check time", got)
+	}
+	if !sslSeen {
+		t.Error("probe not told the site uses SSL")
+	}
+	if got := c.Get("down.example.com").Status; got != SiteUnhealthy {
+		t.Errorf("down.example.com = %q, want unhealthy", got)
+	}
+
+	delete(cfg.Site, "down.example.com")
+	c.Check()
+	if got := c.Get("down.example.com").Status; got != SiteUnknown {
+		t.Errorf("removed site = %q, want unknown", got)
+	}
+}
@@ -0,0 +1,194 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// uptimeDays is how many days of daily uptime are kept per site
+const uptimeDays = 90
+
+// maxOutages is how many of a site's most recent outages are kept
+const maxOutages = 50
+
+// AvailabilityDays is the window of the rolling availability reported in
+// GET /sites and /metrics
+const AvailabilityDays = 30
+
+// uptimeDateFormat names a UptimeDay, in UTC
+const uptimeDateFormat = "2006-01-02"
+
+// UptimeDay counts a site's health probes on one day
+type UptimeDay struct {
+	Date    string `json:"date"` // YYYY-MM-DD, UTC
+	Checks  int    `json:"checks"`
+	Healthy int    `json:"healthy"`
+}
+
+// Outage is a run of failed health probes of a site
+type Outage struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"` // nil while it lasts
+}
+
+// Duration returns how long the outage lasted, or has lasted by now
+func (o Outage) Duration(now time.Time) time.Duration {
+	if o.End != nil {
+		return o.End.Sub(o.Start)
+	}
+	return now.Sub(o.Start)
+}
+
+// SiteUptime is a site's probe history
+type SiteUptime struct {
+	Days    []UptimeDay `json:"days"`    // oldest first
+	Outages []Outage    `json:"outages"` // oldest first
+}
+
+// Percent returns the share of probes that found the site healthy, and
+// false when it hasn't been probed
+func (u SiteUptime) Percent() (float64, bool) {
+	checks, healthy := 0, 0
+	for _, d := range u.Days {
+		checks += d.Checks
+		healthy += d.Healthy
+	}
+	if checks == 0 {
+		return 0, false
+	}
+	return 100 * float64(healthy) / float64(checks), true
+}
+
+// Availability returns the share of probes that found the site healthy on
+// the last days days up to now, today included, and false when it wasn't
+// probed in them
+func (u SiteUptime) Availability(days int, now time.Time) (float64, bool) {
+	since := now.UTC().AddDate(0, 0, 1-days).Format(uptimeDateFormat)
+	checks, healthy := 0, 0
+	for _, d := range u.Days {
+		if d.Date >= since {
+			checks += d.Checks
+			healthy += d.Healthy
+		}
+	}
+	if checks == 0 {
+		return 0, false
+	}
+	return 100 * float64(healthy) / float64(checks), true
+}
+
+// Down reports whether the site's latest outage is still going on
+func (u SiteUptime) Down() bool {
+	return len(u.Outages) > 0 && u.Outages[len(u.Outages)-1].End == nil
+}
+
+// UptimePath returns the uptime history location within a state directory
+func UptimePath(stateDir string) string {
+	return filepath.Join(stateDir, "uptime.json")
+}
+
+// UptimeLog keeps each site's daily probe counts and outages, for the
+// status page. It is saved to disk after every round of probes.
+type UptimeLog struct {
+	path  string
+	mu    sync.Mutex
+	sites map[string]*SiteUptime
+}
+
+// NewUptimeLog loads the uptime history stored at path
+func NewUptimeLog(path string) *UptimeLog {
+	l := &UptimeLog{path: path, sites: make(map[string]*SiteUptime)}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &l.sites); err != nil {
+			slog.Warn("ignoring unreadable uptime history", "path", path, "error", err)
+			l.sites = make(map[string]*SiteUptime)
+		}
+	}
+	return l
+}
+
+// Record adds a probe of a site at a time, opening an outage when it fails
+// and closing it when the site is healthy again
+func (l *UptimeLog) Record(site string, healthy bool, at time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u := l.sites[site]
+	if u == nil {
+		u = &SiteUptime{Days: []UptimeDay{}, Outages: []Outage{}}
+		l.sites[site] = u
+	}
+	date := at.UTC().Format(uptimeDateFormat)
+	if n := len(u.Days); n == 0 || u.Days[n-1].Date != date {
+		u.Days = append(u.Days, UptimeDay{Date: date})
+	}
+	day := &u.Days[len(u.Days)-1]
+	day.Checks++
+	if healthy {
+		day.Healthy++
+	}
+	cutoff := at.UTC().AddDate(0, 0, -uptimeDays).Format(uptimeDateFormat)
+	for u.Days[0].Date <= cutoff {
+		u.Days = u.Days[1:]
+	}
+
+	switch {
+	case !healthy && !u.Down():
+		u.Outages = append(u.Outages, Outage{Start: at.UTC()})
+		if len(u.Outages) > maxOutages {
+			u.Outages = u.Outages[len(u.Outages)-maxOutages:]
+		}
+	case healthy && u.Down():
+		end := at.UTC()
+		u.Outages[len(u.Outages)-1].End = &end
+	}
+}
+
+// Get returns a copy of a site's history
+func (l *UptimeLog) Get(site string) SiteUptime {
+	if l == nil {
+		return SiteUptime{Days: []UptimeDay{}, Outages: []Outage{}}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	u, ok := l.sites[site]
+	if !ok {
+		return SiteUptime{Days: []UptimeDay{}, Outages: []Outage{}}
+	}
+	return SiteUptime{
+		Days:    append([]UptimeDay{}, u.Days...),
+		Outages: append([]Outage{}, u.Outages...),
+	}
+}
+
+// Save writes the history to disk through a temporary file
+func (l *UptimeLog) Save() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	data, err := json.Marshal(l.sites)
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encode uptime history: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("create uptime directory: %w", err)
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write uptime history: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("write uptime history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package health
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUptimeLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uptime.json")
+	l := NewUptimeLog(path)
+	start := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+
+	l.Record("example.com", true, start)
+	l.Record("example.com", false, start.Add(30*time.Second))
+	l.Record("example.com", false, start.Add(90*time.Second)) // the next day
+	u := l.Get("example.com")
+	if len(u.Days) != 2 || u.Days[0] != (UptimeDay{Date: "2026-03-01", Checks: 2, Healthy: 1}) {
+		t.Errorf("days = %+v, want two, the first with 1 of 2 healthy", u.Days)
+	}
+	if len(u.Outages) != 1 || !u.Down() {
+		t.Fatalf("outages = %+v, want one ongoing", u.Outages)
+	}
+	if pct, ok := u.Percent(); !ok || pct < 33.3 || pct > 33.4 {
+		t.Errorf("Percent = %v, %v; want 33.3%%", pct, ok)
+	}
+
+	l.Record("example.com", true, start.Add(2*time.Minute))
+	u = l.Get("example.com")
+	if u.Down() || u.Outages[0].Duration(time.Now()) != 90*time.Second {
+		t.Errorf("outages = %+v, want one of 90s, ended", u.Outages)
+	}
+
+	// Days older than uptimeDays are dropped
+	l.Record("example.com", true, start.AddDate(0, 0, uptimeDays))
+	if u := l.Get("example.com"); len(u.Days) != 2 || u.Days[0].Date != "2026-03-02" {
+		t.Errorf("days = %+v, want the first day dropped", u.Days)
+	}
+
+	if err := l.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if u := NewUptimeLog(path).Get("example.com"); len(u.Days) != 2 || len(u.Outages) != 1 {
+		t.Errorf("reloaded = %+v, want the saved history", u)
+	}
+	if _, ok := l.Get("other.example.com").Percent(); ok {
+		t.Error("a site never probed has an uptime")
+	}
+}
+
+func TestSiteUptime_Availability(t *testing.T) {
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+	u := SiteUptime{Days: []UptimeDay{
+		{Date: "2026-03-01", Checks: 10, Healthy: 0}, // 31 days ago: outside the window
+		{Date: "2026-03-02", Checks: 10, Healthy: 5},
+		{Date: "2026-03-31", Checks: 10, Healthy: 10},
+	}}
+	if pct, ok := u.Availability(AvailabilityDays, now); !ok || pct != 75 {
+		t.Errorf("Availability = %v, %v; want 75", pct, ok)
+	}
+	if _, ok := u.Availability(AvailabilityDays, now.AddDate(0, 2, 0)); ok {
+		t.Error("a site not probed in the window has an availability")
+	}
+}
@@ -0,0 +1,67 @@
+package jail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lachierussell/shipyard/runner"
+)
+
+// updateTimeout is how long freebsd-update may take to fetch and install a
+// jail's updates
+const updateTimeout = time.Hour
+
+// UserlandVersion returns the FreeBSD userland version installed in the pot,
+// e.g. "14.3-RELEASE-p2"
+func (m *Manager) UserlandVersion(siteName string) (string, error) {
+	root, err := m.RootPath(siteName)
+	if err != nil {
+		return "", err
+	}
+	// freebsd-version is a script with the version built in, so the host can run the jail's copy
+	out, err := runner.Output(context.Background(), "/bin/sh", filepath.Join(root, "bin", "freebsd-version"), "-u")
+	if err != nil {
+		return "", fmt.Errorf("freebsd-version: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// UpdateBase fetches and installs FreeBSD security and errata updates into the
+// pot's root with freebsd-update. The root must be writable. It reports
+// whether anything was installed; the backend must be restarted to use it.
+func (m *Manager) UpdateBase(siteName string) (bool, error) {
+	root, err := m.RootPath(siteName)
+	if err != nil {
+		return false, err
+	}
+	version, err := m.UserlandVersion(siteName)
+	if err != nil {
+		return false, err
+	}
+
+	slog.Info("updating jail base", "site", siteName, "version", version)
+	// Stop pagers from waiting for input
+	update := &runner.Runner{Timeout: updateTimeout, Env: []string{"PAGER=cat"}}
+	output, err := update.Run(context.Background(), "freebsd-update", "--not-running-from-cron",
+		"-b", root, "--currently-running", version, "fetch", "install")
+	if strings.Contains(string(output), "No updates are available") {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("freebsd-update: %w: %s", err, lastLines(string(output), 5))
+	}
+	return true, nil
+}
+
+// lastLines returns the last n lines of s, for errors from chatty commands
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,353 @@
+package jail
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/logger"
+	"github.com/lachierussell/shipyard/service"
+)
+
+// containerIdleCommand keeps a backend's container up between deploys, the way
+// a running pot waits for pot exec. It exits on the TERM from docker stop.
+var containerIdleCommand = []string{"/bin/sh", "-c", "trap 'exit 0' TERM; while :; do sleep 3600 & wait $!; done"}
+
+// ContainerDriver runs backends in Docker or Podman containers. Each container
+// shares the host's network like an inherit pot, and its logs, crash dumps and
+// binary live in bind-mounted host directories under
+// <state_dir>/containers/<name>, so recreating it loses nothing.
+type ContainerDriver struct {
+	cfg *config.Config
+}
+
+// NewContainerDriver creates a container driver
+func NewContainerDriver(cfg *config.Config) *ContainerDriver {
+	return &ContainerDriver{cfg: cfg}
+}
+
+// containerName returns the container a site's backend runs in
+func containerName(siteName string) string {
+	return "shipyard-" + potName(siteName)
+}
+
+// backend returns the site's backend config
+func (d *ContainerDriver) backend(siteName string) (*config.BackendConfig, error) {
+	site, ok := d.cfg.Site[siteName]
+	if !ok {
+		return nil, fmt.Errorf("site not found: %s", siteName)
+	}
+	if site.Backend == nil {
+		return nil, fmt.Errorf("site %s has no backend config", siteName)
+	}
+	return site.Backend, nil
+}
+
+// run runs a container runtime command, returning its output. It is killed
+// when ctx ends, or after jail.command_timeout if ctx has no deadline.
+func (d *ContainerDriver) run(ctx context.Context, args ...string) (string, error) {
+	output, err := commands(d.cfg).Run(ctx, d.cfg.Jail.ContainerCmd(), args...)
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", d.cfg.Jail.DriverName(), args[0], err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// hostDir returns the host directory holding a container's bind mounts
+func (d *ContainerDriver) hostDir(siteName string) string {
+	return filepath.Join(d.cfg.StateDir(), "containers", potName(siteName))
+}
+
+// binds returns the container paths bind-mounted from the host directory
+func binds(backend *config.BackendConfig) []string {
+	paths := []string{"/var/log", "/var/crash", "/usr/local/bin"}
+	if backend.ReadOnly {
+		for _, p := range backend.WritablePaths() {
+			if p != "/tmp" && !slices.Contains(paths, p) {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+// createArgs returns the arguments that create a site's container
+func createArgs(cfg *config.Config, siteName string, backend *config.BackendConfig, hostDir string) []string {
+	args := []string{"create", "--name", containerName(siteName), "--network", "host",
+		"--label", "shipyard.site=" + siteName}
+	for _, p := range binds(backend) {
+		args = append(args, "-v", filepath.Join(hostDir, p)+":"+p)
+	}
+	for _, v := range backend.Volumes {
+		mount := filepath.Clean(v.Host) + ":" + filepath.Clean(v.Path)
+		if v.ReadOnly {
+			mount += ":ro"
+		}
+		args = append(args, "-v", mount)
+	}
+	if backend.ReadOnly {
+		args = append(args, "--read-only", "--tmpfs", "/tmp")
+	}
+	// jail.init: the runtime already gives containers a copy of the host's
+	// resolv.conf, so only one set explicitly is mounted
+	if r := cfg.Jail.Init.ResolvConf; r != "" && r != "none" {
+		args = append(args, "-v", r+":/etc/resolv.conf:ro")
+	}
+	if z := cfg.Jail.Init.ZoneinfoPath(); z != "" {
+		args = append(args, "-v", z+":/etc/localtime:ro")
+	}
+	// The label records what the container was created with, so a config
+	// change recreates it
+	sum := sha256.Sum256([]byte(strings.Join(append(args, cfg.Jail.ContainerImage()), "\x00")))
+	args = append(args, "--label", "shipyard.spec="+hex.EncodeToString(sum[:8]))
+	args = append(args, cfg.Jail.ContainerImage())
+	return append(args, containerIdleCommand...)
+}
+
+// specLabel returns the shipyard.spec label in createArgs
+func specLabel(args []string) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "shipyard.spec=") {
+			return strings.TrimPrefix(arg, "shipyard.spec=")
+		}
+	}
+	return ""
+}
+
+// EnsureExists creates the site's container, recreating it if the backend's
+// mounts, jail.init or the image changed. It installs the supervisor and stop scripts
+// each time.
+func (d *ContainerDriver) EnsureExists(ctx context.Context, siteName string) error {
+	backend, err := d.backend(siteName)
+	if err != nil {
+		return err
+	}
+	dir := d.hostDir(siteName)
+	for _, p := range binds(backend) {
+		if err := os.MkdirAll(filepath.Join(dir, p), 0755); err != nil {
+			return fmt.Errorf("create %s: %w", p, err)
+		}
+	}
+	for _, v := range backend.Volumes {
+		if err := os.MkdirAll(filepath.Clean(v.Host), 0755); err != nil {
+			return fmt.Errorf("create volume %s: %w", v.Host, err)
+		}
+	}
+	script := filepath.Join(dir, service.ContainerRunScript)
+	if err := os.WriteFile(script, []byte(service.RunScript()), 0755); err != nil {
+		return fmt.Errorf("install supervisor: %w", err)
+	}
+	script = filepath.Join(dir, service.ContainerStopScript)
+	if err := os.WriteFile(script, []byte(service.StopScript()), 0755); err != nil {
+		return fmt.Errorf("install stop script: %w", err)
+	}
+
+	name := containerName(siteName)
+	args := createArgs(d.cfg, siteName, backend, dir)
+	log := logger.FromContext(ctx)
+	current, err := d.run(ctx, "container", "inspect", "-f", `{{index .Config.Labels "shipyard.spec"}}`, name)
+	if err == nil {
+		if current == specLabel(args) {
+			return nil
+		}
+		log.Info("recreating container for changed config", "site", siteName, "container", name)
+		if _, err := d.run(ctx, "rm", "-f", name); err != nil {
+			return err
+		}
+	}
+
+	log.Info("creating container", "site", siteName, "container", name, "image", d.cfg.Jail.ContainerImage())
+	_, err = d.run(ctx, args...)
+	return err
+}
+
+// Start starts the site's container
+func (d *ContainerDriver) Start(ctx context.Context, siteName string) error {
+	if _, err := d.backend(siteName); err != nil {
+		return err
+	}
+	if d.IsRunning(siteName) {
+		return nil
+	}
+	logger.FromContext(ctx).Info("starting container", "site", siteName, "container", containerName(siteName))
+	_, err := d.run(ctx, "start", containerName(siteName))
+	return err
+}
+
+// Stop stops the site's container
+func (d *ContainerDriver) Stop(ctx context.Context, siteName string) error {
+	if _, err := d.run(ctx, "stop", "-t", "10", containerName(siteName)); err != nil {
+		logger.FromContext(ctx).Debug("container stop failed (may not be running)", "site", siteName, "error", err)
+	}
+	return nil
+}
+
+// Destroy removes the site's container and its host directory
+func (d *ContainerDriver) Destroy(ctx context.Context, siteName string) error {
+	if _, err := d.backend(siteName); err != nil {
+		return nil
+	}
+	logger.FromContext(ctx).Info("destroying container", "site", siteName, "container", containerName(siteName))
+	if _, err := d.run(ctx, "rm", "-f", containerName(siteName)); err != nil {
+		return err
+	}
+	os.RemoveAll(d.hostDir(siteName))
+	return nil
+}
+
+// IsRunning checks if the site's container is running
+func (d *ContainerDriver) IsRunning(siteName string) bool {
+	out, err := d.run(context.Background(), "container", "inspect", "-f", "{{.State.Running}}", containerName(siteName))
+	return err == nil && out == "true"
+}
+
+// EnsureUser gives the run_as user's UID the files the backend writes. The
+// backend runs with the numeric UID, so the image needs no account for it.
+func (d *ContainerDriver) EnsureUser(ctx context.Context, siteName string) error {
+	backend, err := d.backend(siteName)
+	if err != nil {
+		return err
+	}
+	if backend.RunAs == "" {
+		return nil
+	}
+	uid := backend.RunAsUID()
+	if uid == 0 {
+		return fmt.Errorf("run_as needs a jail_ip to derive the container user's UID")
+	}
+
+	dir := d.hostDir(siteName)
+	for _, f := range []string{"var/log/app.log", "var/log/app.exit"} {
+		file, err := os.OpenFile(filepath.Join(dir, f), os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("create log files: %w", err)
+		}
+		file.Close()
+	}
+	for _, p := range []string{"var/log", "var/log/app.log", "var/log/app.exit", "var/crash"} {
+		if err := os.Chown(filepath.Join(dir, p), uid, uid); err != nil {
+			return fmt.Errorf("chown runtime files to %d: %w", uid, err)
+		}
+	}
+	return nil
+}
+
+// CopyIn copies a file into the container. Paths under its bind mounts are
+// written on the host, which also works with a read-only root.
+func (d *ContainerDriver) CopyIn(ctx context.Context, siteName, srcPath, destPath string) error {
+	backend, err := d.backend(siteName)
+	if err != nil {
+		return err
+	}
+
+	hostPath := ""
+	for _, p := range binds(backend) {
+		if rel, err := filepath.Rel(p, filepath.Clean(destPath)); err == nil && !strings.HasPrefix(rel, "..") {
+			hostPath = filepath.Join(d.hostDir(siteName), p, rel)
+			break
+		}
+	}
+	if hostPath == "" {
+		_, err := d.run(ctx, "cp", srcPath, containerName(siteName)+":"+destPath)
+		return err
+	}
+
+	if err := copyFile(srcPath, hostPath); err != nil {
+		return fmt.Errorf("copy %s: %w", destPath, err)
+	}
+	if backend.RunAs != "" {
+		if uid := backend.RunAsUID(); uid > 0 {
+			if err := os.Chown(hostPath, uid, uid); err != nil {
+				return fmt.Errorf("chown %s: %w", destPath, err)
+			}
+		}
+	}
+	return nil
+}
+
+// copyFile replaces dst with a copy of src, keeping its mode
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// Exec runs a command inside the container
+func (d *ContainerDriver) Exec(ctx context.Context, siteName string, command string, args ...string) error {
+	if _, err := d.backend(siteName); err != nil {
+		return err
+	}
+	_, err := d.run(ctx, append([]string{"exec", containerName(siteName), command}, args...)...)
+	return err
+}
+
+// RootPath returns the host directory holding the container's bind mounts,
+// laid out like its root
+func (d *ContainerDriver) RootPath(siteName string) (string, error) {
+	if _, err := d.backend(siteName); err != nil {
+		return "", err
+	}
+	return d.hostDir(siteName), nil
+}
+
+// LogPath returns the host path of the backend's app.log
+func (d *ContainerDriver) LogPath(siteName string) (string, error) {
+	root, err := d.RootPath(siteName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "var", "log", "app.log"), nil
+}
+
+// DiskUsage returns the bytes in the container's writable layer
+func (d *ContainerDriver) DiskUsage(siteName string) (int64, error) {
+	out, err := d.run(context.Background(), "container", "inspect", "--size", "-f", "{{.SizeRw}}", containerName(siteName))
+	if err != nil {
+		return 0, err
+	}
+	used, err := strconv.ParseInt(out, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse container size %q: %w", out, err)
+	}
+	return used, nil
+}
+
+// LockRoot does nothing: a read_only container is created with --read-only
+func (d *ContainerDriver) LockRoot(siteName string) error { return nil }
+
+// UnlockRoot does nothing: deploys only write to bind mounts
+func (d *ContainerDriver) UnlockRoot(siteName string) error { return nil }
+
+// Writable runs fn; bind mounts are writable whether or not the root is
+func (d *ContainerDriver) Writable(siteName string, fn func() error) error { return fn() }
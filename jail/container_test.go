@@ -0,0 +1,81 @@
+package jail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestCreateArgs(t *testing.T) {
+	cfg := &config.Config{Jail: config.JailConfig{Driver: config.DriverDocker}}
+	backend := &config.BackendConfig{
+		ReadOnly: true,
+		Writable: []string{"/var/db/app"},
+		Volumes:  []config.VolumeConfig{{Host: "/data/uploads", Path: "/srv/uploads", ReadOnly: true}},
+	}
+	args := createArgs(cfg, "api.example.com", backend, "/var/db/shipyard/containers/api-example-com")
+	joined := strings.Join(args, " ")
+
+	for _, want := range []string{
+		"create --name shipyard-api-example-com --network host",
+		"-v /var/db/shipyard/containers/api-example-com/var/log:/var/log",
+		"-v /var/db/shipyard/containers/api-example-com/usr/local/bin:/usr/local/bin",
+		"-v /var/db/shipyard/containers/api-example-com/var/db/app:/var/db/app",
+		"-v /data/uploads:/srv/uploads:ro",
+		"--read-only --tmpfs /tmp",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args missing %q: %s", want, joined)
+		}
+	}
+	if i := slices.Index(args, config.DefaultContainerImage); i < 0 || !slices.Equal(args[i+1:], containerIdleCommand) {
+		t.Errorf("args = %q: want the image followed by the idle command", args)
+	}
+
+	cfg.Jail.Init = config.JailInitConfig{ResolvConf: "/etc/resolv.shipyard", Timezone: "Australia/Perth"}
+	joined = strings.Join(createArgs(cfg, "api.example.com", backend, "/x"), " ")
+	for _, want := range []string{
+		"-v /etc/resolv.shipyard:/etc/resolv.conf:ro",
+		"-v /usr/share/zoneinfo/Australia/Perth:/etc/localtime:ro",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args missing %q: %s", want, joined)
+		}
+	}
+
+	// The spec label changes with the config, so the container is recreated
+	before := specLabel(args)
+	backend.ReadOnly = false
+	if after := specLabel(createArgs(cfg, "api.example.com", backend, "/x")); after == "" || after == before {
+		t.Errorf("spec label %q unchanged from %q after config change", after, before)
+	}
+}
+
+func TestContainerCopyIn(t *testing.T) {
+	cfg := &config.Config{
+		Self: config.SelfConfig{StateDir: t.TempDir()},
+		Jail: config.JailConfig{Driver: config.DriverDocker},
+		Site: map[string]config.SiteConfig{"api.example.com": {Backend: &config.BackendConfig{}}},
+	}
+	d := NewContainerDriver(cfg)
+	dir := d.hostDir("api.example.com")
+	os.MkdirAll(filepath.Join(dir, "usr/local/bin"), 0755)
+
+	src := filepath.Join(t.TempDir(), "api")
+	os.WriteFile(src, []byte("binary"), 0755)
+	if err := d.CopyIn(context.Background(), "api.example.com", src, "/usr/local/bin/api"); err != nil {
+		t.Fatalf("CopyIn: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "usr/local/bin/api"))
+	if err != nil {
+		t.Fatalf("binary not written to the bind mount: %v", err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("mode = %v, want 0755", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,56 @@
+package jail
+
+import (
+	"context"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// Driver is the sandbox a backend runs in. Manager implements it with pot
+// jails on FreeBSD and ContainerDriver with Docker or Podman containers on
+// Linux. Features that only exist for pots, such as templates, base updates
+// and firewall UIDs, stay on Manager. Methods that take a ctx kill the
+// commands they run when it ends, or after jail.command_timeout if it has
+// no deadline, and log through its logger.
+type Driver interface {
+	// EnsureExists creates the site's sandbox if it doesn't exist
+	EnsureExists(ctx context.Context, siteName string) error
+	// Start starts the sandbox, without the backend
+	Start(ctx context.Context, siteName string) error
+	// Stop stops the sandbox and everything in it
+	Stop(ctx context.Context, siteName string) error
+	// Destroy removes the sandbox; volumes are left alone
+	Destroy(ctx context.Context, siteName string) error
+	IsRunning(siteName string) bool
+	// EnsureUser prepares the backend's run_as user and the files it writes
+	EnsureUser(ctx context.Context, siteName string) error
+	// CopyIn copies a host file into the sandbox, owned by the run_as user
+	CopyIn(ctx context.Context, siteName, srcPath, destPath string) error
+	// Exec runs a command inside the sandbox and waits for it
+	Exec(ctx context.Context, siteName, command string, args ...string) error
+	// RootPath returns the host directory laid out like the sandbox's root,
+	// holding at least var/log and var/crash
+	RootPath(siteName string) (string, error)
+	// LogPath returns the host path of the backend's app.log
+	LogPath(siteName string) (string, error)
+	// DiskUsage returns the bytes the sandbox uses
+	DiskUsage(siteName string) (int64, error)
+	// LockRoot and UnlockRoot protect a read_only root between deploys
+	LockRoot(siteName string) error
+	UnlockRoot(siteName string) error
+	// Writable runs fn with the root writable
+	Writable(siteName string, fn func() error) error
+}
+
+var (
+	_ Driver = (*Manager)(nil)
+	_ Driver = (*ContainerDriver)(nil)
+)
+
+// NewDriver returns the driver configured by jail.driver
+func NewDriver(cfg *config.Config) Driver {
+	if cfg.Jail.UsesContainers() {
+		return NewContainerDriver(cfg)
+	}
+	return NewManager(cfg)
+}
@@ -0,0 +1,99 @@
+package jail
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// initFiles returns the jail files jail.init keeps in step with the host,
+// mapped to the host file each is copied from
+func initFiles(init config.JailInitConfig) map[string]string {
+	files := map[string]string{}
+	if p := init.ResolvConfPath(); p != "" {
+		files["/etc/resolv.conf"] = p
+	}
+	if p := init.ZoneinfoPath(); p != "" {
+		files["/etc/localtime"] = p
+	}
+	return files
+}
+
+// staleInitFiles returns the contents of the files in files (jail path to
+// host path) that differ under root, keyed by jail path
+func staleInitFiles(root string, files map[string]string) (map[string][]byte, error) {
+	stale := map[string][]byte{}
+	for jailPath, hostPath := range files {
+		want, err := os.ReadFile(hostPath)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", hostPath, err)
+		}
+		// Anything but a regular file, such as a symlink, is replaced
+		dest := filepath.Join(root, jailPath)
+		if info, err := os.Lstat(dest); err == nil && info.Mode().IsRegular() {
+			if have, err := os.ReadFile(dest); err == nil && bytes.Equal(have, want) {
+				continue
+			}
+		}
+		stale[jailPath] = want
+	}
+	return stale, nil
+}
+
+// writeRootFile replaces a file under a jail's root. It renames over the
+// old one, so a symlink the jail put there is replaced rather than followed.
+func writeRootFile(root, jailPath string, data []byte) error {
+	dir := filepath.Join(root, filepath.Dir(jailPath))
+	if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a directory in the jail", filepath.Dir(jailPath))
+	}
+	tmp, err := os.CreateTemp(dir, ".shipyard-init-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(root, jailPath))
+}
+
+// initRoot applies jail.init to a pot's root: the host's resolv.conf and the
+// configured timezone. The root is only unlocked when a file changed.
+func (m *Manager) initRoot(siteName string) error {
+	root, err := m.RootPath(siteName)
+	if err != nil {
+		return err
+	}
+	stale, err := staleInitFiles(root, initFiles(m.cfg.Jail.Init))
+	if err != nil || len(stale) == 0 {
+		return err
+	}
+
+	paths := make([]string, 0, len(stale))
+	for p := range stale {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	slog.Info("initialising jail files", "site", siteName, "files", paths)
+	return m.Writable(siteName, func() error {
+		for _, p := range paths {
+			if err := writeRootFile(root, p, stale[p]); err != nil {
+				return fmt.Errorf("write %s: %w", p, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,64 @@
+package jail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestInitFiles(t *testing.T) {
+	files := initFiles(config.JailInitConfig{})
+	if len(files) != 1 || files["/etc/resolv.conf"] != "/etc/resolv.conf" {
+		t.Errorf("default initFiles = %v, want only the host's resolv.conf", files)
+	}
+	files = initFiles(config.JailInitConfig{ResolvConf: "none", Timezone: "Europe/Berlin"})
+	if len(files) != 1 || files["/etc/localtime"] != "/usr/share/zoneinfo/Europe/Berlin" {
+		t.Errorf("initFiles = %v, want only the Berlin zoneinfo", files)
+	}
+}
+
+func TestStaleInitFiles(t *testing.T) {
+	host := t.TempDir()
+	resolv := filepath.Join(host, "resolv.conf")
+	os.WriteFile(resolv, []byte("nameserver 192.0.2.53\n"), 0644)
+
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	files := map[string]string{"/etc/resolv.conf": resolv}
+
+	stale, err := staleInitFiles(root, files)
+	if err != nil {
+		t.Fatalf("staleInitFiles: %v", err)
+	}
+	if string(stale["/etc/resolv.conf"]) != "nameserver 192.0.2.53\n" {
+		t.Fatalf("stale = %q, want the host's resolv.conf", stale)
+	}
+	if err := writeRootFile(root, "/etc/resolv.conf", stale["/etc/resolv.conf"]); err != nil {
+		t.Fatalf("writeRootFile: %v", err)
+	}
+	if stale, _ := staleInitFiles(root, files); len(stale) != 0 {
+		t.Errorf("stale = %q after writing, want none", stale)
+	}
+
+	// A symlink left in the jail is replaced, not written through
+	target := filepath.Join(host, "target")
+	os.WriteFile(target, []byte("keep"), 0644)
+	os.Remove(filepath.Join(root, "etc", "resolv.conf"))
+	os.Symlink(target, filepath.Join(root, "etc", "resolv.conf"))
+	stale, _ = staleInitFiles(root, files)
+	if err := writeRootFile(root, "/etc/resolv.conf", stale["/etc/resolv.conf"]); err != nil {
+		t.Fatalf("writeRootFile over symlink: %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "keep" {
+		t.Errorf("symlink target = %q, want it untouched", data)
+	}
+	if info, _ := os.Lstat(filepath.Join(root, "etc", "resolv.conf")); !info.Mode().IsRegular() {
+		t.Error("resolv.conf is still a symlink")
+	}
+
+	if _, err := staleInitFiles(root, map[string]string{"/etc/localtime": filepath.Join(host, "missing")}); err == nil {
+		t.Error("expected an error for a missing host file")
+	}
+}
@@ -1,12 +1,18 @@
 package jail
 
 import (
+	"bufio"
+	"context"
 	"fmt"
-	"log/slog"
-	"os/exec"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/logger"
+	"github.com/lachierussell/shipyard/runner"
 )
 
 // Manager handles jail lifecycle operations using pot
@@ -27,14 +33,27 @@ func (m *Manager) potCmd() string {
 	return "pot"
 }
 
+// commands returns the Runner for jail commands. Each is killed after
+// jail.command_timeout unless its ctx has a deadline of its own: callers with
+// a longer job, such as a migration, set one.
+func commands(cfg *config.Config) *runner.Runner {
+	return &runner.Runner{Timeout: cfg.Jail.CommandTimeoutDuration()}
+}
+
+// run runs a pot command, returning its combined output
+func (m *Manager) run(ctx context.Context, args ...string) ([]byte, error) {
+	return commands(m.cfg).Run(ctx, m.potCmd(), args...)
+}
+
 // potName converts a site name to a valid pot name (alphanumeric and hyphens only)
 func potName(siteName string) string {
 	// Replace dots with hyphens for pot compatibility
 	return strings.ReplaceAll(siteName, ".", "-")
 }
 
-// EnsureExists creates a pot if it doesn't exist (idempotent)
-func (m *Manager) EnsureExists(siteName string) error {
+// EnsureExists creates a pot if it doesn't exist (idempotent), then brings
+// the files jail.init manages up to date
+func (m *Manager) EnsureExists(ctx context.Context, siteName string) error {
 	site, ok := m.cfg.Site[siteName]
 	if !ok {
 		return fmt.Errorf("site not found: %s", siteName)
@@ -46,24 +65,29 @@ func (m *Manager) EnsureExists(siteName string) error {
 
 	name := potName(siteName)
 
-	// Check if pot already exists
-	if m.potExists(name) {
-		return nil
+	if !m.potExists(ctx, name) {
+		logger.FromContext(ctx).Info("creating pot", "site", siteName, "pot", name)
+		if err := m.createPot(ctx, siteName); err != nil {
+			return err
+		}
 	}
-
-	// Create pot
-	slog.Info("creating pot", "site", siteName, "pot", name)
-	return m.createPot(siteName)
+	if err := m.initRoot(siteName); err != nil {
+		return fmt.Errorf("initialise pot: %w", err)
+	}
+	return nil
 }
 
 // potExists checks if a pot with the given name exists
-func (m *Manager) potExists(name string) bool {
-	cmd := exec.Command(m.potCmd(), "info", "-p", name)
-	return cmd.Run() == nil
+func (m *Manager) potExists(ctx context.Context, name string) bool {
+	_, err := m.run(ctx, "info", "-p", name)
+	return err == nil
 }
 
-// createPot creates a new pot for a site
-func (m *Manager) createPot(siteName string) error {
+// createPot creates a new pot for a site, cloned from its template if it has one
+func (m *Manager) createPot(ctx context.Context, siteName string) error {
+	if t := m.cfg.Site[siteName].Backend.Template; t != "" {
+		return m.clonePot(ctx, siteName, t)
+	}
 	name := potName(siteName)
 
 	// Create a pot based on the default base
@@ -78,8 +102,7 @@ func (m *Manager) createPot(siteName string) error {
 		"-N", "inherit",
 	}
 
-	cmd := exec.Command(m.potCmd(), args...)
-	output, err := cmd.CombinedOutput()
+	output, err := m.run(ctx, args...)
 	if err != nil {
 		return fmt.Errorf("pot create: %w: %s", err, string(output))
 	}
@@ -88,7 +111,7 @@ func (m *Manager) createPot(siteName string) error {
 }
 
 // Start starts a pot
-func (m *Manager) Start(siteName string) error {
+func (m *Manager) Start(ctx context.Context, siteName string) error {
 	site, ok := m.cfg.Site[siteName]
 	if !ok {
 		return fmt.Errorf("site not found: %s", siteName)
@@ -99,17 +122,19 @@ func (m *Manager) Start(siteName string) error {
 	}
 
 	name := potName(siteName)
-	slog.Info("starting pot", "site", siteName, "pot", name)
-	cmd := exec.Command(m.potCmd(), "start", "-p", name)
-	output, err := cmd.CombinedOutput()
+	logger.FromContext(ctx).Info("starting pot", "site", siteName, "pot", name)
+	output, err := m.run(ctx, "start", "-p", name)
 	if err != nil {
 		return fmt.Errorf("pot start: %w: %s", err, string(output))
 	}
-	return nil
+	if err := m.MountVolumes(siteName); err != nil {
+		return err
+	}
+	return m.LockRoot(siteName)
 }
 
 // Stop stops a pot
-func (m *Manager) Stop(siteName string) error {
+func (m *Manager) Stop(ctx context.Context, siteName string) error {
 	site, ok := m.cfg.Site[siteName]
 	if !ok {
 		return fmt.Errorf("site not found: %s", siteName)
@@ -120,16 +145,16 @@ func (m *Manager) Stop(siteName string) error {
 	}
 
 	name := potName(siteName)
-	slog.Debug("stopping pot", "site", siteName, "pot", name)
-	cmd := exec.Command(m.potCmd(), "stop", "-p", name)
-	if err := cmd.Run(); err != nil {
-		slog.Debug("pot stop failed (may not be running)", "site", siteName, "error", err)
+	log := logger.FromContext(ctx)
+	log.Debug("stopping pot", "site", siteName, "pot", name)
+	if _, err := m.run(ctx, "stop", "-p", name); err != nil {
+		log.Debug("pot stop failed (may not be running)", "site", siteName, "error", err)
 	}
 	return nil
 }
 
 // Destroy removes a pot completely
-func (m *Manager) Destroy(siteName string) error {
+func (m *Manager) Destroy(ctx context.Context, siteName string) error {
 	site, ok := m.cfg.Site[siteName]
 	if !ok {
 		return fmt.Errorf("site not found: %s", siteName)
@@ -140,23 +165,25 @@ func (m *Manager) Destroy(siteName string) error {
 	}
 
 	name := potName(siteName)
-	slog.Info("destroying pot", "site", siteName, "pot", name)
+	logger.FromContext(ctx).Info("destroying pot", "site", siteName, "pot", name)
 
 	// Stop pot first
-	m.Stop(siteName)
+	m.Stop(ctx, siteName)
 
 	// Destroy pot
-	cmd := exec.Command(m.potCmd(), "destroy", "-p", name)
-	output, err := cmd.CombinedOutput()
+	output, err := m.run(ctx, "destroy", "-p", name)
 	if err != nil {
 		return fmt.Errorf("pot destroy: %w: %s", err, string(output))
 	}
 
+	// Data behind the writable paths of a read-only pot goes with it
+	os.RemoveAll(filepath.Join(m.cfg.StateDir(), "jails", name))
+
 	return nil
 }
 
 // CopyIn copies a file into the pot
-func (m *Manager) CopyIn(siteName string, srcPath string, destPath string) error {
+func (m *Manager) CopyIn(ctx context.Context, siteName string, srcPath string, destPath string) error {
 	site, ok := m.cfg.Site[siteName]
 	if !ok {
 		return fmt.Errorf("site not found: %s", siteName)
@@ -168,17 +195,103 @@ func (m *Manager) CopyIn(siteName string, srcPath string, destPath string) error
 
 	name := potName(siteName)
 	// Use -F flag to allow copying to a running pot
-	cmd := exec.Command(m.potCmd(), "copy-in", "-p", name, "-F", "-s", srcPath, "-d", destPath)
-	output, err := cmd.CombinedOutput()
+	output, err := m.run(ctx, "copy-in", "-p", name, "-F", "-s", srcPath, "-d", destPath)
 	if err != nil {
 		return fmt.Errorf("pot copy-in: %w: %s", err, string(output))
 	}
 
+	// Hand the file to the user the backend runs as
+	if user := site.Backend.RunAs; user != "" {
+		if err := m.Exec(ctx, siteName, "chown", user, destPath); err != nil {
+			return fmt.Errorf("chown %s: %w", destPath, err)
+		}
+	}
+
 	return nil
 }
 
+// EnsureUser creates the backend's run_as user inside the running pot if it
+// doesn't exist, and gives it the log files and the crash directory the
+// backend writes to. It does nothing for backends that run as root.
+func (m *Manager) EnsureUser(ctx context.Context, siteName string) error {
+	site, ok := m.cfg.Site[siteName]
+	if !ok {
+		return fmt.Errorf("site not found: %s", siteName)
+	}
+
+	if site.Backend == nil || site.Backend.RunAs == "" {
+		return nil
+	}
+	user := site.Backend.RunAs
+
+	if m.Exec(ctx, siteName, "id", user) != nil {
+		logger.FromContext(ctx).Info("creating jail user", "site", siteName, "user", user)
+		args := []string{"useradd", "-n", user,
+			"-c", "shipyard backend", "-d", "/nonexistent", "-s", "/usr/sbin/nologin"}
+		if uid := site.Backend.RunAsUID(); uid > 0 {
+			args = append(args, "-u", strconv.Itoa(uid))
+		}
+		if err := m.Exec(ctx, siteName, "pw", args...); err != nil {
+			return fmt.Errorf("create user %s: %w", user, err)
+		}
+	}
+
+	if err := m.Exec(ctx, siteName, "mkdir", "-p", "/var/log", "/var/crash"); err != nil {
+		return fmt.Errorf("create runtime directories: %w", err)
+	}
+	if err := m.Exec(ctx, siteName, "touch", "/var/log/app.log", "/var/log/app.exit"); err != nil {
+		return fmt.Errorf("create log files: %w", err)
+	}
+	if err := m.Exec(ctx, siteName, "chown", user, "/var/log/app.log", "/var/log/app.exit", "/var/crash"); err != nil {
+		return fmt.Errorf("chown runtime files to %s: %w", user, err)
+	}
+	return nil
+}
+
+// UserID returns the UID of the backend's run_as user inside the pot
+func (m *Manager) UserID(siteName string) (int, error) {
+	site, ok := m.cfg.Site[siteName]
+	if !ok {
+		return 0, fmt.Errorf("site not found: %s", siteName)
+	}
+
+	if site.Backend == nil || site.Backend.RunAs == "" {
+		return 0, fmt.Errorf("site %s has no run_as user", siteName)
+	}
+
+	root, err := m.RootPath(siteName)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(filepath.Join(root, "etc", "passwd"))
+	if err != nil {
+		return 0, fmt.Errorf("read jail passwd: %w", err)
+	}
+	defer f.Close()
+	return lookupUID(f, site.Backend.RunAs)
+}
+
+// lookupUID finds a user's UID in passwd(5) data
+func lookupUID(r io.Reader, user string) (int, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 3 && fields[0] == user {
+			uid, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return 0, fmt.Errorf("user %s: invalid uid %q", user, fields[2])
+			}
+			return uid, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("user %s not found in jail", user)
+}
+
 // Exec executes a command inside the pot
-func (m *Manager) Exec(siteName string, command string, args ...string) error {
+func (m *Manager) Exec(ctx context.Context, siteName string, command string, args ...string) error {
 	site, ok := m.cfg.Site[siteName]
 	if !ok {
 		return fmt.Errorf("site not found: %s", siteName)
@@ -194,8 +307,7 @@ func (m *Manager) Exec(siteName string, command string, args ...string) error {
 	execArgs := []string{"exec", "-p", name, command}
 	execArgs = append(execArgs, args...)
 
-	cmd := exec.Command(m.potCmd(), execArgs...)
-	output, err := cmd.CombinedOutput()
+	output, err := m.run(ctx, execArgs...)
 	if err != nil {
 		return fmt.Errorf("pot exec: %w: %s", err, string(output))
 	}
@@ -217,8 +329,7 @@ func (m *Manager) IsRunning(siteName string) bool {
 	name := potName(siteName)
 
 	// pot ps lists running pots
-	cmd := exec.Command(m.potCmd(), "ps", "-q")
-	output, err := cmd.Output()
+	output, err := commands(m.cfg).Output(context.Background(), m.potCmd(), "ps", "-q")
 	if err != nil {
 		return false
 	}
@@ -248,8 +359,7 @@ func (m *Manager) GetPotPath(siteName string) (string, error) {
 	name := potName(siteName)
 
 	// Get pot info to find the path
-	cmd := exec.Command(m.potCmd(), "info", "-p", name, "-E")
-	output, err := cmd.Output()
+	output, err := commands(m.cfg).Output(context.Background(), m.potCmd(), "info", "-p", name, "-E")
 	if err != nil {
 		return "", fmt.Errorf("pot info: %w", err)
 	}
@@ -265,3 +375,79 @@ func (m *Manager) GetPotPath(siteName string) (string, error) {
 	// Fallback: construct the path based on pot conventions
 	return fmt.Sprintf("/opt/pot/jails/%s", name), nil
 }
+
+// DiskUsage returns the bytes used by a pot's dataset
+func (m *Manager) DiskUsage(siteName string) (int64, error) {
+	potPath, err := m.GetPotPath(siteName)
+	if err != nil {
+		return 0, err
+	}
+
+	// zfs resolves the mountpoint to its dataset
+	output, err := commands(m.cfg).Output(context.Background(), "zfs", "list", "-Hp", "-o", "used", potPath)
+	if err != nil {
+		return 0, fmt.Errorf("zfs list: %w", err)
+	}
+
+	used, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse zfs used %q: %w", strings.TrimSpace(string(output)), err)
+	}
+	return used, nil
+}
+
+// RootPath returns the host path of the pot's root filesystem
+func (m *Manager) RootPath(siteName string) (string, error) {
+	potPath, err := m.GetPotPath(siteName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(potPath, "m"), nil
+}
+
+// LogPath returns the host path of the site's application log
+func (m *Manager) LogPath(siteName string) (string, error) {
+	root, err := m.RootPath(siteName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "var", "log", "app.log"), nil
+}
+
+// TailFile reads the last n lines from a file
+func TailFile(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var all []string
+	scanner := bufio.NewScanner(f)
+	// Increase buffer size for long log lines
+	scanner.Buffer(make([]byte, 0, 64*1024), 256*1024)
+	for scanner.Scan() {
+		all = append(all, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(all) > n {
+		all = all[len(all)-n:]
+	}
+	return all, nil
+}
+
+// ProcessCounts returns the number of processes in each running pot, keyed by site
+func (m *Manager) ProcessCounts() (map[string]int, error) {
+	procs, err := m.Processes()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(procs))
+	for siteName, list := range procs {
+		counts[siteName] = len(list)
+	}
+	return counts, nil
+}
@@ -0,0 +1,55 @@
+package jail
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/runner"
+)
+
+func TestLookupUID(t *testing.T) {
+	passwd := "# comment\nroot:*:0:0:Charlie &:/root:/bin/sh\napp:*:30005:30005:shipyard backend:/nonexistent:/usr/sbin/nologin\n"
+	if uid, err := lookupUID(strings.NewReader(passwd), "app"); err != nil || uid != 30005 {
+		t.Errorf("lookupUID(app) = %d, %v", uid, err)
+	}
+	if _, err := lookupUID(strings.NewReader(passwd), "www"); err == nil {
+		t.Error("lookupUID found a missing user")
+	}
+}
+
+func TestExec_RunsPotWithCommandTimeout(t *testing.T) {
+	cfg := &config.Config{
+		Jail: config.JailConfig{BinaryPath: "/usr/local/bin/pot", CommandTimeout: 5},
+		Site: map[string]config.SiteConfig{"api.example.com": {Backend: &config.BackendConfig{}}},
+	}
+	var got []string
+	var deadline time.Time
+	defer runner.SetHook(func(ctx context.Context, name string, args []string) ([]byte, error) {
+		got = append([]string{name}, args...)
+		deadline, _ = ctx.Deadline()
+		return nil, nil
+	})()
+
+	if err := NewManager(cfg).Exec(context.Background(), "api.example.com", "id", "app"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/usr/local/bin/pot exec -p api-example-com id app"; strings.Join(got, " ") != want {
+		t.Errorf("ran %q, want %q", strings.Join(got, " "), want)
+	}
+	if deadline.IsZero() || time.Until(deadline) > 5*time.Second {
+		t.Errorf("deadline = %v, want within jail.command_timeout", deadline)
+	}
+
+	// A longer deadline of the caller's own, such as a migration's, stands
+	long, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if err := NewManager(cfg).Exec(long, "api.example.com", "id", "app"); err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(deadline) < 30*time.Minute {
+		t.Errorf("deadline = %v, want the caller's hour", deadline)
+	}
+}
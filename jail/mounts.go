@@ -0,0 +1,122 @@
+package jail
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MountVolumes mounts the backend's volumes that aren't mounted yet, creating
+// their host directories. pot remounts them on every start after.
+func (m *Manager) MountVolumes(siteName string) error {
+	site, ok := m.cfg.Site[siteName]
+	if !ok {
+		return fmt.Errorf("site not found: %s", siteName)
+	}
+
+	if site.Backend == nil || len(site.Backend.Volumes) == 0 {
+		return nil
+	}
+
+	mounted, err := m.mountedDirs(siteName)
+	if err != nil {
+		return err
+	}
+	for _, v := range site.Backend.Volumes {
+		hostDir := filepath.Clean(v.Host)
+		if mounted[hostDir] {
+			continue
+		}
+		if err := os.MkdirAll(hostDir, 0755); err != nil {
+			return fmt.Errorf("create volume %s: %w", hostDir, err)
+		}
+		if err := m.mountIn(siteName, hostDir, v.Path, v.ReadOnly); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeVolumes deletes the data in the backend's volumes
+func (m *Manager) PurgeVolumes(siteName string) error {
+	site, ok := m.cfg.Site[siteName]
+	if !ok {
+		return fmt.Errorf("site not found: %s", siteName)
+	}
+
+	if site.Backend == nil {
+		return nil
+	}
+
+	for _, v := range site.Backend.Volumes {
+		slog.Info("purging volume", "site", siteName, "dir", v.Host)
+		if err := os.RemoveAll(filepath.Clean(v.Host)); err != nil {
+			return fmt.Errorf("purge volume %s: %w", v.Host, err)
+		}
+	}
+	return nil
+}
+
+// mountIn adds a host directory to the pot's mounts, mounted at jailPath now
+// if the pot is running and on every start after. A missing mountpoint is
+// created, so a read-only root is unlocked first; the caller locks it again.
+func (m *Manager) mountIn(siteName, hostDir, jailPath string, readOnly bool) error {
+	root, err := m.RootPath(siteName)
+	if err != nil {
+		return err
+	}
+	mountpoint := filepath.Join(root, jailPath)
+	if _, err := os.Stat(mountpoint); os.IsNotExist(err) {
+		if err := m.UnlockRoot(siteName); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(mountpoint, 0755); err != nil {
+			return fmt.Errorf("create mountpoint %s: %w", jailPath, err)
+		}
+	}
+
+	slog.Info("mounting into pot", "site", siteName, "dir", hostDir, "mountpoint", jailPath, "read_only", readOnly)
+	args := []string{"mount-in", "-p", potName(siteName), "-d", hostDir, "-m", jailPath}
+	if readOnly {
+		args = append(args, "-r")
+	}
+	if output, err := m.run(context.Background(), args...); err != nil {
+		return fmt.Errorf("pot mount-in %s: %w: %s", jailPath, err, string(output))
+	}
+	return nil
+}
+
+// mountedDirs returns the host directories already in the pot's mounts, from
+// its fscomp.conf ("<host dir> <mountpoint> [ro]" per line)
+func (m *Manager) mountedDirs(siteName string) (map[string]bool, error) {
+	potPath, err := m.GetPotPath(siteName)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(potPath, "conf", "fscomp.conf"))
+	if os.IsNotExist(err) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read pot mounts: %w", err)
+	}
+	defer f.Close()
+	return parseFscomp(f), nil
+}
+
+// parseFscomp returns the host directories named in a pot's fscomp.conf
+func parseFscomp(r io.Reader) map[string]bool {
+	dirs := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 2 {
+			dirs[fields[0]] = true
+		}
+	}
+	return dirs
+}
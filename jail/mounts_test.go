@@ -0,0 +1,15 @@
+package jail
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFscomp(t *testing.T) {
+	conf := "/var/db/shipyard/jails/api/var/log /opt/pot/jails/api/m/var/log\n" +
+		"/data/uploads /opt/pot/jails/api/m/srv/uploads ro\n\n"
+	dirs := parseFscomp(strings.NewReader(conf))
+	if len(dirs) != 2 || !dirs["/var/db/shipyard/jails/api/var/log"] || !dirs["/data/uploads"] {
+		t.Errorf("parseFscomp = %v", dirs)
+	}
+}
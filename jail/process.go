@@ -0,0 +1,107 @@
+package jail
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lachierussell/shipyard/runner"
+)
+
+// Process is a process running inside a pot
+type Process struct {
+	PID     int
+	PPID    int
+	CPU     float64 // percent of one core, as reported by ps
+	RSS     uint64  // resident memory in bytes
+	Command string
+}
+
+// Processes returns the processes in each running pot, keyed by site
+func (m *Manager) Processes() (map[string][]Process, error) {
+	// jls prints "<jid> <name>" for each running jail
+	out, err := runner.Output(context.Background(), "jls", "jid", "name")
+	if err != nil {
+		return nil, fmt.Errorf("jls: %w", err)
+	}
+	jids := make(map[string]string) // jid -> site
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		for siteName, site := range m.cfg.Site {
+			if site.Backend != nil && potName(siteName) == fields[1] {
+				jids[fields[0]] = siteName
+			}
+		}
+	}
+
+	procs := make(map[string][]Process)
+	if len(jids) == 0 {
+		return procs, nil
+	}
+
+	out, err = runner.Output(context.Background(), "ps", "-ax", "-o", "jid=,pid=,ppid=,pcpu=,rss=,comm=")
+	if err != nil {
+		return nil, fmt.Errorf("ps: %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		jid, proc, ok := parsePSLine(line)
+		if !ok {
+			continue
+		}
+		if siteName, ok := jids[jid]; ok {
+			procs[siteName] = append(procs[siteName], proc)
+		}
+	}
+	return procs, nil
+}
+
+// parsePSLine parses a "jid pid ppid pcpu rss(KiB) comm" line from ps
+func parsePSLine(line string) (string, Process, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 6 {
+		return "", Process{}, false
+	}
+	pid, err1 := strconv.Atoi(fields[1])
+	ppid, err2 := strconv.Atoi(fields[2])
+	cpu, err3 := strconv.ParseFloat(fields[3], 64)
+	rss, err4 := strconv.ParseUint(fields[4], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return "", Process{}, false
+	}
+	return fields[0], Process{
+		PID:     pid,
+		PPID:    ppid,
+		CPU:     cpu,
+		RSS:     rss << 10,
+		Command: strings.Join(fields[5:], " "),
+	}, true
+}
+
+// OpenFiles returns the number of open file descriptors of a process
+func OpenFiles(pid int) (int, error) {
+	out, err := runner.Output(context.Background(), "procstat", "-f", strconv.Itoa(pid))
+	if err != nil {
+		return 0, fmt.Errorf("procstat: %w", err)
+	}
+	return countFDs(string(out)), nil
+}
+
+// countFDs counts the numbered descriptors in procstat -f output, skipping the
+// header and the text, cwd, root and jail entries
+func countFDs(out string) int {
+	n := 0
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[2]); err == nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,31 @@
+package jail
+
+import "testing"
+
+func TestParsePSLine(t *testing.T) {
+	jid, proc, ok := parsePSLine("  3  4521  4520  12.5  20480 myapp-api")
+	if !ok || jid != "3" {
+		t.Fatalf("parsePSLine() = %q, %+v, %v", jid, proc, ok)
+	}
+	if proc.PID != 4521 || proc.PPID != 4520 || proc.CPU != 12.5 || proc.RSS != 20480<<10 || proc.Command != "myapp-api" {
+		t.Errorf("parsePSLine() = %+v", proc)
+	}
+
+	if _, _, ok := parsePSLine("JID PID PPID %CPU RSS COMMAND"); ok {
+		t.Error("parsePSLine() accepted a header line")
+	}
+}
+
+func TestCountFDs(t *testing.T) {
+	out := `  PID COMM                FD T V FLAGS    REF  OFFSET PRO NAME
+ 4521 myapp-api          text v r r-------   -       - -   /usr/local/bin/myapp-api
+ 4521 myapp-api           cwd v d r-------   -       - -   /
+ 4521 myapp-api          root v d r-------   -       - -   /
+ 4521 myapp-api             0 v c rw------   3       0 -   /dev/null
+ 4521 myapp-api             1 v r -w------   2     120 -   /var/log/app.log
+ 4521 myapp-api             3 s - rw---n--   1       0 TCP 10.0.0.2:8080 0.0.0.0:0
+`
+	if got := countFDs(out); got != 3 {
+		t.Errorf("countFDs() = %d, want 3", got)
+	}
+}
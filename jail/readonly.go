@@ -0,0 +1,144 @@
+package jail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lachierussell/shipyard/runner"
+)
+
+// writableDir returns the host directory mounted over a writable path of a
+// read-only pot
+func (m *Manager) writableDir(siteName, jailPath string) string {
+	return filepath.Join(m.cfg.StateDir(), "jails", potName(siteName), strings.TrimPrefix(filepath.Clean(jailPath), "/"))
+}
+
+// LockRoot makes the pot's root filesystem read-only if the backend has
+// read_only set, with its writable paths mounted from the host, and writable
+// otherwise. Start calls it; call it again after UnlockRoot.
+func (m *Manager) LockRoot(siteName string) error {
+	site, ok := m.cfg.Site[siteName]
+	if !ok {
+		return fmt.Errorf("site not found: %s", siteName)
+	}
+
+	if site.Backend == nil {
+		return fmt.Errorf("site %s has no backend config", siteName)
+	}
+
+	if !site.Backend.ReadOnly {
+		// Undo an earlier read_only; pots that never had it need nothing
+		if err := m.setReadOnly(siteName, false); err != nil {
+			slog.Debug("check jail root read-only", "site", siteName, "error", err)
+		}
+		return nil
+	}
+
+	root, err := m.RootPath(siteName)
+	if err != nil {
+		return err
+	}
+	mounted, err := m.mountedDirs(siteName)
+	if err != nil {
+		return err
+	}
+	for _, jailPath := range site.Backend.WritablePaths() {
+		hostDir := m.writableDir(siteName, jailPath)
+		if mounted[hostDir] {
+			continue
+		}
+		if err := seedDir(commands(m.cfg), filepath.Join(root, jailPath), hostDir); err != nil {
+			return fmt.Errorf("prepare writable %s: %w", jailPath, err)
+		}
+		if err := m.mountIn(siteName, hostDir, jailPath, false); err != nil {
+			return err
+		}
+	}
+	return m.setReadOnly(siteName, true)
+}
+
+// UnlockRoot makes a read_only pot's root filesystem writable so a deploy can
+// change it
+func (m *Manager) UnlockRoot(siteName string) error {
+	site, ok := m.cfg.Site[siteName]
+	if !ok {
+		return fmt.Errorf("site not found: %s", siteName)
+	}
+
+	if site.Backend == nil || !site.Backend.ReadOnly {
+		return nil // LockRoot left it writable
+	}
+	return m.setReadOnly(siteName, false)
+}
+
+// Writable runs fn with the pot's root filesystem writable, then locks it again
+func (m *Manager) Writable(siteName string, fn func() error) error {
+	if err := m.UnlockRoot(siteName); err != nil {
+		return err
+	}
+	fnErr := fn()
+	if err := m.LockRoot(siteName); err != nil && fnErr == nil {
+		return err
+	}
+	return fnErr
+}
+
+// setReadOnly sets the readonly property of the pot's root dataset
+func (m *Manager) setReadOnly(siteName string, on bool) error {
+	root, err := m.RootPath(siteName)
+	if err != nil {
+		return err
+	}
+	// zfs resolves the mountpoint to its dataset
+	out, err := commands(m.cfg).Output(context.Background(), "zfs", "list", "-H", "-o", "name,readonly", root)
+	if err != nil {
+		return fmt.Errorf("zfs list %s: %w", root, err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return fmt.Errorf("zfs list %s: unexpected output %q", root, strings.TrimSpace(string(out)))
+	}
+	dataset, current := fields[0], fields[1]
+
+	want := "off"
+	if on {
+		want = "on"
+	}
+	if current == want {
+		return nil
+	}
+	slog.Info("setting jail root read-only", "site", siteName, "readonly", want)
+	if output, err := commands(m.cfg).Run(context.Background(), "zfs", "set", "readonly="+want, dataset); err != nil {
+		return fmt.Errorf("zfs set readonly=%s %s: %w: %s", want, dataset, err, string(output))
+	}
+	return nil
+}
+
+// seedDir creates hostDir, copying in what the jail had at src the first time
+// so mounting over it hides nothing the backend expects
+func seedDir(r *runner.Runner, src, hostDir string) error {
+	if _, err := os.Stat(hostDir); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(hostDir, 0755); err != nil {
+		return err
+	}
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(hostDir, info.Mode().Perm()|info.Mode()&os.ModeSticky); err != nil {
+		return err
+	}
+	if output, err := r.Run(context.Background(), "cp", "-Rp", src+"/.", hostDir); err != nil {
+		return fmt.Errorf("cp -Rp %s: %w: %s", src, err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package jail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestWritableDir(t *testing.T) {
+	m := NewManager(&config.Config{Self: config.SelfConfig{StateDir: "/var/db/shipyard"}})
+	if got := m.writableDir("api.example.com", "/var/db/app/"); got != "/var/db/shipyard/jails/api-example-com/var/db/app" {
+		t.Errorf("writableDir = %q", got)
+	}
+}
+
+func TestSeedDir(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "messages"), []byte("boot\n"), 0640)
+	os.Chmod(src, 0750)
+
+	hostDir := filepath.Join(t.TempDir(), "var/log")
+	if err := seedDir(nil, src, hostDir); err != nil {
+		t.Fatalf("seedDir: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(hostDir, "messages")); err != nil || string(data) != "boot\n" {
+		t.Errorf("seeded file = %q, %v", data, err)
+	}
+	if info, _ := os.Stat(hostDir); info.Mode().Perm() != 0750 {
+		t.Errorf("seeded dir mode = %v, want 0750", info.Mode().Perm())
+	}
+
+	// Seeding happens once; later contents are the backend's
+	os.WriteFile(filepath.Join(src, "new"), []byte("x"), 0644)
+	if err := seedDir(nil, src, hostDir); err != nil {
+		t.Fatalf("seedDir again: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(hostDir, "new")); !os.IsNotExist(err) {
+		t.Error("existing writable dir was reseeded")
+	}
+
+	// A path the jail doesn't have yet starts empty
+	if err := seedDir(nil, filepath.Join(src, "missing"), filepath.Join(t.TempDir(), "data")); err != nil {
+		t.Errorf("seedDir of a missing path: %v", err)
+	}
+}
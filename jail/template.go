@@ -0,0 +1,138 @@
+package jail
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/logger"
+)
+
+// TemplatePot returns the pot a template's current definition is built into.
+// The name carries a hash of the definition, so editing a template builds a
+// new pot and jails already cloned from the old one keep it as their origin.
+func (m *Manager) TemplatePot(name string) (string, error) {
+	t, ok := m.cfg.Jail.Templates[name]
+	if !ok {
+		return "", fmt.Errorf("jail template not found: %s", name)
+	}
+	return templatePotName(name, t, m.cfg.Jail.FreeBSDVersion), nil
+}
+
+// templatePotName names the pot for one version of a template
+func templatePotName(name string, t config.TemplateConfig, version string) string {
+	// encoding/json sorts map keys, so equal definitions hash alike
+	data, _ := json.Marshal(struct {
+		Version  string
+		Template config.TemplateConfig
+	}{version, t})
+	sum := sha256.Sum256(data)
+	return "tpl-" + name + "-" + hex.EncodeToString(sum[:4])
+}
+
+// TemplateBuilt reports whether the template's current definition has been built
+func (m *Manager) TemplateBuilt(name string) (bool, error) {
+	pot, err := m.TemplatePot(name)
+	if err != nil {
+		return false, err
+	}
+	return m.potExists(context.Background(), pot), nil
+}
+
+// BuildTemplate builds the template's pot unless its current definition has
+// been built already: a fresh base with the pot attributes set, the packages
+// installed and the users created, then snapshotted for cloning. It returns
+// the pot and whether it was built now.
+func (m *Manager) BuildTemplate(ctx context.Context, name string) (string, bool, error) {
+	pot, err := m.TemplatePot(name)
+	if err != nil {
+		return "", false, err
+	}
+	if m.potExists(ctx, pot) {
+		return pot, false, nil
+	}
+	t := m.cfg.Jail.Templates[name]
+
+	log := logger.FromContext(ctx)
+	log.Info("building jail template", "template", name, "pot", pot)
+	if err := m.buildTemplate(ctx, pot, t); err != nil {
+		// A half-built template must not be cloned; the next build starts
+		// over. Clean up even when ctx ended the build.
+		cleanup := context.WithoutCancel(ctx)
+		m.pot(cleanup, "stop", "-p", pot)
+		m.pot(cleanup, "destroy", "-p", pot)
+		return "", false, fmt.Errorf("build template %s: %w", name, err)
+	}
+	log.Info("jail template built", "template", name, "pot", pot)
+	return pot, true, nil
+}
+
+// buildTemplate creates, provisions and snapshots a template pot
+func (m *Manager) buildTemplate(ctx context.Context, pot string, t config.TemplateConfig) error {
+	if err := m.pot(ctx, "create", "-p", pot, "-t", "single", "-b", m.cfg.Jail.FreeBSDVersion, "-N", "inherit"); err != nil {
+		return err
+	}
+	if err := m.setAttributes(ctx, pot, t.Attributes); err != nil {
+		return err
+	}
+	if err := m.pot(ctx, "start", "-p", pot); err != nil {
+		return err
+	}
+	if len(t.Packages) > 0 {
+		args := append([]string{"exec", "-p", pot, "env", "ASSUME_ALWAYS_YES=yes", "pkg", "install", "-y"}, t.Packages...)
+		if err := m.pot(ctx, args...); err != nil {
+			return err
+		}
+	}
+	for _, user := range t.Users {
+		if err := m.pot(ctx, "exec", "-p", pot, "pw", "useradd", "-n", user,
+			"-d", "/nonexistent", "-s", "/usr/sbin/nologin"); err != nil {
+			return err
+		}
+	}
+	if err := m.pot(ctx, "stop", "-p", pot); err != nil {
+		return err
+	}
+	return m.pot(ctx, "snapshot", "-p", pot)
+}
+
+// clonePot creates a site's pot from a template, building the template first if needed
+func (m *Manager) clonePot(ctx context.Context, siteName, template string) error {
+	tpl, _, err := m.BuildTemplate(ctx, template)
+	if err != nil {
+		return err
+	}
+	name := potName(siteName)
+	logger.FromContext(ctx).Info("cloning pot from template", "site", siteName, "template", template, "from", tpl)
+	if err := m.pot(ctx, "clone", "-P", tpl, "-p", name, "-N", "inherit"); err != nil {
+		return err
+	}
+	return m.setAttributes(ctx, name, m.cfg.Jail.Templates[template].Attributes)
+}
+
+// setAttributes applies pot attributes in a stable order
+func (m *Manager) setAttributes(ctx context.Context, pot string, attrs map[string]string) error {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := m.pot(ctx, "set-attribute", "-p", pot, "-A", k, "-V", attrs[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pot runs a pot subcommand, returning its output with any error
+func (m *Manager) pot(ctx context.Context, args ...string) error {
+	if output, err := m.run(ctx, args...); err != nil {
+		return fmt.Errorf("pot %s: %w: %s", args[0], err, string(output))
+	}
+	return nil
+}
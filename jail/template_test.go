@@ -0,0 +1,36 @@
+package jail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestTemplatePotName(t *testing.T) {
+	tpl := config.TemplateConfig{
+		Packages:   []string{"ca_root_nss"},
+		Attributes: map[string]string{"sysvipc": "new", "mlock": "on"},
+	}
+	name := templatePotName("go-runtime", tpl, "14.3-RELEASE")
+	if !strings.HasPrefix(name, "tpl-go-runtime-") || len(name) != len("tpl-go-runtime-")+8 {
+		t.Errorf("templatePotName = %q", name)
+	}
+
+	same := config.TemplateConfig{
+		Packages:   []string{"ca_root_nss"},
+		Attributes: map[string]string{"mlock": "on", "sysvipc": "new"},
+	}
+	if got := templatePotName("go-runtime", same, "14.3-RELEASE"); got != name {
+		t.Errorf("equal definitions named %q and %q", name, got)
+	}
+
+	changed := tpl
+	changed.Packages = []string{"ca_root_nss", "curl"}
+	if templatePotName("go-runtime", changed, "14.3-RELEASE") == name {
+		t.Error("changing the packages kept the pot name")
+	}
+	if templatePotName("go-runtime", tpl, "15.0-RELEASE") == name {
+		t.Error("changing the FreeBSD version kept the pot name")
+	}
+}
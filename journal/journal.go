@@ -0,0 +1,236 @@
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Deploy kinds
+const (
+	KindFrontend = "frontend"
+	KindBackend  = "backend"
+	// KindAbandoned records an operation shutdown stopped waiting for
+	KindAbandoned = "abandoned"
+)
+
+// Steps are recorded before the destructive action they name is started
+const (
+	StepExtract      = "extract"       // writing the commit directory
+	StepSymlink      = "symlink"       // flipping the latest symlink
+	StepNginx        = "nginx"         // writing site config and reloading nginx
+	StepServiceStop  = "service_stop"  // stopping the backend
+	StepBinaryCopy   = "binary_copy"   // replacing the backend binary in the jail
+	StepServiceStart = "service_start" // starting the new backend
+)
+
+// Entry records an in-progress deploy and what is needed to undo it
+type Entry struct {
+	ID      string    `json:"id"`
+	Kind    string    `json:"kind"`
+	Site    string    `json:"site"`
+	Commit  string    `json:"commit"`
+	Started time.Time `json:"started"`
+	Step    string    `json:"step"`
+	Updated time.Time `json:"updated"`
+
+	// Subdomain is set for deploys to one subdomain of a wildcard site
+	Subdomain string `json:"subdomain,omitempty"`
+	// Operation is the kind of request an abandoned entry was, e.g. site_init
+	Operation string `json:"operation,omitempty"`
+
+	// Undo data
+	CommitDirCreated bool              `json:"commit_dir_created,omitempty"`
+	PrevBinary       bool              `json:"prev_binary,omitempty"` // jail binary backed up to <path>.prev
+	Backups          map[string]string `json:"backups,omitempty"`     // original path -> backup path ("" = did not exist)
+}
+
+// Journal persists entries as one JSON file each so that a crash mid-deploy
+// leaves a record of what was in progress
+type Journal struct {
+	dir string
+}
+
+// New creates a Journal stored in dir
+func New(dir string) *Journal {
+	return &Journal{dir: dir}
+}
+
+// Begin starts a new entry and writes it to disk
+func (j *Journal) Begin(kind, site, commit string) (*Entry, error) {
+	now := time.Now().UTC()
+	e := &Entry{
+		ID:      uuid.NewString(),
+		Kind:    kind,
+		Site:    site,
+		Commit:  commit,
+		Started: now,
+		Updated: now,
+	}
+	if err := j.write(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Step records that step is about to start
+func (j *Journal) Step(e *Entry, step string) error {
+	e.Step = step
+	e.Updated = time.Now().UTC()
+	return j.write(e)
+}
+
+// Save rewrites the entry after its undo data changed
+func (j *Journal) Save(e *Entry) error {
+	e.Updated = time.Now().UTC()
+	return j.write(e)
+}
+
+// Backup copies path into the journal so Restore can put it back.
+// A missing file is recorded so Restore removes whatever replaced it.
+func (j *Journal) Backup(e *Entry, path string) error {
+	if e.Backups == nil {
+		e.Backups = make(map[string]string)
+	}
+	if _, done := e.Backups[path]; done {
+		return nil
+	}
+
+	backupPath := ""
+	if _, err := os.Stat(path); err == nil {
+		backupPath = filepath.Join(j.dir, fmt.Sprintf("%s.%d.bak", e.ID, len(e.Backups)))
+		if err := copyFile(path, backupPath); err != nil {
+			return fmt.Errorf("backup %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	e.Backups[path] = backupPath
+	return j.Save(e)
+}
+
+// Restore puts back every file saved with Backup
+func (j *Journal) Restore(e *Entry) error {
+	for path, backupPath := range e.Backups {
+		if backupPath == "" {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("remove %s: %w", path, err)
+			}
+			continue
+		}
+		if err := copyFile(backupPath, path); err != nil {
+			return fmt.Errorf("restore %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Complete removes a finished entry and its backups
+func (j *Journal) Complete(e *Entry) error {
+	for _, backupPath := range e.Backups {
+		if backupPath != "" {
+			os.Remove(backupPath)
+		}
+	}
+	if err := os.Remove(j.entryPath(e.ID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove journal entry: %w", err)
+	}
+	return nil
+}
+
+// Pending returns entries left behind by deploys that never completed
+func (j *Journal) Pending() ([]*Entry, error) {
+	matches, err := filepath.Glob(filepath.Join(j.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
+
+func (j *Journal) entryPath(id string) string {
+	return filepath.Join(j.dir, id+".json")
+}
+
+// write stores the entry atomically (temp file, fsync, rename)
+func (j *Journal) write(e *Entry) error {
+	if err := os.MkdirAll(j.dir, 0700); err != nil {
+		return fmt.Errorf("create journal directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode journal entry: %w", err)
+	}
+
+	path := j.entryPath(e.ID)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("write journal entry: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("write journal entry: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync journal entry: %w", err)
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("commit journal entry: %w", err)
+	}
+	return nil
+}
+
+// copyFile copies src to dst, syncing dst to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := dst + ".shipyard-tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	out.Close()
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,118 @@
+package jsonl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MaxSize is how large a log grows before it is rotated
+const MaxSize = 10 << 20
+
+// maxLine is the longest line read back; longer ones fail the read
+const maxLine = 4 << 20
+
+// Log is an append-only JSON lines file. Once an append would take it past
+// its size limit it is moved to <path>.1, replacing the previous one, so at
+// most about twice the limit is kept.
+type Log struct {
+	path    string
+	maxSize int64
+	mu      sync.Mutex
+}
+
+// New creates a Log stored at path, rotated at maxSize bytes (MaxSize if 0)
+func New(path string, maxSize int64) *Log {
+	if maxSize <= 0 {
+		maxSize = MaxSize
+	}
+	return &Log{path: path, maxSize: maxSize}
+}
+
+// rotatedPath is where the previous part of the log is kept
+func (l *Log) rotatedPath() string {
+	return l.path + ".1"
+}
+
+// Append writes v as one line
+func (l *Log) Append(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s entry: %w", filepath.Base(l.path), err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("create %s directory: %w", filepath.Base(l.path), err)
+	}
+	if info, err := os.Stat(l.path); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > l.maxSize {
+		if err := os.Rename(l.path, l.rotatedPath()); err != nil {
+			return fmt.Errorf("rotate %s: %w", l.path, err)
+		}
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", l.path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("write %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// Read returns the entries that keep accepts (nil keeps all), newest first,
+// including the rotated part. A missing log has no entries.
+func Read[T any](l *Log, keep func(T) bool) ([]T, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []T{}
+	for _, path := range []string{l.rotatedPath(), l.path} {
+		var err error
+		if entries, err = readFile(path, entries, keep); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// readFile appends the kept entries in path to entries, oldest first
+func readFile[T any](path string, entries []T, keep func(T) bool) ([]T, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLine)
+	for scanner.Scan() {
+		var v T
+		// Skip a torn final line from a crash mid-write
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			continue
+		}
+		if keep == nil || keep(v) {
+			entries = append(entries, v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return entries, nil
+}
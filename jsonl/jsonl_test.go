@@ -0,0 +1,58 @@
+package jsonl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type entry struct {
+	N    int    `json:"n"`
+	Site string `json:"site"`
+}
+
+func TestLog_AppendAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "log.jsonl")
+	l := New(path, 0)
+
+	if got, err := Read[entry](l, nil); err != nil || len(got) != 0 {
+		t.Fatalf("missing log = %v, %v; want empty", got, err)
+	}
+	l.Append(entry{N: 1, Site: "a.example.com"})
+	l.Append(entry{N: 2, Site: "b.example.com"})
+	// A torn line from a crash mid-write is skipped
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"n":3,"si`)
+	f.Close()
+
+	got, err := Read[entry](l, nil)
+	if err != nil || len(got) != 2 || got[0].N != 2 || got[1].N != 1 {
+		t.Errorf("Read = %v, %v; want 2 then 1", got, err)
+	}
+	got, _ = Read(l, func(e entry) bool { return e.Site == "a.example.com" })
+	if len(got) != 1 || got[0].N != 1 {
+		t.Errorf("filtered Read = %v, want entry 1", got)
+	}
+}
+
+func TestLog_Rotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.jsonl")
+	// Each entry is 29 bytes, so two fit
+	l := New(path, 60)
+	for n := 1; n <= 5; n++ {
+		if err := l.Append(entry{N: n, Site: "example.com"}); err != nil {
+			t.Fatalf("Append %d: %v", n, err)
+		}
+	}
+
+	for _, p := range []string{path, path + ".1"} {
+		if info, err := os.Stat(p); err != nil || info.Size() > 60 {
+			t.Errorf("%s: %v, size over the limit", p, err)
+		}
+	}
+	// The oldest part is dropped; the rotated one is still read
+	got, err := Read[entry](l, nil)
+	if err != nil || len(got) != 3 || got[0].N != 5 || got[2].N != 3 {
+		t.Errorf("Read = %v, %v; want 5 down to 3", got, err)
+	}
+}
@@ -11,6 +11,16 @@ type Broadcaster interface {
 	Broadcast(msg []byte)
 }
 
+// Broadcasters is a Broadcaster that passes each record to all of them.
+type Broadcasters []Broadcaster
+
+// Broadcast passes msg to each Broadcaster in turn.
+func (bs Broadcasters) Broadcast(msg []byte) {
+	for _, b := range bs {
+		b.Broadcast(msg)
+	}
+}
+
 // TeeHandler is an slog.Handler that writes to a primary handler and
 // broadcasts a JSON copy of each record to a Broadcaster (e.g. WebSocket hub).
 type TeeHandler struct {
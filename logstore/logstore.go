@@ -0,0 +1,529 @@
+// Package logstore keeps a searchable copy of shipyard's log and of its
+// backends' app.log. Lines are stored as JSON in one file per day, each with
+// a small index of the times, sites and levels it holds, so searches skip
+// the days that can't match.
+package logstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of stored lines
+const (
+	SourceShipyard = "shipyard"
+	SourceApp      = "app"
+)
+
+// Search limits
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// queueSize is how many of shipyard's lines wait for the writer before
+// more are dropped
+const queueSize = 1024
+
+// flushInterval is how often indexes are saved, old days removed and
+// backends' logs read
+const flushInterval = 5 * time.Second
+
+// maxLineSize bounds a stored line; longer app.log lines are cut
+const maxLineSize = 64 << 10
+
+// dateFormat names a day's files, in UTC
+const dateFormat = "2006-01-02"
+
+// levelRank orders levels for minimum-level searches. Unknown levels rank
+// as info.
+var levelRank = map[string]int{"DEBUG": 0, "INFO": 1, "WARN": 2, "ERROR": 3}
+
+// Dir returns where logs are kept within a state directory
+func Dir(stateDir string) string {
+	return filepath.Join(stateDir, "logs")
+}
+
+// dayIndex summarises the lines of one day's file
+type dayIndex struct {
+	First  time.Time      `json:"first"`
+	Last   time.Time      `json:"last"`
+	Lines  int            `json:"lines"`
+	Sites  map[string]int `json:"sites"`
+	Levels map[string]int `json:"levels"`
+	dirty  bool
+}
+
+// Query picks stored lines. Zero fields match everything.
+type Query struct {
+	Text   string // case-insensitive, anywhere in the line
+	Site   string
+	Level  string // the minimum level: DEBUG, INFO, WARN or ERROR
+	Source string // SourceShipyard or SourceApp
+	// DeployID keeps the lines logged during one deploy, which carry it as
+	// deploy_id
+	DeployID string
+	From     time.Time
+	To       time.Time
+	Limit    int // DefaultLimit when 0, at most MaxLimit
+	// Allow, when set, hides lines of the sites it returns false for
+	Allow func(site string) bool
+}
+
+// Store writes log lines to disk and searches them. It implements
+// logger.Broadcaster.
+type Store struct {
+	dir       string
+	retention int // days
+	lines     chan []byte
+
+	mu       sync.Mutex
+	days     map[string]*dayIndex
+	file     *os.File // the day file lines were last written to
+	fileDate string
+	appLogs  func() map[string]string // site -> app.log path
+	offsets  map[string]int64         // app.log path -> bytes read
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// New loads the indexes of the logs kept in dir, retention days of which
+// are kept; call Run to begin writing
+func New(dir string, retention int) *Store {
+	s := &Store{
+		dir:       dir,
+		retention: retention,
+		lines:     make(chan []byte, queueSize),
+		days:      make(map[string]*dayIndex),
+		offsets:   make(map[string]int64),
+		done:      make(chan struct{}),
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.idx.json"))
+	for _, path := range paths {
+		var idx dayIndex
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &idx)
+		}
+		if err != nil {
+			slog.Warn("ignoring unreadable log index", "path", path, "error", err)
+			continue
+		}
+		if idx.Sites == nil {
+			idx.Sites = map[string]int{}
+		}
+		if idx.Levels == nil {
+			idx.Levels = map[string]int{}
+		}
+		s.days[strings.TrimSuffix(filepath.Base(path), ".idx.json")] = &idx
+	}
+	if data, err := os.ReadFile(s.offsetsPath()); err == nil {
+		if err := json.Unmarshal(data, &s.offsets); err != nil {
+			slog.Warn("ignoring unreadable app log offsets", "path", s.offsetsPath(), "error", err)
+		}
+	}
+	return s
+}
+
+// Broadcast queues one of shipyard's JSON log lines for writing (implements
+// logger.Broadcaster). Lines are dropped while the queue is full.
+func (s *Store) Broadcast(msg []byte) {
+	select {
+	case s.lines <- msg:
+	default:
+	}
+}
+
+// FollowAppLogs has Run copy the lines backends add to their app.log. paths
+// returns each backend's log by site; a log is read from its end when first
+// seen.
+func (s *Store) FollowAppLogs(paths func() map[string]string) {
+	s.mu.Lock()
+	s.appLogs = paths
+	s.mu.Unlock()
+}
+
+// Run writes queued lines until Stop. Every flushInterval it also reads the
+// backends' logs, saves changed indexes and removes days past retention.
+// Call in a goroutine.
+func (s *Store) Run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-s.lines:
+			s.addShipyard(msg, time.Now())
+		case <-ticker.C:
+			s.readAppLogs(time.Now())
+			if err := s.Flush(time.Now()); err != nil {
+				slog.Warn("save log indexes", "error", err)
+			}
+		case <-s.done:
+			for len(s.lines) > 0 {
+				s.addShipyard(<-s.lines, time.Now())
+			}
+			if err := s.Flush(time.Now()); err != nil {
+				slog.Warn("save log indexes", "error", err)
+			}
+			s.mu.Lock()
+			if s.file != nil {
+				s.file.Close()
+				s.file = nil
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Stop ends Run after it writes the lines still queued
+func (s *Store) Stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+// addShipyard stores one of shipyard's own lines
+func (s *Store) addShipyard(msg []byte, now time.Time) {
+	var rec map[string]any
+	if err := json.Unmarshal(msg, &rec); err != nil {
+		return
+	}
+	rec["source"] = SourceShipyard
+	// Logging a failure here would queue another line to fail
+	_ = s.Add(rec, now)
+}
+
+// Add stores a log record, stamping it with now when it has no time
+func (s *Store) Add(rec map[string]any, now time.Time) error {
+	at, ok := recordTime(rec)
+	if !ok {
+		at = now
+		rec["time"] = now.Format(time.RFC3339Nano)
+	}
+	level := recordLevel(rec)
+	site, _ := rec["site"].(string)
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode log line: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	date := at.UTC().Format(dateFormat)
+	if s.file == nil || s.fileDate != date {
+		if s.file != nil {
+			s.file.Close()
+			s.file = nil
+		}
+		if err := os.MkdirAll(s.dir, 0750); err != nil {
+			return fmt.Errorf("create log directory: %w", err)
+		}
+		f, err := os.OpenFile(s.dayPath(date), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		s.file, s.fileDate = f, date
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write log line: %w", err)
+	}
+
+	idx := s.days[date]
+	if idx == nil {
+		idx = &dayIndex{First: at, Last: at, Sites: map[string]int{}, Levels: map[string]int{}}
+		s.days[date] = idx
+	}
+	if at.Before(idx.First) {
+		idx.First = at
+	}
+	if at.After(idx.Last) {
+		idx.Last = at
+	}
+	idx.Lines++
+	idx.Levels[level]++
+	if site != "" {
+		idx.Sites[site]++
+	}
+	idx.dirty = true
+	return nil
+}
+
+// readAppLogs copies the lines added to each backend's app.log
+func (s *Store) readAppLogs(now time.Time) {
+	s.mu.Lock()
+	paths := s.appLogs
+	s.mu.Unlock()
+	if paths == nil {
+		return
+	}
+	for site, path := range paths() {
+		if err := s.Tail(site, path, now); err != nil {
+			slog.Debug("read app log", "site", site, "error", err)
+		}
+	}
+}
+
+// Tail stores the lines a site's backend added to its log at path since the
+// last call. A log not seen before is read from its current end. Lines that
+// are JSON objects keep their fields; others become the msg of an INFO line.
+func (s *Store) Tail(site, path string, now time.Time) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open app log: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat app log: %w", err)
+	}
+
+	s.mu.Lock()
+	offset, seen := s.offsets[path]
+	if !seen {
+		s.offsets[path] = info.Size()
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+	if info.Size() < offset {
+		offset = 0 // truncated or rotated
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seek app log: %w", err)
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break // an incomplete last line is read next time
+		}
+		offset += int64(len(line))
+		text := strings.TrimRight(line, "\r\n")
+		if text == "" {
+			continue
+		}
+		var rec map[string]any
+		if json.Unmarshal([]byte(text), &rec) != nil || rec == nil {
+			if len(text) > maxLineSize {
+				text = text[:maxLineSize]
+			}
+			rec = map[string]any{"level": "INFO", "msg": text}
+		}
+		rec["site"] = site
+		rec["source"] = SourceApp
+		if err := s.Add(rec, now); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.offsets[path] = offset
+	s.mu.Unlock()
+	return nil
+}
+
+// Flush saves the indexes and app.log offsets that changed, and removes the
+// days older than the retention
+func (s *Store) Flush(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.days) == 0 && len(s.offsets) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0750); err != nil {
+		return fmt.Errorf("create log directory: %w", err)
+	}
+
+	cutoff := now.UTC().AddDate(0, 0, -s.retention).Format(dateFormat)
+	for date, idx := range s.days {
+		if date <= cutoff {
+			if s.fileDate == date && s.file != nil {
+				s.file.Close()
+				s.file = nil
+			}
+			os.Remove(s.dayPath(date))
+			os.Remove(s.indexPath(date))
+			delete(s.days, date)
+			continue
+		}
+		if !idx.dirty {
+			continue
+		}
+		data, err := json.Marshal(idx)
+		if err != nil {
+			return fmt.Errorf("encode log index: %w", err)
+		}
+		if err := writeFile(s.indexPath(date), data); err != nil {
+			return fmt.Errorf("write log index: %w", err)
+		}
+		idx.dirty = false
+	}
+
+	data, err := json.Marshal(s.offsets)
+	if err != nil {
+		return fmt.Errorf("encode app log offsets: %w", err)
+	}
+	if err := writeFile(s.offsetsPath(), data); err != nil {
+		return fmt.Errorf("write app log offsets: %w", err)
+	}
+	return nil
+}
+
+// Search returns the stored lines matching q, newest first
+func (s *Store) Search(q Query) ([]map[string]any, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	limit = min(limit, MaxLimit)
+	text := strings.ToLower(q.Text)
+	minLevel := -1
+	if q.Level != "" {
+		minLevel = rankOf(strings.ToUpper(q.Level))
+	}
+
+	// The days that may hold matches, newest first
+	s.mu.Lock()
+	var dates []string
+	for date, idx := range s.days {
+		if !q.From.IsZero() && idx.Last.Before(q.From) ||
+			!q.To.IsZero() && idx.First.After(q.To) ||
+			q.Site != "" && idx.Sites[q.Site] == 0 ||
+			minLevel >= 0 && !hasLevel(idx, minLevel) {
+			continue
+		}
+		dates = append(dates, date)
+	}
+	s.mu.Unlock()
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+
+	results := []map[string]any{}
+	for _, date := range dates {
+		if len(results) >= limit {
+			break
+		}
+		f, err := os.Open(s.dayPath(date))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("open log file: %w", err)
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64<<10), 4*maxLineSize)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if text != "" && !strings.Contains(strings.ToLower(string(line)), text) {
+				continue
+			}
+			var rec map[string]any
+			if json.Unmarshal(line, &rec) != nil {
+				continue // a line still being written
+			}
+			if q.matches(rec, minLevel) {
+				results = append(results, rec)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read log file: %w", err)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, _ := recordTime(results[i])
+		b, _ := recordTime(results[j])
+		return a.After(b)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// matches reports whether a record passes the query's filters other than
+// its text
+func (q Query) matches(rec map[string]any, minLevel int) bool {
+	site, _ := rec["site"].(string)
+	if q.Site != "" && site != q.Site || q.Allow != nil && !q.Allow(site) {
+		return false
+	}
+	if source, _ := rec["source"].(string); q.Source != "" && source != q.Source {
+		return false
+	}
+	if deployID, _ := rec["deploy_id"].(string); q.DeployID != "" && deployID != q.DeployID {
+		return false
+	}
+	if minLevel >= 0 && rankOf(recordLevel(rec)) < minLevel {
+		return false
+	}
+	at, _ := recordTime(rec)
+	return (q.From.IsZero() || !at.Before(q.From)) && (q.To.IsZero() || !at.After(q.To))
+}
+
+// hasLevel reports whether a day holds lines at minLevel or above
+func hasLevel(idx *dayIndex, minLevel int) bool {
+	for level, n := range idx.Levels {
+		if n > 0 && rankOf(level) >= minLevel {
+			return true
+		}
+	}
+	return false
+}
+
+// rankOf returns a level's rank
+func rankOf(level string) int {
+	if r, ok := levelRank[level]; ok {
+		return r
+	}
+	return levelRank["INFO"]
+}
+
+// recordTime returns a record's time, and false when it has none
+func recordTime(rec map[string]any) (time.Time, bool) {
+	v, _ := rec["time"].(string)
+	t, err := time.Parse(time.RFC3339Nano, v)
+	return t, err == nil
+}
+
+// recordLevel returns a record's level in upper case, INFO when it has none
+func recordLevel(rec map[string]any) string {
+	if v, ok := rec["level"].(string); ok && v != "" {
+		return strings.ToUpper(v)
+	}
+	return "INFO"
+}
+
+func (s *Store) dayPath(date string) string {
+	return filepath.Join(s.dir, date+".jsonl")
+}
+
+func (s *Store) indexPath(date string) string {
+	return filepath.Join(s.dir, date+".idx.json")
+}
+
+func (s *Store) offsetsPath() string {
+	return filepath.Join(s.dir, "offsets.json")
+}
+
+// writeFile replaces path with data through a temporary file
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,103 @@
+package logstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_AddAndSearch(t *testing.T) {
+	dir := t.TempDir()
+	s := New(dir, 14)
+	day1 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	add := func(at time.Time, level, msg, site string) {
+		rec := map[string]any{"time": at.Format(time.RFC3339Nano), "level": level, "msg": msg, "source": SourceShipyard}
+		if site != "" {
+			rec["site"] = site
+		}
+		if err := s.Add(rec, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add(day1, "INFO", "deploy started", "a.example.com")
+	add(day1.Add(time.Minute), "ERROR", "Deploy failed", "a.example.com")
+	add(day2, "WARN", "nginx reload slow", "")
+	add(day2.Add(time.Minute), "INFO", "deploy started", "b.example.com")
+
+	msgs := func(q Query) []string {
+		t.Helper()
+		recs, err := s.Search(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, r := range recs {
+			out = append(out, r["msg"].(string))
+		}
+		return out
+	}
+
+	if got := msgs(Query{Text: "DEPLOY"}); len(got) != 3 || got[0] != "deploy started" || got[1] != "Deploy failed" {
+		t.Errorf("text search = %v, want 3 deploy lines newest first", got)
+	}
+	if got := msgs(Query{Site: "a.example.com", Level: "warn"}); len(got) != 1 || got[0] != "Deploy failed" {
+		t.Errorf("site and level search = %v, want [Deploy failed]", got)
+	}
+	if got := msgs(Query{From: day2}); len(got) != 2 {
+		t.Errorf("from search = %v, want the second day's 2 lines", got)
+	}
+	if got := msgs(Query{To: day1.Add(30 * time.Second)}); len(got) != 1 || got[0] != "deploy started" {
+		t.Errorf("to search = %v, want the first line", got)
+	}
+	if got := msgs(Query{Allow: func(site string) bool { return site == "b.example.com" }}); len(got) != 1 {
+		t.Errorf("allowed search = %v, want b.example.com's line", got)
+	}
+	if got := msgs(Query{Limit: 1}); len(got) != 1 || got[0] != "deploy started" {
+		t.Errorf("limited search = %v, want the newest line", got)
+	}
+
+	// Indexes survive a restart, and old days are removed
+	if err := s.Flush(day2); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := New(dir, 14)
+	if recs, _ := reloaded.Search(Query{}); len(recs) != 4 {
+		t.Errorf("reloaded search found %d lines, want 4", len(recs))
+	}
+	if err := reloaded.Flush(day1.AddDate(0, 0, 15)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2026-03-01.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("day past retention still on disk: %v", err)
+	}
+}
+
+func TestStore_Tail(t *testing.T) {
+	s := New(t.TempDir(), 14)
+	path := filepath.Join(t.TempDir(), "app.log")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	os.WriteFile(path, []byte("before shipyard saw it\n"), 0644)
+
+	if err := s.Tail("api.example.com", path, now); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("plain line\n{\"level\":\"error\",\"msg\":\"db down\"}\npartial")
+	f.Close()
+	if err := s.Tail("api.example.com", path, now); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := s.Search(Query{Site: "api.example.com", Source: SourceApp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("app lines = %v, want the 2 complete lines added after the first read", recs)
+	}
+	if errs, _ := s.Search(Query{Level: "error"}); len(errs) != 1 || errs[0]["msg"] != "db down" {
+		t.Errorf("error lines = %v, want the JSON line's own level kept", errs)
+	}
+}
@@ -0,0 +1,43 @@
+package nginx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lachierussell/shipyard/ban"
+	"github.com/lachierussell/shipyard/config"
+)
+
+// banBlock returns the http-level directives of [ban]: the log shipyard
+// reads and the deny file it writes. Empty unless ban is enabled.
+func banBlock(cfg *config.Config) string {
+	if !cfg.Ban.Enabled {
+		return ""
+	}
+	return fmt.Sprintf(`
+    # Banned clients — managed by shipyard, see GET /bans
+    log_format shipyard_ban '%s';
+    access_log %s shipyard_ban;
+    include %s;
+`, ban.LogFormat, cfg.Ban.LogPath(), cfg.Ban.IncludePath(cfg.Nginx))
+}
+
+// ensureBanInclude creates an empty deny file, so the main conf validates
+// before shipyard has banned anyone
+func ensureBanInclude(cfg *config.Config) error {
+	if !cfg.Ban.Enabled {
+		return nil
+	}
+	path := cfg.Ban.IncludePath(cfg.Nginx)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create ban include directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(ban.DenyConf(nil)), 0644); err != nil {
+		return fmt.Errorf("write ban include: %w", err)
+	}
+	return nil
+}
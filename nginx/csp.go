@@ -0,0 +1,83 @@
+package nginx
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// CSPReportPath is where a site's browsers send CSP violation reports. The
+// generated config forwards them to shipyard's POST /csp-report.
+const CSPReportPath = "/.well-known/shipyard/csp-report"
+
+// APIUpstream is the upstream override.conf defines for shipyard's API
+const APIUpstream = "shipyard_api"
+
+// CSPDirectives returns the server-level directives that send a site's
+// Content-Security-Policy header and, with csp.report, forward violation
+// reports to shipyard. It returns nil for sites without a csp section.
+func CSPDirectives(site config.SiteConfig, cfg *config.Config) []string {
+	if site.CSP == nil {
+		return nil
+	}
+
+	lines := []string{
+		"# Content Security Policy",
+		cspHeader(*site.CSP),
+		"",
+	}
+	if site.CSP.Report {
+		scheme := "http"
+		if cfg.Server.TLSCert != "" {
+			scheme = "https"
+		}
+		lines = append(lines,
+			"# CSP violation reports, collected by shipyard (GET /site/csp-reports)",
+			fmt.Sprintf("location = %s {", CSPReportPath),
+			fmt.Sprintf("    proxy_pass %s://%s/csp-report;", scheme, APIUpstream),
+			"    proxy_set_header Host $host;",
+			"}",
+			"",
+		)
+	}
+	return lines
+}
+
+// cspHeader returns the add_header directive that sends csp's policy
+func cspHeader(csp config.CSPConfig) string {
+	header := "Content-Security-Policy"
+	if csp.ReportOnly {
+		header = "Content-Security-Policy-Report-Only"
+	}
+	policy := strings.TrimSuffix(strings.TrimSpace(csp.Policy), ";")
+	if csp.Report {
+		policy += "; report-uri " + CSPReportPath
+	}
+	return fmt.Sprintf(`add_header %s "%s" always;`, header, policy)
+}
+
+// apiUpstreamBlock returns the upstream block CSP report locations proxy to,
+// or an empty string if no site sends reports
+func apiUpstreamBlock(cfg *config.Config) string {
+	if cfg.ListenAddr() == "" {
+		return ""
+	}
+	for _, site := range cfg.Site {
+		if site.CSPReports() {
+			return fmt.Sprintf("# --- Shipyard API, for CSP violation reports ---\nupstream %s {\n    server %s;\n}\n\n",
+				APIUpstream, loopbackAddr(cfg.ListenAddr()))
+		}
+	}
+	return ""
+}
+
+// loopbackAddr returns how nginx reaches a shipyard listener: listeners bound
+// to all interfaces are reached via loopback
+func loopbackAddr(listenAddr string) string {
+	if host, port, err := net.SplitHostPort(listenAddr); err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return listenAddr
+}
@@ -0,0 +1,45 @@
+package nginx
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestCSPDirectives(t *testing.T) {
+	if lines := CSPDirectives(config.SiteConfig{FrontendRoot: "/var/www/app"}, &config.Config{}); lines != nil {
+		t.Errorf("site without csp = %v, want nil", lines)
+	}
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{ListenAddr: "0.0.0.0:8443", TLSCert: "/etc/shipyard/cert.pem"},
+		Site: map[string]config.SiteConfig{
+			"app.example.com": {FrontendRoot: "/var/www/app", CSP: &config.CSPConfig{Policy: "default-src 'self';", ReportOnly: true, Report: true}},
+		},
+	}
+	out := strings.Join(CSPDirectives(cfg.Site["app.example.com"], cfg), "\n")
+	for _, want := range []string{
+		`add_header Content-Security-Policy-Report-Only "default-src 'self'; report-uri /.well-known/shipyard/csp-report" always;`,
+		"location = /.well-known/shipyard/csp-report {",
+		"proxy_pass https://shipyard_api/csp-report;",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("CSP directives missing %q:\n%s", want, out)
+		}
+	}
+
+	// A site key's deploy may proxy reports to shipyard
+	conf := "server {\n" + FrontendBlock(cfg.Site["app.example.com"], cfg) + "\n}\n"
+	if _, _, err := EnforcePolicy(conf, "app.example.com", cfg); err != nil {
+		t.Errorf("generated CSP config should pass the directive policy: %v", err)
+	}
+
+	override := GenerateOverrideConf(cfg)
+	if !strings.Contains(override, "upstream shipyard_api {\n    server 127.0.0.1:8443;\n}") {
+		t.Errorf("override.conf should define the shipyard_api upstream:\n%s", override)
+	}
+	if strings.Contains(GenerateOverrideConf(&config.Config{Server: cfg.Server}), "upstream shipyard_api") {
+		t.Error("override.conf should only define shipyard_api when a site sends CSP reports")
+	}
+}
@@ -0,0 +1,91 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// experimentCookieMaxAge is how long (seconds) a client keeps its variant
+const experimentCookieMaxAge = 30 * 24 * 3600
+
+// ExperimentCookie returns the cookie holding a client's variant of an
+// experiment. Each experiment has its own, so a new one re-splits everyone.
+func ExperimentCookie(name string) string {
+	return "shipyard_exp_" + name
+}
+
+// experimentSplit returns the split_clients lines sharing clients between an
+// experiment's variants by weight. Variants with weight 0 get no one.
+func experimentSplit(exp config.ExperimentConfig) []string {
+	var weighted []string
+	for _, name := range exp.VariantNames() {
+		if exp.Variants[name].Weight > 0 {
+			weighted = append(weighted, name)
+		}
+	}
+	total := exp.TotalWeight()
+
+	var lines []string
+	for i, name := range weighted {
+		if i == len(weighted)-1 {
+			lines = append(lines, fmt.Sprintf("    *        %s;", name))
+			break
+		}
+		// Rounded down, so the shares never add up to more than 100%
+		hundredths := exp.Variants[name].Weight * 10000 / total
+		if hundredths == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("    %d.%02d%%  %s;", hundredths/100, hundredths%100, name))
+	}
+	return lines
+}
+
+// experimentMaps returns the override.conf blocks for a site's experiment:
+// the split of new clients, the variant kept in their cookie, the version
+// each variant serves, and the Set-Cookie value for clients without one
+func experimentMaps(domain string, exp config.ExperimentConfig) string {
+	normalized := NormalizeDomainName(domain)
+	cookie := ExperimentCookie(exp.Name)
+	split := experimentSplit(exp)
+
+	var sb strings.Builder
+	shares := make([]string, 0, len(exp.Variants))
+	for _, name := range exp.VariantNames() {
+		shares = append(shares, fmt.Sprintf("%s %d", name, exp.Variants[name].Weight))
+	}
+	sb.WriteString(fmt.Sprintf("# site: %s — experiment %s (%s)\n", domain, exp.Name, strings.Join(shares, ", ")))
+
+	sb.WriteString(fmt.Sprintf("split_clients \"${remote_addr}${http_user_agent}%s\" $experiment_split_%s {\n", exp.Name, normalized))
+	sb.WriteString(strings.Join(split, "\n") + "\n")
+	sb.WriteString("}\n\n")
+
+	// A cookie naming a variant that still gets traffic keeps the client on it
+	sb.WriteString(fmt.Sprintf("map $cookie_%s $experiment_variant_%s {\n", cookie, normalized))
+	sb.WriteString(fmt.Sprintf("    default  $experiment_split_%s;\n", normalized))
+	for _, name := range exp.VariantNames() {
+		if exp.Variants[name].Weight > 0 {
+			sb.WriteString(fmt.Sprintf("    %s  %s;\n", name, name))
+		}
+	}
+	sb.WriteString("}\n\n")
+
+	sb.WriteString(fmt.Sprintf("map $experiment_variant_%s $experiment_version_%s {\n", normalized, normalized))
+	sb.WriteString("    default  latest;\n")
+	for _, name := range exp.VariantNames() {
+		sb.WriteString(fmt.Sprintf("    %s  %s;\n", name, exp.Variants[name].Commit))
+	}
+	sb.WriteString("}\n\n")
+
+	sb.WriteString(fmt.Sprintf("map $cookie_%s $experiment_cookie_%s {\n", cookie, normalized))
+	sb.WriteString(fmt.Sprintf("    default  \"%s=$experiment_variant_%s; Path=/; Max-Age=%d; SameSite=Lax\";\n", cookie, normalized, experimentCookieMaxAge))
+	for _, name := range exp.VariantNames() {
+		if exp.Variants[name].Weight > 0 {
+			sb.WriteString(fmt.Sprintf("    %s  \"\";\n", name))
+		}
+	}
+	sb.WriteString("}\n\n")
+	return sb.String()
+}
@@ -0,0 +1,122 @@
+package nginx
+
+import (
+	"fmt"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// frontendHeaders are the add_header directives FrontendDirectives puts in
+// the server block
+var frontendHeaders = []string{
+	"add_header X-Robots-Tag $xrobots_value always;",
+	"add_header Set-Cookie $experiment_cookie;",
+	"add_header Set-Cookie $override_cookie;",
+}
+
+// FrontendDirectives returns the server-level directives that serve a site's
+// frontend build and uploaded assets, one per line and without indentation.
+// The build is tried first, then the assets directory, then (with
+// spa_fallback) /index.html. Commits previewed with ?override= are kept out
+// of search engines and stay pinned across page loads, experiment clients are
+// kept on their variant, and precompressed copies are served when the site
+// has them.
+func FrontendDirectives(site config.SiteConfig) []string {
+	lines := []string{
+		"# Override previews are never indexed ($xrobots_value is empty otherwise)",
+		frontendHeaders[0],
+		"# Keeps the client on its experiment variant ($experiment_cookie is empty otherwise)",
+		frontendHeaders[1],
+		"# Keeps a tester on the commit previewed with ?override= ($override_cookie is empty otherwise)",
+		frontendHeaders[2],
+		"",
+	}
+
+	if site.Precompresses(config.EncodingGzip) || site.Precompresses(config.EncodingBrotli) {
+		lines = append(lines, "# Compressed copies written at deploy time")
+		if site.Precompresses(config.EncodingGzip) {
+			lines = append(lines, "gzip_static on;", "gzip_vary on;")
+		}
+		if site.Precompresses(config.EncodingBrotli) {
+			lines = append(lines, "brotli_static on;")
+		}
+		lines = append(lines, "")
+	}
+
+	if site.ErrorPage404 != "" || site.ErrorPage50x != "" {
+		lines = append(lines, "# Custom error pages from the build")
+		if site.ErrorPage404 != "" {
+			lines = append(lines, fmt.Sprintf("error_page 404 %s;", site.ErrorPage404))
+		}
+		if site.ErrorPage50x != "" {
+			lines = append(lines, fmt.Sprintf("error_page 500 502 503 504 %s;", site.ErrorPage50x))
+		}
+		lines = append(lines, "")
+	}
+
+	// Without a trailing slash, /about is served from about.html or about/index.html
+	tryFiles := "$uri $uri/"
+	if site.TrailingSlash == config.TrailingSlashRemove {
+		tryFiles = "$uri $uri.html $uri/index.html"
+	}
+	fallback := "=404"
+	if site.SPA() {
+		fallback = "@shipyard_spa"
+	}
+
+	lines = append(lines, "# Frontend routes: build output, then uploaded assets", "location / {")
+	switch site.TrailingSlash {
+	case config.TrailingSlashAdd:
+		// Paths with a file extension are left alone
+		lines = append(lines, `    rewrite ^([^.]*[^/])$ $1/ permanent;`)
+	case config.TrailingSlashRemove:
+		lines = append(lines, `    rewrite ^(.+)/$ $1 permanent;`)
+	}
+	lines = append(lines,
+		fmt.Sprintf("    try_files %s @shipyard_assets;", tryFiles),
+		"}",
+		"",
+		"# Override previews disallow all crawling, whatever the commit's robots.txt says",
+		"location = /robots.txt {",
+		"    default_type text/plain;",
+		"    if ($is_override) {",
+		`        return 200 "User-agent: *\nDisallow: /\n";`,
+		"    }",
+		"    try_files $uri @shipyard_assets;",
+		"}",
+		"",
+		"# Files uploaded with POST /site/assets (favicons, verification tokens, ...)",
+		"location @shipyard_assets {",
+		fmt.Sprintf("    root %s;", site.AssetsDir()),
+		fmt.Sprintf("    try_files $uri %s;", fallback),
+		"}",
+	)
+
+	if site.SPA() {
+		lines = append(lines,
+			"",
+			"# SPA fallback: client-side routes are handled by index.html",
+			"location @shipyard_spa {",
+			"    rewrite ^ /index.html break;",
+			"}",
+		)
+	}
+	return lines
+}
+
+// inheritedHeaders returns the add_header directives a site's generated
+// config sets in its server block. nginx only passes them down to a location
+// or if block that has no add_header of its own, so such blocks repeat them.
+func inheritedHeaders(site config.SiteConfig) []string {
+	var headers []string
+	if site.CSP != nil {
+		headers = append(headers, cspHeader(*site.CSP))
+	}
+	return append(headers, frontendHeaders...)
+}
+
+// FrontendBlock returns the site's CSPDirectives and FrontendDirectives
+// indented for a server block
+func FrontendBlock(site config.SiteConfig, cfg *config.Config) string {
+	return indentLines(append(CSPDirectives(site, cfg), FrontendDirectives(site)...), "    ")
+}
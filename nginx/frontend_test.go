@@ -0,0 +1,124 @@
+package nginx
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestFrontendDirectives_Defaults(t *testing.T) {
+	out := strings.Join(FrontendDirectives(config.SiteConfig{FrontendRoot: "/var/www/app"}), "\n")
+
+	for _, want := range []string{
+		"try_files $uri $uri/ @shipyard_assets;",
+		"root /var/www/app/_assets;",
+		"try_files $uri @shipyard_spa;",
+		"rewrite ^ /index.html break;",
+		"add_header X-Robots-Tag $xrobots_value always;",
+		"location = /robots.txt {",
+		`return 200 "User-agent: *\nDisallow: /\n";`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("default frontend config missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "error_page") || strings.Contains(out, "permanent") || strings.Contains(out, "_static") {
+		t.Errorf("default frontend config should not set error pages, redirects or precompression:\n%s", out)
+	}
+}
+
+func TestFrontendDirectives_Options(t *testing.T) {
+	noSPA := false
+	site := config.SiteConfig{
+		FrontendRoot:  "/var/www/app",
+		SPAFallback:   &noSPA,
+		ErrorPage404:  "/404.html",
+		ErrorPage50x:  "/50x.html",
+		TrailingSlash: config.TrailingSlashRemove,
+		Precompress:   []string{config.EncodingGzip, config.EncodingBrotli},
+	}
+	out := strings.Join(FrontendDirectives(site), "\n")
+
+	for _, want := range []string{
+		"gzip_static on;",
+		"brotli_static on;",
+		"error_page 404 /404.html;",
+		"error_page 500 502 503 504 /50x.html;",
+		"rewrite ^(.+)/$ $1 permanent;",
+		"try_files $uri $uri.html $uri/index.html @shipyard_assets;",
+		"try_files $uri =404;",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("frontend config missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "@shipyard_spa") {
+		t.Errorf("spa_fallback = false should not fall back to index.html:\n%s", out)
+	}
+
+	site.TrailingSlash = config.TrailingSlashAdd
+	if out := strings.Join(FrontendDirectives(site), "\n"); !strings.Contains(out, "rewrite ^([^.]*[^/])$ $1/ permanent;") {
+		t.Errorf("trailing_slash = add missing redirect:\n%s", out)
+	}
+}
+
+func TestFrontendDirectives_PassPolicy(t *testing.T) {
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"app.example.com": {FrontendRoot: "/var/www/app", ErrorPage404: "/404.html", TrailingSlash: config.TrailingSlashAdd, Precompress: []string{config.EncodingGzip, config.EncodingBrotli}},
+	}}
+	conf := "server {\n" + FrontendBlock(cfg.Site["app.example.com"], cfg) + "\n}\n"
+	if _, _, err := EnforcePolicy(conf, "app.example.com", cfg); err != nil {
+		t.Errorf("generated frontend config should pass the directive policy: %v", err)
+	}
+}
+
+// addHeaderBlocks returns the add_header directives set directly in each
+// block of conf, by the line that opens the block
+func addHeaderBlocks(conf string) map[string][]string {
+	blocks := map[string][]string{}
+	var open []string
+	for _, line := range strings.Split(conf, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasSuffix(line, "{"):
+			open = append(open, line)
+		case line == "}":
+			open = open[:len(open)-1]
+		case strings.HasPrefix(line, "add_header ") && len(open) > 0:
+			blocks[open[len(open)-1]] = append(blocks[open[len(open)-1]], line)
+		}
+	}
+	return blocks
+}
+
+func TestGenerateSiteCombinedConfig_RepeatsInheritedHeaders(t *testing.T) {
+	site := config.SiteConfig{
+		FrontendRoot: "/var/www/app",
+		CSP:          &config.CSPConfig{Policy: "default-src 'self'"},
+		Backend:      &config.BackendConfig{ListenPort: 8080, RestartResponse: config.RestartResponse503},
+	}
+	cfg := &config.Config{Site: map[string]config.SiteConfig{"app.example.com": site}}
+	conf := GenerateSiteCombinedConfig("app.example.com", site, cfg)
+
+	blocks := addHeaderBlocks(conf)
+	server := blocks["server {"]
+	if len(server) != 4 {
+		t.Fatalf("server block add_header = %v, want the CSP, robots and cookie headers", server)
+	}
+	// nginx drops the server block's headers in a block with its own
+	for open, headers := range blocks {
+		if open == "server {" {
+			continue
+		}
+		for _, want := range server {
+			if !slices.Contains(headers, want) {
+				t.Errorf("%s sets add_header without repeating %q:\n%s", open, want, conf)
+			}
+		}
+	}
+	if len(blocks) < 2 {
+		t.Errorf("the maintenance 503 should set its own headers:\n%s", conf)
+	}
+}
@@ -0,0 +1,64 @@
+package nginx
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// userTemplateFuncs returns the functions available to user nginx templates:
+//
+//	default "x" .Val        - .Val, or "x" if .Val is empty
+//	normalizeDomain .Domain - "my-app.example.com" -> "my_app_example_com"
+//	upstreamName .Domain    - a unique upstream block name for the site
+//	env "NAME"              - an environment variable listed in nginx.template_env
+//	join " " .Aliases       - list elements joined by a separator
+//	snippet "name"          - the shared snippet stored under that name
+//	maintenance .Domain     - the 503 block of a backend with restart_response = "503"
+//	mirror .Domain          - the directives copying requests of a backend with a mirror
+//	mirrorLocation .Domain  - the internal location those copies go through
+//	geo .Domain             - the server-level block applying a site's country rules
+func userTemplateFuncs(cfg *config.Config) template.FuncMap {
+	snippets := NewSnippetStore(cfg.SnippetsDir())
+	return template.FuncMap{
+		"default":         defaultValue,
+		"normalizeDomain": NormalizeDomainName,
+		"upstreamName":    UpstreamName,
+		"join":            func(sep string, elems []string) string { return strings.Join(elems, sep) },
+		"env": func(name string) (string, error) {
+			if !slices.Contains(cfg.Nginx.TemplateEnv, name) {
+				return "", fmt.Errorf("env %q is not listed in nginx.template_env", name)
+			}
+			return os.Getenv(name), nil
+		},
+		"maintenance":    func(domain string) string { return maintenanceBlock(cfg, domain) },
+		"mirror":         func(domain string) string { return mirrorBlock(cfg, domain) },
+		"mirrorLocation": func(domain string) string { return mirrorLocationBlock(cfg, domain) },
+		"geo":            func(domain string) string { return geoBlock(cfg, domain) },
+		"snippet": func(name string) (string, error) {
+			content, err := snippets.Get(name)
+			return strings.TrimRight(content, "\n"), err
+		},
+	}
+}
+
+// UpstreamName returns the nginx upstream block name for a site
+func UpstreamName(domain string) string {
+	return "shipyard_" + NormalizeDomainName(domain)
+}
+
+// defaultValue returns val unless it is the zero value, in which case def
+func defaultValue(def, val any) any {
+	if val == nil {
+		return def
+	}
+	if v := reflect.ValueOf(val); v.IsZero() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0) {
+		return def
+	}
+	return val
+}
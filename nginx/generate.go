@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/ssl"
 )
 
 //go:embed backend_proxy.conf.tmpl
@@ -33,28 +34,35 @@ var siteCombinedTmpl = template.Must(template.New("site_combined").Delims("<%",
 var siteCombinedHTTPSTmpl = template.Must(template.New("site_combined_https").Delims("<%", "%>").Parse(siteCombinedHTTPSTmplStr))
 
 type backendProxyData struct {
-	Domain      string
-	AcmeWebroot string
-	Location    string
-	ListenPort  int
-	SSLCert     string
-	SSLKey      string
+	Domain          string
+	AcmeWebroot     string
+	Location        string
+	GeoDirectives   string
+	ProxyDirectives string
+	MirrorLocation  string
+	TLSDirectives   string
+	HTTP2           bool
 }
 
 type siteCombinedData struct {
-	Domain       string
-	AcmeWebroot  string
-	FrontendRoot string
-	ProxyPath    string
-	ListenPort   int
-	SSLCert      string
-	SSLKey       string
+	Domain             string
+	AcmeWebroot        string
+	FrontendRoot       string
+	FrontendDirectives string
+	GeoDirectives      string
+	ProxyPath          string
+	ProxyDirectives    string
+	MirrorLocation     string
+	TLSDirectives      string
+	HTTP2              bool
 }
 
 // NormalizeDomainName converts "example.com" to "example_com" for variable naming
-// Also replaces hyphens since nginx variables can't contain them
+// Also replaces hyphens since nginx variables can't contain them, and the * of
+// a wildcard site ("*.example.com" becomes "wildcard_example_com")
 func NormalizeDomainName(domain string) string {
-	result := strings.ReplaceAll(domain, ".", "_")
+	result := strings.Replace(domain, "*", "wildcard", 1)
+	result = strings.ReplaceAll(result, ".", "_")
 	result = strings.ReplaceAll(result, "-", "_")
 	return result
 }
@@ -67,25 +75,60 @@ func GenerateOverrideConf(cfg *config.Config) string {
 	sb.WriteString(fmt.Sprintf("# Generated: %s\n\n", time.Now().UTC().Format(time.RFC3339)))
 
 	// Version selection map
-	// Note: nginx doesn't support {n} quantifier in regexes; using ~.+ instead.
-	// The Go API handler validates that it's 7-40 hex chars (git short or long hash).
+	// Note: nginx doesn't support {n} quantifier in regexes; using ~^[0-9a-f]+$ instead.
+	// $frontend_version ends up in root, so anything else (e.g. ../) must not reach it.
 	sb.WriteString(`# --- Version selection: valid git hash (7-40 char hex) or "latest" ---
+# ?override= wins, then the sticky override cookie, then the site's canary split
 map $arg_override $frontend_version {
-    default      latest;
-    ~.+          $arg_override;
+    default      $cookie_override_version;
+    latest       latest;
+    ~^[0-9a-f]+$ $arg_override;
+}
+
+map $cookie_shipyard_override $cookie_override_version {
+    default         $canary_version;
+    ~^[0-9a-f]+$    $cookie_shipyard_override;
 }
 
 `)
 
+	// Sticky override cookie
+	sb.WriteString("# --- Sticky override: Set-Cookie value for add_header (empty = no header) ---\n")
+	sb.WriteString("# ?override=<commit> pins the client to that commit; ?override=latest unpins it\n")
+	sb.WriteString("map $arg_override $override_cookie {\n")
+	sb.WriteString("    default         \"\";\n")
+	if maxAge := cfg.Nginx.OverrideCookieMaxAge(); maxAge > 0 {
+		sb.WriteString("    latest          \"shipyard_override=; Path=/; Max-Age=0; HttpOnly; SameSite=Lax\";\n")
+		sb.WriteString(fmt.Sprintf("    ~^[0-9a-f]+$    \"shipyard_override=$arg_override; Path=/; Max-Age=%d; HttpOnly; SameSite=Lax\";\n", maxAge))
+	}
+	sb.WriteString("}\n\n")
+
 	// Is override active?
 	sb.WriteString(`# --- Is an override active? (used to gate header + access) ---
-map $arg_override $is_override {
-    default  0;
-    ~.+      1;
+map "$arg_override:$cookie_shipyard_override" $is_override {
+    default           0;
+    ~^latest:         0;
+    ~^[^:]+:          1;
+    ~^:[0-9a-f]+$     1;
+}
+
+`)
+
+	// WebSocket upgrade header for backends with websocket = true
+	sb.WriteString(`# --- WebSocket: Connection header for proxied upgrades ---
+map $http_upgrade $connection_upgrade {
+    default  upgrade;
+    ""       close;
 }
 
 `)
 
+	// Shipyard's API, for sites that send it their CSP violation reports
+	sb.WriteString(apiUpstreamBlock(cfg))
+
+	// Client country, for geo rules and country override_ips
+	sb.WriteString(geoipBlock(cfg))
+
 	// X-Robots-Tag value
 	sb.WriteString(`# --- X-Robots-Tag value (empty string = header not meaningful) ---
 map $is_override $xrobots_value {
@@ -95,24 +138,106 @@ map $is_override $xrobots_value {
 
 `)
 
-	// Per-site geo blocks (IP whitelist) - sort for deterministic output
-	sb.WriteString("# --- Per-site IP whitelist ---\n")
 	siteNames := make([]string, 0, len(cfg.Site))
 	for name := range cfg.Site {
 		siteNames = append(siteNames, name)
 	}
 	sort.Strings(siteNames)
 
+	// Canary rollouts and experiments: hash each client into a version
+	sb.WriteString("# --- Canary rollouts and experiments: version served when there is no override ---\n")
+	sb.WriteString("map $host $canary_version {\n")
+	sb.WriteString("    default  latest;\n")
+	for _, domain := range siteNames {
+		switch site := cfg.Site[domain]; {
+		case site.Canary != nil:
+			sb.WriteString(fmt.Sprintf("    %s  $canary_version_%s;\n", domain, NormalizeDomainName(domain)))
+		case site.Experiment != nil:
+			sb.WriteString(fmt.Sprintf("    %s  $experiment_version_%s;\n", domain, NormalizeDomainName(domain)))
+		}
+	}
+	sb.WriteString("}\n\n")
+	sb.WriteString("# Set-Cookie value keeping a client on its experiment variant (empty = no header)\n")
+	sb.WriteString("map $host $experiment_cookie {\n")
+	sb.WriteString("    default  \"\";\n")
+	for _, domain := range siteNames {
+		if cfg.Site[domain].Experiment != nil {
+			sb.WriteString(fmt.Sprintf("    %s  $experiment_cookie_%s;\n", domain, NormalizeDomainName(domain)))
+		}
+	}
+	sb.WriteString("}\n\n")
+	for _, domain := range siteNames {
+		if exp := cfg.Site[domain].Experiment; exp != nil {
+			sb.WriteString(experimentMaps(domain, *exp))
+		}
+	}
+	for _, domain := range siteNames {
+		canary := cfg.Site[domain].Canary
+		if canary == nil {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("# site: %s — %d%% on %s\n", domain, canary.Percent, canary.Commit))
+		sb.WriteString(fmt.Sprintf("split_clients \"${remote_addr}${http_user_agent}\" $canary_version_%s {\n", NormalizeDomainName(domain)))
+		switch {
+		case canary.Percent >= 100:
+			sb.WriteString(fmt.Sprintf("    *    %s;\n", canary.Commit))
+		case canary.Percent > 0:
+			sb.WriteString(fmt.Sprintf("    %d%%  %s;\n", canary.Percent, canary.Commit))
+			fallthrough
+		default:
+			sb.WriteString("    *    latest;\n")
+		}
+		sb.WriteString("}\n\n")
+	}
+
+	// Request mirroring: which requests are copied, and the URI they go to
+	sb.WriteString("# --- Request mirroring: share of backend requests copied to a candidate ---\n")
+	for _, domain := range siteNames {
+		site := cfg.Site[domain]
+		if site.Backend != nil && site.Backend.Mirrored() {
+			sb.WriteString(mirrorMaps(domain, site))
+		}
+	}
+
+	// Per-site geo blocks (IP whitelist) - sort for deterministic output
+	sb.WriteString("# --- Per-site IP whitelist ---\n")
+
 	for _, domain := range siteNames {
 		site := cfg.Site[domain]
 		normalized := NormalizeDomainName(domain)
+		ips, countries := overrideCountries(cfg, site.OverrideIPs)
 		sb.WriteString(fmt.Sprintf("# site: %s\n", domain))
-		sb.WriteString(fmt.Sprintf("geo $override_allowed_%s {\n", normalized))
+		if len(countries) == 0 {
+			sb.WriteString(fmt.Sprintf("geo $override_allowed_%s {\n", normalized))
+		} else {
+			sb.WriteString(fmt.Sprintf("geo $override_ip_allowed_%s {\n", normalized))
+		}
 		sb.WriteString("    default         0;\n")
-		for _, ip := range site.OverrideIPs {
+		for _, ip := range ips {
 			sb.WriteString(fmt.Sprintf("    %s 1;\n", ip))
 		}
 		sb.WriteString("}\n\n")
+		if len(countries) == 0 {
+			continue
+		}
+
+		// Clients from a listed country may override too
+		sb.WriteString(fmt.Sprintf("map $geoip2_country_code $override_country_allowed_%s {\n", normalized))
+		sb.WriteString("    default         0;\n")
+		for _, country := range countries {
+			sb.WriteString(fmt.Sprintf("    %s 1;\n", country))
+		}
+		sb.WriteString("}\n\n")
+		sb.WriteString(fmt.Sprintf("map \"$override_ip_allowed_%s$override_country_allowed_%s\" $override_allowed_%s {\n", normalized, normalized, normalized))
+		sb.WriteString("    default         1;\n")
+		sb.WriteString("    00              0;\n")
+		sb.WriteString("}\n\n")
+	}
+
+	// Per-site country rules
+	sb.WriteString("# --- Per-site country rules: \"\" serves, block refuses, a URL redirects ---\n")
+	for _, domain := range siteNames {
+		sb.WriteString(geoActionMaps(cfg, domain, cfg.Site[domain]))
 	}
 
 	// Per-site access decision maps (combined)
@@ -130,10 +255,21 @@ map $is_override $xrobots_value {
 	return sb.String()
 }
 
-// GenerateMainConf creates the main nginx.conf (written once during bootstrap)
-func GenerateMainConf() string {
+// GenerateMainConf creates the main nginx.conf (written during bootstrap and refreshed at startup).
+// When ssl.acme_listen_addr is set, a catch-all port 80 server forwards ACME HTTP-01
+// challenges to shipyard so certificates can be issued before a site's own config exists.
+func GenerateMainConf(cfg *config.Config) string {
+	overrideConf := cfg.Nginx.OverrideConf
+	if overrideConf == "" {
+		overrideConf = "/usr/local/etc/nginx/override.conf"
+	}
+	sitesEnabled := cfg.Nginx.SitesEnabled
+	if sitesEnabled == "" {
+		sitesEnabled = "/usr/local/etc/nginx/sites-enabled"
+	}
+
 	return `# MANAGED BY SHIPYARD — DO NOT EDIT
-worker_processes auto;
+` + geoipModule(cfg) + `worker_processes auto;
 error_log  /var/log/nginx/error.log warn;
 pid        /var/run/nginx.pid;
 
@@ -145,29 +281,103 @@ http {
     include       mime.types;
     default_type  application/octet-stream;
 
-    access_log  /var/log/nginx/access.log combined;
+    # The request's trace ID: the client's X-Request-Id, or a new one.
+    # Forwarded to backends and logged, so a request can be followed.
+    map $http_x_request_id $` + RequestIDVar + ` {
+        default $http_x_request_id;
+        ""      $request_id;
+    }
+    log_format shipyard '$remote_addr - $remote_user [$time_local] "$request" '
+                        '$status $body_bytes_sent "$http_referer" '
+                        '"$http_user_agent" "$` + RequestIDVar + `"';
+
+    access_log  /var/log/nginx/access.log shipyard;
+` + banBlock(cfg) + `
 
     sendfile        on;
     tcp_nopush      on;
     keepalive_timeout  65;
 
-    # Allow large uploads for deployments (500MB)
-    client_max_body_size 500M;
+    # Allow large uploads for deployments (matches shipyard's server.max_body_mb)
+    client_max_body_size ` + fmt.Sprintf("%dM", cfg.Server.BodyLimit()>>20) + `;
 
     # Override subsystem (map/geo blocks) — regenerated by shipyard
-    include /usr/local/etc/nginx/override.conf;
+    include ` + overrideConf + `;
 
     # Per-site server blocks — user-provided
-    include /usr/local/etc/nginx/sites-enabled/*.conf;
-}
+    include ` + sitesEnabled + `/*.conf;
+` + acmeDefaultServer(cfg.SSL.ACMEListenAddr) + `}
 `
 }
 
-// GenerateRobotsTxt creates a permissive default robots.txt
-func GenerateRobotsTxt() string {
-	return `User-agent: *
-Allow: /
-`
+// acmeDefaultServer returns the catch-all server block that proxies ACME challenges
+// to shipyard's challenge listener, or an empty string if the listener is disabled
+func acmeDefaultServer(listenAddr string) string {
+	if listenAddr == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+    # ACME HTTP-01 challenges for domains without a server block — answered by shipyard
+    server {
+        listen 80 default_server;
+        listen [::]:80 default_server;
+        server_name _;
+
+        location /.well-known/acme-challenge/ {
+            proxy_pass http://%s;
+            proxy_set_header Host $host;
+        }
+
+        location / {
+            return 404;
+        }
+    }
+`, loopbackAddr(listenAddr))
+}
+
+// GenerateRobotsTxt creates the robots.txt written into a frontend build of
+// siteName served at host: the site's robots rules, or everything allowed.
+// Staging and wildcard sites disallow everything by default. hasSitemap
+// reports whether the build has a /sitemap.xml to announce.
+func GenerateRobotsTxt(siteName, host string, site config.SiteConfig, hasSitemap bool) string {
+	if site.RobotsDisallowAll(siteName) {
+		return "User-agent: *\nDisallow: /\n"
+	}
+
+	robots := site.Robots
+	if robots == nil {
+		robots = &config.RobotsConfig{}
+	}
+	var sb strings.Builder
+	if robots.Rules != "" {
+		sb.WriteString(strings.TrimRight(robots.Rules, "\n") + "\n")
+	} else {
+		sb.WriteString("User-agent: *\n")
+		if len(robots.Disallow) == 0 {
+			sb.WriteString("Allow: /\n")
+		}
+		for _, path := range robots.Disallow {
+			sb.WriteString(fmt.Sprintf("Disallow: %s\n", path))
+		}
+	}
+
+	// Sitemap lines must be absolute URLs
+	sitemap := robots.Sitemap
+	if sitemap == "" && hasSitemap {
+		sitemap = "/sitemap.xml"
+	}
+	if strings.HasPrefix(sitemap, "/") {
+		scheme := "http"
+		if site.SSLEnabled {
+			scheme = "https"
+		}
+		sitemap = scheme + "://" + host + sitemap
+	}
+	if sitemap != "" && sitemap != config.SitemapNone {
+		sb.WriteString(fmt.Sprintf("\nSitemap: %s\n", sitemap))
+	}
+	return sb.String()
 }
 
 // AcmeWebroot is the directory where ACME challenges are served from
@@ -199,7 +409,7 @@ func GenerateHTTPOnlyConfig(domain string) string {
 // 1. Transforms it to use HTTPS (listen 443 ssl, adds SSL directives)
 // 2. Generates an HTTP->HTTPS redirect block with ACME passthrough
 // Returns the combined configuration (redirect block + HTTPS block)
-func TransformToHTTPS(nginxConfig string, domain string, sslCert string, sslKey string) string {
+func TransformToHTTPS(nginxConfig string, domain string, sslCert string, sslKey string, tls config.TLSConfig) string {
 	var sb strings.Builder
 
 	// Generate HTTP redirect block with ACME passthrough
@@ -220,15 +430,16 @@ func TransformToHTTPS(nginxConfig string, domain string, sslCert string, sslKey
 	sb.WriteString("}\n\n")
 
 	// Transform the user's config to HTTPS
-	httpsConfig := transformServerBlockToHTTPS(nginxConfig, sslCert, sslKey)
+	httpsConfig := transformServerBlockToHTTPS(nginxConfig, TLSDirectives(tls, sslCert, sslKey))
 	sb.WriteString("# HTTPS server block\n")
 	sb.WriteString(httpsConfig)
 
 	return sb.String()
 }
 
-// transformServerBlockToHTTPS modifies a server block to use SSL
-func transformServerBlockToHTTPS(config string, sslCert string, sslKey string) string {
+// transformServerBlockToHTTPS modifies a server block to use SSL, inserting tlsLines
+// after the first listen directive
+func transformServerBlockToHTTPS(config string, tlsLines []string) string {
 	lines := strings.Split(config, "\n")
 	var result []string
 	inServerBlock := false
@@ -257,16 +468,7 @@ func transformServerBlockToHTTPS(config string, sslCert string, sslKey string) s
 					result = append(result, indent+"listen [::]:443 ssl;")
 					result = append(result, "")
 					result = append(result, indent+"# SSL configuration (auto-generated by Shipyard)")
-					result = append(result, fmt.Sprintf("%sssl_certificate %s;", indent, sslCert))
-					result = append(result, fmt.Sprintf("%sssl_certificate_key %s;", indent, sslKey))
-					result = append(result, indent+"ssl_session_timeout 1d;")
-					result = append(result, indent+"ssl_session_cache shared:SSL:50m;")
-					result = append(result, indent+"ssl_session_tickets off;")
-					result = append(result, "")
-					result = append(result, indent+"# Modern SSL configuration")
-					result = append(result, indent+"ssl_protocols TLSv1.2 TLSv1.3;")
-					result = append(result, indent+"ssl_ciphers ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384;")
-					result = append(result, indent+"ssl_prefer_server_ciphers off;")
+					result = append(result, indentLines(tlsLines, indent))
 					result = append(result, "")
 					sslDirectivesAdded = true
 				}
@@ -290,19 +492,24 @@ func getIndent(line string) string {
 	return line
 }
 
-// GenerateBackendProxyConfig creates a default nginx config for proxying to a backend service
-func GenerateBackendProxyConfig(domain string, listenPort int, proxyPath string) string {
+// GenerateBackendProxyConfig creates a default nginx config for proxying to a
+// backend-only site's backend service
+func GenerateBackendProxyConfig(domain string, site config.SiteConfig) string {
+	backend := *site.Backend
 	location := "/"
-	if proxyPath != "" && proxyPath != "/" {
-		location = proxyPath
+	if backend.ProxyPath != "" && backend.ProxyPath != "/" {
+		location = backend.ProxyPath
 	}
 
 	var buf bytes.Buffer
 	if err := backendProxyTmpl.Execute(&buf, backendProxyData{
-		Domain:      domain,
-		AcmeWebroot: AcmeWebroot,
-		Location:    location,
-		ListenPort:  listenPort,
+		Domain:          domain,
+		AcmeWebroot:     AcmeWebroot,
+		Location:        location,
+		GeoDirectives:   indentLines(GeoDirectives(domain, site), "    "),
+		ProxyDirectives: indentLines(proxyLocation(domain, backend, nil), "        "),
+		MirrorLocation:  indentLines(MirrorLocationBlock(domain, backend, false), "    "),
+		HTTP2:           backend.BackendProtocol() == config.ProtocolGRPC,
 	}); err != nil {
 		return fmt.Sprintf("# Template error: %v\n", err)
 	}
@@ -310,21 +517,25 @@ func GenerateBackendProxyConfig(domain string, listenPort int, proxyPath string)
 	return buf.String()
 }
 
-// GenerateBackendProxyConfigHTTPS creates an HTTPS nginx config for proxying to a backend service
-func GenerateBackendProxyConfigHTTPS(domain string, listenPort int, proxyPath string, sslCert string, sslKey string) string {
+// GenerateBackendProxyConfigHTTPS creates an HTTPS nginx config for proxying
+// to a backend-only site's backend service
+func GenerateBackendProxyConfigHTTPS(domain string, site config.SiteConfig, sslCert string, sslKey string, tls config.TLSConfig) string {
+	backend := *site.Backend
 	location := "/"
-	if proxyPath != "" && proxyPath != "/" {
-		location = proxyPath
+	if backend.ProxyPath != "" && backend.ProxyPath != "/" {
+		location = backend.ProxyPath
 	}
 
 	var buf bytes.Buffer
 	if err := backendProxyHTTPSTmpl.Execute(&buf, backendProxyData{
-		Domain:      domain,
-		AcmeWebroot: AcmeWebroot,
-		Location:    location,
-		ListenPort:  listenPort,
-		SSLCert:     sslCert,
-		SSLKey:      sslKey,
+		Domain:          domain,
+		AcmeWebroot:     AcmeWebroot,
+		Location:        location,
+		GeoDirectives:   indentLines(GeoDirectives(domain, site), "    "),
+		ProxyDirectives: indentLines(proxyLocation(domain, backend, nil), "        "),
+		MirrorLocation:  indentLines(MirrorLocationBlock(domain, backend, false), "    "),
+		HTTP2:           backend.BackendProtocol() == config.ProtocolGRPC,
+		TLSDirectives:   indentLines(TLSDirectives(tls, sslCert, sslKey), "    "),
 	}); err != nil {
 		return fmt.Sprintf("# Template error: %v\n", err)
 	}
@@ -333,18 +544,24 @@ func GenerateBackendProxyConfigHTTPS(domain string, listenPort int, proxyPath st
 }
 
 // GenerateSiteCombinedConfig creates an nginx config with frontend + backend proxy
-func GenerateSiteCombinedConfig(domain string, frontendRoot string, listenPort int, proxyPath string) string {
+func GenerateSiteCombinedConfig(domain string, site config.SiteConfig, cfg *config.Config) string {
+	backend := *site.Backend
+	proxyPath := backend.ProxyPath
 	if proxyPath == "" {
 		proxyPath = "/api"
 	}
 
 	var buf bytes.Buffer
 	if err := siteCombinedTmpl.Execute(&buf, siteCombinedData{
-		Domain:       domain,
-		AcmeWebroot:  AcmeWebroot,
-		FrontendRoot: frontendRoot,
-		ProxyPath:    proxyPath,
-		ListenPort:   listenPort,
+		Domain:             domain,
+		AcmeWebroot:        AcmeWebroot,
+		FrontendRoot:       site.FrontendRoot,
+		FrontendDirectives: FrontendBlock(site, cfg),
+		GeoDirectives:      indentLines(GeoDirectives(domain, site), "    "),
+		ProxyPath:          proxyPath,
+		ProxyDirectives:    indentLines(proxyLocation(domain, backend, inheritedHeaders(site)), "        "),
+		MirrorLocation:     indentLines(MirrorLocationBlock(domain, backend, true), "    "),
+		HTTP2:              backend.BackendProtocol() == config.ProtocolGRPC,
 	}); err != nil {
 		return fmt.Sprintf("# Template error: %v\n", err)
 	}
@@ -353,20 +570,25 @@ func GenerateSiteCombinedConfig(domain string, frontendRoot string, listenPort i
 }
 
 // GenerateSiteCombinedConfigHTTPS creates an HTTPS nginx config with frontend + backend proxy
-func GenerateSiteCombinedConfigHTTPS(domain string, frontendRoot string, listenPort int, proxyPath string, sslCert string, sslKey string) string {
+func GenerateSiteCombinedConfigHTTPS(domain string, site config.SiteConfig, sslCert string, sslKey string, tls config.TLSConfig, cfg *config.Config) string {
+	backend := *site.Backend
+	proxyPath := backend.ProxyPath
 	if proxyPath == "" {
 		proxyPath = "/api"
 	}
 
 	var buf bytes.Buffer
 	if err := siteCombinedHTTPSTmpl.Execute(&buf, siteCombinedData{
-		Domain:       domain,
-		AcmeWebroot:  AcmeWebroot,
-		FrontendRoot: frontendRoot,
-		ProxyPath:    proxyPath,
-		ListenPort:   listenPort,
-		SSLCert:      sslCert,
-		SSLKey:       sslKey,
+		Domain:             domain,
+		AcmeWebroot:        AcmeWebroot,
+		FrontendRoot:       site.FrontendRoot,
+		FrontendDirectives: FrontendBlock(site, cfg),
+		GeoDirectives:      indentLines(GeoDirectives(domain, site), "    "),
+		ProxyPath:          proxyPath,
+		ProxyDirectives:    indentLines(proxyLocation(domain, backend, inheritedHeaders(site)), "        "),
+		MirrorLocation:     indentLines(MirrorLocationBlock(domain, backend, true), "    "),
+		HTTP2:              backend.BackendProtocol() == config.ProtocolGRPC,
+		TLSDirectives:      indentLines(TLSDirectives(tls, sslCert, sslKey), "    "),
 	}); err != nil {
 		return fmt.Sprintf("# Template error: %v\n", err)
 	}
@@ -377,11 +599,16 @@ func GenerateSiteCombinedConfigHTTPS(domain string, frontendRoot string, listenP
 // UserConfigData contains the template variables available to user-provided nginx configs.
 type UserConfigData struct {
 	Domain       string
+	Aliases      []string
 	FrontendRoot string
+	AssetsDir    string
 	ProxyPath    string
 	ListenPort   int
+	JailIP       string
 	AcmeWebroot  string
 	SSLEnabled   bool
+	SSLCert      string
+	SSLKey       string
 }
 
 // RenderUserConfig processes a user-provided nginx config as a Go template
@@ -395,7 +622,9 @@ func RenderUserConfig(nginxConfig string, siteName string, cfg *config.Config) (
 
 	data := UserConfigData{
 		Domain:       siteName,
+		Aliases:      site.Aliases,
 		FrontendRoot: site.FrontendRoot,
+		AssetsDir:    site.AssetsDir(),
 		AcmeWebroot:  AcmeWebroot,
 		SSLEnabled:   site.SSLEnabled,
 	}
@@ -403,9 +632,14 @@ func RenderUserConfig(nginxConfig string, siteName string, cfg *config.Config) (
 	if site.Backend != nil {
 		data.ProxyPath = site.Backend.ProxyPath
 		data.ListenPort = site.Backend.ListenPort
+		data.JailIP = site.Backend.JailIP
+	}
+
+	if site.SSLEnabled {
+		data.SSLCert, data.SSLKey = ssl.CertPaths(siteName)
 	}
 
-	tmpl, err := template.New("user_config").Delims("<%", "%>").Parse(nginxConfig)
+	tmpl, err := template.New("user_config").Delims("<%", "%>").Funcs(userTemplateFuncs(cfg)).Parse(nginxConfig)
 	if err != nil {
 		return "", fmt.Errorf("parse nginx config template: %w", err)
 	}
@@ -1,6 +1,7 @@
 package nginx
 
 import (
+	"slices"
 	"strings"
 	"testing"
 
@@ -105,7 +106,7 @@ func TestGenerateOverrideConf_DeterministicOrder(t *testing.T) {
 }
 
 func TestGenerateMainConf_HasManagedHeader(t *testing.T) {
-	result := GenerateMainConf()
+	result := GenerateMainConf(&config.Config{})
 
 	if !strings.Contains(result, "MANAGED BY SHIPYARD") {
 		t.Error("GenerateMainConf() should include managed header")
@@ -113,7 +114,7 @@ func TestGenerateMainConf_HasManagedHeader(t *testing.T) {
 }
 
 func TestGenerateMainConf_HasIncludeDirectives(t *testing.T) {
-	result := GenerateMainConf()
+	result := GenerateMainConf(&config.Config{})
 
 	if !strings.Contains(result, "include /usr/local/etc/nginx/override.conf") {
 		t.Error("GenerateMainConf() should include override.conf")
@@ -124,8 +125,77 @@ func TestGenerateMainConf_HasIncludeDirectives(t *testing.T) {
 	}
 }
 
+func TestGenerateMainConf_NoACMEServerByDefault(t *testing.T) {
+	result := GenerateMainConf(&config.Config{})
+
+	if strings.Contains(result, "default_server") {
+		t.Error("GenerateMainConf() should not add a default server without acme_listen_addr")
+	}
+}
+
+func TestGenerateMainConf_RequestID(t *testing.T) {
+	result := GenerateMainConf(&config.Config{})
+	for _, want := range []string{
+		"map $http_x_request_id $shipyard_request_id {",
+		`""      $request_id;`,
+		"access_log  /var/log/nginx/access.log shipyard;",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("GenerateMainConf() missing %q", want)
+		}
+	}
+}
+
+func TestProxyDirectives_ForwardRequestID(t *testing.T) {
+	for protocol, want := range map[string]string{
+		"":                     "proxy_set_header X-Request-Id $shipyard_request_id;",
+		config.ProtocolGRPC:    "grpc_set_header X-Request-Id $shipyard_request_id;",
+		config.ProtocolFastCGI: "fastcgi_param HTTP_X_REQUEST_ID $shipyard_request_id;",
+		config.ProtocolUWSGI:   "uwsgi_param HTTP_X_REQUEST_ID $shipyard_request_id;",
+	} {
+		lines := ProxyDirectives(config.BackendConfig{ListenPort: 8080, Protocol: protocol})
+		if !slices.Contains(lines, want) {
+			t.Errorf("protocol %q: ProxyDirectives() missing %q", protocol, want)
+		}
+	}
+}
+
+func TestGenerateMainConf_Ban(t *testing.T) {
+	if strings.Contains(GenerateMainConf(&config.Config{}), "shipyard_ban") {
+		t.Error("GenerateMainConf() should not log for bans unless ban is enabled")
+	}
+
+	cfg := &config.Config{
+		Nginx: config.NginxConfig{OverrideConf: "/etc/nginx/override.conf"},
+		Ban:   config.BanConfig{Enabled: true},
+	}
+	result := GenerateMainConf(cfg)
+	for _, want := range []string{
+		"log_format shipyard_ban '$msec\\t$remote_addr",
+		"access_log " + config.DefaultBanLog + " shipyard_ban;",
+		"include /etc/nginx/bans.conf;",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("GenerateMainConf() missing %q:\n%s", want, result)
+		}
+	}
+}
+
+func TestGenerateMainConf_ACMEServer(t *testing.T) {
+	result := GenerateMainConf(&config.Config{
+		SSL: config.SSLConfig{ACMEListenAddr: "0.0.0.0:8402"},
+	})
+
+	if !strings.Contains(result, "listen 80 default_server;") {
+		t.Error("GenerateMainConf() should add a catch-all port 80 server")
+	}
+	if !strings.Contains(result, "proxy_pass http://127.0.0.1:8402;") {
+		t.Error("GenerateMainConf() should proxy challenges to shipyard via loopback")
+	}
+}
+
 func TestGenerateRobotsTxt(t *testing.T) {
-	result := GenerateRobotsTxt()
+	result := GenerateRobotsTxt("example.com", "example.com", config.SiteConfig{}, false)
 
 	if !strings.Contains(result, "User-agent: *") {
 		t.Error("GenerateRobotsTxt() should include User-agent directive")
@@ -136,6 +206,35 @@ func TestGenerateRobotsTxt(t *testing.T) {
 	}
 }
 
+func TestGenerateRobotsTxt_Policy(t *testing.T) {
+	allow := false
+	tests := []struct {
+		name       string
+		siteName   string
+		site       config.SiteConfig
+		hasSitemap bool
+		want       string
+	}{
+		{"staging site", "staging.example.com", config.SiteConfig{StagingOf: "example.com"}, true,
+			"User-agent: *\nDisallow: /\n"},
+		{"wildcard previews", "*.docs.example.com", config.SiteConfig{}, false,
+			"User-agent: *\nDisallow: /\n"},
+		{"disallow_all = false", "*.docs.example.com", config.SiteConfig{Robots: &config.RobotsConfig{DisallowAll: &allow}}, false,
+			"User-agent: *\nAllow: /\n"},
+		{"build sitemap", "example.com", config.SiteConfig{SSLEnabled: true, Robots: &config.RobotsConfig{Disallow: []string{"/admin/"}}}, true,
+			"User-agent: *\nDisallow: /admin/\n\nSitemap: https://example.com/sitemap.xml\n"},
+		{"custom rules", "example.com", config.SiteConfig{Robots: &config.RobotsConfig{Rules: "User-agent: GPTBot\nDisallow: /", Sitemap: "/sitemaps/index.xml"}}, true,
+			"User-agent: GPTBot\nDisallow: /\n\nSitemap: http://example.com/sitemaps/index.xml\n"},
+		{"no sitemap", "example.com", config.SiteConfig{Robots: &config.RobotsConfig{Sitemap: config.SitemapNone}}, true,
+			"User-agent: *\nAllow: /\n"},
+	}
+	for _, tt := range tests {
+		if got := GenerateRobotsTxt(tt.siteName, "example.com", tt.site, tt.hasSitemap); got != tt.want {
+			t.Errorf("%s: robots.txt = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
 func TestTransformToHTTPS_AddsRedirectBlock(t *testing.T) {
 	httpConfig := `server {
     listen 80;
@@ -143,7 +242,7 @@ func TestTransformToHTTPS_AddsRedirectBlock(t *testing.T) {
     root /var/www;
 }`
 
-	result := TransformToHTTPS(httpConfig, "example.com", "/etc/ssl/cert.pem", "/etc/ssl/key.pem")
+	result := TransformToHTTPS(httpConfig, "example.com", "/etc/ssl/cert.pem", "/etc/ssl/key.pem", config.TLSConfig{})
 
 	if !strings.Contains(result, "listen 80;") {
 		t.Error("TransformToHTTPS() should include HTTP redirect block")
@@ -161,7 +260,7 @@ func TestTransformToHTTPS_AddsSSLDirectives(t *testing.T) {
     root /var/www;
 }`
 
-	result := TransformToHTTPS(httpConfig, "example.com", "/etc/ssl/cert.pem", "/etc/ssl/key.pem")
+	result := TransformToHTTPS(httpConfig, "example.com", "/etc/ssl/cert.pem", "/etc/ssl/key.pem", config.TLSConfig{})
 
 	if !strings.Contains(result, "listen 443 ssl") {
 		t.Error("TransformToHTTPS() should change port to 443 with ssl")
@@ -340,3 +439,355 @@ func TestGetIndent(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateOverrideConf_Canary(t *testing.T) {
+	cfg := &config.Config{
+		Site: map[string]config.SiteConfig{
+			"canary.example.com": {Canary: &config.CanaryConfig{Commit: "abc1234", Percent: 10}},
+			"full.example.com":   {Canary: &config.CanaryConfig{Commit: "def5678", Percent: 100}},
+			"plain.example.com":  {},
+		},
+	}
+
+	result := GenerateOverrideConf(cfg)
+
+	for _, want := range []string{
+		"default         $canary_version;",
+		"canary.example.com  $canary_version_canary_example_com;",
+		`split_clients "${remote_addr}${http_user_agent}" $canary_version_canary_example_com {`,
+		"10%  abc1234;",
+		"*    def5678;",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("GenerateOverrideConf() missing %q", want)
+		}
+	}
+	if strings.Contains(result, "$canary_version_plain_example_com") {
+		t.Error("GenerateOverrideConf() should not split sites without a canary")
+	}
+}
+
+func TestGenerateOverrideConf_Experiment(t *testing.T) {
+	cfg := &config.Config{
+		Site: map[string]config.SiteConfig{
+			"ab.example.com": {Experiment: &config.ExperimentConfig{Name: "checkout", Variants: map[string]config.VariantConfig{
+				"a":   {Commit: "latest", Weight: 1},
+				"b":   {Commit: "abc1234", Weight: 2},
+				"off": {Commit: "def5678", Weight: 0},
+			}}},
+			"plain.example.com": {},
+		},
+	}
+
+	result := GenerateOverrideConf(cfg)
+
+	for _, want := range []string{
+		"ab.example.com  $experiment_version_ab_example_com;",
+		"ab.example.com  $experiment_cookie_ab_example_com;",
+		"split_clients \"${remote_addr}${http_user_agent}checkout\" $experiment_split_ab_example_com {\n    33.33%  a;\n    *        b;\n}",
+		"map $cookie_shipyard_exp_checkout $experiment_variant_ab_example_com {\n    default  $experiment_split_ab_example_com;\n    a  a;\n    b  b;\n}",
+		"    b  abc1234;\n    off  def5678;\n",
+		`default  "shipyard_exp_checkout=$experiment_variant_ab_example_com; Path=/; Max-Age=2592000; SameSite=Lax";`,
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("GenerateOverrideConf() missing %q:\n%s", want, result)
+		}
+	}
+	if strings.Contains(result, "off  off;") {
+		t.Error("a variant with weight 0 should not keep its clients")
+	}
+	if strings.Contains(result, "$experiment_version_plain_example_com") {
+		t.Error("GenerateOverrideConf() should not split sites without an experiment")
+	}
+
+	frontend := strings.Join(FrontendDirectives(config.SiteConfig{}), "\n")
+	if !strings.Contains(frontend, "add_header Set-Cookie $experiment_cookie;") {
+		t.Errorf("frontend directives should set the experiment cookie:\n%s", frontend)
+	}
+}
+
+func TestGenerateOverrideConf_StickyCookie(t *testing.T) {
+	cfg := &config.Config{
+		Nginx: config.NginxConfig{OverrideCookieTTL: 600},
+		Site:  map[string]config.SiteConfig{"test.example.com": {}},
+	}
+
+	result := GenerateOverrideConf(cfg)
+
+	for _, want := range []string{
+		"map $cookie_shipyard_override $cookie_override_version",
+		"map $arg_override $override_cookie",
+		"shipyard_override=$arg_override; Path=/; Max-Age=600",
+		"shipyard_override=; Path=/; Max-Age=0",
+		`map "$arg_override:$cookie_shipyard_override" $is_override`,
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("GenerateOverrideConf() missing %q", want)
+		}
+	}
+
+	// The generated site config sends the cookie the map builds
+	block := FrontendBlock(cfg.Site["test.example.com"], cfg)
+	if !strings.Contains(block, "    add_header Set-Cookie $override_cookie;") {
+		t.Errorf("frontend block should set the override cookie:\n%s", block)
+	}
+
+	cfg.Nginx.OverrideCookieTTL = -1
+	if result := GenerateOverrideConf(cfg); strings.Contains(result, "Max-Age") {
+		t.Error("GenerateOverrideConf() should not set the cookie when override_cookie_ttl = -1")
+	}
+}
+
+func TestRenderUserConfig_Funcs(t *testing.T) {
+	t.Setenv("SHIPYARD_TEST_ORIGIN", "https://app.example.com")
+	cfg := &config.Config{
+		Nginx: config.NginxConfig{TemplateEnv: []string{"SHIPYARD_TEST_ORIGIN"}},
+		Site: map[string]config.SiteConfig{
+			"my-app.example.com": {
+				FrontendRoot: "/var/www/my-app",
+				Aliases:      []string{"www.my-app.example.com", "my-app.example.net"},
+				SSLEnabled:   true,
+				Backend:      &config.BackendConfig{JailIP: "127.0.1.5", ListenPort: 8080},
+			},
+		},
+	}
+
+	tmpl := `upstream <% upstreamName .Domain %> { server <% .JailIP %>:<% .ListenPort %>; }
+server_name <% .Domain %> <% .Aliases | join " " %>;
+location <% default "/api" .ProxyPath %>/ {}
+set $site <% normalizeDomain .Domain %>;
+add_header Access-Control-Allow-Origin "<% env "SHIPYARD_TEST_ORIGIN" %>";
+ssl_certificate <% .SSLCert %>;`
+
+	result, err := RenderUserConfig(tmpl, "my-app.example.com", cfg)
+	if err != nil {
+		t.Fatalf("RenderUserConfig() error = %v", err)
+	}
+
+	for _, want := range []string{
+		"upstream shipyard_my_app_example_com { server 127.0.1.5:8080; }",
+		"server_name my-app.example.com www.my-app.example.com my-app.example.net;",
+		"location /api/ {}",
+		"set $site my_app_example_com;",
+		`add_header Access-Control-Allow-Origin "https://app.example.com";`,
+		"ssl_certificate /usr/local/etc/letsencrypt/live/my-app.example.com/fullchain.pem;",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("RenderUserConfig() missing %q in:\n%s", want, result)
+		}
+	}
+}
+
+func TestRenderUserConfig_EnvNotAllowed(t *testing.T) {
+	t.Setenv("SHIPYARD_TEST_SECRET", "hunter2")
+	cfg := &config.Config{
+		Site: map[string]config.SiteConfig{"example.com": {FrontendRoot: "/var/www/example.com"}},
+	}
+
+	if _, err := RenderUserConfig(`<% env "SHIPYARD_TEST_SECRET" %>`, "example.com", cfg); err == nil {
+		t.Error("RenderUserConfig() should refuse env vars not in nginx.template_env")
+	}
+}
+
+func TestRenderUserConfig_OverrideExampleRenders(t *testing.T) {
+	cfg := &config.Config{
+		Site: map[string]config.SiteConfig{"example.com": {FrontendRoot: "/var/www/example.com"}},
+	}
+	if _, err := RenderUserConfig(GetOverrideExample(), "example.com", cfg); err != nil {
+		t.Errorf("the override example should deploy as-is: %v", err)
+	}
+}
+
+func TestGenerateSiteCombinedConfig_WebSocket(t *testing.T) {
+	plain := GenerateSiteCombinedConfig("app.example.com", config.SiteConfig{FrontendRoot: "/var/www/app", Backend: &config.BackendConfig{ListenPort: 8080}}, &config.Config{})
+	if strings.Contains(plain, "Upgrade") {
+		t.Error("backends without websocket should not forward upgrades")
+	}
+	if !strings.Contains(plain, "        proxy_pass http://127.0.0.1:8080;\n") {
+		t.Errorf("proxy_pass missing or misindented:\n%s", plain)
+	}
+
+	ws := GenerateBackendProxyConfig("app.example.com", config.SiteConfig{Backend: &config.BackendConfig{ListenPort: 8080, WebSocket: true, WebSocketTimeout: 600}})
+	for _, want := range []string{
+		"proxy_set_header Upgrade $http_upgrade;",
+		"proxy_set_header Connection $connection_upgrade;",
+		"proxy_read_timeout 600s;",
+		"proxy_send_timeout 600s;",
+	} {
+		if !strings.Contains(ws, want) {
+			t.Errorf("websocket config missing %q", want)
+		}
+	}
+
+	if !strings.Contains(GenerateOverrideConf(&config.Config{}), "map $http_upgrade $connection_upgrade {") {
+		t.Error("override.conf should define $connection_upgrade")
+	}
+}
+
+func TestGenerateBackendProxyConfig_Protocols(t *testing.T) {
+	tests := []struct {
+		protocol string
+		want     []string
+	}{
+		{config.ProtocolGRPC, []string{"http2 on;", "grpc_pass grpc://127.0.0.1:9000;", "grpc_set_header X-Real-IP $remote_addr;"}},
+		{config.ProtocolFastCGI, []string{"include fastcgi_params;", "fastcgi_pass 127.0.0.1:9000;", "fastcgi_param SCRIPT_FILENAME /srv/app$fastcgi_script_name;"}},
+		{config.ProtocolUWSGI, []string{"include uwsgi_params;", "uwsgi_pass 127.0.0.1:9000;"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			backend := config.BackendConfig{ListenPort: 9000, Protocol: tt.protocol, ScriptRoot: "/srv/app"}
+			result := GenerateBackendProxyConfig("api.example.com", config.SiteConfig{Backend: &backend})
+			for _, want := range tt.want {
+				if !strings.Contains(result, want) {
+					t.Errorf("config missing %q:\n%s", want, result)
+				}
+			}
+			if strings.Contains(result, "proxy_pass") {
+				t.Errorf("%s config should not use proxy_pass", tt.protocol)
+			}
+		})
+	}
+
+	if strings.Contains(GenerateBackendProxyConfig("api.example.com", config.SiteConfig{Backend: &config.BackendConfig{ListenPort: 8080}}), "http2") {
+		t.Error("http backends should not enable http2")
+	}
+}
+
+func TestGenerateBackendProxyConfig_RestartResponse(t *testing.T) {
+	flag := "if (-f " + MaintenanceDir + "/api_example_com) {"
+
+	backend := config.BackendConfig{ListenPort: 8080}
+	if result := GenerateBackendProxyConfig("api.example.com", config.SiteConfig{Backend: &backend}); strings.Contains(result, "return 503") {
+		t.Errorf("proxy restart response should not answer 503:\n%s", result)
+	}
+
+	backend.RestartResponse = config.RestartResponse503
+	result := GenerateBackendProxyConfig("api.example.com", config.SiteConfig{Backend: &backend})
+	for _, want := range []string{flag, "add_header Retry-After 5 always;", "return 503;"} {
+		if !strings.Contains(result, want) {
+			t.Errorf("config missing %q:\n%s", want, result)
+		}
+	}
+	if strings.Index(result, "return 503;") > strings.Index(result, "proxy_pass") {
+		t.Errorf("the 503 must come before proxy_pass:\n%s", result)
+	}
+
+	cfg := &config.Config{Site: map[string]config.SiteConfig{"api.example.com": {Backend: &backend}}}
+	out, err := RenderUserConfig("location / {\n<% maintenance .Domain %>\n}", "api.example.com", cfg)
+	if err != nil {
+		t.Fatalf("RenderUserConfig: %v", err)
+	}
+	if !strings.Contains(out, flag) {
+		t.Errorf("maintenance func missing the flag check:\n%s", out)
+	}
+}
+
+func TestGenerateConfig_Mirror(t *testing.T) {
+	backend := config.BackendConfig{ListenPort: 8080, Mirror: &config.MirrorConfig{Target: "127.0.0.1:9090", Percent: 25}}
+
+	plain := GenerateBackendProxyConfig("api.example.com", config.SiteConfig{Backend: &config.BackendConfig{ListenPort: 8080}})
+	if strings.Contains(plain, "mirror") {
+		t.Errorf("backends without a mirror should not mirror:\n%s", plain)
+	}
+
+	result := GenerateBackendProxyConfig("api.example.com", config.SiteConfig{Backend: &backend})
+	for _, want := range []string{
+		"        mirror /_shipyard_mirror;\n",
+		"    location = /_shipyard_mirror {\n        internal;\n",
+		"if ($mirror_sample_api_example_com = \"\") {",
+		"proxy_pass http://127.0.0.1:9090$request_uri;",
+		"proxy_set_header X-Shipyard-Mirror 1;",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("backend config missing %q:\n%s", want, result)
+		}
+	}
+
+	site := config.SiteConfig{FrontendRoot: "/var/www/app", Backend: &backend}
+	combined := GenerateSiteCombinedConfig("app.example.com", site, &config.Config{})
+	if !strings.Contains(combined, "proxy_pass http://127.0.0.1:9090$mirror_uri_app_example_com;") {
+		t.Errorf("combined config should mirror to the stripped URI:\n%s", combined)
+	}
+
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"app.example.com": site,
+		"api.example.com": {Backend: &backend},
+	}}
+	override := GenerateOverrideConf(cfg)
+	for _, want := range []string{
+		"split_clients \"${request_id}\" $mirror_sample_app_example_com {\n    25%  1;\n    *    \"\";\n}",
+		"map $request_uri $mirror_uri_app_example_com {",
+		`"~^/api(/.*)$"  $1;`,
+		"$mirror_sample_api_example_com {",
+	} {
+		if !strings.Contains(override, want) {
+			t.Errorf("override.conf missing %q:\n%s", want, override)
+		}
+	}
+	if strings.Contains(override, "$mirror_uri_api_example_com") {
+		t.Error("backend-only sites don't strip a proxy path, so need no mirror URI map")
+	}
+
+	out, err := RenderUserConfig("location / {\n<% mirror .Domain %>\n}\n<% mirrorLocation .Domain %>", "api.example.com", cfg)
+	if err != nil {
+		t.Fatalf("RenderUserConfig: %v", err)
+	}
+	if !strings.Contains(out, "mirror /_shipyard_mirror;") || !strings.Contains(out, "location = /_shipyard_mirror {") {
+		t.Errorf("mirror funcs missing their directives:\n%s", out)
+	}
+}
+
+func TestGenerateConfig_Geo(t *testing.T) {
+	geo := &config.GeoConfig{Block: []string{"KP", "RU"}, Redirect: map[string]string{"DE": "https://de.example.com", "RU": "https://ru.example.com"}}
+	cfg := &config.Config{
+		Nginx: config.NginxConfig{GeoIPDatabase: "/usr/local/share/GeoIP/GeoLite2-Country.mmdb", GeoIPModule: "/usr/local/libexec/nginx/ngx_http_geoip2_module.so"},
+		Site: map[string]config.SiteConfig{
+			"geo.example.com":   {FrontendRoot: "/var/www/geo", Geo: geo, OverrideIPs: []string{"10.0.0.0/8", "AU", "AU"}},
+			"allow.example.com": {FrontendRoot: "/var/www/allow", Geo: &config.GeoConfig{Allow: []string{"AU", "--"}}},
+			"plain.example.com": {FrontendRoot: "/var/www/plain", OverrideIPs: []string{"10.0.0.1"}},
+		},
+	}
+
+	override := GenerateOverrideConf(cfg)
+	for _, want := range []string{
+		"geoip2 /usr/local/share/GeoIP/GeoLite2-Country.mmdb {\n    $geoip2_country_code default=-- country iso_code;\n}",
+		"map $geoip2_country_code $geo_country_action_geo_example_com {\n    default  \"\";\n    DE  \"https://de.example.com\";\n    KP  block;\n    RU  \"https://ru.example.com\";\n}",
+		"map $geoip2_country_code $geo_country_action_allow_example_com {\n    default  block;\n    --  \"\";\n    AU  \"\";\n}",
+		"map $uri $geo_action_geo_example_com {\n    default  $geo_country_action_geo_example_com;\n    ~^/\\.well-known/acme-challenge/  \"\";\n}",
+		"map $host $geo_action_plain_example_com {\n    default  \"\";\n}",
+		"geo $override_ip_allowed_geo_example_com {\n    default         0;\n    10.0.0.0/8 1;\n}",
+		"map $geoip2_country_code $override_country_allowed_geo_example_com {\n    default         0;\n    AU 1;\n}",
+		"map \"$override_ip_allowed_geo_example_com$override_country_allowed_geo_example_com\" $override_allowed_geo_example_com {",
+		"geo $override_allowed_plain_example_com {",
+	} {
+		if !strings.Contains(override, want) {
+			t.Errorf("override.conf missing %q:\n%s", want, override)
+		}
+	}
+
+	if main := GenerateMainConf(cfg); !strings.HasPrefix(main, "# MANAGED BY SHIPYARD — DO NOT EDIT\nload_module /usr/local/libexec/nginx/ngx_http_geoip2_module.so;\n") {
+		t.Errorf("nginx.conf should load the geoip2 module first:\n%s", main)
+	}
+
+	site := cfg.Site["geo.example.com"]
+	site.Backend = &config.BackendConfig{ListenPort: 8080}
+	for name, result := range map[string]string{
+		"combined": GenerateSiteCombinedConfig("geo.example.com", site, cfg),
+		"backend":  GenerateBackendProxyConfig("geo.example.com", config.SiteConfig{Backend: site.Backend, Geo: geo}),
+		"wildcard": GenerateWildcardConfig("*.geo.example.com", config.SiteConfig{FrontendRoot: "/var/www/geo", Geo: geo}, cfg),
+	} {
+		for _, want := range []string{"if ($geo_action_", "    return 302 $geo_action_"} {
+			if !strings.Contains(result, want) {
+				t.Errorf("%s config missing %q:\n%s", name, want, result)
+			}
+		}
+	}
+	if result := GenerateSiteCombinedConfig("plain.example.com", config.SiteConfig{FrontendRoot: "/var/www/plain", Backend: &config.BackendConfig{ListenPort: 8080}}, cfg); strings.Contains(result, "geo_action") {
+		t.Errorf("sites without geo rules should not check them:\n%s", result)
+	}
+
+	if strings.Contains(GenerateOverrideConf(&config.Config{Site: map[string]config.SiteConfig{"a.com": {}}}), "geoip2") {
+		t.Error("override.conf should not look up countries without a database")
+	}
+}
@@ -0,0 +1,124 @@
+package nginx
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// geoipBlock returns the override.conf block that looks up the client's
+// country in nginx.geoip_database, or "" when none is set
+func geoipBlock(cfg *config.Config) string {
+	if cfg.Nginx.GeoIPDatabase == "" {
+		return ""
+	}
+	return fmt.Sprintf(`# --- GeoIP: client country ($geoip2_country_code; "--" when unknown) ---
+geoip2 %s {
+    $geoip2_country_code default=-- country iso_code;
+}
+
+`, cfg.Nginx.GeoIPDatabase)
+}
+
+// geoipModule returns the load_module line for nginx.geoip_module, or "" if
+// it is not set
+func geoipModule(cfg *config.Config) string {
+	if cfg.Nginx.GeoIPModule == "" {
+		return ""
+	}
+	return fmt.Sprintf("load_module %s;\n", cfg.Nginx.GeoIPModule)
+}
+
+// geoActionMaps returns the override.conf maps setting $geo_action_<site>:
+// "" to serve the request, "block" to refuse it, or a URL to redirect to.
+// Every site gets one so site configs never refer to a missing variable.
+// ACME challenges are always served.
+func geoActionMaps(cfg *config.Config, domain string, site config.SiteConfig) string {
+	normalized := NormalizeDomainName(domain)
+	if site.Geo == nil || cfg.Nginx.GeoIPDatabase == "" {
+		return fmt.Sprintf("map $host $geo_action_%s {\n    default  \"\";\n}\n\n", normalized)
+	}
+	geo := site.Geo
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# site: %s\n", domain))
+	// Redirects win over Block and Allow; nginx refuses a map with a key twice
+	def := `""`
+	actions := make(map[string]string)
+	if len(geo.Allow) > 0 {
+		def = "block"
+		for _, country := range geo.Allow {
+			actions[country] = `""`
+		}
+	}
+	for _, country := range geo.Block {
+		actions[country] = "block"
+	}
+	for country, target := range geo.Redirect {
+		actions[country] = fmt.Sprintf("%q", target)
+	}
+	countries := make([]string, 0, len(actions))
+	for country := range actions {
+		countries = append(countries, country)
+	}
+	slices.Sort(countries)
+
+	sb.WriteString(fmt.Sprintf("map $geoip2_country_code $geo_country_action_%s {\n", normalized))
+	sb.WriteString(fmt.Sprintf("    default  %s;\n", def))
+	for _, country := range countries {
+		sb.WriteString(fmt.Sprintf("    %s  %s;\n", country, actions[country]))
+	}
+	sb.WriteString("}\n\n")
+
+	sb.WriteString(fmt.Sprintf("map $uri $geo_action_%s {\n", normalized))
+	sb.WriteString(fmt.Sprintf("    default  $geo_country_action_%s;\n", normalized))
+	sb.WriteString("    ~^/\\.well-known/acme-challenge/  \"\";\n")
+	sb.WriteString("}\n\n")
+	return sb.String()
+}
+
+// GeoDirectives returns the server-level directives that apply a site's geo
+// rules. They are empty unless the site has any.
+func GeoDirectives(domain string, site config.SiteConfig) []string {
+	if site.Geo == nil {
+		return nil
+	}
+	action := "$geo_action_" + NormalizeDomainName(domain)
+	return []string{
+		"# Country rules ([site.<name>.geo])",
+		fmt.Sprintf("if (%s = block) {", action),
+		"    return 403;",
+		"}",
+		fmt.Sprintf("if (%s) {", action),
+		fmt.Sprintf("    return 302 %s$request_uri;", action),
+		"}",
+		"",
+	}
+}
+
+// geoBlock returns GeoDirectives for a site of cfg as one string, for user
+// templates
+func geoBlock(cfg *config.Config, domain string) string {
+	site, ok := cfg.Site[domain]
+	if !ok {
+		return ""
+	}
+	return strings.TrimRight(strings.Join(GeoDirectives(domain, site), "\n"), "\n")
+}
+
+// overrideCountries splits override_ips into addresses and (sorted, unique)
+// country codes. Country codes are dropped when there is no GeoIP database.
+func overrideCountries(cfg *config.Config, entries []string) (ips, countries []string) {
+	for _, entry := range entries {
+		switch {
+		case !config.IsCountryCode(entry):
+			ips = append(ips, entry)
+		case cfg.Nginx.GeoIPDatabase != "":
+			countries = append(countries, entry)
+		}
+	}
+	slices.Sort(countries)
+	return ips, slices.Compact(countries)
+}
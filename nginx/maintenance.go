@@ -0,0 +1,80 @@
+package nginx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// MaintenanceDir holds a flag file for each site whose backend is being
+// restarted by a deploy. It is under /var/run so a flag left behind by a
+// crash is gone after a reboot, and nginx's workers can see it.
+const MaintenanceDir = "/var/run/shipyard-maintenance"
+
+// maintenanceRetryAfter is the Retry-After (seconds) sent with the 503
+const maintenanceRetryAfter = 5
+
+// MaintenanceFlag returns the file whose presence makes a site's proxy
+// location answer 503
+func MaintenanceFlag(domain string) string {
+	return filepath.Join(MaintenanceDir, NormalizeDomainName(domain))
+}
+
+// MaintenanceDirectives returns the directives, placed before the proxy
+// directives of a backend's location, that answer 503 while the site's
+// maintenance flag exists. They are empty unless its restart_response is 503.
+// headers are the server block's add_header directives; the if block sets
+// Retry-After, so nginx would drop them from the 503 unless they are repeated.
+func MaintenanceDirectives(domain string, backend config.BackendConfig, headers []string) []string {
+	if !backend.UnavailableDuringRestart() {
+		return nil
+	}
+	lines := []string{
+		"# The backend is restarting",
+		fmt.Sprintf("if (-f %s) {", MaintenanceFlag(domain)),
+		fmt.Sprintf("    add_header Retry-After %d always;", maintenanceRetryAfter),
+	}
+	for _, header := range headers {
+		lines = append(lines, "    "+header)
+	}
+	return append(lines, "    return 503;", "}", "")
+}
+
+// proxyLocation returns the directives of a backend's proxy location, in a
+// server block with the given add_header directives
+func proxyLocation(domain string, backend config.BackendConfig, headers []string) []string {
+	lines := append(MaintenanceDirectives(domain, backend, headers), ProxyDirectives(backend)...)
+	return append(lines, MirrorDirectives(backend)...)
+}
+
+// maintenanceBlock returns MaintenanceDirectives for a site of cfg as one
+// string, for user templates
+func maintenanceBlock(cfg *config.Config, domain string) string {
+	site, ok := cfg.Site[domain]
+	if !ok || site.Backend == nil {
+		return ""
+	}
+	return strings.TrimRight(strings.Join(MaintenanceDirectives(domain, *site.Backend, nil), "\n"), "\n")
+}
+
+// SetMaintenance creates or removes a site's maintenance flag. nginx checks
+// for it on every request, so no reload is needed.
+func SetMaintenance(domain string, on bool) error {
+	flag := MaintenanceFlag(domain)
+	if !on {
+		if err := os.Remove(flag); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove maintenance flag: %w", err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(MaintenanceDir, 0755); err != nil {
+		return fmt.Errorf("create maintenance directory: %w", err)
+	}
+	if err := os.WriteFile(flag, nil, 0644); err != nil {
+		return fmt.Errorf("write maintenance flag: %w", err)
+	}
+	return nil
+}
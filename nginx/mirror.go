@@ -0,0 +1,122 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// MirrorLocation is the internal location that sends a mirrored request's
+// copy to the backend's mirror target
+const MirrorLocation = "/_shipyard_mirror"
+
+// mirrorSampleVar returns the variable, set in override.conf, that is
+// non-empty for the share of requests a site mirrors
+func mirrorSampleVar(domain string) string {
+	return "$mirror_sample_" + NormalizeDomainName(domain)
+}
+
+// mirrorURIVar returns the variable, set in override.conf, holding the URI a
+// combined site's mirrored request is sent to (without its proxy_path)
+func mirrorURIVar(domain string) string {
+	return "$mirror_uri_" + NormalizeDomainName(domain)
+}
+
+// mirrorMaps returns the override.conf blocks for a site whose backend is
+// mirrored: the sample split and, for combined sites, the URI with the proxy
+// path stripped the way their proxy location strips it
+func mirrorMaps(domain string, site config.SiteConfig) string {
+	mirror := site.Backend.Mirror
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# site: %s — %d%% to %s\n", domain, mirror.Percent, mirror.Target))
+	sb.WriteString(fmt.Sprintf("split_clients \"${request_id}\" %s {\n", mirrorSampleVar(domain)))
+	if mirror.Percent >= 100 {
+		sb.WriteString("    *    1;\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("    %d%%  1;\n", mirror.Percent))
+		sb.WriteString("    *    \"\";\n")
+	}
+	sb.WriteString("}\n\n")
+
+	if !site.IsBackendOnly() {
+		proxyPath := site.Backend.ProxyPath
+		if proxyPath == "" {
+			proxyPath = "/api"
+		}
+		sb.WriteString(fmt.Sprintf("map $request_uri %s {\n", mirrorURIVar(domain)))
+		sb.WriteString("    default  $request_uri;\n")
+		sb.WriteString(fmt.Sprintf("    \"~^%s(/.*)$\"  $1;\n", regexp.QuoteMeta(proxyPath)))
+		sb.WriteString("}\n\n")
+	}
+	return sb.String()
+}
+
+// MirrorDirectives returns the directives, placed in a backend's proxy
+// location, that copy its requests to the mirror location. They are empty
+// unless the backend has a mirror.
+func MirrorDirectives(backend config.BackendConfig) []string {
+	if !backend.Mirrored() {
+		return nil
+	}
+	return []string{
+		"",
+		"# Copy requests to the mirror target; its responses are discarded",
+		fmt.Sprintf("mirror %s;", MirrorLocation),
+		"mirror_request_body on;",
+	}
+}
+
+// MirrorLocationBlock returns the internal location, placed in the site's
+// server block, that forwards the sampled share of mirrored requests to the
+// mirror target. combined is set for a site whose proxy location strips the
+// proxy path. It is empty unless the backend has a mirror.
+func MirrorLocationBlock(domain string, backend config.BackendConfig, combined bool) []string {
+	if !backend.Mirrored() {
+		return nil
+	}
+	uri := "$request_uri"
+	if combined {
+		uri = mirrorURIVar(domain)
+	}
+	return []string{
+		"# Mirrored copies of backend requests",
+		fmt.Sprintf("location = %s {", MirrorLocation),
+		"    internal;",
+		fmt.Sprintf("    if (%s = \"\") {", mirrorSampleVar(domain)),
+		"        return 204;",
+		"    }",
+		fmt.Sprintf("    proxy_pass http://%s%s;", backend.Mirror.Target, uri),
+		"    proxy_http_version 1.1;",
+		"    proxy_set_header X-Real-IP $remote_addr;",
+		"    proxy_set_header Host $host;",
+		"    proxy_set_header X-Forwarded-Proto $scheme;",
+		"    proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;",
+		"    proxy_set_header X-Request-Id $" + RequestIDVar + ";",
+		"    proxy_set_header X-Shipyard-Mirror 1;",
+		"    proxy_connect_timeout 1s;",
+		"    proxy_read_timeout 10s;",
+		"}",
+	}
+}
+
+// mirrorBlock returns MirrorDirectives for a site of cfg as one string, for
+// user templates
+func mirrorBlock(cfg *config.Config, domain string) string {
+	site, ok := cfg.Site[domain]
+	if !ok || site.Backend == nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.Join(MirrorDirectives(*site.Backend), "\n"))
+}
+
+// mirrorLocationBlock returns MirrorLocationBlock for a site of cfg as one
+// string, for user templates
+func mirrorLocationBlock(cfg *config.Config, domain string) string {
+	site, ok := cfg.Site[domain]
+	if !ok || site.Backend == nil {
+		return ""
+	}
+	return strings.Join(MirrorLocationBlock(domain, *site.Backend, !site.IsBackendOnly()), "\n")
+}
@@ -0,0 +1,565 @@
+package nginx
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/ssl"
+)
+
+// deniedDirectives are directives a site key may never use: they load code,
+// read or write files outside the site, or change how nginx itself runs
+var deniedDirectives = []string{
+	"load_module",
+	"include",
+	"env",
+	"user",
+	"daemon",
+	"master_process",
+	"working_directory",
+	"access_log",
+	"error_log",
+	"*_temp_path",
+	"*_cache_path",
+	"proxy_store",
+	"proxy_store_access",
+	"perl*",
+	"js_*",
+	"*lua*",
+	"ssl_password_file",
+	"auth_basic_user_file",
+	"ssl_trusted_certificate",
+	"ssl_client_certificate",
+	"ssl_dhparam",
+}
+
+// proxyDirectives forward requests; their targets must belong to the site
+var proxyDirectives = map[string]bool{
+	"proxy_pass":     true,
+	"fastcgi_pass":   true,
+	"uwsgi_pass":     true,
+	"scgi_pass":      true,
+	"grpc_pass":      true,
+	"memcached_pass": true,
+}
+
+// variableSetters assign the variable named by the argument at the given
+// index (-1 for the last)
+var variableSetters = map[string]int{
+	"set":                0,
+	"map":                1,
+	"geo":                -1,
+	"split_clients":      1,
+	"perl_set":           0,
+	"js_set":             0,
+	"js_var":             0,
+	"auth_request_set":   0,
+	"auth_jwt_claim_set": 0,
+}
+
+// rootVariableName is the one variable root and alias may use. Shipyard
+// sets it; a site config must not.
+const rootVariableName = "frontend_version"
+
+// rootVariableCapture matches a regex named capture that sets $frontend_version
+var rootVariableCapture = regexp.MustCompile(`\(\?(P?<` + rootVariableName + `>|'` + rootVariableName + `')`)
+
+// Directive is one statement in an nginx config
+type Directive struct {
+	Name  string
+	Args  []string
+	Line  int
+	Block []*Directive // nil unless the directive opens a { } block
+
+	start, end int // byte range in the source, including the trailing ; or }
+}
+
+// ParseDirectives parses an nginx config into its directive tree.
+// It understands quoting and comments but not the meaning of any directive.
+func ParseDirectives(conf string) ([]*Directive, error) {
+	p := &directiveParser{src: conf, line: 1}
+	dirs, err := p.parseBlock(false)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.line, err)
+	}
+	return dirs, nil
+}
+
+type directiveParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// parseBlock reads directives until } (if nested) or end of input
+func (p *directiveParser) parseBlock(nested bool) ([]*Directive, error) {
+	dirs := []*Directive{}
+	var cur *Directive
+
+	for {
+		tok, start, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case "":
+			if cur != nil {
+				return nil, fmt.Errorf("directive %q is missing ;", cur.Name)
+			}
+			if nested {
+				return nil, fmt.Errorf("unexpected end of config, missing }")
+			}
+			return dirs, nil
+		case ";":
+			if cur == nil {
+				return nil, fmt.Errorf("unexpected ;")
+			}
+			cur.end = p.pos
+			dirs = append(dirs, cur)
+			cur = nil
+		case "{":
+			if cur == nil {
+				return nil, fmt.Errorf("unexpected {")
+			}
+			// *_by_lua_block bodies are Lua, not directives
+			if strings.HasSuffix(cur.Name, "_lua_block") {
+				if err := p.skipRawBlock(); err != nil {
+					return nil, err
+				}
+				cur.Block = []*Directive{}
+				cur.end = p.pos
+				dirs = append(dirs, cur)
+				cur = nil
+				continue
+			}
+			block, err := p.parseBlock(true)
+			if err != nil {
+				return nil, err
+			}
+			cur.Block = block
+			cur.end = p.pos
+			dirs = append(dirs, cur)
+			cur = nil
+		case "}":
+			if cur != nil {
+				return nil, fmt.Errorf("directive %q is missing ;", cur.Name)
+			}
+			if !nested {
+				return nil, fmt.Errorf("unexpected }")
+			}
+			return dirs, nil
+		default:
+			if cur == nil {
+				cur = &Directive{Name: unquote(tok), Line: p.line, start: start}
+			} else {
+				cur.Args = append(cur.Args, unquote(tok))
+			}
+		}
+	}
+}
+
+// next returns the next token and its offset: a word, ";", "{", "}" or "" at the end
+func (p *directiveParser) next() (string, int, error) {
+	// Skip whitespace and comments
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			break
+		}
+		if c == '\n' {
+			p.line++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return "", p.pos, nil
+	}
+
+	start := p.pos
+	switch c := p.src[p.pos]; c {
+	case ';', '{', '}':
+		p.pos++
+		return string(c), start, nil
+	case '"', '\'':
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != c {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+			if p.pos < len(p.src) && p.src[p.pos] == '\n' {
+				p.line++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			return "", start, fmt.Errorf("unterminated quoted string")
+		}
+		p.pos++
+		return p.src[start:p.pos], start, nil
+	}
+
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ';' || c == '{' || c == '}' {
+			break
+		}
+		// ${var} is part of the word, not a block
+		if c == '$' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '{' {
+			if end := strings.IndexByte(p.src[p.pos:], '}'); end > 0 {
+				p.pos += end + 1
+				continue
+			}
+		}
+		if c == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos], start, nil
+}
+
+// skipRawBlock skips to the } that closes the current block without parsing it
+func (p *directiveParser) skipRawBlock() error {
+	depth := 1
+	for ; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '\n':
+			p.line++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unexpected end of config, missing }")
+}
+
+// unquote strips matching quotes from a token
+func unquote(tok string) string {
+	if len(tok) >= 2 && (tok[0] == '"' || tok[0] == '\'') && tok[len(tok)-1] == tok[0] {
+		return tok[1 : len(tok)-1]
+	}
+	return tok
+}
+
+// Violation is a directive a policy does not allow
+type Violation struct {
+	Directive string `json:"directive"`
+	Line      int    `json:"line"`
+	Reason    string `json:"reason"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("line %d: %s: %s", v.Line, v.Directive, v.Reason)
+}
+
+// PolicyError is returned when a config breaks the directive policy in reject mode
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+	return "directives not allowed: " + strings.Join(msgs, "; ")
+}
+
+// Policy decides which directives a site's nginx config may contain
+type Policy struct {
+	// Deny lists directive name globs that are never allowed
+	Deny []string
+	// Roots lists the directories root and alias may point into
+	Roots []string
+	// ProxyHosts lists host or host:port globs proxy_pass and friends may target,
+	// in addition to upstream blocks defined in the config itself
+	ProxyHosts []string
+	// CertFiles lists the files ssl_certificate and ssl_certificate_key may name
+	CertFiles []string
+	// ServerNames lists the names server_name may use: the site's domain and
+	// aliases
+	ServerNames []string
+}
+
+// NewPolicy returns the policy for a site's configs deployed with a site key
+func NewPolicy(cfg *config.Config, siteName string) *Policy {
+	pc := cfg.Nginx.Policy
+	p := &Policy{Roots: []string{AcmeWebroot}}
+
+	for _, name := range append(append([]string{}, deniedDirectives...), pc.Deny...) {
+		if !slices.Contains(pc.Allow, name) {
+			p.Deny = append(p.Deny, name)
+		}
+	}
+	p.ProxyHosts = append(p.ProxyHosts, pc.ProxyHosts...)
+
+	p.ServerNames = []string{strings.ToLower(siteName)}
+	if strings.HasPrefix(siteName, "*.") {
+		p.ServerNames = append(p.ServerNames, WildcardServerName(strings.ToLower(siteName)))
+	}
+
+	if site, ok := cfg.Site[siteName]; ok {
+		for _, alias := range site.Aliases {
+			p.ServerNames = append(p.ServerNames, strings.ToLower(alias))
+		}
+		if site.FrontendRoot != "" {
+			p.Roots = append(p.Roots, site.FrontendRoot)
+		}
+		if site.SSLEnabled {
+			certPath, keyPath := ssl.CertPaths(siteName)
+			p.CertFiles = append(p.CertFiles, certPath, keyPath)
+		}
+		if site.CSPReports() {
+			p.ProxyHosts = append(p.ProxyHosts, APIUpstream)
+		}
+		if site.Backend != nil {
+			if site.Backend.JailIP != "" {
+				p.ProxyHosts = append(p.ProxyHosts, site.Backend.JailIP, site.Backend.JailIP+":*")
+			}
+			if site.Backend.ListenPort != 0 {
+				port := strconv.Itoa(site.Backend.ListenPort)
+				p.ProxyHosts = append(p.ProxyHosts, "127.0.0.1:"+port, "localhost:"+port, `\[::1\]:`+port)
+			}
+		}
+	}
+	return p
+}
+
+// Check returns every directive in conf the policy does not allow
+func (p *Policy) Check(conf string) ([]Violation, error) {
+	dirs, err := ParseDirectives(conf)
+	if err != nil {
+		return nil, err
+	}
+	var violations []Violation
+	p.walk(dirs, upstreamNames(dirs), "", func(d *Directive, v Violation) {
+		violations = append(violations, v)
+	})
+	return violations, nil
+}
+
+// Strip removes every directive the policy does not allow (with its block,
+// if it has one) and returns the remaining config and what was removed
+func (p *Policy) Strip(conf string) (string, []Violation, error) {
+	dirs, err := ParseDirectives(conf)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var violations []Violation
+	var removed []*Directive
+	p.walk(dirs, upstreamNames(dirs), "", func(d *Directive, v Violation) {
+		violations = append(violations, v)
+		// A directive inside an already removed block needs no separate cut
+		if len(removed) == 0 || d.start >= removed[len(removed)-1].end {
+			removed = append(removed, d)
+		}
+	})
+
+	var b strings.Builder
+	last := 0
+	for _, d := range removed {
+		b.WriteString(conf[last:d.start])
+		fmt.Fprintf(&b, "# removed by shipyard: %s", d.Name)
+		last = d.end
+	}
+	b.WriteString(conf[last:])
+	return b.String(), violations, nil
+}
+
+// walk calls deny for each disallowed directive, in source order
+func (p *Policy) walk(dirs []*Directive, upstreams map[string]bool, parent string, deny func(*Directive, Violation)) {
+	for _, d := range dirs {
+		if reason := p.check(d, upstreams, parent); reason != "" {
+			deny(d, Violation{Directive: d.Name, Line: d.Line, Reason: reason})
+		}
+		if d.Block != nil {
+			p.walk(d.Block, upstreams, d.Name, deny)
+		}
+	}
+}
+
+// check returns why d is not allowed, or "" if it is
+func (p *Policy) check(d *Directive, upstreams map[string]bool, parent string) string {
+	for _, pattern := range p.Deny {
+		if ok, _ := path.Match(pattern, d.Name); ok {
+			return "directive is not allowed for site keys"
+		}
+	}
+
+	if reason := checkAssignsRootVariable(d); reason != "" {
+		return reason
+	}
+
+	switch {
+	case d.Name == "root" || d.Name == "alias":
+		if len(d.Args) == 0 {
+			return ""
+		}
+		return p.checkRoot(d.Args[0])
+	case d.Name == "server_name":
+		for _, name := range d.Args {
+			if !slices.Contains(p.ServerNames, strings.ToLower(name)) {
+				return fmt.Sprintf("name %q is not the site's domain or one of its aliases", name)
+			}
+		}
+		return ""
+	case d.Name == "listen":
+		for _, arg := range d.Args {
+			if arg == "default_server" || arg == "default" {
+				return "a site may not be the default server"
+			}
+		}
+		return ""
+	case d.Name == "ssl_certificate" || d.Name == "ssl_certificate_key":
+		if len(d.Args) == 0 || slices.Contains(p.CertFiles, d.Args[0]) {
+			return ""
+		}
+		return fmt.Sprintf("file %q is not the site's certificate", d.Args[0])
+	case proxyDirectives[d.Name]:
+		if len(d.Args) == 0 {
+			return ""
+		}
+		return p.checkProxyTarget(d.Args[0], upstreams)
+	case d.Name == "server" && parent == "upstream":
+		if len(d.Args) == 0 {
+			return ""
+		}
+		return p.checkProxyTarget(d.Args[0], nil)
+	}
+	return ""
+}
+
+// rootVariable matches a variable in a root or alias path
+var rootVariable = regexp.MustCompile(`\$(\{[^}]*\}?|[A-Za-z0-9_]*)`)
+
+// checkRoot requires a root or alias path to stay within the site's directories.
+// The only variable allowed is $frontend_version after a fixed prefix, e.g.
+// /var/www/site/$frontend_version; any other could be set by the client to ../
+func (p *Policy) checkRoot(dir string) string {
+	for _, v := range rootVariable.FindAllString(dir, -1) {
+		if v != "$"+rootVariableName && v != "${"+rootVariableName+"}" {
+			return fmt.Sprintf("path %q may only use the variable $frontend_version", dir)
+		}
+	}
+	prefix := dir
+	if i := strings.IndexByte(dir, '$'); i >= 0 {
+		prefix = dir[:i]
+	}
+	if !filepath.IsAbs(prefix) {
+		return fmt.Sprintf("path %q must be absolute", dir)
+	}
+	for _, seg := range strings.Split(dir, "/") {
+		if seg == ".." {
+			return fmt.Sprintf("path %q must not contain ..", dir)
+		}
+	}
+	for _, root := range p.Roots {
+		root = strings.TrimSuffix(root, "/")
+		if prefix == root || strings.HasPrefix(prefix, root+"/") {
+			return ""
+		}
+	}
+	return fmt.Sprintf("path %q is outside the site's directories", dir)
+}
+
+// checkAssignsRootVariable refuses a directive that sets $frontend_version,
+// which could then point root outside the site
+func checkAssignsRootVariable(d *Directive) string {
+	if i, ok := variableSetters[d.Name]; ok && len(d.Args) > 0 {
+		if i < 0 || i >= len(d.Args) {
+			i = len(d.Args) - 1
+		}
+		if name := strings.Trim(d.Args[i], "${}"); name == rootVariableName {
+			return "$" + rootVariableName + " is set by shipyard"
+		}
+	}
+	for _, arg := range d.Args {
+		if rootVariableCapture.MatchString(arg) {
+			return "$" + rootVariableName + " is set by shipyard"
+		}
+	}
+	return ""
+}
+
+// checkProxyTarget requires a proxy target to be an upstream defined in the
+// config, the site's backend, or a host in ProxyHosts
+func (p *Policy) checkProxyTarget(target string, upstreams map[string]bool) string {
+	if strings.Contains(target, "$") {
+		return fmt.Sprintf("target %q uses variables", target)
+	}
+	if strings.HasPrefix(target, "unix:") {
+		return fmt.Sprintf("target %q is a unix socket", target)
+	}
+
+	host := target
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexByte(host, '/'); i >= 0 {
+		host = host[:i]
+	}
+	host = strings.ToLower(host)
+
+	if upstreams[host] {
+		return ""
+	}
+	for _, pattern := range p.ProxyHosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return ""
+		}
+	}
+	return fmt.Sprintf("target %q is not the site's backend or in nginx.policy.proxy_hosts", target)
+}
+
+// upstreamNames returns the upstream blocks defined at the top level of a config
+func upstreamNames(dirs []*Directive) map[string]bool {
+	names := make(map[string]bool)
+	for _, d := range dirs {
+		if d.Name == "upstream" && len(d.Args) > 0 {
+			names[strings.ToLower(d.Args[0])] = true
+		}
+	}
+	return names
+}
+
+// EnforcePolicy applies nginx.policy to a rendered site config deployed with a
+// site key. In reject mode a *PolicyError lists the violations; in strip mode
+// the offending directives are removed and returned.
+func EnforcePolicy(conf, siteName string, cfg *config.Config) (string, []Violation, error) {
+	mode := cfg.Nginx.Policy.PolicyMode()
+	if mode == config.PolicyOff {
+		return conf, nil, nil
+	}
+
+	p := NewPolicy(cfg, siteName)
+	if mode == config.PolicyStrip {
+		return p.Strip(conf)
+	}
+
+	violations, err := p.Check(conf)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(violations) > 0 {
+		return "", nil, &PolicyError{Violations: violations}
+	}
+	return conf, nil, nil
+}
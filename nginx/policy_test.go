@@ -0,0 +1,220 @@
+package nginx
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func policyTestConfig(mode string) *config.Config {
+	return &config.Config{
+		Nginx: config.NginxConfig{
+			Policy: config.DirectivePolicyConfig{Mode: mode, ProxyHosts: []string{"api.example.net"}},
+		},
+		Site: map[string]config.SiteConfig{
+			"app.example.com": {
+				FrontendRoot: "/var/www/app.example.com",
+				Aliases:      []string{"www.app.example.com"},
+				Backend:      &config.BackendConfig{ListenPort: 8080, JailIP: "127.0.1.2"},
+			},
+		},
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	conf := `# comment { ;
+server {
+    server_name "a b" c;  # trailing
+    location / { try_files $uri ${uri}/ =404; }
+}
+`
+	dirs, err := ParseDirectives(conf)
+	if err != nil {
+		t.Fatalf("ParseDirectives() error = %v", err)
+	}
+	if len(dirs) != 1 || dirs[0].Name != "server" || len(dirs[0].Block) != 2 {
+		t.Fatalf("unexpected tree: %+v", dirs)
+	}
+	name := dirs[0].Block[0]
+	if name.Line != 3 || len(name.Args) != 2 || name.Args[0] != "a b" {
+		t.Errorf("server_name = %+v", name)
+	}
+	tryFiles := dirs[0].Block[1].Block[0]
+	if tryFiles.Name != "try_files" || tryFiles.Args[1] != "${uri}/" {
+		t.Errorf("try_files = %+v", tryFiles)
+	}
+
+	for _, bad := range []string{"server {", "listen 80", "}", `root "/x;`} {
+		if _, err := ParseDirectives(bad); err == nil {
+			t.Errorf("ParseDirectives(%q) should fail", bad)
+		}
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	p := NewPolicy(policyTestConfig(""), "app.example.com")
+
+	allowed := []string{
+		"root /var/www/app.example.com/$frontend_version;",
+		"root /var/www/app.example.com/${frontend_version}/public;",
+		"root /var/www/acme;",
+		"alias /var/www/app.example.com/latest/static/;",
+		"proxy_pass http://127.0.0.1:8080;",
+		"proxy_pass http://127.0.1.2:9000/api/;",
+		"proxy_pass https://api.example.net;",
+		"upstream shipyard_app { server 127.0.0.1:8080; } server { location / { proxy_pass http://shipyard_app; } }",
+		"add_header X-Frame-Options DENY;",
+		`set $site_version $frontend_version;`,
+		"server { listen 443 ssl; server_name app.example.com www.app.example.com; }",
+		"server_name App.Example.com;",
+		`map $host $backend_name { default app; }`,
+	}
+	for _, conf := range allowed {
+		v, err := p.Check(conf)
+		if err != nil || len(v) != 0 {
+			t.Errorf("Check(%q) = %v, %v; want allowed", conf, v, err)
+		}
+	}
+
+	denied := []string{
+		"load_module /tmp/evil.so;",
+		"include /etc/nginx/secrets.conf;",
+		"access_log /etc/passwd;",
+		"content_by_lua_block { ngx.say(1) }",
+		"root /etc;",
+		"root /var/www/app.example.com.evil;",
+		"alias /var/www/app.example.com/../other.com/;",
+		"root /var/www/app.example.com/$arg_p;",
+		"alias /var/www/app.example.com/${uri}/;",
+		"root /var/www/app.example.com/$frontend_version$uri;",
+		"proxy_cache_path /etc/x keys_zone=a:1m;",
+		"proxy_store on;",
+		"proxy_store_access user:rw;",
+		"ssl_dhparam /etc/ssl/dh.pem;",
+		"proxy_pass http://169.254.169.254/;",
+		"proxy_pass http://127.0.0.1:9090;",
+		"proxy_pass http://$arg_host;",
+		"fastcgi_pass unix:/var/run/php.sock;",
+		"upstream internal { server 10.0.0.5:5432; }",
+		"ssl_certificate_key /usr/local/etc/ssl/other.key;",
+		// Other sites' traffic
+		"server_name other.example.com;",
+		"server_name app.example.com other.example.com;",
+		"server_name _;",
+		`server_name "";`,
+		`server_name ~^(?<x>.+)\.example\.com$;`,
+		"server_name *.example.com;",
+		"listen 80 default_server;",
+		"listen [::]:443 ssl default_server;",
+		// $frontend_version is shipyard's to set
+		`server { set $frontend_version "../../../etc"; }`,
+		`location / { set ${frontend_version} x; }`,
+		`map $host $frontend_version { default "../../etc"; }`,
+		`geo $frontend_version { default "../../etc"; }`,
+		`geo $remote_addr $frontend_version { default x; }`,
+		`split_clients "${remote_addr}" $frontend_version { 50% "../a"; * b; }`,
+		`auth_request_set $frontend_version $upstream_http_x_version;`,
+		`location ~ ^/(?<frontend_version>.+)/ { root /var/www/app.example.com/$frontend_version; }`,
+		`if ($uri ~ "(?P<frontend_version>[^/]+)") { return 404; }`,
+	}
+	for _, conf := range denied {
+		v, err := p.Check(conf)
+		if err != nil || len(v) == 0 {
+			t.Errorf("Check(%q) = %v, %v; want a violation", conf, v, err)
+		}
+	}
+}
+
+func TestPolicy_AllowAndDeny(t *testing.T) {
+	cfg := policyTestConfig("")
+	cfg.Nginx.Policy.Allow = []string{"include"}
+	cfg.Nginx.Policy.Deny = []string{"return"}
+	p := NewPolicy(cfg, "app.example.com")
+
+	if v, _ := p.Check("include mime.types;"); len(v) != 0 {
+		t.Errorf("include should be allowed, got %v", v)
+	}
+	if v, _ := p.Check("return 301 https://example.com;"); len(v) != 1 {
+		t.Errorf("return should be denied, got %v", v)
+	}
+}
+
+func TestPolicy_Strip(t *testing.T) {
+	p := NewPolicy(policyTestConfig(""), "app.example.com")
+
+	conf := `server {
+    listen 80;
+    access_log /etc/cron.d/x;
+    location /internal {
+        proxy_pass http://10.0.0.1;
+    }
+    location / {
+        root /var/www/app.example.com/latest;
+    }
+}
+`
+	out, v, err := p.Strip(conf)
+	if err != nil {
+		t.Fatalf("Strip() error = %v", err)
+	}
+	if len(v) != 2 {
+		t.Errorf("Strip() violations = %v, want 2", v)
+	}
+	if strings.Contains(out, "/etc/cron.d") || strings.Contains(out, "10.0.0.1") {
+		t.Errorf("Strip() left denied directives:\n%s", out)
+	}
+	if !strings.Contains(out, "# removed by shipyard: access_log") || !strings.Contains(out, "root /var/www/app.example.com/latest;") {
+		t.Errorf("Strip() output unexpected:\n%s", out)
+	}
+	if _, err := ParseDirectives(out); err != nil {
+		t.Errorf("stripped config does not parse: %v", err)
+	}
+}
+
+func TestEnforcePolicy_Modes(t *testing.T) {
+	conf := "server { include /etc/shadow; }"
+
+	_, _, err := EnforcePolicy(conf, "app.example.com", policyTestConfig(""))
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || len(policyErr.Violations) != 1 {
+		t.Errorf("reject mode: err = %v, want *PolicyError", err)
+	}
+
+	out, stripped, err := EnforcePolicy(conf, "app.example.com", policyTestConfig(config.PolicyStrip))
+	if err != nil || len(stripped) != 1 || strings.Contains(out, "/etc/shadow") {
+		t.Errorf("strip mode: out = %q, stripped = %v, err = %v", out, stripped, err)
+	}
+
+	out, _, err = EnforcePolicy(conf, "app.example.com", policyTestConfig(config.PolicyOff))
+	if err != nil || out != conf {
+		t.Errorf("off mode: out = %q, err = %v", out, err)
+	}
+}
+
+func TestEnforcePolicy_OverrideExamplePasses(t *testing.T) {
+	cfg := policyTestConfig("")
+	rendered, err := RenderUserConfig(GetOverrideExample(), "app.example.com", cfg)
+	if err != nil {
+		t.Fatalf("RenderUserConfig() error = %v", err)
+	}
+	if _, _, err := EnforcePolicy(rendered, "app.example.com", cfg); err != nil {
+		t.Errorf("the override example should pass the default policy: %v", err)
+	}
+}
+
+func TestPolicy_WildcardServerName(t *testing.T) {
+	cfg := policyTestConfig("")
+	cfg.Site["*.docs.example.com"] = config.SiteConfig{FrontendRoot: "/var/www/docs"}
+	p := NewPolicy(cfg, "*.docs.example.com")
+
+	for _, name := range []string{"*.docs.example.com", WildcardServerName("*.docs.example.com")} {
+		if v, _ := p.Check("server_name " + name + ";"); len(v) != 0 {
+			t.Errorf("server_name %s: %v, want allowed", name, v)
+		}
+	}
+	if v, _ := p.Check("server_name *.example.com;"); len(v) != 1 {
+		t.Errorf("server_name *.example.com: %v, want refused", v)
+	}
+}
@@ -0,0 +1,88 @@
+package nginx
+
+import (
+	"fmt"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// RequestIDVar is the variable, mapped in the managed nginx.conf, holding a
+// request's trace ID: the client's X-Request-Id or nginx's $request_id
+const RequestIDVar = "shipyard_request_id"
+
+// ProxyDirectives returns the directives inside a backend's proxy location,
+// one per line and without indentation
+func ProxyDirectives(backend config.BackendConfig) []string {
+	addr := fmt.Sprintf("127.0.0.1:%d", backend.ListenPort)
+
+	switch backend.BackendProtocol() {
+	case config.ProtocolGRPC:
+		return []string{
+			fmt.Sprintf("grpc_pass grpc://%s;", addr),
+			"grpc_set_header X-Real-IP $remote_addr;",
+			"grpc_set_header X-Forwarded-Proto $scheme;",
+			"grpc_set_header X-Forwarded-For $proxy_add_x_forwarded_for;",
+			"grpc_set_header X-Request-Id $" + RequestIDVar + ";",
+			"client_max_body_size 0;",
+			"",
+			"# Don't restrict framing",
+			"grpc_hide_header X-Frame-Options;",
+		}
+
+	case config.ProtocolFastCGI:
+		return []string{
+			"include fastcgi_params;",
+			fmt.Sprintf("fastcgi_pass %s;", addr),
+			"fastcgi_index index.php;",
+			"# Scripts live inside the jail, not under nginx's document root",
+			fmt.Sprintf("fastcgi_param SCRIPT_FILENAME %s$fastcgi_script_name;", backend.FastCGIScriptRoot()),
+			fmt.Sprintf("fastcgi_param DOCUMENT_ROOT %s;", backend.FastCGIScriptRoot()),
+			"fastcgi_param HTTP_X_REQUEST_ID $" + RequestIDVar + ";",
+			"client_max_body_size 0;",
+			"",
+			"# Don't restrict framing",
+			"fastcgi_hide_header X-Frame-Options;",
+		}
+
+	case config.ProtocolUWSGI:
+		return []string{
+			"include uwsgi_params;",
+			fmt.Sprintf("uwsgi_pass %s;", addr),
+			"uwsgi_param HTTP_X_REQUEST_ID $" + RequestIDVar + ";",
+			"client_max_body_size 0;",
+			"",
+			"# Don't restrict framing",
+			"uwsgi_hide_header X-Frame-Options;",
+		}
+	}
+
+	lines := []string{
+		fmt.Sprintf("proxy_pass http://%s;", addr),
+		"proxy_http_version 1.1;",
+		"proxy_set_header X-Real-IP $remote_addr;",
+		"proxy_set_header Host $host;",
+		"proxy_set_header X-Forwarded-Proto $scheme;",
+		"proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;",
+		"proxy_set_header X-Request-Id $" + RequestIDVar + ";",
+		"proxy_pass_request_headers on;",
+		"client_max_body_size 0;",
+	}
+
+	if backend.WebSocket {
+		timeout := backend.WebSocketTimeoutSeconds()
+		lines = append(lines,
+			"",
+			"# WebSocket support: upgrade when asked, and keep idle connections open",
+			"proxy_set_header Upgrade $http_upgrade;",
+			"proxy_set_header Connection $connection_upgrade;",
+			fmt.Sprintf("proxy_read_timeout %ds;", timeout),
+			fmt.Sprintf("proxy_send_timeout %ds;", timeout),
+		)
+	}
+
+	return append(lines,
+		"",
+		"# Don't restrict framing",
+		"proxy_hide_header X-Frame-Options;",
+	)
+}
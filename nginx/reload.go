@@ -1,17 +1,28 @@
 package nginx
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/logger"
 	"github.com/lachierussell/shipyard/ssl"
 )
 
+// What EnsureRunning did
+const (
+	NginxReloaded = "reloaded"
+	NginxStarted  = "started"
+)
+
 // Manager orchestrates the nginx config deployment: write → validate → symlink → reload
+// The nginx commands it runs are killed when their ctx ends or after
+// nginx.command_timeout.
 type Manager struct {
 	cfg *config.Config
 }
@@ -24,8 +35,11 @@ func NewManager(cfg *config.Config) *Manager {
 // EnsureMainConf writes the managed main nginx.conf if it has changed.
 // Called at startup so that self-updates can ship nginx.conf fixes (e.g. client_max_body_size).
 // Returns true if the file was updated and nginx was reloaded.
-func (m *Manager) EnsureMainConf() (bool, error) {
-	desired := GenerateMainConf()
+func (m *Manager) EnsureMainConf(ctx context.Context) (bool, error) {
+	if err := ensureBanInclude(m.cfg); err != nil {
+		return false, err
+	}
+	desired := GenerateMainConf(m.cfg)
 	confPath := m.cfg.Nginx.MainConfPath
 
 	// Read existing config
@@ -44,7 +58,7 @@ func (m *Manager) EnsureMainConf() (bool, error) {
 	}
 
 	// Validate before reloading
-	if err := Validate(m.cfg); err != nil {
+	if err := Validate(ctx, m.cfg); err != nil {
 		// Restore old config on validation failure
 		if len(existing) > 0 {
 			os.WriteFile(confPath, existing, 0644)
@@ -52,17 +66,25 @@ func (m *Manager) EnsureMainConf() (bool, error) {
 		return false, fmt.Errorf("nginx validation failed after main conf update: %w", err)
 	}
 
-	cmd := exec.Command(m.cfg.Nginx.BinaryPath, "-s", "reload")
-	if err := cmd.Run(); err != nil {
-		return false, fmt.Errorf("nginx reload: %w", err)
+	if err := m.reload(ctx); err != nil {
+		return false, err
 	}
 
 	return true, nil
 }
 
+// reload signals nginx to reload its config
+func (m *Manager) reload(ctx context.Context) error {
+	if out, err := commands(m.cfg).Run(ctx, m.cfg.Nginx.BinaryPath, "-s", "reload"); err != nil {
+		return fmt.Errorf("nginx reload: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 // DeploySiteConfig writes a site config to sites-available, validates it, and reloads nginx
-// If SSL is enabled for the site, it transforms the config to HTTPS
-func (m *Manager) DeploySiteConfig(siteName string, nginxConfig string) (bool, string, error) {
+// If SSL is enabled for the site, it transforms the config to HTTPS. It logs
+// through ctx's logger.
+func (m *Manager) DeploySiteConfig(ctx context.Context, siteName string, nginxConfig string) (bool, string, error) {
 	site, ok := m.cfg.Site[siteName]
 	if !ok {
 		return false, "", fmt.Errorf("site not found: %s", siteName)
@@ -73,15 +95,17 @@ func (m *Manager) DeploySiteConfig(siteName string, nginxConfig string) (bool, s
 	finalConfig := nginxConfig
 	if site.SSLEnabled {
 		certPath, keyPath := ssl.CertPaths(siteName)
-		finalConfig = TransformToHTTPS(nginxConfig, siteName, certPath, keyPath)
+		finalConfig = TransformToHTTPS(nginxConfig, siteName, certPath, keyPath, m.cfg.TLSFor(siteName))
 	}
 
-	return m.DeploySiteConfigRaw(siteName, finalConfig)
+	return m.DeploySiteConfigRaw(ctx, siteName, finalConfig)
 }
 
 // DeploySiteConfigRaw writes a site config directly without SSL transformation
 // Use this when the config already includes SSL directives (e.g., from HTTPS templates)
-func (m *Manager) DeploySiteConfigRaw(siteName string, nginxConfig string) (bool, string, error) {
+func (m *Manager) DeploySiteConfigRaw(ctx context.Context, siteName string, nginxConfig string) (bool, string, error) {
+	log := logger.FromContext(ctx)
+
 	if _, ok := m.cfg.Site[siteName]; !ok {
 		return false, "", fmt.Errorf("site not found: %s", siteName)
 	}
@@ -104,9 +128,9 @@ func (m *Manager) DeploySiteConfigRaw(siteName string, nginxConfig string) (bool
 	}
 
 	// Validate the entire nginx config
-	isValid, errMsg := ValidateAndGetError(m.cfg)
+	isValid, errMsg := ValidateAndGetError(ctx, m.cfg)
 	if !isValid {
-		slog.Warn("nginx validation failed", "domain", siteName, "error", errMsg)
+		log.Warn("nginx validation failed", "domain", siteName, "error", errMsg)
 		return false, errMsg, nil
 	}
 
@@ -116,15 +140,87 @@ func (m *Manager) DeploySiteConfigRaw(siteName string, nginxConfig string) (bool
 	}
 
 	// Reload nginx
-	slog.Info("reloading nginx", "domain", siteName)
-	cmd := exec.Command(m.cfg.Nginx.BinaryPath, "-s", "reload")
-	if err := cmd.Run(); err != nil {
-		return false, "", fmt.Errorf("nginx reload: %w", err)
+	log.Info("reloading nginx", "domain", siteName)
+	if err := m.reload(ctx); err != nil {
+		return false, "", err
 	}
 
 	return true, "", nil
 }
 
+// Reload validates the current config and reloads nginx
+func (m *Manager) Reload(ctx context.Context) error {
+	if valid, errMsg := ValidateAndGetError(ctx, m.cfg); !valid {
+		return fmt.Errorf("nginx config invalid: %s", errMsg)
+	}
+	return m.reload(ctx)
+}
+
+// EnsureRunning validates the config and reloads nginx, starting it instead
+// when it isn't running, e.g. after a host reboot that left it stopped. It
+// returns NginxReloaded or NginxStarted.
+func (m *Manager) EnsureRunning(ctx context.Context) (string, error) {
+	if valid, errMsg := ValidateAndGetError(ctx, m.cfg); !valid {
+		return "", fmt.Errorf("nginx config invalid: %s", errMsg)
+	}
+	// A reload fails when there is no master process to signal
+	if err := m.reload(ctx); err == nil {
+		return NginxReloaded, nil
+	}
+	if out, err := commands(m.cfg).Run(ctx, m.cfg.Nginx.BinaryPath); err != nil {
+		return "", fmt.Errorf("start nginx: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return NginxStarted, nil
+}
+
+// EnsureSiteLinks re-creates the sites-enabled symlink of every configured
+// site whose config is in sites-available but isn't linked to it, and
+// returns the sites it linked. nginx must be reloaded to pick them up.
+func (m *Manager) EnsureSiteLinks() ([]string, error) {
+	names := make([]string, 0, len(m.cfg.Site))
+	for name := range m.cfg.Site {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var linked []string
+	for _, name := range names {
+		availablePath := filepath.Join(m.cfg.Nginx.SitesAvailable, name+".conf")
+		if _, err := os.Stat(availablePath); err != nil {
+			continue // never deployed
+		}
+		enabledPath := filepath.Join(m.cfg.Nginx.SitesEnabled, name+".conf")
+		if target, err := os.Readlink(enabledPath); err == nil && target == availablePath {
+			continue
+		}
+		if err := m.symlinkSiteConfig(name); err != nil {
+			return linked, fmt.Errorf("%s: %w", name, err)
+		}
+		linked = append(linked, name)
+	}
+	return linked, nil
+}
+
+// ApplyOverrides regenerates override.conf from the current config, validates it
+// and reloads nginx. If nginx rejects it, the previous override.conf is restored.
+func (m *Manager) ApplyOverrides(ctx context.Context) error {
+	previous, readErr := os.ReadFile(m.cfg.Nginx.OverrideConf)
+
+	if err := os.WriteFile(m.cfg.Nginx.OverrideConf, []byte(GenerateOverrideConf(m.cfg)), 0644); err != nil {
+		return fmt.Errorf("write override conf: %w", err)
+	}
+
+	if valid, errMsg := ValidateAndGetError(ctx, m.cfg); !valid {
+		if readErr == nil {
+			os.WriteFile(m.cfg.Nginx.OverrideConf, previous, 0644)
+		}
+		return fmt.Errorf("nginx config invalid: %s", errMsg)
+	}
+
+	slog.Info("reloading nginx for override change")
+	return m.reload(ctx)
+}
+
 // symlinkSiteConfig creates a symlink from sites-enabled to sites-available
 func (m *Manager) symlinkSiteConfig(siteName string) error {
 	// siteName IS the domain (domain is the key)
@@ -152,7 +248,7 @@ func (m *Manager) symlinkSiteConfig(siteName string) error {
 
 // DeployHTTPOnlyConfig deploys a minimal HTTP config for ACME challenge
 // This is used during initial certificate acquisition
-func (m *Manager) DeployHTTPOnlyConfig(domain string) error {
+func (m *Manager) DeployHTTPOnlyConfig(ctx context.Context, domain string) error {
 	// Ensure directories exist
 	if err := os.MkdirAll(m.cfg.Nginx.SitesAvailable, 0755); err != nil {
 		return fmt.Errorf("mkdir sites-available: %w", err)
@@ -178,7 +274,7 @@ func (m *Manager) DeployHTTPOnlyConfig(domain string) error {
 	}
 
 	// Validate nginx config
-	isValid, errMsg := ValidateAndGetError(m.cfg)
+	isValid, errMsg := ValidateAndGetError(ctx, m.cfg)
 	if !isValid {
 		// Clean up on failure
 		os.Remove(enabledPath)
@@ -187,17 +283,12 @@ func (m *Manager) DeployHTTPOnlyConfig(domain string) error {
 	}
 
 	// Reload nginx
-	cmd := exec.Command(m.cfg.Nginx.BinaryPath, "-s", "reload")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("nginx reload: %w", err)
-	}
-
-	return nil
+	return m.reload(ctx)
 }
 
 // RemoveSiteConfigByDomain removes a site's nginx config by domain name
 // Does not check if site exists in config (useful for cleanup)
-func (m *Manager) RemoveSiteConfigByDomain(domain string) error {
+func (m *Manager) RemoveSiteConfigByDomain(ctx context.Context, domain string) error {
 	enabledPath := filepath.Join(m.cfg.Nginx.SitesEnabled, domain+".conf")
 	availablePath := filepath.Join(m.cfg.Nginx.SitesAvailable, domain+".conf")
 
@@ -205,14 +296,13 @@ func (m *Manager) RemoveSiteConfigByDomain(domain string) error {
 	os.Remove(availablePath)
 
 	// Reload nginx
-	cmd := exec.Command(m.cfg.Nginx.BinaryPath, "-s", "reload")
-	cmd.Run() // ignore errors
+	m.reload(ctx) // ignore errors
 
 	return nil
 }
 
 // RemoveSiteConfig removes a site from nginx and reloads
-func (m *Manager) RemoveSiteConfig(siteName string) error {
+func (m *Manager) RemoveSiteConfig(ctx context.Context, siteName string) error {
 	// siteName IS the domain (domain is the key)
 	if _, ok := m.cfg.Site[siteName]; !ok {
 		return fmt.Errorf("site not found: %s", siteName)
@@ -232,15 +322,10 @@ func (m *Manager) RemoveSiteConfig(siteName string) error {
 	}
 
 	// Validate and reload
-	isValid, errMsg := ValidateAndGetError(m.cfg)
+	isValid, errMsg := ValidateAndGetError(ctx, m.cfg)
 	if !isValid {
 		return fmt.Errorf("nginx validation after removal: %s", errMsg)
 	}
 
-	cmd := exec.Command(m.cfg.Nginx.BinaryPath, "-s", "reload")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("nginx reload: %w", err)
-	}
-
-	return nil
+	return m.reload(ctx)
 }
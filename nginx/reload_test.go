@@ -0,0 +1,67 @@
+package nginx
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestEnsureSiteLinks(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Nginx: config.NginxConfig{
+			SitesAvailable: filepath.Join(dir, "sites-available"),
+			SitesEnabled:   filepath.Join(dir, "sites-enabled"),
+		},
+		Site: map[string]config.SiteConfig{
+			"linked.example.com":  {},
+			"missing.example.com": {},
+			"stale.example.com":   {},
+			"new.example.com":     {},
+		},
+	}
+	os.MkdirAll(cfg.Nginx.SitesAvailable, 0755)
+	os.MkdirAll(cfg.Nginx.SitesEnabled, 0755)
+	available := func(name string) string { return filepath.Join(cfg.Nginx.SitesAvailable, name+".conf") }
+	enabled := func(name string) string { return filepath.Join(cfg.Nginx.SitesEnabled, name+".conf") }
+	for _, name := range []string{"linked.example.com", "missing.example.com", "stale.example.com"} {
+		os.WriteFile(available(name), []byte("server {}\n"), 0644)
+	}
+	os.Symlink(available("linked.example.com"), enabled("linked.example.com"))
+	os.Symlink("/elsewhere.conf", enabled("stale.example.com"))
+
+	linked, err := NewManager(cfg).EnsureSiteLinks()
+	if err != nil {
+		t.Fatalf("EnsureSiteLinks: %v", err)
+	}
+	if want := []string{"missing.example.com", "stale.example.com"}; !slices.Equal(linked, want) {
+		t.Errorf("linked = %v, want %v", linked, want)
+	}
+	for _, name := range []string{"linked.example.com", "missing.example.com", "stale.example.com"} {
+		if target, _ := os.Readlink(enabled(name)); target != available(name) {
+			t.Errorf("%s links to %q, want %q", name, target, available(name))
+		}
+	}
+	if _, err := os.Lstat(enabled("new.example.com")); !os.IsNotExist(err) {
+		t.Errorf("site never deployed was linked")
+	}
+}
+
+func TestValidate_KilledAfterCommandTimeout(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "nginx")
+	os.WriteFile(bin, []byte("#!/bin/sh\nexec sleep 30\n"), 0755)
+	cfg := &config.Config{Nginx: config.NginxConfig{BinaryPath: bin, CommandTimeout: 1}}
+
+	start := time.Now()
+	if valid, _ := ValidateAndGetError(context.Background(), cfg); valid {
+		t.Error("a killed nginx -t passed validation")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("nginx -t ran %s, want it killed after 1s", elapsed)
+	}
+}
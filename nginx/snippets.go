@@ -0,0 +1,137 @@
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrSnippetNotFound is returned when no snippet has the requested name
+var ErrSnippetNotFound = errors.New("snippet not found")
+
+// snippetNameRegex limits snippet names to lowercase slugs like "security-headers"
+var snippetNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ValidSnippetName reports whether name can be used for a snippet
+func ValidSnippetName(name string) bool {
+	return snippetNameRegex.MatchString(name)
+}
+
+// Snippet describes a stored snippet
+type Snippet struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// SnippetStore keeps shared nginx snippets as <dir>/<name>.conf. User
+// templates insert them with the snippet function, so a changed snippet
+// reaches each site on its next deploy.
+type SnippetStore struct {
+	dir string
+}
+
+// NewSnippetStore creates a SnippetStore in dir
+func NewSnippetStore(dir string) *SnippetStore {
+	return &SnippetStore{dir: dir}
+}
+
+// Get returns a snippet's content
+func (s *SnippetStore) Get(name string) (string, error) {
+	if !ValidSnippetName(name) {
+		return "", fmt.Errorf("invalid snippet name %q", name)
+	}
+	data, err := os.ReadFile(s.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%w: %s", ErrSnippetNotFound, name)
+		}
+		return "", fmt.Errorf("read snippet: %w", err)
+	}
+	return string(data), nil
+}
+
+// Put creates or replaces a snippet. The content must be a list of complete
+// nginx directives.
+func (s *SnippetStore) Put(name, content string) error {
+	if !ValidSnippetName(name) {
+		return fmt.Errorf("invalid snippet name %q", name)
+	}
+	if _, err := ParseDirectives(content); err != nil {
+		return fmt.Errorf("invalid snippet: %w", err)
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("create snippets directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".snippet-*")
+	if err != nil {
+		return fmt.Errorf("write snippet: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err != nil {
+		return fmt.Errorf("write snippet: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("write snippet: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(name)); err != nil {
+		return fmt.Errorf("write snippet: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a snippet
+func (s *SnippetStore) Delete(name string) error {
+	if !ValidSnippetName(name) {
+		return fmt.Errorf("invalid snippet name %q", name)
+	}
+	if err := os.Remove(s.path(name)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrSnippetNotFound, name)
+		}
+		return fmt.Errorf("remove snippet: %w", err)
+	}
+	return nil
+}
+
+// List returns every snippet, sorted by name
+func (s *SnippetStore) List() ([]Snippet, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Snippet{}, nil
+		}
+		return nil, fmt.Errorf("read snippets directory: %w", err)
+	}
+
+	snippets := []Snippet{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".conf")
+		if !ok || !entry.Type().IsRegular() || !ValidSnippetName(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snippets = append(snippets, Snippet{Name: name, Size: info.Size(), Modified: info.ModTime().UTC()})
+	}
+	sort.Slice(snippets, func(i, j int) bool { return snippets[i].Name < snippets[j].Name })
+	return snippets, nil
+}
+
+func (s *SnippetStore) path(name string) string {
+	return filepath.Join(s.dir, name+".conf")
+}
@@ -0,0 +1,83 @@
+package nginx
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestSnippetStore_PutGetListDelete(t *testing.T) {
+	store := NewSnippetStore(t.TempDir())
+
+	if list, err := store.List(); err != nil || len(list) != 0 {
+		t.Fatalf("List() on empty store = %v, %v", list, err)
+	}
+
+	headers := "add_header X-Frame-Options DENY;\nadd_header X-Content-Type-Options nosniff;\n"
+	if err := store.Put("security-headers", headers); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := store.Put("cors-api", "add_header Access-Control-Allow-Origin *;\n"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := store.Get("security-headers")
+	if err != nil || got != headers {
+		t.Errorf("Get() = %q, %v", got, err)
+	}
+
+	list, err := store.List()
+	if err != nil || len(list) != 2 || list[0].Name != "cors-api" || list[1].Name != "security-headers" {
+		t.Errorf("List() = %+v, %v", list, err)
+	}
+
+	if err := store.Delete("cors-api"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get("cors-api"); !errors.Is(err, ErrSnippetNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrSnippetNotFound", err)
+	}
+	if err := store.Delete("cors-api"); !errors.Is(err, ErrSnippetNotFound) {
+		t.Errorf("second Delete() error = %v, want ErrSnippetNotFound", err)
+	}
+}
+
+func TestSnippetStore_Rejects(t *testing.T) {
+	store := NewSnippetStore(t.TempDir())
+
+	for _, name := range []string{"", "../etc", "Caps", "a/b", "-x"} {
+		if err := store.Put(name, "gzip on;"); err == nil {
+			t.Errorf("Put(%q) should reject the name", name)
+		}
+	}
+	if err := store.Put("broken", "add_header X-A 1"); err == nil {
+		t.Error("Put() should reject a directive without ;")
+	}
+}
+
+func TestRenderUserConfig_Snippet(t *testing.T) {
+	cfg := &config.Config{
+		Self: config.SelfConfig{StateDir: t.TempDir()},
+		Site: map[string]config.SiteConfig{
+			"app.example.com": {FrontendRoot: "/var/www/app.example.com"},
+		},
+	}
+	if err := NewSnippetStore(cfg.SnippetsDir()).Put("security-headers", "add_header X-Frame-Options DENY;\n"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	out, err := RenderUserConfig(`server { <% snippet "security-headers" %> }`, "app.example.com", cfg)
+	if err != nil {
+		t.Fatalf("RenderUserConfig() error = %v", err)
+	}
+	if out != "server { add_header X-Frame-Options DENY; }" {
+		t.Errorf("RenderUserConfig() = %q", out)
+	}
+
+	_, err = RenderUserConfig(`<% snippet "missing" %>`, "app.example.com", cfg)
+	if err == nil || !strings.Contains(err.Error(), "snippet not found") {
+		t.Errorf("missing snippet error = %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package nginx
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// tlsPolicy describes the protocol and cipher settings for a Mozilla TLS profile
+type tlsPolicy struct {
+	Protocols           string
+	Ciphers             string // empty = let OpenSSL pick (TLSv1.3 only)
+	PreferServerCiphers bool
+	UsesDHParam         bool
+}
+
+// tlsPolicies maps policy names to Mozilla server-side TLS recommendations
+// See https://ssl-config.mozilla.org/
+var tlsPolicies = map[string]tlsPolicy{
+	config.TLSPolicyModern: {
+		Protocols: "TLSv1.3",
+	},
+	config.TLSPolicyIntermediate: {
+		Protocols:   "TLSv1.2 TLSv1.3",
+		Ciphers:     "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384",
+		UsesDHParam: true,
+	},
+	config.TLSPolicyOld: {
+		Protocols:           "TLSv1 TLSv1.1 TLSv1.2 TLSv1.3",
+		Ciphers:             "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256:ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES128-SHA:ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA384:ECDHE-ECDSA-AES256-SHA:ECDHE-RSA-AES256-SHA:DHE-RSA-AES128-SHA256:DHE-RSA-AES256-SHA256:AES128-GCM-SHA256:AES256-GCM-SHA384:AES128-SHA256:AES256-SHA256:AES128-SHA:AES256-SHA:DES-CBC3-SHA",
+		PreferServerCiphers: true,
+		UsesDHParam:         true,
+	},
+}
+
+// TLSDirectives returns the ssl_* directives for an HTTPS server block, one per line
+// and without indentation. The default policy is intermediate with stapling and
+// session tickets disabled, which matches what shipyard has always generated.
+func TLSDirectives(tls config.TLSConfig, sslCert string, sslKey string) []string {
+	policy, ok := tlsPolicies[tls.Policy]
+	if !ok {
+		policy = tlsPolicies[config.TLSPolicyIntermediate]
+	}
+
+	tickets := "off"
+	if tls.SessionTickets != nil && *tls.SessionTickets {
+		tickets = "on"
+	}
+
+	lines := []string{
+		fmt.Sprintf("ssl_certificate %s;", sslCert),
+		fmt.Sprintf("ssl_certificate_key %s;", sslKey),
+		"ssl_session_timeout 1d;",
+		"ssl_session_cache shared:SSL:50m;",
+		fmt.Sprintf("ssl_session_tickets %s;", tickets),
+		"",
+		fmt.Sprintf("# TLS policy: %s", policyName(tls.Policy)),
+		fmt.Sprintf("ssl_protocols %s;", policy.Protocols),
+	}
+	if policy.Ciphers != "" {
+		lines = append(lines, fmt.Sprintf("ssl_ciphers %s;", policy.Ciphers))
+	}
+	if policy.PreferServerCiphers {
+		lines = append(lines, "ssl_prefer_server_ciphers on;")
+	} else {
+		lines = append(lines, "ssl_prefer_server_ciphers off;")
+	}
+	if policy.UsesDHParam && tls.DHParam != "" {
+		lines = append(lines, fmt.Sprintf("ssl_dhparam %s;", tls.DHParam))
+	}
+
+	if tls.Stapling != nil && *tls.Stapling {
+		// certbot writes chain.pem next to fullchain.pem
+		lines = append(lines,
+			"",
+			"# OCSP stapling",
+			"ssl_stapling on;",
+			"ssl_stapling_verify on;",
+			fmt.Sprintf("ssl_trusted_certificate %s;", filepath.Join(filepath.Dir(sslCert), "chain.pem")),
+		)
+		if tls.Resolver != "" {
+			lines = append(lines, fmt.Sprintf("resolver %s valid=300s;", tls.Resolver))
+		}
+	}
+
+	return lines
+}
+
+// policyName returns the display name of a policy, resolving the default
+func policyName(p string) string {
+	if _, ok := tlsPolicies[p]; ok {
+		return p
+	}
+	return config.TLSPolicyIntermediate
+}
+
+// indentLines joins lines with the given indent, leaving blank lines empty
+func indentLines(lines []string, indent string) string {
+	var sb strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		if line != "" {
+			sb.WriteString(indent + line)
+		}
+	}
+	return sb.String()
+}
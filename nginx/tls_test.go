@@ -0,0 +1,89 @@
+package nginx
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestTLSDirectives_DefaultIsIntermediate(t *testing.T) {
+	result := strings.Join(TLSDirectives(config.TLSConfig{}, "/c/fullchain.pem", "/c/privkey.pem"), "\n")
+
+	if !strings.Contains(result, "ssl_protocols TLSv1.2 TLSv1.3;") {
+		t.Error("default policy should allow TLSv1.2 and TLSv1.3")
+	}
+	if !strings.Contains(result, "ssl_session_tickets off;") {
+		t.Error("session tickets should be off by default")
+	}
+	if strings.Contains(result, "ssl_stapling") {
+		t.Error("stapling should be off by default")
+	}
+}
+
+func TestTLSDirectives_Modern(t *testing.T) {
+	result := strings.Join(TLSDirectives(config.TLSConfig{
+		Policy:  config.TLSPolicyModern,
+		DHParam: "/etc/ssl/dhparam.pem",
+	}, "/c/fullchain.pem", "/c/privkey.pem"), "\n")
+
+	if !strings.Contains(result, "ssl_protocols TLSv1.3;") {
+		t.Error("modern policy should only allow TLSv1.3")
+	}
+	if strings.Contains(result, "ssl_ciphers") {
+		t.Error("modern policy should not set ssl_ciphers")
+	}
+	if strings.Contains(result, "ssl_dhparam") {
+		t.Error("modern policy should ignore dhparam")
+	}
+}
+
+func TestTLSDirectives_OldWithDHParam(t *testing.T) {
+	result := strings.Join(TLSDirectives(config.TLSConfig{
+		Policy:  config.TLSPolicyOld,
+		DHParam: "/etc/ssl/dhparam.pem",
+	}, "/c/fullchain.pem", "/c/privkey.pem"), "\n")
+
+	if !strings.Contains(result, "TLSv1 TLSv1.1") {
+		t.Error("old policy should allow legacy protocols")
+	}
+	if !strings.Contains(result, "ssl_prefer_server_ciphers on;") {
+		t.Error("old policy should prefer server ciphers")
+	}
+	if !strings.Contains(result, "ssl_dhparam /etc/ssl/dhparam.pem;") {
+		t.Error("old policy should include dhparam")
+	}
+}
+
+func TestTLSDirectives_StaplingAndTickets(t *testing.T) {
+	on := true
+	result := strings.Join(TLSDirectives(config.TLSConfig{
+		Stapling:       &on,
+		SessionTickets: &on,
+		Resolver:       "127.0.0.1",
+	}, "/c/fullchain.pem", "/c/privkey.pem"), "\n")
+
+	for _, want := range []string{
+		"ssl_stapling on;",
+		"ssl_stapling_verify on;",
+		"ssl_trusted_certificate /c/chain.pem;",
+		"resolver 127.0.0.1 valid=300s;",
+		"ssl_session_tickets on;",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("directives missing %q", want)
+		}
+	}
+}
+
+func TestGenerateSiteCombinedConfigHTTPS_UsesTLSPolicy(t *testing.T) {
+	result := GenerateSiteCombinedConfigHTTPS("example.com", config.SiteConfig{FrontendRoot: "/var/www/example.com", Backend: &config.BackendConfig{ListenPort: 8080, ProxyPath: "/api"}},
+		"/c/fullchain.pem", "/c/privkey.pem", config.TLSConfig{Policy: config.TLSPolicyModern}, &config.Config{})
+
+	if !strings.Contains(result, "    ssl_protocols TLSv1.3;") {
+		t.Error("combined HTTPS config should render the configured policy")
+	}
+	if !strings.Contains(result, "    ssl_certificate /c/fullchain.pem;") {
+		t.Error("combined HTTPS config should include the certificate path")
+	}
+}
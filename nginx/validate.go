@@ -1,17 +1,23 @@
 package nginx
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
 	"strings"
 
 	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/runner"
 )
 
+// commands returns the runner for nginx commands, which are killed after
+// nginx.command_timeout
+func commands(cfg *config.Config) *runner.Runner {
+	return &runner.Runner{Timeout: cfg.Nginx.CommandTimeoutDuration()}
+}
+
 // Validate runs "nginx -t" to check if the current config is valid
-func Validate(cfg *config.Config) error {
-	cmd := exec.Command(cfg.Nginx.BinaryPath, "-t")
-	output, err := cmd.CombinedOutput()
+func Validate(ctx context.Context, cfg *config.Config) error {
+	output, err := commands(cfg).Run(ctx, cfg.Nginx.BinaryPath, "-t")
 
 	if err != nil {
 		// nginx -t outputs to stderr on error
@@ -24,9 +30,8 @@ func Validate(cfg *config.Config) error {
 
 // ValidateAndGetError is like Validate but returns the error message separately
 // (for API responses that need to differentiate between validation failures and other errors)
-func ValidateAndGetError(cfg *config.Config) (bool, string) {
-	cmd := exec.Command(cfg.Nginx.BinaryPath, "-t")
-	output, err := cmd.CombinedOutput()
+func ValidateAndGetError(ctx context.Context, cfg *config.Config) (bool, string) {
+	output, err := commands(cfg).Run(ctx, cfg.Nginx.BinaryPath, "-t")
 
 	if err != nil {
 		return false, strings.TrimSpace(string(output))
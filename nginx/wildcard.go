@@ -0,0 +1,45 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// WildcardServerName returns a regex server_name for a wildcard site that
+// captures the subdomain label, e.g. *.docs.example.com becomes
+// ~^(?<subdomain>[a-z0-9-]+)\.docs\.example\.com$
+func WildcardServerName(domain string) string {
+	rest := strings.TrimPrefix(domain, "*.")
+	return fmt.Sprintf(`~^(?<subdomain>[a-z0-9-]+)\.%s$`, regexp.QuoteMeta(rest))
+}
+
+// GenerateWildcardConfig creates an HTTP nginx config for a wildcard site.
+// Each subdomain is served from its own directory under frontend_root, so
+// foo.docs.example.com serves <frontend_root>/foo/latest. TransformToHTTPS
+// adds SSL like any other site config.
+func GenerateWildcardConfig(domain string, site config.SiteConfig, cfg *config.Config) string {
+	var sb strings.Builder
+	sb.WriteString("# Wildcard site (auto-generated by Shipyard)\n")
+	sb.WriteString("server {\n")
+	sb.WriteString("    listen 80;\n")
+	sb.WriteString(fmt.Sprintf("    server_name %s;\n", WildcardServerName(domain)))
+	sb.WriteString("\n")
+	sb.WriteString("    # ACME challenge for Let's Encrypt\n")
+	sb.WriteString("    location /.well-known/acme-challenge/ {\n")
+	sb.WriteString(fmt.Sprintf("        root %s;\n", AcmeWebroot))
+	sb.WriteString("    }\n")
+	sb.WriteString("\n")
+	if geo := GeoDirectives(domain, site); geo != nil {
+		sb.WriteString(indentLines(geo, "    ") + "\n")
+	}
+	sb.WriteString("    # Each subdomain has its own deploys under frontend_root\n")
+	sb.WriteString(fmt.Sprintf("    root %s/$subdomain/latest;\n", site.FrontendRoot))
+	sb.WriteString("    index index.html;\n")
+	sb.WriteString("\n")
+	sb.WriteString(FrontendBlock(site, cfg))
+	sb.WriteString("\n}\n")
+	return sb.String()
+}
@@ -0,0 +1,56 @@
+package nginx
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestWildcardServerName(t *testing.T) {
+	name := WildcardServerName("*.docs.example.com")
+	if name != `~^(?<subdomain>[a-z0-9-]+)\.docs\.example\.com$` {
+		t.Errorf("WildcardServerName() = %q", name)
+	}
+
+	// Go's regexp accepts the same syntax, so check what it matches
+	re := regexp.MustCompile(strings.TrimPrefix(name, "~"))
+	if m := re.FindStringSubmatch("pr-42.docs.example.com"); m == nil || m[1] != "pr-42" {
+		t.Errorf("server_name should capture pr-42, got %v", m)
+	}
+	for _, host := range []string{"docs.example.com", "a.b.docs.example.com", "x.docsXexample.com"} {
+		if re.MatchString(host) {
+			t.Errorf("server_name should not match %q", host)
+		}
+	}
+}
+
+func TestGenerateWildcardConfig(t *testing.T) {
+	site := config.SiteConfig{FrontendRoot: "/var/www/wildcard.docs.example.com"}
+	conf := GenerateWildcardConfig("*.docs.example.com", site, &config.Config{})
+
+	for _, want := range []string{
+		"root /var/www/wildcard.docs.example.com/$subdomain/latest;",
+		"location @shipyard_spa",
+		"location /.well-known/acme-challenge/",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Errorf("config missing %q:\n%s", want, conf)
+		}
+	}
+	if _, err := ParseDirectives(conf); err != nil {
+		t.Errorf("generated config does not parse: %v", err)
+	}
+
+	https := TransformToHTTPS(conf, "*.docs.example.com", "/c.pem", "/k.pem", config.TLSConfig{})
+	if !strings.Contains(https, "listen 443 ssl;") || !strings.Contains(https, "server_name *.docs.example.com;") {
+		t.Errorf("HTTPS config unexpected:\n%s", https)
+	}
+}
+
+func TestNormalizeDomainName_Wildcard(t *testing.T) {
+	if got := NormalizeDomainName("*.docs.example.com"); got != "wildcard_docs_example_com" {
+		t.Errorf("NormalizeDomainName() = %q", got)
+	}
+}
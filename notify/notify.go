@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// Event kinds
+const (
+	KindBackendCrash     = "backend_crash"
+	KindBaseUpdatePaused = "base_update_paused"
+	// A deploy to a site with require_approval waits for a second admin
+	KindApprovalRequested = "deploy_approval_requested"
+	KindApprovalExpired   = "deploy_approval_expired"
+	// Files of a live frontend commit changed after it was deployed
+	KindIntegrityDrift = "integrity_drift"
+	// A site failed health.failure_threshold probes in a row, and recovered
+	KindIncidentOpened   = "incident_opened"
+	KindIncidentResolved = "incident_resolved"
+)
+
+// Event is something an operator should hear about
+type Event struct {
+	Kind    string    `json:"kind"`
+	Site    string    `json:"site,omitempty"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	Details any       `json:"details,omitempty"`
+	// Owner and Tags are the site's, filled in by Send
+	Owner string   `json:"owner,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// Notifier logs events and posts them to the configured webhook
+type Notifier struct {
+	cfg    *config.Config
+	client *http.Client
+}
+
+// New creates a Notifier
+func New(cfg *config.Config) *Notifier {
+	return &Notifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Notify.TimeoutDuration()},
+	}
+}
+
+// Send logs e and delivers it in the background to the webhook and to the
+// webhook of the site's owner. A nil Notifier drops events.
+func (n *Notifier) Send(e Event) {
+	if n == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if site, ok := n.cfg.Site[e.Site]; ok {
+		e.Owner, e.Tags = site.Owner, site.Tags
+	}
+	slog.Warn("notification", "kind", e.Kind, "site", e.Site, "owner", e.Owner, "message", e.Message)
+
+	for _, url := range n.webhooks(e.Owner) {
+		go func() {
+			if err := n.post(url, e); err != nil {
+				slog.Error("notification webhook failed", "kind", e.Kind, "site", e.Site, "url", url, "error", err)
+			}
+		}()
+	}
+}
+
+// webhooks returns the URLs an event of a site with owner goes to
+func (n *Notifier) webhooks(owner string) []string {
+	var urls []string
+	if url := n.cfg.Notify.WebhookURL; url != "" {
+		urls = append(urls, url)
+	}
+	if url := n.cfg.Notify.OwnerWebhook(owner); url != "" && !slices.Contains(urls, url) {
+		urls = append(urls, url)
+	}
+	return urls
+}
+
+// post delivers one event to url
+func (n *Notifier) post(url string, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode event: %w", err)
+	}
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("POST webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST webhook: status %d", resp.StatusCode)
+	}
+	return nil
+}
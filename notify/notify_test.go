@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestNotifier_Post(t *testing.T) {
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	n := New(&config.Config{Notify: config.NotifyConfig{WebhookURL: srv.URL}})
+	if err := n.post(srv.URL, Event{Kind: KindBackendCrash, Site: "api.example.com", Message: "crashed"}); err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if got.Kind != KindBackendCrash || got.Site != "api.example.com" || got.Message != "crashed" {
+		t.Errorf("webhook received %+v", got)
+	}
+}
+
+func TestNotifier_PostRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	n := New(&config.Config{})
+	if err := n.post(srv.URL, Event{Kind: KindBackendCrash}); err == nil {
+		t.Error("post succeeded on a 403")
+	}
+}
+
+func TestNotifier_SendWithoutWebhook(t *testing.T) {
+	// Neither may panic or block
+	var nilNotifier *Notifier
+	nilNotifier.Send(Event{Kind: KindBackendCrash})
+	New(&config.Config{}).Send(Event{Kind: KindBackendCrash})
+}
+
+func TestNotifier_SendToOwner(t *testing.T) {
+	received := make(chan string, 4)
+	hook := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var e Event
+			json.NewDecoder(r.Body).Decode(&e)
+			if e.Owner != "payments" || len(e.Tags) != 1 || e.Tags[0] != "team-payments" {
+				t.Errorf("%s received %+v, want the site's owner and tags", name, e)
+			}
+			received <- name
+		}))
+	}
+	all, team := hook("all"), hook("team")
+	defer all.Close()
+	defer team.Close()
+
+	n := New(&config.Config{
+		Notify: config.NotifyConfig{WebhookURL: all.URL, OwnerWebhooks: map[string]string{"payments": team.URL}},
+		Site:   map[string]config.SiteConfig{"pay.example.com": {Owner: "payments", Tags: []string{"team-payments"}}},
+	})
+	n.Send(Event{Kind: KindBackendCrash, Site: "pay.example.com", Message: "crashed"})
+
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case name := <-received:
+			got[name] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("webhooks called: %v, want all and team", got)
+		}
+	}
+	if !got["all"] || !got["team"] {
+		t.Errorf("webhooks called: %v, want all and team", got)
+	}
+}
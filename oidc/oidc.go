@@ -0,0 +1,222 @@
+// Package oidc verifies OpenID Connect ID and access tokens (JWTs) against an
+// issuer's published signing keys, so the admin API can accept tokens from the
+// company IdP alongside static keys.
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token errors
+var (
+	ErrMalformed     = errors.New("malformed token")
+	ErrSignature     = errors.New("invalid token signature")
+	ErrExpired       = errors.New("token expired")
+	ErrWrongIssuer   = errors.New("token issuer does not match")
+	ErrWrongAudience = errors.New("token audience does not match")
+)
+
+// leeway allows for clock skew between shipyard and the IdP
+const leeway = time.Minute
+
+// Claims are the verified claims of a token
+type Claims struct {
+	Issuer   string
+	Subject  string
+	Audience []string
+	Expiry   time.Time
+	Email    string
+
+	raw map[string]any
+}
+
+// Strings returns a claim as a list of strings. A single string is returned
+// as a one-element list; other types give nil.
+func (c Claims) Strings(name string) []string {
+	switch v := c.raw[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// Verifier checks tokens from one issuer for one audience
+type Verifier struct {
+	issuer   string
+	audience string
+	keys     *keySet
+	now      func() time.Time
+}
+
+// NewVerifier creates a Verifier. jwksURL may be empty, in which case it is
+// discovered from the issuer's /.well-known/openid-configuration.
+func NewVerifier(issuer, audience, jwksURL string) *Verifier {
+	return &Verifier{
+		issuer:   issuer,
+		audience: audience,
+		keys:     newKeySet(issuer, jwksURL),
+		now:      time.Now,
+	}
+}
+
+var (
+	verifiersMu sync.Mutex
+	verifiers   = map[string]*Verifier{}
+)
+
+// For returns a shared Verifier for the issuer and audience, so every route
+// uses the same cached signing keys
+func For(issuer, audience, jwksURL string) *Verifier {
+	verifiersMu.Lock()
+	defer verifiersMu.Unlock()
+	id := issuer + "\x00" + audience + "\x00" + jwksURL
+	v, ok := verifiers[id]
+	if !ok {
+		v = NewVerifier(issuer, audience, jwksURL)
+		verifiers[id] = v
+	}
+	return v
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks a compact JWT's signature, issuer, audience and validity
+// period and returns its claims
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+
+	var hdr header
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return Claims{}, err
+	}
+	var raw map[string]any
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return Claims{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+
+	key, err := v.keys.get(hdr.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	if err := verifySignature(hdr.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return Claims{}, err
+	}
+
+	claims := Claims{raw: raw}
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Email, _ = raw["email"].(string)
+	claims.Audience = claims.Strings("aud")
+
+	if claims.Issuer != v.issuer {
+		return Claims{}, ErrWrongIssuer
+	}
+	if !contains(claims.Audience, v.audience) {
+		return Claims{}, ErrWrongAudience
+	}
+
+	now := v.now()
+	exp, ok := raw["exp"].(float64)
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: no exp claim", ErrMalformed)
+	}
+	claims.Expiry = time.Unix(int64(exp), 0)
+	if now.After(claims.Expiry.Add(leeway)) {
+		return Claims{}, ErrExpired
+	}
+	if nbf, ok := raw["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return Claims{}, fmt.Errorf("token not valid yet")
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// verifySignature checks an RS* or ES* signature. "none" and HMAC algorithms
+// are refused: the signing keys are public.
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	var h hash.Hash
+	var hashID crypto.Hash
+	switch alg[min(len(alg), 2):] {
+	case "256":
+		h, hashID = sha256.New(), crypto.SHA256
+	case "384":
+		h, hashID = sha512.New384(), crypto.SHA384
+	case "512":
+		h, hashID = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(pub, hashID, digest, sig) != nil {
+			return ErrSignature
+		}
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig)%2 != 0 {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(sig[:len(sig)/2])
+		s := new(big.Int).SetBytes(sig[len(sig)/2:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrSignature
+		}
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
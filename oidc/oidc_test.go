@@ -0,0 +1,157 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testIssuer serves discovery and a JWKS for one RSA and one EC key
+type testIssuer struct {
+	server  *httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	fetches int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": ti.server.URL, "jwks_uri": ti.server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		ti.fetches++
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	})
+	ti.server = httptest.NewServer(mux)
+	t.Cleanup(ti.server.Close)
+	return ti
+}
+
+// sign creates a token with the given algorithm ("RS256" or "ES256") and claims
+func (ti *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := enc(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch alg {
+	case "RS256":
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, ti.rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, ti.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (ti *testIssuer) claims(extra map[string]any) map[string]any {
+	c := map[string]any{
+		"iss":    ti.server.URL,
+		"sub":    "user-1",
+		"aud":    "shipyard",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"email":  "dev@example.com",
+		"groups": []string{"platform", "team-a"},
+	}
+	for k, v := range extra {
+		c[k] = v
+	}
+	return c
+}
+
+func TestVerify_Valid(t *testing.T) {
+	ti := newTestIssuer(t)
+	v := NewVerifier(ti.server.URL, "shipyard", "")
+
+	for _, alg := range []string{"RS256", "ES256"} {
+		kid := map[string]string{"RS256": "rsa1", "ES256": "ec1"}[alg]
+		claims, err := v.Verify(ti.sign(t, alg, kid, ti.claims(nil)))
+		if err != nil {
+			t.Fatalf("%s: Verify() error = %v", alg, err)
+		}
+		if claims.Subject != "user-1" || claims.Email != "dev@example.com" {
+			t.Errorf("%s: claims = %+v", alg, claims)
+		}
+		if groups := claims.Strings("groups"); len(groups) != 2 || groups[1] != "team-a" {
+			t.Errorf("%s: groups = %v", alg, groups)
+		}
+	}
+	if ti.fetches != 1 {
+		t.Errorf("keys fetched %d times, want 1", ti.fetches)
+	}
+}
+
+func TestVerify_Rejects(t *testing.T) {
+	ti := newTestIssuer(t)
+	v := NewVerifier(ti.server.URL, "shipyard", ti.server.URL+"/keys")
+
+	tampered := ti.sign(t, "RS256", "rsa1", ti.claims(nil))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", ti.sign(t, "RS256", "rsa1", ti.claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})), ErrExpired},
+		{"issuer", ti.sign(t, "RS256", "rsa1", ti.claims(map[string]any{"iss": "https://evil.example.com"})), ErrWrongIssuer},
+		{"audience", ti.sign(t, "RS256", "rsa1", ti.claims(map[string]any{"aud": []string{"other"}})), ErrWrongAudience},
+		{"signature", tampered, ErrSignature},
+		{"unknown kid", ti.sign(t, "RS256", "rsa2", ti.claims(nil)), ErrSignature},
+		{"malformed", "not-a-jwt", ErrMalformed},
+	}
+	for _, tt := range tests {
+		if _, err := v.Verify(tt.token); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Algorithm "none" and HMAC are never accepted
+	enc := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	for _, alg := range []string{"none", "HS256"} {
+		token := enc(map[string]string{"alg": alg, "kid": "rsa1"}) + "." + enc(ti.claims(nil)) + "."
+		if _, err := v.Verify(token); err == nil {
+			t.Errorf("alg %s should be refused", alg)
+		}
+	}
+}
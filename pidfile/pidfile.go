@@ -37,13 +37,14 @@ func Create(path string) (*File, error) {
 	return &File{path: path, file: f}, nil
 }
 
-// Close releases the lock and removes the PID file
+// Close releases the lock and removes the PID file. Safe to call more than once.
 func (pf *File) Close() error {
 	if pf.file == nil {
 		return nil
 	}
 	syscall.Flock(int(pf.file.Fd()), syscall.LOCK_UN)
 	pf.file.Close()
+	pf.file = nil
 	os.Remove(pf.path)
 	return nil
 }
@@ -0,0 +1,208 @@
+// Package runner runs the external commands shipyard manages the host with,
+// such as nginx, pot, certbot and the service manager. Every command has a
+// timeout, keeps a bounded amount of output and is logged with how long it
+// took. A hook can stand in for the commands, for dry runs and tests.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lachierussell/shipyard/logger"
+)
+
+const (
+	// DefaultTimeout is how long a command may run when neither its Runner
+	// nor its ctx sets a limit
+	DefaultTimeout = time.Minute
+	// DefaultMaxOutput is how many bytes of combined output Run keeps
+	DefaultMaxOutput = 64 << 10
+	// waitDelay is how long a killed command's children may hold its output
+	// open before Run stops waiting for them
+	waitDelay = 5 * time.Second
+)
+
+// Runner runs commands. The zero value (and a nil Runner) uses the defaults.
+type Runner struct {
+	// Timeout kills a command after this long, unless its ctx has a deadline
+	// of its own. DefaultTimeout when 0.
+	Timeout time.Duration
+	// MaxOutput is how many bytes of combined output Run keeps, from the
+	// end. DefaultMaxOutput when 0.
+	MaxOutput int
+	// Env is added to shipyard's environment for each command
+	Env []string
+}
+
+// Hook runs in place of every command while it is set, returning the
+// command's output. ctx has the deadline the command would have had.
+type Hook func(ctx context.Context, name string, args []string) ([]byte, error)
+
+var (
+	hookMu sync.RWMutex
+	hook   Hook
+)
+
+// SetHook makes h run in place of every command, and returns a function that
+// puts back the previous hook. A nil h runs commands again.
+func SetHook(h Hook) (restore func()) {
+	hookMu.Lock()
+	defer hookMu.Unlock()
+	previous := hook
+	hook = h
+	return func() { SetHook(previous) }
+}
+
+// currentHook returns the hook set with SetHook
+func currentHook() Hook {
+	hookMu.RLock()
+	defer hookMu.RUnlock()
+	return hook
+}
+
+// DryRun is a Hook that logs each command instead of running it
+func DryRun(ctx context.Context, name string, args []string) ([]byte, error) {
+	logger.FromContext(ctx).Info("dry run: command not run", "command", commandLine(name, args))
+	return nil, nil
+}
+
+// Run runs a command and returns its combined output, trimmed to the last
+// MaxOutput bytes
+func (r *Runner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return r.RunInput(ctx, nil, name, args...)
+}
+
+// RunInput is Run with stdin as the command's standard input. A hook is not
+// given stdin.
+func (r *Runner) RunInput(ctx context.Context, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	out := &tailBuffer{max: r.maxOutput()}
+	err := r.run(ctx, name, args, stdin, out, out)
+	return out.Bytes(), err
+}
+
+// Output runs a command and returns its standard output in full, for
+// commands whose output is parsed. A failure's error ends with the tail of
+// its standard error.
+func (r *Runner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout bytes.Buffer
+	stderr := &tailBuffer{max: r.maxOutput()}
+	err := r.run(ctx, name, args, nil, &stdout, stderr)
+	if err != nil {
+		if msg := strings.TrimSpace(string(stderr.Bytes())); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+	}
+	return stdout.Bytes(), err
+}
+
+// run runs a command, or the hook in its place, and logs how it went
+func (r *Runner) run(ctx context.Context, name string, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	log := logger.FromContext(ctx)
+	line := commandLine(name, args)
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout())
+		defer cancel()
+	}
+
+	if h := currentHook(); h != nil {
+		out, err := h(ctx, name, args)
+		stdout.Write(out)
+		return err
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	if r != nil && len(r.Env) > 0 {
+		cmd.Env = append(os.Environ(), r.Env...)
+	}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = waitDelay
+
+	start := time.Now()
+	err := cmd.Run()
+	elapsed := time.Since(start)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Warn("command timed out", "command", line, "duration_ms", elapsed.Milliseconds())
+		return fmt.Errorf("timed out after %s: %w", elapsed.Round(time.Second), err)
+	}
+	if err != nil {
+		log.Debug("command failed", "command", line, "duration_ms", elapsed.Milliseconds(), "error", err)
+		return err
+	}
+	log.Debug("command finished", "command", line, "duration_ms", elapsed.Milliseconds())
+	return nil
+}
+
+// timeout returns how long a command may run
+func (r *Runner) timeout() time.Duration {
+	if r == nil || r.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return r.Timeout
+}
+
+// maxOutput returns how many bytes of output Run keeps
+func (r *Runner) maxOutput() int {
+	if r == nil || r.MaxOutput <= 0 {
+		return DefaultMaxOutput
+	}
+	return r.MaxOutput
+}
+
+// Run runs a command with the default Runner
+func Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return (*Runner)(nil).Run(ctx, name, args...)
+}
+
+// Output runs a command with the default Runner, returning its standard output
+func Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return (*Runner)(nil).Output(ctx, name, args...)
+}
+
+// commandLine returns a command as it would be typed, for logs
+func commandLine(name string, args []string) string {
+	return strings.Join(append([]string{name}, args...), " ")
+}
+
+// tailBuffer keeps the last max bytes written to it. A max of 0 keeps all.
+type tailBuffer struct {
+	buf     []byte
+	max     int
+	dropped int
+}
+
+// Write appends p, dropping the oldest bytes beyond max
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	// Trim in batches so long output isn't copied on every write
+	if b.max > 0 && len(b.buf) > 2*b.max {
+		cut := len(b.buf) - b.max
+		b.dropped += cut
+		b.buf = append(b.buf[:0], b.buf[cut:]...)
+	}
+	return len(p), nil
+}
+
+// Bytes returns what was kept, noting how much came before it
+func (b *tailBuffer) Bytes() []byte {
+	out := b.buf
+	dropped := b.dropped
+	if b.max > 0 && len(out) > b.max {
+		dropped += len(out) - b.max
+		out = out[len(out)-b.max:]
+	}
+	if dropped == 0 {
+		return out
+	}
+	return append([]byte(fmt.Sprintf("[%d bytes of output truncated]\n", dropped)), out...)
+}
@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun_KeepsTailOfOutput(t *testing.T) {
+	r := &Runner{MaxOutput: 10}
+	out, err := r.Run(context.Background(), "sh", "-c", "printf 0123456789; printf abcdefghij >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[10 bytes of output truncated]\nabcdefghij"; string(out) != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestRun_KilledAfterTimeout(t *testing.T) {
+	r := &Runner{Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err := r.Run(context.Background(), "sleep", "10")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %s to kill", elapsed)
+	}
+}
+
+func TestOutput_ErrorHasStderr(t *testing.T) {
+	out, err := Output(context.Background(), "sh", "-c", "echo parsed; echo broken >&2; exit 3")
+	if string(out) != "parsed\n" {
+		t.Errorf("output = %q", out)
+	}
+	if err == nil || !strings.HasSuffix(err.Error(), ": broken") {
+		t.Errorf("err = %v, want it to end with stderr", err)
+	}
+}
+
+func TestRun_Env(t *testing.T) {
+	r := &Runner{Env: []string{"SHIPYARD_RUNNER_TEST=set"}}
+	out, err := r.Run(context.Background(), "sh", "-c", "printf %s \"$SHIPYARD_RUNNER_TEST\"")
+	if err != nil || string(out) != "set" {
+		t.Errorf("Run = %q, %v", out, err)
+	}
+}
+
+func TestRunInput(t *testing.T) {
+	out, err := (&Runner{}).RunInput(context.Background(), strings.NewReader("pass out\n"), "cat")
+	if err != nil || string(out) != "pass out\n" {
+		t.Errorf("RunInput = %q, %v", out, err)
+	}
+}
+
+func TestSetHook(t *testing.T) {
+	var deadlines []time.Duration
+	restore := SetHook(func(ctx context.Context, name string, args []string) ([]byte, error) {
+		if name != "nginx" || len(args) != 1 || args[0] != "-t" {
+			t.Errorf("hook got %s %v", name, args)
+		}
+		deadline, _ := ctx.Deadline()
+		deadlines = append(deadlines, time.Until(deadline))
+		return []byte("faked"), errors.New("failed")
+	})
+
+	r := &Runner{Timeout: time.Minute}
+	out, err := r.Run(context.Background(), "nginx", "-t")
+	if string(out) != "faked" || err == nil {
+		t.Errorf("Run = %q, %v; want the hook's result", out, err)
+	}
+	// A deadline of the caller's own wins over Timeout
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	r.Run(ctx, "nginx", "-t")
+	if len(deadlines) != 2 || deadlines[0] > time.Minute || deadlines[1] < 30*time.Minute {
+		t.Errorf("deadlines = %v, want Timeout then the caller's hour", deadlines)
+	}
+
+	restore()
+	if currentHook() != nil {
+		t.Error("restore left the hook set")
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 4}
+	for i := 0; i < 100; i++ {
+		b.Write([]byte("ab"))
+	}
+	if got := b.Bytes(); !bytes.HasSuffix(got, []byte("\nabab")) || !bytes.HasPrefix(got, []byte("[196 bytes")) {
+		t.Errorf("Bytes = %q", got)
+	}
+	if len(b.buf) > 2*b.max {
+		t.Errorf("kept %d bytes, want at most %d", len(b.buf), 2*b.max)
+	}
+}
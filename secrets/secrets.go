@@ -0,0 +1,117 @@
+// Package secrets resolves the secret://<name> values of backend env entries,
+// so secrets are looked up when a backend starts instead of being kept in
+// shipyard.toml.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+// commandTimeout bounds how long the command provider may take per secret
+const commandTimeout = 30 * time.Second
+
+// Provider looks up secrets by name
+type Provider interface {
+	Get(name string) (string, error)
+}
+
+// NewProvider returns the provider configured by [secrets]
+func NewProvider(cfg config.SecretsConfig) (Provider, error) {
+	switch cfg.ProviderName() {
+	case config.SecretsFile:
+		return fileProvider{dir: cfg.SecretsDir()}, nil
+	case config.SecretsEnv:
+		return envProvider{prefix: cfg.Prefix()}, nil
+	case config.SecretsCommand:
+		if len(cfg.Command) == 0 {
+			return nil, fmt.Errorf("secrets.command is not set")
+		}
+		return commandProvider{argv: cfg.Command}, nil
+	}
+	return nil, fmt.Errorf("unknown secrets provider %q", cfg.Provider)
+}
+
+// fileProvider reads each secret from a file named after it
+type fileProvider struct {
+	dir string
+}
+
+func (p fileProvider) Get(name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(p.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// envProvider reads each secret from shipyard's environment. The name is
+// upper-cased, and its slashes, dots and dashes become underscores.
+type envProvider struct {
+	prefix string
+}
+
+func (p envProvider) Get(name string) (string, error) {
+	key := p.prefix + strings.ToUpper(strings.NewReplacer("/", "_", ".", "_", "-", "_").Replace(name))
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("%s is not set", key)
+	}
+	return value, nil
+}
+
+// commandProvider runs a command, such as a vault or sops wrapper, with the
+// secret's name as its last argument and reads the value from its output
+type commandProvider struct {
+	argv []string
+}
+
+func (p commandProvider) Get(name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.argv[0], append(p.argv[1:], name)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// Resolve returns env with each secret://<name> value replaced by the
+// secret's value. Errors name the variable and secret, never a value.
+func Resolve(p Provider, env map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(env))
+	for key, value := range env {
+		name, ok := strings.CutPrefix(value, config.SecretScheme)
+		if !ok {
+			resolved[key] = value
+			continue
+		}
+		if !config.ValidSecretName(name) {
+			return nil, fmt.Errorf("%s: invalid secret name %q", key, name)
+		}
+		secret, err := p.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: secret %q: %w", key, name, err)
+		}
+		if strings.ContainsAny(secret, "\n\x00") {
+			return nil, fmt.Errorf("%s: secret %q must be a single line", key, name)
+		}
+		resolved[key] = secret
+	}
+	return resolved, nil
+}
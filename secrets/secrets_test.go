@@ -0,0 +1,83 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestResolve_File(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "app"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app", "db-password"), []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewProvider(config.SecretsConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Resolve(p, map[string]string{
+		"DB_PASSWORD": "secret://app/db-password",
+		"LOG_LEVEL":   "debug",
+	})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got["DB_PASSWORD"] != "hunter2" || got["LOG_LEVEL"] != "debug" {
+		t.Errorf("Resolve = %v", got)
+	}
+
+	if _, err := Resolve(p, map[string]string{"API_KEY": "secret://app/missing"}); err == nil || !strings.Contains(err.Error(), "API_KEY") {
+		t.Errorf("missing secret: err = %v, want one naming API_KEY", err)
+	}
+	if _, err := Resolve(p, map[string]string{"X": "secret://../etc/passwd"}); err == nil {
+		t.Error("expected an error for a name leaving the secrets directory")
+	}
+}
+
+func TestResolve_Env(t *testing.T) {
+	t.Setenv("SHIPYARD_SECRET_APP_TOKEN", "abc")
+
+	p, err := NewProvider(config.SecretsConfig{Provider: config.SecretsEnv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Resolve(p, map[string]string{"TOKEN": "secret://app/token"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got["TOKEN"] != "abc" {
+		t.Errorf("TOKEN = %q, want abc", got["TOKEN"])
+	}
+}
+
+func TestResolve_Command(t *testing.T) {
+	p, err := NewProvider(config.SecretsConfig{
+		Provider: config.SecretsCommand,
+		Command:  []string{"sh", "-c", `echo "value-of-$1"`, "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Resolve(p, map[string]string{"KEY": "secret://api"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got["KEY"] != "value-of-api" {
+		t.Errorf("KEY = %q, want value-of-api", got["KEY"])
+	}
+
+	fail, _ := NewProvider(config.SecretsConfig{
+		Provider: config.SecretsCommand,
+		Command:  []string{"sh", "-c", "echo denied >&2; exit 1", "secret"},
+	})
+	if _, err := Resolve(fail, map[string]string{"KEY": "secret://api"}); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Errorf("failing command: err = %v, want its stderr", err)
+	}
+}
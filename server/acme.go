@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/ssl"
+)
+
+// ACMEChallenge serves HTTP-01 challenge responses written by certbot to the ACME webroot
+func (s *Server) ACMEChallenge(c *fiber.Ctx) error {
+	body, err := ssl.ChallengeResponse(c.Params("token"))
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMETextPlain)
+	return c.Send(body)
+}
+
+// ListenACME starts the plain-HTTP challenge listener used when ssl.acme_listen_addr is set.
+// nginx's catch-all server forwards challenges for domains without a server block here.
+func (s *Server) ListenACME(addr string) error {
+	return s.acmeApp.Listen(addr)
+}
+
+// stopACME closes the challenge listener. Safe to call more than once.
+func (s *Server) stopACME() {
+	if s.acmeApp != nil {
+		s.acmeOnce.Do(func() { s.acmeApp.Shutdown() })
+	}
+}
+
+// acmeEnabled reports whether shipyard answers ACME challenges itself
+func (s *Server) acmeEnabled() bool {
+	return s.cfg.SSL.ACMEListenAddr != ""
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/nginx"
+)
+
+// ApplyRequest is the JSON body for POST /apply: the sites shipyard should be
+// running, keyed by domain
+type ApplyRequest struct {
+	Sites map[string]DesiredSite `json:"sites"`
+	// Plan reports the steps without changing anything
+	Plan bool `json:"plan"`
+	// Prune destroys sites missing from Sites; without it they are left alone
+	Prune bool `json:"prune"`
+}
+
+// DesiredSite is the declared state of one site. Omitted fields take the same
+// defaults as POST /site/create; an omitted frontend_root keeps the current one.
+type DesiredSite struct {
+	FrontendRoot     string          `json:"frontend_root,omitempty"`
+	SSLEnabled       bool            `json:"ssl_enabled"`
+	Aliases          []string        `json:"aliases,omitempty"`
+	OverrideIPs      []string        `json:"override_ips,omitempty"`
+	QuotaMB          int             `json:"quota_mb,omitempty"`
+	RequireSignature bool            `json:"require_signature,omitempty"`
+	ACMEEmail        string          `json:"acme_email,omitempty"`
+	StagingOf        string          `json:"staging_of,omitempty"`
+	Tags             []string        `json:"tags,omitempty"`
+	Owner            string          `json:"owner,omitempty"`
+	Description      string          `json:"description,omitempty"`
+	Backend          *DesiredBackend `json:"backend,omitempty"`
+}
+
+// DesiredBackend is the declared backend of a site
+type DesiredBackend struct {
+	ListenPort int    `json:"listen_port,omitempty"`
+	ProxyPath  string `json:"proxy_path,omitempty"`
+	Protocol   string `json:"protocol,omitempty"`
+	WebSocket  bool   `json:"websocket,omitempty"`
+}
+
+// Apply actions
+const (
+	applyCreate  = "create"
+	applyUpdate  = "update"
+	applyDestroy = "destroy"
+)
+
+// applyStep is one change in an apply plan
+type applyStep struct {
+	Action  string   `json:"action"`
+	Site    string   `json:"site"`
+	Changes []string `json:"changes,omitempty"`
+
+	// Set once the step has run
+	Status string `json:"status,omitempty"` // "done" or "failed"
+	Error  string `json:"error,omitempty"`
+	APIKey string `json:"api_key,omitempty"` // created sites only
+	Note   string `json:"note,omitempty"`
+
+	desired DesiredSite
+}
+
+// Apply converges the sites to a desired-state document: missing sites are
+// created, differing ones updated and, with prune, sites not in the document
+// destroyed. With plan set it only reports the steps it would take.
+func (s *Server) Apply(c *fiber.Ctx) error {
+	log := reqLog(c)
+
+	var req ApplyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse JSON body")
+	}
+	if req.Sites == nil {
+		return sendError(c, errInvalidRequest, "sites is required; send {} to declare no sites")
+	}
+
+	steps, apiErr, detail := s.planApply(req, func(site string) bool { return requestAllowsSite(c, site) })
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
+	}
+	if steps == nil {
+		steps = []*applyStep{}
+	}
+
+	if req.Plan {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"status": "planned",
+			"steps":  steps,
+		})
+	}
+
+	log.Info("apply started", "steps", len(steps), "prune", req.Prune)
+	for _, step := range steps {
+		stepLog := log.With("site", step.Site, "action", step.Action)
+		if err := s.runStep(stepLog, step); err != nil {
+			step.Status = "failed"
+			step.Error = err.Error()
+			stepLog.Error("apply step failed", "error", err)
+			return sendErrorWith(c, errApplyFailed, fmt.Sprintf("%s %s: %v", step.Action, step.Site, err), fiber.Map{"steps": steps})
+		}
+		step.Status = "done"
+		stepLog.Info("apply step done", "changes", step.Changes)
+	}
+
+	log.Info("apply completed", "steps", len(steps))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "applied",
+		"steps":  steps,
+	})
+}
+
+// planApply compares the desired sites with the config. Destroys come first so
+// their jails and names are free for the creates. Sites that allowed rejects
+// are never pruned, and naming one in the document is an error.
+func (s *Server) planApply(req ApplyRequest, allowed func(site string) bool) ([]*applyStep, *APIError, string) {
+	var steps []*applyStep
+
+	if req.Prune {
+		for _, name := range sortedNames(s.cfg.Site) {
+			if _, keep := req.Sites[name]; !keep && allowed(name) {
+				steps = append(steps, &applyStep{Action: applyDestroy, Site: name})
+			}
+		}
+	}
+
+	for _, name := range sortedNames(req.Sites) {
+		desired := req.Sites[name]
+		if !allowed(name) {
+			return nil, errSiteNotAllowed, name
+		}
+		protocol := ""
+		if desired.Backend != nil {
+			protocol = desired.Backend.Protocol
+		}
+		if apiErr, detail := s.validateNewSite(name, desired.Backend != nil, desired.SSLEnabled, protocol); apiErr != nil {
+			return nil, apiErr, name + ": " + detail
+		}
+		if desired.ACMEEmail != "" && !strings.Contains(desired.ACMEEmail, "@") {
+			return nil, errInvalidRequest, name + ": acme_email is not an email address"
+		}
+		meta := config.SiteConfig{Tags: desired.Tags, Owner: desired.Owner, Description: desired.Description}
+		if err := meta.ValidateMetadata(); err != nil {
+			return nil, errInvalidRequest, name + ": " + err.Error()
+		}
+		if prod := desired.StagingOf; prod != "" {
+			_, declared := req.Sites[prod]
+			_, exists := s.cfg.Site[prod]
+			if prod == name || !(declared || exists) {
+				return nil, errInvalidRequest, name + ": staging_of must name another site"
+			}
+		}
+
+		current, exists := s.cfg.Site[name]
+		if !exists {
+			steps = append(steps, &applyStep{Action: applyCreate, Site: name, desired: desired})
+			continue
+		}
+		changes, err := siteChanges(current, desired)
+		if err != nil {
+			return nil, errInvalidDesiredState, name + ": " + err.Error()
+		}
+		if len(changes) > 0 {
+			steps = append(steps, &applyStep{Action: applyUpdate, Site: name, Changes: changes, desired: desired})
+		}
+	}
+	return steps, nil, ""
+}
+
+// sortedNames returns the keys of sites in order
+func sortedNames[T any](sites map[string]T) []string {
+	names := make([]string, 0, len(sites))
+	for name := range sites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withDesired returns site with the desired state applied. Settings the
+// document doesn't cover (API key, canary, experiment, TLS overrides, the
+// backend's jail) are kept.
+func withDesired(site config.SiteConfig, desired DesiredSite) config.SiteConfig {
+	if desired.FrontendRoot != "" {
+		site.FrontendRoot = desired.FrontendRoot
+	}
+	site.SSLEnabled = desired.SSLEnabled
+	site.Aliases = desired.Aliases
+	site.OverrideIPs = desired.OverrideIPs
+	site.QuotaMB = desired.QuotaMB
+	site.RequireSignature = desired.RequireSignature
+	site.ACMEEmail = desired.ACMEEmail
+	site.StagingOf = desired.StagingOf
+	site.Tags = desired.Tags
+	site.Owner = desired.Owner
+	site.Description = desired.Description
+
+	if desired.Backend == nil {
+		site.Backend = nil
+		return site
+	}
+	var backend config.BackendConfig
+	if site.Backend != nil {
+		backend = *site.Backend
+	}
+	backend.ListenPort = desired.Backend.ListenPort
+	if backend.ListenPort == 0 {
+		backend.ListenPort = 8080
+	}
+	backend.ProxyPath = desired.Backend.ProxyPath
+	if backend.ProxyPath == "" {
+		backend.ProxyPath = "/api"
+	}
+	backend.Protocol = desired.Backend.Protocol
+	backend.WebSocket = desired.Backend.WebSocket
+	site.Backend = &backend
+	return site
+}
+
+// siteChanges lists the settings that differ between an existing site and its
+// desired state, or returns an error for changes apply can't make
+func siteChanges(current config.SiteConfig, desired DesiredSite) ([]string, error) {
+	if desired.FrontendRoot != "" && desired.FrontendRoot != current.FrontendRoot {
+		return nil, fmt.Errorf("frontend_root can't change on an existing site (is %q)", current.FrontendRoot)
+	}
+	if current.IsBackendOnly() && desired.Backend == nil {
+		return nil, fmt.Errorf("backend-only sites need a backend")
+	}
+
+	target := withDesired(current, desired)
+	var changes []string
+	if target.SSLEnabled != current.SSLEnabled {
+		changes = append(changes, "ssl_enabled")
+	}
+	if !slices.Equal(target.Aliases, current.Aliases) {
+		changes = append(changes, "aliases")
+	}
+	if !slices.Equal(target.OverrideIPs, current.OverrideIPs) {
+		changes = append(changes, "override_ips")
+	}
+	if target.QuotaMB != current.QuotaMB {
+		changes = append(changes, "quota_mb")
+	}
+	if target.RequireSignature != current.RequireSignature {
+		changes = append(changes, "require_signature")
+	}
+	if target.ACMEEmail != current.ACMEEmail {
+		changes = append(changes, "acme_email")
+	}
+	if target.StagingOf != current.StagingOf {
+		changes = append(changes, "staging_of")
+	}
+	if !slices.Equal(target.Tags, current.Tags) {
+		changes = append(changes, "tags")
+	}
+	if target.Owner != current.Owner {
+		changes = append(changes, "owner")
+	}
+	if target.Description != current.Description {
+		changes = append(changes, "description")
+	}
+
+	switch {
+	case current.Backend == nil && target.Backend != nil:
+		changes = append(changes, "backend added")
+	case current.Backend != nil && target.Backend == nil:
+		changes = append(changes, "backend removed")
+	case current.Backend != nil:
+		if target.Backend.ListenPort != current.Backend.ListenPort {
+			changes = append(changes, "backend.listen_port")
+		}
+		if target.Backend.ProxyPath != current.Backend.ProxyPath {
+			changes = append(changes, "backend.proxy_path")
+		}
+		if target.Backend.Protocol != current.Backend.Protocol {
+			changes = append(changes, "backend.protocol")
+		}
+		if target.Backend.WebSocket != current.Backend.WebSocket {
+			changes = append(changes, "backend.websocket")
+		}
+	}
+	return changes, nil
+}
+
+// runStep carries out one step of a plan
+func (s *Server) runStep(log *slog.Logger, step *applyStep) error {
+	switch step.Action {
+	case applyDestroy:
+		site, ok := s.cfg.Site[step.Site]
+		if !ok {
+			return nil // already gone
+		}
+		// Pruning never deletes volume data; destroy the site to purge it
+		if !s.destroySite(log, step.Site, site, false) {
+			return fmt.Errorf("site torn down but not removed from the config")
+		}
+		return nil
+
+	case applyCreate:
+		var backend *config.BackendConfig
+		if step.desired.Backend != nil {
+			backend = &config.BackendConfig{}
+		}
+		site, err := s.newSiteConfig(step.Site, step.desired.FrontendRoot, step.desired.SSLEnabled, backend)
+		if err != nil {
+			return fmt.Errorf("generate API key: %w", err)
+		}
+		site = withDesired(site, step.desired)
+		if _, apiErr, detail := s.provisionSite(log, step.Site, site); apiErr != nil {
+			return fmt.Errorf("%s: %s", apiErr.Message, detail)
+		}
+		step.APIKey = site.APIKey
+		if site.HasFrontend() && !config.IsWildcardDomain(step.Site) {
+			step.Note = "run /site/init or deploy with an nginx_config to serve the frontend"
+		}
+		return nil
+
+	case applyUpdate:
+		return s.updateSite(log, step)
+	}
+	return fmt.Errorf("unknown action %q", step.Action)
+}
+
+// updateSite applies a step's desired state to an existing site and
+// regenerates its nginx config when shipyard generated it
+func (s *Server) updateSite(log *slog.Logger, step *applyStep) error {
+	current, ok := s.cfg.Site[step.Site]
+	if !ok {
+		return fmt.Errorf("site no longer exists")
+	}
+	target := withDesired(current, step.desired)
+
+	if target.Backend != nil && target.Backend.JailName == "" {
+		target.Backend.JailName = step.Site
+		target.Backend.JailIP = s.cfg.NextJailIP()
+		target.Backend.BinaryName = step.Site
+	}
+
+	// Get the certificate before the config claims SSL is on
+	if target.SSLEnabled && !current.SSLEnabled {
+		if err := s.sslMgr.ObtainCert(logContext(log), step.Site, target.ACMEEmail); err != nil {
+			return fmt.Errorf("obtain certificate: %w", err)
+		}
+	}
+
+	if current.Backend != nil && target.Backend == nil {
+		log.Info("removing backend")
+		s.removeBackend(log, step.Site)
+	}
+
+	if err := s.cfg.UpdateSite(step.Site, target); err != nil {
+		return fmt.Errorf("save config: %w", err)
+	}
+
+	var nginxConfig string
+	raw := false
+	switch {
+	case target.IsBackendOnly():
+		nginxConfig, raw = s.backendProxyConfig(step.Site, target), true
+	case config.IsWildcardDomain(step.Site):
+		nginxConfig = nginx.GenerateWildcardConfig(step.Site, target, s.cfg)
+	default:
+		// The site's nginx config came from the user; it is re-rendered with
+		// the new settings on the next deploy
+		step.Note = "deploy the frontend with its nginx_config to apply the changes to nginx"
+		return nil
+	}
+
+	deploySiteConfig := s.nginxMgr.DeploySiteConfig
+	if raw {
+		deploySiteConfig = s.nginxMgr.DeploySiteConfigRaw
+	}
+	reloaded, nginxErr, err := deploySiteConfig(logContext(log), step.Site, nginxConfig)
+	if err != nil {
+		return fmt.Errorf("deploy nginx config: %w", err)
+	}
+	if !reloaded {
+		return fmt.Errorf("nginx config invalid: %s", nginxErr)
+	}
+	return nil
+}
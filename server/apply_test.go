@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func applyTestConfig() *config.Config {
+	return &config.Config{
+		AdminKeys: []string{"sk-admin", "sk-team-a"},
+		KeyACL:    map[string][]string{"sk-team-a": {"*.team-a.example.com"}},
+		Site: map[string]config.SiteConfig{
+			"www.example.com": {FrontendRoot: "/var/www/www", APIKey: "sk-www"},
+			"api.example.com": {APIKey: "sk-api", Backend: &config.BackendConfig{
+				JailName: "api.example.com", JailIP: "127.0.1.2", ListenPort: 8080, ProxyPath: "/api",
+			}},
+			"old.example.com": {FrontendRoot: "/var/www/old", APIKey: "sk-old"},
+		},
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	srv := testServer(applyTestConfig())
+	all := func(string) bool { return true }
+
+	req := ApplyRequest{
+		Prune: true,
+		Sites: map[string]DesiredSite{
+			"www.example.com": {Aliases: []string{"example.com"}, QuotaMB: 500},
+			"api.example.com": {Backend: &DesiredBackend{ListenPort: 9000}},
+			"new.example.com": {SSLEnabled: true},
+		},
+	}
+	steps, apiErr, detail := srv.planApply(req, all)
+	if apiErr != nil {
+		t.Fatalf("planApply() error = %s: %s", apiErr.Code, detail)
+	}
+
+	var got []string
+	for _, step := range steps {
+		got = append(got, step.Action+" "+step.Site+" "+strings.Join(step.Changes, ","))
+	}
+	want := []string{
+		"destroy old.example.com ",
+		"update api.example.com backend.listen_port",
+		"create new.example.com ",
+		"update www.example.com aliases,quota_mb",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("plan =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Without prune, undeclared sites are left alone; matching sites need no step
+	req = ApplyRequest{Sites: map[string]DesiredSite{"www.example.com": {}}}
+	if steps, _, _ := srv.planApply(req, all); len(steps) != 0 {
+		t.Errorf("plan = %+v, want no steps", steps)
+	}
+}
+
+func TestPlanApply_Refuses(t *testing.T) {
+	srv := testServer(applyTestConfig())
+	all := func(string) bool { return true }
+
+	tests := []struct {
+		name  string
+		sites map[string]DesiredSite
+		want  *APIError
+	}{
+		{"frontend root change", map[string]DesiredSite{"www.example.com": {FrontendRoot: "/srv/www"}}, errInvalidDesiredState},
+		{"backend-only without backend", map[string]DesiredSite{"api.example.com": {}}, errInvalidDesiredState},
+		{"invalid domain", map[string]DesiredSite{"Bad_Domain": {}}, errInvalidDomain},
+		{"wildcard backend", map[string]DesiredSite{"*.docs.example.com": {Backend: &DesiredBackend{}}}, errInvalidRequest},
+		{"protocol", map[string]DesiredSite{"new.example.com": {Backend: &DesiredBackend{Protocol: "ftp"}}}, errInvalidRequest},
+	}
+	for _, tt := range tests {
+		if _, apiErr, _ := srv.planApply(ApplyRequest{Sites: tt.sites}, all); apiErr != tt.want {
+			t.Errorf("%s: error = %v, want %s", tt.name, apiErr, tt.want.Code)
+		}
+	}
+}
+
+func TestApply_Plan(t *testing.T) {
+	cfg := applyTestConfig()
+	srv := testServer(cfg)
+
+	app := fiber.New()
+	app.Post("/apply", AdminListAuth(cfg), srv.Apply)
+
+	post := func(key, body string) (int, map[string]any) {
+		req := httptest.NewRequest("POST", "/apply", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Shipyard-Key", key)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		var result map[string]any
+		json.Unmarshal(data, &result)
+		return resp.StatusCode, result
+	}
+
+	status, result := post("sk-admin", `{"plan": true, "prune": true, "sites": {"www.example.com": {}}}`)
+	if status != 200 || result["status"] != "planned" {
+		t.Fatalf("status = %d, body = %v", status, result)
+	}
+	if steps, _ := result["steps"].([]any); len(steps) != 2 {
+		t.Errorf("steps = %v, want 2 destroys", result["steps"])
+	}
+	if len(cfg.Site) != 3 {
+		t.Errorf("plan changed the config: %v", cfg.Site)
+	}
+
+	if status, _ := post("sk-admin", `{"plan": true}`); status != 400 {
+		t.Errorf("missing sites: status = %d, want 400", status)
+	}
+
+	// A key limited to team A can't declare other sites, and prune leaves them alone
+	if status, _ := post("sk-team-a", `{"plan": true, "sites": {"www.example.com": {}}}`); status != 403 {
+		t.Errorf("scoped key, other site: status = %d, want 403", status)
+	}
+	status, result = post("sk-team-a", `{"plan": true, "prune": true, "sites": {"docs.team-a.example.com": {}}}`)
+	if status != 200 {
+		t.Fatalf("scoped key: status = %d, body = %v", status, result)
+	}
+	if steps, _ := result["steps"].([]any); len(steps) != 1 || steps[0].(map[string]any)["action"] != "create" {
+		t.Errorf("scoped key steps = %v, want one create", result["steps"])
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/notify"
+)
+
+// ApproveDeploy handles POST /deploys/:id/approve: an admin other than the
+// one who requested a deploy to a site with require_approval releases it.
+// It then runs straight away, or at its run_at.
+func (s *Server) ApproveDeploy(c *fiber.Ctx) error {
+	j, ok := s.jobs.get(c.Params("id"))
+	if !ok || !requestAllowsSite(c, j.Site) || (c.Query("site") != "" && c.Query("site") != j.Site) {
+		return sendError(c, errJobNotFound, "")
+	}
+
+	approver := requestInitiator(c, s.cfg, j.Site)
+	d, apiErr, detail := s.schedule.approve(j.ID, approver, time.Now())
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
+	}
+	s.jobs.approve(j.ID, approver)
+	reqLog(c).Info("deploy approved", "site", d.Site, "commit", d.Commit, "job_id", d.ID, "requested_by", d.Initiator, "approved_by", approver)
+
+	j, _ = s.jobs.get(j.ID)
+	return c.JSON(fiber.Map{
+		"status": "approved",
+		"job":    j,
+	})
+}
+
+// requestApproval announces a deploy waiting for approval
+func (s *Server) requestApproval(log *slog.Logger, d scheduledDeploy) {
+	log.Info("deploy waiting for approval", "job_id", d.ID, "requested_by", d.Initiator, "expires", d.Approval.Expires)
+	s.notifier.Send(notify.Event{
+		Kind:    notify.KindApprovalRequested,
+		Site:    d.Site,
+		Message: d.Operation + " of " + d.Commit + " by " + d.Initiator + " is waiting for approval",
+		Details: approvalDetails(d),
+	})
+}
+
+// expireApproval fails a deploy that was not approved in time
+func (s *Server) expireApproval(j *job, d scheduledDeploy) {
+	j.log.Warn("deploy approval expired", "requested_by", d.Initiator)
+	s.jobs.finish(j, errorResult(errApprovalExpired, ""))
+	s.notifier.Send(notify.Event{
+		Kind:    notify.KindApprovalExpired,
+		Site:    d.Site,
+		Message: d.Operation + " of " + d.Commit + " by " + d.Initiator + " was not approved in time",
+		Details: approvalDetails(d),
+	})
+}
+
+// approvalDetails is the detail of approval notifications
+func approvalDetails(d scheduledDeploy) fiber.Map {
+	details := fiber.Map{
+		"job_id":       d.ID,
+		"operation":    d.Operation,
+		"commit":       d.Commit,
+		"requested_by": d.Initiator,
+		"expires":      d.Approval.Expires,
+		"approve":      "POST /deploys/" + d.ID + "/approve",
+	}
+	if !d.RunAt.IsZero() {
+		details["run_at"] = d.RunAt
+	}
+	return details
+}
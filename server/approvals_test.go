@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/artifact"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestApproveDeploy(t *testing.T) {
+	srv := testServer(&config.Config{
+		Site: map[string]config.SiteConfig{
+			"example.com": {FrontendRoot: t.TempDir(), APIKey: "sk-site", RequireApproval: true},
+		},
+	})
+	srv.artifacts = artifact.NewStore(t.TempDir(), 5)
+	srv.jobs = newJobQueue()
+	srv.ops = newOpTracker()
+	srv.schedule = newDeploySchedule(scheduledDeploysPath(t.TempDir()))
+	if _, err := srv.artifacts.Put(artifact.Meta{Site: "example.com", Kind: artifact.KindFrontend, Commit: "abc1234"}, strings.NewReader("zip bytes")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	admin := func(c *fiber.Ctx) error {
+		c.Locals("admin_key", strings.HasPrefix(c.Get("X-Shipyard-Key"), "sk-admin"))
+		return c.Next()
+	}
+	app := fiber.New()
+	app.Post("/deploy/redeploy", admin, srv.Redeploy)
+	app.Post("/deploys/:id/approve", admin, srv.ApproveDeploy)
+
+	redeploy := func(key string) string {
+		t.Helper()
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("site", "example.com")
+		writer.WriteField("commit", "abc1234")
+		writer.Close()
+		req := httptest.NewRequest("POST", "/deploy/redeploy", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Shipyard-Key", key)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		var result struct {
+			Status string    `json:"status"`
+			Job    jobStatus `json:"job"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		if resp.StatusCode != fiber.StatusAccepted || result.Status != "pending_approval" || result.Job.State != jobPendingApproval {
+			t.Fatalf("redeploy = %d %+v, want 202 pending_approval", resp.StatusCode, result)
+		}
+		return result.Job.ID
+	}
+	approve := func(id, key string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/deploys/"+id+"/approve", nil)
+		req.Header.Set("X-Shipyard-Key", key)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		var result map[string]any
+		json.NewDecoder(resp.Body).Decode(&result)
+		code, _ := result["error"].(string)
+		return resp.StatusCode, code
+	}
+
+	// Requested by one admin, approved by another
+	id := redeploy("sk-admin-1")
+	if status, code := approve(id, "sk-admin-1"); status != fiber.StatusForbidden || code != "self_approval" {
+		t.Errorf("self approval = %d %s, want 403 self_approval", status, code)
+	}
+	if status, code := approve(id, "sk-admin-2"); status != fiber.StatusOK {
+		t.Fatalf("approval = %d %s", status, code)
+	}
+	if status, code := approve(id, "sk-admin-2"); status != fiber.StatusConflict || code != "not_pending_approval" {
+		t.Errorf("second approval = %d %s, want 409 not_pending_approval", status, code)
+	}
+	// Without run_at it is queued straight away
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		st, _ := srv.jobs.get(id)
+		if st.State == jobQueued {
+			if st.ApprovedBy != keyInitiator("sk-admin-2") {
+				t.Errorf("approved_by = %q", st.ApprovedBy)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("approved job state = %s, want queued", st.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Unapproved deploys expire
+	id = redeploy("sk-site")
+	srv.schedule.mu.Lock()
+	d := srv.schedule.deploys[id]
+	d.Approval.Expires = time.Now()
+	srv.schedule.deploys[id] = d
+	srv.schedule.rearm(id)
+	srv.schedule.mu.Unlock()
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		st, _ := srv.jobs.get(id)
+		if st.State == jobFailed {
+			if st.Result["error"] != "approval_expired" {
+				t.Errorf("expired job result = %v", st.Result)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expired job state = %s, want failed", st.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
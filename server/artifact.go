@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"mime/multipart"
+
+	"github.com/lachierussell/shipyard/artifact"
+)
+
+// deployArtifact is the artifact for a deploy, uploaded or fetched from artifact_url
+type deployArtifact struct {
+	io.ReadCloser
+	Size   int64
+	URL    string // set when fetched from artifact_url (query string removed)
+	SHA256 string // set when fetched from artifact_url
+
+	ContentType string // of the upload, as the client sent it
+}
+
+// openArtifact returns the deploy artifact from the artifact upload or, if
+// given, the artifact_url field (verified against artifact_sha256 when set).
+// On failure it returns the API error to send and a detail message.
+func (s *Server) openArtifact(form *multipart.Form) (*deployArtifact, *APIError, string) {
+	if urls := form.Value["artifact_url"]; len(urls) > 0 && urls[0] != "" {
+		wantSHA := ""
+		if sums := form.Value["artifact_sha256"]; len(sums) > 0 {
+			wantSHA = sums[0]
+		}
+
+		d, err := artifact.Fetch(s.cfg.Artifacts, urls[0], wantSHA, int64(s.cfg.Server.BodyLimit()))
+		if err != nil {
+			switch {
+			case errors.Is(err, artifact.ErrInvalidURL), errors.Is(err, artifact.ErrHostNotAllowed), errors.Is(err, artifact.ErrAddressNotAllowed):
+				return nil, errInvalidArtifactURL, err.Error()
+			case errors.Is(err, artifact.ErrChecksumMismatch):
+				return nil, errArtifactChecksum, err.Error()
+			case errors.Is(err, artifact.ErrTooLarge):
+				return nil, errRequestTooLarge, err.Error()
+			}
+			return nil, errArtifactDownloadFailed, err.Error()
+		}
+		return &deployArtifact{ReadCloser: d, Size: d.Size, URL: d.URL, SHA256: d.SHA256}, nil, ""
+	}
+
+	files := form.File["artifact"]
+	if len(files) == 0 {
+		return nil, errMissingArtifact, ""
+	}
+	src, err := files[0].Open()
+	if err != nil {
+		return nil, errArtifactReadFailed, ""
+	}
+	return &deployArtifact{ReadCloser: src, Size: files[0].Size, ContentType: files[0].Header.Get("Content-Type")}, nil, ""
+}
+
+// keepArtifact stores src for later redeploys when artifact storage is enabled.
+// It returns the reader to deploy from and the artifact's SHA-256 (empty for
+// uploads when storage is disabled).
+func (s *Server) keepArtifact(src *deployArtifact, meta artifact.Meta) (io.ReadCloser, string, error) {
+	if !s.artifacts.Enabled() {
+		return io.NopCloser(src), src.SHA256, nil
+	}
+
+	stored, err := s.artifacts.Put(meta, src)
+	if stored == nil {
+		return nil, "", err
+	}
+	if err != nil {
+		// Stored fine; only pruning older artifacts failed
+		slog.Warn("artifact retention", "site", meta.Site, "error", err)
+	}
+
+	f, _, err := s.artifacts.Open(meta.Site, meta.Kind, meta.Commit)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, stored.SHA256, nil
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/jsonl"
+)
+
+// auditEntry records an operator action that changed a site without a deploy
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Site       string    `json:"site"`
+	Action     string    `json:"action"`
+	Initiator  string    `json:"initiator"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Error      string    `json:"error,omitempty"` // why the action failed
+}
+
+// auditPath returns the audit log location within a state directory
+func auditPath(stateDir string) string {
+	return filepath.Join(stateDir, "audit.jsonl")
+}
+
+// auditLog is an append-only JSON lines log of operator actions
+type auditLog struct {
+	log *jsonl.Log
+}
+
+// newAuditLog creates an auditLog stored at path
+func newAuditLog(path string) *auditLog {
+	return &auditLog{log: jsonl.New(path, jsonl.MaxSize)}
+}
+
+// append adds an entry to the log
+func (l *auditLog) append(e auditEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	return l.log.Append(e)
+}
+
+// list returns the entries for a site ("" for all), newest first
+func (l *auditLog) list(site string) ([]auditEntry, error) {
+	return jsonl.Read(l.log, func(e auditEntry) bool {
+		return site == "" || e.Site == site
+	})
+}
+
+// Audit returns the audit log, newest first (optionally ?site=)
+func (s *Server) Audit(c *fiber.Ctx) error {
+	entries, err := s.audit.list(c.Query("site"))
+	if err != nil {
+		return sendError(c, errHistoryReadFailed, err.Error())
+	}
+	return c.JSON(fiber.Map{
+		"entries": entries,
+	})
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// AuthConfig handles GET /auth/config, telling the dashboard how to sign in.
+// With [oidc] configured it returns the issuer and client ID for an
+// authorization code flow; the resulting token is sent as a bearer token.
+func (s *Server) AuthConfig(c *fiber.Ctx) error {
+	if !s.cfg.OIDC.Enabled() {
+		return c.JSON(fiber.Map{"oidc": nil})
+	}
+	return c.JSON(fiber.Map{
+		"oidc": fiber.Map{
+			"issuer":    s.cfg.OIDC.Issuer,
+			"client_id": s.cfg.OIDC.ClientID,
+		},
+	})
+}
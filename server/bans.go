@@ -0,0 +1,131 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/ban"
+	"github.com/lachierussell/shipyard/config"
+)
+
+// Bans handles GET /bans: the IPs banned for abuse, newest first
+// (optionally ?site=, the site whose requests tripped the rule)
+func (s *Server) Bans(c *fiber.Ctx) error {
+	if !s.cfg.Ban.Enabled {
+		return sendError(c, errBansDisabled, "")
+	}
+	site := c.Query("site")
+	bans := []ban.Ban{}
+	for _, b := range s.bans.List() {
+		if (site == "" || b.Site == site) && requestAllowsSite(c, b.Site) {
+			bans = append(bans, b)
+		}
+	}
+	return c.JSON(fiber.Map{
+		"bans": bans,
+	})
+}
+
+// AddBan handles POST /bans, which bans the ip form field by hand for
+// duration seconds (default ban.duration)
+func (s *Server) AddBan(c *fiber.Ctx) error {
+	if apiErr, detail := s.checkBanRequest(c); apiErr != nil {
+		return sendError(c, apiErr, detail)
+	}
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
+	}
+	ip := ""
+	if v := form.Value["ip"]; len(v) > 0 {
+		ip = v[0]
+	}
+	if net.ParseIP(ip) == nil {
+		return sendError(c, errInvalidRequest, "ip must be an IP address")
+	}
+	d := s.cfg.Ban.DurationFor(config.BanRule{})
+	if v := form.Value["duration"]; len(v) > 0 && v[0] != "" {
+		secs, err := strconv.Atoi(v[0])
+		if err != nil || secs < 1 {
+			return sendError(c, errInvalidRequest, "duration must be a number of seconds")
+		}
+		d = time.Duration(secs) * time.Second
+	}
+
+	b, err := s.bans.Add(ip, d)
+	if err != nil {
+		return sendError(c, errBanFailed, err.Error())
+	}
+	s.auditBan(c, "ban_add", ip)
+	return c.JSON(fiber.Map{
+		"status": "banned",
+		"ban":    b,
+	})
+}
+
+// ClearBans handles POST /bans/clear, which lifts the ban on the ip form
+// field, or every ban with all=true
+func (s *Server) ClearBans(c *fiber.Ctx) error {
+	if apiErr, detail := s.checkBanRequest(c); apiErr != nil {
+		return sendError(c, apiErr, detail)
+	}
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
+	}
+	ip, all := "", false
+	if v := form.Value["ip"]; len(v) > 0 {
+		ip = v[0]
+	}
+	if v := form.Value["all"]; len(v) > 0 {
+		all = v[0] == "true"
+	}
+	if all == (ip != "") {
+		return sendError(c, errInvalidRequest, "pass either ip or all=true")
+	}
+
+	cleared, err := s.bans.Clear(ip)
+	if err != nil {
+		return sendError(c, errBanFailed, err.Error())
+	}
+	if ip != "" && cleared == 0 {
+		return sendError(c, errBanNotFound, ip)
+	}
+	s.auditBan(c, "ban_clear", ip)
+	return c.JSON(fiber.Map{
+		"status":  "cleared",
+		"cleared": cleared,
+	})
+}
+
+// checkBanRequest refuses changes to the bans when they are disabled, or
+// from keys and users limited to some sites: a ban denies every site
+func (s *Server) checkBanRequest(c *fiber.Ctx) (*APIError, string) {
+	if !s.cfg.Ban.Enabled {
+		return errBansDisabled, ""
+	}
+	if _, scoped := c.Locals("site_scope").([]string); scoped {
+		return errSiteNotAllowed, "bans apply to every site"
+	}
+	return nil, ""
+}
+
+// auditBan records a change to the bans; ip is "" when every ban was cleared
+func (s *Server) auditBan(c *fiber.Ctx, action, ip string) {
+	log := reqLog(c).With("action", action, "ip", ip)
+	entry := auditEntry{
+		Action:     action,
+		Initiator:  requestInitiator(c, s.cfg, ""),
+		RemoteAddr: c.IP(),
+		RequestID:  requestID(c),
+	}
+	if ip != "" {
+		entry.Action += ":" + ip
+	}
+	if err := s.audit.append(entry); err != nil {
+		log.Warn("failed to record ban change", "error", err)
+	}
+	log.Info("bans changed", "initiator", entry.Initiator)
+}
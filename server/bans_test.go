@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/ban"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestBans_AddListClear(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Self:  config.SelfConfig{StateDir: dir},
+		Nginx: config.NginxConfig{OverrideConf: filepath.Join(dir, "override.conf")},
+		Ban:   config.BanConfig{Enabled: true},
+	}
+	srv := testServer(cfg)
+	srv.audit = newAuditLog(auditPath(dir))
+	srv.bans = ban.NewManager(cfg, nil)
+
+	app := fiber.New()
+	app.Get("/bans", srv.Bans)
+	app.Post("/bans", srv.AddBan)
+	app.Post("/bans/clear", srv.ClearBans)
+
+	post := func(path string, fields ...[2]string) map[string]any {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for _, f := range fields {
+			writer.WriteField(f[0], f[1])
+		}
+		writer.Close()
+		req := httptest.NewRequest("POST", path, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		var result map[string]any
+		json.NewDecoder(resp.Body).Decode(&result)
+		return result
+	}
+
+	if got := post("/bans", [2]string{"ip", "not-an-ip"}); got["error"] != "invalid_request" {
+		t.Errorf("bad ip: error = %v, want invalid_request", got["error"])
+	}
+	if got := post("/bans", [2]string{"ip", "203.0.113.9"}, [2]string{"duration", "60"}); got["status"] != "banned" {
+		t.Fatalf("POST /bans = %v", got)
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/bans", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed struct {
+		Bans []ban.Ban `json:"bans"`
+	}
+	json.NewDecoder(resp.Body).Decode(&listed)
+	if len(listed.Bans) != 1 || listed.Bans[0].IP != "203.0.113.9" || listed.Bans[0].Rule != ban.RuleManual {
+		t.Fatalf("GET /bans = %+v", listed.Bans)
+	}
+
+	if got := post("/bans/clear"); got["error"] != "invalid_request" {
+		t.Errorf("clear without ip or all: error = %v, want invalid_request", got["error"])
+	}
+	if got := post("/bans/clear", [2]string{"ip", "198.51.100.1"}); got["error"] != "ban_not_found" {
+		t.Errorf("clear unbanned ip: error = %v, want ban_not_found", got["error"])
+	}
+	if got := post("/bans/clear", [2]string{"all", "true"}); got["cleared"] != float64(1) {
+		t.Errorf("clear all = %v, want 1 cleared", got)
+	}
+}
+
+func TestBans_Disabled(t *testing.T) {
+	cfg := &config.Config{Self: config.SelfConfig{StateDir: t.TempDir()}}
+	srv := testServer(cfg)
+	srv.bans = ban.NewManager(cfg, nil)
+
+	app := fiber.New()
+	app.Get("/bans", srv.Bans)
+	resp, err := app.Test(httptest.NewRequest("GET", "/bans", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]any
+	json.NewDecoder(resp.Body).Decode(&result)
+	if result["error"] != "bans_disabled" {
+		t.Errorf("error = %v, want bans_disabled", result["error"])
+	}
+}
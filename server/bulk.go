@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
+	"github.com/lachierussell/shipyard/health"
+	"github.com/lachierussell/shipyard/nginx"
+)
+
+// maxBulkItems bounds the site and action pairs one POST /sites/bulk runs
+const maxBulkItems = 1000
+
+// Bulk actions
+var bulkActions = []string{
+	"rotate_key",
+	"backend_restart", "backend_stop", "backend_start",
+	"maintenance_on", "maintenance_off",
+}
+
+// BulkRequest is the JSON body of POST /sites/bulk
+type BulkRequest struct {
+	Operations []BulkOperation `json:"operations"`
+}
+
+// BulkOperation runs one action on the listed sites, or on every site with
+// a tag. Exactly one of Sites and Tag is set.
+type BulkOperation struct {
+	Action string   `json:"action"`
+	Sites  []string `json:"sites,omitempty"`
+	Tag    string   `json:"tag,omitempty"`
+}
+
+// bulkResult is the outcome of one action on one site
+type bulkResult struct {
+	Action string `json:"action"`
+	Site   string `json:"site"`
+	Status string `json:"status"` // "ok" or "failed"
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+	APIKey string `json:"api_key,omitempty"` // the new key, for rotate_key
+}
+
+// bulkItem is one site an operation applies to
+type bulkItem struct {
+	action string
+	site   string
+}
+
+// SitesBulk handles POST /sites/bulk: it runs each operation on its sites in
+// turn and reports every site's result, carrying on past failures. Keys
+// limited by key_acl select only their own sites by tag, and fail on others
+// named outright.
+func (s *Server) SitesBulk(c *fiber.Ctx) error {
+	var req BulkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse JSON body")
+	}
+	items, detail := s.bulkItems(req, func(site string) bool { return requestAllowsSite(c, site) })
+	if detail != "" {
+		return sendError(c, errInvalidRequest, detail)
+	}
+
+	log := reqLog(c)
+	log.Info("bulk operation started", "operations", len(req.Operations), "items", len(items))
+	results := make([]bulkResult, 0, len(items))
+	failed := 0
+	started := make(map[string]bool) // backends this request started or restarted
+	for _, item := range items {
+		res := bulkResult{Action: item.action, Site: item.site, Status: "ok"}
+		if !requestAllowsSite(c, item.site) {
+			res.Status, res.Code, res.Error = "failed", errSiteNotAllowed.Code, errSiteNotAllowed.Message
+			failed++
+			results = append(results, res)
+			continue
+		}
+
+		initiator := requestInitiator(c, s.cfg, item.site)
+		apiErr, err := s.bulkAction(logContext(log.With("site", item.site, "action", item.action)), item, started, &res)
+		if apiErr != nil {
+			res.Status, res.Code, res.Error = "failed", apiErr.Code, apiErr.Message
+			if err != nil {
+				res.Error = err.Error()
+			}
+			failed++
+			log.Warn("bulk item failed", "site", item.site, "action", item.action, "error", res.Error)
+		}
+
+		entry := auditEntry{Site: item.site, Action: item.action, Initiator: initiator, RemoteAddr: c.IP(), RequestID: requestID(c), Error: res.Error}
+		if aerr := s.audit.append(entry); aerr != nil {
+			log.Warn("failed to record bulk action", "site", item.site, "error", aerr)
+		}
+		results = append(results, res)
+	}
+
+	log.Info("bulk operation finished", "items", len(items), "failed", failed)
+	return c.JSON(fiber.Map{
+		"results":   results,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+	})
+}
+
+// bulkItems expands the operations into one item per site, or returns a
+// detail message for the first invalid operation
+func (s *Server) bulkItems(req BulkRequest, allowed func(site string) bool) ([]bulkItem, string) {
+	if len(req.Operations) == 0 {
+		return nil, "operations is required"
+	}
+	var items []bulkItem
+	for i, op := range req.Operations {
+		if !slices.Contains(bulkActions, op.Action) {
+			return nil, fmt.Sprintf("operations[%d]: action %q must be one of %v", i, op.Action, bulkActions)
+		}
+		if (len(op.Sites) == 0) == (op.Tag == "") {
+			return nil, fmt.Sprintf("operations[%d]: set either sites or tag", i)
+		}
+		sites := op.Sites
+		if op.Tag != "" {
+			sites = nil
+			for _, name := range sortedNames(s.cfg.Site) {
+				if s.cfg.Site[name].HasTag(op.Tag) && allowed(name) {
+					sites = append(sites, name)
+				}
+			}
+		}
+		if op.Action == "backend_start" || op.Action == "backend_restart" {
+			sites = s.dependencyOrder(sites)
+		}
+		for _, site := range sites {
+			items = append(items, bulkItem{action: op.Action, site: site})
+		}
+	}
+	if len(items) > maxBulkItems {
+		return nil, fmt.Sprintf("%d site actions requested; at most %d per request", len(items), maxBulkItems)
+	}
+	return items, ""
+}
+
+// dependencyOrder returns sites with those whose backends others depend on
+// first, keeping the given order otherwise
+func (s *Server) dependencyOrder(sites []string) []string {
+	order, err := s.cfg.BackendOrder()
+	if err != nil {
+		return sites
+	}
+	sorted := slices.Clone(sites)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		return slices.Index(order, a) - slices.Index(order, b)
+	})
+	return sorted
+}
+
+// bulkAction runs one item, filling in res. It returns the API error that
+// describes a failure, with the underlying error when there is one. Backends
+// are started only once the dependencies started before them, recorded in
+// started, are healthy.
+func (s *Server) bulkAction(ctx context.Context, item bulkItem, started map[string]bool, res *bulkResult) (*APIError, error) {
+	site, ok := s.cfg.Site[item.site]
+	if !ok {
+		return errSiteNotFound, nil
+	}
+
+	switch item.action {
+	case "rotate_key":
+		key, err := config.GenerateAPIKey("sk-site-")
+		if err != nil {
+			return errKeyGeneration, err
+		}
+		site.APIKey = key
+		if err := s.cfg.UpdateSite(item.site, site); err != nil {
+			return errSaveFailed, err
+		}
+		res.APIKey = key
+		return nil, nil
+
+	case "maintenance_on", "maintenance_off":
+		if site.Backend == nil || !site.Backend.UnavailableDuringRestart() {
+			return errMaintenanceUnsupported, nil
+		}
+		if err := nginx.SetMaintenance(item.site, item.action == "maintenance_on"); err != nil {
+			return errBackendActionFailed, err
+		}
+		return nil, nil
+	}
+
+	// backend_restart, backend_stop and backend_start
+	if site.Backend == nil {
+		return errSiteHasNoBackend, nil
+	}
+	if deploy.BackendDeployInProgress(s.cfg, item.site) {
+		return errBackendDeploying, nil
+	}
+	if item.action != "backend_stop" {
+		for _, dep := range site.Backend.DependsOn {
+			if !started[dep] {
+				continue
+			}
+			backend := s.cfg.Site[dep].Backend
+			if err := health.WaitHealthy(backend, s.cfg.Health, backend.StartupGraceDuration(s.cfg.Health)); err != nil {
+				return errDependencyNotReady, fmt.Errorf("%s: %w", dep, err)
+			}
+		}
+	}
+	if err := s.backendAction(ctx, item.site, strings.TrimPrefix(item.action, "backend_")); err != nil {
+		return errBackendActionFailed, err
+	}
+	if item.action != "backend_stop" {
+		started[item.site] = true
+	}
+	return nil, nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestSitesBulk(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shipyard.toml")
+	os.WriteFile(path, []byte(`
+[site."a.example.com"]
+frontend_root = "/var/www/a"
+api_key = "sk-a"
+tags = ["team-payments"]
+
+[site."b.example.com"]
+frontend_root = "/var/www/b"
+api_key = "sk-b"
+tags = ["team-payments"]
+
+[site."c.example.com"]
+frontend_root = "/var/www/c"
+api_key = "sk-c"
+`), 0644)
+	// Only the sites matter here; the rest of the config is left out
+	cfg, _ := config.Load(path)
+	cfg.Self.StateDir = dir
+	srv := testServer(cfg)
+	srv.audit = newAuditLog(filepath.Join(dir, "audit.jsonl"))
+
+	app := fiber.New()
+	app.Post("/sites/bulk", srv.SitesBulk)
+	post := func(body string) (int, map[string]any) {
+		req := httptest.NewRequest("POST", "/sites/bulk", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		var result map[string]any
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	code, result := post(`{"operations": [
+		{"action": "rotate_key", "tag": "team-payments"},
+		{"action": "backend_restart", "sites": ["c.example.com", "missing.example.com"]}
+	]}`)
+	if code != fiber.StatusOK {
+		t.Fatalf("status = %d, body = %v", code, result)
+	}
+	if result["succeeded"] != float64(2) || result["failed"] != float64(2) {
+		t.Errorf("succeeded = %v, failed = %v, want 2 and 2", result["succeeded"], result["failed"])
+	}
+	results := result["results"].([]any)
+	wantCodes := []string{"", "", "site_has_no_backend", "site_not_found"}
+	for i, r := range results {
+		item := r.(map[string]any)
+		if got, _ := item["code"].(string); got != wantCodes[i] {
+			t.Errorf("results[%d] (%v %v): code = %q, want %q", i, item["action"], item["site"], got, wantCodes[i])
+		}
+	}
+
+	first := results[0].(map[string]any)
+	newKey, _ := first["api_key"].(string)
+	if first["site"] != "a.example.com" || newKey == "" || newKey == "sk-a" {
+		t.Errorf("results[0] = %v, want a new key for a.example.com", first)
+	}
+	saved, _ := config.Load(path)
+	if saved.Site["a.example.com"].APIKey != newKey || saved.Site["c.example.com"].APIKey != "sk-c" {
+		t.Errorf("saved keys = %q, %q; want the rotated key and c's unchanged", saved.Site["a.example.com"].APIKey, saved.Site["c.example.com"].APIKey)
+	}
+	if entries, _ := srv.audit.list("a.example.com"); len(entries) != 1 || entries[0].Action != "rotate_key" {
+		t.Errorf("audit = %+v, want the rotation", entries)
+	}
+
+	for _, body := range []string{
+		`{"operations": []}`,
+		`{"operations": [{"action": "destroy", "tag": "team-payments"}]}`,
+		`{"operations": [{"action": "rotate_key"}]}`,
+		`{"operations": [{"action": "rotate_key", "tag": "x", "sites": ["a.example.com"]}]}`,
+	} {
+		if code, result := post(body); code != fiber.StatusBadRequest || result["error"] != errInvalidRequest.Code {
+			t.Errorf("%s: status = %d, body = %v, want 400 invalid_request", body, code, result)
+		}
+	}
+}
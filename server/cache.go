@@ -0,0 +1,144 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// readCacheTTL is how long a read endpoint's response is reused. Changes
+// made through the API invalidate it sooner; edits to site files show up
+// within this.
+const readCacheTTL = 5 * time.Second
+
+// cachedResponse is a read endpoint's rendered JSON
+type cachedResponse struct {
+	body    []byte
+	etag    string
+	expires time.Time
+}
+
+// responseCache keeps the JSON of polled read endpoints for a few seconds
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]cachedResponse)}
+}
+
+func (rc *responseCache) get(key string) (cachedResponse, bool) {
+	if rc == nil {
+		return cachedResponse{}, false
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e, ok := rc.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		delete(rc.entries, key)
+		return cachedResponse{}, false
+	}
+	return e, true
+}
+
+func (rc *responseCache) put(key string, e cachedResponse) {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.entries[key] = e
+}
+
+// invalidate drops every cached response, after anything changed
+func (rc *responseCache) invalidate() {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	clear(rc.entries)
+}
+
+// bodyETag returns a strong ETag for a response body
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header names etag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// CachedRead serves a read endpoint from the response cache under the key
+// key returns, and answers 304 when If-None-Match names the response's ETag.
+// cacheControl is sent with every response so proxies revalidate too.
+func (s *Server) CachedRead(cacheControl string, key func(c *fiber.Ctx) string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, cacheControl)
+		k := key(c)
+
+		e, ok := s.readCache.get(k)
+		if !ok {
+			if err := c.Next(); err != nil {
+				return err
+			}
+			if c.Response().StatusCode() != fiber.StatusOK {
+				return nil
+			}
+			body := append([]byte(nil), c.Response().Body()...)
+			e = cachedResponse{body: body, etag: bodyETag(body), expires: time.Now().Add(readCacheTTL)}
+			s.readCache.put(k, e)
+		}
+
+		c.Set(fiber.HeaderETag, e.etag)
+		if etagMatches(c.Get(fiber.HeaderIfNoneMatch), e.etag) {
+			c.Response().ResetBody()
+			c.Status(fiber.StatusNotModified)
+			return nil
+		}
+		if ok {
+			c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			return c.Send(e.body)
+		}
+		return nil
+	}
+}
+
+// statusCacheKey is the cache key of GET /status/:site
+func statusCacheKey(c *fiber.Ctx) string {
+	return "status|" + c.Params("site")
+}
+
+// sitesCacheKey is the cache key of GET /sites, which depends on the sites
+// the caller may see
+func sitesCacheKey(c *fiber.Ctx) string {
+	return "sites|" + scopeKey(c) + "|" + string(c.Request().URI().QueryString())
+}
+
+// promotionsCacheKey is the cache key of GET /deploy/frontend/promotions
+func promotionsCacheKey(c *fiber.Ctx) string {
+	return "promotions|" + string(c.Request().URI().QueryString())
+}
+
+// scopeKey identifies the sites a request may see, for cache keys of
+// listings filtered by requestAllowsSite
+func scopeKey(c *fiber.Ctx) string {
+	globs, scoped := c.Locals("site_scope").([]string)
+	if !scoped {
+		return "*"
+	}
+	return strings.Join(globs, ",")
+}
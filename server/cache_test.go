@@ -0,0 +1,56 @@
+package server
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestCachedRead_ETagAndInvalidation(t *testing.T) {
+	srv := testServer(&config.Config{})
+	srv.readCache = newResponseCache()
+
+	calls := 0
+	app := fiber.New()
+	app.Get("/sites", srv.CachedRead("private, no-cache", sitesCacheKey), func(c *fiber.Ctx) error {
+		calls++
+		return c.JSON(fiber.Map{"calls": calls})
+	})
+
+	get := func(ifNoneMatch string) (int, string, string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/sites", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		if cc := resp.Header.Get("Cache-Control"); cc != "private, no-cache" {
+			t.Errorf("Cache-Control = %q", cc)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("ETag"), string(body)
+	}
+
+	status, etag, body := get("")
+	if status != 200 || etag == "" || body != `{"calls":1}` {
+		t.Fatalf("first GET = %d %q %s", status, etag, body)
+	}
+	if status, _, body := get(""); status != 200 || body != `{"calls":1}` || calls != 1 {
+		t.Errorf("second GET = %d %s after %d handler calls, want the cached response", status, body, calls)
+	}
+	if status, _, body := get(`W/"other", ` + etag); status != fiber.StatusNotModified || body != "" {
+		t.Errorf("conditional GET = %d %q, want 304 without a body", status, body)
+	}
+
+	srv.readCache.invalidate()
+	status, newTag, body := get(etag)
+	if status != 200 || body != `{"calls":2}` || newTag == etag {
+		t.Errorf("GET after invalidate = %d %q %s, want a fresh response", status, newTag, body)
+	}
+}
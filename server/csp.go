@@ -0,0 +1,364 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+)
+
+// CSP report limits: browsers send one small report per violation, and a
+// site keeps at most maxCSPViolations distinct ones
+const (
+	maxCSPReportBytes = 64 << 10
+	maxCSPViolations  = 500
+	maxCSPFieldLength = 512
+)
+
+// cspSaveDelay batches the saves of a burst of reports
+const cspSaveDelay = 10 * time.Second
+
+// cspReportsPath is where the aggregated reports are kept
+func cspReportsPath(stateDir string) string {
+	return filepath.Join(stateDir, "csp-reports.json")
+}
+
+// cspViolation aggregates the reports of one directive blocking one
+// resource on one page
+type cspViolation struct {
+	Directive   string    `json:"directive"`
+	BlockedURI  string    `json:"blocked_uri"`
+	DocumentURI string    `json:"document_uri"`
+	Disposition string    `json:"disposition,omitempty"` // enforce or report
+	SourceFile  string    `json:"source_file,omitempty"` // from the latest report
+	LineNumber  int       `json:"line_number,omitempty"`
+	Sample      string    `json:"sample,omitempty"`
+	Count       int       `json:"count"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// key identifies the violation a report adds to
+func (v cspViolation) key() string {
+	return v.Directive + " " + v.BlockedURI + " " + v.DocumentURI
+}
+
+// siteCSPReports is one site's aggregated violations. Dropped counts reports
+// of new violations that arrived once maxCSPViolations were kept.
+type siteCSPReports struct {
+	Violations map[string]*cspViolation `json:"violations"`
+	Dropped    int                      `json:"dropped,omitempty"`
+}
+
+// cspReportStore aggregates CSP violation reports per site. Reports are
+// counted in memory and saved to path shortly after they arrive.
+type cspReportStore struct {
+	mu    sync.Mutex
+	path  string
+	sites map[string]*siteCSPReports
+	timer *time.Timer
+}
+
+// newCSPReportStore creates a store saved at path, loading what it holds
+func newCSPReportStore(path string) *cspReportStore {
+	st := &cspReportStore{path: path, sites: make(map[string]*siteCSPReports)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to read CSP reports", "path", path, "error", err)
+		}
+		return st
+	}
+	if err := json.Unmarshal(data, &st.sites); err != nil {
+		slog.Warn("failed to parse CSP reports", "path", path, "error", err)
+		st.sites = make(map[string]*siteCSPReports)
+	}
+	return st
+}
+
+// add counts a report of v against site
+func (st *cspReportStore) add(site string, v cspViolation, now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	reports := st.sites[site]
+	if reports == nil {
+		reports = &siteCSPReports{Violations: make(map[string]*cspViolation)}
+		st.sites[site] = reports
+	}
+	existing := reports.Violations[v.key()]
+	switch {
+	case existing != nil:
+		existing.Count++
+		existing.LastSeen = now
+		existing.Disposition = v.Disposition
+		existing.SourceFile, existing.LineNumber, existing.Sample = v.SourceFile, v.LineNumber, v.Sample
+	case len(reports.Violations) >= maxCSPViolations:
+		reports.Dropped++
+	default:
+		v.Count, v.FirstSeen, v.LastSeen = 1, now, now
+		reports.Violations[v.key()] = &v
+	}
+
+	if st.timer == nil {
+		st.timer = time.AfterFunc(cspSaveDelay, st.flush)
+	}
+}
+
+// list returns a site's violations, most reported first, and how many
+// reports were dropped
+func (st *cspReportStore) list(site string) ([]cspViolation, int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	violations := []cspViolation{}
+	reports := st.sites[site]
+	if reports == nil {
+		return violations, 0
+	}
+	for _, v := range reports.Violations {
+		violations = append(violations, *v)
+	}
+	sort.Slice(violations, func(a, b int) bool {
+		if violations[a].Count != violations[b].Count {
+			return violations[a].Count > violations[b].Count
+		}
+		return violations[a].key() < violations[b].key()
+	})
+	return violations, reports.Dropped
+}
+
+// flush saves the reports now. It is called after cspSaveDelay and on shutdown.
+func (st *cspReportStore) flush() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.timer != nil {
+		st.timer.Stop()
+		st.timer = nil
+	}
+	if err := st.save(); err != nil {
+		slog.Warn("failed to save CSP reports", "path", st.path, "error", err)
+	}
+}
+
+func (st *cspReportStore) save() error {
+	data, err := json.Marshal(st.sites)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.path), 0755); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write CSP reports: %w", err)
+	}
+	return os.Rename(tmp, st.path)
+}
+
+// CSPReport handles POST /csp-report. Browsers send it the violations of
+// sites with csp.report, through the generated config's report location;
+// the site is the one serving the Host header.
+func (s *Server) CSPReport(c *fiber.Ctx) error {
+	// The site name outlives the request, and fiber reuses its buffers
+	siteName, ok := siteForHost(s.cfg, strings.Clone(c.Hostname()))
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	if !s.cfg.Site[siteName].CSPReports() {
+		return sendError(c, errCSPReportsDisabled, "")
+	}
+	if len(c.Body()) > maxCSPReportBytes {
+		return sendError(c, errRequestTooLarge, fmt.Sprintf("max %dKB", maxCSPReportBytes>>10))
+	}
+
+	violations, err := parseCSPReports(c.Body())
+	if err != nil {
+		return sendError(c, errInvalidCSPReport, err.Error())
+	}
+	now := time.Now()
+	for _, v := range violations {
+		s.cspReports.add(siteName, v, now)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// SiteCSPReports returns a site's aggregated CSP violations, most reported first
+func (s *Server) SiteCSPReports(c *fiber.Ctx) error {
+	siteName := c.Query("site")
+	if siteName == "" {
+		return sendError(c, errMissingSite, "")
+	}
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+
+	violations, dropped := s.cspReports.list(siteName)
+	if q := c.Query("limit"); q != "" {
+		if n, err := strconv.Atoi(q); err == nil && n >= 0 && n < len(violations) {
+			violations = violations[:n]
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"status":     "ok",
+		"site":       siteName,
+		"collecting": site.CSPReports(),
+		"violations": violations,
+		"dropped":    dropped,
+	})
+}
+
+// siteForHost returns the site serving host: the site of that name, one
+// with host among its aliases, or the wildcard site it is a subdomain of
+func siteForHost(cfg *config.Config, host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if _, ok := cfg.Site[host]; ok {
+		return host, true
+	}
+	for name, site := range cfg.Site {
+		if slices.Contains(site.Aliases, host) {
+			return name, true
+		}
+	}
+	if label, rest, ok := strings.Cut(host, "."); ok && config.ValidSubdomainLabel(label) {
+		if _, ok := cfg.Site["*."+rest]; ok {
+			return "*." + rest, true
+		}
+	}
+	return "", false
+}
+
+// cspReportFields are the fields of an application/csp-report body (CSP level 2)
+type cspReportFields struct {
+	DocumentURI        string `json:"document-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	BlockedURI         string `json:"blocked-uri"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	Disposition        string `json:"disposition"`
+	ScriptSample       string `json:"script-sample"`
+}
+
+// reportingAPIReport is one report of an application/reports+json body
+// (the Reporting API, sent by browsers that support report-to)
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		BlockedURL         string `json:"blockedURL"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		Disposition        string `json:"disposition"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+// parseCSPReports reads the violations in a report body, in either format
+func parseCSPReports(body []byte) ([]cspViolation, error) {
+	body = bytes.TrimSpace(body)
+	var violations []cspViolation
+
+	if bytes.HasPrefix(body, []byte("[")) {
+		var reports []reportingAPIReport
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, err
+		}
+		for _, r := range reports {
+			if r.Type != "csp-violation" {
+				continue
+			}
+			violations = append(violations, cspViolation{
+				Directive:   r.Body.EffectiveDirective,
+				BlockedURI:  r.Body.BlockedURL,
+				DocumentURI: r.Body.DocumentURL,
+				Disposition: r.Body.Disposition,
+				SourceFile:  r.Body.SourceFile,
+				LineNumber:  r.Body.LineNumber,
+				Sample:      r.Body.Sample,
+			})
+		}
+	} else {
+		var report struct {
+			Report *cspReportFields `json:"csp-report"`
+		}
+		if err := json.Unmarshal(body, &report); err != nil {
+			return nil, err
+		}
+		if r := report.Report; r != nil {
+			directive := r.EffectiveDirective
+			if directive == "" {
+				// Older browsers send only the directive with its sources
+				directive, _, _ = strings.Cut(r.ViolatedDirective, " ")
+			}
+			violations = append(violations, cspViolation{
+				Directive:   directive,
+				BlockedURI:  r.BlockedURI,
+				DocumentURI: r.DocumentURI,
+				Disposition: r.Disposition,
+				SourceFile:  r.SourceFile,
+				LineNumber:  r.LineNumber,
+				Sample:      r.ScriptSample,
+			})
+		}
+	}
+
+	kept := violations[:0]
+	for _, v := range violations {
+		if v.Directive == "" {
+			continue
+		}
+		v.Directive = truncate(v.Directive)
+		v.BlockedURI = truncate(reportedURI(v.BlockedURI))
+		v.DocumentURI = truncate(reportedURI(v.DocumentURI))
+		v.SourceFile = truncate(reportedURI(v.SourceFile))
+		v.Disposition = truncate(v.Disposition)
+		v.Sample = truncate(v.Sample)
+		kept = append(kept, v)
+	}
+	if len(kept) == 0 {
+		return nil, errors.New("no CSP violations in the report")
+	}
+	return kept, nil
+}
+
+// reportedURI drops the query and fragment of a reported URL, so that
+// violations on the same page or resource are counted together. Keywords
+// like inline and eval are returned unchanged.
+func reportedURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return uri
+	}
+	u.RawQuery, u.Fragment, u.RawFragment, u.User = "", "", "", nil
+	return u.String()
+}
+
+// truncate bounds a report field's length
+func truncate(s string) string {
+	if len(s) > maxCSPFieldLength {
+		return s[:maxCSPFieldLength]
+	}
+	return s
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestCSPReport(t *testing.T) {
+	stateDir := t.TempDir()
+	csp := &config.CSPConfig{Policy: "default-src 'self'", Report: true}
+	srv := testServer(&config.Config{
+		Site: map[string]config.SiteConfig{
+			"example.com":        {FrontendRoot: "/var/www/example.com", Aliases: []string{"www.example.com"}, CSP: csp},
+			"*.docs.example.com": {FrontendRoot: "/var/www/docs", CSP: csp},
+			"other.com":          {FrontendRoot: "/var/www/other.com"},
+		},
+	})
+	srv.cspReports = newCSPReportStore(cspReportsPath(stateDir))
+
+	app := fiber.New()
+	app.Post("/csp-report", srv.CSPReport)
+	app.Get("/site/csp-reports", srv.SiteCSPReports)
+
+	report := func(host, contentType, body string) int {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/csp-report", strings.NewReader(body))
+		req.Host = host
+		req.Header.Set("Content-Type", contentType)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	level2 := `{"csp-report": {"document-uri": "https://example.com/pricing?plan=pro", "violated-directive": "script-src 'self'", "blocked-uri": "https://cdn.tracker.net/t.js?id=1"}}`
+	reporting := `[{"type": "csp-violation", "body": {"documentURL": "https://example.com/pricing", "effectiveDirective": "script-src", "blockedURL": "https://cdn.tracker.net/t.js?id=2", "disposition": "enforce"}}]`
+	tests := []struct {
+		name        string
+		host        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"csp-report", "example.com", "application/csp-report", level2, fiber.StatusNoContent},
+		{"reports+json from an alias", "www.example.com", "application/reports+json", reporting, fiber.StatusNoContent},
+		{"wildcard subdomain", "pr-1.docs.example.com", "application/csp-report", level2, fiber.StatusNoContent},
+		{"reports disabled", "other.com", "application/csp-report", level2, fiber.StatusNotFound},
+		{"unknown host", "evil.com", "application/csp-report", level2, fiber.StatusNotFound},
+		{"not a report", "example.com", "application/json", `{"hello": "world"}`, fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := report(tt.host, tt.contentType, tt.body); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Both reports are the same violation once queries are dropped
+	resp, err := app.Test(httptest.NewRequest("GET", "/site/csp-reports?site=example.com", nil))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	var result struct {
+		Collecting bool           `json:"collecting"`
+		Violations []cspViolation `json:"violations"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if !result.Collecting || len(result.Violations) != 1 {
+		t.Fatalf("reports = %+v, want one violation", result)
+	}
+	v := result.Violations[0]
+	if v.Count != 2 || v.Directive != "script-src" || v.BlockedURI != "https://cdn.tracker.net/t.js" || v.DocumentURI != "https://example.com/pricing" {
+		t.Errorf("violation = %+v", v)
+	}
+
+	// Saved, and loaded again after a restart
+	srv.cspReports.flush()
+	if violations, _ := newCSPReportStore(filepath.Join(stateDir, "csp-reports.json")).list("*.docs.example.com"); len(violations) != 1 {
+		t.Errorf("after restart wildcard site has %d violations, want 1", len(violations))
+	}
+}
@@ -1,26 +1,29 @@
 package server
 
 import (
+	"errors"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"path"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/artifact"
+	"github.com/lachierussell/shipyard/deploy"
 )
 
 // DeployBackend handles POST /deploy/backend
 func (s *Server) DeployBackend(c *fiber.Ctx) error {
-	form, err := c.MultipartForm()
+	form, err := requestForm(c, s.cfg)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "invalid_request",
-		})
+		return sendError(c, errInvalidRequest, "")
 	}
 
 	siteValues := form.Value["site"]
 	commitValues := form.Value["commit"]
 	if len(siteValues) == 0 || len(commitValues) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "missing_fields",
-		})
+		return sendError(c, errMissingFields, "")
 	}
 
 	siteName := siteValues[0]
@@ -29,73 +32,164 @@ func (s *Server) DeployBackend(c *fiber.Ctx) error {
 	// Validate site exists and has backend config
 	site, ok := s.cfg.Site[siteName]
 	if !ok {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"status": "error",
-			"error":  "site_not_found",
-		})
+		return sendError(c, errSiteNotFound, "")
+	}
+
+	// A site with a staging site runs only what was promoted from it
+	if staging := s.cfg.StagingSite(siteName); staging != "" {
+		return sendError(c, errPromotionRequired, "deploy to "+staging+" first")
 	}
 
 	if site.Backend == nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "site_has_no_backend",
-		})
+		return sendError(c, errSiteHasNoBackend, "")
 	}
 
 	// Validate commit hash
 	if !isValidCommitHash(commitHash) {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "invalid_commit_hash",
-		})
+		return sendError(c, errInvalidCommitHash, "")
 	}
 
-	// Get artifact file
-	files := form.File["artifact"]
-	if len(files) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "missing_artifact",
-		})
+	// With run_at, or on sites requiring approval, the deploy is stored now
+	// and runs later
+	runAt, apiErr, detail := s.requestRunAt(form)
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
 	}
 
-	artifactFile := files[0]
-
-	// Open artifact
-	src, err := artifactFile.Open()
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "failed to read artifact",
-		})
+	// Get the artifact: uploaded, or downloaded from artifact_url
+	src, apiErr, detail := s.openArtifact(form)
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
 	}
 	defer src.Close()
 
+	// Refuse deploys that would take the site over its disk quota
+	if err := deploy.CheckQuota(s.cfg, siteName, src.Size); err != nil {
+		var quotaErr *deploy.QuotaError
+		if errors.As(err, &quotaErr) {
+			return sendError(c, errQuotaExceeded, err.Error())
+		}
+		return sendError(c, errUsageFailed, err.Error())
+	}
+
 	// Determine binary name (default to site name if not specified)
 	binaryName := site.Backend.BinaryName
 	if binaryNameValues := form.Value["binary_name"]; len(binaryNameValues) > 0 {
 		binaryName = binaryNameValues[0]
 	}
 
+	// The artifact is a zip unless it says it's the binary itself
+	format, apiErr, detail := backendArtifactFormat(form, src)
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
+	}
+	var binaryPath string
+	if values := form.Value["binary_path"]; len(values) > 0 && values[0] != "" {
+		binaryPath = values[0]
+		if format == artifact.FormatBinary || !validBinaryPath(binaryPath) {
+			return sendError(c, errInvalidBinaryPath, binaryPath)
+		}
+	}
+
 	log := reqLog(c).With("site", siteName, "commit", commitHash, "jail", site.Backend.JailName)
-	log.Info("backend deploy started")
+	log.Info("backend deploy started", "artifact_url", src.URL, "artifact_size", src.Size)
+
+	// Keep the artifact for /deploy/redeploy, then deploy from the stored copy
+	artifactReader, sha256, err := s.keepArtifact(src, artifact.Meta{
+		Site:       siteName,
+		Kind:       artifact.KindBackend,
+		Commit:     commitHash,
+		SourceURL:  src.URL,
+		BinaryName: binaryName,
+		BinaryPath: binaryPath,
+		Format:     format,
+	})
+	if err != nil {
+		log.Error("store artifact failed", "error", err)
+		return sendError(c, errArtifactStoreFailed, err.Error())
+	}
+
+	op := operation{Kind: "deploy_backend", Site: siteName, Commit: commitHash}
+	if !runAt.IsZero() || site.RequireApproval {
+		artifactReader.Close()
+		return s.scheduleDeploy(c, log, scheduledDeploy{Operation: op.Kind, Site: siteName, Kind: artifact.KindBackend, Commit: commitHash, RunAt: runAt})
+	}
+	return s.respondDeploy(c, form, log, op, artifactReader, func(log *slog.Logger) deployResult {
+		return s.runBackendDeploy(log, siteName, commitHash, artifactReader, binarySpec(&artifact.Meta{BinaryName: binaryName, BinaryPath: binaryPath, Format: format}), sha256)
+	})
+}
 
-	// Deploy
-	if err := s.backendDeployer.Deploy(siteName, commitHash, src, binaryName); err != nil {
+// executableTypes are upload content types that mark a backend artifact as
+// the binary itself
+var executableTypes = map[string]bool{
+	"application/x-executable":  true,
+	"application/x-elf":         true,
+	"application/x-sharedlib":   true,
+	"application/x-mach-binary": true,
+}
+
+// backendArtifactFormat returns the format of a backend artifact: the
+// artifact_format field, else binary for uploads sent with an executable
+// content type, else zip
+func backendArtifactFormat(form *multipart.Form, src *deployArtifact) (string, *APIError, string) {
+	if values := form.Value["artifact_format"]; len(values) > 0 && values[0] != "" {
+		switch values[0] {
+		case artifact.FormatZip:
+			return "", nil, ""
+		case artifact.FormatBinary:
+			return artifact.FormatBinary, nil, ""
+		}
+		return "", errInvalidArtifactFormat, values[0]
+	}
+	if executableTypes[src.ContentType] {
+		return artifact.FormatBinary, nil, ""
+	}
+	return "", nil, ""
+}
+
+// validBinaryPath reports whether p is a relative path that stays inside a zip
+func validBinaryPath(p string) bool {
+	if strings.Contains(p, "\\") || path.IsAbs(p) {
+		return false
+	}
+	clean := path.Clean(p)
+	return clean != "." && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// binarySpec says where the binary is in a backend artifact stored with meta
+func binarySpec(meta *artifact.Meta) deploy.BinarySpec {
+	return deploy.BinarySpec{Name: meta.BinaryName, Path: meta.BinaryPath, Format: meta.Format}
+}
+
+// runBackendDeploy deploys a backend artifact and returns the response
+func (s *Server) runBackendDeploy(log *slog.Logger, siteName, commitHash string, src io.Reader, bin deploy.BinarySpec, sha256 string) (r deployResult) {
+	site := s.cfg.Site[siteName]
+	ctx, log, deployID := beginDeploy(log)
+	defer func() { r.Body["deploy_id"] = deployID }()
+
+	res, err := s.backendDeployer.Deploy(ctx, siteName, commitHash, src, bin)
+	if err != nil {
 		log.Error("backend deploy failed", "error", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error",
-			"error":  "deployment_failed",
-			"detail": err.Error(),
-		})
+		if res != nil && res.Migration != nil && res.Migration.Error != "" {
+			return deployResult{errMigrationFailed.HTTPStatus, errorBody(errMigrationFailed, err.Error(), fiber.Map{"migration": res.Migration})}
+		}
+		return errorResult(errDeploymentFailed, err.Error())
 	}
 
 	log.Info("backend deploy succeeded")
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"status":   "deployed",
-		"site":     siteName,
-		"commit":   commitHash,
-		"jail":     site.Backend.JailName,
-		"healthy":  true,
-	})
+	body := fiber.Map{
+		"status":          "deployed",
+		"site":            siteName,
+		"commit":          commitHash,
+		"jail":            site.Backend.JailName,
+		"healthy":         res.Healthy,
+		"artifact_sha256": sha256,
+	}
+	if res.HealthError != "" {
+		body["health_error"] = res.HealthError
+	}
+	if res.Migration != nil {
+		body["migration"] = res.Migration
+	}
+	return deployResult{fiber.StatusOK, body}
 }
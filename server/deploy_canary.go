@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// Canary handles POST /deploy/frontend/canary, serving a deployed commit to a
+// percentage of clients. Post again to change the percentage.
+func (s *Server) Canary(c *fiber.Ctx) error {
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
+	}
+
+	siteValues := form.Value["site"]
+	commitValues := form.Value["commit"]
+	percentValues := form.Value["percent"]
+	if len(siteValues) == 0 || len(commitValues) == 0 || len(percentValues) == 0 {
+		return sendError(c, errMissingFields, "site, commit and percent are required")
+	}
+	siteName := siteValues[0]
+	commitHash := commitValues[0]
+
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	if site.IsBackendOnly() {
+		return sendError(c, errBackendOnlySite, "")
+	}
+	if commitHash == "latest" || !isValidCommitHash(commitHash) {
+		return sendError(c, errInvalidCommitHash, "must be 7-40 char hex string")
+	}
+	percent, err := strconv.Atoi(percentValues[0])
+	if err != nil || percent < 0 || percent > 100 {
+		return sendError(c, errInvalidRequest, "percent must be an integer from 0 to 100")
+	}
+
+	log := reqLog(c).With("site", siteName, "commit", commitHash)
+
+	canary, err := s.frontendDeployer.StartCanary(siteName, commitHash, percent)
+	if err != nil {
+		return s.canaryError(c, log, err)
+	}
+
+	log.Info("canary updated", "percent", percent)
+	return c.JSON(fiber.Map{
+		"status": "canary",
+		"site":   siteName,
+		"canary": canary,
+	})
+}
+
+// CanaryFinalize handles POST /deploy/frontend/canary/finalize, making the
+// canary commit latest for everyone
+func (s *Server) CanaryFinalize(c *fiber.Ctx) error {
+	siteName, apiErr := s.canarySite(c)
+	if apiErr != nil {
+		return sendError(c, apiErr, "")
+	}
+	log := reqLog(c).With("site", siteName)
+
+	canary, previous, err := s.frontendDeployer.FinalizeCanary(logContext(log), siteName)
+	if err != nil {
+		return s.canaryError(c, log, err)
+	}
+
+	if err := s.promotions.Append(deploy.Promotion{
+		Site:           siteName,
+		Commit:         canary.Commit,
+		PreviousCommit: previous,
+		Initiator:      siteInitiator(s.cfg.Site[siteName].APIKey, c.Get("X-Shipyard-Key"), siteName),
+		RemoteAddr:     c.IP(),
+		RequestID:      requestID(c),
+	}); err != nil {
+		log.Warn("failed to record promotion", "error", err)
+	}
+
+	log.Info("canary finalized", "commit", canary.Commit, "previous", previous)
+	return c.JSON(fiber.Map{
+		"status":          "promoted",
+		"site":            siteName,
+		"commit":          canary.Commit,
+		"previous_commit": previous,
+	})
+}
+
+// CanaryAbort handles POST /deploy/frontend/canary/abort, sending everyone back to latest
+func (s *Server) CanaryAbort(c *fiber.Ctx) error {
+	siteName, apiErr := s.canarySite(c)
+	if apiErr != nil {
+		return sendError(c, apiErr, "")
+	}
+	log := reqLog(c).With("site", siteName)
+
+	canary, err := s.frontendDeployer.AbortCanary(siteName)
+	if err != nil {
+		return s.canaryError(c, log, err)
+	}
+
+	log.Info("canary aborted", "commit", canary.Commit)
+	return c.JSON(fiber.Map{
+		"status": "aborted",
+		"site":   siteName,
+		"commit": canary.Commit,
+	})
+}
+
+// CanaryStatus returns a site's canary, or null if none is running
+func (s *Server) CanaryStatus(c *fiber.Ctx) error {
+	siteName := c.Query("site")
+	if siteName == "" {
+		return sendError(c, errMissingSite, "")
+	}
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	return c.JSON(fiber.Map{
+		"site":   siteName,
+		"canary": site.Canary,
+	})
+}
+
+// canarySite reads and checks the site field for canary finalize/abort and
+// experiment stop
+func (s *Server) canarySite(c *fiber.Ctx) (string, *APIError) {
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return "", errInvalidRequest
+	}
+	siteValues := form.Value["site"]
+	if len(siteValues) == 0 {
+		return "", errMissingSite
+	}
+	if _, ok := s.cfg.Site[siteValues[0]]; !ok {
+		return "", errSiteNotFound
+	}
+	return siteValues[0], nil
+}
+
+// canaryError maps canary failures to API errors
+func (s *Server) canaryError(c *fiber.Ctx, log *slog.Logger, err error) error {
+	switch {
+	case errors.Is(err, deploy.ErrCommitNotDeployed):
+		return sendError(c, errCommitNotDeployed, err.Error())
+	case errors.Is(err, deploy.ErrNoCanary):
+		return sendError(c, errNoCanary, err.Error())
+	case errors.Is(err, deploy.ErrRolloutConflict):
+		return sendError(c, errRolloutConflict, err.Error())
+	}
+	log.Error("canary change failed", "error", err)
+	return sendError(c, errCanaryFailed, err.Error())
+}
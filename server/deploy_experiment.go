@@ -0,0 +1,180 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// Experiment handles POST /deploy/frontend/experiment, splitting a site's
+// clients between variants. Each variant field is name:commit:weight, where
+// commit is a deployed commit or latest. Post again with the same name to
+// change the variants; clients keep theirs.
+func (s *Server) Experiment(c *fiber.Ctx) error {
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
+	}
+
+	siteValues := form.Value["site"]
+	nameValues := form.Value["name"]
+	variantValues := form.Value["variant"]
+	if len(siteValues) == 0 || len(nameValues) == 0 || len(variantValues) == 0 {
+		return sendError(c, errMissingFields, "site, name and variant are required")
+	}
+	siteName := siteValues[0]
+
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	if site.IsBackendOnly() {
+		return sendError(c, errBackendOnlySite, "")
+	}
+
+	variants := make(map[string]config.VariantConfig, len(variantValues))
+	for _, value := range variantValues {
+		parts := strings.Split(value, ":")
+		if len(parts) != 3 {
+			return sendError(c, errInvalidRequest, "variant must be name:commit:weight")
+		}
+		weight, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return sendError(c, errInvalidRequest, "variant weight must be an integer")
+		}
+		if _, dup := variants[parts[0]]; dup {
+			return sendError(c, errInvalidRequest, "variant "+parts[0]+" is given twice")
+		}
+		variants[parts[0]] = config.VariantConfig{Commit: parts[1], Weight: weight}
+	}
+	experiment := config.ExperimentConfig{Name: nameValues[0], Variants: variants}
+	if err := experiment.Validate(); err != nil {
+		return sendError(c, errInvalidRequest, err.Error())
+	}
+
+	log := reqLog(c).With("site", siteName, "experiment", experiment.Name)
+
+	started, err := s.frontendDeployer.StartExperiment(siteName, experiment.Name, variants)
+	if err != nil {
+		return s.experimentError(c, log, err)
+	}
+
+	log.Info("experiment updated", "variants", len(variants))
+	return c.JSON(fiber.Map{
+		"status":     "experiment",
+		"site":       siteName,
+		"experiment": started,
+	})
+}
+
+// ExperimentWeights handles POST /deploy/frontend/experiment/weights, changing
+// the weights of a running experiment's variants. Each weight field is
+// name:weight; variants not named keep theirs.
+func (s *Server) ExperimentWeights(c *fiber.Ctx) error {
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
+	}
+
+	siteValues := form.Value["site"]
+	weightValues := form.Value["weight"]
+	if len(siteValues) == 0 || len(weightValues) == 0 {
+		return sendError(c, errMissingFields, "site and weight are required")
+	}
+	siteName := siteValues[0]
+
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	if site.Experiment == nil {
+		return sendError(c, errNoExperiment, "")
+	}
+
+	weights := make(map[string]int, len(weightValues))
+	for _, value := range weightValues {
+		name, weightStr, ok := strings.Cut(value, ":")
+		if !ok {
+			return sendError(c, errInvalidRequest, "weight must be name:weight")
+		}
+		if _, exists := site.Experiment.Variants[name]; !exists {
+			return sendError(c, errInvalidRequest, "the experiment has no variant "+name)
+		}
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil {
+			return sendError(c, errInvalidRequest, "weight must be an integer")
+		}
+		weights[name] = weight
+	}
+
+	log := reqLog(c).With("site", siteName, "experiment", site.Experiment.Name)
+
+	experiment, err := s.frontendDeployer.SetExperimentWeights(siteName, weights)
+	if err != nil {
+		return s.experimentError(c, log, err)
+	}
+
+	log.Info("experiment weights updated")
+	return c.JSON(fiber.Map{
+		"status":     "experiment",
+		"site":       siteName,
+		"experiment": experiment,
+	})
+}
+
+// ExperimentStop handles POST /deploy/frontend/experiment/stop, sending
+// everyone back to latest
+func (s *Server) ExperimentStop(c *fiber.Ctx) error {
+	siteName, apiErr := s.canarySite(c)
+	if apiErr != nil {
+		return sendError(c, apiErr, "")
+	}
+	log := reqLog(c).With("site", siteName)
+
+	experiment, err := s.frontendDeployer.StopExperiment(siteName)
+	if err != nil {
+		return s.experimentError(c, log, err)
+	}
+
+	log.Info("experiment stopped", "experiment", experiment.Name)
+	return c.JSON(fiber.Map{
+		"status":     "stopped",
+		"site":       siteName,
+		"experiment": experiment.Name,
+	})
+}
+
+// ExperimentStatus returns a site's experiment, or null if none is running
+func (s *Server) ExperimentStatus(c *fiber.Ctx) error {
+	siteName := c.Query("site")
+	if siteName == "" {
+		return sendError(c, errMissingSite, "")
+	}
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	return c.JSON(fiber.Map{
+		"site":       siteName,
+		"experiment": site.Experiment,
+	})
+}
+
+// experimentError maps experiment failures to API errors
+func (s *Server) experimentError(c *fiber.Ctx, log *slog.Logger, err error) error {
+	switch {
+	case errors.Is(err, deploy.ErrCommitNotDeployed):
+		return sendError(c, errCommitNotDeployed, err.Error())
+	case errors.Is(err, deploy.ErrNoExperiment):
+		return sendError(c, errNoExperiment, err.Error())
+	case errors.Is(err, deploy.ErrRolloutConflict):
+		return sendError(c, errRolloutConflict, err.Error())
+	}
+	log.Error("experiment change failed", "error", err)
+	return sendError(c, errExperimentFailed, err.Error())
+}
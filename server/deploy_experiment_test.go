@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+func TestExperiment_Rejects(t *testing.T) {
+	cfg := &config.Config{
+		Site: map[string]config.SiteConfig{
+			"example.com":        {FrontendRoot: t.TempDir()},
+			"canary.example.com": {FrontendRoot: t.TempDir(), Canary: &config.CanaryConfig{Commit: "abc1234", Percent: 10}},
+			"api.example.com":    {Backend: &config.BackendConfig{ListenPort: 8080}},
+		},
+	}
+	srv := testServer(cfg)
+	srv.frontendDeployer = deploy.NewFrontendDeployer(cfg, deploy.Managers{})
+
+	app := fiber.New()
+	app.Post("/deploy/frontend/experiment", srv.Experiment)
+	app.Post("/deploy/frontend/experiment/weights", srv.ExperimentWeights)
+	app.Post("/deploy/frontend/experiment/stop", srv.ExperimentStop)
+
+	tests := []struct {
+		name   string
+		path   string
+		fields [][2]string
+		want   string
+	}{
+		{"no variants", "", [][2]string{{"site", "example.com"}, {"name", "checkout"}}, "missing_fields"},
+		{"backend-only site", "", [][2]string{{"site", "api.example.com"}, {"name", "checkout"}, {"variant", "a:latest:1"}}, "backend_only_site"},
+		{"malformed variant", "", [][2]string{{"site", "example.com"}, {"name", "checkout"}, {"variant", "a:latest"}}, "invalid_request"},
+		{"one variant", "", [][2]string{{"site", "example.com"}, {"name", "checkout"}, {"variant", "a:latest:1"}}, "invalid_request"},
+		{"commit not deployed", "", [][2]string{{"site", "example.com"}, {"name", "checkout"}, {"variant", "a:latest:1"}, {"variant", "b:abc1234:1"}}, "commit_not_deployed"},
+		{"canary running", "", [][2]string{{"site", "canary.example.com"}, {"name", "checkout"}, {"variant", "a:latest:1"}, {"variant", "b:latest:1"}}, "rollout_conflict"},
+		{"weights without experiment", "/weights", [][2]string{{"site", "example.com"}, {"weight", "a:1"}}, "no_experiment"},
+		{"stop without experiment", "/stop", [][2]string{{"site", "example.com"}}, "no_experiment"},
+	}
+	for _, tt := range tests {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for _, f := range tt.fields {
+			writer.WriteField(f[0], f[1])
+		}
+		writer.Close()
+		req := httptest.NewRequest("POST", "/deploy/frontend/experiment"+tt.path, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.name, err)
+		}
+		var result map[string]any
+		json.NewDecoder(resp.Body).Decode(&result)
+		if result["error"] != tt.want {
+			t.Errorf("%s: error = %v, want %s", tt.name, result["error"], tt.want)
+		}
+	}
+
+	if cfg.Site["example.com"].Experiment != nil {
+		t.Error("rejected requests should not start an experiment")
+	}
+}
@@ -3,11 +3,18 @@ package server
 import (
 	"bytes"
 	_ "embed"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
 	"regexp"
 	"text/template"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/artifact"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
 	"github.com/lachierussell/shipyard/nginx"
 )
 
@@ -15,8 +22,9 @@ import (
 var commitHashRegex = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
 
 type nginxDefaultData struct {
-	ServerName   string
-	FrontendRoot string
+	ServerName         string
+	FrontendRoot       string
+	FrontendDirectives string
 }
 
 //go:embed nginx_default.conf.tmpl
@@ -27,23 +35,16 @@ var nginxDefaultTmpl = template.Must(template.New("nginx_default").Delims("<%",
 // DeployFrontend handles POST /deploy/frontend
 func (s *Server) DeployFrontend(c *fiber.Ctx) error {
 	// Parse form data
-	form, err := c.MultipartForm()
+	form, err := requestForm(c, s.cfg)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "invalid_request",
-			"detail": "failed to parse multipart form",
-		})
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
 	}
 
 	// Get form fields
 	siteValues := form.Value["site"]
 	commitValues := form.Value["commit"]
 	if len(siteValues) == 0 || len(commitValues) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "missing_fields",
-		})
+		return sendError(c, errMissingFields, "")
 	}
 
 	siteName := siteValues[0]
@@ -58,46 +59,50 @@ func (s *Server) DeployFrontend(c *fiber.Ctx) error {
 	// Validate site exists
 	site, ok := s.cfg.Site[siteName]
 	if !ok {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"status": "error",
-			"error":  "site_not_found",
-		})
+		return sendError(c, errSiteNotFound, "")
+	}
+
+	// A site with a staging site runs only what was promoted from it
+	if staging := s.cfg.StagingSite(siteName); staging != "" {
+		return sendError(c, errPromotionRequired, "deploy to "+staging+" first")
 	}
 
 	// Reject frontend deploys for backend-only sites
 	if site.IsBackendOnly() {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "backend_only_site",
-			"detail": "this site has no frontend; use /deploy/backend instead",
-		})
+		return sendError(c, errBackendOnlySite, "this site has no frontend; use /deploy/backend instead")
 	}
 
 	// Validate commit hash format (7-40 char hex)
 	if !isValidCommitHash(commitHash) {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "invalid_commit_hash",
-			"detail": "must be 7-40 char hex string",
-		})
+		return sendError(c, errInvalidCommitHash, "must be 7-40 char hex string")
 	}
 
-	// Get artifact file
-	files := form.File["artifact"]
-	if len(files) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "missing_artifact",
-		})
+	// With run_at, or on sites requiring approval, the deploy is stored now
+	// and runs later
+	runAt, apiErr, detail := s.requestRunAt(form)
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
 	}
 
-	artifactFile := files[0]
+	// Wildcard sites deploy to one subdomain at a time
+	subdomain := ""
+	if config.IsWildcardDomain(siteName) {
+		if values := form.Value["subdomain"]; len(values) > 0 {
+			subdomain = values[0]
+		}
+		if !config.ValidSubdomainLabel(subdomain) {
+			return sendError(c, errInvalidSubdomain, "")
+		}
+		if len(form.Value["nginx_config"]) > 0 || len(form.File["nginx_config"]) > 0 {
+			return sendError(c, errWildcardNginxConfig, "")
+		}
+	}
 
 	// Get nginx config (optional - will use default if not provided)
-	var nginxConfig string
+	var userConfig string
 	nginxConfigValues := form.Value["nginx_config"]
 	if len(nginxConfigValues) > 0 && nginxConfigValues[0] != "" {
-		nginxConfig = nginxConfigValues[0]
+		userConfig = nginxConfigValues[0]
 	} else {
 		// Try as file
 		nginxFiles := form.File["nginx_config"]
@@ -105,99 +110,191 @@ func (s *Server) DeployFrontend(c *fiber.Ctx) error {
 			nginxFile := nginxFiles[0]
 			src, err := nginxFile.Open()
 			if err != nil {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"status": "error",
-					"error":  "failed to read nginx_config file",
-				})
+				return sendError(c, errNginxConfigReadFailed, "")
 			}
 			defer src.Close()
 
 			nginxBytes := make([]byte, nginxFile.Size)
 			if _, err := src.Read(nginxBytes); err != nil {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"status": "error",
-					"error":  "failed to read nginx_config",
-				})
+				return sendError(c, errNginxConfigReadFailed, "")
 			}
-			nginxConfig = string(nginxBytes)
+			userConfig = string(nginxBytes)
 		}
 	}
 
-	// Use default nginx config if none provided
-	if nginxConfig == "" {
-		var buf bytes.Buffer
-		if err := nginxDefaultTmpl.Execute(&buf, nginxDefaultData{
-			ServerName:   siteName,
-			FrontendRoot: site.FrontendRoot,
-		}); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status": "error",
-				"error":  "nginx_config_generation_failed",
-				"detail": err.Error(),
-			})
-		}
-		nginxConfig = buf.String()
-	} else {
-		// Render user-provided config as a template with site data
-		rendered, err := nginx.RenderUserConfig(nginxConfig, siteName, s.cfg)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"status": "error",
-				"error":  "nginx_template_error",
-				"detail": err.Error(),
-			})
+	log := reqLog(c).With("site", siteName, "commit", commitHash)
+	if subdomain != "" {
+		log = log.With("subdomain", subdomain)
+	}
+
+	nginxConfig, apiErr, detail := s.frontendNginxConfig(log, siteName, subdomain, userConfig, isAdminRequest(c))
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
+	}
+
+	// Get the artifact: uploaded, or downloaded from artifact_url
+	src, apiErr, detail := s.openArtifact(form)
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
+	}
+	defer src.Close()
+
+	// Refuse deploys that would take the site over its disk quota
+	if err := deploy.CheckQuota(s.cfg, siteName, src.Size); err != nil {
+		var quotaErr *deploy.QuotaError
+		if errors.As(err, &quotaErr) {
+			return sendError(c, errQuotaExceeded, err.Error())
 		}
-		nginxConfig = rendered
+		return sendError(c, errUsageFailed, err.Error())
 	}
 
-	// Open the artifact file
-	src, err := artifactFile.Open()
+	log.Info("frontend deploy started", "artifact_url", src.URL, "artifact_size", src.Size)
+
+	// Keep the artifact for /deploy/redeploy, then deploy from the stored copy
+	artifactReader, sha256, err := s.keepArtifact(src, artifact.Meta{
+		Site:          siteName,
+		Kind:          artifact.KindFrontend,
+		Commit:        commitHash,
+		SourceURL:     src.URL,
+		NginxConfig:   nginxConfig,
+		NginxTemplate: userConfig,
+		Subdomain:     subdomain,
+	})
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "failed to read artifact",
-		})
+		log.Error("store artifact failed", "error", err)
+		return sendError(c, errArtifactStoreFailed, err.Error())
 	}
-	defer src.Close()
 
-	log := reqLog(c).With("site", siteName, "commit", commitHash)
-	log.Info("frontend deploy started")
+	op := operation{Kind: "deploy_frontend", Site: siteName, Commit: commitHash}
+	if !runAt.IsZero() || site.RequireApproval {
+		artifactReader.Close()
+		return s.scheduleDeploy(c, log, scheduledDeploy{Operation: op.Kind, Site: siteName, Kind: artifact.KindFrontend, Commit: commitHash, UpdateLatest: updateLatest, RunAt: runAt})
+	}
+	return s.respondDeploy(c, form, log, op, artifactReader, func(log *slog.Logger) deployResult {
+		return s.runFrontendDeploy(log, siteName, subdomain, commitHash, artifactReader, nginxConfig, updateLatest, sha256)
+	})
+}
 
-	// Check that frontend root exists (site must be initialized)
-	reloaded, nginxErr, err := s.frontendDeployer.Deploy(siteName, commitHash, src, nginxConfig, updateLatest)
+// runFrontendDeploy deploys a frontend artifact and returns the response.
+// subdomain is set for wildcard sites only.
+func (s *Server) runFrontendDeploy(log *slog.Logger, siteName, subdomain, commitHash string, src io.Reader, nginxConfig string, updateLatest bool, sha256 string) (r deployResult) {
+	site := s.cfg.Site[siteName]
+	ctx, log, deployID := beginDeploy(log)
+	defer func() { r.Body["deploy_id"] = deployID }()
 
+	frontendRoot := site.FrontendRoot
+	var reloaded bool
+	var nginxErr string
+	var err error
+	if subdomain != "" {
+		frontendRoot = site.SubdomainRoot(subdomain)
+		reloaded, nginxErr, err = s.frontendDeployer.DeploySubdomain(ctx, siteName, subdomain, commitHash, src, nginxConfig, updateLatest)
+	} else {
+		reloaded, nginxErr, err = s.frontendDeployer.Deploy(ctx, siteName, commitHash, src, nginxConfig, updateLatest)
+	}
+	if errors.Is(err, deploy.ErrArtifactRejected) {
+		log.Warn("frontend artifact rejected", "error", err)
+		return errorResult(errArtifactRejected, err.Error())
+	}
 	if err != nil {
 		log.Error("frontend deploy failed", "error", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error",
-			"error":  "deployment_failed",
-			"detail": err.Error(),
-		})
+		return errorResult(errDeploymentFailed, err.Error())
 	}
+	build := s.inspectBuild(log, siteName, filepath.Join(frontendRoot, commitHash), commitHash)
 
 	if !reloaded {
 		log.Warn("frontend deploy partial: nginx validation failed", "nginx_error", nginxErr)
-		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+		return deployResult{fiber.StatusUnprocessableEntity, fiber.Map{
 			"status":          "partially_deployed",
-			"error":           "nginx_validation_failed",
+			"error":           errNginxValidation.Code,
 			"detail":          nginxErr,
 			"commit_deployed": true,
 			"nginx_reloaded":  false,
 			"latest_updated":  updateLatest,
 			"site":            siteName,
 			"commit":          commitHash,
-		})
+			"build":           build,
+		}}
 	}
 
 	log.Info("frontend deploy succeeded", "update_latest", updateLatest)
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"status":         "deployed",
-		"site":           siteName,
-		"commit":         commitHash,
-		"path":           fmt.Sprintf("%s/%s", site.FrontendRoot, commitHash),
-		"nginx_reloaded": true,
-		"latest_updated": updateLatest,
-	})
+	return deployResult{fiber.StatusOK, fiber.Map{
+		"status":          "deployed",
+		"site":            siteName,
+		"commit":          commitHash,
+		"path":            fmt.Sprintf("%s/%s", frontendRoot, commitHash),
+		"nginx_reloaded":  true,
+		"latest_updated":  updateLatest,
+		"artifact_sha256": sha256,
+		"build":           build,
+	}}
+}
+
+// inspectBuild reports on an extracted frontend build and records the report
+// with the stored artifact. Problems with the build are logged as warnings;
+// a failed inspection only leaves the report out.
+func (s *Server) inspectBuild(log *slog.Logger, siteName, commitDir, commitHash string) *artifact.BuildReport {
+	build, err := artifact.InspectBuild(commitDir)
+	if err != nil {
+		log.Warn("build inspection failed", "error", err)
+		return nil
+	}
+	for _, w := range build.Warnings {
+		log.Warn("suspicious frontend build", "warning", w)
+	}
+	if s.artifacts.Enabled() {
+		if err := s.artifacts.SetBuild(siteName, artifact.KindFrontend, commitHash, build); err != nil && !errors.Is(err, artifact.ErrNotStored) {
+			log.Warn("failed to record build report", "error", err)
+		}
+	}
+	return build
+}
+
+// frontendNginxConfig renders a frontend deploy's nginx config: the wildcard
+// or default config, or userConfig (an uploaded config) rendered as a
+// template with the site's data. Unless admin is set the result is held to nginx.policy. On
+// failure it returns the API error to send and a detail message.
+func (s *Server) frontendNginxConfig(log *slog.Logger, siteName, subdomain, userConfig string, admin bool) (string, *APIError, string) {
+	site := s.cfg.Site[siteName]
+
+	var nginxConfig string
+	if subdomain != "" {
+		nginxConfig = nginx.GenerateWildcardConfig(siteName, site, s.cfg)
+	} else if userConfig == "" {
+		var buf bytes.Buffer
+		if err := nginxDefaultTmpl.Execute(&buf, nginxDefaultData{
+			ServerName:         siteName,
+			FrontendRoot:       site.FrontendRoot,
+			FrontendDirectives: nginx.FrontendBlock(site, s.cfg),
+		}); err != nil {
+			return "", errNginxConfigGeneration, err.Error()
+		}
+		nginxConfig = buf.String()
+	} else {
+		rendered, err := nginx.RenderUserConfig(userConfig, siteName, s.cfg)
+		if err != nil {
+			return "", errNginxTemplate, err.Error()
+		}
+		nginxConfig = rendered
+	}
+
+	// Site keys may only use the directives allowed by nginx.policy
+	if !admin {
+		allowed, stripped, err := nginx.EnforcePolicy(nginxConfig, siteName, s.cfg)
+		if err != nil {
+			var policyErr *nginx.PolicyError
+			if errors.As(err, &policyErr) {
+				log.Warn("nginx config refused by directive policy", "violations", len(policyErr.Violations))
+				return "", errNginxPolicy, err.Error()
+			}
+			return "", errNginxTemplate, err.Error()
+		}
+		for _, v := range stripped {
+			log.Warn("removed nginx directive not allowed by policy", "directive", v.Directive, "line", v.Line, "reason", v.Reason)
+		}
+		nginxConfig = allowed
+	}
+	return nginxConfig, nil, ""
 }
 
 // isValidCommitHash checks if a string is a valid git commit hash (7-40 hex chars) or "latest"
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// PromoteFrontend handles POST /deploy/frontend/promote, pointing latest at a
+// commit that was already deployed (typically a branch preview)
+func (s *Server) PromoteFrontend(c *fiber.Ctx) error {
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
+	}
+
+	siteValues := form.Value["site"]
+	commitValues := form.Value["commit"]
+	if len(siteValues) == 0 || len(commitValues) == 0 {
+		return sendError(c, errMissingFields, "")
+	}
+	siteName := siteValues[0]
+	commitHash := commitValues[0]
+
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	if site.IsBackendOnly() {
+		return sendError(c, errBackendOnlySite, "")
+	}
+	// "latest" is not a commit that can be promoted
+	if commitHash == "latest" || !isValidCommitHash(commitHash) {
+		return sendError(c, errInvalidCommitHash, "must be 7-40 char hex string")
+	}
+
+	// Wildcard sites promote one subdomain at a time
+	subdomain := ""
+	if config.IsWildcardDomain(siteName) {
+		if values := form.Value["subdomain"]; len(values) > 0 {
+			subdomain = values[0]
+		}
+		if !config.ValidSubdomainLabel(subdomain) {
+			return sendError(c, errInvalidSubdomain, "")
+		}
+	}
+
+	log := reqLog(c).With("site", siteName, "commit", commitHash)
+	var previous string
+	if subdomain != "" {
+		log = log.With("subdomain", subdomain)
+		previous, err = s.frontendDeployer.PromoteSubdomain(logContext(log), siteName, subdomain, commitHash)
+	} else {
+		previous, err = s.frontendDeployer.Promote(logContext(log), siteName, commitHash)
+	}
+	if err != nil {
+		if errors.Is(err, deploy.ErrCommitNotDeployed) {
+			return sendError(c, errCommitNotDeployed, err.Error())
+		}
+		log.Error("promote failed", "error", err)
+		return sendError(c, errDeploymentFailed, err.Error())
+	}
+
+	if err := s.promotions.Append(deploy.Promotion{
+		Site:           siteName,
+		Subdomain:      subdomain,
+		Commit:         commitHash,
+		PreviousCommit: previous,
+		Initiator:      siteInitiator(s.cfg.Site[siteName].APIKey, c.Get("X-Shipyard-Key"), siteName),
+		RemoteAddr:     c.IP(),
+		RequestID:      requestID(c),
+	}); err != nil {
+		log.Warn("failed to record promotion", "error", err)
+	}
+
+	log.Info("frontend promoted", "previous", previous)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":          "promoted",
+		"site":            siteName,
+		"subdomain":       subdomain,
+		"commit":          commitHash,
+		"previous_commit": previous,
+	})
+}
+
+// Promotions returns the promotion history, newest first (optionally ?site=)
+func (s *Server) Promotions(c *fiber.Ctx) error {
+	promotions, err := s.promotions.List(c.Query("site"))
+	if err != nil {
+		return sendError(c, errHistoryReadFailed, err.Error())
+	}
+	return c.JSON(fiber.Map{
+		"promotions": promotions,
+	})
+}
+
+// siteInitiator identifies the key behind a site-scoped request without revealing it
+func siteInitiator(siteKey, key, siteName string) string {
+	if subtle.ConstantTimeCompare([]byte(key), []byte(siteKey)) == 1 {
+		return "site:" + siteName
+	}
+	return keyInitiator(key)
+}
+
+// requestInitiator identifies who made a request: the OIDC user, or as
+// siteInitiator the key
+func requestInitiator(c *fiber.Ctx, cfg *config.Config, siteName string) string {
+	if user, _ := c.Locals("user").(string); user != "" {
+		return "user:" + user
+	}
+	return siteInitiator(cfg.Site[siteName].APIKey, c.Get("X-Shipyard-Key"), siteName)
+}
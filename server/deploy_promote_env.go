@@ -0,0 +1,277 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/artifact"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// envPromotion is one artifact being promoted from a staging site
+type envPromotion struct {
+	kind        string
+	file        *os.File
+	meta        *artifact.Meta
+	nginxConfig string // rendered for the production site, frontend only
+}
+
+// closeAll closes the files of promotions
+type closeAll []envPromotion
+
+func (ps closeAll) Close() error {
+	for _, p := range ps {
+		p.file.Close()
+	}
+	return nil
+}
+
+// PromoteEnv handles POST /deploy/promote-env: site (the production site) is
+// deployed from the artifacts, binary name and nginx config its staging site
+// was deployed with for commit. kind is "frontend" or "backend"; without it
+// both are promoted, backend first, if staging has them. update_latest
+// defaults to true.
+func (s *Server) PromoteEnv(c *fiber.Ctx) error {
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
+	}
+
+	siteValues := form.Value["site"]
+	commitValues := form.Value["commit"]
+	if len(siteValues) == 0 || len(commitValues) == 0 {
+		return sendError(c, errMissingFields, "")
+	}
+	siteName := siteValues[0]
+	commitHash := commitValues[0]
+
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	if commitHash == "latest" || !isValidCommitHash(commitHash) {
+		return sendError(c, errInvalidCommitHash, "must be 7-40 char hex string")
+	}
+	staging := s.cfg.StagingSite(siteName)
+	if staging == "" {
+		return sendError(c, errNoStagingSite, "")
+	}
+
+	kind := ""
+	if values := form.Value["kind"]; len(values) > 0 && values[0] != "" {
+		kind = values[0]
+		switch {
+		case kind == artifact.KindFrontend && !site.HasFrontend():
+			return sendError(c, errBackendOnlySite, "")
+		case kind == artifact.KindBackend && site.Backend == nil:
+			return sendError(c, errSiteHasNoBackend, "")
+		case kind != artifact.KindFrontend && kind != artifact.KindBackend:
+			return sendError(c, errInvalidRequest, "kind must be frontend or backend")
+		}
+	}
+
+	updateLatest := true
+	if values := form.Value["update_latest"]; len(values) > 0 {
+		updateLatest = values[0] == "true" || values[0] == "1"
+	}
+
+	runAt, apiErr, detail := s.requestRunAt(form)
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
+	}
+
+	if !s.artifacts.Enabled() {
+		return sendError(c, errArtifactNotStored, "artifact storage is disabled (artifacts.keep = -1)")
+	}
+
+	log := reqLog(c).With("site", siteName, "commit", commitHash, "from", staging)
+
+	promotions, apiErr, detail := s.stagePromotion(log, staging, siteName, commitHash, kind, isAdminRequest(c))
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
+	}
+
+	log.Info("environment promotion started", "artifacts", len(promotions), "update_latest", updateLatest)
+
+	initiator := requestInitiator(c, s.cfg, siteName)
+	remoteAddr, reqID := c.IP(), requestID(c)
+	op := operation{Kind: "deploy_promote_env", Site: siteName, Commit: commitHash}
+	if !runAt.IsZero() || site.RequireApproval {
+		// The copies stay in the site's store until the promotion runs
+		promotions.Close()
+		if len(promotions) == 1 {
+			kind = promotions[0].kind
+		}
+		return s.scheduleDeploy(c, log, scheduledDeploy{Operation: op.Kind, Site: siteName, Kind: kind, Commit: commitHash, UpdateLatest: updateLatest, RunAt: runAt, From: staging})
+	}
+	return s.respondDeploy(c, form, log, op, promotions, func(log *slog.Logger) deployResult {
+		return s.runPromotion(log, siteName, staging, commitHash, promotions, updateLatest, initiator, remoteAddr, reqID)
+	})
+}
+
+// promotionKinds returns the artifact kinds a promotion of kind ("" for
+// both) deploys, in the order they are deployed
+func promotionKinds(kind string) []string {
+	if kind != "" {
+		return []string{kind}
+	}
+	return []string{artifact.KindBackend, artifact.KindFrontend}
+}
+
+// stagePromotion copies the artifacts staging was deployed with for commit
+// into the site's store and opens the copies. With kind empty, both kinds the
+// site has are promoted if staging has them.
+func (s *Server) stagePromotion(log *slog.Logger, staging, siteName, commitHash, kind string, admin bool) (closeAll, *APIError, string) {
+	site := s.cfg.Site[siteName]
+	var promotions closeAll
+	var size int64
+	for _, k := range promotionKinds(kind) {
+		if (k == artifact.KindFrontend && !site.HasFrontend()) || (k == artifact.KindBackend && site.Backend == nil) {
+			continue
+		}
+		p, apiErr, detail := s.copyStagingArtifact(log, staging, siteName, k, commitHash, kind != "", admin)
+		if apiErr != nil {
+			promotions.Close()
+			return nil, apiErr, detail
+		}
+		if p != nil {
+			promotions = append(promotions, *p)
+			size += p.meta.Size
+		}
+	}
+	if len(promotions) == 0 {
+		return nil, errArtifactNotStored, fmt.Sprintf("%s has no stored artifact for %s", staging, commitHash)
+	}
+
+	if err := deploy.CheckQuota(s.cfg, siteName, size); err != nil {
+		promotions.Close()
+		var quotaErr *deploy.QuotaError
+		if errors.As(err, &quotaErr) {
+			return nil, errQuotaExceeded, err.Error()
+		}
+		return nil, errUsageFailed, err.Error()
+	}
+	return promotions, nil, ""
+}
+
+// openPromotion reopens the copies stagePromotion made for a promotion that
+// was held or scheduled
+func (s *Server) openPromotion(siteName, commitHash, kind string) (closeAll, *APIError, string) {
+	var promotions closeAll
+	for _, k := range promotionKinds(kind) {
+		f, meta, err := s.artifacts.Open(siteName, k, commitHash)
+		if err != nil {
+			promotions.Close()
+			if errors.Is(err, artifact.ErrNotStored) {
+				return nil, errArtifactNotStored, err.Error()
+			}
+			return nil, errArtifactReadFailed, err.Error()
+		}
+		promotions = append(promotions, envPromotion{kind: k, file: f, meta: meta, nginxConfig: meta.NginxConfig})
+	}
+	return promotions, nil, ""
+}
+
+// runPromotion deploys the promoted artifacts in order, stopping at the
+// first failure
+func (s *Server) runPromotion(log *slog.Logger, siteName, staging, commitHash string, promotions closeAll, updateLatest bool, initiator, remoteAddr, requestID string) deployResult {
+	body := fiber.Map{
+		"status": "promoted",
+		"site":   siteName,
+		"from":   staging,
+		"commit": commitHash,
+	}
+	for _, p := range promotions {
+		var r deployResult
+		if p.kind == artifact.KindBackend {
+			r = s.runBackendDeploy(log, siteName, commitHash, p.file, binarySpec(p.meta), p.meta.SHA256)
+		} else {
+			previous, _, _ := deploy.LatestCommit(s.cfg.Site[siteName].FrontendRoot)
+			r = s.runFrontendDeploy(log, siteName, "", commitHash, p.file, p.nginxConfig, updateLatest, p.meta.SHA256)
+			if r.Status == fiber.StatusOK && updateLatest {
+				s.recordEnvPromotion(log, siteName, commitHash, previous, staging, initiator, remoteAddr, requestID)
+			}
+		}
+		body[p.kind] = r.Body
+		if r.Status != fiber.StatusOK {
+			body["status"] = r.Body["status"]
+			body["error"] = r.Body["error"]
+			return deployResult{r.Status, body}
+		}
+	}
+	return deployResult{fiber.StatusOK, body}
+}
+
+// copyStagingArtifact copies the artifact staging was deployed with into the
+// production site's store and opens the copy. A missing artifact is an error
+// when required, else nil.
+func (s *Server) copyStagingArtifact(log *slog.Logger, staging, siteName, kind, commitHash string, required, admin bool) (*envPromotion, *APIError, string) {
+	f, meta, err := s.artifacts.Open(staging, kind, commitHash)
+	if err != nil {
+		if errors.Is(err, artifact.ErrNotStored) {
+			if !required {
+				return nil, nil, ""
+			}
+			return nil, errArtifactNotStored, err.Error()
+		}
+		return nil, errArtifactReadFailed, err.Error()
+	}
+	defer f.Close()
+
+	// The production config comes from the same uploaded template, rendered
+	// for the production site
+	nginxConfig := ""
+	if kind == artifact.KindFrontend {
+		var apiErr *APIError
+		var detail string
+		nginxConfig, apiErr, detail = s.frontendNginxConfig(log, siteName, "", meta.NginxTemplate, admin)
+		if apiErr != nil {
+			return nil, apiErr, detail
+		}
+	}
+
+	stored, err := s.artifacts.Put(artifact.Meta{
+		Site:          siteName,
+		Kind:          kind,
+		Commit:        commitHash,
+		SourceURL:     meta.SourceURL,
+		NginxConfig:   nginxConfig,
+		NginxTemplate: meta.NginxTemplate,
+		BinaryName:    meta.BinaryName,
+		BinaryPath:    meta.BinaryPath,
+		Format:        meta.Format,
+	}, f)
+	if stored == nil {
+		return nil, errArtifactStoreFailed, err.Error()
+	}
+	if err != nil {
+		log.Warn("artifact retention", "error", err)
+	}
+	if stored.SHA256 != meta.SHA256 {
+		return nil, errArtifactChanged, fmt.Sprintf("staging has %s, copied %s", meta.SHA256, stored.SHA256)
+	}
+
+	copied, _, err := s.artifacts.Open(siteName, kind, commitHash)
+	if err != nil {
+		return nil, errArtifactReadFailed, err.Error()
+	}
+	return &envPromotion{kind: kind, file: copied, meta: stored, nginxConfig: nginxConfig}, nil, ""
+}
+
+// recordEnvPromotion adds a promotion from staging to the promotion log
+func (s *Server) recordEnvPromotion(log *slog.Logger, siteName, commitHash, previous, staging, initiator, remoteAddr, requestID string) {
+	if err := s.promotions.Append(deploy.Promotion{
+		Site:           siteName,
+		Commit:         commitHash,
+		PreviousCommit: previous,
+		From:           staging,
+		Initiator:      initiator,
+		RemoteAddr:     remoteAddr,
+		RequestID:      requestID,
+	}); err != nil {
+		log.Warn("failed to record promotion", "error", err)
+	}
+}
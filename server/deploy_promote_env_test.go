@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/artifact"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestPromoteEnv(t *testing.T) {
+	srv := testServer(&config.Config{
+		Site: map[string]config.SiteConfig{
+			"example.com":         {FrontendRoot: t.TempDir(), APIKey: "sk-prod"},
+			"staging.example.com": {FrontendRoot: t.TempDir(), APIKey: "sk-staging", StagingOf: "example.com"},
+		},
+	})
+	srv.artifacts = artifact.NewStore(t.TempDir(), 5)
+
+	app := fiber.New()
+	app.Post("/deploy/frontend", srv.DeployFrontend)
+	app.Post("/deploy/promote-env", srv.PromoteEnv)
+
+	post := func(path string, fields map[string]string) (int, string) {
+		t.Helper()
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for k, v := range fields {
+			writer.WriteField(k, v)
+		}
+		writer.Close()
+		req := httptest.NewRequest("POST", path, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		var result map[string]any
+		data, _ := io.ReadAll(resp.Body)
+		json.Unmarshal(data, &result)
+		code, _ := result["error"].(string)
+		return resp.StatusCode, code
+	}
+
+	// Production only takes promotions
+	if status, code := post("/deploy/frontend", map[string]string{"site": "example.com", "commit": "abc1234"}); status != 409 || code != "promotion_required" {
+		t.Errorf("direct deploy = %d %s, want 409 promotion_required", status, code)
+	}
+	if status, code := post("/deploy/promote-env", map[string]string{"site": "staging.example.com", "commit": "abc1234"}); status != 409 || code != "no_staging_site" {
+		t.Errorf("promote staging = %d %s, want 409 no_staging_site", status, code)
+	}
+	if status, code := post("/deploy/promote-env", map[string]string{"site": "example.com", "commit": "abc1234"}); status != 404 || code != "artifact_not_stored" {
+		t.Errorf("promote undeployed commit = %d %s, want 404 artifact_not_stored", status, code)
+	}
+
+	// The stored staging artifact is copied, its nginx config rendered for production
+	staged, err := srv.artifacts.Put(artifact.Meta{
+		Site:          "staging.example.com",
+		Kind:          artifact.KindFrontend,
+		Commit:        "abc1234",
+		NginxConfig:   "server { server_name staging.example.com; }",
+		NginxTemplate: "server { server_name <% .Domain %>; }",
+	}, strings.NewReader("zip bytes"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	p, apiErr, detail := srv.copyStagingArtifact(slog.Default(), "staging.example.com", "example.com", artifact.KindFrontend, "abc1234", true, true)
+	if apiErr != nil {
+		t.Fatalf("copyStagingArtifact: %s %s", apiErr.Code, detail)
+	}
+	defer p.file.Close()
+	if p.meta.SHA256 != staged.SHA256 || p.meta.Site != "example.com" {
+		t.Errorf("copied meta = %+v, want staging's artifact for example.com", p.meta)
+	}
+	if p.nginxConfig != "server { server_name example.com; }" {
+		t.Errorf("nginx config = %q", p.nginxConfig)
+	}
+	if data, _ := io.ReadAll(p.file); string(data) != "zip bytes" {
+		t.Errorf("copied artifact = %q", data)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/artifact"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// Redeploy handles POST /deploy/redeploy, re-running a deploy from a stored
+// artifact. kind is "frontend" or "backend" (default: frontend if the site has
+// one). update_latest applies to frontends as for /deploy/frontend.
+func (s *Server) Redeploy(c *fiber.Ctx) error {
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
+	}
+
+	siteValues := form.Value["site"]
+	commitValues := form.Value["commit"]
+	if len(siteValues) == 0 || len(commitValues) == 0 {
+		return sendError(c, errMissingFields, "")
+	}
+	siteName := siteValues[0]
+	commitHash := commitValues[0]
+
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	if !isValidCommitHash(commitHash) {
+		return sendError(c, errInvalidCommitHash, "must be 7-40 char hex string")
+	}
+
+	kind := artifact.KindFrontend
+	if site.IsBackendOnly() {
+		kind = artifact.KindBackend
+	}
+	if kinds := form.Value["kind"]; len(kinds) > 0 && kinds[0] != "" {
+		kind = kinds[0]
+	}
+	switch {
+	case kind == artifact.KindFrontend && !site.HasFrontend():
+		return sendError(c, errBackendOnlySite, "")
+	case kind == artifact.KindBackend && site.Backend == nil:
+		return sendError(c, errSiteHasNoBackend, "")
+	case kind != artifact.KindFrontend && kind != artifact.KindBackend:
+		return sendError(c, errInvalidRequest, "kind must be frontend or backend")
+	}
+
+	updateLatest := false
+	if values := form.Value["update_latest"]; len(values) > 0 {
+		updateLatest = values[0] == "true" || values[0] == "1"
+	}
+
+	runAt, apiErr, detail := s.requestRunAt(form)
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
+	}
+
+	if !s.artifacts.Enabled() {
+		return sendError(c, errArtifactNotStored, "artifact storage is disabled (artifacts.keep = -1)")
+	}
+	f, meta, err := s.artifacts.Open(siteName, kind, commitHash)
+	if err != nil {
+		if errors.Is(err, artifact.ErrNotStored) {
+			return sendError(c, errArtifactNotStored, err.Error())
+		}
+		return sendError(c, errArtifactReadFailed, err.Error())
+	}
+
+	if err := deploy.CheckQuota(s.cfg, siteName, meta.Size); err != nil {
+		var quotaErr *deploy.QuotaError
+		if errors.As(err, &quotaErr) {
+			f.Close()
+			return sendError(c, errQuotaExceeded, err.Error())
+		}
+		f.Close()
+		return sendError(c, errUsageFailed, err.Error())
+	}
+
+	log := reqLog(c).With("site", siteName, "commit", commitHash)
+	log.Info("redeploy started", "kind", kind, "stored", meta.Stored, "artifact_sha256", meta.SHA256)
+
+	op := operation{Kind: "deploy_redeploy", Site: siteName, Commit: commitHash}
+	if !runAt.IsZero() || site.RequireApproval {
+		f.Close()
+		return s.scheduleDeploy(c, log, scheduledDeploy{Operation: op.Kind, Site: siteName, Kind: kind, Commit: commitHash, UpdateLatest: updateLatest, RunAt: runAt})
+	}
+	return s.respondDeploy(c, form, log, op, f, func(log *slog.Logger) deployResult {
+		return s.runStored(log, siteName, kind, commitHash, f, meta, updateLatest)
+	})
+}
+
+// runStored deploys a stored artifact opened as f
+func (s *Server) runStored(log *slog.Logger, siteName, kind, commitHash string, f io.Reader, meta *artifact.Meta, updateLatest bool) deployResult {
+	if kind == artifact.KindBackend {
+		return s.runBackendDeploy(log, siteName, commitHash, f, binarySpec(meta), meta.SHA256)
+	}
+	if meta.Subdomain != "" {
+		log = log.With("subdomain", meta.Subdomain)
+	}
+	return s.runFrontendDeploy(log, siteName, meta.Subdomain, commitHash, f, meta.NginxConfig, updateLatest, meta.SHA256)
+}
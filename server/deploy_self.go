@@ -2,33 +2,61 @@ package server
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/update"
 )
 
-// DeploySelf handles shipyard's own update
+// DeploySelf handles shipyard's own update.
+// ?force=true bypasses the downgrade, min_version and pin_version checks.
 func (s *Server) DeploySelf(c *fiber.Ctx) error {
 	log := reqLog(c)
 
 	body := c.Body()
 	if len(body) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "request body is empty",
-		})
+		return sendError(c, errEmptyBody, "")
 	}
 
-	log.Info("self-update started", "binary_size", len(body))
+	force := c.QueryBool("force")
+	log.Info("self-update started", "binary_size", len(body), "force", force)
 
 	// Perform the update
-	if err := s.updater.Update(bytes.NewReader(body)); err != nil {
+	policy := update.Policy{
+		CurrentVersion: s.version,
+		MinVersion:     s.cfg.Self.MinVersion,
+		PinVersion:     s.cfg.Self.PinVersion,
+		Force:          force,
+	}
+	info, err := s.updater.Update(bytes.NewReader(body), policy)
+	if err != nil {
+		var policyErr *update.PolicyError
+		if errors.As(err, &policyErr) {
+			log.Warn("self-update refused", "new_version", info.Version, "reason", policyErr.Reason)
+			return sendError(c, errVersionPolicy, policyErr.Reason)
+		}
 		log.Error("self-update failed", "error", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return sendError(c, errSelfUpdateFailed, err.Error())
+	}
+
+	if err := s.updateHistory.Append(update.Record{
+		Event:      update.EventUpdate,
+		OldVersion: s.version,
+		OldCommit:  s.commit,
+		NewVersion: info.Version,
+		NewCommit:  info.Commit,
+		Initiator:  keyInitiator(c.Get("X-Shipyard-Key")),
+		RemoteAddr: c.IP(),
+		RequestID:  requestID(c),
+		Forced:     force,
+	}); err != nil {
+		log.Warn("failed to record self-update history", "error", err)
 	}
 
-	log.Info("self-update succeeded, scheduling restart")
+	log.Info("self-update succeeded, scheduling restart", "old_version", s.version, "new_version", info.Version)
 
 	// Schedule graceful shutdown after response is sent
 	go func() {
@@ -38,7 +66,31 @@ func (s *Server) DeploySelf(c *fiber.Ctx) error {
 	}()
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"status":  "restarting",
-		"message": "Update successful, restarting...",
+		"status":      "restarting",
+		"message":     "Update successful, restarting...",
+		"old_version": s.version,
+		"new_version": info.Version,
 	})
 }
+
+// SelfUpdates returns the self-update history, newest first
+func (s *Server) SelfUpdates(c *fiber.Ctx) error {
+	records, err := s.updateHistory.List()
+	if err != nil {
+		return sendError(c, errHistoryReadFailed, err.Error())
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"version":     s.version,
+		"commit":      s.commit,
+		"min_version": s.cfg.Self.MinVersion,
+		"pin_version": s.cfg.Self.PinVersion,
+		"updates":     records,
+	})
+}
+
+// keyInitiator identifies the admin key that made a request without revealing it
+func keyInitiator(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "admin:" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,391 @@
+package server
+
+import "github.com/gofiber/fiber/v2"
+
+// APIError is a machine-readable error returned by the API.
+// Responses carry the code in "error" alongside "message", an optional
+// request-specific "detail", and a "remediation" hint.
+type APIError struct {
+	Code        string `json:"code"`
+	HTTPStatus  int    `json:"http_status"`
+	Message     string `json:"message"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// errorCatalogue lists every error the API can return, in registration order
+var errorCatalogue []*APIError
+
+// defineError registers an error in the catalogue
+func defineError(code string, status int, message, remediation string) *APIError {
+	e := &APIError{Code: code, HTTPStatus: status, Message: message, Remediation: remediation}
+	errorCatalogue = append(errorCatalogue, e)
+	return e
+}
+
+// Request errors
+var (
+	errInvalidRequest = defineError("invalid_request", fiber.StatusBadRequest,
+		"The request body could not be parsed",
+		"Send multipart/form-data (or JSON for /site/create) with the documented fields")
+	errMissingFields = defineError("missing_fields", fiber.StatusBadRequest,
+		"Required form fields are missing",
+		"Include both the site and commit fields")
+	errMissingSite = defineError("missing_site", fiber.StatusBadRequest,
+		"No site was specified",
+		"Pass the site as a form field or ?site= query parameter")
+	errMissingDomain = defineError("missing_domain", fiber.StatusBadRequest,
+		"No domain was specified",
+		"Include the domain field in the request body")
+	errInvalidDomain = defineError("invalid_domain", fiber.StatusBadRequest,
+		"The domain is not valid",
+		"Use a lowercase hostname made of letters, digits, dots and hyphens")
+	errInvalidCommitHash = defineError("invalid_commit_hash", fiber.StatusBadRequest,
+		"The commit is not a valid git hash",
+		"Pass a 7-40 character hex commit hash, e.g. $(git rev-parse HEAD)")
+	errMissingArtifact = defineError("missing_artifact", fiber.StatusBadRequest,
+		"No artifact was provided",
+		"Attach the build as the artifact form file or pass artifact_url")
+	errArtifactReadFailed = defineError("artifact_read_failed", fiber.StatusBadRequest,
+		"The uploaded artifact could not be read",
+		"Retry the upload; check the file is not truncated")
+	errInvalidArtifactURL = defineError("invalid_artifact_url", fiber.StatusBadRequest,
+		"The artifact_url cannot be used",
+		"Use an https:// (e.g. presigned) or s3://bucket/key URL on a host in artifacts.allowed_hosts")
+	errArtifactChecksum = defineError("artifact_checksum_mismatch", fiber.StatusBadRequest,
+		"The downloaded artifact does not match artifact_sha256",
+		"Check the URL points at the build you meant and the checksum is its SHA-256")
+	errArtifactRejected = defineError("artifact_rejected", fiber.StatusUnprocessableEntity,
+		"The artifact breaks the artifact policy",
+		"Remove the file named in detail from the build, or adjust max_extracted_mb, max_compression_ratio or deny_extensions in [artifacts]")
+	errInvalidArtifactFormat = defineError("invalid_artifact_format", fiber.StatusBadRequest,
+		"artifact_format is not zip or binary",
+		"Send artifact_format=binary for a bare executable, or leave it out for a zip")
+	errInvalidBinaryPath = defineError("invalid_binary_path", fiber.StatusBadRequest,
+		"binary_path is not a path inside the zip",
+		"Pass the binary's relative path in the zip, e.g. bin/linux/api; it doesn't apply with artifact_format=binary")
+	errMissingAsset = defineError("missing_asset", fiber.StatusBadRequest,
+		"No asset file was provided",
+		"Attach the file as the file form field")
+	errInvalidAssetPath = defineError("invalid_asset_path", fiber.StatusBadRequest,
+		"The asset path is not valid",
+		"Use a relative path like /favicon.ico or /.well-known/security.txt without .. or other hidden segments")
+	errNginxConfigReadFailed = defineError("nginx_config_read_failed", fiber.StatusBadRequest,
+		"The uploaded nginx_config could not be read",
+		"Send nginx_config as a text field or a readable file")
+	errMissingNginxConfig = defineError("missing_nginx_config", fiber.StatusBadRequest,
+		"Sites with a frontend need an nginx config",
+		"Send nginx_config; see GET /nginx/example?site= for a starting point")
+	errNginxTemplate = defineError("nginx_template_error", fiber.StatusBadRequest,
+		"The nginx config template failed to render",
+		"Fix the template syntax reported in detail")
+	errNginxPolicy = defineError("nginx_directive_denied", fiber.StatusForbidden,
+		"The nginx config uses directives site keys may not use",
+		"Remove the directives listed in detail, deploy with an admin key, or adjust [nginx.policy]")
+	errInvalidSnippetName = defineError("invalid_snippet_name", fiber.StatusBadRequest,
+		"The snippet name is not valid",
+		"Use lowercase letters, digits, hyphens and underscores, e.g. security-headers")
+	errInvalidSnippet = defineError("invalid_snippet", fiber.StatusBadRequest,
+		"The snippet is not a valid list of nginx directives",
+		"Fix the syntax reported in detail; every directive needs a ; or a closed { } block")
+	errEmptyBody = defineError("empty_body", fiber.StatusBadRequest,
+		"The request body is empty",
+		"Send the new binary as the raw request body")
+	errSiteHasNoBackend = defineError("site_has_no_backend", fiber.StatusBadRequest,
+		"The site has no backend configured",
+		"Add a [site.<name>.backend] section or use /deploy/frontend")
+	errMaintenanceUnsupported = defineError("maintenance_unsupported", fiber.StatusBadRequest,
+		"The site's nginx config does not check the maintenance flag",
+		"Set restart_response = \"503\" on the site's backend and regenerate its nginx config")
+	errBackendOnlySite = defineError("backend_only_site", fiber.StatusBadRequest,
+		"The site has no frontend",
+		"Use /deploy/backend for backend-only sites")
+	errInvalidSubdomain = defineError("invalid_subdomain", fiber.StatusBadRequest,
+		"Wildcard sites need a valid subdomain to deploy to",
+		"Send a subdomain field with one lowercase DNS label, e.g. pr-42 for pr-42.docs.example.com")
+	errInvalidBackendAction = defineError("invalid_backend_action", fiber.StatusBadRequest,
+		"The backend action is not one of restart, stop or start",
+		"POST to /site/backend/restart, /site/backend/stop or /site/backend/start")
+	errInvalidRunAt = defineError("invalid_run_at", fiber.StatusBadRequest,
+		"run_at is not a time in the next 30 days",
+		"Pass run_at as an RFC 3339 time in the future, e.g. 2026-01-02T09:00:00+08:00")
+	errInvalidCSPReport = defineError("invalid_csp_report", fiber.StatusBadRequest,
+		"The body is not a CSP violation report",
+		"Send an application/csp-report or application/reports+json body, as browsers do for report-uri")
+	errWildcardNginxConfig = defineError("wildcard_nginx_config", fiber.StatusBadRequest,
+		"Wildcard sites use a generated nginx config",
+		"Remove nginx_config; every subdomain shares the site's generated config")
+	errInvalidDesiredState = defineError("invalid_desired_state", fiber.StatusBadRequest,
+		"The desired state cannot be applied",
+		"Fix the site named in detail; to change frontend_root, destroy the site (prune) and create it again")
+	errRequestTooLarge = defineError("request_too_large", fiber.StatusRequestEntityTooLarge,
+		"The request body exceeds the configured limit",
+		"Shrink the artifact or raise server.max_body_mb")
+)
+
+// Auth errors
+var (
+	errMissingAuth = defineError("missing_auth", fiber.StatusUnauthorized,
+		"No API key was provided",
+		"Send the key in the X-Shipyard-Key header")
+	errInvalidKey = defineError("invalid_key", fiber.StatusUnauthorized,
+		"The API key is not valid for this operation",
+		"Use an admin key or the site's api_key")
+	errSiteNotAllowed = defineError("site_not_allowed", fiber.StatusForbidden,
+		"The API key may not manage this site",
+		"Use a key whose key_acl globs match the site, or an unrestricted admin key")
+	errInvalidToken = defineError("invalid_token", fiber.StatusUnauthorized,
+		"The bearer token is not valid",
+		"Sign in again to get a fresh token from the identity provider")
+	errNoGroupAccess = defineError("no_group_access", fiber.StatusForbidden,
+		"None of your groups grant access to shipyard",
+		"Ask an admin to map one of your identity provider groups under [oidc.groups]")
+	errInvalidSignature = defineError("invalid_signature", fiber.StatusUnauthorized,
+		"The request signature is not valid",
+		"Sign timestamp, nonce, method, path and body SHA-256 with the site's api_key and leave out X-Shipyard-Key (see README); use a fresh nonce and a current clock")
+	errSignatureRequired = defineError("signature_required", fiber.StatusUnauthorized,
+		"This site only accepts signed deploy requests",
+		"Send X-Shipyard-Timestamp, X-Shipyard-Nonce and X-Shipyard-Signature")
+	errClientCertRequired = defineError("client_cert_required", fiber.StatusUnauthorized,
+		"A client certificate is required",
+		"Present a certificate signed by the CA in server.client_ca")
+	errSelfApproval = defineError("self_approval", fiber.StatusForbidden,
+		"A deploy cannot be approved by whoever requested it",
+		"Ask another admin to approve it with POST /deploys/<id>/approve")
+)
+
+// State errors
+var (
+	errArtifactNotStored = defineError("artifact_not_stored", fiber.StatusNotFound,
+		"No stored artifact for this site and commit",
+		"Deploy the commit normally; only the newest artifacts.keep deploys can be redeployed")
+	errCommitNotDeployed = defineError("commit_not_deployed", fiber.StatusNotFound,
+		"The commit has not been deployed to this site",
+		"Deploy it first with /deploy/frontend (update_latest=false for a preview)")
+	errNoBaseUpdate = defineError("no_base_update", fiber.StatusNotFound,
+		"No jail base update has run since shipyard started",
+		"Start one with POST /jails/update")
+	errJailTemplateNotFound = defineError("jail_template_not_found", fiber.StatusNotFound,
+		"No jail template has this name",
+		"Check GET /jails/templates or declare it under [jail.template.<name>]")
+	errNoCanary = defineError("no_canary", fiber.StatusNotFound,
+		"The site has no canary in progress",
+		"Start one with POST /deploy/frontend/canary")
+	errNoExperiment = defineError("no_experiment", fiber.StatusNotFound,
+		"The site has no experiment in progress",
+		"Start one with POST /deploy/frontend/experiment")
+	errSnippetNotFound = defineError("snippet_not_found", fiber.StatusNotFound,
+		"No snippet has this name",
+		"Check GET /nginx/snippets or create it with PUT /nginx/snippets/<name>")
+	errAssetNotFound = defineError("asset_not_found", fiber.StatusNotFound,
+		"No asset exists at this path",
+		"Check GET /site/assets?site= for the uploaded assets")
+	errJobNotFound = defineError("job_not_found", fiber.StatusNotFound,
+		"No job for this site has this ID",
+		"Pass the job's site as ?site=; finished jobs are forgotten after the newest 200")
+	errBanNotFound = defineError("ban_not_found", fiber.StatusNotFound,
+		"This IP is not banned",
+		"Check GET /bans; bans are lifted when they expire")
+	errNoManifest = defineError("no_manifest", fiber.StatusNotFound,
+		"No file manifest was recorded for this commit",
+		"Redeploy the commit; commits deployed before shipyard recorded manifests have none")
+	errSiteNotFound = defineError("site_not_found", fiber.StatusNotFound,
+		"The site is not configured",
+		"Check GET /sites or create it with POST /site/create")
+	errCSPReportsDisabled = defineError("csp_reports_disabled", fiber.StatusNotFound,
+		"The site does not collect CSP violation reports",
+		"Set csp.report = true for the site and redeploy its frontend")
+	errStatusPageDisabled = defineError("status_page_disabled", fiber.StatusNotFound,
+		"No status page is configured",
+		"Set status_page.sites or status_page.tag and restart shipyard")
+	errBansDisabled = defineError("bans_disabled", fiber.StatusNotFound,
+		"Abusive clients are not being banned",
+		"Set ban.enabled = true and restart shipyard")
+	errSiteExists = defineError("site_exists", fiber.StatusConflict,
+		"A site with this domain already exists",
+		"Choose another domain or destroy the existing site first")
+	errBackendDeploying = defineError("backend_deploying", fiber.StatusConflict,
+		"The site's backend is being deployed",
+		"Retry once the deploy has finished")
+	errDependencyNotReady = defineError("dependency_not_ready", fiber.StatusFailedDependency,
+		"A backend this one depends on did not become healthy, so it was not started",
+		"See detail for the dependency; start it first, or check its health check")
+	errRolloutConflict = defineError("rollout_conflict", fiber.StatusConflict,
+		"The site is already running a canary or an experiment",
+		"Finalize or abort the canary, or stop the experiment, first")
+	errBaseUpdateRunning = defineError("base_update_running", fiber.StatusConflict,
+		"A jail base update is already running",
+		"Follow it with GET /jails/update")
+	errBaseUpdateNotPaused = defineError("base_update_not_paused", fiber.StatusConflict,
+		"No jail base update is paused",
+		"Check GET /jails/update; start a new one with POST /jails/update")
+	errVersionPolicy = defineError("version_policy", fiber.StatusConflict,
+		"The update was refused by version policy",
+		"Check self.min_version / self.pin_version, or retry with ?force=true")
+	errShuttingDown = defineError("shutting_down", fiber.StatusServiceUnavailable,
+		"Shipyard is shutting down or restarting",
+		"Retry the request in a few seconds")
+	errJobQueueFull = defineError("job_queue_full", fiber.StatusServiceUnavailable,
+		"Too many deploys are waiting in the job queue",
+		"Retry once queued jobs have finished (see GET /jobs)")
+	errPromotionRequired = defineError("promotion_required", fiber.StatusConflict,
+		"The site is deployed only by promotion from its staging site",
+		"Deploy to the staging site, then POST /deploy/promote-env with this site and the commit")
+	errNoStagingSite = defineError("no_staging_site", fiber.StatusConflict,
+		"The site has no staging site to promote from",
+		"Set staging_of = \"<this site>\" on the staging site")
+	errArtifactChanged = defineError("artifact_changed", fiber.StatusConflict,
+		"The promoted artifact does not match the one deployed to staging",
+		"Redeploy the commit to the staging site and promote it again")
+	errAsyncUnavailable = defineError("async_unavailable", fiber.StatusConflict,
+		"Async deploys need artifact storage",
+		"Set artifacts.keep to 1 or more, or deploy without async")
+	errJobNotScheduled = defineError("job_not_scheduled", fiber.StatusConflict,
+		"Only scheduled jobs can be cancelled",
+		"The job has already been queued; check it with GET /jobs/<id>?site=")
+	errNotPendingApproval = defineError("not_pending_approval", fiber.StatusConflict,
+		"The job is not waiting for approval",
+		"Check its state with GET /jobs/<id>?site=")
+	errApprovalExpired = defineError("approval_expired", fiber.StatusConflict,
+		"The deploy was not approved in time",
+		"Request the deploy again and have it approved within deploy.approval_timeout")
+	errDeployFrozen = defineError("deploy_frozen", fiber.StatusLocked,
+		"The site is in a deploy freeze window",
+		"Deploy after the window closes, schedule the deploy with run_at, or retry with an admin key and force=true")
+	errQuotaExceeded = defineError("quota_exceeded", fiber.StatusInsufficientStorage,
+		"The deploy would exceed the site's disk quota",
+		"Remove old commits (see GET /site/usage) or raise the site's quota_mb")
+	errNginxValidation = defineError("nginx_validation_failed", fiber.StatusUnprocessableEntity,
+		"nginx rejected the generated config",
+		"Fix the nginx error reported in detail and redeploy")
+)
+
+// Server-side failures
+var (
+	errDeploymentFailed = defineError("deployment_failed", fiber.StatusInternalServerError,
+		"The deployment failed",
+		"See detail and the shipyard log for the failing step")
+	errNginxConfigGeneration = defineError("nginx_config_generation_failed", fiber.StatusInternalServerError,
+		"The nginx config could not be generated",
+		"See detail; check the site configuration")
+	errNginxDeployment = defineError("nginx_deployment_failed", fiber.StatusInternalServerError,
+		"The nginx config could not be written",
+		"Check permissions on nginx.sites_available and nginx.sites_enabled")
+	errNginxHTTPSDeployment = defineError("nginx_https_deployment_failed", fiber.StatusInternalServerError,
+		"The HTTPS nginx config could not be written",
+		"Check permissions on nginx.sites_available and nginx.sites_enabled")
+	errNginxSetup = defineError("nginx_setup_failed", fiber.StatusInternalServerError,
+		"The initial nginx config could not be deployed",
+		"See detail; run shipyard doctor to check the nginx setup")
+	errKeyGeneration = defineError("key_generation_failed", fiber.StatusInternalServerError,
+		"An API key could not be generated",
+		"Check the host's entropy source and retry")
+	errCertGeneration = defineError("cert_generation_failed", fiber.StatusInternalServerError,
+		"The TLS certificate could not be obtained",
+		"Check DNS points at this host and port 80 is reachable")
+	errCanaryFailed = defineError("canary_failed", fiber.StatusInternalServerError,
+		"The canary could not be applied",
+		"See detail; the previous canary state has been kept")
+	errExperimentFailed = defineError("experiment_failed", fiber.StatusInternalServerError,
+		"The experiment could not be applied",
+		"See detail; the previous experiment state has been kept")
+	errSaveFailed = defineError("save_failed", fiber.StatusInternalServerError,
+		"The config file could not be saved",
+		"Check the shipyard config file is writable")
+	errTemplate = defineError("template_error", fiber.StatusInternalServerError,
+		"A built-in template failed to render",
+		"Report this as a bug with the detail")
+	errLogReadFailed = defineError("log_read_failed", fiber.StatusInternalServerError,
+		"The site log could not be read",
+		"Check the jail's /var/log/app.log permissions")
+	errLogSearchFailed = defineError("log_search_failed", fiber.StatusInternalServerError,
+		"The stored logs could not be searched",
+		"Check the permissions of <state_dir>/logs")
+	errLogSearchDisabled = defineError("log_search_disabled", fiber.StatusNotFound,
+		"Logs are not being kept for searching",
+		"Remove logs.retention_days = -1 and restart shipyard")
+	errNonceSaveFailed = defineError("nonce_save_failed", fiber.StatusInternalServerError,
+		"The signed request's nonce could not be recorded",
+		"Check <state_dir> is writable")
+	errUsageFailed = defineError("usage_failed", fiber.StatusInternalServerError,
+		"The site's disk usage could not be measured",
+		"Check frontend_root is readable")
+	errVerifyFailed = defineError("verify_failed", fiber.StatusInternalServerError,
+		"The commit's files could not be checked",
+		"Check the commit directory under frontend_root is readable")
+	errArtifactDownloadFailed = defineError("artifact_download_failed", fiber.StatusBadGateway,
+		"The artifact could not be downloaded from artifact_url",
+		"Check the URL has not expired and the s3 credentials in [artifacts] can read it")
+	errArtifactStoreFailed = defineError("artifact_store_failed", fiber.StatusInternalServerError,
+		"The artifact could not be stored for redeploys",
+		"Check free space in self.state_dir, or set artifacts.keep = -1 to disable storage")
+	errScheduleSaveFailed = defineError("schedule_save_failed", fiber.StatusInternalServerError,
+		"The scheduled deploy could not be saved",
+		"Check free space and permissions in self.state_dir")
+	errSnippetFailed = defineError("snippet_failed", fiber.StatusInternalServerError,
+		"The snippet could not be read or written",
+		"Check nginx.snippets_dir exists and is writable")
+	errAssetWriteFailed = defineError("asset_write_failed", fiber.StatusInternalServerError,
+		"The site's assets could not be read or written",
+		"Check frontend_root is writable")
+	errHistoryReadFailed = defineError("history_read_failed", fiber.StatusInternalServerError,
+		"A history log could not be read",
+		"Check self.state_dir is readable")
+	errJailTemplateFailed = defineError("jail_template_failed", fiber.StatusInternalServerError,
+		"The jail template could not be built",
+		"See detail for the failing pot command; the partial template pot was removed")
+	errBackendActionFailed = defineError("backend_action_failed", fiber.StatusInternalServerError,
+		"The backend could not be started or stopped",
+		"See detail for the failing step; GET /site/logs shows the backend's output")
+	errMigrationFailed = defineError("migration_failed", fiber.StatusInternalServerError,
+		"The backend's migrate_command failed, so the deploy was aborted",
+		"See migration.output; the previous binary was restored and started if there was one")
+	errApplyFailed = defineError("apply_failed", fiber.StatusInternalServerError,
+		"Applying the desired state stopped at a failed step",
+		"See steps for what was done and what failed, fix the cause and apply again; finished steps drop out of the next plan")
+	errSelfUpdateFailed = defineError("self_update_failed", fiber.StatusInternalServerError,
+		"The new binary could not be installed",
+		"See detail; the running binary is unchanged")
+	errBanFailed = defineError("ban_failed", fiber.StatusInternalServerError,
+		"The bans could not be applied",
+		"See detail; check nginx.override_conf's directory is writable and nginx -t passes")
+)
+
+// sendError writes e as the response, with an optional request-specific detail
+func sendError(c *fiber.Ctx, e *APIError, detail string) error {
+	return sendErrorWith(c, e, detail, nil)
+}
+
+// sendErrorWith is sendError with extra fields in the response body
+func sendErrorWith(c *fiber.Ctx, e *APIError, detail string, extra fiber.Map) error {
+	return c.Status(e.HTTPStatus).JSON(errorBody(e, detail, extra))
+}
+
+// errorBody is the response body for an API error
+func errorBody(e *APIError, detail string, extra fiber.Map) fiber.Map {
+	body := fiber.Map{
+		"status":  "error",
+		"error":   e.Code,
+		"message": e.Message,
+	}
+	if detail != "" {
+		body["detail"] = detail
+	}
+	if e.Remediation != "" {
+		body["remediation"] = e.Remediation
+	}
+	for k, v := range extra {
+		body[k] = v
+	}
+	return body
+}
+
+// Errors returns the error catalogue
+func (s *Server) Errors(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"errors": errorCatalogue,
+	})
+}
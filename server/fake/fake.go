@@ -0,0 +1,459 @@
+// Package fake has in-memory stand-ins for the managers the server changes
+// the host with, so its API can be tested without nginx, pot or a service
+// manager. Pass them to server.New in a server.Managers. Each fake records
+// its calls and can be told to fail.
+package fake
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lachierussell/shipyard/jail"
+	"github.com/lachierussell/shipyard/nginx"
+	"github.com/lachierussell/shipyard/ssl"
+)
+
+// Managers is one of each fake
+type Managers struct {
+	Nginx    *Nginx
+	Jail     *Jails
+	Driver   *Driver
+	Service  *Services
+	SSL      *SSL
+	Firewall *Firewall
+}
+
+// New returns a fresh set of fakes whose sandbox roots are under dir
+func New(dir string) Managers {
+	return Managers{
+		Nginx:    &Nginx{},
+		Jail:     &Jails{},
+		Driver:   &Driver{Dir: dir},
+		Service:  &Services{},
+		SSL:      &SSL{},
+		Firewall: &Firewall{},
+	}
+}
+
+// Recorder records a fake's calls and the errors its methods return
+type Recorder struct {
+	mu    sync.Mutex
+	calls []string
+	errs  map[string]error
+}
+
+// FailWith makes every later call of method return err; a nil err clears it
+func (r *Recorder) FailWith(method string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.errs == nil {
+		r.errs = make(map[string]error)
+	}
+	r.errs[method] = err
+}
+
+// Calls returns the calls so far, each as "Method arg...", oldest first
+func (r *Recorder) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+// call records a call and returns the error set for its method
+func (r *Recorder) call(method string, args ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, strings.Join(append([]string{method}, args...), " "))
+	return r.errs[method]
+}
+
+// Nginx stands in for *nginx.Manager, keeping site configs in memory
+type Nginx struct {
+	Recorder
+	// Invalid, when set, is what nginx -t reports for every site config
+	Invalid string
+
+	mu      sync.Mutex
+	configs map[string]string
+}
+
+// Config returns the config deployed for a site
+func (n *Nginx) Config(siteName string) (string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	conf, ok := n.configs[siteName]
+	return conf, ok
+}
+
+// set stores or, for an empty conf, removes a site's config
+func (n *Nginx) set(siteName, conf string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.configs == nil {
+		n.configs = make(map[string]string)
+	}
+	if conf == "" {
+		delete(n.configs, siteName)
+		return
+	}
+	n.configs[siteName] = conf
+}
+
+// DeploySiteConfig stores a site config, as if nginx accepted and reloaded it
+func (n *Nginx) DeploySiteConfig(ctx context.Context, siteName, nginxConfig string) (bool, string, error) {
+	return n.deploy("DeploySiteConfig", siteName, nginxConfig)
+}
+
+// DeploySiteConfigRaw stores a site config like DeploySiteConfig
+func (n *Nginx) DeploySiteConfigRaw(ctx context.Context, siteName, nginxConfig string) (bool, string, error) {
+	return n.deploy("DeploySiteConfigRaw", siteName, nginxConfig)
+}
+
+// deploy stores a site config unless it fails or is invalid
+func (n *Nginx) deploy(method, siteName, nginxConfig string) (bool, string, error) {
+	if err := n.call(method, siteName); err != nil {
+		return false, "", err
+	}
+	if n.Invalid != "" {
+		return false, n.Invalid, nil
+	}
+	n.set(siteName, nginxConfig)
+	return true, "", nil
+}
+
+// DeployHTTPOnlyConfig stores the HTTP-only config for a new domain
+func (n *Nginx) DeployHTTPOnlyConfig(ctx context.Context, domain string) error {
+	if err := n.call("DeployHTTPOnlyConfig", domain); err != nil {
+		return err
+	}
+	n.set(domain, nginx.GenerateHTTPOnlyConfig(domain))
+	return nil
+}
+
+// RemoveSiteConfig removes a site config
+func (n *Nginx) RemoveSiteConfig(ctx context.Context, siteName string) error {
+	if err := n.call("RemoveSiteConfig", siteName); err != nil {
+		return err
+	}
+	n.set(siteName, "")
+	return nil
+}
+
+// RemoveSiteConfigByDomain removes a domain's config
+func (n *Nginx) RemoveSiteConfigByDomain(ctx context.Context, domain string) error {
+	if err := n.call("RemoveSiteConfigByDomain", domain); err != nil {
+		return err
+	}
+	n.set(domain, "")
+	return nil
+}
+
+// ApplyOverrides records the call
+func (n *Nginx) ApplyOverrides(ctx context.Context) error {
+	return n.call("ApplyOverrides")
+}
+
+// Reload records a reload
+func (n *Nginx) Reload(ctx context.Context) error {
+	return n.call("Reload")
+}
+
+// EnsureRunning reports nginx as reloaded
+func (n *Nginx) EnsureRunning(ctx context.Context) (string, error) {
+	if err := n.call("EnsureRunning"); err != nil {
+		return "", err
+	}
+	return nginx.NginxReloaded, nil
+}
+
+// EnsureSiteLinks finds every site linked already
+func (n *Nginx) EnsureSiteLinks() ([]string, error) {
+	return nil, n.call("EnsureSiteLinks")
+}
+
+// Jails stands in for *jail.Manager's templates and volumes
+type Jails struct {
+	Recorder
+
+	mu    sync.Mutex
+	built map[string]bool
+}
+
+// TemplatePot returns "tpl-<name>" for any template
+func (j *Jails) TemplatePot(name string) (string, error) {
+	if err := j.call("TemplatePot", name); err != nil {
+		return "", err
+	}
+	return "tpl-" + name, nil
+}
+
+// TemplateBuilt reports whether BuildTemplate has built the template
+func (j *Jails) TemplateBuilt(name string) (bool, error) {
+	if err := j.call("TemplateBuilt", name); err != nil {
+		return false, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.built[name], nil
+}
+
+// BuildTemplate marks a template built, reporting whether it wasn't yet
+func (j *Jails) BuildTemplate(ctx context.Context, name string) (string, bool, error) {
+	if err := j.call("BuildTemplate", name); err != nil {
+		return "", false, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.built == nil {
+		j.built = make(map[string]bool)
+	}
+	now := !j.built[name]
+	j.built[name] = true
+	return "tpl-" + name, now, nil
+}
+
+// PurgeVolumes records a purge
+func (j *Jails) PurgeVolumes(siteName string) error {
+	return j.call("PurgeVolumes", siteName)
+}
+
+// Driver stands in for a jail.Driver. Each site's root is a directory under
+// Dir, created when the site's sandbox is.
+type Driver struct {
+	Recorder
+	// Dir holds the sites' roots; a test's t.TempDir(), say
+	Dir string
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+var _ jail.Driver = (*Driver)(nil)
+
+// EnsureExists creates the site's root
+func (d *Driver) EnsureExists(ctx context.Context, siteName string) error {
+	if err := d.call("EnsureExists", siteName); err != nil {
+		return err
+	}
+	return os.MkdirAll(filepath.Join(d.Dir, siteName, "var", "log"), 0755)
+}
+
+// Start marks the sandbox running
+func (d *Driver) Start(ctx context.Context, siteName string) error {
+	if err := d.call("Start", siteName); err != nil {
+		return err
+	}
+	d.setRunning(siteName, true)
+	return nil
+}
+
+// Stop marks the sandbox stopped
+func (d *Driver) Stop(ctx context.Context, siteName string) error {
+	if err := d.call("Stop", siteName); err != nil {
+		return err
+	}
+	d.setRunning(siteName, false)
+	return nil
+}
+
+// Destroy removes the site's root
+func (d *Driver) Destroy(ctx context.Context, siteName string) error {
+	if err := d.call("Destroy", siteName); err != nil {
+		return err
+	}
+	d.setRunning(siteName, false)
+	return os.RemoveAll(filepath.Join(d.Dir, siteName))
+}
+
+// IsRunning reports whether the sandbox was started and not stopped since
+func (d *Driver) IsRunning(siteName string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.running[siteName]
+}
+
+// setRunning records whether a site's sandbox is running
+func (d *Driver) setRunning(siteName string, on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running == nil {
+		d.running = make(map[string]bool)
+	}
+	d.running[siteName] = on
+}
+
+// EnsureUser records the call
+func (d *Driver) EnsureUser(ctx context.Context, siteName string) error {
+	return d.call("EnsureUser", siteName)
+}
+
+// CopyIn records the call; nothing is copied
+func (d *Driver) CopyIn(ctx context.Context, siteName, srcPath, destPath string) error {
+	return d.call("CopyIn", siteName, srcPath, destPath)
+}
+
+// Exec records the command; nothing is run
+func (d *Driver) Exec(ctx context.Context, siteName, command string, args ...string) error {
+	return d.call("Exec", append([]string{siteName, command}, args...)...)
+}
+
+// RootPath returns the site's directory under Dir
+func (d *Driver) RootPath(siteName string) (string, error) {
+	return filepath.Join(d.Dir, siteName), nil
+}
+
+// LogPath returns var/log/app.log under the site's root
+func (d *Driver) LogPath(siteName string) (string, error) {
+	return filepath.Join(d.Dir, siteName, "var", "log", "app.log"), nil
+}
+
+// DiskUsage reports nothing used
+func (d *Driver) DiskUsage(siteName string) (int64, error) {
+	return 0, d.call("DiskUsage", siteName)
+}
+
+// LockRoot records the call
+func (d *Driver) LockRoot(siteName string) error {
+	return d.call("LockRoot", siteName)
+}
+
+// UnlockRoot records the call
+func (d *Driver) UnlockRoot(siteName string) error {
+	return d.call("UnlockRoot", siteName)
+}
+
+// Writable runs fn
+func (d *Driver) Writable(siteName string, fn func() error) error {
+	if err := d.call("Writable", siteName); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// Services stands in for *service.Manager, tracking which backends are
+// enabled and running
+type Services struct {
+	Recorder
+
+	mu      sync.Mutex
+	enabled map[string]bool
+	running map[string]bool
+}
+
+// Enabled reports whether a backend's service is enabled
+func (s *Services) Enabled(siteName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enabled[siteName]
+}
+
+// set records one of a backend's flags
+func (s *Services) set(flags *map[string]bool, siteName string, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if *flags == nil {
+		*flags = make(map[string]bool)
+	}
+	(*flags)[siteName] = on
+}
+
+// WriteEnv records the call; the fake backends get no env
+func (s *Services) WriteEnv(siteName string) ([]string, error) {
+	return nil, s.call("WriteEnv", siteName)
+}
+
+// CreateBackendService records the call
+func (s *Services) CreateBackendService(siteName string) error {
+	return s.call("CreateBackendService", siteName)
+}
+
+// RemoveBackendService records the call
+func (s *Services) RemoveBackendService(siteName string) error {
+	return s.call("RemoveBackendService", siteName)
+}
+
+// Enable marks the service enabled
+func (s *Services) Enable(siteName string) error {
+	if err := s.call("Enable", siteName); err != nil {
+		return err
+	}
+	s.set(&s.enabled, siteName, true)
+	return nil
+}
+
+// Disable marks the service disabled
+func (s *Services) Disable(siteName string) error {
+	if err := s.call("Disable", siteName); err != nil {
+		return err
+	}
+	s.set(&s.enabled, siteName, false)
+	return nil
+}
+
+// Start marks the service running
+func (s *Services) Start(siteName string) error {
+	if err := s.call("Start", siteName); err != nil {
+		return err
+	}
+	s.set(&s.running, siteName, true)
+	return nil
+}
+
+// Stop marks the service stopped
+func (s *Services) Stop(siteName string) error {
+	if err := s.call("Stop", siteName); err != nil {
+		return err
+	}
+	s.set(&s.running, siteName, false)
+	return nil
+}
+
+// Status reports whether the service is running
+func (s *Services) Status(siteName string) (bool, error) {
+	if err := s.call("Status", siteName); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running[siteName], nil
+}
+
+// SSL stands in for *ssl.Manager. Every domain points here and gets a
+// certificate unless told otherwise.
+type SSL struct {
+	Recorder
+	// DNS, when set, is the DNS check of every domain
+	DNS *ssl.DNSCheck
+}
+
+// CheckDNS returns DNS, or a check that points here
+func (s *SSL) CheckDNS(domain string) ssl.DNSCheck {
+	s.call("CheckDNS", domain)
+	if s.DNS != nil {
+		return *s.DNS
+	}
+	return ssl.DNSCheck{Resolved: []string{"192.0.2.1"}, HostIPs: []string{"192.0.2.1"}, PointsHere: true}
+}
+
+// ObtainCert records the request; no certificate is written
+func (s *SSL) ObtainCert(ctx context.Context, domain, email string) error {
+	return s.call("ObtainCert", domain, email)
+}
+
+// Firewall stands in for *firewall.Manager, loading no rules
+type Firewall struct {
+	Recorder
+}
+
+// Apply records the call
+func (f *Firewall) Apply(siteName string) error {
+	return f.call("Apply", siteName)
+}
+
+// Remove records the call
+func (f *Firewall) Remove(siteName string) error {
+	return f.call("Remove", siteName)
+}
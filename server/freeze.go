@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+)
+
+// FreezeGuard refuses deploys with 423 while the site is in one of its
+// freeze windows. An admin key can deploy anyway with force=true. A deploy
+// scheduled with run_at is checked against the windows at run_at instead.
+// The site and run_at come from the form fields the deploy handlers read.
+func (s *Server) FreezeGuard() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		siteName := formValue(c, s.cfg, "site")
+		site, ok := s.cfg.Site[siteName]
+		if !ok || len(site.Freeze) == 0 {
+			return c.Next()
+		}
+
+		at := time.Now()
+		if runAt, err := time.Parse(time.RFC3339, formValue(c, s.cfg, "run_at")); err == nil {
+			at = runAt
+		}
+		w, until, frozen := site.Frozen(at)
+		if !frozen {
+			return c.Next()
+		}
+		if !forceRequested(c, s.cfg) {
+			return sendError(c, errDeployFrozen, freezeDetail(w, until))
+		}
+		if !isAdminRequest(c) {
+			return sendError(c, errDeployFrozen, freezeDetail(w, until)+"; force needs an admin key")
+		}
+		reqLog(c).Warn("deploy freeze overridden", "site", siteName, "reason", w.Reason, "until", until)
+		return c.Next()
+	}
+}
+
+// freezeDetail describes the freeze window a deploy ran into
+func freezeDetail(w *config.FreezeWindow, until time.Time) string {
+	detail := "frozen until " + until.Format(time.RFC3339)
+	if w.Reason != "" {
+		detail += fmt.Sprintf(" (%s)", w.Reason)
+	}
+	return detail
+}
+
+// forceRequested reports whether the request asks to override a freeze
+func forceRequested(c *fiber.Ctx, cfg *config.Config) bool {
+	force := requestValue(c, cfg, "force")
+	return force == "true" || force == "1"
+}
+
+// requestValue returns a query parameter, or else the multipart form field
+// of that name
+func requestValue(c *fiber.Ctx, cfg *config.Config, name string) string {
+	if v := c.Query(name); v != "" {
+		return v
+	}
+	return formValue(c, cfg, name)
+}
+
+// formValue returns the first multipart form field of that name
+func formValue(c *fiber.Ctx, cfg *config.Config, name string) string {
+	if form, err := requestForm(c, cfg); err == nil {
+		if v := form.Value[name]; len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/artifact"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestFreezeGuard(t *testing.T) {
+	srv := testServer(&config.Config{
+		Site: map[string]config.SiteConfig{
+			// Always frozen: every minute opens an hour-long window
+			"example.com": {APIKey: "sk-site", Freeze: []config.FreezeWindow{{Start: "* * * * *", Duration: time.Hour, Timezone: "UTC", Reason: "launch"}}},
+			"other.com":   {APIKey: "sk-other"},
+		},
+	})
+
+	app := fiber.New()
+	app.Post("/deploy", func(c *fiber.Ctx) error {
+		c.Locals("admin_key", c.Get("X-Shipyard-Key") == "sk-admin")
+		return c.Next()
+	}, srv.FreezeGuard(), func(c *fiber.Ctx) error {
+		return c.SendString("deployed")
+	})
+
+	tests := []struct {
+		name   string
+		key    string
+		query  string
+		fields map[string]string
+		want   int
+	}{
+		{"frozen", "sk-site", "", map[string]string{"site": "example.com"}, fiber.StatusLocked},
+		{"unfrozen site", "sk-other", "", map[string]string{"site": "other.com"}, fiber.StatusOK},
+		{"forced by site key", "sk-site", "", map[string]string{"site": "example.com", "force": "true"}, fiber.StatusLocked},
+		{"forced by admin", "sk-admin", "", map[string]string{"site": "example.com", "force": "true"}, fiber.StatusOK},
+		// The handlers read site from the form, so the query can't pick another
+		{"query names unfrozen site", "sk-admin", "?site=other.com", map[string]string{"site": "example.com"}, fiber.StatusLocked},
+		{"only form site", "sk-admin", "?site=example.com", map[string]string{"site": "other.com"}, fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for k, v := range tt.fields {
+			writer.WriteField(k, v)
+		}
+		writer.Close()
+		req := httptest.NewRequest("POST", "/deploy"+tt.query, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Shipyard-Key", tt.key)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.name, err)
+		}
+		if resp.StatusCode != tt.want {
+			data, _ := io.ReadAll(resp.Body)
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, resp.StatusCode, tt.want, data)
+		}
+	}
+}
+
+func TestScheduledDeploy(t *testing.T) {
+	stateDir := t.TempDir()
+	srv := testServer(&config.Config{
+		Site: map[string]config.SiteConfig{
+			"example.com": {FrontendRoot: t.TempDir(), APIKey: "sk-site"},
+		},
+	})
+	srv.artifacts = artifact.NewStore(t.TempDir(), 5)
+	srv.jobs = newJobQueue()
+	srv.ops = newOpTracker()
+	srv.schedule = newDeploySchedule(scheduledDeploysPath(stateDir))
+	if _, err := srv.artifacts.Put(artifact.Meta{Site: "example.com", Kind: artifact.KindFrontend, Commit: "abc1234"}, strings.NewReader("zip bytes")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	app := fiber.New()
+	app.Post("/deploy/redeploy", srv.Redeploy)
+	app.Delete("/jobs/:id", srv.CancelJob)
+
+	schedule := func(runAt string) (int, map[string]any) {
+		t.Helper()
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("site", "example.com")
+		writer.WriteField("commit", "abc1234")
+		writer.WriteField("run_at", runAt)
+		writer.Close()
+		req := httptest.NewRequest("POST", "/deploy/redeploy", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		var result map[string]any
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	for _, runAt := range []string{"tomorrow", time.Now().Add(-time.Hour).Format(time.RFC3339), time.Now().Add(60 * 24 * time.Hour).Format(time.RFC3339)} {
+		if status, result := schedule(runAt); status != fiber.StatusBadRequest || result["error"] != "invalid_run_at" {
+			t.Errorf("run_at %q = %d %v, want 400 invalid_run_at", runAt, status, result["error"])
+		}
+	}
+
+	status, result := schedule(time.Now().Add(time.Hour).Format(time.RFC3339))
+	if status != fiber.StatusAccepted || result["status"] != "scheduled" {
+		t.Fatalf("schedule = %d %v", status, result)
+	}
+	id := result["job"].(map[string]any)["id"].(string)
+	if st, _ := srv.jobs.get(id); st.State != jobScheduled || st.RunAt == nil {
+		t.Errorf("job = %+v, want scheduled with run_at", st)
+	}
+
+	// Saved, and rescheduled after a restart
+	restarted := testServer(srv.cfg)
+	restarted.jobs = newJobQueue()
+	restarted.schedule = newDeploySchedule(scheduledDeploysPath(stateDir))
+	restarted.resumeSchedule()
+	restarted.schedule.stop()
+	if st, ok := restarted.jobs.get(id); !ok || st.State != jobScheduled {
+		t.Errorf("after restart job = %+v, %v; want scheduled", st, ok)
+	}
+
+	req := httptest.NewRequest("DELETE", "/jobs/"+id+"?site=example.com", nil)
+	resp, err := app.Test(req)
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("cancel = %v %v", resp.StatusCode, err)
+	}
+	if st, _ := srv.jobs.get(id); st.State != jobCancelled {
+		t.Errorf("cancelled job state = %s", st.State)
+	}
+	data, _ := os.ReadFile(filepath.Join(stateDir, "scheduled-deploys.json"))
+	if strings.Contains(string(data), id) {
+		t.Errorf("cancelled deploy still saved: %s", data)
+	}
+	if resp, _ := app.Test(httptest.NewRequest("DELETE", "/jobs/"+id+"?site=example.com", nil)); resp.StatusCode != fiber.StatusConflict {
+		t.Errorf("second cancel = %d, want 409", resp.StatusCode)
+	}
+
+	// A deploy that comes due inside a freeze window fails unless forced
+	srv.cfg.Site["example.com"] = config.SiteConfig{
+		FrontendRoot: srv.cfg.Site["example.com"].FrontendRoot,
+		Freeze:       []config.FreezeWindow{{Start: "* * * * *", Duration: time.Hour}},
+	}
+	d := scheduledDeploy{ID: "frozen", Operation: "deploy_redeploy", Site: "example.com", Kind: artifact.KindFrontend, Commit: "abc1234", RunAt: time.Now()}
+	srv.schedule.add(d)
+	j := srv.scheduledJob(d)
+	srv.jobs.schedule(j)
+	srv.runScheduled(j)
+	if st, _ := srv.jobs.get("frozen"); st.State != jobFailed || st.Result["error"] != "deploy_frozen" {
+		t.Errorf("frozen job = %s %v, want failed deploy_frozen", st.State, st.Result["error"])
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SiteCrashes returns the crash reports of a site's backend, newest first
+func (s *Server) SiteCrashes(c *fiber.Ctx) error {
+	siteName := c.Query("site")
+	if siteName == "" {
+		return sendError(c, errMissingSite, "")
+	}
+	if _, ok := s.cfg.Site[siteName]; !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+
+	crashes, err := s.crashes.List(siteName)
+	if err != nil {
+		return sendError(c, errHistoryReadFailed, err.Error())
+	}
+	if q := c.Query("limit"); q != "" {
+		if n, err := strconv.Atoi(q); err == nil && n >= 0 && n < len(crashes) {
+			crashes = crashes[:n]
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "ok",
+		"site":    siteName,
+		"crashes": crashes,
+	})
+}
@@ -1,12 +1,11 @@
 package server
 
 import (
-	"bufio"
 	"os"
-	"path/filepath"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/jail"
 )
 
 // SiteLogs returns the last N lines of a site's application log
@@ -15,17 +14,11 @@ func (s *Server) SiteLogs(c *fiber.Ctx) error {
 
 	siteName := c.Query("site")
 	if siteName == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "missing site parameter",
-		})
+		return sendError(c, errMissingSite, "")
 	}
 
 	if _, ok := s.cfg.Site[siteName]; !ok {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"status": "error",
-			"error":  "site not found",
-		})
+		return sendError(c, errSiteNotFound, "")
 	}
 
 	maxLines := 200
@@ -38,7 +31,7 @@ func (s *Server) SiteLogs(c *fiber.Ctx) error {
 		}
 	}
 
-	potPath, err := s.jailMgr.GetPotPath(siteName)
+	logFile, err := s.driver.LogPath(siteName)
 	if err != nil {
 		log.Warn("get pot path for logs", "site", siteName, "error", err)
 		return c.JSON(fiber.Map{
@@ -48,8 +41,7 @@ func (s *Server) SiteLogs(c *fiber.Ctx) error {
 		})
 	}
 
-	logFile := filepath.Join(potPath, "m", "var", "log", "app.log")
-	lines, err := tailFile(logFile, maxLines)
+	lines, err := jail.TailFile(logFile, maxLines)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return c.JSON(fiber.Map{
@@ -59,10 +51,7 @@ func (s *Server) SiteLogs(c *fiber.Ctx) error {
 			})
 		}
 		log.Warn("read site log", "site", siteName, "error", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error",
-			"error":  "failed to read log file",
-		})
+		return sendError(c, errLogReadFailed, "")
 	}
 
 	return c.JSON(fiber.Map{
@@ -71,28 +60,3 @@ func (s *Server) SiteLogs(c *fiber.Ctx) error {
 		"lines":  lines,
 	})
 }
-
-// tailFile reads the last n lines from a file
-func tailFile(path string, n int) ([]string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	var all []string
-	scanner := bufio.NewScanner(f)
-	// Increase buffer size for long log lines
-	scanner.Buffer(make([]byte, 0, 64*1024), 256*1024)
-	for scanner.Scan() {
-		all = append(all, scanner.Text())
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	if len(all) > n {
-		all = all[len(all)-n:]
-	}
-	return all, nil
-}
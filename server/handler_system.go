@@ -0,0 +1,11 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/system"
+)
+
+// System returns host capacity: disk, load, memory, nginx and jail processes
+func (s *Server) System(c *fiber.Ctx) error {
+	return c.JSON(system.Collect(s.cfg))
+}
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"io"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/update"
 )
 
 func testServer(cfg *config.Config) *Server {
@@ -15,6 +17,8 @@ func testServer(cfg *config.Config) *Server {
 		cfg:     cfg,
 		version: "1.0.0-test",
 		commit:  "abc1234",
+		nonces:  newNonceCache(""),
+		ops:     newOpTracker(),
 	}
 }
 
@@ -120,6 +124,35 @@ func TestStatus_ExistingSiteWithBackend(t *testing.T) {
 	}
 }
 
+func TestStatus_DependencyWarning(t *testing.T) {
+	srv := testServer(&config.Config{
+		Site: map[string]config.SiteConfig{
+			"api.example.com":  {Backend: &config.BackendConfig{JailName: "api", DependsOn: []string{"auth.example.com"}}},
+			"auth.example.com": {Backend: &config.BackendConfig{JailName: "auth"}},
+		},
+	})
+
+	app := fiber.New()
+	app.Get("/status/:site", srv.Status)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/status/api.example.com", nil))
+	if err != nil {
+		t.Fatalf("Test request failed: %v", err)
+	}
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	backend, _ := result["backend"].(map[string]interface{})
+	deps, _ := backend["depends_on"].(map[string]interface{})
+	if deps["auth.example.com"] != "unknown" {
+		t.Errorf("backend.depends_on = %v, want auth.example.com unknown", backend["depends_on"])
+	}
+	warnings, _ := result["warnings"].([]interface{})
+	if len(warnings) != 1 || warnings[0] != "depends on auth.example.com, which is unknown" {
+		t.Errorf("warnings = %v, want one for auth.example.com", result["warnings"])
+	}
+}
+
 func TestStatus_SiteNotFound(t *testing.T) {
 	srv := testServer(&config.Config{
 		Site: map[string]config.SiteConfig{},
@@ -157,6 +190,9 @@ func TestValidDomain(t *testing.T) {
 		{"example.com.", false}, // trailing dot
 		{"exam ple.com", false}, // space
 		{"example..com", true},  // double dot (valid per regex)
+		{"*.docs.example.com", true}, // wildcard site
+		{"*example.com", false},      // wildcard without a dot
+		{"docs.*.example.com", false}, // wildcard not leftmost
 		{"", false},
 	}
 
@@ -169,3 +205,82 @@ func TestValidDomain(t *testing.T) {
 		})
 	}
 }
+
+func TestSelfUpdates_ListsNewestFirst(t *testing.T) {
+	srv := testServer(&config.Config{})
+	srv.updateHistory = update.NewHistory(filepath.Join(t.TempDir(), "self-updates.jsonl"))
+	srv.updateHistory.Append(update.Record{Event: update.EventUpdate, OldVersion: "1.0.0", NewVersion: "1.1.0"})
+	srv.updateHistory.Append(update.Record{Event: update.EventUpdate, OldVersion: "1.1.0", NewVersion: "1.2.0"})
+
+	app := fiber.New()
+	app.Get("/self/updates", srv.SelfUpdates)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/self/updates", nil))
+	if err != nil {
+		t.Fatalf("Test request failed: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("Status = %d, want 200", resp.StatusCode)
+	}
+
+	var result struct {
+		Updates []update.Record `json:"updates"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	if len(result.Updates) != 2 {
+		t.Fatalf("got %d updates, want 2", len(result.Updates))
+	}
+	if result.Updates[0].NewVersion != "1.2.0" {
+		t.Errorf("first update = %s, want newest (1.2.0)", result.Updates[0].NewVersion)
+	}
+}
+
+func TestErrors_CatalogueCodesUnique(t *testing.T) {
+	srv := testServer(&config.Config{})
+
+	app := fiber.New()
+	app.Get("/errors", srv.Errors)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/errors", nil))
+	if err != nil {
+		t.Fatalf("Test request failed: %v", err)
+	}
+
+	var result struct {
+		Errors []APIError `json:"errors"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	if len(result.Errors) == 0 {
+		t.Fatal("catalogue is empty")
+	}
+	seen := make(map[string]bool)
+	for _, e := range result.Errors {
+		if seen[e.Code] {
+			t.Errorf("duplicate error code %q", e.Code)
+		}
+		seen[e.Code] = true
+		if e.HTTPStatus < 400 || e.Message == "" {
+			t.Errorf("%s: incomplete entry %+v", e.Code, e)
+		}
+	}
+}
+
+func TestStatus_SiteNotFoundIsStructured(t *testing.T) {
+	srv := testServer(&config.Config{Site: map[string]config.SiteConfig{}})
+
+	app := fiber.New()
+	app.Get("/status/:site", srv.Status)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/status/missing.example.com", nil))
+	if err != nil {
+		t.Fatalf("Test request failed: %v", err)
+	}
+
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if result["error"] != "site_not_found" || result["message"] == nil || result["remediation"] == nil {
+		t.Errorf("unstructured error response: %v", result)
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// SiteUsage returns a site's disk usage per frontend commit, jail and log
+func (s *Server) SiteUsage(c *fiber.Ctx) error {
+	siteName := c.Query("site")
+	if siteName == "" {
+		return sendError(c, errMissingSite, "")
+	}
+
+	if _, ok := s.cfg.Site[siteName]; !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+
+	usage, err := deploy.SiteUsage(s.cfg, siteName)
+	if err != nil {
+		reqLog(c).Warn("measure site usage", "site", siteName, "error", err)
+		return sendError(c, errUsageFailed, err.Error())
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "ok",
+		"usage":  usage,
+	})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// SiteVerify checks a deployed frontend commit against the manifest recorded
+// when it was deployed. The commit defaults to latest; wildcard sites name
+// the subdomain. The response also carries the periodic verifier's latest
+// results for the site.
+func (s *Server) SiteVerify(c *fiber.Ctx) error {
+	siteName := c.Query("site")
+	if siteName == "" {
+		return sendError(c, errMissingSite, "")
+	}
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	if !site.HasFrontend() {
+		return sendError(c, errBackendOnlySite, "")
+	}
+
+	frontendRoot := site.FrontendRoot
+	subdomain := c.Query("subdomain")
+	if config.IsWildcardDomain(siteName) {
+		if !config.ValidSubdomainLabel(subdomain) {
+			return sendError(c, errInvalidSubdomain, "")
+		}
+		frontendRoot = site.SubdomainRoot(subdomain)
+	} else {
+		subdomain = ""
+	}
+
+	commitHash := c.Query("commit", "latest")
+	if commitHash == "latest" {
+		latest, _, err := deploy.LatestCommit(frontendRoot)
+		if err != nil {
+			return sendError(c, errCommitNotDeployed, "nothing is live")
+		}
+		commitHash = latest
+	} else if !isValidCommitHash(commitHash) {
+		return sendError(c, errInvalidCommitHash, "must be 7-40 char hex string")
+	}
+	commitDir := filepath.Join(frontendRoot, commitHash)
+	if info, err := os.Stat(commitDir); err != nil || !info.IsDir() {
+		return sendError(c, errCommitNotDeployed, "")
+	}
+
+	drift, err := deploy.VerifyCommit(s.cfg.StateDir(), siteName, subdomain, commitDir, commitHash)
+	if errors.Is(err, deploy.ErrNoManifest) {
+		return sendError(c, errNoManifest, "")
+	}
+	if err != nil {
+		reqLog(c).Warn("verify commit", "site", siteName, "commit", commitHash, "error", err)
+		return sendError(c, errVerifyFailed, err.Error())
+	}
+
+	periodic := s.verifier.Get(siteName)
+	if periodic == nil {
+		periodic = []deploy.Drift{}
+	}
+	return c.JSON(fiber.Map{
+		"status":   "ok",
+		"clean":    drift.Clean(),
+		"drift":    drift,
+		"periodic": periodic,
+	})
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// ListenFDEnv carries the inherited listener fd to the binary a self-update starts
+const ListenFDEnv = "SHIPYARD_LISTEN_FD"
+
+// HandoverFDEnv carries a pipe from the previous process, which closes it
+// once it has finished its in-flight requests
+const HandoverFDEnv = "SHIPYARD_HANDOVER_FD"
+
+// SupervisorFDEnv carries a socket to the process waiting on this one since
+// a self-update, which starts the binary of the next self-update
+const SupervisorFDEnv = "SHIPYARD_SUPERVISOR_FD"
+
+// handoverListener wraps the API listener so that fiber's Shutdown stops
+// accepting without closing the socket, which the new binary started by a
+// self-update is accepting on too.
+type handoverListener struct {
+	*net.TCPListener
+	closed atomic.Bool
+}
+
+// Accept returns net.ErrClosed once Close has been called
+func (l *handoverListener) Accept() (net.Conn, error) {
+	conn, err := l.TCPListener.Accept()
+	if l.closed.Load() {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, net.ErrClosed
+	}
+	return conn, err
+}
+
+// Close unblocks Accept but leaves the socket open for handover
+func (l *handoverListener) Close() error {
+	l.closed.Store(true)
+	return l.TCPListener.SetDeadline(time.Now())
+}
+
+// listenTCP returns the API listener, reusing one inherited from the
+// previous process after a self-update if present
+func listenTCP(addr string) (*net.TCPListener, error) {
+	fdStr := os.Getenv(ListenFDEnv)
+	if fdStr == "" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return ln.(*net.TCPListener), nil
+	}
+
+	// Don't leak the fd number into backend processes we spawn
+	os.Unsetenv(ListenFDEnv)
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", ListenFDEnv, fdStr, err)
+	}
+	f := os.NewFile(uintptr(fd), "shipyard-listener")
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherit listener fd %d: %w", fd, err)
+	}
+	tcp, ok := ln.(*net.TCPListener)
+	if !ok {
+		ln.Close()
+		return nil, fmt.Errorf("inherited fd %d is not a TCP listener", fd)
+	}
+	slog.Info("inherited listener from previous process", "addr", tcp.Addr().String())
+	return tcp, nil
+}
+
+// Listen starts the HTTP server
+func (s *Server) Listen(addr string) error {
+	tcp, err := listenTCP(addr)
+	if err != nil {
+		return err
+	}
+	s.listener = &handoverListener{TCPListener: tcp}
+
+	// Use TLS if cert and key are configured
+	if s.cfg.Server.TLSCert != "" && s.cfg.Server.TLSKey != "" {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return err
+		}
+		return s.app.Listener(tls.NewListener(s.listener, tlsConfig))
+	}
+	return s.app.Listener(s.listener)
+}
+
+// Successor is the new binary a self-update handed the listener to. The
+// process that started it stays its parent, and starts the binaries of later
+// self-updates itself when the successor asks, so at most one process is ever
+// left waiting.
+type Successor struct {
+	listener *os.File // dup of the API listener, for later successors
+	current  *child   // nil once handed to this process's own parent
+}
+
+// child is a binary started with the API listener
+type child struct {
+	cmd     *exec.Cmd
+	done    *os.File // closing it tells the child its predecessor has drained
+	control net.Conn // the child's requests to start the next binary
+	next    *child   // started at the child's request; released when it exits
+}
+
+// handoverRequest is a child's request to start binary in its place
+type handoverRequest struct {
+	from   *child
+	binary string
+	reply  chan error
+}
+
+// Handover starts binaryPath with the API listener and stops accepting, so
+// the new binary serves new connections while this process finishes its
+// in-flight requests with Shutdown. Call Release once drained, then Wait.
+// A process that was itself started by a handover asks its parent to start
+// the binary instead, and Wait then returns at once.
+func (s *Server) Handover(binaryPath string) (*Successor, error) {
+	if s.listener == nil {
+		return nil, fmt.Errorf("no listener to hand over")
+	}
+
+	// The successor binds the challenge port itself
+	s.stopACME()
+
+	if s.supervisor != nil {
+		if err := requestHandover(s.supervisor, binaryPath); err != nil {
+			return nil, err
+		}
+		slog.Info("parent process started new binary", "binary", binaryPath)
+		s.listener.Close()
+		return &Successor{}, nil
+	}
+
+	// File returns a dup of the socket for the child
+	f, err := s.listener.TCPListener.File()
+	if err != nil {
+		return nil, fmt.Errorf("dup listener: %w", err)
+	}
+	c, err := startChild(binaryPath, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	s.listener.Close()
+	return &Successor{listener: f, current: c}, nil
+}
+
+// startChild starts binaryPath with this process's arguments, the listener,
+// the read end of its handover pipe and its control socket
+func startChild(binaryPath string, listener *os.File) (*child, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("handover pipe: %w", err)
+	}
+	defer r.Close()
+	control, remote, err := controlSocket()
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	defer remote.Close()
+
+	// ExtraFiles are numbered from 3 in the child
+	cmd := exec.Command(binaryPath)
+	cmd.Args = os.Args
+	cmd.Env = append(os.Environ(), ListenFDEnv+"=3", HandoverFDEnv+"=4", SupervisorFDEnv+"=5")
+	cmd.ExtraFiles = []*os.File{listener, r, remote}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		control.Close()
+		return nil, fmt.Errorf("start %s: %w", binaryPath, err)
+	}
+	slog.Info("handed over listener to new binary", "binary", binaryPath, "pid", cmd.Process.Pid)
+	return &child{cmd: cmd, done: w, control: control}, nil
+}
+
+// controlSocket returns a connected pair of unix sockets: this process's end
+// and the file to pass to a child
+func controlSocket() (net.Conn, *os.File, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, fmt.Errorf("handover socket: %w", err)
+	}
+
+	local := os.NewFile(uintptr(fds[0]), "shipyard-control")
+	defer local.Close()
+	conn, err := net.FileConn(local)
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, fmt.Errorf("handover socket: %w", err)
+	}
+	return conn, os.NewFile(uintptr(fds[1]), "shipyard-supervisor"), nil
+}
+
+// requestHandover asks the parent process on conn to start binaryPath in
+// this process's place, and waits for it to have done so
+func requestHandover(conn net.Conn, binaryPath string) error {
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	if _, err := fmt.Fprintf(conn, "%s\n", binaryPath); err != nil {
+		return fmt.Errorf("ask parent process to start %s: %w", binaryPath, err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("ask parent process to start %s: %w", binaryPath, err)
+	}
+	if reply = strings.TrimSuffix(reply, "\n"); reply != "ok" {
+		return fmt.Errorf("parent process: %s", reply)
+	}
+	return nil
+}
+
+// serveRequests passes c's handover requests to Wait until c closes its socket
+func serveRequests(c *child, requests chan<- handoverRequest) {
+	lines := bufio.NewScanner(c.control)
+	for lines.Scan() {
+		reply := make(chan error, 1)
+		requests <- handoverRequest{from: c, binary: lines.Text(), reply: reply}
+		if err := <-reply; err != nil {
+			fmt.Fprintf(c.control, "%v\n", err)
+		} else {
+			fmt.Fprintf(c.control, "ok\n")
+		}
+	}
+}
+
+// Release tells the successor that this process has drained, so it may
+// recover interrupted deploys and start taking operations
+func (p *Successor) Release() {
+	if p.current != nil {
+		p.current.done.Close()
+	}
+}
+
+// Wait forwards signals to the successor until it exits. The old process
+// stays its parent so the pid rc.d and daemon(8) supervise keeps running.
+// When the successor asks for a handover of its own, Wait starts the new
+// binary, releases it once the successor has exited, and waits for it instead.
+func (p *Successor) Wait(signals <-chan os.Signal) error {
+	if p.current == nil {
+		return nil
+	}
+	defer p.listener.Close()
+
+	type exit struct {
+		c   *child
+		err error
+	}
+	exits := make(chan exit)
+	requests := make(chan handoverRequest)
+	live := map[*child]bool{}
+	watch := func(c *child) {
+		live[c] = true
+		go func() { exits <- exit{c, c.cmd.Wait()} }()
+		go serveRequests(c, requests)
+	}
+	watch(p.current)
+
+	for {
+		select {
+		case sig := <-signals:
+			for c := range live {
+				c.cmd.Process.Signal(sig)
+			}
+		case req := <-requests:
+			if req.from != p.current || req.from.next != nil {
+				req.reply <- fmt.Errorf("a newer binary has already taken over")
+				continue
+			}
+			next, err := startChild(req.binary, p.listener)
+			req.reply <- err
+			if err == nil {
+				req.from.next = next
+				p.current = next
+				watch(next)
+			}
+		case e := <-exits:
+			delete(live, e.c)
+			e.c.control.Close()
+			if e.c == p.current {
+				return e.err
+			}
+			// A predecessor has drained once it exits
+			slog.Info("previous binary exited, new binary has taken over", "pid", e.c.cmd.Process.Pid)
+			e.c.next.done.Close()
+		}
+	}
+}
+
+// HandedOver reports whether a self-update started this process with the
+// previous one's listener. The server then recovers interrupted deploys
+// itself, once the previous process has drained.
+func HandedOver() bool {
+	return os.Getenv(HandoverFDEnv) != ""
+}
+
+// followPredecessor waits for the previous process to drain, repairs what it
+// left unfinished, and starts taking operations
+func (s *Server) followPredecessor(done <-chan struct{}, mgrs deploy.Managers) {
+	<-done
+	slog.Info("previous process drained, taking operations")
+	if _, err := deploy.Recover(s.cfg, mgrs); err != nil {
+		slog.Error("deploy recovery failed", "error", err)
+	}
+	s.resumeSchedule()
+	s.ops.release()
+}
+
+// awaitPredecessor returns a channel closed once the process that handed
+// over its listener has drained, or nil if there was no handover
+func awaitPredecessor() <-chan struct{} {
+	fdStr := os.Getenv(HandoverFDEnv)
+	if fdStr == "" {
+		return nil
+	}
+	os.Unsetenv(HandoverFDEnv)
+
+	done := make(chan struct{})
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		slog.Warn("ignoring invalid "+HandoverFDEnv, "value", fdStr)
+		close(done)
+		return done
+	}
+	f := os.NewFile(uintptr(fd), "shipyard-handover")
+	go func() {
+		defer close(done)
+		defer f.Close()
+		// The predecessor closes its end after draining, or dies
+		io.Copy(io.Discard, f)
+	}()
+	return done
+}
+
+// supervisorConn returns the socket to the process that started this one
+// with a handover, or nil if there was no handover
+func supervisorConn() net.Conn {
+	fdStr := os.Getenv(SupervisorFDEnv)
+	if fdStr == "" {
+		return nil
+	}
+	os.Unsetenv(SupervisorFDEnv)
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		slog.Warn("ignoring invalid "+SupervisorFDEnv, "value", fdStr)
+		return nil
+	}
+	f := os.NewFile(uintptr(fd), "shipyard-supervisor")
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	if err != nil {
+		slog.Warn("ignoring "+SupervisorFDEnv, "error", err)
+		return nil
+	}
+	return conn
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandoverListener_CloseKeepsSocketOpen(t *testing.T) {
+	tcp, err := listenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer tcp.Close()
+	ln := &handoverListener{TCPListener: tcp}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		errc <- err
+	}()
+
+	ln.Close()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Accept error = %v, want net.ErrClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+
+	// The socket is still bound, so new connections queue in the backlog
+	conn, err := net.DialTimeout("tcp", tcp.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("dial after Close: %v", err)
+	}
+	conn.Close()
+}
+
+func TestListenTCP_InheritsFD(t *testing.T) {
+	orig, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer orig.Close()
+
+	f, err := orig.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("dup: %v", err)
+	}
+	defer f.Close()
+
+	// listenTCP takes ownership of the fd it is given, so pass a separate dup
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatalf("dup: %v", err)
+	}
+	t.Setenv(ListenFDEnv, strconv.Itoa(fd))
+	tcp, err := listenTCP("127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("listenTCP: %v", err)
+	}
+	defer tcp.Close()
+
+	if tcp.Addr().String() != orig.Addr().String() {
+		t.Errorf("inherited addr = %s, want %s", tcp.Addr(), orig.Addr())
+	}
+}
+
+// successorEnv makes the test binary act as the new binary in the handover
+// tests; chainSuccessor as the binaries in TestHandover_TwoUpdatesLeaveOneParent
+const (
+	successorEnv   = "SHIPYARD_TEST_SUCCESSOR"
+	chainSuccessor = "chain"
+)
+
+// chainMarkerEnv names a file the first binary of the chain creates, so the
+// second knows it is the last
+const chainMarkerEnv = "SHIPYARD_TEST_CHAIN_MARKER"
+
+func TestHandover_SuccessorServesWhileDraining(t *testing.T) {
+	if os.Getenv(successorEnv) == "1" {
+		runTestSuccessor()
+		return
+	}
+
+	tcp, err := listenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer tcp.Close()
+	srv := &Server{listener: &handoverListener{TCPListener: tcp}}
+
+	// Start this test again as the successor
+	t.Setenv(successorEnv, "1")
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestHandover_SuccessorServesWhileDraining$"}
+	successor, err := srv.Handover(args[0])
+	os.Args = args
+	if err != nil {
+		t.Fatalf("Handover: %v", err)
+	}
+
+	// New connections reach the successor before this process has drained
+	conn, err := net.DialTimeout("tcp", tcp.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "successor\n" {
+		t.Fatalf("read = %q, %v; want the successor's greeting", line, err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- successor.Wait(nil) }()
+	select {
+	case err := <-exited:
+		t.Fatalf("successor exited before Release: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	successor.Release()
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("successor: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("successor did not see the release")
+	}
+}
+
+// runTestSuccessor greets one connection on the inherited listener, then
+// waits for its predecessor and exits
+func runTestSuccessor() {
+	done := awaitPredecessor()
+	tcp, err := listenTCP("")
+	if err != nil || done == nil {
+		os.Exit(2)
+	}
+	conn, err := tcp.Accept()
+	if err != nil {
+		os.Exit(3)
+	}
+	conn.Write([]byte("successor\n"))
+	conn.Close()
+	<-done
+	os.Exit(0)
+}
+
+func TestHandover_TwoUpdatesLeaveOneParent(t *testing.T) {
+	if os.Getenv(successorEnv) == chainSuccessor {
+		runChainSuccessor()
+		return
+	}
+
+	tcp, err := listenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer tcp.Close()
+	srv := &Server{listener: &handoverListener{TCPListener: tcp}}
+
+	// The first successor updates again as soon as it is released
+	t.Setenv(successorEnv, chainSuccessor)
+	t.Setenv(chainMarkerEnv, filepath.Join(t.TempDir(), "updated"))
+	// Wait starts the second one with these arguments too
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestHandover_TwoUpdatesLeaveOneParent$"}
+	defer func() { os.Args = args }()
+	successor, err := srv.Handover(args[0])
+	if err != nil {
+		t.Fatalf("Handover: %v", err)
+	}
+	successor.Release()
+	exited := make(chan error, 1)
+	go func() { exited <- successor.Wait(nil) }()
+
+	// The second successor reports its parent
+	conn, err := net.DialTimeout("tcp", tcp.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if want := strconv.Itoa(os.Getpid()) + "\n"; line != want {
+		t.Errorf("second successor's parent = %q, want this process (%q)", line, want)
+	}
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("successor: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Wait did not return after the second successor exited")
+	}
+}
+
+// runChainSuccessor hands over again as the first binary of
+// TestHandover_TwoUpdatesLeaveOneParent, or as the second reports its parent
+// pid to one connection once the first has exited
+func runChainSuccessor() {
+	srv := &Server{supervisor: supervisorConn()}
+	done := awaitPredecessor()
+	tcp, err := listenTCP("")
+	if err != nil || done == nil || srv.supervisor == nil {
+		os.Exit(2)
+	}
+	srv.listener = &handoverListener{TCPListener: tcp}
+	<-done
+
+	marker := os.Getenv(chainMarkerEnv)
+	if _, err := os.Stat(marker); err == nil {
+		conn, err := tcp.Accept()
+		if err != nil {
+			os.Exit(3)
+		}
+		conn.Write([]byte(strconv.Itoa(os.Getppid()) + "\n"))
+		conn.Close()
+		os.Exit(0)
+	}
+
+	os.WriteFile(marker, nil, 0644)
+	successor, err := srv.Handover(os.Args[0])
+	if err != nil {
+		os.Exit(4)
+	}
+	successor.Release()
+	if err := successor.Wait(nil); err != nil {
+		os.Exit(5)
+	}
+	os.Exit(0)
+}
@@ -1,17 +1,26 @@
 package server
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/health"
+)
 
 // Health returns the health status of shipyard and its services
 func (s *Server) Health(c *fiber.Ctx) error {
 	// Basic health check - in production with a monitor, this would
 	// include service status from the health monitor
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+	response := fiber.Map{
 		"status":   "healthy",
 		"version":  s.version,
 		"commit":   s.commit,
 		"services": make(map[string]interface{}),
-	})
+	}
+	if report := s.startupStatus(); report != nil {
+		response["startup"] = report
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
 }
 
 // Status returns the status of a specific site
@@ -20,10 +29,7 @@ func (s *Server) Status(c *fiber.Ctx) error {
 
 	site, ok := s.cfg.Site[siteName]
 	if !ok {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"status": "error",
-			"error":  "site_not_found",
-		})
+		return sendError(c, errSiteNotFound, "")
 	}
 
 	response := fiber.Map{
@@ -32,10 +38,39 @@ func (s *Server) Status(c *fiber.Ctx) error {
 
 	// Basic backend status
 	if site.Backend != nil {
-		response["backend"] = fiber.Map{
+		backend := fiber.Map{
 			"jail":   site.Backend.JailName,
 			"status": "unknown", // Would be updated by health monitor in production
 		}
+		if s.metrics != nil {
+			if pm, ok := s.metrics.Get(siteName); ok {
+				backend["metrics"] = pm
+			}
+		}
+		if st, ok := s.reconciler.Get(siteName); ok {
+			backend["status"] = st.Status()
+			backend["reconcile"] = st
+		}
+		// A backend can't serve while one it depends on isn't ready
+		if len(site.Backend.DependsOn) > 0 {
+			deps := fiber.Map{}
+			var warnings []string
+			for _, dep := range site.Backend.DependsOn {
+				status := "unknown"
+				if st, ok := s.reconciler.Get(dep); ok {
+					status = st.Status()
+				}
+				deps[dep] = status
+				if status != health.BackendReady {
+					warnings = append(warnings, fmt.Sprintf("depends on %s, which is %s", dep, status))
+				}
+			}
+			backend["depends_on"] = deps
+			if len(warnings) > 0 {
+				response["warnings"] = warnings
+			}
+		}
+		response["backend"] = backend
 	}
 
 	return c.Status(fiber.StatusOK).JSON(response)
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/health"
+)
+
+// Incidents handles GET /incidents: the periods sites failed
+// health.failure_threshold probes in a row, newest first (optionally
+// ?site= and ?open=true for those still going on)
+func (s *Server) Incidents(c *fiber.Ctx) error {
+	site := c.Query("site")
+	incidents := []health.Incident{}
+	for _, inc := range s.incidents.List(site, c.QueryBool("open")) {
+		if requestAllowsSite(c, inc.Site) {
+			incidents = append(incidents, inc)
+		}
+	}
+	return c.JSON(fiber.Map{
+		"incidents": incidents,
+	})
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/health"
+)
+
+func TestIncidents_List(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{Self: config.SelfConfig{StateDir: dir}}
+	srv := testServer(cfg)
+	srv.incidents = health.NewIncidentLog(filepath.Join(dir, "incidents.json"), 2, nil, nil)
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		srv.incidents.Observe("a.example.com", false, start.Add(time.Duration(i)*time.Minute))
+		srv.incidents.Observe("b.example.com", false, start.Add(time.Duration(i)*time.Minute))
+	}
+	srv.incidents.Observe("a.example.com", true, start.Add(5*time.Minute))
+
+	app := fiber.New()
+	app.Get("/incidents", srv.Incidents)
+	list := func(query string) []health.Incident {
+		resp, err := app.Test(httptest.NewRequest("GET", "/incidents"+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Incidents []health.Incident `json:"incidents"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return body.Incidents
+	}
+
+	if got := list(""); len(got) != 2 {
+		t.Fatalf("GET /incidents = %+v, want 2", got)
+	}
+	if got := list("?site=a.example.com"); len(got) != 1 || got[0].End == nil {
+		t.Errorf("GET /incidents?site=a = %+v, want one closed incident", got)
+	}
+	if got := list("?open=true"); len(got) != 1 || got[0].Site != "b.example.com" {
+		t.Errorf("GET /incidents?open=true = %+v, want b.example.com", got)
+	}
+}
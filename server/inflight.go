@@ -0,0 +1,168 @@
+package server
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// operation is a deploy or site lifecycle request that must not be cut short
+type operation struct {
+	Kind    string    `json:"kind"`
+	Site    string    `json:"site,omitempty"`
+	Commit  string    `json:"commit,omitempty"`
+	Started time.Time `json:"started"`
+}
+
+// opTracker counts in-flight operations so shutdown can wait for them
+type opTracker struct {
+	mu       sync.Mutex
+	nextID   int
+	ops      map[int]operation
+	draining bool
+	idle     chan struct{} // closed when draining and no ops remain
+	held     chan struct{} // set while holding, closed on release
+}
+
+func newOpTracker() *opTracker {
+	return &opTracker{ops: make(map[int]operation)}
+}
+
+// hold refuses new operations until release, while the process that handed
+// over the listener finishes its own
+func (t *opTracker) hold() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.held = make(chan struct{})
+}
+
+// release ends a hold
+func (t *opTracker) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.held != nil {
+		close(t.held)
+		t.held = nil
+	}
+}
+
+// released returns a channel that is closed once there is no hold
+func (t *opTracker) released() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.held != nil {
+		return t.held
+	}
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// begin registers an operation. Returns false while held or once draining
+// has started.
+func (t *opTracker) begin(op operation) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining || t.held != nil {
+		return 0, false
+	}
+	t.nextID++
+	t.ops[t.nextID] = op
+	return t.nextID, true
+}
+
+// end removes a finished operation
+func (t *opTracker) end(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.ops, id)
+	if t.draining && len(t.ops) == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// drain refuses new operations and waits up to timeout for running ones.
+// Returns the operations still running at the deadline.
+func (t *opTracker) drain(timeout time.Duration) []operation {
+	t.mu.Lock()
+	t.draining = true
+	if len(t.ops) == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	idle := make(chan struct{})
+	t.idle = idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	remaining := make([]operation, 0, len(t.ops))
+	for _, op := range t.ops {
+		remaining = append(remaining, op)
+	}
+	return remaining
+}
+
+// TrackOperation marks the request as an in-flight operation for graceful
+// shutdown, and rejects it with 503 once shutdown has begun
+func (s *Server) TrackOperation(kind string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		op := operation{Kind: kind, Started: time.Now()}
+		// Site-scoped routes are multipart; the form is already parsed by SiteAuth
+		if form, err := requestForm(c, s.cfg); err == nil {
+			if v := form.Value["site"]; len(v) > 0 {
+				op.Site = v[0]
+			}
+			if v := form.Value["commit"]; len(v) > 0 {
+				op.Commit = v[0]
+			}
+		}
+
+		id, ok := s.ops.begin(op)
+		if !ok {
+			return sendError(c, errShuttingDown, "")
+		}
+		defer s.ops.end(id)
+		// Whatever the operation changed shows up in the next read
+		defer s.readCache.invalidate()
+		return c.Next()
+	}
+}
+
+// drainOperations waits for in-flight deploys before the listener is closed,
+// and returns what is left of the shutdown timeout. Operations still running
+// at the deadline are journaled, so the next start reports them.
+func (s *Server) drainOperations() time.Duration {
+	timeout := time.Duration(s.cfg.Server.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	start := time.Now()
+	remaining := s.ops.drain(timeout)
+	for _, op := range remaining {
+		slog.Error("operation still running at shutdown deadline, abandoning",
+			"kind", op.Kind,
+			"site", op.Site,
+			"commit", op.Commit,
+			"running_for", time.Since(op.Started).Round(time.Second).String(),
+		)
+		if err := deploy.RecordAbandoned(s.cfg, op.Kind, op.Site, op.Commit, op.Started); err != nil {
+			slog.Error("failed to journal abandoned operation", "kind", op.Kind, "site", op.Site, "error", err)
+		}
+	}
+	if len(remaining) == 0 {
+		slog.Info("in-flight operations drained", "waited", time.Since(start).Round(time.Millisecond).String())
+	}
+	return max(timeout-time.Since(start), 0)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+func TestOpTracker_DrainWaitsForOperations(t *testing.T) {
+	tr := newOpTracker()
+	id, ok := tr.begin(operation{Kind: "deploy_frontend", Site: "example.com"})
+	if !ok {
+		t.Fatal("begin refused before drain")
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		tr.end(id)
+	}()
+
+	if remaining := tr.drain(2 * time.Second); len(remaining) != 0 {
+		t.Errorf("drain returned %d remaining ops, want 0", len(remaining))
+	}
+	if _, ok := tr.begin(operation{Kind: "deploy_backend"}); ok {
+		t.Error("begin accepted after drain started")
+	}
+}
+
+func TestOpTracker_DrainTimeout(t *testing.T) {
+	tr := newOpTracker()
+	tr.begin(operation{Kind: "deploy_backend", Site: "api.example.com"})
+
+	remaining := tr.drain(20 * time.Millisecond)
+	if len(remaining) != 1 || remaining[0].Site != "api.example.com" {
+		t.Errorf("remaining = %+v, want the stuck deploy", remaining)
+	}
+}
+
+func TestOpTracker_Hold(t *testing.T) {
+	tr := newOpTracker()
+	tr.hold()
+	if _, ok := tr.begin(operation{Kind: "deploy_frontend"}); ok {
+		t.Error("begin accepted while held")
+	}
+	select {
+	case <-tr.released():
+		t.Fatal("released before release")
+	default:
+	}
+
+	tr.release()
+	<-tr.released()
+	if _, ok := tr.begin(operation{Kind: "deploy_frontend"}); !ok {
+		t.Error("begin refused after release")
+	}
+}
+
+func TestDrainOperations_JournalsAbandoned(t *testing.T) {
+	srv := testServer(&config.Config{
+		Self:   config.SelfConfig{StateDir: t.TempDir()},
+		Server: config.ServerConfig{ShutdownTimeout: 1},
+		Site:   map[string]config.SiteConfig{"api.example.com": {}},
+	})
+	srv.ops = newOpTracker()
+	srv.ops.begin(operation{Kind: "site_init", Site: "api.example.com", Started: time.Now()})
+
+	if left := srv.drainOperations(); left > 0 {
+		t.Errorf("left = %s, want none after a timed-out drain", left)
+	}
+
+	// The next start reports the operation and clears it
+	results, err := deploy.Recover(srv.cfg, deploy.Managers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Entry.Operation != "site_init" || results[0].Action != deploy.RecoverySkipped || results[0].Err != nil {
+		t.Fatalf("results = %+v, want the abandoned site_init", results)
+	}
+	if results, _ := deploy.Recover(srv.cfg, deploy.Managers{}); len(results) != 0 {
+		t.Errorf("second recovery = %+v, want nothing left", results)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+)
+
+// JailTemplates lists the jail templates and whether their current
+// definitions have been built
+func (s *Server) JailTemplates(c *fiber.Ctx) error {
+	templates := make([]fiber.Map, 0, len(s.cfg.Jail.Templates))
+	for _, name := range sortedNames(s.cfg.Jail.Templates) {
+		t := s.cfg.Jail.Templates[name]
+		pot, _ := s.jailMgr.TemplatePot(name)
+		built, _ := s.jailMgr.TemplateBuilt(name)
+		templates = append(templates, fiber.Map{
+			"name":       name,
+			"pot":        pot,
+			"built":      built,
+			"packages":   t.Packages,
+			"users":      t.Users,
+			"attributes": t.Attributes,
+			"sites":      templateSites(s.cfg, name),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":    "ok",
+		"templates": templates,
+	})
+}
+
+// BuildJailTemplate builds a template's pot ahead of the first site that
+// needs it. Building an already built definition does nothing.
+func (s *Server) BuildJailTemplate(c *fiber.Ctx) error {
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "")
+	}
+	values := form.Value["name"]
+	if len(values) == 0 {
+		return sendError(c, errInvalidRequest, "name is required")
+	}
+	name := values[0]
+	if _, ok := s.cfg.Jail.Templates[name]; !ok {
+		return sendError(c, errJailTemplateNotFound, name)
+	}
+
+	log := reqLog(c).With("template", name)
+	pot, built, err := s.jailMgr.BuildTemplate(logContext(log), name)
+	if err != nil {
+		log.Error("jail template build failed", "error", err)
+		return sendError(c, errJailTemplateFailed, err.Error())
+	}
+	return c.JSON(fiber.Map{
+		"status": "ok",
+		"name":   name,
+		"pot":    pot,
+		"built":  built,
+	})
+}
+
+// templateSites returns the sites whose backends are cloned from a template
+func templateSites(cfg *config.Config, template string) []string {
+	sites := []string{}
+	for _, name := range sortedNames(cfg.Site) {
+		if b := cfg.Site[name].Backend; b != nil && b.Template == template {
+			sites = append(sites, name)
+		}
+	}
+	return sites
+}
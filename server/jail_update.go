@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/deploy"
+	"github.com/lachierussell/shipyard/notify"
+)
+
+// StartBaseUpdate starts updating the FreeBSD base of every backend jail, or
+// of the sites passed as repeated site fields, one jail at a time. A key
+// limited by key_acl must be allowed every site it names.
+func (s *Server) StartBaseUpdate(c *fiber.Ctx) error {
+	var sites []string
+	if form, err := requestForm(c, s.cfg); err == nil {
+		sites = form.Value["site"]
+	}
+	for _, name := range sites {
+		if _, ok := s.cfg.Site[name]; !ok {
+			return sendError(c, errSiteNotFound, name)
+		}
+		if !requestAllowsSite(c, name) {
+			return sendError(c, errSiteNotAllowed, name)
+		}
+	}
+
+	s.baseUpdateMu.Lock()
+	defer s.baseUpdateMu.Unlock()
+	if s.baseUpdate != nil && !s.baseUpdate.Done() {
+		return sendError(c, errBaseUpdateRunning, "")
+	}
+	u, err := deploy.NewBaseUpdate(s.cfg, sites)
+	if err != nil {
+		return sendError(c, errInvalidRequest, err.Error())
+	}
+	s.baseUpdate = u
+
+	status := u.Status()
+	reqLog(c).Info("jail base update started", "jails", len(status.Jails))
+	go s.runBaseUpdate(u)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status": "started",
+		"update": status,
+	})
+}
+
+// ResumeBaseUpdate continues a paused base update. skip=true moves past the
+// jail that failed instead of retrying it.
+func (s *Server) ResumeBaseUpdate(c *fiber.Ctx) error {
+	skip := false
+	if form, err := requestForm(c, s.cfg); err == nil {
+		if values := form.Value["skip"]; len(values) > 0 {
+			skip = values[0] == "true" || values[0] == "1"
+		}
+	}
+
+	s.baseUpdateMu.Lock()
+	defer s.baseUpdateMu.Unlock()
+	if s.baseUpdate == nil {
+		return sendError(c, errNoBaseUpdate, "")
+	}
+	if err := s.baseUpdate.Resume(skip); err != nil {
+		return sendError(c, errBaseUpdateNotPaused, err.Error())
+	}
+
+	reqLog(c).Info("jail base update resumed", "skip", skip)
+	go s.runBaseUpdate(s.baseUpdate)
+	return c.JSON(fiber.Map{
+		"status": "resumed",
+		"update": s.baseUpdate.Status(),
+	})
+}
+
+// BaseUpdateStatus returns the progress of the latest base update
+func (s *Server) BaseUpdateStatus(c *fiber.Ctx) error {
+	s.baseUpdateMu.Lock()
+	u := s.baseUpdate
+	s.baseUpdateMu.Unlock()
+	if u == nil {
+		return sendError(c, errNoBaseUpdate, "")
+	}
+	return c.JSON(fiber.Map{
+		"status": "ok",
+		"update": u.Status(),
+	})
+}
+
+// runBaseUpdate updates jails until the rollout completes or pauses. Each
+// jail counts as an in-flight operation, so shutdown waits for the current
+// one and leaves the rest.
+func (s *Server) runBaseUpdate(u *deploy.BaseUpdate) {
+	for !u.Done() {
+		site := u.Current()
+		id, ok := s.ops.begin(operation{Kind: "base_update", Site: site, Started: time.Now()})
+		if !ok {
+			slog.Warn("jail base update stopped by shutdown", "next", site)
+			return
+		}
+		err := u.Step()
+		s.ops.end(id)
+		if err != nil {
+			s.notifier.Send(notify.Event{
+				Kind:    notify.KindBaseUpdatePaused,
+				Site:    site,
+				Time:    time.Now(),
+				Message: fmt.Sprintf("jail base update paused at %s: %v", site, err),
+				Details: u.Status(),
+			})
+			return // ResumeBaseUpdate starts a new runner
+		}
+	}
+	slog.Info("jail base update completed")
+}
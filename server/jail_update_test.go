@@ -0,0 +1,47 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestStartBaseUpdate_ChecksEverySite(t *testing.T) {
+	srv := testServer(&config.Config{
+		AdminKeys: []string{"sk-team-a"},
+		KeyACL:    map[string][]string{"sk-team-a": {"*.team-a.example.com"}},
+		Site: map[string]config.SiteConfig{
+			"docs.team-a.example.com": {Backend: &config.BackendConfig{BinaryName: "docs"}},
+			"prod.team-b.example.com": {Backend: &config.BackendConfig{BinaryName: "prod"}},
+		},
+	})
+	app := fiber.New()
+	app.Post("/jails/update", GlobalAdminAuth(srv.cfg), srv.StartBaseUpdate)
+
+	// A limited key may not start a base update, whatever sites it names
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("site", "docs.team-a.example.com")
+	writer.WriteField("site", "prod.team-b.example.com")
+	writer.Close()
+	req := httptest.NewRequest("POST", "/jails/update", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Shipyard-Key", "sk-team-a")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Test request failed: %v", err)
+	}
+	var result map[string]any
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != fiber.StatusForbidden || result["error"] != "site_not_allowed" {
+		t.Errorf("status = %d %v, want 403 site_not_allowed", resp.StatusCode, result)
+	}
+	if srv.baseUpdate != nil {
+		t.Error("base update was started")
+	}
+}
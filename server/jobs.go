@@ -0,0 +1,378 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Job states
+const (
+	jobPendingApproval = "pending_approval"
+	jobScheduled       = "scheduled" // waiting for its run_at
+	jobQueued          = "queued"
+	jobRunning         = "running"
+	jobSucceeded       = "succeeded"
+	jobFailed          = "failed"
+	jobCancelled       = "cancelled"
+)
+
+const (
+	// jobQueueSize bounds the deploys waiting for a worker
+	jobQueueSize = 100
+	// keepFinishedJobs is how many finished jobs stay visible to GET /jobs/:id
+	keepFinishedJobs = 200
+	// maxJobEvents bounds the progress messages kept per job
+	maxJobEvents = 100
+)
+
+// deployResult is the response a deploy finishes with. Synchronous requests
+// send it; async ones keep it on their job.
+type deployResult struct {
+	Status int
+	Body   fiber.Map
+}
+
+// beginDeploy gives a deploy its ID, returning a logger that tags every line
+// with it as deploy_id and a context carrying that logger for the deployers.
+// GET /logs/search?deploy_id= finds the lines again.
+func beginDeploy(log *slog.Logger) (context.Context, *slog.Logger, string) {
+	id := uuid.NewString()
+	log = log.With("deploy_id", id)
+	return logContext(log), log, id
+}
+
+// errorResult is the deployResult for an API error
+func errorResult(e *APIError, detail string) deployResult {
+	return deployResult{Status: e.HTTPStatus, Body: errorBody(e, detail, nil)}
+}
+
+// jobEvent is a progress message logged while a job ran
+type jobEvent struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// jobStatus is what the API reports about a job
+type jobStatus struct {
+	ID      string     `json:"id"`
+	Kind    string     `json:"kind"`
+	Site    string     `json:"site"`
+	Commit  string     `json:"commit,omitempty"`
+	State   string     `json:"state"`
+	Created time.Time  `json:"created"`
+	RunAt   *time.Time `json:"run_at,omitempty"`
+	// RequestID is the X-Request-Id of the request that queued the job
+	RequestID string `json:"request_id,omitempty"`
+	// Approval of deploys to sites with require_approval
+	RequestedBy     string     `json:"requested_by,omitempty"`
+	ApprovedBy      string     `json:"approved_by,omitempty"`
+	ApprovalExpires *time.Time `json:"approval_expires,omitempty"`
+	Started         *time.Time `json:"started,omitempty"`
+	Finished        *time.Time `json:"finished,omitempty"`
+	Events          []jobEvent `json:"events"`
+	// HTTPStatus and Result are the response the request would have got
+	// had it waited for the deploy
+	HTTPStatus int       `json:"http_status,omitempty"`
+	Result     fiber.Map `json:"result,omitempty"`
+}
+
+// job is a deploy queued by an async request
+type job struct {
+	jobStatus
+	opID    int
+	log     *slog.Logger
+	run     func(log *slog.Logger) deployResult
+	release io.Closer // the artifact, closed when the job finishes
+}
+
+// jobQueue holds queued, running and recently finished jobs
+type jobQueue struct {
+	mu    sync.Mutex
+	jobs  map[string]*job
+	done  []string // finished job IDs, oldest first
+	queue chan *job
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{
+		jobs:  make(map[string]*job),
+		queue: make(chan *job, jobQueueSize),
+	}
+}
+
+// add registers a job and queues it. Returns false if the queue is full.
+func (q *jobQueue) add(j *job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.queue <- j:
+	default:
+		return false
+	}
+	q.jobs[j.ID] = j
+	return true
+}
+
+// schedule registers a job that is queued later with enqueue
+func (q *jobQueue) schedule(j *job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs[j.ID] = j
+}
+
+// enqueue queues a scheduled job. Returns false if the queue is full.
+func (q *jobQueue) enqueue(j *job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.queue <- j:
+	default:
+		return false
+	}
+	j.State = jobQueued
+	return true
+}
+
+// approve moves a job waiting for approval to the scheduled state
+func (q *jobQueue) approve(id, approver string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if j, ok := q.jobs[id]; ok && j.State == jobPendingApproval {
+		j.State = jobScheduled
+		j.ApprovedBy = approver
+		j.Events = append(j.Events, jobEvent{Time: time.Now(), Message: "approved by " + approver})
+	}
+}
+
+// cancel marks a scheduled job cancelled. Returns false if the job isn't
+// waiting to be queued.
+func (q *jobQueue) cancel(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok || (j.State != jobScheduled && j.State != jobPendingApproval) {
+		return false
+	}
+	now := time.Now()
+	j.State = jobCancelled
+	j.Finished = &now
+	q.retire(j.ID)
+	return true
+}
+
+// event records a progress message for a job
+func (q *jobQueue) event(id, msg string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if j, ok := q.jobs[id]; ok && len(j.Events) < maxJobEvents {
+		j.Events = append(j.Events, jobEvent{Time: time.Now(), Message: msg})
+	}
+}
+
+// start marks a job running
+func (q *jobQueue) start(j *job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	j.State = jobRunning
+	j.Started = &now
+}
+
+// finish records a job's result and forgets the oldest finished jobs
+func (q *jobQueue) finish(j *job, r deployResult) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	j.Finished = &now
+	j.HTTPStatus = r.Status
+	j.Result = r.Body
+	j.State = jobSucceeded
+	if r.Status >= fiber.StatusBadRequest {
+		j.State = jobFailed
+	}
+	q.retire(j.ID)
+}
+
+// retire adds a finished job to the done list and forgets the oldest
+// finished jobs; the caller holds the queue lock
+func (q *jobQueue) retire(id string) {
+	q.done = append(q.done, id)
+	for len(q.done) > keepFinishedJobs {
+		delete(q.jobs, q.done[0])
+		q.done = q.done[1:]
+	}
+}
+
+// get returns a copy of a job's status
+func (q *jobQueue) get(id string) (jobStatus, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return jobStatus{}, false
+	}
+	return j.snapshot(), true
+}
+
+// list returns every known job, newest first
+func (q *jobQueue) list() []jobStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]jobStatus, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		jobs = append(jobs, j.snapshot())
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].Created.After(jobs[b].Created) })
+	return jobs
+}
+
+// snapshot copies the status; the caller holds the queue lock
+func (j *job) snapshot() jobStatus {
+	st := j.jobStatus
+	st.Events = append([]jobEvent{}, j.Events...)
+	return st
+}
+
+// jobHandler records each message logged for a job as a progress event
+type jobHandler struct {
+	slog.Handler
+	q  *jobQueue
+	id string
+}
+
+func (h jobHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.q.event(h.id, r.Message)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h jobHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return jobHandler{Handler: h.Handler.WithAttrs(attrs), q: h.q, id: h.id}
+}
+
+func (h jobHandler) WithGroup(name string) slog.Handler {
+	return jobHandler{Handler: h.Handler.WithGroup(name), q: h.q, id: h.id}
+}
+
+// respondDeploy runs a deploy, logging to log, and sends its result. With
+// async=true it queues the deploy as a job instead and answers 202 with the
+// job; the job closes artifact when it finishes.
+func (s *Server) respondDeploy(c *fiber.Ctx, form *multipart.Form, log *slog.Logger, op operation, artifact io.Closer, run func(log *slog.Logger) deployResult) error {
+	async := false
+	if values := form.Value["async"]; len(values) > 0 {
+		async = values[0] == "true" || values[0] == "1"
+	}
+	if !async {
+		defer artifact.Close()
+		r := run(log)
+		return c.Status(r.Status).JSON(r.Body)
+	}
+
+	// Without storage the artifact is the upload, which is gone once the
+	// request returns
+	if !s.artifacts.Enabled() {
+		artifact.Close()
+		return sendError(c, errAsyncUnavailable, "artifact storage is disabled (artifacts.keep = -1)")
+	}
+
+	// Queued jobs count as in-flight, so shutdown waits for them too
+	op.Started = time.Now()
+	opID, ok := s.ops.begin(op)
+	if !ok {
+		artifact.Close()
+		return sendError(c, errShuttingDown, "")
+	}
+
+	id := uuid.NewString()
+	j := &job{
+		jobStatus: jobStatus{
+			ID:        id,
+			Kind:      op.Kind,
+			Site:      op.Site,
+			Commit:    op.Commit,
+			State:     jobQueued,
+			Created:   op.Started,
+			RequestID: requestID(c),
+			Events:    []jobEvent{{Time: op.Started, Message: "job queued"}},
+		},
+		opID:    opID,
+		run:     run,
+		release: artifact,
+	}
+	log = log.With("job_id", id)
+	j.log = slog.New(jobHandler{Handler: log.Handler(), q: s.jobs, id: id})
+
+	if !s.jobs.add(j) {
+		s.ops.end(opID)
+		artifact.Close()
+		return sendError(c, errJobQueueFull, "")
+	}
+	log.Info("job queued", "kind", op.Kind)
+
+	st, _ := s.jobs.get(id)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status": "queued",
+		"job":    st,
+	})
+}
+
+// startJobWorkers starts the workers that run queued deploys
+func (s *Server) startJobWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := range s.jobs.queue {
+				s.runJob(j)
+			}
+		}()
+	}
+}
+
+// runJob runs a queued deploy and records its result
+func (s *Server) runJob(j *job) {
+	defer s.ops.end(j.opID)
+	defer j.release.Close()
+
+	s.jobs.start(j)
+	j.log.Info("job started")
+	r := j.run(j.log)
+	s.jobs.finish(j, r)
+	s.readCache.invalidate()
+	st, _ := s.jobs.get(j.ID)
+	j.log.Info("job finished", "state", st.State, "http_status", r.Status)
+}
+
+// ListJobs lists queued, running and recently finished jobs, newest first.
+// ?site= limits the list to one site.
+func (s *Server) ListJobs(c *fiber.Ctx) error {
+	site := c.Query("site")
+	jobs := []jobStatus{}
+	for _, j := range s.jobs.list() {
+		if (site == "" || j.Site == site) && requestAllowsSite(c, j.Site) {
+			jobs = append(jobs, j)
+		}
+	}
+	return c.JSON(fiber.Map{
+		"status": "ok",
+		"jobs":   jobs,
+	})
+}
+
+// Job returns one job's progress and, once finished, its result. The site
+// query parameter must name the job's site.
+func (s *Server) Job(c *fiber.Ctx) error {
+	j, ok := s.jobs.get(c.Params("id"))
+	if !ok || j.Site != c.Query("site") {
+		return sendError(c, errJobNotFound, "")
+	}
+	return c.JSON(fiber.Map{
+		"status": "ok",
+		"job":    j,
+	})
+}
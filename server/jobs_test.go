@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lachierussell/shipyard/artifact"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/logger"
+)
+
+// closeRecorder notes when the job closes its artifact
+type closeRecorder struct{ closed chan struct{} }
+
+func (r *closeRecorder) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func TestRespondDeploy_Async(t *testing.T) {
+	srv := testServer(&config.Config{})
+	srv.ops = newOpTracker()
+	srv.jobs = newJobQueue()
+	srv.artifacts = artifact.NewStore(t.TempDir(), 1)
+	srv.startJobWorkers(1)
+
+	release := make(chan struct{})
+	rec := &closeRecorder{closed: make(chan struct{})}
+	app := fiber.New()
+	app.Post("/deploy", func(c *fiber.Ctx) error {
+		form, _ := requestForm(c, srv.cfg)
+		op := operation{Kind: "deploy_frontend", Site: "example.com", Commit: "abc1234"}
+		return srv.respondDeploy(c, form, slog.Default(), op, rec, func(log *slog.Logger) deployResult {
+			<-release
+			log.Info("copying files")
+			return deployResult{fiber.StatusOK, fiber.Map{"status": "deployed"}}
+		})
+	})
+	app.Get("/jobs/:id", srv.Job)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("async", "true")
+	writer.Close()
+	req := httptest.NewRequest("POST", "/deploy", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Test request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusAccepted {
+		t.Fatalf("Status = %d, want 202", resp.StatusCode)
+	}
+	var queued struct{ Job jobStatus }
+	json.NewDecoder(resp.Body).Decode(&queued)
+	if queued.Job.ID == "" {
+		t.Fatal("response has no job ID")
+	}
+
+	getJob := func(site string) (int, jobStatus) {
+		resp, err := app.Test(httptest.NewRequest("GET", "/jobs/"+queued.Job.ID+"?site="+site, nil))
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		var result struct{ Job jobStatus }
+		json.Unmarshal(data, &result)
+		return resp.StatusCode, result.Job
+	}
+
+	if code, _ := getJob("other.example.com"); code != fiber.StatusNotFound {
+		t.Errorf("job looked up under another site: status = %d, want 404", code)
+	}
+
+	close(release)
+	select {
+	case <-rec.closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("job did not finish")
+	}
+	// The artifact is closed once the result is recorded
+	_, st := getJob("example.com")
+	if st.State != jobSucceeded || st.HTTPStatus != fiber.StatusOK || st.Result["status"] != "deployed" {
+		t.Fatalf("job = %+v, want succeeded with the deploy's response", st)
+	}
+	found := false
+	for _, e := range st.Events {
+		found = found || e.Message == "copying files"
+	}
+	if !found {
+		t.Errorf("events = %+v, want the deploy's log messages", st.Events)
+	}
+}
+
+func TestBeginDeploy_TagsLines(t *testing.T) {
+	var buf bytes.Buffer
+	ctx, log, id := beginDeploy(slog.New(slog.NewJSONHandler(&buf, nil)))
+	log.Info("from the server")
+	logger.FromContext(ctx).Info("from a deployer")
+
+	dec := json.NewDecoder(&buf)
+	for i := 0; i < 2; i++ {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		if line["deploy_id"] != id {
+			t.Errorf("%s: deploy_id = %v, want %s", line["msg"], line["deploy_id"], id)
+		}
+	}
+}
+
+func TestJobQueue_FinishAndRetention(t *testing.T) {
+	q := newJobQueue()
+	var first string
+	for i := 0; i < keepFinishedJobs+1; i++ {
+		j := &job{jobStatus: jobStatus{ID: uuid.NewString(), State: jobQueued}}
+		if i == 0 {
+			first = j.ID
+		}
+		q.jobs[j.ID] = j
+		q.finish(j, errorResult(errDeploymentFailed, "boom"))
+	}
+
+	if _, ok := q.get(first); ok {
+		t.Error("oldest finished job kept past keepFinishedJobs")
+	}
+	jobs := q.list()
+	if len(jobs) != keepFinishedJobs {
+		t.Fatalf("len(list) = %d, want %d", len(jobs), keepFinishedJobs)
+	}
+	if jobs[0].State != jobFailed || jobs[0].Result["error"] != errDeploymentFailed.Code {
+		t.Errorf("job = %+v, want failed with the error body", jobs[0])
+	}
+}
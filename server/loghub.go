@@ -1,23 +1,52 @@
 package server
 
 import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
 	"github.com/gofiber/websocket/v2"
 )
 
+// logHistorySize is how many recent log lines the hub keeps for ?replay=
+const logHistorySize = 1000
+
+// logClientBuffer is how many lines wait for a client beyond its replay
+// before newer ones are dropped
+const logClientBuffer = 256
+
 // LogClient is a single WebSocket subscriber.
 type LogClient struct {
-	conn *websocket.Conn
-	send chan []byte
+	conn   *websocket.Conn
+	send   chan []byte
+	replay int // recent lines to send first
+	// dropped counts lines that didn't fit in send since the last marker.
+	// Only the hub's Run loop touches it.
+	dropped int
+}
+
+// newLogClient creates a subscriber whose send buffer fits replay lines of
+// history as well as logClientBuffer new ones
+func newLogClient(conn *websocket.Conn, replay int) *LogClient {
+	return &LogClient{conn: conn, send: make(chan []byte, replay+logClientBuffer), replay: replay}
 }
 
 // LogHub manages WebSocket log subscribers using the hub pattern.
-// It implements logger.Broadcaster.
+// It implements logger.Broadcaster. A client that falls behind has lines
+// dropped rather than being disconnected, and is told how many with a
+// marker line once it catches up.
 type LogHub struct {
 	clients    map[*LogClient]struct{}
 	register   chan *LogClient
 	unregister chan *LogClient
 	broadcast  chan []byte
 	stop       chan struct{}
+	history    [][]byte // ring of the latest logHistorySize lines
+	next       int      // where the next line goes in history
+	// dropped counts lines Broadcast couldn't queue, which no client got
+	dropped atomic.Int64
+	conns   atomic.Int32 // open connections, held with acquire
 }
 
 // NewLogHub creates a new LogHub.
@@ -28,6 +57,7 @@ func NewLogHub() *LogHub {
 		unregister: make(chan *LogClient),
 		broadcast:  make(chan []byte, 256),
 		stop:       make(chan struct{}),
+		history:    make([][]byte, 0, logHistorySize),
 	}
 }
 
@@ -37,20 +67,20 @@ func (h *LogHub) Run() {
 		select {
 		case client := <-h.register:
 			h.clients[client] = struct{}{}
+			for _, msg := range h.recent(client.replay) {
+				client.send <- msg
+			}
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
 				close(client.send)
 			}
 		case msg := <-h.broadcast:
+			h.remember(msg)
+			lost := int(h.dropped.Swap(0))
 			for client := range h.clients {
-				select {
-				case client.send <- msg:
-				default:
-					// Slow client — drop and disconnect.
-					delete(h.clients, client)
-					close(client.send)
-				}
+				client.dropped += lost
+				deliver(client, msg)
 			}
 		case <-h.stop:
 			for client := range h.clients {
@@ -62,12 +92,99 @@ func (h *LogHub) Run() {
 	}
 }
 
+// acquire takes one of max connection slots, reporting false when all are
+// taken. Each successful acquire needs a release.
+func (h *LogHub) acquire(max int) bool {
+	if h.conns.Add(1) > int32(max) {
+		h.conns.Add(-1)
+		return false
+	}
+	return true
+}
+
+// release frees a connection slot taken by acquire
+func (h *LogHub) release() {
+	h.conns.Add(-1)
+}
+
+// add subscribes a client, reporting false when the hub has stopped
+func (h *LogHub) add(client *LogClient) bool {
+	select {
+	case h.register <- client:
+		return true
+	case <-h.stop:
+		return false
+	}
+}
+
+// remove unsubscribes a client, closing its send channel
+func (h *LogHub) remove(client *LogClient) {
+	select {
+	case h.unregister <- client:
+	case <-h.stop:
+		// Run closed every client's channel on its way out
+	}
+}
+
+// deliver queues a line for a client, preceded by a marker when lines were
+// dropped before it. A full queue drops the line. Only Run calls it.
+func deliver(client *LogClient, msg []byte) {
+	if client.dropped > 0 {
+		select {
+		case client.send <- droppedMarker(client.dropped, time.Now()):
+			client.dropped = 0
+		default:
+			client.dropped++
+			return
+		}
+	}
+	select {
+	case client.send <- msg:
+	default:
+		client.dropped++
+	}
+}
+
+// droppedMarker returns the log line telling a client n lines were dropped
+func droppedMarker(n int, at time.Time) []byte {
+	msg, _ := json.Marshal(map[string]any{
+		"time":    at.Format(time.RFC3339Nano),
+		"level":   "WARN",
+		"msg":     fmt.Sprintf("dropped %d log messages", n),
+		"dropped": n,
+	})
+	return msg
+}
+
+// remember adds a line to the history. Only Run calls it.
+func (h *LogHub) remember(msg []byte) {
+	if len(h.history) < logHistorySize {
+		h.history = append(h.history, msg)
+	} else {
+		h.history[h.next] = msg
+	}
+	h.next = (h.next + 1) % logHistorySize
+}
+
+// recent returns up to the last n lines of history, oldest first. Only Run
+// calls it.
+func (h *LogHub) recent(n int) [][]byte {
+	n = min(n, len(h.history))
+	out := make([][]byte, 0, n)
+	for i := len(h.history) - n; i < len(h.history); i++ {
+		out = append(out, h.history[(h.next+i)%len(h.history)])
+	}
+	return out
+}
+
 // Broadcast sends a message to all connected clients (implements logger.Broadcaster).
 func (h *LogHub) Broadcast(msg []byte) {
 	select {
 	case h.broadcast <- msg:
 	default:
-		// Hub broadcast channel full — drop message.
+		// Hub broadcast channel full — drop the message and tell clients
+		// with the next one.
+		h.dropped.Add(1)
 	}
 }
 
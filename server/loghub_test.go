@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// waitForLines reads n lines a client was sent
+func waitForLines(t *testing.T, client *LogClient, n int) []string {
+	t.Helper()
+	var lines []string
+	for len(lines) < n {
+		select {
+		case msg := <-client.send:
+			lines = append(lines, string(msg))
+		case <-time.After(2 * time.Second):
+			t.Fatalf("got %d lines %v, want %d", len(lines), lines, n)
+		}
+	}
+	return lines
+}
+
+func TestLogHub_Recent(t *testing.T) {
+	h := NewLogHub()
+	h.remember([]byte("first"))
+	if got := h.recent(5); len(got) != 1 || string(got[0]) != "first" {
+		t.Errorf("recent = %q, want [first]", got)
+	}
+
+	for i := 0; i < logHistorySize+5; i++ {
+		h.remember([]byte(fmt.Sprint(i)))
+	}
+	var got []string
+	for _, msg := range h.recent(3) {
+		got = append(got, string(msg))
+	}
+	want := []string{fmt.Sprint(logHistorySize + 2), fmt.Sprint(logHistorySize + 3), fmt.Sprint(logHistorySize + 4)}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("recent = %v, want %v", got, want)
+	}
+	if n := len(h.recent(logHistorySize + 10)); n != logHistorySize {
+		t.Errorf("recent kept %d lines, want %d", n, logHistorySize)
+	}
+}
+
+func TestDeliver_SlowClientGetsDroppedMarker(t *testing.T) {
+	client := newLogClient(nil, 0)
+	for i := 0; i < logClientBuffer+4; i++ {
+		deliver(client, []byte(fmt.Sprint(i)))
+	}
+	if client.dropped != 4 {
+		t.Fatalf("dropped = %d, want 4", client.dropped)
+	}
+
+	// Once the client reads its buffer, the next line comes after a marker
+	// counting the lines it missed
+	waitForLines(t, client, logClientBuffer)
+	deliver(client, []byte("next"))
+	lines := waitForLines(t, client, 2)
+
+	var marker struct {
+		Level   string `json:"level"`
+		Dropped int    `json:"dropped"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &marker); err != nil || marker.Dropped != 4 || marker.Level != "WARN" {
+		t.Errorf("marker = %s, want a WARN line with dropped 4", lines[0])
+	}
+	if lines[1] != "next" || client.dropped != 0 {
+		t.Errorf("line after marker = %q, dropped = %d; want next, 0", lines[1], client.dropped)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/logstore"
+)
+
+// SearchLogs handles GET /logs/search: stored lines of shipyard's log and
+// backends' app.log, newest first. q matches text anywhere in a line; site,
+// level (the minimum), source (shipyard or app), deploy_id, from and to
+// (RFC 3339) narrow it, and limit caps it. Keys limited by key_acl only see their
+// sites' lines.
+func (s *Server) SearchLogs(c *fiber.Ctx) error {
+	if s.logs == nil {
+		return sendError(c, errLogSearchDisabled, "")
+	}
+	q := logstore.Query{
+		Text:     c.Query("q"),
+		Site:     c.Query("site"),
+		Level:    strings.ToUpper(c.Query("level")),
+		Source:   c.Query("source"),
+		DeployID: c.Query("deploy_id"),
+		Allow:    func(site string) bool { return requestAllowsSite(c, site) },
+	}
+	switch q.Level {
+	case "", "DEBUG", "INFO", "WARN", "ERROR":
+	default:
+		return sendError(c, errInvalidRequest, "level must be debug, info, warn or error")
+	}
+	switch q.Source {
+	case "", logstore.SourceShipyard, logstore.SourceApp:
+	default:
+		return sendError(c, errInvalidRequest, "source must be shipyard or app")
+	}
+	for name, dst := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return sendError(c, errInvalidRequest, name+" must be an RFC 3339 time, such as 2026-03-01T12:00:00Z")
+			}
+			*dst = t
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > logstore.MaxLimit {
+			return sendError(c, errInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", logstore.MaxLimit))
+		}
+		q.Limit = n
+	}
+
+	lines, err := s.logs.Search(q)
+	if err != nil {
+		reqLog(c).Warn("search logs", "error", err)
+		return sendError(c, errLogSearchFailed, "")
+	}
+	return c.JSON(fiber.Map{
+		"lines": lines,
+	})
+}
+
+// backendLogPaths returns the function the log store calls for the app.log
+// of each site with a backend. Paths are looked up once per site, since
+// finding a jail's root may run pot.
+func (s *Server) backendLogPaths() func() map[string]string {
+	known := make(map[string]string)
+	return func() map[string]string {
+		paths := make(map[string]string)
+		for name, site := range s.cfg.Site {
+			if site.Backend == nil {
+				continue
+			}
+			path, ok := known[name]
+			if !ok {
+				var err error
+				if path, err = s.driver.LogPath(name); err != nil {
+					continue // the jail may not exist yet
+				}
+				known[name] = path
+			}
+			paths[name] = path
+		}
+		return paths
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/logstore"
+)
+
+func TestSearchLogs(t *testing.T) {
+	srv := testServer(&config.Config{})
+	srv.logs = logstore.New(t.TempDir(), 14)
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, rec := range []map[string]any{
+		{"level": "INFO", "msg": "deploy started", "site": "a.example.com", "deploy_id": "d1"},
+		{"level": "ERROR", "msg": "deploy failed", "site": "a.example.com", "deploy_id": "d2"},
+		{"level": "ERROR", "msg": "reload failed"},
+	} {
+		rec["time"] = at.Add(time.Duration(i) * time.Minute).Format(time.RFC3339Nano)
+		if err := srv.logs.Add(rec, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New()
+	app.Get("/logs/search", srv.SearchLogs)
+	search := func(query string) (int, []map[string]any) {
+		resp, err := app.Test(httptest.NewRequest("GET", "/logs/search?"+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Lines []map[string]any `json:"lines"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Lines
+	}
+
+	if _, lines := search("q=deploy&level=error"); len(lines) != 1 || lines[0]["msg"] != "deploy failed" {
+		t.Errorf("q=deploy&level=error = %v, want [deploy failed]", lines)
+	}
+	if _, lines := search("site=a.example.com&to=2026-03-01T12:00:30Z"); len(lines) != 1 || lines[0]["msg"] != "deploy started" {
+		t.Errorf("site and to = %v, want [deploy started]", lines)
+	}
+	if _, lines := search("deploy_id=d2"); len(lines) != 1 || lines[0]["msg"] != "deploy failed" {
+		t.Errorf("deploy_id=d2 = %v, want [deploy failed]", lines)
+	}
+	for _, query := range []string{"level=fatal", "source=nginx", "from=yesterday", "limit=0"} {
+		if code, _ := search(query); code != fiber.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, code)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
+	"github.com/lachierussell/shipyard/firewall"
+	"github.com/lachierussell/shipyard/jail"
+	"github.com/lachierussell/shipyard/nginx"
+	"github.com/lachierussell/shipyard/service"
+	"github.com/lachierussell/shipyard/ssl"
+)
+
+// NginxManager writes site configs and validates, reloads and starts nginx.
+// *nginx.Manager is the real one.
+type NginxManager interface {
+	DeploySiteConfig(ctx context.Context, siteName, nginxConfig string) (bool, string, error)
+	DeploySiteConfigRaw(ctx context.Context, siteName, nginxConfig string) (bool, string, error)
+	DeployHTTPOnlyConfig(ctx context.Context, domain string) error
+	RemoveSiteConfig(ctx context.Context, siteName string) error
+	RemoveSiteConfigByDomain(ctx context.Context, domain string) error
+	ApplyOverrides(ctx context.Context) error
+	Reload(ctx context.Context) error
+	EnsureRunning(ctx context.Context) (string, error)
+	EnsureSiteLinks() ([]string, error)
+}
+
+// JailManager builds jail templates and removes backends' volumes.
+// *jail.Manager is the real one.
+type JailManager interface {
+	TemplatePot(name string) (string, error)
+	TemplateBuilt(name string) (bool, error)
+	BuildTemplate(ctx context.Context, name string) (string, bool, error)
+	PurgeVolumes(siteName string) error
+}
+
+// ServiceManager manages the host services that run backends.
+// *service.Manager is the real one.
+type ServiceManager interface {
+	WriteEnv(siteName string) ([]string, error)
+	CreateBackendService(siteName string) error
+	RemoveBackendService(siteName string) error
+	Enable(siteName string) error
+	Disable(siteName string) error
+	Start(siteName string) error
+	Stop(siteName string) error
+	Status(siteName string) (bool, error)
+}
+
+// SSLManager checks sites' DNS and obtains their certificates.
+// *ssl.Manager is the real one.
+type SSLManager interface {
+	CheckDNS(domain string) ssl.DNSCheck
+	ObtainCert(ctx context.Context, domain, email string) error
+}
+
+var (
+	_ NginxManager   = (*nginx.Manager)(nil)
+	_ JailManager    = (*jail.Manager)(nil)
+	_ ServiceManager = (*service.Manager)(nil)
+	_ SSLManager     = (*ssl.Manager)(nil)
+)
+
+// Managers are what the server changes the host with. A nil field gets the
+// real manager; tests pass the fakes in server/fake instead. The deployers
+// are given the same ones.
+type Managers struct {
+	Nginx    NginxManager
+	Jail     JailManager
+	Driver   jail.Driver
+	Service  ServiceManager
+	SSL      SSLManager
+	Firewall deploy.Firewall
+}
+
+// withDefaults fills the nil fields with the real managers
+func (m Managers) withDefaults(cfg *config.Config) Managers {
+	if m.Nginx == nil {
+		m.Nginx = nginx.NewManager(cfg)
+	}
+	if m.Jail == nil {
+		m.Jail = jail.NewManager(cfg)
+	}
+	if m.Driver == nil {
+		m.Driver = jail.NewDriver(cfg)
+	}
+	if m.Service == nil {
+		m.Service = service.NewManager(cfg)
+	}
+	if m.SSL == nil {
+		m.SSL = ssl.NewManager(cfg)
+	}
+	if m.Firewall == nil {
+		m.Firewall = firewall.NewManager(cfg)
+	}
+	return m
+}
+
+// deploy returns the managers the deployers use
+func (m Managers) deploy() deploy.Managers {
+	return deploy.Managers{Nginx: m.Nginx, Driver: m.Driver, Service: m.Service, Firewall: m.Firewall}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
+	"github.com/lachierussell/shipyard/server/fake"
+)
+
+var (
+	_ NginxManager   = (*fake.Nginx)(nil)
+	_ JailManager    = (*fake.Jails)(nil)
+	_ ServiceManager = (*fake.Services)(nil)
+	_ SSLManager     = (*fake.SSL)(nil)
+
+	_ deploy.Firewall = (*fake.Firewall)(nil)
+)
+
+// fakeServer builds the whole server with fake managers, for testing the API
+// end to end
+func fakeServer(t *testing.T, cfg *config.Config) (*Server, fake.Managers) {
+	t.Helper()
+	cfg.AdminKeys = []string{"admin"}
+	// The health checkers may still be writing here after Shutdown, which
+	// t.TempDir's cleanup would fail on
+	stateDir, err := os.MkdirTemp("", "shipyard-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Self.StateDir = stateDir
+	cfg.Health.ReconcileInterval = -1
+	fakes := fake.New(t.TempDir())
+	srv := New(cfg, "1.0.0-test", "abc1234", nil, nil, Managers{
+		Nginx:    fakes.Nginx,
+		Jail:     fakes.Jail,
+		Driver:   fakes.Driver,
+		Service:  fakes.Service,
+		SSL:      fakes.SSL,
+		Firewall: fakes.Firewall,
+	})
+	t.Cleanup(func() {
+		srv.Shutdown()
+		os.RemoveAll(stateDir)
+	})
+	return srv, fakes
+}
+
+// post sends an admin form request to the server, returning the status and JSON body
+func post(t *testing.T, srv *Server, path string, fields map[string]string) (int, map[string]any) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for k, v := range fields {
+		writer.WriteField(k, v)
+	}
+	writer.Close()
+	req := httptest.NewRequest("POST", path, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Shipyard-Key", "admin")
+	resp, err := srv.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	var result map[string]any
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+func TestAPI_BackendActionsWithFakes(t *testing.T) {
+	srv, fakes := fakeServer(t, &config.Config{
+		Site: map[string]config.SiteConfig{
+			"api.example.com": {Backend: &config.BackendConfig{BinaryName: "api", ListenPort: 8080}},
+		},
+	})
+	site := map[string]string{"site": "api.example.com"}
+
+	status, result := post(t, srv, "/site/backend/start", site)
+	if status != 200 || result["running"] != true {
+		t.Fatalf("start = %d %v", status, result)
+	}
+	if !fakes.Driver.IsRunning("api.example.com") || !fakes.Service.Enabled("api.example.com") {
+		t.Error("start didn't start the jail and enable the service")
+	}
+
+	status, result = post(t, srv, "/site/backend/stop", site)
+	if status != 200 || result["running"] != false {
+		t.Fatalf("stop = %d %v", status, result)
+	}
+	if fakes.Service.Enabled("api.example.com") {
+		t.Error("stop left the service enabled")
+	}
+
+	fakes.Service.FailWith("Start", errors.New("rc.d script missing"))
+	status, result = post(t, srv, "/site/backend/restart", site)
+	if status != 500 || result["error"] != "backend_action_failed" {
+		t.Errorf("failed restart = %d %v", status, result)
+	}
+	if calls := fakes.Service.Calls(); !slices.Contains(calls, "Start api.example.com") {
+		t.Errorf("service calls = %v", calls)
+	}
+
+	// A service that won't stop is reported, and stays enabled
+	fakes.Service.FailWith("Start", nil)
+	fakes.Service.FailWith("Stop", errors.New("service timed out"))
+	status, result = post(t, srv, "/site/backend/stop", site)
+	if status != 500 || result["error"] != "backend_action_failed" {
+		t.Errorf("failed stop = %d %v", status, result)
+	}
+	if !fakes.Service.Enabled("api.example.com") {
+		t.Error("a failed stop disabled the service")
+	}
+	status, result = post(t, srv, "/site/backend/restart", site)
+	if status != 500 || result["error"] != "backend_action_failed" {
+		t.Errorf("restart with a failed stop = %d %v", status, result)
+	}
+}
+
+func TestAPI_BuildJailTemplateWithFakes(t *testing.T) {
+	srv, fakes := fakeServer(t, &config.Config{
+		Jail: config.JailConfig{Templates: map[string]config.TemplateConfig{"node": {Packages: []string{"node20"}}}},
+	})
+
+	status, result := post(t, srv, "/jails/templates/build", map[string]string{"name": "node"})
+	if status != 200 || result["built"] != true || result["pot"] != "tpl-node" {
+		t.Fatalf("build = %d %v", status, result)
+	}
+	// The definition is built already
+	if _, result = post(t, srv, "/jails/templates/build", map[string]string{"name": "node"}); result["built"] != false {
+		t.Errorf("rebuild = %v, want built false", result)
+	}
+	if got := fakes.Jail.Calls(); len(got) != 2 {
+		t.Errorf("jail calls = %v", got)
+	}
+}
+
+func TestAPI_FrontendDeployWithFakes(t *testing.T) {
+	root := t.TempDir()
+	srv, fakes := fakeServer(t, &config.Config{
+		Site: map[string]config.SiteConfig{
+			"app.example.com": {FrontendRoot: root, Backend: &config.BackendConfig{BinaryName: "api", ListenPort: 8080}},
+		},
+	})
+
+	archive := &bytes.Buffer{}
+	zw := zip.NewWriter(archive)
+	f, _ := zw.Create("index.html")
+	f.Write([]byte("<h1>hi</h1>"))
+	zw.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("site", "app.example.com")
+	writer.WriteField("commit", "abc1234")
+	part, _ := writer.CreateFormFile("artifact", "dist.zip")
+	part.Write(archive.Bytes())
+	writer.Close()
+	req := httptest.NewRequest("POST", "/deploy/frontend", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Shipyard-Key", "admin")
+	resp, err := srv.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	var result map[string]any
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != 200 {
+		t.Fatalf("deploy = %d %v", resp.StatusCode, result)
+	}
+
+	// The deployer wrote the site's config through the fake, not nginx
+	if conf, ok := fakes.Nginx.Config("app.example.com"); !ok || conf == "" {
+		t.Errorf("site config not deployed to the fake; calls = %v", fakes.Nginx.Calls())
+	}
+	if _, err := os.Stat(filepath.Join(root, "abc1234", "index.html")); err != nil {
+		t.Errorf("commit not extracted: %v", err)
+	}
+}
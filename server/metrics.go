@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/health"
+)
+
+// Metrics returns backend process metrics in the Prometheus text format.
+// Keys limited by key_acl only see their own sites.
+func (s *Server) Metrics(c *fiber.Ctx) error {
+	var all map[string]health.ProcessMetrics
+	if s.metrics != nil {
+		all = s.metrics.All()
+	}
+
+	var b strings.Builder
+	metric := func(name, kind, help string, value func(site string) string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, site := range sortedNames(all) {
+			if requestAllowsSite(c, site) {
+				fmt.Fprintf(&b, "%s{site=%q} %s\n", name, site, value(site))
+			}
+		}
+	}
+
+	metric("shipyard_backend_processes", "gauge", "Processes running in the backend's jail",
+		func(site string) string { return fmt.Sprint(all[site].Processes) })
+	metric("shipyard_backend_cpu_percent", "gauge", "CPU use of the backend's processes, in percent of one core",
+		func(site string) string { return fmt.Sprint(all[site].CPUPercent) })
+	metric("shipyard_backend_rss_bytes", "gauge", "Resident memory of the backend's processes",
+		func(site string) string { return fmt.Sprint(all[site].RSSBytes) })
+	metric("shipyard_backend_open_fds", "gauge", "Open file descriptors of the backend's processes",
+		func(site string) string { return fmt.Sprint(all[site].OpenFDs) })
+	metric("shipyard_backend_restarts_total", "counter", "Times the backend was restarted after exiting since shipyard started",
+		func(site string) string { return fmt.Sprint(all[site].Restarts) })
+
+	// Readiness comes from the reconciler's latest check of each backend
+	states := s.reconciler.States()
+	name := "shipyard_backend_ready"
+	fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, "Whether the backend passed its health check at the last reconcile (1) or not (0)", name)
+	for _, site := range sortedNames(states) {
+		if requestAllowsSite(c, site) && states[site].Enabled {
+			fmt.Fprintf(&b, "%s{site=%q} %d\n", name, site, boolGauge(states[site].Ready))
+		}
+	}
+
+	// Repairs are labelled by action too, and only sites with repairs appear
+	repairs := s.reconciler.Actions()
+	name = "shipyard_backend_reconcile_repairs_total"
+	fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, "Times the reconciler repaired the backend since shipyard started, by action", name)
+	for _, site := range sortedNames(repairs) {
+		if !requestAllowsSite(c, site) {
+			continue
+		}
+		for _, action := range sortedNames(repairs[site]) {
+			fmt.Fprintf(&b, "%s{site=%q,action=%q} %d\n", name, site, action, repairs[site][action])
+		}
+	}
+
+	// Availability comes from the uptime history of each site's probes, and
+	// only sites that have been probed appear
+	now := time.Now()
+	name = "shipyard_site_availability_ratio"
+	fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, "Share of the site's health probes that passed over the last 30 days", name)
+	for _, site := range sortedNames(s.cfg.Site) {
+		if !requestAllowsSite(c, site) {
+			continue
+		}
+		if pct, ok := s.uptime.Get(site).Availability(health.AvailabilityDays, now); ok {
+			fmt.Fprintf(&b, "%s{site=%q} %g\n", name, site, pct/100)
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4")
+	return c.SendString(b.String())
+}
+
+// boolGauge returns 1 for true and 0 for false
+func boolGauge(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -1,83 +1,251 @@
 package server
 
 import (
+	"bytes"
 	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"mime/multipart"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/oidc"
 )
 
-// AdminAuth checks X-Shipyard-Key against admin_keys
+// AdminAuth checks X-Shipyard-Key against admin_keys, or with [oidc] an
+// Authorization bearer token from the IdP. Keys limited by key_acl and users
+// whose groups grant only some sites must name a site they may manage (see
+// targetSite).
 func AdminAuth(cfg *config.Config) fiber.Handler {
+	return adminAuth(cfg, scopeSite)
+}
+
+// AdminListAuth is AdminAuth for listings across sites: limited keys and
+// users are let through without naming a site, and the handler filters the
+// results with requestAllowsSite.
+func AdminListAuth(cfg *config.Config) fiber.Handler {
+	return adminAuth(cfg, scopeListing)
+}
+
+// GlobalAdminAuth is AdminAuth for host-wide routes, such as self-updates
+// and shared snippets: limited keys and users are refused whatever site the
+// request names.
+func GlobalAdminAuth(cfg *config.Config) fiber.Handler {
+	return adminAuth(cfg, scopeGlobal)
+}
+
+// adminScope is what a limited key or user may reach on an admin route
+type adminScope int
+
+const (
+	scopeSite    adminScope = iota // the site the request names
+	scopeListing                   // listings filtered to its sites
+	scopeGlobal                    // nothing
+)
+
+func adminAuth(cfg *config.Config, scope adminScope) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		key := c.Get("X-Shipyard-Key")
-		if key == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"status": "error",
-				"error":  "missing_auth",
-				"detail": "X-Shipyard-Key header required",
-			})
-		}
-
-		// Check if key is in admin_keys (constant-time comparison)
-		found := false
-		for _, adminKey := range cfg.AdminKeys {
-			if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1 {
-				found = true
-				break
+		var globs []string
+		scoped := false
+
+		if token := bearerToken(c, cfg); token != "" {
+			var apiErr *APIError
+			var detail string
+			globs, apiErr, detail = bearerAuth(c, cfg, token)
+			if apiErr != nil {
+				return sendError(c, apiErr, detail)
+			}
+			scoped = globs != nil
+		} else {
+			key := c.Get("X-Shipyard-Key")
+			if key == "" {
+				return sendError(c, errMissingAuth, "X-Shipyard-Key header required")
 			}
+
+			// Check if key is in admin_keys (constant-time comparison)
+			if !isAdminKey(cfg, key) {
+				return sendError(c, errInvalidKey, "")
+			}
+			globs, scoped = cfg.KeyACL[key]
 		}
-		if !found {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"status": "error",
-				"error":  "invalid_key",
-			})
+
+		if scoped {
+			if scope == scopeGlobal {
+				return sendError(c, errSiteNotAllowed, "this key or user is limited to some sites and the route affects every site")
+			}
+			c.Locals("site_scope", globs)
+			site, ok := targetSite(c, cfg)
+			switch {
+			case !ok:
+				return sendError(c, errSiteNotAllowed, "the query and the body name different sites")
+			case site == "" && scope == scopeSite:
+				return sendError(c, errSiteNotAllowed, "this key or user is limited to some sites and the request does not name one")
+			case site != "" && !config.MatchSiteGlobs(globs, site):
+				return sendError(c, errSiteNotAllowed, "")
+			}
 		}
 
 		return c.Next()
 	}
 }
 
+// isAdminKey reports whether key is one of admin_keys (constant-time comparison)
+func isAdminKey(cfg *config.Config, key string) bool {
+	found := false
+	for _, adminKey := range cfg.AdminKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+// bearerToken returns the request's Authorization bearer token when [oidc] is configured
+func bearerToken(c *fiber.Ctx, cfg *config.Config) string {
+	if !cfg.OIDC.Enabled() {
+		return ""
+	}
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// bearerAuth verifies an OIDC token and returns the site globs its groups
+// grant (nil for every site). The user is added to the request logger.
+func bearerAuth(c *fiber.Ctx, cfg *config.Config, token string) ([]string, *APIError, string) {
+	oc := cfg.OIDC
+	claims, err := oidc.For(oc.Issuer, oc.ExpectedAudience(), oc.JWKSURL).Verify(token)
+	if err != nil {
+		return nil, errInvalidToken, err.Error()
+	}
+	globs, ok := oc.SiteGlobs(claims.Strings(oc.GroupsClaimName()))
+	if !ok {
+		return nil, errNoGroupAccess, ""
+	}
+
+	user := claims.Email
+	if user == "" {
+		user = claims.Subject
+	}
+	c.Locals("user", user)
+	c.Locals("logger", reqLog(c).With("user", user))
+	return globs, nil, ""
+}
+
+// targetSite returns the site an admin request operates on: the site query
+// parameter, the site form field, or the domain of a JSON site creation body.
+// Handlers read the site from one place or the other, so a request whose
+// query and body name different sites is ambiguous and returns ok false.
+func targetSite(c *fiber.Ctx, cfg *config.Config) (site string, ok bool) {
+	query := c.Query("site")
+	body := bodySite(c, cfg)
+	if query != "" && body != "" && query != body {
+		return "", false
+	}
+	if query != "" {
+		return query, true
+	}
+	return body, true
+}
+
+// bodySite returns the site a request's form or JSON body names
+func bodySite(c *fiber.Ctx, cfg *config.Config) string {
+	if form, err := requestForm(c, cfg); err == nil {
+		if v := form.Value["site"]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		var body struct {
+			Domain string `json:"domain"`
+			Site   string `json:"site"`
+		}
+		if json.Unmarshal(c.Body(), &body) == nil {
+			if body.Domain != "" {
+				return body.Domain
+			}
+			return body.Site
+		}
+	}
+	return ""
+}
+
+// requestAllowsSite reports whether the authenticated key or user may manage site
+func requestAllowsSite(c *fiber.Ctx, site string) bool {
+	globs, scoped := c.Locals("site_scope").([]string)
+	return !scoped || config.MatchSiteGlobs(globs, site)
+}
+
 // SiteAuth checks X-Shipyard-Key against the targeted site's api_key OR admin_keys
 // The site is identified from the "site" form field
-// Admin keys can perform any site operation
+// A request ReplayGuard verified as signed with the site's api_key needs no key
+// Admin keys (and OIDC users) can perform site operations within their key_acl or groups
 func SiteAuth(cfg *config.Config) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return siteAuth(cfg, func(c *fiber.Ctx) ([]string, error) {
 		// Parse the body to get the site field
-		form, err := c.MultipartForm()
+		form, err := requestForm(c, cfg)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"status": "error",
-				"error":  "invalid_request",
-				"detail": "failed to parse form",
-			})
+			return nil, err
 		}
+		return form.Value["site"], nil
+	})
+}
 
-		siteName := form.Value["site"]
+// SiteQueryAuth is SiteAuth for GET requests, which name the site with the
+// site query parameter
+func SiteQueryAuth(cfg *config.Config) fiber.Handler {
+	return siteAuth(cfg, func(c *fiber.Ctx) ([]string, error) {
+		if site := c.Query("site"); site != "" {
+			return []string{site}, nil
+		}
+		return nil, nil
+	})
+}
+
+// siteAuth authenticates a request for the site that target names
+func siteAuth(cfg *config.Config, target func(c *fiber.Ctx) ([]string, error)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		siteName, err := target(c)
+		if err != nil {
+			return sendError(c, errInvalidRequest, "failed to parse form")
+		}
 		if len(siteName) == 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"status": "error",
-				"error":  "missing_site",
-			})
+			return sendError(c, errMissingSite, "")
 		}
 
 		site, ok := cfg.Site[siteName[0]]
 		if !ok {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"status": "error",
-				"error":  "site_not_found",
-			})
+			return sendError(c, errSiteNotFound, "")
+		}
+
+		if token := bearerToken(c, cfg); token != "" {
+			globs, apiErr, detail := bearerAuth(c, cfg, token)
+			if apiErr != nil {
+				return sendError(c, apiErr, detail)
+			}
+			if globs != nil && !config.MatchSiteGlobs(globs, siteName[0]) {
+				return sendError(c, errSiteNotAllowed, "")
+			}
+			c.Locals("admin_key", true)
+			return c.Next()
 		}
 
 		key := c.Get("X-Shipyard-Key")
 		if key == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"status": "error",
-				"error":  "missing_auth",
-			})
+			// ReplayGuard verified a request signed with the site's key
+			if signed, _ := c.Locals("signed_site").(string); signed != "" && signed == siteName[0] {
+				return c.Next()
+			}
+			return sendError(c, errMissingAuth, "")
 		}
 
 		// Check if key matches site API key
@@ -85,27 +253,39 @@ func SiteAuth(cfg *config.Config) fiber.Handler {
 			return c.Next()
 		}
 
-		// Also allow admin keys to perform site operations
-		for _, adminKey := range cfg.AdminKeys {
-			if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1 {
-				return c.Next()
+		// Also allow admin keys to perform site operations, within their key_acl
+		if isAdminKey(cfg, key) {
+			if !cfg.KeyAllowsSite(key, siteName[0]) {
+				return sendError(c, errSiteNotAllowed, "")
 			}
+			c.Locals("admin_key", true)
+			return c.Next()
 		}
 
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"status": "error",
-			"error":  "invalid_key",
-		})
+		return sendError(c, errInvalidKey, "")
 	}
 }
 
+// isAdminRequest reports whether SiteAuth accepted the request with an admin key or OIDC token
+func isAdminRequest(c *fiber.Ctx) bool {
+	admin, _ := c.Locals("admin_key").(bool)
+	return admin
+}
+
+// validRequestID matches the X-Request-Id values a client may supply
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{8,128}$`)
+
 // RequestLogger logs incoming requests with method, path, status, and latency.
 // It assigns a unique request ID accessible via X-Request-Id header and c.Locals("request_id").
+// A well-formed X-Request-Id sent by the client, such as a CI run's, is kept.
 func RequestLogger() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		start := time.Now()
 
-		requestID := uuid.NewString()
+		requestID := c.Get("X-Request-Id")
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
 		c.Set("X-Request-Id", requestID)
 		c.Locals("request_id", requestID)
 
@@ -128,12 +308,19 @@ func RequestLogger() fiber.Handler {
 	}
 }
 
+// requestID returns the ID RequestLogger gave the request, or ""
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("request_id").(string)
+	return id
+}
+
 // CORS handles cross-origin requests for the web admin UI
 func CORS() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		c.Set("Access-Control-Allow-Origin", "*")
-		c.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Set("Access-Control-Allow-Headers", "Content-Type, X-Shipyard-Key")
+		c.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Set("Access-Control-Allow-Headers", "Content-Type, X-Shipyard-Key, Authorization, If-None-Match, X-Request-Id")
+		c.Set("Access-Control-Expose-Headers", "ETag, X-Request-Id")
 
 		// Handle preflight
 		if c.Method() == "OPTIONS" {
@@ -144,16 +331,47 @@ func CORS() fiber.Handler {
 	}
 }
 
-// SizeLimit rejects requests larger than MaxRequestSize
-func SizeLimit() fiber.Handler {
+// SizeLimit rejects requests with a declared body larger than limit bytes
+func SizeLimit(limit int) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		if len := c.Request().Header.ContentLength(); len > MaxRequestSize {
-			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
-				"status": "error",
-				"error":  "request_too_large",
-				"detail": "max 500MB",
-			})
+		if len := c.Request().Header.ContentLength(); len > limit {
+			return sendError(c, errRequestTooLarge, fmt.Sprintf("max %dMB", limit>>20))
 		}
 		return c.Next()
 	}
 }
+
+// errNoMultipartForm is returned by requestForm for non-multipart requests
+var errNoMultipartForm = errors.New("request is not multipart/form-data")
+
+// requestForm parses the request's multipart form once and caches it for later
+// handlers. Up to server.multipart_memory_mb is held in memory; larger file parts
+// spill to temporary files, which MultipartCleanup removes after the request.
+func requestForm(c *fiber.Ctx, cfg *config.Config) (*multipart.Form, error) {
+	if form, ok := c.Locals("multipart_form").(*multipart.Form); ok {
+		return form, nil
+	}
+
+	boundary := string(c.Request().Header.MultipartFormBoundary())
+	if boundary == "" {
+		return nil, errNoMultipartForm
+	}
+
+	form, err := multipart.NewReader(bytes.NewReader(c.Body()), boundary).ReadForm(cfg.Server.MultipartMemory())
+	if err != nil {
+		return nil, fmt.Errorf("read multipart form: %w", err)
+	}
+	c.Locals("multipart_form", form)
+	return form, nil
+}
+
+// MultipartCleanup removes temporary files created by requestForm
+func MultipartCleanup() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		if form, ok := c.Locals("multipart_form").(*multipart.Form); ok {
+			form.RemoveAll()
+		}
+		return err
+	}
+}
@@ -158,6 +158,204 @@ func TestSiteAuth_ValidKey(t *testing.T) {
 	}
 }
 
+func TestAdminAuth_KeyACL(t *testing.T) {
+	cfg := &config.Config{
+		AdminKeys: []string{"sk-admin", "sk-team-a"},
+		KeyACL:    map[string][]string{"sk-team-a": {"*.team-a.example.com"}},
+	}
+
+	app := fiber.New()
+	app.Get("/test", AdminAuth(cfg), func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+	app.Post("/create", AdminAuth(cfg), func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	tests := []struct {
+		key, path string
+		want      int
+	}{
+		{"sk-admin", "/test", 200},
+		{"sk-admin", "/test?site=prod.team-b.example.com", 200},
+		{"sk-team-a", "/test?site=docs.team-a.example.com", 200},
+		{"sk-team-a", "/test?site=prod.team-b.example.com", 403},
+		{"sk-team-a", "/test", 403},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("X-Shipyard-Key", tt.key)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s: status = %d, want %d", tt.key, tt.path, resp.StatusCode, tt.want)
+		}
+	}
+
+	// Site creation names the site in a JSON body
+	for domain, want := range map[string]int{"new.team-a.example.com": 200, "new.team-b.example.com": 403} {
+		req := httptest.NewRequest("POST", "/create", bytes.NewBufferString(`{"domain":"`+domain+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Shipyard-Key", "sk-team-a")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("create %s: status = %d, want %d", domain, resp.StatusCode, want)
+		}
+	}
+
+	// Handlers act on the form site, so a query site can't vouch for another one
+	for _, tt := range []struct {
+		query, form string
+		want        int
+	}{
+		{"docs.team-a.example.com", "prod.team-b.example.com", 403},
+		{"docs.team-a.example.com", "docs.team-a.example.com", 200},
+		{"", "prod.team-b.example.com", 403},
+	} {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("site", tt.form)
+		writer.Close()
+		req := httptest.NewRequest("POST", "/create?site="+tt.query, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Shipyard-Key", "sk-team-a")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("query %q, form %q: status = %d, want %d", tt.query, tt.form, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestAdminAuth_BearerToken(t *testing.T) {
+	cfg := &config.Config{
+		AdminKeys: []string{"sk-admin"},
+		OIDC: config.OIDCConfig{
+			Issuer:   "https://idp.example.com",
+			ClientID: "shipyard",
+			Groups:   map[string][]string{"platform": {"*"}},
+		},
+	}
+
+	app := fiber.New()
+	app.Get("/test", AdminAuth(cfg), func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+		code    string
+	}{
+		{"malformed token", map[string]string{"Authorization": "Bearer not-a-jwt"}, 401, "invalid_token"},
+		{"static key still works", map[string]string{"X-Shipyard-Key": "sk-admin"}, 200, ""},
+		{"basic auth is not a bearer token", map[string]string{"Authorization": "Basic Zm9vOmJhcg=="}, 401, "missing_auth"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/test", nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+		if tt.code != "" {
+			var body map[string]any
+			json.NewDecoder(resp.Body).Decode(&body)
+			if body["error"] != tt.code {
+				t.Errorf("%s: error = %v, want %s", tt.name, body["error"], tt.code)
+			}
+		}
+	}
+}
+
+func TestSiteAuth_KeyACL(t *testing.T) {
+	cfg := &config.Config{
+		AdminKeys: []string{"sk-team-a"},
+		KeyACL:    map[string][]string{"sk-team-a": {"*.team-a.example.com"}},
+		Site: map[string]config.SiteConfig{
+			"docs.team-a.example.com": {FrontendRoot: "/var/www/a", APIKey: "sk-site-a"},
+			"prod.team-b.example.com": {FrontendRoot: "/var/www/b", APIKey: "sk-site-b"},
+		},
+	}
+
+	app := fiber.New()
+	app.Post("/test", SiteAuth(cfg), func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	for site, want := range map[string]int{"docs.team-a.example.com": 200, "prod.team-b.example.com": 403} {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("site", site)
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/test", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Shipyard-Key", "sk-team-a")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("site %s: status = %d, want %d", site, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestSiteAuth_MarksAdminKey(t *testing.T) {
+	cfg := &config.Config{
+		AdminKeys: []string{"sk-admin"},
+		Site: map[string]config.SiteConfig{
+			"test.example.com": {
+				FrontendRoot: "/var/www/test",
+				APIKey:       "sk-site-test-key",
+			},
+		},
+	}
+
+	app := fiber.New()
+	app.Post("/test", SiteAuth(cfg), func(c *fiber.Ctx) error {
+		if isAdminRequest(c) {
+			return c.SendString("admin")
+		}
+		return c.SendString("site")
+	})
+
+	for key, want := range map[string]string{"sk-admin": "admin", "sk-site-test-key": "site"} {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("site", "test.example.com")
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/test", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Shipyard-Key", key)
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		if string(got) != want {
+			t.Errorf("key %s: isAdminRequest gave %q, want %q", key, got, want)
+		}
+	}
+}
+
 func TestSiteAuth_MissingSite(t *testing.T) {
 	cfg := &config.Config{
 		Site: map[string]config.SiteConfig{},
@@ -254,7 +452,7 @@ func TestSiteAuth_InvalidKey(t *testing.T) {
 
 func TestSizeLimit_UnderLimit(t *testing.T) {
 	app := fiber.New()
-	app.Post("/test", SizeLimit(), func(c *fiber.Ctx) error {
+	app.Post("/test", SizeLimit(config.DefaultMaxBodyMB<<20), func(c *fiber.Ctx) error {
 		return c.SendString("OK")
 	})
 
@@ -274,7 +472,7 @@ func TestSizeLimit_UnderLimit(t *testing.T) {
 
 func TestSizeLimit_OverLimit(t *testing.T) {
 	app := fiber.New()
-	app.Post("/test", SizeLimit(), func(c *fiber.Ctx) error {
+	app.Post("/test", SizeLimit(config.DefaultMaxBodyMB<<20), func(c *fiber.Ctx) error {
 		return c.SendString("OK")
 	})
 
@@ -320,3 +518,144 @@ func TestIsValidCommitHash(t *testing.T) {
 		})
 	}
 }
+
+func TestClientCert_RequiredExceptExemptRoutes(t *testing.T) {
+	app := fiber.New()
+	app.Use(ClientCert())
+	ok := func(c *fiber.Ctx) error { return c.SendString("OK") }
+	app.Get("/health", ok)
+	app.Get("/.well-known/acme-challenge/:token", ok)
+	app.Get("/sites", ok)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/health", 200},
+		{"/.well-known/acme-challenge/abc", 200},
+		{"/sites", 401},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: Status = %d, want %d", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestRequestLogger_RequestID(t *testing.T) {
+	app := fiber.New()
+	app.Get("/test", RequestLogger(), func(c *fiber.Ctx) error {
+		return c.SendString(requestID(c))
+	})
+
+	for _, tt := range []struct {
+		sent string
+		kept bool
+	}{
+		{"", false},
+		{"ci-run-4512.attempt-1", true},
+		{"short", false},
+		{"has spaces in it", false},
+	} {
+		req := httptest.NewRequest("GET", "/test", nil)
+		if tt.sent != "" {
+			req.Header.Set("X-Request-Id", tt.sent)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		header := resp.Header.Get("X-Request-Id")
+		if header == "" || header != string(body) {
+			t.Errorf("%q: X-Request-Id = %q, request_id = %q; want the same ID", tt.sent, header, body)
+		}
+		if (header == tt.sent) != tt.kept {
+			t.Errorf("%q: X-Request-Id = %q, kept = %v", tt.sent, header, tt.kept)
+		}
+	}
+}
+
+func TestGlobalAdminAuth_RefusesLimitedKeys(t *testing.T) {
+	srv, _ := fakeServer(t, &config.Config{
+		Site: map[string]config.SiteConfig{"x.a.com": {FrontendRoot: "/var/www/x"}},
+	})
+	srv.cfg.AdminKeys = append(srv.cfg.AdminKeys, "sk-team-a")
+	srv.cfg.KeyACL = map[string][]string{"sk-team-a": {"*.a.com"}}
+
+	// Naming a site the key may manage doesn't open host-wide routes
+	routes := []struct{ method, path string }{
+		{"GET", "/system"},
+		{"POST", "/deploy/self"},
+		{"GET", "/self/updates"},
+		{"PUT", "/nginx/snippets/headers"},
+		{"DELETE", "/nginx/snippets/headers"},
+		{"POST", "/jails/update"},
+		{"POST", "/jails/update/resume"},
+		{"GET", "/jails/update"},
+		{"GET", "/jails/templates"},
+		{"POST", "/jails/templates/build"},
+		{"POST", "/bans"},
+		{"POST", "/bans/clear"},
+	}
+	for _, r := range routes {
+		req := httptest.NewRequest(r.method, r.path+"?site=x.a.com", nil)
+		req.Header.Set("X-Shipyard-Key", "sk-team-a")
+		resp, err := srv.app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("%s %s: %v", r.method, r.path, err)
+		}
+		var body map[string]any
+		json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != 403 || body["error"] != "site_not_allowed" {
+			t.Errorf("%s %s with a limited key = %d %v, want 403 site_not_allowed", r.method, r.path, resp.StatusCode, body["error"])
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/self/updates", nil)
+	req.Header.Set("X-Shipyard-Key", "admin")
+	resp, err := srv.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("GET /self/updates: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("GET /self/updates with an unrestricted key = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestSiteCreate_LimitedKeyChecksTheCreatedDomain(t *testing.T) {
+	srv, _ := fakeServer(t, &config.Config{
+		Site: map[string]config.SiteConfig{"x.a.com": {FrontendRoot: "/var/www/x"}},
+	})
+	srv.cfg.AdminKeys = append(srv.cfg.AdminKeys, "sk-team-a")
+	srv.cfg.KeyACL = map[string][]string{"sk-team-a": {"*.a.com"}}
+
+	send := func(contentType, body string) (int, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/site/create?site=x.a.com", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Shipyard-Key", "sk-team-a")
+		resp, err := srv.app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		var result map[string]any
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	// The query names a site the key may manage; the body creates another
+	if status, result := send("application/x-www-form-urlencoded", "domain=prod.b.com"); status != 400 {
+		t.Errorf("urlencoded create = %d %v, want 400", status, result)
+	}
+	if status, result := send("application/json", `{"domain":"prod.b.com"}`); status != 403 {
+		t.Errorf("JSON create of another team's domain = %d %v, want 403", status, result)
+	}
+	if _, ok := srv.cfg.Site["prod.b.com"]; ok {
+		t.Error("prod.b.com was created")
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// tlsConfig builds the API listener's TLS config. With server.client_ca set,
+// client certificates are verified against the bundle when presented;
+// ClientCert decides per route whether one is required.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(s.cfg.Server.TLSCert, s.cfg.Server.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS key pair: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if s.cfg.Server.ClientCA != "" {
+		pem, err := os.ReadFile(s.cfg.Server.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA %s contains no certificates", s.cfg.Server.ClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		// Unauthenticated routes (/health, ACME) must still be reachable without a cert
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// mtlsExempt reports whether a path is reachable without a client certificate.
+// The error catalogue is public documentation; CSP reports come from browsers;
+// the status page is for the public.
+func mtlsExempt(path string) bool {
+	return path == "/health" || path == "/errors" || path == "/csp-report" || path == "/statuspage" || path == "/statuspage.json" ||
+		strings.HasPrefix(path, "/.well-known/acme-challenge/")
+}
+
+// ClientCert requires a verified client certificate on all routes except
+// /health and ACME challenges. The TLS handshake has already verified any
+// presented certificate against server.client_ca.
+func ClientCert() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if mtlsExempt(c.Path()) {
+			return c.Next()
+		}
+
+		state := c.Context().TLSConnectionState()
+		if state == nil || len(state.PeerCertificates) == 0 {
+			return sendError(c, errClientCertRequired, "a client certificate signed by the configured CA is required")
+		}
+
+		reqLog(c).Debug("client certificate accepted", "subject", state.PeerCertificates[0].Subject.CommonName)
+		return c.Next()
+	}
+}
@@ -16,23 +16,17 @@ func (s *Server) NginxExample(c *fiber.Ctx) error {
 	if siteName != "" {
 		site, ok := s.cfg.Site[siteName]
 		if !ok {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"status": "error",
-				"error":  "site_not_found",
-			})
+			return sendError(c, errSiteNotFound, "")
 		}
 
 		// Generate the default config that would be used for this site
 		var buf bytes.Buffer
 		if err := nginxDefaultTmpl.Execute(&buf, nginxDefaultData{
-			ServerName:   siteName,
-			FrontendRoot: site.FrontendRoot,
+			ServerName:         siteName,
+			FrontendRoot:       site.FrontendRoot,
+			FrontendDirectives: nginx.FrontendBlock(site, s.cfg),
 		}); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status": "error",
-				"error":  "template_error",
-				"detail": err.Error(),
-			})
+			return sendError(c, errTemplate, err.Error())
 		}
 
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
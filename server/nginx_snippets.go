@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/nginx"
+)
+
+// Snippets lists the shared nginx snippets
+func (s *Server) Snippets(c *fiber.Ctx) error {
+	snippets, err := s.snippets.List()
+	if err != nil {
+		return sendError(c, errSnippetFailed, err.Error())
+	}
+	return c.JSON(fiber.Map{
+		"status":   "ok",
+		"snippets": snippets,
+	})
+}
+
+// Snippet returns one snippet's content
+func (s *Server) Snippet(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if !nginx.ValidSnippetName(name) {
+		return sendError(c, errInvalidSnippetName, name)
+	}
+
+	content, err := s.snippets.Get(name)
+	if err != nil {
+		if errors.Is(err, nginx.ErrSnippetNotFound) {
+			return sendError(c, errSnippetNotFound, name)
+		}
+		return sendError(c, errSnippetFailed, err.Error())
+	}
+	return c.JSON(fiber.Map{
+		"status":  "ok",
+		"name":    name,
+		"content": content,
+	})
+}
+
+// PutSnippet creates or replaces a snippet from the raw request body.
+// Sites pick up the change on their next deploy.
+func (s *Server) PutSnippet(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if !nginx.ValidSnippetName(name) {
+		return sendError(c, errInvalidSnippetName, name)
+	}
+
+	body := c.Body()
+	if len(body) == 0 {
+		return sendError(c, errEmptyBody, "send the snippet as the raw request body")
+	}
+	if _, err := nginx.ParseDirectives(string(body)); err != nil {
+		return sendError(c, errInvalidSnippet, err.Error())
+	}
+
+	if err := s.snippets.Put(name, string(body)); err != nil {
+		reqLog(c).Error("save snippet failed", "snippet", name, "error", err)
+		return sendError(c, errSnippetFailed, err.Error())
+	}
+
+	reqLog(c).Info("snippet saved", "snippet", name, "size", len(body))
+	return c.JSON(fiber.Map{
+		"status": "saved",
+		"name":   name,
+	})
+}
+
+// DeleteSnippet removes a snippet. Templates that still use it fail to render.
+func (s *Server) DeleteSnippet(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if !nginx.ValidSnippetName(name) {
+		return sendError(c, errInvalidSnippetName, name)
+	}
+
+	if err := s.snippets.Delete(name); err != nil {
+		if errors.Is(err, nginx.ErrSnippetNotFound) {
+			return sendError(c, errSnippetNotFound, name)
+		}
+		return sendError(c, errSnippetFailed, err.Error())
+	}
+
+	reqLog(c).Info("snippet deleted", "snippet", name)
+	return c.JSON(fiber.Map{
+		"status": "deleted",
+		"name":   name,
+	})
+}
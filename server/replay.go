@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// signatureWindow is how far a signed request's timestamp may be from the
+// server's clock. Nonces are remembered for twice as long.
+const signatureWindow = 5 * time.Minute
+
+// Signed request headers
+const (
+	headerTimestamp = "X-Shipyard-Timestamp"
+	headerNonce     = "X-Shipyard-Nonce"
+	headerSignature = "X-Shipyard-Signature"
+)
+
+// noncesPath returns where used nonces are kept, so a restart or handover
+// doesn't accept a replay
+func noncesPath(stateDir string) string {
+	return filepath.Join(stateDir, "nonces.json")
+}
+
+// nonceCache remembers recently used nonces so a signed request is accepted
+// once. With a path they are saved until they expire.
+type nonceCache struct {
+	mu   sync.Mutex
+	path string
+	seen map[string]time.Time // nonce -> when it can be forgotten
+}
+
+// newNonceCache loads the nonces saved at path, if any; an empty path keeps
+// them in memory only
+func newNonceCache(path string) *nonceCache {
+	n := &nonceCache{path: path, seen: make(map[string]time.Time)}
+	if path == "" {
+		return n
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to read used nonces", "path", path, "error", err)
+		}
+		return n
+	}
+	if err := json.Unmarshal(data, &n.seen); err != nil {
+		slog.Warn("failed to parse used nonces", "path", path, "error", err)
+		n.seen = make(map[string]time.Time)
+	}
+	return n
+}
+
+// use records a nonce, returning false if it was already used. A nonce that
+// can't be saved is refused, since it could be replayed after a restart.
+func (n *nonceCache) use(nonce string, now time.Time) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for k, expires := range n.seen {
+		if now.After(expires) {
+			delete(n.seen, k)
+		}
+	}
+	if _, ok := n.seen[nonce]; ok {
+		return false, nil
+	}
+	n.seen[nonce] = now.Add(2 * signatureWindow)
+	if err := n.save(); err != nil {
+		delete(n.seen, nonce)
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes the nonces to disk; the caller holds the lock
+func (n *nonceCache) save() error {
+	if n.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(n.seen)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(n.path), 0755); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	tmp := n.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write used nonces: %w", err)
+	}
+	return os.Rename(tmp, n.path)
+}
+
+// requestSignature computes the hex HMAC-SHA256 a client sends in
+// X-Shipyard-Signature. The message is the timestamp, nonce, method, path and
+// the hex SHA-256 of the body, joined by newlines.
+func requestSignature(key, timestamp, nonce, method, path string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", timestamp, nonce, method, path, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ReplayGuard verifies signed deploy requests. A signed request names its
+// site in the form and is signed with that site's api_key, which it must not
+// send in X-Shipyard-Key; it must fall within signatureWindow and use a fresh
+// nonce. Unsigned requests are refused for sites with require_signature.
+// Runs before SiteAuth, which accepts a verified request for its site.
+func (s *Server) ReplayGuard() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		siteName := formValue(c, s.cfg, "site")
+		site, ok := s.cfg.Site[siteName]
+		signature := c.Get(headerSignature)
+		if signature == "" {
+			if ok && site.RequireSignature && bearerToken(c, s.cfg) == "" {
+				return sendError(c, errSignatureRequired, "")
+			}
+			return c.Next()
+		}
+		if !ok {
+			// SiteAuth reports the missing or unknown site
+			return c.Next()
+		}
+		if c.Get("X-Shipyard-Key") != "" {
+			return sendError(c, errInvalidSignature, "signed requests must not send X-Shipyard-Key")
+		}
+
+		timestamp, nonce := c.Get(headerTimestamp), c.Get(headerNonce)
+		if timestamp == "" || nonce == "" || len(nonce) > 128 {
+			return sendError(c, errInvalidSignature, fmt.Sprintf("%s and %s are required with %s", headerTimestamp, headerNonce, headerSignature))
+		}
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return sendError(c, errInvalidSignature, "timestamp must be unix seconds")
+		}
+		now := time.Now()
+		if skew := now.Sub(time.Unix(unix, 0)); skew > signatureWindow || skew < -signatureWindow {
+			return sendError(c, errInvalidSignature, "timestamp is outside the allowed window")
+		}
+
+		want := requestSignature(site.APIKey, timestamp, nonce, c.Method(), c.Path(), c.Body())
+		if site.APIKey == "" || !hmac.Equal([]byte(signature), []byte(want)) {
+			return sendError(c, errInvalidSignature, "")
+		}
+		fresh, err := s.nonces.use(nonce, now)
+		if err != nil {
+			reqLog(c).Error("failed to save used nonce", "error", err)
+			return sendError(c, errNonceSaveFailed, err.Error())
+		}
+		if !fresh {
+			reqLog(c).Warn("replayed deploy request refused", "nonce", nonce)
+			return sendError(c, errInvalidSignature, "nonce already used")
+		}
+		c.Locals("signed_site", siteName)
+		return c.Next()
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestReplayGuard(t *testing.T) {
+	srv := testServer(&config.Config{
+		Site: map[string]config.SiteConfig{
+			"open.example.com":   {FrontendRoot: "/var/www/open", APIKey: "sk-site-open"},
+			"signed.example.com": {FrontendRoot: "/var/www/signed", APIKey: "sk-site-signed", RequireSignature: true},
+		},
+	})
+
+	app := fiber.New()
+	app.Post("/deploy", srv.ReplayGuard(), SiteAuth(srv.cfg), func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	send := func(query, site, key string, sign func(body []byte) (ts, nonce, sig string)) int {
+		t.Helper()
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("site", site)
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/deploy"+query, bytes.NewReader(body.Bytes()))
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if key != "" {
+			req.Header.Set("X-Shipyard-Key", key)
+		}
+		if sign != nil {
+			ts, nonce, sig := sign(body.Bytes())
+			req.Header.Set(headerTimestamp, ts)
+			req.Header.Set(headerNonce, nonce)
+			req.Header.Set(headerSignature, sig)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		return resp.StatusCode
+	}
+	signer := func(key, nonce string, at time.Time) func([]byte) (string, string, string) {
+		return func(body []byte) (string, string, string) {
+			ts := strconv.FormatInt(at.Unix(), 10)
+			return ts, nonce, requestSignature(key, ts, nonce, "POST", "/deploy", body)
+		}
+	}
+
+	if got := send("", "open.example.com", "sk-site-open", nil); got != 200 {
+		t.Errorf("unsigned request to open site: status = %d, want 200", got)
+	}
+	if got := send("", "signed.example.com", "sk-site-signed", nil); got != 401 {
+		t.Errorf("unsigned request to signed site: status = %d, want 401", got)
+	}
+	if got := send("?site=open.example.com", "signed.example.com", "sk-site-signed", nil); got != 401 {
+		t.Errorf("unsigned request naming an open site in the query: status = %d, want 401", got)
+	}
+
+	now := time.Now()
+	if got := send("", "signed.example.com", "", signer("sk-site-signed", "n1", now)); got != 200 {
+		t.Errorf("signed request: status = %d, want 200", got)
+	}
+	if got := send("", "signed.example.com", "", signer("sk-site-signed", "n1", now)); got != 401 {
+		t.Errorf("replayed nonce: status = %d, want 401", got)
+	}
+	if got := send("", "signed.example.com", "", signer("sk-site-signed", "n2", now.Add(-10*time.Minute))); got != 401 {
+		t.Errorf("stale timestamp: status = %d, want 401", got)
+	}
+	if got := send("", "signed.example.com", "", signer("sk-wrong", "n3", now)); got != 401 {
+		t.Errorf("wrong key: status = %d, want 401", got)
+	}
+	if got := send("", "signed.example.com", "sk-site-signed", signer("sk-site-signed", "n4", now)); got != 401 {
+		t.Errorf("signed request sending the key: status = %d, want 401", got)
+	}
+	// The signature is checked with the form's site's key
+	if got := send("", "open.example.com", "", signer("sk-site-signed", "n5", now)); got != 401 {
+		t.Errorf("signed with another site's key: status = %d, want 401", got)
+	}
+	if got := send("", "open.example.com", "", signer("sk-site-open", "n6", now)); got != 200 {
+		t.Errorf("signed request to open site: status = %d, want 200", got)
+	}
+}
+
+func TestNonceCache_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nonces.json")
+	now := time.Now()
+
+	if fresh, err := newNonceCache(path).use("n1", now); err != nil || !fresh {
+		t.Fatalf("first use = %v, %v; want fresh", fresh, err)
+	}
+	restarted := newNonceCache(path)
+	if fresh, err := restarted.use("n1", now.Add(time.Minute)); err != nil || fresh {
+		t.Errorf("replay after restart = %v, %v; want refused", fresh, err)
+	}
+	// Forgotten once the timestamp window has passed
+	if fresh, err := restarted.use("n1", now.Add(3*signatureWindow)); err != nil || !fresh {
+		t.Errorf("use after expiry = %v, %v; want fresh", fresh, err)
+	}
+}
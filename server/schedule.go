@@ -0,0 +1,469 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lachierussell/shipyard/artifact"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// maxScheduleAhead is how far ahead a deploy can be scheduled
+const maxScheduleAhead = 30 * 24 * time.Hour
+
+// scheduledDeploy is a deploy of a stored artifact that runs later: at
+// RunAt, or once approved for a site with require_approval. The artifact is
+// stored when the deploy is requested.
+type scheduledDeploy struct {
+	ID           string    `json:"id"` // the job's ID
+	Operation    string    `json:"operation"`
+	Site         string    `json:"site"`
+	Kind         string    `json:"kind"` // empty for a promotion of both kinds
+	Commit       string    `json:"commit"`
+	UpdateLatest bool      `json:"update_latest,omitempty"`
+	RunAt        time.Time `json:"run_at"`         // zero to run once approved
+	From         string    `json:"from,omitempty"` // staging site, for promotions
+	// Force runs the deploy even inside a freeze window; set when an admin
+	// scheduled it with force=true
+	Force      bool            `json:"force,omitempty"`
+	Initiator  string          `json:"initiator"`
+	RemoteAddr string          `json:"remote_addr,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"` // of the request that scheduled it
+	Approval   *deployApproval `json:"approval,omitempty"`
+	Created    time.Time       `json:"created"`
+}
+
+// deployApproval is the approval a deploy to a site with require_approval
+// waits for
+type deployApproval struct {
+	Expires    time.Time  `json:"expires"`
+	ApprovedBy string     `json:"approved_by,omitempty"`
+	Approved   *time.Time `json:"approved,omitempty"`
+}
+
+// pending reports whether the deploy is waiting for approval
+func (d scheduledDeploy) pending() bool {
+	return d.Approval != nil && d.Approval.Approved == nil
+}
+
+// due returns when the deploy's timer fires: when its approval expires while
+// pending, else at RunAt
+func (d scheduledDeploy) due() time.Time {
+	if d.pending() {
+		return d.Approval.Expires
+	}
+	return d.RunAt
+}
+
+// scheduledDeploysPath returns where scheduled deploys are kept, so they
+// survive restarts
+func scheduledDeploysPath(stateDir string) string {
+	return filepath.Join(stateDir, "scheduled-deploys.json")
+}
+
+// deploySchedule holds the deploys waiting for their run_at or approval
+type deploySchedule struct {
+	mu      sync.Mutex
+	path    string
+	deploys map[string]scheduledDeploy
+	timers  map[string]*time.Timer
+	fns     map[string]func() // what each timer runs
+	stopped bool
+}
+
+func newDeploySchedule(path string) *deploySchedule {
+	return &deploySchedule{
+		path:    path,
+		deploys: make(map[string]scheduledDeploy),
+		timers:  make(map[string]*time.Timer),
+		fns:     make(map[string]func()),
+	}
+}
+
+// load reads the saved deploys, soonest first
+func (ds *deploySchedule) load() ([]scheduledDeploy, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	data, err := os.ReadFile(ds.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var deploys []scheduledDeploy
+	if err := json.Unmarshal(data, &deploys); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", ds.path, err)
+	}
+	for _, d := range deploys {
+		ds.deploys[d.ID] = d
+	}
+	sort.Slice(deploys, func(a, b int) bool { return deploys[a].RunAt.Before(deploys[b].RunAt) })
+	return deploys, nil
+}
+
+// add saves a deploy
+func (ds *deploySchedule) add(d scheduledDeploy) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.deploys[d.ID] = d
+	if err := ds.save(); err != nil {
+		delete(ds.deploys, d.ID)
+		return err
+	}
+	return nil
+}
+
+// arm runs fn when a deploy is due, or straight away if that has passed
+func (ds *deploySchedule) arm(id string, fn func()) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.fns[id] = fn
+	ds.rearm(id)
+}
+
+// rearm restarts a deploy's timer for when it is now due; the caller holds
+// the lock
+func (ds *deploySchedule) rearm(id string) {
+	d, ok := ds.deploys[id]
+	if !ok || ds.stopped {
+		return
+	}
+	if t, ok := ds.timers[id]; ok {
+		t.Stop()
+	}
+	ds.timers[id] = time.AfterFunc(time.Until(d.due()), ds.fns[id])
+}
+
+// take removes a deploy so it runs or is cancelled at most once. Returns
+// false if it has already been taken.
+func (ds *deploySchedule) take(id string) (scheduledDeploy, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	return ds.remove(id)
+}
+
+// takeDue is take for a deploy's timer: it returns false unless the deploy
+// is due, as approving it may have moved its timer since this one fired
+func (ds *deploySchedule) takeDue(id string, now time.Time) (scheduledDeploy, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if d, ok := ds.deploys[id]; !ok || now.Before(d.due()) {
+		return scheduledDeploy{}, false
+	}
+	return ds.remove(id)
+}
+
+// remove forgets a deploy; the caller holds the lock
+func (ds *deploySchedule) remove(id string) (scheduledDeploy, bool) {
+	d, ok := ds.deploys[id]
+	if !ok {
+		return scheduledDeploy{}, false
+	}
+	if t, ok := ds.timers[id]; ok {
+		t.Stop()
+		delete(ds.timers, id)
+	}
+	delete(ds.deploys, id)
+	delete(ds.fns, id)
+	if err := ds.save(); err != nil {
+		slog.Warn("failed to save scheduled deploys", "error", err)
+	}
+	return d, true
+}
+
+// approve records a deploy's approval and moves its timer to its run_at.
+// The approver must not be who requested the deploy.
+func (ds *deploySchedule) approve(id, approver string, now time.Time) (scheduledDeploy, *APIError, string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	d, ok := ds.deploys[id]
+	switch {
+	case !ok || !d.pending():
+		return d, errNotPendingApproval, ""
+	case !now.Before(d.Approval.Expires):
+		return d, errApprovalExpired, ""
+	case approver == d.Initiator:
+		return d, errSelfApproval, "requested by " + d.Initiator
+	}
+
+	approval := *d.Approval
+	approval.ApprovedBy = approver
+	approval.Approved = &now
+	d.Approval = &approval
+	ds.deploys[id] = d
+	if err := ds.save(); err != nil {
+		slog.Warn("failed to save scheduled deploys", "error", err)
+	}
+	ds.rearm(id)
+	return d, nil, ""
+}
+
+// stop stops every timer for shutdown. The deploys stay saved and are
+// rescheduled when shipyard starts again.
+func (ds *deploySchedule) stop() {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.stopped = true
+	for id, t := range ds.timers {
+		t.Stop()
+		delete(ds.timers, id)
+	}
+}
+
+// save writes the deploys to disk; the caller holds the lock
+func (ds *deploySchedule) save() error {
+	deploys := make([]scheduledDeploy, 0, len(ds.deploys))
+	for _, d := range ds.deploys {
+		deploys = append(deploys, d)
+	}
+	sort.Slice(deploys, func(a, b int) bool { return deploys[a].RunAt.Before(deploys[b].RunAt) })
+	data, err := json.MarshalIndent(deploys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ds.path), 0755); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	tmp := ds.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write scheduled deploys: %w", err)
+	}
+	return os.Rename(tmp, ds.path)
+}
+
+// requestRunAt parses the optional run_at field of a deploy. A deploy with
+// run_at is stored and scheduled instead of run, so it needs artifact storage.
+func (s *Server) requestRunAt(form *multipart.Form) (time.Time, *APIError, string) {
+	values := form.Value["run_at"]
+	if len(values) == 0 || values[0] == "" {
+		return time.Time{}, nil, ""
+	}
+	runAt, err := time.Parse(time.RFC3339, values[0])
+	if err != nil {
+		return time.Time{}, errInvalidRunAt, err.Error()
+	}
+	if until := time.Until(runAt); until <= 0 || until > maxScheduleAhead {
+		return time.Time{}, errInvalidRunAt, ""
+	}
+	if !s.artifacts.Enabled() {
+		return time.Time{}, errAsyncUnavailable, "scheduled deploys need artifact storage (artifacts.keep = -1)"
+	}
+	return runAt, nil, ""
+}
+
+// scheduleDeploy saves d and answers 202 with its job. The job waits in the
+// scheduled state until d.RunAt, after waiting for approval if the site
+// requires it.
+func (s *Server) scheduleDeploy(c *fiber.Ctx, log *slog.Logger, d scheduledDeploy) error {
+	d.ID = uuid.NewString()
+	d.Created = time.Now()
+	d.Force = isAdminRequest(c) && forceRequested(c, s.cfg)
+	d.Initiator = requestInitiator(c, s.cfg, d.Site)
+	d.RemoteAddr = c.IP()
+	d.RequestID = requestID(c)
+	if s.cfg.Site[d.Site].RequireApproval {
+		d.Approval = &deployApproval{Expires: d.Created.Add(s.cfg.Deploy.ApprovalTimeoutDuration())}
+	}
+
+	j := s.scheduledJob(d)
+	s.jobs.schedule(j)
+	if err := s.schedule.add(d); err != nil {
+		s.jobs.cancel(d.ID)
+		log.Error("failed to save scheduled deploy", "error", err)
+		return sendError(c, errScheduleSaveFailed, err.Error())
+	}
+	s.schedule.arm(d.ID, func() { s.runScheduled(j) })
+
+	status := "scheduled"
+	if d.pending() {
+		status = "pending_approval"
+		s.requestApproval(log, d)
+	} else {
+		log.Info("deploy scheduled", "job_id", d.ID, "kind", d.Kind, "run_at", d.RunAt, "force", d.Force)
+	}
+
+	st, _ := s.jobs.get(d.ID)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status": status,
+		"job":    st,
+	})
+}
+
+// scheduledJob creates the job reporting on a scheduled deploy
+func (s *Server) scheduledJob(d scheduledDeploy) *job {
+	j := &job{
+		jobStatus: jobStatus{
+			ID:          d.ID,
+			Kind:        d.Operation,
+			Site:        d.Site,
+			Commit:      d.Commit,
+			State:       jobScheduled,
+			Created:     d.Created,
+			RequestedBy: d.Initiator,
+			RequestID:   d.RequestID,
+		},
+	}
+	if !d.RunAt.IsZero() {
+		runAt := d.RunAt
+		j.RunAt = &runAt
+		j.Events = append(j.Events, jobEvent{Time: d.Created, Message: "deploy scheduled for " + runAt.Format(time.RFC3339)})
+	}
+	if a := d.Approval; a != nil {
+		expires := a.Expires
+		j.ApprovalExpires = &expires
+		j.ApprovedBy = a.ApprovedBy
+		if d.pending() {
+			j.State = jobPendingApproval
+			j.Events = append(j.Events, jobEvent{Time: d.Created, Message: "waiting for approval"})
+		}
+	}
+	log := slog.Default().With("site", d.Site, "commit", d.Commit, "job_id", d.ID)
+	if d.RequestID != "" {
+		log = log.With("request_id", d.RequestID)
+	}
+	j.log = slog.New(jobHandler{Handler: log.Handler(), q: s.jobs, id: d.ID})
+	return j
+}
+
+// resumeSchedule reschedules the deploys saved before a restart. Deploys
+// whose run_at passed while shipyard was down run straight away.
+func (s *Server) resumeSchedule() {
+	deploys, err := s.schedule.load()
+	if err != nil {
+		slog.Error("failed to load scheduled deploys", "error", err)
+		return
+	}
+	for _, d := range deploys {
+		j := s.scheduledJob(d)
+		s.jobs.schedule(j)
+		s.schedule.arm(d.ID, func() { s.runScheduled(j) })
+		slog.Info("deploy rescheduled", "site", d.Site, "commit", d.Commit, "job_id", d.ID, "run_at", d.RunAt, "pending_approval", d.pending())
+	}
+}
+
+// runScheduled queues a scheduled deploy once it is due, or expires it if it
+// was never approved. It is refused if the site is frozen then, unless an
+// admin forced it.
+func (s *Server) runScheduled(j *job) {
+	// Queued jobs count as in-flight, so shutdown waits for them too
+	op := operation{Kind: j.Kind, Site: j.Site, Commit: j.Commit, Started: time.Now()}
+	opID, ok := s.ops.begin(op)
+	if !ok {
+		// Left saved for the next start
+		return
+	}
+	d, ok := s.schedule.takeDue(j.ID, time.Now())
+	if !ok {
+		s.ops.end(opID)
+		return
+	}
+	if d.pending() {
+		s.ops.end(opID)
+		s.expireApproval(j, d)
+		return
+	}
+
+	run, release, apiErr, detail := s.prepareScheduled(j.log, d)
+	if apiErr != nil {
+		s.ops.end(opID)
+		j.log.Warn("scheduled deploy not run", "error", apiErr.Code, "detail", detail)
+		s.jobs.finish(j, errorResult(apiErr, detail))
+		return
+	}
+
+	j.opID = opID
+	j.release = release
+	j.run = run
+	if !s.jobs.enqueue(j) {
+		s.ops.end(opID)
+		release.Close()
+		s.jobs.finish(j, errorResult(errJobQueueFull, ""))
+		return
+	}
+	j.log.Info("job queued", "kind", j.Kind)
+}
+
+// prepareScheduled checks a scheduled deploy can still run and opens its
+// artifacts, returning the job's run function and what it closes after
+func (s *Server) prepareScheduled(log *slog.Logger, d scheduledDeploy) (func(*slog.Logger) deployResult, io.Closer, *APIError, string) {
+	site, ok := s.cfg.Site[d.Site]
+	if !ok {
+		return nil, nil, errSiteNotFound, ""
+	}
+	if w, until, frozen := site.Frozen(time.Now()); frozen {
+		if !d.Force {
+			return nil, nil, errDeployFrozen, freezeDetail(w, until)
+		}
+		log.Warn("deploy freeze overridden", "reason", w.Reason, "until", until)
+	}
+
+	if d.Operation == "deploy_promote_env" {
+		promotions, apiErr, detail := s.openPromotion(d.Site, d.Commit, d.Kind)
+		if apiErr != nil {
+			return nil, nil, apiErr, detail
+		}
+		return func(log *slog.Logger) deployResult {
+			return s.runPromotion(log, d.Site, d.From, d.Commit, promotions, d.UpdateLatest, d.Initiator, d.RemoteAddr, d.RequestID)
+		}, promotions, nil, ""
+	}
+
+	f, meta, err := s.artifacts.Open(d.Site, d.Kind, d.Commit)
+	if err != nil {
+		if errors.Is(err, artifact.ErrNotStored) {
+			return nil, nil, errArtifactNotStored, err.Error()
+		}
+		return nil, nil, errArtifactReadFailed, err.Error()
+	}
+	if err := deploy.CheckQuota(s.cfg, d.Site, meta.Size); err != nil {
+		f.Close()
+		var quotaErr *deploy.QuotaError
+		if errors.As(err, &quotaErr) {
+			return nil, nil, errQuotaExceeded, err.Error()
+		}
+		return nil, nil, errUsageFailed, err.Error()
+	}
+	return func(log *slog.Logger) deployResult {
+		return s.runStored(log, d.Site, d.Kind, d.Commit, f, meta, d.UpdateLatest)
+	}, f, nil, ""
+}
+
+// CancelJob handles DELETE /jobs/:id, cancelling a scheduled deploy or one
+// waiting for approval before it is queued. The site query parameter must
+// name the job's site.
+func (s *Server) CancelJob(c *fiber.Ctx) error {
+	j, ok := s.jobs.get(c.Params("id"))
+	if !ok || j.Site != c.Query("site") {
+		return sendError(c, errJobNotFound, "")
+	}
+	if _, ok := s.schedule.take(j.ID); !ok {
+		return sendError(c, errJobNotScheduled, "job is "+j.State)
+	}
+	s.jobs.cancel(j.ID)
+	reqLog(c).Info("scheduled deploy cancelled", "site", j.Site, "commit", j.Commit, "job_id", j.ID)
+
+	j, _ = s.jobs.get(j.ID)
+	return c.JSON(fiber.Map{
+		"status": "cancelled",
+		"job":    j,
+	})
+}
@@ -1,68 +1,177 @@
 package server
 
 import (
+	"context"
 	"log/slog"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
+	"github.com/lachierussell/shipyard/artifact"
+	"github.com/lachierussell/shipyard/ban"
 	"github.com/lachierussell/shipyard/config"
 	"github.com/lachierussell/shipyard/deploy"
+	"github.com/lachierussell/shipyard/health"
 	"github.com/lachierussell/shipyard/jail"
+	"github.com/lachierussell/shipyard/logger"
+	"github.com/lachierussell/shipyard/logstore"
 	"github.com/lachierussell/shipyard/nginx"
-	"github.com/lachierussell/shipyard/service"
-	"github.com/lachierussell/shipyard/ssl"
+	"github.com/lachierussell/shipyard/notify"
+	"github.com/lachierussell/shipyard/statuspage"
 	"github.com/lachierussell/shipyard/update"
 )
 
-// MaxRequestSize is the maximum allowed request body size (500MB)
-const MaxRequestSize = 500 << 20
-
 // Server manages the HTTP API server
 type Server struct {
 	app              *fiber.App
+	acmeApp          *fiber.App
+	acmeOnce         sync.Once
+	listener         *handoverListener
+	supervisor       net.Conn
 	cfg              *config.Config
 	version          string
 	commit           string
-	nginxMgr         *nginx.Manager
-	jailMgr          *jail.Manager
-	serviceMgr       *service.Manager
-	sslMgr           *ssl.Manager
+	nginxMgr         NginxManager
+	jailMgr          JailManager
+	driver           jail.Driver
+	firewall         deploy.Firewall
+	serviceMgr       ServiceManager
+	sslMgr           SSLManager
 	frontendDeployer *deploy.FrontendDeployer
 	backendDeployer  *deploy.BackendDeployer
 	updater          *update.Updater
+	updateHistory    *update.History
+	artifacts        *artifact.Store
+	snippets         *nginx.SnippetStore
+	promotions       *deploy.PromotionLog
+	audit            *auditLog
 	logHub           *LogHub
+	logs             *logstore.Store
 	shutdownChan     chan struct{}
+	shutdownOnce     sync.Once
+	ops              *opTracker
+	nonces           *nonceCache
+	metrics          *health.MetricsCollector
+	notifier         *notify.Notifier
+	crashes          *health.CrashLog
+	crashCollector   *health.CrashCollector
+	siteHealth       *health.SiteChecker
+	uptime           *health.UptimeLog
+	incidents        *health.IncidentLog
+	statusPage       *statuspage.Publisher
+	verifier         *deploy.Verifier
+	reconciler       *health.Reconciler
+	bans             *ban.Manager
+	jobs             *jobQueue
+	schedule         *deploySchedule
+	cspReports       *cspReportStore
+	readCache        *responseCache
+	baseUpdateMu     sync.Mutex
+	baseUpdate       *deploy.BaseUpdate
+	startupMu        sync.Mutex
+	startup          *startupReport
 }
 
+// defaultShutdownTimeout bounds how long shutdown waits for in-flight deploys
+const defaultShutdownTimeout = 2 * time.Minute
+
 // New creates a new HTTP server with routes configured.
-// logHub may be nil if log streaming is not needed.
-func New(cfg *config.Config, version, commit string, logHub *LogHub) *Server {
+// logHub may be nil if log streaming is not needed, and logs if logs are
+// not kept for searching. mgrs replaces the real nginx, jail, service and SSL
+// managers, e.g. with fakes in tests.
+func New(cfg *config.Config, version, commit string, logHub *LogHub, logs *logstore.Store, mgrs Managers) *Server {
 	app := fiber.New(fiber.Config{
-		Prefork:   false,
-		BodyLimit: MaxRequestSize,
+		Prefork:      false,
+		BodyLimit:    cfg.Server.BodyLimit(),
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
+		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout) * time.Second,
 	})
 
 	// Global middleware
 	app.Use(CORS())
-	app.Use(SizeLimit())
+	app.Use(SizeLimit(cfg.Server.BodyLimit()))
 	app.Use(RequestLogger())
+	if cfg.Server.ClientCA != "" {
+		app.Use(ClientCert())
+	}
+	app.Use(MultipartCleanup())
 
+	mgrs = mgrs.withDefaults(cfg)
 	srv := &Server{
 		app:              app,
 		cfg:              cfg,
 		version:          version,
 		commit:           commit,
-		nginxMgr:         nginx.NewManager(cfg),
-		jailMgr:          jail.NewManager(cfg),
-		serviceMgr:       service.NewManager(cfg),
-		sslMgr:           ssl.NewManager(cfg),
-		frontendDeployer: deploy.NewFrontendDeployer(cfg),
-		backendDeployer:  deploy.NewBackendDeployer(cfg),
+		nginxMgr:         mgrs.Nginx,
+		jailMgr:          mgrs.Jail,
+		driver:           mgrs.Driver,
+		firewall:         mgrs.Firewall,
+		serviceMgr:       mgrs.Service,
+		sslMgr:           mgrs.SSL,
+		frontendDeployer: deploy.NewFrontendDeployer(cfg, mgrs.deploy()),
+		backendDeployer:  deploy.NewBackendDeployer(cfg, mgrs.deploy()),
 		updater:          update.NewUpdater(cfg.Self.BinaryPath),
+		updateHistory:    update.NewHistory(update.HistoryPath(cfg.StateDir())),
+		artifacts:        artifact.NewStore(filepath.Join(cfg.StateDir(), "artifacts"), cfg.Artifacts.KeepCount()),
+		snippets:         nginx.NewSnippetStore(cfg.SnippetsDir()),
+		promotions:       deploy.NewPromotionLog(deploy.PromotionsPath(cfg.StateDir())),
+		audit:            newAuditLog(auditPath(cfg.StateDir())),
 		logHub:           logHub,
+		logs:             logs,
 		shutdownChan:     make(chan struct{}),
+		ops:              newOpTracker(),
+		nonces:           newNonceCache(noncesPath(cfg.StateDir())),
+		jobs:             newJobQueue(),
+		schedule:         newDeploySchedule(scheduledDeploysPath(cfg.StateDir())),
+		cspReports:       newCSPReportStore(cspReportsPath(cfg.StateDir())),
+		readCache:        newResponseCache(),
+		metrics:          health.NewMetricsCollector(cfg),
+		notifier:         notify.New(cfg),
+		crashes:          health.NewCrashLog(cfg.StateDir()),
+		uptime:           health.NewUptimeLog(health.UptimePath(cfg.StateDir())),
 	}
+	srv.incidents = health.NewIncidentLog(health.IncidentsPath(cfg.StateDir()), cfg.Health.FailureLimit(), func(site string) int {
+		return srv.reconciler.Repairs(site)
+	}, srv.notifier)
+	srv.siteHealth = health.NewSiteChecker(cfg, srv.uptime, srv.incidents)
+	srv.statusPage = statuspage.NewPublisher(cfg, srv.siteHealth, srv.uptime)
+	srv.crashCollector = health.NewCrashCollector(cfg, srv.crashes, srv.notifier)
+	srv.verifier = deploy.NewVerifier(cfg, srv.notifier)
+	srv.reconciler = health.NewReconciler(cfg, func(site string) bool {
+		return deploy.BackendDeployInProgress(cfg, site)
+	})
+	srv.bans = ban.NewManager(cfg, func() error { return srv.nginxMgr.Reload(context.Background()) })
 	srv.setupRoutes()
+	srv.startJobWorkers(cfg.Deploy.WorkerCount())
+	// After a self-update the previous process may still be finishing
+	// deploys, so operations wait until it has drained
+	srv.supervisor = supervisorConn()
+	if done := awaitPredecessor(); done != nil {
+		srv.ops.hold()
+		go srv.followPredecessor(done, mgrs.deploy())
+	} else {
+		srv.resumeSchedule()
+	}
+	srv.metrics.Start()
+	srv.crashCollector.Start()
+	srv.siteHealth.Start()
+	srv.statusPage.Start()
+	srv.verifier.Start()
+	srv.reconciler.Start()
+	srv.bans.Start()
+	if logs != nil {
+		logs.FollowAppLogs(srv.backendLogPaths())
+	}
+
+	// Standalone challenge listener (plain HTTP, no middleware)
+	if cfg.SSL.ACMEListenAddr != "" {
+		srv.acmeApp = fiber.New(fiber.Config{DisableStartupMessage: true})
+		srv.acmeApp.Get("/.well-known/acme-challenge/:token", srv.ACMEChallenge)
+	}
 
 	return srv
 }
@@ -71,24 +180,84 @@ func New(cfg *config.Config, version, commit string, logHub *LogHub) *Server {
 func (s *Server) setupRoutes() {
 	// Health checks (no auth)
 	s.app.Get("/health", s.Health)
-	s.app.Get("/status/:site", s.Status)
+	s.app.Get("/status/:site", s.CachedRead("no-cache", statusCacheKey), s.Status)
+	s.app.Get("/errors", s.Errors)
+	s.app.Get("/statuspage", s.StatusPage)
+	s.app.Get("/statuspage.json", s.StatusPageJSON)
+	s.app.Get("/auth/config", s.AuthConfig)
+
+	// ACME HTTP-01 challenges (no auth)
+	s.app.Get("/.well-known/acme-challenge/:token", s.ACMEChallenge)
+
+	// CSP violation reports from browsers, forwarded by nginx (no auth)
+	s.app.Post("/csp-report", s.CSPReport)
+
+	// Host status (unrestricted admin auth)
+	s.app.Get("/system", GlobalAdminAuth(s.cfg), s.System)
+	s.app.Get("/metrics", AdminListAuth(s.cfg), s.Metrics)
 
 	// Site lifecycle (admin auth)
-	s.app.Get("/sites", AdminAuth(s.cfg), s.ListSites)
-	s.app.Post("/site/create", AdminAuth(s.cfg), s.SiteCreate)
-	s.app.Post("/site/init", AdminAuth(s.cfg), s.SiteInit)
-	s.app.Post("/site/destroy", AdminAuth(s.cfg), s.SiteDestroy)
+	s.app.Get("/sites", AdminListAuth(s.cfg), s.CachedRead("private, no-cache", sitesCacheKey), s.ListSites)
+	s.app.Post("/site/create", AdminAuth(s.cfg), s.TrackOperation("site_create"), s.SiteCreate)
+	s.app.Post("/site/init", AdminAuth(s.cfg), s.TrackOperation("site_init"), s.SiteInit)
+	s.app.Post("/site/destroy", AdminAuth(s.cfg), s.TrackOperation("site_destroy"), s.SiteDestroy)
+	s.app.Post("/apply", AdminListAuth(s.cfg), s.TrackOperation("apply"), s.Apply)
+	s.app.Post("/sites/bulk", AdminListAuth(s.cfg), s.TrackOperation("bulk"), s.SitesBulk)
+
+	// Jail base updates (unrestricted admin auth)
+	s.app.Post("/jails/update", GlobalAdminAuth(s.cfg), s.StartBaseUpdate)
+	s.app.Post("/jails/update/resume", GlobalAdminAuth(s.cfg), s.ResumeBaseUpdate)
+	s.app.Get("/jails/update", GlobalAdminAuth(s.cfg), s.BaseUpdateStatus)
+	s.app.Get("/jails/templates", GlobalAdminAuth(s.cfg), s.JailTemplates)
+	s.app.Post("/jails/templates/build", GlobalAdminAuth(s.cfg), s.TrackOperation("template_build"), s.BuildJailTemplate)
 
 	// Site info (admin auth)
 	s.app.Get("/site/logs", AdminAuth(s.cfg), s.SiteLogs)
+	s.app.Get("/site/crashes", AdminAuth(s.cfg), s.SiteCrashes)
+	s.app.Get("/site/usage", AdminAuth(s.cfg), s.SiteUsage)
+	s.app.Get("/site/assets", AdminAuth(s.cfg), s.SiteAssets)
+	s.app.Get("/site/csp-reports", AdminAuth(s.cfg), s.SiteCSPReports)
+	s.app.Get("/site/verify", AdminAuth(s.cfg), s.SiteVerify)
+	s.app.Get("/audit", AdminAuth(s.cfg), s.Audit)
+	s.app.Get("/logs/search", AdminListAuth(s.cfg), s.SearchLogs)
+	s.app.Get("/bans", AdminListAuth(s.cfg), s.Bans)
+	s.app.Get("/incidents", AdminListAuth(s.cfg), s.Incidents)
+	s.app.Post("/bans", GlobalAdminAuth(s.cfg), s.AddBan)
+	s.app.Post("/bans/clear", GlobalAdminAuth(s.cfg), s.ClearBans)
+
+	// Site assets and backend control (per-site auth)
+	s.app.Post("/site/assets", SiteAuth(s.cfg), s.SiteAssetUpload)
+	s.app.Post("/site/assets/delete", SiteAuth(s.cfg), s.SiteAssetDelete)
+	s.app.Post("/site/backend/:action", SiteAuth(s.cfg), s.TrackOperation("backend_action"), s.SiteBackendAction)
 
 	// Nginx config helpers (admin auth)
 	s.app.Get("/nginx/example", AdminAuth(s.cfg), s.NginxExample)
+	s.app.Get("/nginx/snippets", AdminAuth(s.cfg), s.Snippets)
+	s.app.Get("/nginx/snippets/:name", AdminAuth(s.cfg), s.Snippet)
+	s.app.Put("/nginx/snippets/:name", GlobalAdminAuth(s.cfg), s.PutSnippet)
+	s.app.Delete("/nginx/snippets/:name", GlobalAdminAuth(s.cfg), s.DeleteSnippet)
 
 	// Deploy endpoints (per-site auth)
-	s.app.Post("/deploy/frontend", SiteAuth(s.cfg), s.DeployFrontend)
-	s.app.Post("/deploy/backend", SiteAuth(s.cfg), s.DeployBackend)
-	s.app.Post("/deploy/self", AdminAuth(s.cfg), s.DeploySelf)
+	s.app.Post("/deploy/frontend", s.ReplayGuard(), SiteAuth(s.cfg), s.FreezeGuard(), s.TrackOperation("deploy_frontend"), s.DeployFrontend)
+	s.app.Post("/deploy/frontend/promote", s.ReplayGuard(), SiteAuth(s.cfg), s.FreezeGuard(), s.TrackOperation("deploy_promote"), s.PromoteFrontend)
+	s.app.Get("/deploy/frontend/promotions", AdminAuth(s.cfg), s.CachedRead("private, no-cache", promotionsCacheKey), s.Promotions)
+	s.app.Post("/deploy/frontend/canary", s.ReplayGuard(), SiteAuth(s.cfg), s.FreezeGuard(), s.TrackOperation("deploy_canary"), s.Canary)
+	s.app.Post("/deploy/frontend/canary/finalize", s.ReplayGuard(), SiteAuth(s.cfg), s.FreezeGuard(), s.TrackOperation("deploy_canary"), s.CanaryFinalize)
+	s.app.Post("/deploy/frontend/canary/abort", s.ReplayGuard(), SiteAuth(s.cfg), s.TrackOperation("deploy_canary"), s.CanaryAbort)
+	s.app.Get("/deploy/frontend/canary", AdminAuth(s.cfg), s.CanaryStatus)
+	s.app.Post("/deploy/frontend/experiment", s.ReplayGuard(), SiteAuth(s.cfg), s.FreezeGuard(), s.TrackOperation("deploy_experiment"), s.Experiment)
+	s.app.Post("/deploy/frontend/experiment/weights", s.ReplayGuard(), SiteAuth(s.cfg), s.FreezeGuard(), s.TrackOperation("deploy_experiment"), s.ExperimentWeights)
+	s.app.Post("/deploy/frontend/experiment/stop", s.ReplayGuard(), SiteAuth(s.cfg), s.TrackOperation("deploy_experiment"), s.ExperimentStop)
+	s.app.Get("/deploy/frontend/experiment", AdminAuth(s.cfg), s.ExperimentStatus)
+	s.app.Post("/deploy/backend", s.ReplayGuard(), SiteAuth(s.cfg), s.FreezeGuard(), s.TrackOperation("deploy_backend"), s.DeployBackend)
+	s.app.Post("/deploy/redeploy", s.ReplayGuard(), SiteAuth(s.cfg), s.FreezeGuard(), s.TrackOperation("deploy_redeploy"), s.Redeploy)
+	s.app.Post("/deploy/promote-env", s.ReplayGuard(), SiteAuth(s.cfg), s.FreezeGuard(), s.TrackOperation("deploy_promote_env"), s.PromoteEnv)
+	s.app.Post("/deploy/self", GlobalAdminAuth(s.cfg), s.DeploySelf)
+	s.app.Get("/self/updates", GlobalAdminAuth(s.cfg), s.SelfUpdates)
+	s.app.Get("/jobs", AdminListAuth(s.cfg), s.ListJobs)
+	s.app.Get("/jobs/:id", SiteQueryAuth(s.cfg), s.Job)
+	s.app.Delete("/jobs/:id", SiteQueryAuth(s.cfg), s.CancelJob)
+	s.app.Post("/deploys/:id/approve", AdminAuth(s.cfg), s.ApproveDeploy)
 
 	// WebSocket log streaming (admin auth via query param)
 	if s.logHub != nil {
@@ -97,21 +266,46 @@ func (s *Server) setupRoutes() {
 	}
 }
 
-// Listen starts the HTTP server
-func (s *Server) Listen(addr string) error {
-	// Use TLS if cert and key are configured
-	if s.cfg.Server.TLSCert != "" && s.cfg.Server.TLSKey != "" {
-		return s.app.ListenTLS(addr, s.cfg.Server.TLSCert, s.cfg.Server.TLSKey)
-	}
-	return s.app.Listen(addr)
-}
-
-// Shutdown gracefully stops the server
+// Shutdown gracefully stops the server. New deploys are refused while running
+// ones are given up to server.shutdown_timeout to finish.
 func (s *Server) Shutdown() error {
+	s.schedule.stop()
+	left := s.drainOperations()
+
+	if s.cspReports != nil {
+		s.cspReports.flush()
+	}
+	if s.metrics != nil {
+		s.metrics.Stop()
+	}
+	if s.crashCollector != nil {
+		s.crashCollector.Stop()
+	}
+	if s.siteHealth != nil {
+		s.siteHealth.Stop()
+	}
+	if s.statusPage != nil {
+		s.statusPage.Stop()
+	}
+	if s.verifier != nil {
+		s.verifier.Stop()
+	}
+	if s.reconciler != nil {
+		s.reconciler.Stop()
+	}
+	if s.bans != nil {
+		s.bans.Stop()
+	}
 	if s.logHub != nil {
 		s.logHub.Stop()
 	}
-	return s.app.Shutdown()
+	if s.logs != nil {
+		s.logs.Stop()
+	}
+	s.stopACME()
+	// Requests other than the abandoned operations get what is left of
+	// shutdown_timeout, then their connections are closed
+	return s.app.ShutdownWithTimeout(left)
 }
 
 // ShutdownChan returns the channel used to signal shutdown for self-update
@@ -119,9 +313,10 @@ func (s *Server) ShutdownChan() <-chan struct{} {
 	return s.shutdownChan
 }
 
-// TriggerShutdown signals the server to shutdown (used after self-update)
+// TriggerShutdown signals the server to shutdown (used after self-update or rollback).
+// Safe to call more than once.
 func (s *Server) TriggerShutdown() {
-	close(s.shutdownChan)
+	s.shutdownOnce.Do(func() { close(s.shutdownChan) })
 }
 
 // reqLog returns the request-scoped logger stored by the RequestLogger middleware.
@@ -133,3 +328,9 @@ func reqLog(c *fiber.Ctx) *slog.Logger {
 	return slog.Default()
 }
 
+// logContext returns a context carrying log, for packages that log through
+// logger.FromContext
+func logContext(log *slog.Logger) context.Context {
+	return logger.NewContext(context.Background(), log)
+}
+
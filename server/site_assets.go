@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// SiteAssetUpload handles POST /site/assets, storing one file in the site's
+// assets directory. The path field (e.g. /.well-known/security.txt) defaults
+// to the uploaded file's name.
+func (s *Server) SiteAssetUpload(c *fiber.Ctx) error {
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
+	}
+
+	siteName := form.Value["site"][0]
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	if site.IsBackendOnly() {
+		return sendError(c, errBackendOnlySite, "assets are served alongside the frontend")
+	}
+
+	files := form.File["file"]
+	if len(files) == 0 {
+		return sendError(c, errMissingAsset, "")
+	}
+	assetPath := files[0].Filename
+	if paths := form.Value["path"]; len(paths) > 0 && paths[0] != "" {
+		assetPath = paths[0]
+	}
+
+	// Refuse uploads that would take the site over its disk quota
+	if err := deploy.CheckQuota(s.cfg, siteName, files[0].Size); err != nil {
+		var quotaErr *deploy.QuotaError
+		if errors.As(err, &quotaErr) {
+			return sendError(c, errQuotaExceeded, err.Error())
+		}
+		return sendError(c, errUsageFailed, err.Error())
+	}
+
+	src, err := files[0].Open()
+	if err != nil {
+		return sendError(c, errArtifactReadFailed, "")
+	}
+	defer src.Close()
+
+	size, err := deploy.PutAsset(site, assetPath, src)
+	if err != nil {
+		if errors.Is(err, deploy.ErrInvalidAssetPath) {
+			return sendError(c, errInvalidAssetPath, err.Error())
+		}
+		reqLog(c).Error("asset upload failed", "site", siteName, "path", assetPath, "error", err)
+		return sendError(c, errAssetWriteFailed, err.Error())
+	}
+
+	reqLog(c).Info("asset uploaded", "site", siteName, "path", assetPath, "size", size)
+	return c.JSON(fiber.Map{
+		"status": "uploaded",
+		"site":   siteName,
+		"path":   "/" + strings.TrimLeft(assetPath, "/"),
+		"size":   size,
+	})
+}
+
+// SiteAssetDelete handles POST /site/assets/delete
+func (s *Server) SiteAssetDelete(c *fiber.Ctx) error {
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
+	}
+
+	siteName := form.Value["site"][0]
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+
+	paths := form.Value["path"]
+	if len(paths) == 0 || paths[0] == "" {
+		return sendError(c, errInvalidAssetPath, "path is required")
+	}
+
+	if err := deploy.DeleteAsset(site, paths[0]); err != nil {
+		switch {
+		case errors.Is(err, deploy.ErrInvalidAssetPath):
+			return sendError(c, errInvalidAssetPath, err.Error())
+		case errors.Is(err, deploy.ErrAssetNotFound):
+			return sendError(c, errAssetNotFound, err.Error())
+		}
+		return sendError(c, errAssetWriteFailed, err.Error())
+	}
+
+	reqLog(c).Info("asset deleted", "site", siteName, "path", paths[0])
+	return c.JSON(fiber.Map{
+		"status": "deleted",
+		"site":   siteName,
+		"path":   "/" + strings.TrimLeft(paths[0], "/"),
+	})
+}
+
+// SiteAssets lists a site's uploaded assets
+func (s *Server) SiteAssets(c *fiber.Ctx) error {
+	siteName := c.Query("site")
+	if siteName == "" {
+		return sendError(c, errMissingSite, "")
+	}
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+
+	assets, err := deploy.ListAssets(site)
+	if err != nil {
+		return sendError(c, errAssetWriteFailed, err.Error())
+	}
+	return c.JSON(fiber.Map{
+		"status": "ok",
+		"site":   siteName,
+		"assets": assets,
+	})
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/deploy"
+)
+
+// backendActions are the actions of POST /site/backend/:action
+var backendActions = []string{"restart", "stop", "start"}
+
+// SiteBackendAction handles POST /site/backend/restart, /stop and /start,
+// which bounce a site's backend without redeploying it
+func (s *Server) SiteBackendAction(c *fiber.Ctx) error {
+	action := c.Params("action")
+	if !slices.Contains(backendActions, action) {
+		return sendError(c, errInvalidBackendAction, action)
+	}
+
+	form, err := requestForm(c, s.cfg)
+	if err != nil {
+		return sendError(c, errInvalidRequest, "failed to parse multipart form")
+	}
+	siteName := form.Value["site"][0]
+	site, ok := s.cfg.Site[siteName]
+	if !ok {
+		return sendError(c, errSiteNotFound, "")
+	}
+	if site.Backend == nil {
+		return sendError(c, errSiteHasNoBackend, "")
+	}
+	if deploy.BackendDeployInProgress(s.cfg, siteName) {
+		return sendError(c, errBackendDeploying, "")
+	}
+
+	log := reqLog(c).With("site", siteName, "action", action)
+	err = s.backendAction(logContext(log), siteName, action)
+
+	entry := auditEntry{
+		Site:       siteName,
+		Action:     "backend_" + action,
+		Initiator:  requestInitiator(c, s.cfg, siteName),
+		RemoteAddr: c.IP(),
+		RequestID:  requestID(c),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if aerr := s.audit.append(entry); aerr != nil {
+		log.Warn("failed to record backend action", "error", aerr)
+	}
+
+	if err != nil {
+		log.Error("backend action failed", "error", err)
+		return sendError(c, errBackendActionFailed, err.Error())
+	}
+	running, _ := s.serviceMgr.Status(siteName)
+	log.Info("backend action done", "initiator", entry.Initiator, "running", running)
+	return c.JSON(fiber.Map{
+		"status":  "ok",
+		"site":    siteName,
+		"action":  action,
+		"running": running,
+	})
+}
+
+// backendAction carries out a backend action. A stopped backend's service is
+// disabled too, so neither the reconciler nor a reboot starts it again until
+// it is started or deployed.
+func (s *Server) backendAction(ctx context.Context, siteName, action string) error {
+	if action == "stop" {
+		if err := s.serviceMgr.Stop(siteName); err != nil {
+			return fmt.Errorf("stop service: %w", err)
+		}
+		if err := s.serviceMgr.Disable(siteName); err != nil {
+			return fmt.Errorf("disable service: %w", err)
+		}
+		return nil
+	}
+
+	if err := s.serviceMgr.Enable(siteName); err != nil {
+		return fmt.Errorf("enable service: %w", err)
+	}
+	if action == "restart" {
+		if err := s.serviceMgr.Stop(siteName); err != nil {
+			return fmt.Errorf("stop service: %w", err)
+		}
+	}
+	if err := s.driver.Start(ctx, siteName); err != nil {
+		return fmt.Errorf("start jail: %w", err)
+	}
+	if err := s.serviceMgr.Start(siteName); err != nil {
+		return fmt.Errorf("start service: %w", err)
+	}
+	return nil
+}
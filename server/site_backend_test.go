@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestSiteBackendAction_Rejects(t *testing.T) {
+	srv := testServer(&config.Config{
+		Self: config.SelfConfig{StateDir: t.TempDir()},
+		Site: map[string]config.SiteConfig{
+			"example.com":     {FrontendRoot: "/var/www/example"},
+			"api.example.com": {Backend: &config.BackendConfig{BinaryName: "api", ListenPort: 8080}},
+		},
+	})
+	srv.audit = newAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+
+	app := fiber.New()
+	app.Post("/site/backend/:action", srv.SiteBackendAction)
+
+	tests := []struct {
+		name   string
+		action string
+		site   string
+		want   string
+	}{
+		{"unknown action", "reload", "api.example.com", "invalid_backend_action"},
+		{"unknown site", "restart", "missing.example.com", "site_not_found"},
+		{"no backend", "restart", "example.com", "site_has_no_backend"},
+	}
+	for _, tt := range tests {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("site", tt.site)
+		writer.Close()
+		req := httptest.NewRequest("POST", "/site/backend/"+tt.action, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.name, err)
+		}
+		var result map[string]any
+		json.NewDecoder(resp.Body).Decode(&result)
+		if result["error"] != tt.want {
+			t.Errorf("%s: error = %v, want %s", tt.name, result["error"], tt.want)
+		}
+	}
+
+	if entries, _ := srv.audit.list(""); len(entries) != 0 {
+		t.Errorf("rejected requests were audited: %+v", entries)
+	}
+}
+
+func TestAudit_ListsNewestFirst(t *testing.T) {
+	srv := testServer(&config.Config{})
+	srv.audit = newAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	srv.audit.append(auditEntry{Site: "a.com", Action: "backend_stop", Initiator: "site:a.com"})
+	srv.audit.append(auditEntry{Site: "b.com", Action: "backend_restart", Initiator: "key:1234"})
+	srv.audit.append(auditEntry{Site: "a.com", Action: "backend_start", Initiator: "site:a.com"})
+
+	app := fiber.New()
+	app.Get("/audit", srv.Audit)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/audit?site=a.com", nil))
+	if err != nil {
+		t.Fatalf("Test request failed: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	var result struct {
+		Entries []auditEntry `json:"entries"`
+	}
+	json.Unmarshal(data, &result)
+
+	if len(result.Entries) != 2 {
+		t.Fatalf("got %d entries, want 2: %s", len(result.Entries), data)
+	}
+	if result.Entries[0].Action != "backend_start" || result.Entries[0].Time.IsZero() {
+		t.Errorf("first entry = %+v, want the newest, timestamped", result.Entries[0])
+	}
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"log/slog"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/lachierussell/shipyard/config"
@@ -10,7 +12,7 @@ import (
 	"github.com/lachierussell/shipyard/ssl"
 )
 
-var validDomain = regexp.MustCompile(`^[a-z0-9][a-z0-9\.\-]*[a-z0-9]$`)
+var validDomain = regexp.MustCompile(`^(\*\.)?[a-z0-9][a-z0-9\.\-]*[a-z0-9]$`)
 
 // SiteCreateRequest is the JSON body for creating a new site
 type SiteCreateRequest struct {
@@ -20,165 +22,243 @@ type SiteCreateRequest struct {
 	WithBackend  bool   `json:"with_backend"`
 	BackendPort  int    `json:"backend_port,omitempty"`
 	ProxyPath    string `json:"proxy_path,omitempty"`
+	Protocol     string `json:"protocol,omitempty"`
+	WebSocket    bool   `json:"websocket,omitempty"`
+	// ACMEEmail is the ACME account contact for the site's certificate,
+	// overriding ssl.acme_email
+	ACMEEmail string `json:"acme_email,omitempty"`
+	// IgnoreDNS requests the certificate even when the domain doesn't resolve
+	// to this host, e.g. behind a CDN that proxies to it
+	IgnoreDNS bool `json:"ignore_dns,omitempty"`
+	// Tags, Owner and Description organise the site in GET /sites and
+	// notifications
+	Tags        []string `json:"tags,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	Description string   `json:"description,omitempty"`
 }
 
 // SiteCreate creates a new site configuration and generates an API key
 func (s *Server) SiteCreate(c *fiber.Ctx) error {
 	log := reqLog(c)
 
+	// AdminAuth checked a limited key against the JSON domain, so don't take
+	// the domain from a form body
+	if !c.Is("json") {
+		return sendError(c, errInvalidRequest, "the body must be application/json")
+	}
 	var req SiteCreateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "invalid_request",
-			"detail": "failed to parse JSON body",
-		})
+		return sendError(c, errInvalidRequest, "failed to parse JSON body")
+	}
+	if !requestAllowsSite(c, req.Domain) {
+		return sendError(c, errSiteNotAllowed, req.Domain)
 	}
 
-	// Validate required fields
-	if req.Domain == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "missing_domain",
-		})
+	if apiErr, detail := s.validateNewSite(req.Domain, req.WithBackend, req.SSLEnabled, req.Protocol); apiErr != nil {
+		return sendError(c, apiErr, detail)
 	}
-	if !validDomain.MatchString(req.Domain) {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "invalid_domain",
-			"detail": "domain must be lowercase alphanumeric with dots and hyphens",
-		})
+	if req.ACMEEmail != "" && !strings.Contains(req.ACMEEmail, "@") {
+		return sendError(c, errInvalidRequest, "acme_email is not an email address")
 	}
 
 	// Check if site already exists
 	if _, exists := s.cfg.Site[req.Domain]; exists {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-			"status": "error",
-			"error":  "site_exists",
-		})
+		return sendError(c, errSiteExists, "")
 	}
 
-	// Generate API key
-	apiKey, err := config.GenerateAPIKey("sk-site-")
+	var backend *config.BackendConfig
+	if req.WithBackend {
+		backend = &config.BackendConfig{
+			ListenPort: req.BackendPort,
+			ProxyPath:  req.ProxyPath,
+			Protocol:   req.Protocol,
+			WebSocket:  req.WebSocket,
+		}
+	}
+	site, err := s.newSiteConfig(req.Domain, req.FrontendRoot, req.SSLEnabled, backend)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error",
-			"error":  "key_generation_failed",
-		})
+		return sendError(c, errKeyGeneration, "")
 	}
-
-	// Set defaults for frontend root
-	// Backend-only: with_backend=true and no frontend_root means no frontend
-	frontendRoot := req.FrontendRoot
-	backendOnly := req.WithBackend && frontendRoot == ""
-	if !backendOnly && frontendRoot == "" {
-		frontendRoot = filepath.Join("/var/www", req.Domain)
+	site.ACMEEmail = req.ACMEEmail
+	site.Tags, site.Owner, site.Description = req.Tags, req.Owner, req.Description
+	if err := site.ValidateMetadata(); err != nil {
+		return sendError(c, errInvalidRequest, err.Error())
 	}
+	backendOnly := site.IsBackendOnly()
 
 	log = log.With("domain", req.Domain, "ssl", req.SSLEnabled, "with_backend", req.WithBackend, "backend_only", backendOnly)
 	log.Info("site creation started")
 
+	// A certificate can only be issued once the domain points here; until
+	// then the site is created without SSL rather than failing in certbot.
+	// Wildcards use DNS-01 and don't need it.
+	var dns *ssl.DNSCheck
+	sslStatus := ""
+	if site.SSLEnabled {
+		sslStatus = "issued"
+	}
+	if !req.IgnoreDNS && !config.IsWildcardDomain(req.Domain) {
+		check := s.sslMgr.CheckDNS(req.Domain)
+		if !check.PointsHere {
+			dns = &check
+			log.Warn("domain does not point at this host", "resolved", check.Resolved, "host_ips", check.HostIPs, "error", check.Error)
+			if site.SSLEnabled {
+				site.SSLEnabled = false
+				sslStatus = "dns_not_pointing_here"
+			}
+		}
+	}
+
+	nginxDeployed, apiErr, detail := s.provisionSite(log, req.Domain, site)
+	if apiErr != nil {
+		return sendError(c, apiErr, detail)
+	}
+
+	log.Info("site created", "nginx_deployed", nginxDeployed, "ssl_status", sslStatus)
+	resp := fiber.Map{
+		"status":         "created",
+		"domain":         req.Domain,
+		"api_key":        site.APIKey,
+		"frontend_root":  site.FrontendRoot,
+		"ssl_enabled":    site.SSLEnabled,
+		"has_backend":    req.WithBackend,
+		"backend_only":   backendOnly,
+		"nginx_deployed": nginxDeployed,
+	}
+	if sslStatus != "" {
+		resp["ssl_status"] = sslStatus
+	}
+	if dns != nil {
+		resp["dns"] = dns
+		resp["warnings"] = []string{dns.Message()}
+	}
+	return c.Status(fiber.StatusCreated).JSON(resp)
+}
+
+// validateNewSite checks the domain and options for a site about to be created
+func (s *Server) validateNewSite(domain string, withBackend, sslEnabled bool, protocol string) (*APIError, string) {
+	if domain == "" {
+		return errMissingDomain, ""
+	}
+	if !validDomain.MatchString(domain) {
+		return errInvalidDomain, "domain must be lowercase alphanumeric with dots and hyphens"
+	}
+
+	wildcard := config.IsWildcardDomain(domain)
+	if wildcard && withBackend {
+		return errInvalidRequest, "wildcard sites cannot have a backend"
+	}
+	if wildcard && sslEnabled && s.cfg.SSL.DNSPlugin == "" {
+		return errInvalidRequest, "wildcard certificates need ssl.dns_plugin for DNS-01 challenges"
+	}
+
+	switch protocol {
+	case "", config.ProtocolHTTP, config.ProtocolGRPC, config.ProtocolFastCGI, config.ProtocolUWSGI:
+	default:
+		return errInvalidRequest, "protocol must be one of http, grpc, fastcgi, uwsgi"
+	}
+	return nil, ""
+}
+
+// newSiteConfig returns the config for a new site with a fresh API key. The
+// frontend root defaults to /var/www/<domain> unless the site is backend-only
+// (a backend and no frontend_root), and the backend gets its own jail.
+func (s *Server) newSiteConfig(domain, frontendRoot string, sslEnabled bool, backend *config.BackendConfig) (config.SiteConfig, error) {
+	apiKey, err := config.GenerateAPIKey("sk-site-")
+	if err != nil {
+		return config.SiteConfig{}, err
+	}
+
+	backendOnly := backend != nil && frontendRoot == ""
+	if !backendOnly && frontendRoot == "" {
+		frontendRoot = filepath.Join("/var/www", strings.Replace(domain, "*", "wildcard", 1))
+	}
+
 	site := config.SiteConfig{
 		FrontendRoot: frontendRoot,
 		APIKey:       apiKey,
-		SSLEnabled:   req.SSLEnabled,
+		SSLEnabled:   sslEnabled,
 	}
 
-	// Add backend config if requested
-	if req.WithBackend {
-		port := req.BackendPort
-		if port == 0 {
-			port = 8080
+	if backend != nil {
+		b := *backend
+		if b.ListenPort == 0 {
+			b.ListenPort = 8080
 		}
-		proxyPath := req.ProxyPath
-		if proxyPath == "" {
-			proxyPath = "/api"
-		}
-
-		site.Backend = &config.BackendConfig{
-			JailName:   req.Domain,
-			JailIP:     s.cfg.NextJailIP(),
-			ListenPort: port,
-			ProxyPath:  proxyPath,
-			BinaryName: req.Domain,
+		if b.ProxyPath == "" {
+			b.ProxyPath = "/api"
 		}
+		b.JailName = domain
+		b.JailIP = s.cfg.NextJailIP()
+		b.BinaryName = domain
+		site.Backend = &b
 	}
+	return site, nil
+}
 
+// provisionSite obtains the site's certificate, adds it to the config and,
+// for sites with a backend, deploys a generated nginx config
+func (s *Server) provisionSite(log *slog.Logger, domain string, site config.SiteConfig) (bool, *APIError, string) {
 	// Generate SSL certificate BEFORE saving config
 	// This ensures we don't end up with a site that has ssl_enabled but no cert
-	if req.SSLEnabled {
-		// Step 1: Deploy temporary HTTP-only nginx config for ACME challenge
-		if err := s.nginxMgr.DeployHTTPOnlyConfig(req.Domain); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status": "error",
-				"error":  "nginx_setup_failed",
-				"detail": err.Error(),
-			})
+	if site.SSLEnabled {
+		// Step 1: Deploy temporary HTTP-only nginx config for ACME challenge.
+		// Not needed when shipyard answers challenges itself via nginx's catch-all server,
+		// or for wildcard certificates, which use DNS-01.
+		if !s.acmeEnabled() && !config.IsWildcardDomain(domain) {
+			if err := s.nginxMgr.DeployHTTPOnlyConfig(logContext(log), domain); err != nil {
+				return false, errNginxSetup, err.Error()
+			}
 		}
 
-		// Step 2: Obtain Let's Encrypt certificate via webroot
-		if err := s.sslMgr.ObtainCert(req.Domain); err != nil {
+		// Step 2: Obtain Let's Encrypt certificate via webroot (or DNS for wildcards)
+		if err := s.sslMgr.ObtainCert(logContext(log), domain, site.ACMEEmail); err != nil {
 			// Clean up the temporary nginx config on failure
-			s.nginxMgr.RemoveSiteConfigByDomain(req.Domain)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status": "error",
-				"error":  "cert_generation_failed",
-				"detail": err.Error(),
-			})
+			s.nginxMgr.RemoveSiteConfigByDomain(logContext(log), domain)
+			return false, errCertGeneration, err.Error()
 		}
 		// Note: The HTTP-only config remains until the site is fully initialized
 		// At that point, DeploySiteConfig will replace it with the full SSL config
 	}
 
 	// Add site to config and save (domain is the key)
-	if err := s.cfg.AddSite(req.Domain, site); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error",
-			"error":  "save_failed",
-			"detail": err.Error(),
-		})
+	if err := s.cfg.AddSite(domain, site); err != nil {
+		return false, errSaveFailed, err.Error()
 	}
 
 	// Deploy nginx config for backend if present
 	nginxDeployed := false
 	if site.Backend != nil {
 		var nginxConfig string
-		if backendOnly {
+		if site.IsBackendOnly() {
 			// Backend-only: use backend proxy template (no frontend root)
-			if req.SSLEnabled {
-				certPath, keyPath := ssl.CertPaths(req.Domain)
-				nginxConfig = nginx.GenerateBackendProxyConfigHTTPS(req.Domain, site.Backend.ListenPort, site.Backend.ProxyPath, certPath, keyPath)
-			} else {
-				nginxConfig = nginx.GenerateBackendProxyConfig(req.Domain, site.Backend.ListenPort, site.Backend.ProxyPath)
-			}
+			nginxConfig = s.backendProxyConfig(domain, site)
 		} else {
 			// Combined: frontend + backend proxy template
-			if req.SSLEnabled {
-				certPath, keyPath := ssl.CertPaths(req.Domain)
-				nginxConfig = nginx.GenerateSiteCombinedConfigHTTPS(req.Domain, frontendRoot, site.Backend.ListenPort, site.Backend.ProxyPath, certPath, keyPath)
+			if site.SSLEnabled {
+				certPath, keyPath := ssl.CertPaths(domain)
+				nginxConfig = nginx.GenerateSiteCombinedConfigHTTPS(domain, site, certPath, keyPath, s.cfg.TLSFor(domain), s.cfg)
 			} else {
-				nginxConfig = nginx.GenerateSiteCombinedConfig(req.Domain, frontendRoot, site.Backend.ListenPort, site.Backend.ProxyPath)
+				nginxConfig = nginx.GenerateSiteCombinedConfig(domain, site, s.cfg)
 			}
 		}
 
 		// Deploy directly to sites-available and reload
-		reloaded, nginxErr, err := s.nginxMgr.DeploySiteConfigRaw(req.Domain, nginxConfig)
+		reloaded, nginxErr, err := s.nginxMgr.DeploySiteConfigRaw(logContext(log), domain, nginxConfig)
 		if err != nil {
 			_ = nginxErr
 		} else {
 			nginxDeployed = reloaded
 		}
 	}
+	return nginxDeployed, nil, ""
+}
 
-	log.Info("site created", "nginx_deployed", nginxDeployed)
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"status":         "created",
-		"domain":         req.Domain,
-		"api_key":        apiKey,
-		"frontend_root":  frontendRoot,
-		"ssl_enabled":    req.SSLEnabled,
-		"has_backend":    req.WithBackend,
-		"backend_only":   backendOnly,
-		"nginx_deployed": nginxDeployed,
-	})
+// backendProxyConfig generates the nginx config for a backend-only site
+func (s *Server) backendProxyConfig(domain string, site config.SiteConfig) string {
+	if site.SSLEnabled {
+		certPath, keyPath := ssl.CertPaths(domain)
+		return nginx.GenerateBackendProxyConfigHTTPS(domain, site, certPath, keyPath, s.cfg.TLSFor(domain))
+	}
+	return nginx.GenerateBackendProxyConfig(domain, site)
 }
@@ -1,27 +1,24 @@
 package server
 
 import (
+	"log/slog"
 	"os"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/deploy"
 )
 
 // SiteDestroy tears down a site completely
 func (s *Server) SiteDestroy(c *fiber.Ctx) error {
-	form, err := c.MultipartForm()
+	form, err := requestForm(c, s.cfg)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "invalid_request",
-		})
+		return sendError(c, errInvalidRequest, "")
 	}
 
 	siteValues := form.Value["site"]
 	if len(siteValues) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "missing_site",
-		})
+		return sendError(c, errMissingSite, "")
 	}
 
 	siteName := siteValues[0]
@@ -29,46 +26,76 @@ func (s *Server) SiteDestroy(c *fiber.Ctx) error {
 
 	site, ok := s.cfg.Site[siteName]
 	if !ok {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"status": "error",
-			"error":  "site_not_found",
-		})
+		return sendError(c, errSiteNotFound, "")
 	}
 
-	log.Info("site destroy started")
+	purgeData := false
+	if values := form.Value["purge_data"]; len(values) > 0 {
+		purgeData = values[0] == "true" || values[0] == "1"
+	}
+
+	log.Info("site destroy started", "purge_data", purgeData)
+	configRemoved := s.destroySite(log, siteName, site, purgeData)
+
+	// Volume data outlives the site unless purged
+	volumesKept := []string{}
+	if site.Backend != nil && !purgeData {
+		for _, v := range site.Backend.Volumes {
+			volumesKept = append(volumesKept, v.Host)
+		}
+	}
+
+	log.Info("site destroyed", "config_removed", configRemoved, "volumes_kept", len(volumesKept))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":         "destroyed",
+		"site":           siteName,
+		"config_removed": configRemoved,
+		"volumes_kept":   volumesKept,
+	})
+}
 
+// destroySite stops the site's backend, removes its jail, nginx config and
+// frontend files, and removes it from the config. The backend's volumes are
+// only emptied with purgeData. It reports whether the config was updated.
+func (s *Server) destroySite(log *slog.Logger, siteName string, site config.SiteConfig, purgeData bool) bool {
 	// Stop and disable service
 	if site.Backend != nil {
-		s.serviceMgr.Stop(siteName)
-		s.serviceMgr.Disable(siteName)
-		s.serviceMgr.RemoveBackendService(siteName)
-
-		// Destroy jail
-		s.jailMgr.Destroy(siteName)
+		s.removeBackend(log, siteName)
+		if purgeData {
+			if err := s.jailMgr.PurgeVolumes(siteName); err != nil {
+				log.Warn("failed to purge volumes", "error", err)
+			}
+		}
 	}
 
 	// Remove nginx config
-	if err := s.nginxMgr.RemoveSiteConfig(siteName); err != nil {
+	if err := s.nginxMgr.RemoveSiteConfig(logContext(log), siteName); err != nil {
 		log.Warn("failed to remove nginx config", "error", err)
 	}
 
 	// Remove frontend directory (skip for backend-only sites)
 	if site.HasFrontend() {
 		os.RemoveAll(site.FrontendRoot)
+		os.RemoveAll(deploy.ManifestsDir(s.cfg.StateDir(), siteName))
 	}
 
 	// Remove site from config
-	configRemoved := false
 	if err := s.cfg.RemoveSite(siteName); err != nil {
 		log.Error("failed to remove site from config", "error", err)
-	} else {
-		configRemoved = true
+		return false
 	}
+	return true
+}
 
-	log.Info("site destroyed", "config_removed", configRemoved)
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"status":         "destroyed",
-		"site":           siteName,
-		"config_removed": configRemoved,
-	})
+// removeBackend stops and removes a site's backend service and destroys its jail
+func (s *Server) removeBackend(log *slog.Logger, siteName string) {
+	s.serviceMgr.Stop(siteName)
+	s.serviceMgr.Disable(siteName)
+	s.serviceMgr.RemoveBackendService(siteName)
+
+	// Destroy jail
+	s.driver.Destroy(logContext(log), siteName)
+	if err := s.firewall.Remove(siteName); err != nil {
+		log.Debug("flush firewall anchor", "site", siteName, "error", err)
+	}
 }
@@ -4,18 +4,15 @@ import (
 	"os"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
 	"github.com/lachierussell/shipyard/nginx"
-	"github.com/lachierussell/shipyard/ssl"
 )
 
 // SiteInit initializes a new site (creates directories, jails, nginx config, etc)
 func (s *Server) SiteInit(c *fiber.Ctx) error {
-	form, err := c.MultipartForm()
+	form, err := requestForm(c, s.cfg)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "invalid_request",
-		})
+		return sendError(c, errInvalidRequest, "")
 	}
 
 	siteValues := form.Value["site"]
@@ -23,10 +20,7 @@ func (s *Server) SiteInit(c *fiber.Ctx) error {
 	nginxFiles := form.File["nginx_config"]
 
 	if len(siteValues) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "missing_site",
-		})
+		return sendError(c, errMissingSite, "")
 	}
 
 	siteName := siteValues[0]
@@ -34,10 +28,7 @@ func (s *Server) SiteInit(c *fiber.Ctx) error {
 
 	site, ok := s.cfg.Site[siteName]
 	if !ok {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"status": "error",
-			"error":  "site_not_found",
-		})
+		return sendError(c, errSiteNotFound, "")
 	}
 
 	log.Info("site init started", "ssl", site.SSLEnabled, "has_backend", site.Backend != nil)
@@ -50,50 +41,37 @@ func (s *Server) SiteInit(c *fiber.Ctx) error {
 		nginxFile := nginxFiles[0]
 		src, err := nginxFile.Open()
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"status": "error",
-				"error":  "failed to read nginx_config",
-			})
+			return sendError(c, errNginxConfigReadFailed, "")
 		}
 		defer src.Close()
 
 		nginxBytes := make([]byte, nginxFile.Size)
 		if _, err := src.Read(nginxBytes); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"status": "error",
-				"error":  "failed to read nginx_config",
-			})
+			return sendError(c, errNginxConfigReadFailed, "")
 		}
 		nginxConfig = string(nginxBytes)
 	}
 
 	// For backend-only sites, auto-generate nginx config if none provided
 	if site.IsBackendOnly() && nginxConfig == "" {
-		if site.SSLEnabled {
-			certPath, keyPath := ssl.CertPaths(siteName)
-			nginxConfig = nginx.GenerateBackendProxyConfigHTTPS(siteName, site.Backend.ListenPort, site.Backend.ProxyPath, certPath, keyPath)
-		} else {
-			nginxConfig = nginx.GenerateBackendProxyConfig(siteName, site.Backend.ListenPort, site.Backend.ProxyPath)
-		}
+		nginxConfig = s.backendProxyConfig(siteName, site)
+	}
+
+	// Wildcard sites always use the generated config
+	if config.IsWildcardDomain(siteName) && nginxConfig == "" {
+		nginxConfig = nginx.GenerateWildcardConfig(siteName, site, s.cfg)
 	}
 
 	// Require nginx config for sites with a frontend
 	if nginxConfig == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-			"error":  "missing_nginx_config",
-		})
+		return sendError(c, errMissingNginxConfig, "")
 	}
 
 	// Render user-provided config as a template with site data
 	if nginxConfig != "" {
 		rendered, err := nginx.RenderUserConfig(nginxConfig, siteName, s.cfg)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"status": "error",
-				"error":  "nginx_template_error",
-				"detail": err.Error(),
-			})
+			return sendError(c, errNginxTemplate, err.Error())
 		}
 		nginxConfig = rendered
 	}
@@ -118,14 +96,22 @@ func (s *Server) SiteInit(c *fiber.Ctx) error {
 	jailCreated := false
 	jailStarted := false
 	if site.Backend != nil {
-		if err := s.jailMgr.EnsureExists(siteName); err != nil {
+		ctx := logContext(log)
+		if err := s.driver.EnsureExists(ctx, siteName); err != nil {
 			log.Error("jail creation failed", "error", err)
 		} else {
 			jailCreated = true
-			if err := s.jailMgr.Start(siteName); err != nil {
+			if err := s.driver.Start(ctx, siteName); err != nil {
 				log.Error("jail start failed", "error", err)
 			} else {
 				jailStarted = true
+				ensureUser := func() error { return s.driver.EnsureUser(ctx, siteName) }
+				if err := s.driver.Writable(siteName, ensureUser); err != nil {
+					log.Error("jail user setup failed", "user", site.Backend.RunAs, "error", err)
+				}
+				if err := s.firewall.Apply(siteName); err != nil {
+					log.Error("firewall rules not loaded", "error", err)
+				}
 			}
 		}
 	}
@@ -148,37 +134,32 @@ func (s *Server) SiteInit(c *fiber.Ctx) error {
 
 	// If SSL is enabled, we need to:
 	// 1. First deploy HTTP-only config to serve ACME challenges
+	//    (skipped when shipyard answers challenges itself)
 	// 2. Obtain SSL certificate via Let's Encrypt
 	// 3. Re-deploy with HTTPS transformation
 	sslObtained := false
 	if site.SSLEnabled {
-		// Temporarily disable SSL to deploy HTTP config first
-		site.SSLEnabled = false
-		s.cfg.Site[siteName] = site
+		if !s.acmeEnabled() {
+			// Temporarily disable SSL to deploy HTTP config first
+			site.SSLEnabled = false
+			s.cfg.Site[siteName] = site
 
-		// Deploy HTTP-only config
-		reloaded, nginxErr, err := s.nginxMgr.DeploySiteConfig(siteName, nginxConfig)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status": "error",
-				"error":  "nginx_deployment_failed",
-				"detail": err.Error(),
-			})
-		}
-		if !reloaded {
-			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
-				"status": "error",
-				"error":  "nginx_validation_failed",
-				"detail": nginxErr,
-			})
-		}
+			// Deploy HTTP-only config
+			reloaded, nginxErr, err := s.nginxMgr.DeploySiteConfig(logContext(log), siteName, nginxConfig)
+			if err != nil {
+				return sendError(c, errNginxDeployment, err.Error())
+			}
+			if !reloaded {
+				return sendError(c, errNginxValidation, nginxErr)
+			}
 
-		// Re-enable SSL
-		site.SSLEnabled = true
-		s.cfg.Site[siteName] = site
+			// Re-enable SSL
+			site.SSLEnabled = true
+			s.cfg.Site[siteName] = site
+		}
 
 		// Obtain SSL certificate
-		if err := s.sslMgr.ObtainCert(siteName); err != nil {
+		if err := s.sslMgr.ObtainCert(logContext(log), siteName, site.ACMEEmail); err != nil {
 			response["ssl_error"] = err.Error()
 		} else {
 			sslObtained = true
@@ -189,31 +170,24 @@ func (s *Server) SiteInit(c *fiber.Ctx) error {
 	if !site.SSLEnabled || !sslObtained {
 		// SSL not enabled or cert not obtained - keep HTTP config
 		if !site.SSLEnabled {
-			reloaded, nginxErr, err := s.nginxMgr.DeploySiteConfig(siteName, nginxConfig)
+			reloaded, nginxErr, err := s.nginxMgr.DeploySiteConfig(logContext(log), siteName, nginxConfig)
 			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"status": "error",
-					"error":  "nginx_deployment_failed",
-					"detail": err.Error(),
-				})
+				return sendError(c, errNginxDeployment, err.Error())
 			}
 			response["nginx_reloaded"] = reloaded
 			if !reloaded {
 				response["nginx_error"] = nginxErr
 			}
 		} else {
-			response["nginx_reloaded"] = true
+			// The HTTP-only config is only live if we deployed it for the challenge
+			response["nginx_reloaded"] = !s.acmeEnabled()
 			response["ssl_pending"] = true
 		}
 	} else {
 		// SSL obtained - deploy HTTPS config
-		reloaded, nginxErr, err := s.nginxMgr.DeploySiteConfig(siteName, nginxConfig)
+		reloaded, nginxErr, err := s.nginxMgr.DeploySiteConfig(logContext(log), siteName, nginxConfig)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status": "error",
-				"error":  "nginx_https_deployment_failed",
-				"detail": err.Error(),
-			})
+			return sendError(c, errNginxHTTPSDeployment, err.Error())
 		}
 		response["nginx_reloaded"] = reloaded
 		response["ssl_enabled"] = true
@@ -2,10 +2,14 @@ package server
 
 import (
 	"fmt"
-	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/health"
 )
 
 // SiteInfo contains site configuration and health status
@@ -16,50 +20,218 @@ type SiteInfo struct {
 	BackendOnly  bool   `json:"backend_only"`
 	SSLEnabled   bool   `json:"ssl_enabled"`
 	Health       string `json:"health"` // "healthy", "unhealthy", "unknown"
+
+	// Availability is the percentage of health probes the site passed over
+	// the last 30 days; nil before its first probe
+	Availability *float64 `json:"availability_30d"`
+
+	Tags        []string `json:"tags"`
+	Owner       string   `json:"owner"`
+	Description string   `json:"description"`
+}
+
+// siteFields are the SiteInfo fields ?fields= can pick
+var siteFields = []string{"domain", "frontend_root", "has_backend", "backend_only", "ssl_enabled", "health", "availability_30d", "tags", "owner", "description"}
+
+// maxSitesLimit bounds ?limit= on GET /sites
+const maxSitesLimit = 500
+
+// siteQuery is the paging, filtering and sorting asked of GET /sites
+type siteQuery struct {
+	offset     int
+	limit      int // 0 for every site
+	sortBy     string
+	desc       bool
+	search     string
+	hasBackend *bool
+	ssl        *bool
+	health     string
+	tags       []string // sites must have every one
+	owner      string
+	fields     []string // nil for every field
+}
+
+// parseSiteQuery reads GET /sites query parameters, returning a detail
+// message for the first invalid one
+func parseSiteQuery(c *fiber.Ctx) (siteQuery, string) {
+	q := siteQuery{sortBy: "domain", search: strings.ToLower(c.Query("q")), health: c.Query("health"), owner: c.Query("owner")}
+	if v := c.Query("tag"); v != "" {
+		q.tags = strings.Split(v, ",")
+	}
+
+	var err error
+	if v := c.Query("offset"); v != "" {
+		if q.offset, err = strconv.Atoi(v); err != nil || q.offset < 0 {
+			return q, "offset must be a non-negative integer"
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.limit, err = strconv.Atoi(v); err != nil || q.limit < 1 || q.limit > maxSitesLimit {
+			return q, fmt.Sprintf("limit must be between 1 and %d", maxSitesLimit)
+		}
+	}
+	if v := c.Query("sort"); v != "" {
+		q.sortBy, q.desc = strings.CutPrefix(v, "-")
+		if q.sortBy != "domain" && q.sortBy != "health" {
+			return q, "sort must be domain or health, with - for descending"
+		}
+	}
+	for name, dst := range map[string]**bool{"has_backend": &q.hasBackend, "ssl_enabled": &q.ssl} {
+		if v := c.Query(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return q, name + " must be true or false"
+			}
+			*dst = &b
+		}
+	}
+	switch q.health {
+	case "", "healthy", "unhealthy", "unknown":
+	default:
+		return q, "health must be healthy, unhealthy or unknown"
+	}
+	if v := c.Query("fields"); v != "" {
+		for _, f := range strings.Split(v, ",") {
+			if !slices.Contains(siteFields, f) {
+				return q, fmt.Sprintf("unknown field %q; fields are %s", f, strings.Join(siteFields, ", "))
+			}
+			q.fields = append(q.fields, f)
+		}
+	}
+	return q, ""
+}
+
+// matches reports whether a site passes the query's filters other than health
+func (q siteQuery) matches(info SiteInfo) bool {
+	return (q.search == "" || strings.Contains(info.Domain, q.search)) &&
+		(q.hasBackend == nil || info.HasBackend == *q.hasBackend) &&
+		(q.ssl == nil || info.SSLEnabled == *q.ssl) &&
+		(q.owner == "" || info.Owner == q.owner) &&
+		!slices.ContainsFunc(q.tags, func(tag string) bool { return !slices.Contains(info.Tags, tag) })
 }
 
-// ListSites returns all configured sites with their health status (admin only)
+// wants reports whether the response includes a field
+func (q siteQuery) wants(field string) bool {
+	return q.fields == nil || slices.Contains(q.fields, field)
+}
+
+// project keeps the requested fields of a site
+func (q siteQuery) project(info SiteInfo) any {
+	if q.fields == nil {
+		return info
+	}
+	all := fiber.Map{
+		"domain":           info.Domain,
+		"frontend_root":    info.FrontendRoot,
+		"has_backend":      info.HasBackend,
+		"backend_only":     info.BackendOnly,
+		"ssl_enabled":      info.SSLEnabled,
+		"health":           info.Health,
+		"availability_30d": info.Availability,
+		"tags":             info.Tags,
+		"owner":            info.Owner,
+		"description":      info.Description,
+	}
+	picked := fiber.Map{}
+	for _, f := range q.fields {
+		picked[f] = all[f]
+	}
+	return picked
+}
+
+// ListSites returns the configured sites with their health status and
+// 30-day availability (admin only), sorted by domain. Keys and users limited to some sites only see
+// those. offset and limit page through the list; q, has_backend, ssl_enabled,
+// health, tag (comma-separated, all required) and owner filter it; sort=health or -domain reorders it; fields picks the
+// fields returned. Health comes from the site checker's background probes.
 func (s *Server) ListSites(c *fiber.Ctx) error {
-	sites := make([]SiteInfo, 0, len(s.cfg.Site))
+	q, detail := parseSiteQuery(c)
+	if detail != "" {
+		return sendError(c, errInvalidRequest, detail)
+	}
 
+	sites := make([]SiteInfo, 0, len(s.cfg.Site))
 	for domain, site := range s.cfg.Site {
+		if !requestAllowsSite(c, domain) {
+			continue
+		}
 		info := SiteInfo{
 			Domain:       domain,
 			FrontendRoot: site.FrontendRoot,
 			HasBackend:   site.Backend != nil,
 			BackendOnly:  site.IsBackendOnly(),
 			SSLEnabled:   site.SSLEnabled,
-			Health:       checkSiteHealth(domain, site.SSLEnabled),
+			Tags:         site.Tags,
+			Owner:        site.Owner,
+			Description:  site.Description,
+		}
+		if info.Tags == nil {
+			info.Tags = []string{}
+		}
+		if q.matches(info) {
+			sites = append(sites, info)
 		}
-		sites = append(sites, info)
 	}
 
-	return c.JSON(fiber.Map{
-		"sites": sites,
+	// Filtering or sorting by health needs every site's; otherwise only the
+	// page's is looked up
+	healthChecked := q.health != "" || q.sortBy == "health"
+	if healthChecked {
+		s.fillSiteHealth(sites)
+		if q.health != "" {
+			sites = slices.DeleteFunc(sites, func(info SiteInfo) bool { return info.Health != q.health })
+		}
+	}
+
+	sort.Slice(sites, func(a, b int) bool {
+		x, y := sites[a], sites[b]
+		if q.desc {
+			x, y = y, x
+		}
+		if q.sortBy == "health" && x.Health != y.Health {
+			return x.Health < y.Health
+		}
+		return x.Domain < y.Domain
 	})
-}
 
-// checkSiteHealth performs a quick health check on the site
-func checkSiteHealth(domain string, sslEnabled bool) string {
-	scheme := "http"
-	if sslEnabled {
-		scheme = "https"
+	total := len(sites)
+	page := sites[min(q.offset, total):]
+	if q.limit > 0 && len(page) > q.limit {
+		page = page[:q.limit]
+	}
+	if !healthChecked && q.wants("health") {
+		s.fillSiteHealth(page)
+	}
+	if q.wants("availability_30d") {
+		s.fillAvailability(page, time.Now())
 	}
 
-	url := fmt.Sprintf("%s://%s/health", scheme, domain)
-
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+	result := make([]any, len(page))
+	for i, info := range page {
+		result[i] = q.project(info)
 	}
+	return c.JSON(fiber.Map{
+		"sites":  result,
+		"total":  total,
+		"offset": q.offset,
+		"limit":  q.limit,
+	})
+}
 
-	resp, err := client.Get(url)
-	if err != nil {
-		return "unknown"
+// fillSiteHealth sets the health of each site from the site checker's
+// latest probes
+func (s *Server) fillSiteHealth(sites []SiteInfo) {
+	for i := range sites {
+		sites[i].Health = s.siteHealth.Get(sites[i].Domain).Status
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode == http.StatusOK {
-		return "healthy"
+// fillAvailability sets each site's availability over the last
+// health.AvailabilityDays days from the uptime history
+func (s *Server) fillAvailability(sites []SiteInfo, now time.Time) {
+	for i := range sites {
+		if pct, ok := s.uptime.Get(sites[i].Domain).Availability(health.AvailabilityDays, now); ok {
+			sites[i].Availability = &pct
+		}
 	}
-	return "unhealthy"
 }
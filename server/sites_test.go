@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/health"
+)
+
+func TestListSites_PageFilterFields(t *testing.T) {
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"a.example.com": {SSLEnabled: true},
+		"b.example.com": {Backend: &config.BackendConfig{}},
+		"c.example.com": {SSLEnabled: true},
+		"d.other.org":   {SSLEnabled: true},
+	}}
+	srv := testServer(cfg)
+	app := fiber.New()
+	app.Get("/sites", srv.ListSites)
+
+	list := func(query string) (int, map[string]any) {
+		resp, err := app.Test(httptest.NewRequest("GET", "/sites?"+query, nil))
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		var body map[string]any
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+	domains := func(body map[string]any) []string {
+		var out []string
+		for _, s := range body["sites"].([]any) {
+			out = append(out, s.(map[string]any)["domain"].(string))
+		}
+		return out
+	}
+
+	_, body := list("fields=domain,ssl_enabled&ssl_enabled=true&sort=-domain&limit=2&offset=1")
+	if got := domains(body); len(got) != 2 || got[0] != "c.example.com" || got[1] != "a.example.com" {
+		t.Errorf("sites = %v, want [c.example.com a.example.com]", got)
+	}
+	if body["total"] != float64(3) {
+		t.Errorf("total = %v, want 3", body["total"])
+	}
+	first := body["sites"].([]any)[0].(map[string]any)
+	if _, ok := first["health"]; ok || len(first) != 2 {
+		t.Errorf("site = %v, want only domain and ssl_enabled", first)
+	}
+
+	_, body = list("fields=domain&q=EXAMPLE&has_backend=false")
+	if got := domains(body); len(got) != 2 || got[0] != "a.example.com" || got[1] != "c.example.com" {
+		t.Errorf("sites = %v, want [a.example.com c.example.com]", got)
+	}
+
+	for _, query := range []string{"limit=0", "limit=501", "offset=-1", "sort=size", "ssl_enabled=maybe", "health=ok", "fields=domain,secret"} {
+		if code, body := list(query); code != fiber.StatusBadRequest || body["error"] != errInvalidRequest.Code {
+			t.Errorf("%s: status = %d, body = %v, want 400 %s", query, code, body, errInvalidRequest.Code)
+		}
+	}
+}
+
+func TestListSites_TagsAndOwner(t *testing.T) {
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"pay.example.com":     {Tags: []string{"team-payments", "prod"}, Owner: "payments", Description: "Checkout"},
+		"pay-stg.example.com": {Tags: []string{"team-payments"}, Owner: "payments"},
+		"docs.example.com":    {Owner: "docs"},
+	}}
+	srv := testServer(cfg)
+	app := fiber.New()
+	app.Get("/sites", srv.ListSites)
+
+	list := func(query string) []map[string]any {
+		resp, err := app.Test(httptest.NewRequest("GET", "/sites?"+query, nil))
+		if err != nil {
+			t.Fatalf("Test request failed: %v", err)
+		}
+		var body struct {
+			Sites []map[string]any `json:"sites"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return body.Sites
+	}
+
+	if sites := list("tag=team-payments"); len(sites) != 2 {
+		t.Errorf("tag=team-payments: %d sites, want 2", len(sites))
+	}
+	sites := list("tag=team-payments,prod&fields=domain,tags,description")
+	if len(sites) != 1 || sites[0]["domain"] != "pay.example.com" || sites[0]["description"] != "Checkout" {
+		t.Errorf("tag=team-payments,prod: sites = %v, want pay.example.com", sites)
+	}
+	if sites := list("owner=docs"); len(sites) != 1 || sites[0]["domain"] != "docs.example.com" {
+		t.Errorf("owner=docs: sites = %v, want docs.example.com", sites)
+	}
+	if sites := list("owner=docs"); sites[0]["tags"] == nil {
+		t.Error("untagged site has null tags, want []")
+	}
+}
+
+func TestListSites_Availability(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{Site: map[string]config.SiteConfig{
+		"probed.example.com": {},
+		"new.example.com":    {},
+	}}
+	srv := testServer(cfg)
+	srv.uptime = health.NewUptimeLog(filepath.Join(dir, "uptime.json"))
+	now := time.Now()
+	srv.uptime.Record("probed.example.com", true, now.AddDate(0, 0, -1))
+	srv.uptime.Record("probed.example.com", true, now)
+	srv.uptime.Record("probed.example.com", true, now)
+	srv.uptime.Record("probed.example.com", false, now)
+
+	app := fiber.New()
+	app.Get("/sites", srv.ListSites)
+	resp, err := app.Test(httptest.NewRequest("GET", "/sites?fields=domain,availability_30d", nil))
+	if err != nil {
+		t.Fatalf("Test request failed: %v", err)
+	}
+	var body struct {
+		Sites []map[string]any `json:"sites"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	got := map[string]any{}
+	for _, site := range body.Sites {
+		got[site["domain"].(string)] = site["availability_30d"]
+	}
+	if got["probed.example.com"] != float64(75) || got["new.example.com"] != nil {
+		t.Errorf("availability = %v, want 75 for probed.example.com and null for new.example.com", got)
+	}
+}
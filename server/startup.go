@@ -0,0 +1,85 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/lachierussell/shipyard/health"
+)
+
+// Startup reconcile statuses
+const (
+	startupRunning = "running"
+	startupDone    = "done"
+)
+
+// startupReport is what the startup reconcile phase found and repaired
+type startupReport struct {
+	Status    string              `json:"status"` // running or done
+	StartedAt time.Time           `json:"started_at"`
+	Duration  float64             `json:"duration_seconds"`
+	SiteLinks []string            `json:"site_links"`      // sites-enabled symlinks re-created
+	Nginx     string              `json:"nginx,omitempty"` // reloaded or started
+	Backends  []health.BootResult `json:"backends"`
+	Failed    int                 `json:"failed"`
+	Errors    []string            `json:"errors,omitempty"`
+}
+
+// StartupReconcile brings the host back to the configured state, as after
+// a reboot: it re-creates missing sites-enabled symlinks, reloads nginx or
+// starts it if it is down, and starts every backend jail and service in
+// depends_on order. Backends are left alone when reconciling is off. GET
+// /health reports its progress and result. After a self-update it waits
+// for the previous process to drain first.
+func (s *Server) StartupReconcile() {
+	<-s.ops.released()
+	report := startupReport{Status: startupRunning, StartedAt: time.Now().UTC(), SiteLinks: []string{}, Backends: []health.BootResult{}}
+	s.setStartup(report)
+	log := slog.With("phase", "startup_reconcile")
+	log.Info("startup reconcile started")
+
+	linked, err := s.nginxMgr.EnsureSiteLinks()
+	report.SiteLinks = append(report.SiteLinks, linked...)
+	if err != nil {
+		report.Errors = append(report.Errors, "site links: "+err.Error())
+		log.Error("failed to re-create site links", "error", err)
+	}
+	for _, name := range linked {
+		log.Warn("site config was not enabled, re-linked it", "site", name)
+	}
+
+	if report.Nginx, err = s.nginxMgr.EnsureRunning(logContext(log)); err != nil {
+		report.Errors = append(report.Errors, "nginx: "+err.Error())
+		log.Error("failed to reconcile nginx", "error", err)
+	}
+
+	if s.cfg.Health.ReconcileIntervalDuration() > 0 {
+		report.Backends = s.reconciler.Boot()
+	}
+	report.Failed = len(report.Errors)
+	for _, res := range report.Backends {
+		if res.Error != "" {
+			report.Failed++
+		}
+	}
+
+	report.Status = startupDone
+	report.Duration = time.Since(report.StartedAt).Seconds()
+	s.setStartup(report)
+	log.Info("startup reconcile finished", "backends", len(report.Backends), "site_links", len(report.SiteLinks),
+		"nginx", report.Nginx, "failed", report.Failed, "duration", report.Duration)
+}
+
+// setStartup records the startup reconcile report
+func (s *Server) setStartup(report startupReport) {
+	s.startupMu.Lock()
+	defer s.startupMu.Unlock()
+	s.startup = &report
+}
+
+// startupStatus returns the startup reconcile report, or nil before it runs
+func (s *Server) startupStatus() *startupReport {
+	s.startupMu.Lock()
+	defer s.startupMu.Unlock()
+	return s.startup
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/nginx"
+)
+
+func TestStartupReconcile(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Nginx: config.NginxConfig{
+			BinaryPath:     filepath.Join(dir, "no-nginx"),
+			SitesAvailable: filepath.Join(dir, "sites-available"),
+			SitesEnabled:   filepath.Join(dir, "sites-enabled"),
+		},
+		Health: config.HealthConfig{ReconcileInterval: -1},
+		Site:   map[string]config.SiteConfig{"example.com": {FrontendRoot: "/var/www/example"}},
+	}
+	os.MkdirAll(cfg.Nginx.SitesAvailable, 0755)
+	os.WriteFile(filepath.Join(cfg.Nginx.SitesAvailable, "example.com.conf"), []byte("server {}\n"), 0644)
+	srv := testServer(cfg)
+	srv.nginxMgr = nginx.NewManager(cfg)
+
+	app := fiber.New()
+	app.Get("/health", srv.Health)
+	health := func() map[string]any {
+		resp, err := app.Test(httptest.NewRequest("GET", "/health", nil))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		var result map[string]any
+		json.NewDecoder(resp.Body).Decode(&result)
+		return result
+	}
+	if result := health(); result["startup"] != nil {
+		t.Errorf("startup = %v before the phase ran, want none", result["startup"])
+	}
+
+	srv.StartupReconcile()
+	if target, _ := os.Readlink(filepath.Join(cfg.Nginx.SitesEnabled, "example.com.conf")); target == "" {
+		t.Error("site config not re-linked")
+	}
+
+	startup, _ := health()["startup"].(map[string]any)
+	if startup["status"] != startupDone {
+		t.Errorf("startup.status = %v, want done", startup["status"])
+	}
+	if links, _ := startup["site_links"].([]any); len(links) != 1 || links[0] != "example.com" {
+		t.Errorf("startup.site_links = %v, want example.com", startup["site_links"])
+	}
+	// nginx can't be checked without its binary; the backends aren't started
+	// with reconciling off
+	if startup["failed"] != float64(1) || len(startup["backends"].([]any)) != 0 {
+		t.Errorf("startup = %v, want the nginx failure and no backends", startup)
+	}
+}
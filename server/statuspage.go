@@ -0,0 +1,32 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lachierussell/shipyard/statuspage"
+)
+
+// StatusPage handles GET /statuspage: the public status page of the sites
+// in [status_page], as HTML
+func (s *Server) StatusPage(c *fiber.Ctx) error {
+	if !s.cfg.StatusPage.Enabled() {
+		return sendError(c, errStatusPageDisabled, "")
+	}
+	html, err := statuspage.Render(statuspage.Build(s.cfg, s.siteHealth, s.uptime, time.Now()))
+	if err != nil {
+		return sendError(c, errTemplate, err.Error())
+	}
+	c.Set(fiber.HeaderCacheControl, "public, max-age=30")
+	c.Type("html", "utf-8")
+	return c.Send(html)
+}
+
+// StatusPageJSON handles GET /statuspage.json: the status page's data
+func (s *Server) StatusPageJSON(c *fiber.Ctx) error {
+	if !s.cfg.StatusPage.Enabled() {
+		return sendError(c, errStatusPageDisabled, "")
+	}
+	c.Set(fiber.HeaderCacheControl, "public, max-age=30")
+	return c.JSON(statuspage.Build(s.cfg, s.siteHealth, s.uptime, time.Now()))
+}
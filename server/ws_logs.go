@@ -1,70 +1,119 @@
 package server
 
 import (
-	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 )
 
-// WSLogsUpgrade is middleware that validates the admin key from a query param
-// and marks the request for WebSocket upgrade.
+// WSLogsUpgrade is middleware that validates the admin key (or OIDC
+// access_token) from a query param and marks the request for WebSocket upgrade.
+// ?replay=N asks for up to N recent lines on connect.
 func (s *Server) WSLogsUpgrade(c *fiber.Ctx) error {
 	if !websocket.IsWebSocketUpgrade(c) {
 		return c.Next()
 	}
+	if v := c.Query("replay"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > logHistorySize {
+			return sendError(c, errInvalidRequest, fmt.Sprintf("replay must be between 0 and %d", logHistorySize))
+		}
+		c.Locals("replay", n)
+	}
+
+	// Dashboard users may sign in with an OIDC token instead of a key
+	if token := c.Query("access_token"); token != "" && s.cfg.OIDC.Enabled() {
+		globs, apiErr, detail := bearerAuth(c, s.cfg, token)
+		if apiErr != nil {
+			return sendError(c, apiErr, detail)
+		}
+		if globs != nil {
+			return sendError(c, errSiteNotAllowed, "the log stream covers every site; use /site/logs?site= instead")
+		}
+		return c.Next()
+	}
 
 	key := c.Query("key")
 	if key == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"status": "error",
-			"error":  "missing_auth",
-			"detail": "key query parameter required",
-		})
+		return sendError(c, errMissingAuth, "key query parameter required")
 	}
 
-	found := false
-	for _, adminKey := range s.cfg.AdminKeys {
-		if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1 {
-			found = true
-			break
-		}
+	if !isAdminKey(s.cfg, key) {
+		return sendError(c, errInvalidKey, "")
 	}
-	if !found {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"status": "error",
-			"error":  "invalid_key",
-		})
+	// The stream carries every site's logs
+	if s.cfg.KeyScoped(key) {
+		return sendError(c, errSiteNotAllowed, "the log stream covers every site; use /site/logs?site= instead")
 	}
 
 	return c.Next()
 }
 
-// WSLogs handles a WebSocket connection for streaming log entries.
+// WSLogs handles a WebSocket connection for streaming log entries. Clients
+// are pinged every server.ws_ping_interval, and dropped when a ping or a
+// write goes unanswered for server.ws_pong_timeout.
 func (s *Server) WSLogs(c *websocket.Conn) {
-	client := &LogClient{
-		conn: c,
-		send: make(chan []byte, 64),
+	if !s.logHub.acquire(s.cfg.Server.WSMaxConns()) {
+		c.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too many log stream connections"),
+			time.Now().Add(time.Second))
+		c.Close()
+		return
+	}
+	defer s.logHub.release()
+
+	replay, _ := c.Locals("replay").(int)
+	client := newLogClient(c, replay)
+	if !s.logHub.add(client) {
+		c.Close()
+		return
 	}
 
-	s.logHub.register <- client
+	ping, pongWait := s.cfg.Server.WSPing(), s.cfg.Server.WSPongWait()
 
-	// writePump: send messages from channel to WebSocket.
+	// writePump: send messages from channel to WebSocket, and ping.
+	written := make(chan struct{})
 	go func() {
+		defer close(written)
 		defer c.Close()
-		for msg := range client.send {
-			if err := c.WriteMessage(websocket.TextMessage, msg); err != nil {
-				break
+		ticker := time.NewTicker(ping)
+		defer ticker.Stop()
+		for {
+			select {
+			case msg, ok := <-client.send:
+				c.SetWriteDeadline(time.Now().Add(pongWait))
+				if !ok {
+					c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+					return
+				}
+				if err := c.WriteMessage(websocket.TextMessage, msg); err != nil {
+					return
+				}
+			case <-ticker.C:
+				c.SetWriteDeadline(time.Now().Add(pongWait))
+				if err := c.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
 			}
 		}
 	}()
 
-	// readPump: detect client disconnect.
+	// readPump: detect client disconnect. A connection that hasn't answered
+	// the last ping in time is half-open, and reading it fails.
+	alive := func(string) error { return c.SetReadDeadline(time.Now().Add(ping + pongWait)) }
+	alive("")
+	c.SetPongHandler(alive)
 	for {
 		if _, _, err := c.ReadMessage(); err != nil {
 			break
 		}
+		alive("")
 	}
 
-	s.logHub.unregister <- client
+	s.logHub.remove(client)
+	// The connection is recycled when this returns, so wait for the writer
+	<-written
 }
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	fws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestWSLogs_PingsAndLimitsConnections(t *testing.T) {
+	cfg := &config.Config{
+		AdminKeys: []string{"admin"},
+		Server:    config.ServerConfig{WSMaxConnections: 1, WSPingInterval: 1, WSPongTimeout: 1},
+	}
+	srv := testServer(cfg)
+	srv.logHub = NewLogHub()
+	go srv.logHub.Run()
+	defer srv.logHub.Stop()
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use("/ws", srv.WSLogsUpgrade)
+	app.Get("/ws/logs", websocket.New(srv.WSLogs))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+	url := "ws://" + ln.Addr().String() + "/ws/logs?key=admin"
+
+	conn, _, err := fws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	pinged := make(chan struct{}, 1)
+	conn.SetPingHandler(func(data string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return conn.WriteControl(fws.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-pinged:
+	case <-time.After(3 * time.Second):
+		t.Fatal("no ping within 3s")
+	}
+
+	// A second connection is over ws_max_connections
+	extra, _, err := fws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer extra.Close()
+	extra.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err := extra.ReadMessage(); !fws.IsCloseError(err, fws.CloseTryAgainLater) {
+		t.Errorf("second connection read = %v, want close 1013", err)
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	_ "embed"
+	"strconv"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+//go:embed run.sh
+var runScript string
+
+// RunScript returns the backend supervisor script, for drivers that install
+// it as a file
+func RunScript() string {
+	return runScript
+}
+
+//go:embed stop.sh
+var stopScript string
+
+// StopScript returns the script that stops a backend gracefully, for drivers
+// that install it as a file
+func StopScript() string {
+	return stopScript
+}
+
+// JailPidFile is where the supervisor's pid is recorded inside a jail: by
+// daemon(8) in a pot, by run.sh itself in a container. It is under /var/log
+// because that stays writable in read-only jails.
+const JailPidFile = "/var/log/app.pid"
+
+// JailMigrateLog is where a backend's migrate_command writes its output
+// inside its jail. Each deploy replaces it.
+const JailMigrateLog = "/var/log/migrate.log"
+
+// ContainerRunScript is where the container driver installs RunScript
+const ContainerRunScript = "/usr/local/bin/.shipyard-run"
+
+// ContainerStopScript is where the container driver installs StopScript
+const ContainerStopScript = "/usr/local/bin/.shipyard-stop"
+
+// DaemonArgs returns the command, run inside the pot, that starts a backend
+// under daemon(8). daemon detaches it, drops to the backend's run_as user and
+// writes its output to /var/log/app.log; run.sh restarts it according to the
+// backend's restart policy. pidFile may be empty.
+func DaemonArgs(backend config.BackendConfig, binaryPath, pidFile string) []string {
+	args := []string{"/usr/sbin/daemon"}
+	if pidFile != "" {
+		args = append(args, "-P", pidFile)
+	}
+	if backend.RunAs != "" {
+		args = append(args, "-u", backend.RunAs)
+	}
+	return append(args, "-o", "/var/log/app.log", "-f",
+		"/bin/sh", "-c", runScript, "run", binaryPath,
+		backend.RestartPolicy(),
+		strconv.Itoa(backend.RestartDelaySeconds()),
+		strconv.Itoa(backend.MaxRestarts),
+		strconv.Itoa(backend.RestartWindowSeconds()),
+	)
+}
+
+// ContainerArgs returns the command, run inside a backend's container, that
+// supervises it as DaemonArgs does in a pot. The container runtime detaches it
+// and sets the user; with SHIPYARD_LOG and SHIPYARD_PIDFILE set, run.sh writes
+// its own output and pidfile.
+func ContainerArgs(backend config.BackendConfig, binaryPath string) []string {
+	return []string{"/bin/sh", ContainerRunScript, binaryPath,
+		backend.RestartPolicy(),
+		strconv.Itoa(backend.RestartDelaySeconds()),
+		strconv.Itoa(backend.MaxRestarts),
+		strconv.Itoa(backend.RestartWindowSeconds()),
+	}
+}
+
+// BackendArgs returns the command that starts a backend inside its jail with
+// the environment it listens with, for the configured jail driver
+func BackendArgs(cfg *config.Config, backend config.BackendConfig, binaryPath, pidFile string) []string {
+	args := []string{"env", "PORT=" + strconv.Itoa(backend.ListenPort), "HOST=0.0.0.0"}
+	if cfg.Jail.UsesContainers() {
+		args = append(args, "SHIPYARD_LOG=/var/log/app.log", "SHIPYARD_PIDFILE="+JailPidFile)
+		return append(args, ContainerArgs(backend, binaryPath)...)
+	}
+	return append(args, DaemonArgs(backend, binaryPath, pidFile)...)
+}
+
+// StopArgs returns the command, run inside a backend's jail, that stops it
+// gracefully: its supervisor gets SIGTERM and then drain_timeout seconds to
+// exit before it is killed with SIGKILL
+func StopArgs(cfg *config.Config, backend config.BackendConfig) []string {
+	drain := strconv.Itoa(backend.DrainTimeoutSeconds())
+	if cfg.Jail.UsesContainers() {
+		return []string{"/bin/sh", ContainerStopScript, JailPidFile, drain}
+	}
+	return []string{"/bin/sh", "-c", stopScript, "stop", JailPidFile, drain}
+}
+
+// MigrateArgs returns the command, run inside a backend's jail, that runs its
+// migrate_command with sh as the run_as user and env (NAME=value entries, as
+// from ResolveEnv) added to its environment, writing its output to logPath.
+// timeout(1) stops it after migrate_timeout seconds with status 124.
+func MigrateArgs(backend config.BackendConfig, env []string, logPath string) []string {
+	var cmd []string
+	if len(env) > 0 {
+		cmd = append([]string{"env"}, env...)
+	}
+	cmd = append(cmd, "timeout", strconv.Itoa(backend.MigrateTimeoutSeconds()))
+	if backend.RunAs != "" {
+		cmd = append(cmd, "su", "-m", backend.RunAs, "-c", backend.MigrateCommand)
+	} else {
+		cmd = append(cmd, "/bin/sh", "-c", backend.MigrateCommand)
+	}
+	script := "exec >" + shellQuote(logPath) + " 2>&1; cd /; exec " + shellJoin(cmd)
+	return []string{"/bin/sh", "-c", script}
+}
+
+// shellJoin quotes args for sh
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes s for sh unless it is made of safe characters only
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./:=") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/secrets"
+)
+
+// envFile returns the file holding a backend's resolved env, which its
+// rc.d script or systemd unit reads when it starts. It is kept on the host,
+// readable by root only, and rewritten each time shipyard starts the backend.
+func envFile(cfg *config.Config, siteName string) string {
+	return filepath.Join(cfg.StateDir(), "env", serviceName(siteName)+".env")
+}
+
+// ResolveEnv returns a backend's env as sorted NAME=value entries, with its
+// secret:// values looked up. LANG is jail.init.locale unless env sets it.
+func ResolveEnv(cfg *config.Config, backend config.BackendConfig) ([]string, error) {
+	resolved := map[string]string{}
+	if len(backend.Env) > 0 {
+		provider, err := secrets.NewProvider(cfg.Secrets)
+		if err != nil {
+			return nil, err
+		}
+		if resolved, err = secrets.Resolve(provider, backend.Env); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := resolved["LANG"]; !ok && cfg.Jail.Init.Locale != "" {
+		resolved["LANG"] = cfg.Jail.Init.Locale
+	}
+	env := make([]string, 0, len(resolved))
+	for name, value := range resolved {
+		env = append(env, name+"="+value)
+	}
+	slices.Sort(env)
+	return env, nil
+}
+
+// WriteEnv resolves a backend's env and writes it to its env file, returning
+// the entries written. A secret that cannot be looked up fails it and leaves
+// the file as it was.
+func (m *Manager) WriteEnv(siteName string) ([]string, error) {
+	site, ok := m.cfg.Site[siteName]
+	if !ok {
+		return nil, fmt.Errorf("site not found: %s", siteName)
+	}
+	if site.Backend == nil {
+		return nil, nil
+	}
+
+	env, err := ResolveEnv(m.cfg, *site.Backend)
+	if err != nil {
+		return nil, fmt.Errorf("resolve backend env: %w", err)
+	}
+	var content strings.Builder
+	for _, e := range env {
+		content.WriteString(e + "\n")
+	}
+
+	path := envFile(m.cfg, siteName)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("create env directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content.String()), 0600); err != nil {
+		return nil, fmt.Errorf("write env file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("write env file: %w", err)
+	}
+	return env, nil
+}
+
+// ensureEnvFile creates an empty env file for a backend that has none yet,
+// so its service can start before shipyard has started it once
+func (m *Manager) ensureEnvFile(siteName string) error {
+	path := envFile(m.cfg, siteName)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create env directory: %w", err)
+	}
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		return fmt.Errorf("write env file: %w", err)
+	}
+	return nil
+}
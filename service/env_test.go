@@ -0,0 +1,68 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestWriteEnv(t *testing.T) {
+	stateDir := t.TempDir()
+	secretsDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(secretsDir, "db-password"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Self:    config.SelfConfig{StateDir: stateDir},
+		Secrets: config.SecretsConfig{Dir: secretsDir},
+		Site: map[string]config.SiteConfig{
+			"api.example.com": {Backend: &config.BackendConfig{Env: map[string]string{
+				"LOG_LEVEL":   "info",
+				"DB_PASSWORD": "secret://db-password",
+			}}},
+		},
+	}
+	m := NewManager(cfg)
+
+	env, err := m.WriteEnv("api.example.com")
+	if err != nil {
+		t.Fatalf("WriteEnv: %v", err)
+	}
+	path := envFile(cfg, "api.example.com")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "DB_PASSWORD=s3cret\nLOG_LEVEL=info\n"; string(data) != want {
+		t.Errorf("env file = %q, want %q", data, want)
+	}
+	if len(env) != 2 {
+		t.Errorf("env = %q, want both entries", env)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("env file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// jail.init.locale is the default LANG
+	cfg.Jail.Init.Locale = "en_AU.UTF-8"
+	if env, _ := m.WriteEnv("api.example.com"); !slices.Contains(env, "LANG=en_AU.UTF-8") {
+		t.Errorf("env = %q, want LANG from jail.init.locale", env)
+	}
+	cfg.Site["api.example.com"].Backend.Env["LANG"] = "C.UTF-8"
+	if env, _ := m.WriteEnv("api.example.com"); !slices.Contains(env, "LANG=C.UTF-8") || slices.Contains(env, "LANG=en_AU.UTF-8") {
+		t.Errorf("env = %q, want the backend's own LANG", env)
+	}
+	data, _ = os.ReadFile(path)
+
+	// A secret that cannot be found leaves the last env in place
+	cfg.Site["api.example.com"].Backend.Env["API_KEY"] = "secret://missing"
+	if _, err := m.WriteEnv("api.example.com"); err == nil {
+		t.Fatal("expected an error for a missing secret")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(data) {
+		t.Errorf("env file changed to %q after a failed resolve", after)
+	}
+}
@@ -9,15 +9,22 @@ import (
 	"path/filepath"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/runner"
 )
 
 type rcdData struct {
-	ServiceName string
-	PotName     string
-	BinaryPath  string
-	ListenPort  int
+	ServiceName   string
+	PotName       string
+	BinaryPath    string
+	ListenPort    int
+	PidFile       string
+	EnvFile       string
+	DaemonCommand string
+	StopCommand   string
+	Requires      []string // the services of the backends it depends on
 }
 
 //go:embed rcd.sh.tmpl
@@ -25,7 +32,8 @@ var rcdTmplStr string
 
 var rcdTmpl = template.Must(template.New("rcd").Delims("<%", "%>").Parse(rcdTmplStr))
 
-// Manager manages rc.d service scripts for pot-based services
+// Manager manages the host services that run backends: rc.d scripts for
+// pots, systemd units for containers
 type Manager struct {
 	cfg *config.Config
 }
@@ -40,6 +48,14 @@ func potName(siteName string) string {
 	return strings.ReplaceAll(siteName, ".", "-")
 }
 
+// commands returns the Runner for starting and stopping a backend's service.
+// Stopping waits for the backend to drain, so it has drain_timeout on top of
+// the default limit.
+func commands(backend *config.BackendConfig) *runner.Runner {
+	drain := time.Duration(backend.DrainTimeoutSeconds()) * time.Second
+	return &runner.Runner{Timeout: runner.DefaultTimeout + drain}
+}
+
 // serviceName converts a site name to a valid service name (underscores for rc.d)
 func serviceName(siteName string) string {
 	name := strings.ReplaceAll(siteName, ".", "_")
@@ -47,7 +63,18 @@ func serviceName(siteName string) string {
 	return name
 }
 
-// CreateBackendService creates an rc.d script for a pot-based backend service
+// dependencyServices returns the services of the backends a backend
+// depends on, named by name, so the host starts them first
+func dependencyServices(backend config.BackendConfig, name func(siteName string) string) []string {
+	var services []string
+	for _, dep := range backend.DependsOn {
+		services = append(services, name(dep))
+	}
+	return services
+}
+
+// CreateBackendService creates the host service for a backend: an rc.d
+// script for a pot, or a systemd unit for a container
 func (m *Manager) CreateBackendService(siteName string) error {
 	site, ok := m.cfg.Site[siteName]
 	if !ok {
@@ -58,6 +85,13 @@ func (m *Manager) CreateBackendService(siteName string) error {
 		return fmt.Errorf("site %s has no backend config", siteName)
 	}
 
+	if err := m.ensureEnvFile(siteName); err != nil {
+		return err
+	}
+	if m.cfg.Jail.UsesContainers() {
+		return m.createUnit(siteName, *site.Backend)
+	}
+
 	potN := potName(siteName)
 	svcName := serviceName(siteName)
 	binaryPath := filepath.Join("/usr/local/bin", site.Backend.BinaryName)
@@ -65,10 +99,15 @@ func (m *Manager) CreateBackendService(siteName string) error {
 
 	var buf bytes.Buffer
 	if err := rcdTmpl.Execute(&buf, rcdData{
-		ServiceName: svcName,
-		PotName:     potN,
-		BinaryPath:  binaryPath,
-		ListenPort:  listenPort,
+		ServiceName:   svcName,
+		PotName:       potN,
+		BinaryPath:    binaryPath,
+		ListenPort:    listenPort,
+		PidFile:       JailPidFile,
+		EnvFile:       envFile(m.cfg, siteName),
+		DaemonCommand: shellJoin(DaemonArgs(*site.Backend, binaryPath, JailPidFile)),
+		StopCommand:   shellJoin(StopArgs(m.cfg, *site.Backend)),
+		Requires:      dependencyServices(*site.Backend, serviceName),
 	}); err != nil {
 		return fmt.Errorf("execute rcd template: %w", err)
 	}
@@ -87,7 +126,7 @@ func (m *Manager) CreateBackendService(siteName string) error {
 	return nil
 }
 
-// RemoveBackendService removes an rc.d script
+// RemoveBackendService removes a backend's rc.d script or systemd unit
 func (m *Manager) RemoveBackendService(siteName string) error {
 	site, ok := m.cfg.Site[siteName]
 	if !ok {
@@ -99,6 +138,10 @@ func (m *Manager) RemoveBackendService(siteName string) error {
 	}
 
 	svcName := serviceName(siteName)
+	if m.cfg.Jail.UsesContainers() {
+		os.Remove(filepath.Join(systemdUnitDir, unitName(svcName)))
+		return nil
+	}
 	rcdPath := filepath.Join("/usr/local/etc/rc.d", svcName)
 	os.Remove(rcdPath)
 
@@ -135,7 +178,7 @@ func (m *Manager) Disable(siteName string) error {
 	return disableService(serviceName(siteName))
 }
 
-// Start starts a service
+// Start writes a backend's env, looking up its secrets, and starts its service
 func (m *Manager) Start(siteName string) error {
 	site, ok := m.cfg.Site[siteName]
 	if !ok {
@@ -146,8 +189,11 @@ func (m *Manager) Start(siteName string) error {
 		return nil
 	}
 
+	if _, err := m.WriteEnv(siteName); err != nil {
+		return err
+	}
 	slog.Info("starting service", "site", siteName)
-	return startService(serviceName(siteName))
+	return startService(commands(site.Backend), serviceName(siteName))
 }
 
 // Stop stops a service
@@ -162,11 +208,11 @@ func (m *Manager) Stop(siteName string) error {
 	}
 
 	slog.Info("stopping service", "site", siteName)
-	stopService(serviceName(siteName))
+	stopService(commands(site.Backend), serviceName(siteName))
 	return nil
 }
 
-// Restart restarts a service
+// Restart writes a backend's env, as Start does, and restarts its service
 func (m *Manager) Restart(siteName string) error {
 	site, ok := m.cfg.Site[siteName]
 	if !ok {
@@ -177,7 +223,10 @@ func (m *Manager) Restart(siteName string) error {
 		return nil
 	}
 
-	return restartService(serviceName(siteName))
+	if _, err := m.WriteEnv(siteName); err != nil {
+		return err
+	}
+	return restartService(commands(site.Backend), serviceName(siteName))
 }
 
 // Status checks service status
@@ -193,3 +242,12 @@ func (m *Manager) Status(siteName string) (bool, error) {
 
 	return checkService(serviceName(siteName)), nil
 }
+
+// Enabled reports whether a backend's service is enabled to start at boot
+func (m *Manager) Enabled(siteName string) bool {
+	site, ok := m.cfg.Site[siteName]
+	if !ok || site.Backend == nil {
+		return false
+	}
+	return serviceEnabled(serviceName(siteName))
+}
@@ -2,8 +2,16 @@ package service
 
 import (
 	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
 )
 
 func TestPotName(t *testing.T) {
@@ -56,7 +64,12 @@ func TestRcdTemplateRender(t *testing.T) {
 		PotName:     "example-com",
 		BinaryPath:  "/usr/local/bin/example.com",
 		ListenPort:  8080,
+		PidFile:     JailPidFile,
+		EnvFile:     "/var/db/shipyard/env/example_com.env",
+		Requires:    dependencyServices(config.BackendConfig{DependsOn: []string{"auth.example.com"}}, serviceName),
 	}
+	data.DaemonCommand = shellJoin(DaemonArgs(config.BackendConfig{}, data.BinaryPath, "/var/run/example_com.pid"))
+	data.StopCommand = shellJoin(StopArgs(&config.Config{}, config.BackendConfig{DrainTimeout: 30}))
 
 	var buf bytes.Buffer
 	if err := rcdTmpl.Execute(&buf, data); err != nil {
@@ -71,6 +84,17 @@ func TestRcdTemplateRender(t *testing.T) {
 		"/usr/local/bin/example.com",
 		"8080",
 		"MANAGED BY SHIPYARD",
+		"# REQUIRE: NETWORKING pot auth_example_com\n",
+		"/var/log/app.exit",
+		`pidfile="/var/log/app.pid"`,
+		`env_file="/var/db/shipyard/env/example_com.env"`,
+		`export "${line}"; done; exec "$@"' load-env`,
+		`env PORT=${listen_port} HOST=0.0.0.0 /usr/sbin/daemon`,
+		`< "${env_file}" || return 1`,
+		"pot exec -p ${pot_name} /bin/sh -c '",
+		"stop /var/log/app.pid 30",
+		"/usr/sbin/daemon -P /var/run/example_com.pid -o /var/log/app.log -f /bin/sh -c '",
+		"run /usr/local/bin/example.com always 5 0 60",
 	}
 
 	for _, want := range checks {
@@ -79,3 +103,116 @@ func TestRcdTemplateRender(t *testing.T) {
 		}
 	}
 }
+
+func TestDaemonArgs_RestartPolicy(t *testing.T) {
+	backend := config.BackendConfig{Restart: config.RestartOnFailure, RestartDelay: 2, MaxRestarts: 10, RestartWindow: 300}
+	args := DaemonArgs(backend, "/usr/local/bin/api", "")
+	if slices.Contains(args, "-P") || slices.Contains(args, "-r") || slices.Contains(args, "-u") {
+		t.Errorf("args = %q: want no pidfile, no daemon(8) restarts and no user", args)
+	}
+	want := []string{"run", "/usr/local/bin/api", "on-failure", "2", "10", "300"}
+	if got := args[len(args)-len(want):]; !slices.Equal(got, want) {
+		t.Errorf("run.sh arguments = %q, want %q", got, want)
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	args := []string{"plain", "it's", "two words", "$HOME", "line\nbreak", ""}
+
+	// Evaluate the joined string the way the rc script does
+	out, err := exec.Command("sh", "-c", "set -- "+shellJoin(args)+`; printf '%s|' "$@"`).Output()
+	if err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+	if want := strings.Join(args, "|") + "|"; string(out) != want {
+		t.Errorf("sh saw %q, want %q", out, want)
+	}
+}
+
+func TestDaemonArgs_RunAs(t *testing.T) {
+	args := DaemonArgs(config.BackendConfig{RunAs: "app"}, "/usr/local/bin/api", "/var/run/api.pid")
+	i := slices.Index(args, "-u")
+	if i < 0 || args[i+1] != "app" {
+		t.Fatalf("args = %q, want -u app", args)
+	}
+	if f := slices.Index(args, "-f"); i > f {
+		t.Errorf("args = %q: -u must come before the command", args)
+	}
+}
+
+func TestStopScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+	tests := []struct {
+		name    string
+		backend string // ignoring SIGTERM keeps it running through the drain
+		killed  bool
+	}{
+		{"exits on SIGTERM", "exec sleep 30", false},
+		{"ignores SIGTERM", "trap '' TERM; exec sleep 30", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := exec.Command("sh", "-c", tt.backend)
+			if err := backend.Start(); err != nil {
+				t.Fatalf("start backend: %v", err)
+			}
+			exited := make(chan struct{})
+			go func() {
+				backend.Wait()
+				close(exited)
+			}()
+			pidFile := filepath.Join(t.TempDir(), "app.pid")
+			os.WriteFile(pidFile, []byte(strconv.Itoa(backend.Process.Pid)+"\n"), 0644)
+
+			out, err := exec.Command("sh", "-c", stopScript, "stop", pidFile, "1").CombinedOutput()
+			if err != nil {
+				t.Fatalf("stop script: %v: %s", err, out)
+			}
+			select {
+			case <-exited:
+			case <-time.After(5 * time.Second):
+				backend.Process.Kill()
+				t.Fatal("backend still running after the stop script")
+			}
+			if killed := strings.Contains(string(out), "SIGKILL"); killed != tt.killed {
+				t.Errorf("killed = %v, want %v: %s", killed, tt.killed, out)
+			}
+		})
+	}
+}
+
+func TestMigrateArgs(t *testing.T) {
+	if _, err := exec.LookPath("timeout"); err != nil {
+		t.Skipf("timeout not available: %v", err)
+	}
+	logPath := filepath.Join(t.TempDir(), "migrate.log")
+	run := func(backend config.BackendConfig) int {
+		args := MigrateArgs(backend, []string{"DB_URL=postgres://db/app"}, logPath)
+		err := exec.Command(args[0], args[1:]...).Run()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		if err != nil {
+			t.Fatalf("run migration: %v", err)
+		}
+		return 0
+	}
+
+	if code := run(config.BackendConfig{MigrateCommand: "echo migrated $DB_URL; echo oops >&2; exit 3"}); code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+	if out, _ := os.ReadFile(logPath); string(out) != "migrated postgres://db/app\noops\n" {
+		t.Errorf("log = %q, want the command's stdout and stderr", out)
+	}
+
+	if code := run(config.BackendConfig{MigrateCommand: "sleep 5", MigrateTimeout: 1}); code != 124 {
+		t.Errorf("exit code = %d, want 124 from timeout", code)
+	}
+
+	args := MigrateArgs(config.BackendConfig{MigrateCommand: "/usr/local/bin/api migrate", RunAs: "app"}, nil, logPath)
+	if !strings.Contains(args[2], "su -m app -c '/usr/local/bin/api migrate'") {
+		t.Errorf("script = %q, want the command run as app", args[2])
+	}
+}
@@ -0,0 +1,48 @@
+# Supervises a backend inside its pot; daemon(8) runs it as
+#   sh -c "$(cat run.sh)" run <binary> <policy> <delay> <max restarts> <window>
+# Exits shipyard didn't ask for are appended to /var/log/app.exit as
+# "<unix time> <status>". The backend runs in /var/crash so cores land there.
+# Containers have no daemon(8) to capture output or record the pid; they set
+# SHIPYARD_LOG and SHIPYARD_PIDFILE. On SIGTERM the backend is passed the
+# signal and waited for, however long it takes to drain; stop.sh decides when
+# to give up on it.
+[ -n "$SHIPYARD_LOG" ] && exec >>"$SHIPYARD_LOG" 2>&1
+[ -n "$SHIPYARD_PIDFILE" ] && echo $$ >"$SHIPYARD_PIDFILE"
+ulimit -c unlimited
+cd /var/crash 2>/dev/null || cd /
+
+binary=$1 policy=$2 delay=$3 max=$4 window=$5
+trap 'stopping=1; kill -TERM $pid 2>/dev/null' TERM
+restarts=
+
+while :; do
+    "$binary" &
+    pid=$!
+    wait $pid
+    status=$?
+    if [ -n "$stopping" ]; then
+        wait $pid
+        exit 0
+    fi
+    echo "$(date +%s) $status" >> /var/log/app.exit
+
+    if [ "$policy" = never ] || { [ "$policy" = on-failure ] && [ $status -eq 0 ]; }; then
+        echo "shipyard: backend exited with status $status; restart policy is $policy"
+        exit $status
+    fi
+
+    now=$(date +%s)
+    recent=
+    for t in $restarts; do
+        [ $((now - t)) -lt $window ] && recent="$recent $t"
+    done
+    restarts="$recent $now"
+    if [ $max -gt 0 ] && [ $(echo $restarts | wc -w) -gt $max ]; then
+        echo "shipyard: backend exited with status $status; giving up after $max restarts in ${window}s"
+        exit $status
+    fi
+
+    sleep $delay &
+    wait $!
+    [ -n "$stopping" ] && exit 0
+done
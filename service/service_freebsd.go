@@ -3,54 +3,65 @@
 package service
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"strings"
+
+	"github.com/lachierussell/shipyard/runner"
 )
 
 // enableService enables a service on FreeBSD using sysrc
 func enableService(name string) error {
-	cmd := exec.Command("sysrc", name+"_enable=YES")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("enable service: %w", err)
+	if output, err := runner.Run(context.Background(), "sysrc", name+"_enable=YES"); err != nil {
+		return fmt.Errorf("enable service: %w: %s", err, string(output))
 	}
 	return nil
 }
 
 // disableService disables a service on FreeBSD using sysrc
 func disableService(name string) error {
-	cmd := exec.Command("sysrc", name+"_enable=NO")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("disable service: %w", err)
+	if output, err := runner.Run(context.Background(), "sysrc", name+"_enable=NO"); err != nil {
+		return fmt.Errorf("disable service: %w: %s", err, string(output))
 	}
 	return nil
 }
 
 // startService starts a service on FreeBSD
-func startService(name string) error {
-	cmd := exec.Command("service", name, "start")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("start service: %w", err)
+func startService(r *runner.Runner, name string) error {
+	if output, err := r.Run(context.Background(), "service", name, "start"); err != nil {
+		return fmt.Errorf("start service: %w: %s", err, string(output))
 	}
 	return nil
 }
 
 // stopService stops a service on FreeBSD
-func stopService(name string) {
-	cmd := exec.Command("service", name, "stop")
-	cmd.Run() // Ignore error - service might not be running
+func stopService(r *runner.Runner, name string) {
+	r.Run(context.Background(), "service", name, "stop") // Ignore error - service might not be running
 }
 
 // restartService restarts a service on FreeBSD
-func restartService(name string) error {
-	cmd := exec.Command("service", name, "restart")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("restart service: %w", err)
+func restartService(r *runner.Runner, name string) error {
+	if output, err := r.Run(context.Background(), "service", name, "restart"); err != nil {
+		return fmt.Errorf("restart service: %w: %s", err, string(output))
 	}
 	return nil
 }
 
 // checkService checks if a service is running on FreeBSD
 func checkService(name string) bool {
-	cmd := exec.Command("service", name, "status")
-	return cmd.Run() == nil
+	_, err := runner.Run(context.Background(), "service", name, "status")
+	return err == nil
+}
+
+// serviceEnabled reports whether a service is enabled in rc.conf on FreeBSD
+func serviceEnabled(name string) bool {
+	out, err := runner.Output(context.Background(), "sysrc", "-n", name+"_enable")
+	if err != nil {
+		return false
+	}
+	switch strings.ToUpper(strings.TrimSpace(string(out))) {
+	case "YES", "TRUE", "ON", "1":
+		return true
+	}
+	return false
 }
@@ -0,0 +1,62 @@
+//go:build linux
+
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lachierussell/shipyard/runner"
+)
+
+// enableService reloads systemd's units and enables a backend's unit on Linux
+func enableService(name string) error {
+	if output, err := runner.Run(context.Background(), "systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("systemctl daemon-reload: %w: %s", err, string(output))
+	}
+	if output, err := runner.Run(context.Background(), "systemctl", "enable", unitName(name)); err != nil {
+		return fmt.Errorf("enable service: %w: %s", err, string(output))
+	}
+	return nil
+}
+
+// disableService disables a backend's unit on Linux
+func disableService(name string) error {
+	if output, err := runner.Run(context.Background(), "systemctl", "disable", unitName(name)); err != nil {
+		return fmt.Errorf("disable service: %w: %s", err, string(output))
+	}
+	return nil
+}
+
+// startService starts a backend's unit on Linux
+func startService(r *runner.Runner, name string) error {
+	if output, err := r.Run(context.Background(), "systemctl", "start", unitName(name)); err != nil {
+		return fmt.Errorf("start service: %w: %s", err, string(output))
+	}
+	return nil
+}
+
+// stopService stops a backend's unit on Linux
+func stopService(r *runner.Runner, name string) {
+	r.Run(context.Background(), "systemctl", "stop", unitName(name)) // Ignore error - service might not be running
+}
+
+// restartService restarts a backend's unit on Linux
+func restartService(r *runner.Runner, name string) error {
+	if output, err := r.Run(context.Background(), "systemctl", "restart", unitName(name)); err != nil {
+		return fmt.Errorf("restart service: %w: %s", err, string(output))
+	}
+	return nil
+}
+
+// checkService checks if a backend's unit is active on Linux
+func checkService(name string) bool {
+	_, err := runner.Run(context.Background(), "systemctl", "is-active", "--quiet", unitName(name))
+	return err == nil
+}
+
+// serviceEnabled reports whether a backend's unit is enabled on Linux
+func serviceEnabled(name string) bool {
+	_, err := runner.Run(context.Background(), "systemctl", "is-enabled", "--quiet", unitName(name))
+	return err == nil
+}
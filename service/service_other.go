@@ -1,32 +1,40 @@
-//go:build !freebsd
+//go:build !freebsd && !linux
 
 package service
 
-// enableService is a no-op on non-FreeBSD platforms
+import "github.com/lachierussell/shipyard/runner"
+
+// enableService is a no-op on platforms without rc.d or systemd
 func enableService(name string) error {
 	return nil
 }
 
-// disableService is a no-op on non-FreeBSD platforms
+// disableService is a no-op on platforms without rc.d or systemd
 func disableService(name string) error {
 	return nil
 }
 
-// startService is a no-op on non-FreeBSD platforms
-func startService(name string) error {
+// startService is a no-op on platforms without rc.d or systemd
+func startService(r *runner.Runner, name string) error {
 	return nil
 }
 
-// stopService is a no-op on non-FreeBSD platforms
-func stopService(name string) {
+// stopService is a no-op on platforms without rc.d or systemd
+func stopService(r *runner.Runner, name string) {
 }
 
-// restartService is a no-op on non-FreeBSD platforms
-func restartService(name string) error {
+// restartService is a no-op on platforms without rc.d or systemd
+func restartService(r *runner.Runner, name string) error {
 	return nil
 }
 
-// checkService always returns true on non-FreeBSD platforms
+// checkService always returns true on platforms without rc.d or systemd
 func checkService(name string) bool {
 	return true
 }
+
+// serviceEnabled always returns false on platforms without rc.d or systemd,
+// so nothing is reconciled there
+func serviceEnabled(name string) bool {
+	return false
+}
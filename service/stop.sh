@@ -0,0 +1,20 @@
+# Stops a backend's supervisor inside its jail; the rc.d script runs it as
+#   sh -c "$(cat stop.sh)" stop <pidfile> <drain timeout>
+# SIGTERM reaches the backend through run.sh, so it can finish in-flight
+# requests. If the supervisor still runs after the drain timeout, it and
+# its process group are killed.
+pidfile=$1 drain=$2
+
+pid=$(cat "$pidfile" 2>/dev/null)
+[ -n "$pid" ] && kill -TERM "$pid" 2>/dev/null || exit 0
+
+waited=0
+while kill -0 "$pid" 2>/dev/null; do
+    if [ $waited -ge $drain ]; then
+        echo "shipyard: backend still running ${drain}s after SIGTERM; sending SIGKILL"
+        kill -KILL -"$pid" 2>/dev/null || kill -KILL "$pid" 2>/dev/null
+        exit 0
+    fi
+    sleep 1
+    waited=$((waited + 1))
+done
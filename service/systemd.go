@@ -0,0 +1,94 @@
+package service
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+type systemdData struct {
+	Site         string
+	Runtime      string
+	Requires     string
+	DependsOn    []string // the units of the backends it depends on
+	ContainerCmd string
+	Container    string
+	StartCommand string
+	StopCommand  string
+	StopTimeout  int
+}
+
+//go:embed systemd.service.tmpl
+var systemdTmplStr string
+
+var systemdTmpl = template.Must(template.New("systemd").Delims("<%", "%>").Parse(systemdTmplStr))
+
+// systemdUnitDir is where unit files for backend containers are written
+const systemdUnitDir = "/etc/systemd/system"
+
+// unitName returns the systemd unit of a backend's service
+func unitName(svcName string) string {
+	return "shipyard-" + svcName + ".service"
+}
+
+// containerName returns the container a site's backend runs in
+func containerName(siteName string) string {
+	return "shipyard-" + potName(siteName)
+}
+
+// renderUnit returns the systemd unit that runs a backend in its container
+func (m *Manager) renderUnit(siteName string, backend config.BackendConfig) (string, error) {
+	runtime := m.cfg.Jail.DriverName()
+	data := systemdData{
+		Site:         siteName,
+		Runtime:      runtime,
+		ContainerCmd: m.cfg.Jail.ContainerCmd(),
+		Container:    containerName(siteName),
+		DependsOn:    dependencyServices(backend, func(site string) string { return unitName(serviceName(site)) }),
+	}
+	// Podman has no daemon to wait for
+	if runtime == config.DriverDocker {
+		data.Requires = "docker.service"
+	}
+
+	// The exec stays attached, so the unit is active exactly while the
+	// supervisor runs
+	start := []string{data.ContainerCmd, "exec", "--env-file", envFile(m.cfg, siteName)}
+	if uid := backend.RunAsUID(); backend.RunAs != "" && uid > 0 {
+		start = append(start, "-u", strconv.Itoa(uid)+":"+strconv.Itoa(uid))
+	}
+	start = append(start, data.Container)
+	binaryPath := filepath.Join("/usr/local/bin", backend.BinaryName)
+	data.StartCommand = shellJoin(append(start, BackendArgs(m.cfg, backend, binaryPath, "")...))
+	data.StopCommand = shellJoin(append([]string{data.ContainerCmd, "exec", data.Container}, StopArgs(m.cfg, backend)...))
+	// Leave time for the drain and for stopping the container after it
+	data.StopTimeout = backend.DrainTimeoutSeconds() + 30
+
+	var buf bytes.Buffer
+	if err := systemdTmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("execute systemd template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// createUnit writes the systemd unit for a container backend
+func (m *Manager) createUnit(siteName string, backend config.BackendConfig) error {
+	unit, err := m.renderUnit(siteName, backend)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(systemdUnitDir, 0755); err != nil {
+		return fmt.Errorf("mkdir systemd unit dir: %w", err)
+	}
+	path := filepath.Join(systemdUnitDir, unitName(serviceName(siteName)))
+	if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("write systemd unit: %w", err)
+	}
+	return nil
+}
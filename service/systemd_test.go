@@ -0,0 +1,46 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestRenderUnit(t *testing.T) {
+	cfg := &config.Config{Jail: config.JailConfig{Driver: config.DriverDocker}}
+	backend := config.BackendConfig{BinaryName: "api", ListenPort: 8080, RunAs: "app", JailIP: "127.0.1.5"}
+	unit, err := NewManager(cfg).renderUnit("api.example.com", backend)
+	if err != nil {
+		t.Fatalf("renderUnit: %v", err)
+	}
+
+	for _, want := range []string{
+		"MANAGED BY SHIPYARD",
+		"Requires=docker.service",
+		"ExecStartPre=/usr/bin/docker start shipyard-api-example-com",
+		"ExecStart=/usr/bin/docker exec --env-file /var/db/shipyard/env/api_example_com.env -u 30005:30005 shipyard-api-example-com env PORT=8080 HOST=0.0.0.0 SHIPYARD_LOG=/var/log/app.log SHIPYARD_PIDFILE=/var/log/app.pid /bin/sh " + ContainerRunScript + " /usr/local/bin/api always 5 0 60",
+		"ExecStop=/usr/bin/docker exec shipyard-api-example-com /bin/sh " + ContainerStopScript + " /var/log/app.pid 10",
+		"ExecStop=/usr/bin/docker stop -t 10 shipyard-api-example-com",
+		"TimeoutStopSec=40",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("unit missing %q:\n%s", want, unit)
+		}
+	}
+
+	cfg.Jail.Driver = config.DriverPodman
+	backend.DependsOn = []string{"auth.example.com"}
+	unit, _ = NewManager(cfg).renderUnit("api.example.com", backend)
+	if strings.Contains(unit, "docker.service") {
+		t.Errorf("podman unit should not require docker:\n%s", unit)
+	}
+	for _, want := range []string{
+		"After=network-online.target shipyard-auth_example_com.service\n",
+		"Wants=network-online.target shipyard-auth_example_com.service\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("unit missing %q:\n%s", want, unit)
+		}
+	}
+}
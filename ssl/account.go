@@ -0,0 +1,165 @@
+package ssl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// LetsEncryptDir is certbot's config directory
+const LetsEncryptDir = "/usr/local/etc/letsencrypt"
+
+// accountIndex maps an ACME server and account email ("" for none) to the
+// certbot account registered for them. certbot doesn't record account emails
+// itself, and with several accounts for a server it needs --account to pick.
+type accountIndex map[string]map[string]string
+
+// accountIndexPath returns where the account index is kept
+func (m *Manager) accountIndexPath() string {
+	return filepath.Join(m.cfg.StateDir(), "acme-accounts.json")
+}
+
+func (m *Manager) loadAccountIndex() (accountIndex, error) {
+	index := accountIndex{}
+	data, err := os.ReadFile(m.accountIndexPath())
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", m.accountIndexPath(), err)
+	}
+	return index, nil
+}
+
+func (m *Manager) saveAccountIndex(index accountIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.accountIndexPath()), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(m.accountIndexPath(), data, 0600)
+}
+
+// accountsDir returns the directory certbot keeps a server's accounts in,
+// named after the directory URL's host and path
+func accountsDir(configDir, server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid ACME directory %q", server)
+	}
+	return filepath.Join(configDir, "accounts", u.Host, filepath.FromSlash(strings.TrimPrefix(u.Path, "/"))), nil
+}
+
+// listAccounts returns the IDs of the accounts in an accounts directory
+func listAccounts(dir string) []string {
+	entries, _ := os.ReadDir(dir)
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			ids = append(ids, e.Name())
+		}
+	}
+	return ids
+}
+
+// account returns the certbot account for email on the configured ACME
+// server, registering one the first time the pair is used
+func (m *Manager) account(ctx context.Context, email string) (string, error) {
+	m.accountMu.Lock()
+	defer m.accountMu.Unlock()
+
+	server := m.cfg.SSL.ACMEServer()
+	dir, err := accountsDir(LetsEncryptDir, server)
+	if err != nil {
+		return "", err
+	}
+	index, err := m.loadAccountIndex()
+	if err != nil {
+		return "", err
+	}
+	existing := listAccounts(dir)
+	if id := index[server][email]; id != "" && slices.Contains(existing, id) {
+		return id, nil
+	}
+
+	// An account certbot registered before shipyard picked accounts is the
+	// one certificates came from without an email
+	var unknown []string
+	for _, id := range existing {
+		known := false
+		for _, indexed := range index[server] {
+			known = known || indexed == id
+		}
+		if !known {
+			unknown = append(unknown, id)
+		}
+	}
+	id := ""
+	if email == "" && len(unknown) == 1 {
+		id = unknown[0]
+	} else if id, err = m.register(ctx, server, email, dir); err != nil {
+		return "", err
+	}
+
+	if index[server] == nil {
+		index[server] = map[string]string{}
+	}
+	index[server][email] = id
+	return id, m.saveAccountIndex(index)
+}
+
+// registerArgs returns the certbot arguments registering an account in a
+// scratch config directory
+func registerArgs(server, email, scratch string) []string {
+	args := []string{"register",
+		"--config-dir", scratch,
+		"--work-dir", scratch,
+		"--logs-dir", scratch,
+		"--server", server,
+		"--non-interactive",
+		"--agree-tos",
+		"--no-eff-email",
+	}
+	if email == "" {
+		return append(args, "--register-unsafely-without-email")
+	}
+	return append(args, "--email", email)
+}
+
+// register registers a new account and moves it into certbot's accounts
+// directory. certbot register refuses to add a second account for a server,
+// so it registers into an empty config directory first.
+func (m *Manager) register(ctx context.Context, server, email, dir string) (string, error) {
+	scratch, err := os.MkdirTemp(LetsEncryptDir, ".register-")
+	if err != nil {
+		return "", fmt.Errorf("create scratch directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	if output, err := m.certbot(ctx, registerArgs(server, email, scratch)...); err != nil {
+		return "", fmt.Errorf("certbot register failed: %w\nOutput: %s", err, output)
+	}
+
+	src, _ := accountsDir(scratch, server)
+	ids := listAccounts(src)
+	if len(ids) != 1 {
+		return "", fmt.Errorf("certbot register left %d accounts, want 1", len(ids))
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := os.Rename(filepath.Join(src, ids[0]), filepath.Join(dir, ids[0])); err != nil {
+		return "", fmt.Errorf("install account: %w", err)
+	}
+	return ids[0], nil
+}
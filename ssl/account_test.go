@@ -0,0 +1,30 @@
+package ssl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestAccountsDir(t *testing.T) {
+	dir, err := accountsDir(LetsEncryptDir, config.LetsEncryptStaging)
+	if err != nil || dir != LetsEncryptDir+"/accounts/acme-staging-v02.api.letsencrypt.org/directory" {
+		t.Errorf("accountsDir = %q, %v", dir, err)
+	}
+	if _, err := accountsDir(LetsEncryptDir, "not a url"); err == nil {
+		t.Error("accountsDir accepted a directory without a host")
+	}
+}
+
+func TestRegisterArgs(t *testing.T) {
+	args := strings.Join(registerArgs(config.LetsEncryptStaging, "ops@example.com", "/tmp/scratch"), " ")
+	for _, want := range []string{"register", "--config-dir /tmp/scratch", "--server " + config.LetsEncryptStaging, "--email ops@example.com"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args %q missing %q", args, want)
+		}
+	}
+	if args := strings.Join(registerArgs(config.LetsEncryptStaging, "", "/tmp/scratch"), " "); !strings.Contains(args, "--register-unsafely-without-email") {
+		t.Errorf("args %q register an email without one", args)
+	}
+}
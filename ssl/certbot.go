@@ -1,20 +1,33 @@
 package ssl
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/runner"
 )
 
 // AcmeWebroot is the directory where ACME challenges are served from
 const AcmeWebroot = "/var/www/acme"
 
-// Manager handles SSL certificate generation via Let's Encrypt
+// challengeTokenRegex matches ACME HTTP-01 tokens (base64url alphabet)
+var challengeTokenRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Manager handles SSL certificate generation via Let's Encrypt or another
+// ACME CA
 type Manager struct {
-	cfg *config.Config
+	cfg       *config.Config
+	accountMu sync.Mutex
 }
 
 // NewManager creates a new SSL manager
@@ -22,9 +35,18 @@ func NewManager(cfg *config.Config) *Manager {
 	return &Manager{cfg: cfg}
 }
 
+// CertName returns the certbot lineage name for a domain. A wildcard domain
+// can't name a directory, so *.docs.example.com becomes wildcard.docs.example.com.
+func CertName(domain string) string {
+	if rest, ok := strings.CutPrefix(domain, "*."); ok {
+		return "wildcard." + rest
+	}
+	return domain
+}
+
 // CertPaths returns the paths to the SSL certificate and key for a domain
 func CertPaths(domain string) (certPath, keyPath string) {
-	base := filepath.Join("/usr/local/etc/letsencrypt/live", domain)
+	base := filepath.Join(LetsEncryptDir, "live", CertName(domain))
 	return filepath.Join(base, "fullchain.pem"), filepath.Join(base, "privkey.pem")
 }
 
@@ -36,13 +58,69 @@ func (m *Manager) HasValidCert(domain string) bool {
 	return certErr == nil && keyErr == nil
 }
 
-// ObtainCert obtains a Let's Encrypt certificate for a domain using webroot method
-// Requires nginx to be configured to serve /.well-known/acme-challenge from AcmeWebroot
-func (m *Manager) ObtainCert(domain string) error {
+// CertExpiry returns the NotAfter time of the leaf certificate for a domain
+func CertExpiry(domain string) (time.Time, error) {
+	certPath, _ := CertPaths(domain)
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// fullchain.pem starts with the leaf certificate
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return time.Time{}, fmt.Errorf("no certificate found in %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse certificate: %w", err)
+	}
+	return cert.NotAfter, nil
+}
+
+// certbot runs certbot, trusting ssl.acme_ca_bundle if set. It is killed
+// when ctx ends or after ssl.certbot_timeout.
+func (m *Manager) certbot(ctx context.Context, args ...string) (string, error) {
+	r := &runner.Runner{Timeout: m.cfg.SSL.CertbotTimeoutDuration()}
+	if bundle := m.cfg.SSL.ACMECABundle; bundle != "" {
+		r.Env = []string{"REQUESTS_CA_BUNDLE=" + bundle}
+	}
+	output, err := r.Run(ctx, "certbot", args...)
+	return string(output), err
+}
+
+// accountArgs returns the certbot arguments choosing the ACME server and account
+func accountArgs(server, accountID string) []string {
+	return []string{"--server", server, "--account", accountID, "--non-interactive", "--agree-tos"}
+}
+
+// webrootCertbotArgs returns the certbot arguments for an HTTP-01 certificate
+func webrootCertbotArgs(server, accountID, domain string) []string {
+	args := []string{"certonly", "--webroot", "--webroot-path", AcmeWebroot}
+	args = append(args, accountArgs(server, accountID)...)
+	return append(args, "-d", domain)
+}
+
+// ObtainCert obtains a certificate for a domain from the configured ACME
+// server using the webroot method, under the account for email (default
+// ssl.acme_email). Requires nginx to be configured to serve
+// /.well-known/acme-challenge from AcmeWebroot. Wildcard domains use a DNS-01
+// challenge instead (see obtainWildcardCert).
+func (m *Manager) ObtainCert(ctx context.Context, domain, email string) error {
 	// Skip if cert already exists
 	if m.HasValidCert(domain) {
 		return nil
 	}
+	if email == "" {
+		email = m.cfg.SSL.ACMEEmail
+	}
+	accountID, err := m.account(ctx, email)
+	if err != nil {
+		return fmt.Errorf("acme account: %w", err)
+	}
+	if config.IsWildcardDomain(domain) {
+		return m.obtainWildcardCert(ctx, domain, accountID)
+	}
 
 	// Ensure ACME webroot directory exists
 	acmeDir := filepath.Join(AcmeWebroot, ".well-known", "acme-challenge")
@@ -50,19 +128,9 @@ func (m *Manager) ObtainCert(domain string) error {
 		return fmt.Errorf("create acme directory: %w", err)
 	}
 
-	// Run certbot with webroot
-	cmd := exec.Command("certbot", "certonly",
-		"--webroot",
-		"--webroot-path", AcmeWebroot,
-		"--non-interactive",
-		"--agree-tos",
-		"--register-unsafely-without-email",
-		"-d", domain,
-	)
-	output, err := cmd.CombinedOutput()
-
+	output, err := m.certbot(ctx, webrootCertbotArgs(m.cfg.SSL.ACMEServer(), accountID, domain)...)
 	if err != nil {
-		return fmt.Errorf("certbot failed: %w\nOutput: %s", err, string(output))
+		return fmt.Errorf("certbot failed: %w\nOutput: %s", err, output)
 	}
 
 	// Verify cert was created
@@ -73,16 +141,54 @@ func (m *Manager) ObtainCert(domain string) error {
 	return nil
 }
 
-// RenewAll renews all certificates that are close to expiry
-func (m *Manager) RenewAll() error {
-	cmd := exec.Command("certbot", "renew",
-		"--webroot",
-		"--webroot-path", AcmeWebroot,
-		"--quiet",
-	)
-	output, err := cmd.CombinedOutput()
+// dnsCertbotArgs returns the certbot arguments for a wildcard certificate
+// using the configured DNS plugin
+func dnsCertbotArgs(cfg config.SSLConfig, accountID, domain string) []string {
+	plugin := "dns-" + cfg.DNSPlugin
+	args := []string{"certonly", "--authenticator", plugin}
+	if cfg.DNSCredentials != "" {
+		args = append(args, "--"+plugin+"-credentials", cfg.DNSCredentials)
+	}
+	if cfg.DNSPropagationSeconds > 0 {
+		args = append(args, "--"+plugin+"-propagation-seconds", strconv.Itoa(cfg.DNSPropagationSeconds))
+	}
+	args = append(args, accountArgs(cfg.ACMEServer(), accountID)...)
+	return append(args, "--cert-name", CertName(domain), "-d", domain)
+}
+
+// obtainWildcardCert obtains a wildcard certificate with a DNS-01 challenge,
+// which Let's Encrypt requires for wildcard names
+func (m *Manager) obtainWildcardCert(ctx context.Context, domain, accountID string) error {
+	if m.cfg.SSL.DNSPlugin == "" {
+		return fmt.Errorf("wildcard certificate for %s needs ssl.dns_plugin", domain)
+	}
+
+	output, err := m.certbot(ctx, dnsCertbotArgs(m.cfg.SSL, accountID, domain)...)
 	if err != nil {
-		return fmt.Errorf("certbot renew failed: %w\nOutput: %s", err, string(output))
+		return fmt.Errorf("certbot failed: %w\nOutput: %s", err, output)
+	}
+
+	if !m.HasValidCert(domain) {
+		return fmt.Errorf("certbot succeeded but certificate not found at expected path")
+	}
+	return nil
+}
+
+// RenewAll renews all certificates that are close to expiry. Each lineage
+// renews with the authenticator, ACME server and account it was issued with.
+func (m *Manager) RenewAll(ctx context.Context) error {
+	output, err := m.certbot(ctx, "renew", "--quiet")
+	if err != nil {
+		return fmt.Errorf("certbot renew failed: %w\nOutput: %s", err, output)
 	}
 	return nil
 }
+
+// ChallengeResponse returns the key authorization certbot wrote for an HTTP-01 token.
+// Tokens outside the base64url alphabet are rejected so they can't escape the webroot.
+func ChallengeResponse(token string) ([]byte, error) {
+	if !challengeTokenRegex.MatchString(token) {
+		return nil, fmt.Errorf("invalid challenge token")
+	}
+	return os.ReadFile(filepath.Join(AcmeWebroot, ".well-known", "acme-challenge", token))
+}
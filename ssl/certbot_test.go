@@ -0,0 +1,46 @@
+package ssl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lachierussell/shipyard/config"
+)
+
+func TestCertPaths_Wildcard(t *testing.T) {
+	cert, key := CertPaths("*.docs.example.com")
+	if cert != "/usr/local/etc/letsencrypt/live/wildcard.docs.example.com/fullchain.pem" {
+		t.Errorf("cert = %q", cert)
+	}
+	if key != "/usr/local/etc/letsencrypt/live/wildcard.docs.example.com/privkey.pem" {
+		t.Errorf("key = %q", key)
+	}
+	if CertName("example.com") != "example.com" {
+		t.Errorf("CertName() should leave plain domains alone")
+	}
+}
+
+func TestDNSCertbotArgs(t *testing.T) {
+	args := strings.Join(dnsCertbotArgs(config.SSLConfig{
+		DNSPlugin:             "cloudflare",
+		DNSCredentials:        "/usr/local/etc/shipyard/cloudflare.ini",
+		DNSPropagationSeconds: 30,
+	}, "1234abcd", "*.docs.example.com"), " ")
+
+	for _, want := range []string{
+		"certonly --authenticator dns-cloudflare",
+		"--dns-cloudflare-credentials /usr/local/etc/shipyard/cloudflare.ini",
+		"--dns-cloudflare-propagation-seconds 30",
+		"--server " + config.LetsEncryptProduction,
+		"--account 1234abcd",
+		"--cert-name wildcard.docs.example.com",
+		"-d *.docs.example.com",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args %q missing %q", args, want)
+		}
+	}
+	if strings.Contains(args, "webroot") {
+		t.Errorf("DNS challenge should not use webroot: %q", args)
+	}
+}
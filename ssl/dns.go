@@ -0,0 +1,77 @@
+package ssl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// dnsLookupTimeout bounds the DNS pre-check of a new site
+const dnsLookupTimeout = 5 * time.Second
+
+// DNSCheck compares a domain's DNS with this host's addresses before an
+// HTTP-01 certificate is requested for it
+type DNSCheck struct {
+	Resolved   []string `json:"resolved"`
+	HostIPs    []string `json:"host_ips"`
+	PointsHere bool     `json:"points_here"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Message explains a check that failed
+func (d DNSCheck) Message() string {
+	if d.Error != "" {
+		return "domain does not resolve: " + d.Error
+	}
+	return fmt.Sprintf("domain resolves to %s, not this host (%s)",
+		strings.Join(d.Resolved, ", "), strings.Join(d.HostIPs, ", "))
+}
+
+// CheckDNS resolves domain and reports whether it points at one of this
+// host's addresses: ssl.host_ips, or the host's interface addresses when
+// that is unset (which misses a public address behind NAT)
+func (m *Manager) CheckDNS(domain string) DNSCheck {
+	hostIPs := m.cfg.SSL.HostIPs
+	if len(hostIPs) == 0 {
+		hostIPs = interfaceIPs()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+	resolved, err := net.DefaultResolver.LookupHost(ctx, domain)
+	return compareDNS(resolved, err, hostIPs)
+}
+
+// compareDNS builds the check from a lookup's result
+func compareDNS(resolved []string, lookupErr error, hostIPs []string) DNSCheck {
+	d := DNSCheck{Resolved: resolved, HostIPs: hostIPs}
+	if lookupErr != nil {
+		d.Error = lookupErr.Error()
+		return d
+	}
+	for _, addr := range resolved {
+		ip := net.ParseIP(addr)
+		d.PointsHere = d.PointsHere || slices.ContainsFunc(hostIPs, func(h string) bool {
+			return ip != nil && ip.Equal(net.ParseIP(h))
+		})
+	}
+	return d
+}
+
+// interfaceIPs returns the host's non-loopback interface addresses
+func interfaceIPs() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []string
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP.String())
+		}
+	}
+	return ips
+}
@@ -0,0 +1,23 @@
+package ssl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompareDNS(t *testing.T) {
+	hostIPs := []string{"203.0.113.10", "2001:db8::10"}
+
+	if d := compareDNS([]string{"198.51.100.1", "2001:db8:0:0::10"}, nil, hostIPs); !d.PointsHere {
+		t.Errorf("%+v: want points_here for a matching IPv6 address", d)
+	}
+	d := compareDNS([]string{"198.51.100.1"}, nil, hostIPs)
+	if d.PointsHere || !strings.Contains(d.Message(), "198.51.100.1") {
+		t.Errorf("%+v: want not points_here naming the resolved address", d)
+	}
+	d = compareDNS(nil, errors.New("no such host"), hostIPs)
+	if d.PointsHere || !strings.Contains(d.Message(), "no such host") {
+		t.Errorf("%+v: want not points_here with the lookup error", d)
+	}
+}
@@ -0,0 +1,272 @@
+// Package statuspage builds the public status page: the current health,
+// daily uptime and recent outages of the sites listed in [status_page]
+package statuspage
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/health"
+)
+
+// Site and page statuses
+const (
+	StatusOperational = "operational" // the site answers its health probe
+	StatusDown        = "down"        // it doesn't
+	StatusDegraded    = "degraded"    // the page: some sites are down
+	StatusUnknown     = "unknown"     // not probed yet
+)
+
+// pageDays is how many days of uptime the page shows
+const pageDays = 90
+
+// pageOutages is how many recent outages the page lists
+const pageOutages = 20
+
+// PublishDir is the directory under the status page site's frontend_root
+// the page is written to; "latest" is pointed at it
+const PublishDir = "statuspage"
+
+// Page is what the status page shows
+type Page struct {
+	Title     string    `json:"title"`
+	Status    string    `json:"status"`
+	Generated time.Time `json:"generated"`
+	Sites     []Site    `json:"sites"`
+	Outages   []Outage  `json:"outages"` // newest first
+}
+
+// Site is one site's row on the page
+type Site struct {
+	Name   string   `json:"name"`
+	Status string   `json:"status"`
+	Uptime *float64 `json:"uptime_percent"` // over the days shown; nil before the first probe
+	Days   []Day    `json:"days"`           // oldest first
+}
+
+// Day is a site's uptime on one day
+type Day struct {
+	Date   string   `json:"date"`
+	Uptime *float64 `json:"uptime_percent"` // nil when it wasn't probed that day
+}
+
+// Outage is a period a site failed its health probe
+type Outage struct {
+	Site     string     `json:"site"`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end,omitempty"` // nil while it lasts
+	Duration float64    `json:"duration_seconds"`
+}
+
+// Build assembles the page from the latest probes and the uptime history
+func Build(cfg *config.Config, checker *health.SiteChecker, uptime *health.UptimeLog, now time.Time) Page {
+	page := Page{
+		Title:     cfg.StatusPage.PageTitle(),
+		Status:    StatusUnknown,
+		Generated: now.UTC(),
+		Sites:     []Site{},
+		Outages:   []Outage{},
+	}
+	down := 0
+	for _, name := range cfg.StatusPageSites() {
+		history := uptime.Get(name)
+		site := Site{Name: name, Status: siteStatus(checker.Get(name)), Days: days(history, now)}
+		if pct, ok := history.Percent(); ok {
+			site.Uptime = &pct
+		}
+		switch site.Status {
+		case StatusDown:
+			down++
+			page.Status = StatusDegraded
+		case StatusOperational:
+			if page.Status == StatusUnknown {
+				page.Status = StatusOperational
+			}
+		}
+		page.Sites = append(page.Sites, site)
+		for _, o := range history.Outages {
+			page.Outages = append(page.Outages, Outage{Site: name, Start: o.Start, End: o.End, Duration: o.Duration(now).Seconds()})
+		}
+	}
+	if down > 0 && down == len(page.Sites) {
+		page.Status = StatusDown
+	}
+
+	sort.Slice(page.Outages, func(i, j int) bool { return page.Outages[i].Start.After(page.Outages[j].Start) })
+	if len(page.Outages) > pageOutages {
+		page.Outages = page.Outages[:pageOutages]
+	}
+	return page
+}
+
+// siteStatus turns a site's latest probe into its status on the page. A
+// probe that couldn't reach the site counts as down.
+func siteStatus(h health.SiteHealth) string {
+	switch {
+	case h.CheckedAt.IsZero():
+		return StatusUnknown
+	case h.Status == health.SiteHealthy:
+		return StatusOperational
+	}
+	return StatusDown
+}
+
+// days returns the uptime of each of the last pageDays days, oldest first
+func days(history health.SiteUptime, now time.Time) []Day {
+	byDate := make(map[string]health.UptimeDay, len(history.Days))
+	for _, d := range history.Days {
+		byDate[d.Date] = d
+	}
+	out := make([]Day, 0, pageDays)
+	for i := pageDays - 1; i >= 0; i-- {
+		date := now.UTC().AddDate(0, 0, -i).Format("2006-01-02")
+		day := Day{Date: date}
+		if d, ok := byDate[date]; ok && d.Checks > 0 {
+			pct := 100 * float64(d.Healthy) / float64(d.Checks)
+			day.Uptime = &pct
+		}
+		out = append(out, day)
+	}
+	return out
+}
+
+//go:embed page.html.tmpl
+var pageTmplStr string
+
+var pageTmpl = template.Must(template.New("statuspage").Funcs(template.FuncMap{
+	"percent": func(p *float64) string {
+		if p == nil {
+			return "no data"
+		}
+		return fmt.Sprintf("%.2f%%", *p)
+	},
+	"level": func(p *float64) string {
+		switch {
+		case p == nil:
+			return "none"
+		case *p >= 99.9:
+			return "up"
+		case *p >= 95:
+			return "partial"
+		}
+		return "down"
+	},
+	"time": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 UTC") },
+	"duration": func(seconds float64) string {
+		return (time.Duration(seconds) * time.Second).Round(time.Minute).String()
+	},
+}).Parse(pageTmplStr))
+
+// Render returns the page as HTML
+func Render(page Page) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pageTmpl.Execute(&buf, page); err != nil {
+		return nil, fmt.Errorf("render status page: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Publish writes the page, as index.html and status.json, to PublishDir
+// under the status page site's frontend_root and points the site's latest
+// at it
+func Publish(cfg *config.Config, page Page) error {
+	site, ok := cfg.Site[cfg.StatusPage.Site]
+	if !ok {
+		return fmt.Errorf("status page site %q not found", cfg.StatusPage.Site)
+	}
+	html, err := Render(page)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		return fmt.Errorf("encode status page: %w", err)
+	}
+
+	dir := filepath.Join(site.FrontendRoot, PublishDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create status page directory: %w", err)
+	}
+	for name, content := range map[string][]byte{"index.html": html, "status.json": data} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path+".tmp", content, 0644); err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+
+	latest := filepath.Join(site.FrontendRoot, "latest")
+	if target, err := os.Readlink(latest); err == nil && target == PublishDir {
+		return nil
+	}
+	tmp := latest + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(PublishDir, tmp); err != nil {
+		return fmt.Errorf("create latest symlink: %w", err)
+	}
+	if err := os.Rename(tmp, latest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("rename latest symlink: %w", err)
+	}
+	return nil
+}
+
+// Publisher republishes the status page every health poll interval when
+// status_page.site is set
+type Publisher struct {
+	cfg      *config.Config
+	checker  *health.SiteChecker
+	uptime   *health.UptimeLog
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPublisher creates a publisher; call Start to begin publishing
+func NewPublisher(cfg *config.Config, checker *health.SiteChecker, uptime *health.UptimeLog) *Publisher {
+	return &Publisher{cfg: cfg, checker: checker, uptime: uptime, done: make(chan struct{})}
+}
+
+// Start publishes every health poll interval. It does nothing unless
+// status_page.site is set.
+func (p *Publisher) Start() {
+	if p.cfg.StatusPage.Site == "" {
+		return
+	}
+	interval := p.cfg.Health.PollInterval
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				page := Build(p.cfg, p.checker, p.uptime, time.Now())
+				if err := Publish(p.cfg, page); err != nil {
+					slog.Warn("failed to publish status page", "site", p.cfg.StatusPage.Site, "error", err)
+				}
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops publishing
+func (p *Publisher) Stop() {
+	p.stopOnce.Do(func() { close(p.done) })
+}
@@ -0,0 +1,99 @@
+package statuspage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/health"
+)
+
+func TestBuild(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	cfg := &config.Config{
+		StatusPage: config.StatusPageConfig{Sites: []string{"api.example.com"}, Tag: "public"},
+		Site: map[string]config.SiteConfig{
+			"api.example.com":      {},
+			"www.example.com":      {Tags: []string{"public"}},
+			"internal.example.com": {},
+		},
+	}
+	uptime := health.NewUptimeLog(filepath.Join(t.TempDir(), "uptime.json"))
+	uptime.Record("api.example.com", true, now.Add(-48*time.Hour))
+	uptime.Record("api.example.com", false, now.Add(-time.Hour))
+	uptime.Record("www.example.com", false, now.Add(-2*time.Hour))
+	uptime.Record("www.example.com", true, now.Add(-90*time.Minute))
+
+	page := Build(cfg, nil, uptime, now)
+	if page.Title != config.DefaultStatusPageTitle || page.Status != StatusUnknown {
+		t.Errorf("title, status = %q, %q; want the default title and unknown", page.Title, page.Status)
+	}
+	if len(page.Sites) != 2 || page.Sites[0].Name != "api.example.com" || page.Sites[1].Name != "www.example.com" {
+		t.Fatalf("sites = %+v, want api and www", page.Sites)
+	}
+	api := page.Sites[0]
+	if api.Uptime == nil || *api.Uptime != 50 || len(api.Days) != pageDays {
+		t.Errorf("api = %+v, want 50%% uptime over %d days", api, pageDays)
+	}
+	if d := api.Days[pageDays-3]; d.Date != "2026-03-08" || d.Uptime == nil || *d.Uptime != 100 {
+		t.Errorf("api two days ago = %+v, want 100%%", d)
+	}
+	if api.Days[0].Uptime != nil {
+		t.Errorf("api 89 days ago = %+v, want no data", api.Days[0])
+	}
+	if len(page.Outages) != 2 || page.Outages[0].Site != "api.example.com" || page.Outages[0].End != nil || page.Outages[0].Duration != 3600 {
+		t.Errorf("outages = %+v, want api's ongoing hour first", page.Outages)
+	}
+
+	html, err := Render(page)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	for _, want := range []string{"<title>Service Status</title>", "api.example.com", "50.00% uptime", "(ongoing)", "1h0m0s"} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("page missing %q", want)
+		}
+	}
+}
+
+func TestSiteStatus(t *testing.T) {
+	checked := time.Now()
+	for _, tt := range []struct {
+		h    health.SiteHealth
+		want string
+	}{
+		{health.SiteHealth{Status: health.SiteUnknown}, StatusUnknown},
+		{health.SiteHealth{Status: health.SiteHealthy, CheckedAt: checked}, StatusOperational},
+		{health.SiteHealth{Status: health.SiteUnhealthy, CheckedAt: checked}, StatusDown},
+		{health.SiteHealth{Status: health.SiteUnknown, CheckedAt: checked}, StatusDown},
+	} {
+		if got := siteStatus(tt.h); got != tt.want {
+			t.Errorf("siteStatus(%+v) = %q, want %q", tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	root := t.TempDir()
+	cfg := &config.Config{
+		StatusPage: config.StatusPageConfig{Sites: []string{"api.example.com"}, Site: "status.example.com"},
+		Site: map[string]config.SiteConfig{
+			"api.example.com":    {},
+			"status.example.com": {FrontendRoot: root},
+		},
+	}
+	if err := Publish(cfg, Build(cfg, nil, nil, time.Now())); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if target, _ := os.Readlink(filepath.Join(root, "latest")); target != PublishDir {
+		t.Errorf("latest -> %q, want %q", target, PublishDir)
+	}
+	for _, name := range []string{"index.html", "status.json"} {
+		if _, err := os.Stat(filepath.Join(root, "latest", name)); err != nil {
+			t.Errorf("%s not published: %v", name, err)
+		}
+	}
+}
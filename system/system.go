@@ -0,0 +1,157 @@
+package system
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/lachierussell/shipyard/config"
+	"github.com/lachierussell/shipyard/jail"
+)
+
+// Status is a snapshot of host capacity
+type Status struct {
+	Hostname string         `json:"hostname"`
+	Disks    []DiskUsage    `json:"disks"`
+	Load     *LoadAverage   `json:"load,omitempty"`
+	Memory   *Memory        `json:"memory,omitempty"`
+	Nginx    NginxStatus    `json:"nginx"`
+	Jails    map[string]int `json:"jail_processes"` // site -> process count, running pots only
+}
+
+// DiskUsage is the space on the filesystem holding Path
+type DiskUsage struct {
+	Path       string `json:"path"`
+	Purpose    string `json:"purpose"`
+	TotalBytes uint64 `json:"total_bytes"`
+	FreeBytes  uint64 `json:"free_bytes"` // available to unprivileged users
+	UsedBytes  uint64 `json:"used_bytes"`
+}
+
+// LoadAverage is the 1, 5 and 15 minute run queue length
+type LoadAverage struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
+
+// Memory is physical memory usage
+type Memory struct {
+	TotalBytes     uint64 `json:"total_bytes"`
+	AvailableBytes uint64 `json:"available_bytes"`
+}
+
+// NginxStatus reports whether nginx is running and how many workers it has
+type NginxStatus struct {
+	Running bool `json:"running"`
+	Workers int  `json:"workers"`
+}
+
+// Collect gathers host status. Each part is best-effort: anything that
+// can't be read on this host is left out and logged.
+func Collect(cfg *config.Config) *Status {
+	st := &Status{
+		Disks: diskUsage(cfg),
+		Nginx: nginxStatus(),
+		Jails: map[string]int{},
+	}
+
+	st.Hostname, _ = os.Hostname()
+
+	if load, err := loadAverage(); err == nil {
+		st.Load = load
+	} else {
+		slog.Debug("read load average", "error", err)
+	}
+
+	if mem, err := memory(); err == nil {
+		st.Memory = mem
+	} else {
+		slog.Debug("read memory", "error", err)
+	}
+
+	if counts, err := jail.NewManager(cfg).ProcessCounts(); err == nil {
+		st.Jails = counts
+	} else {
+		slog.Debug("count jail processes", "error", err)
+	}
+
+	return st
+}
+
+// diskUsage reports the filesystems shipyard writes to
+func diskUsage(cfg *config.Config) []DiskUsage {
+	paths := map[string]string{
+		cfg.StateDir():           "state",
+		cfg.Nginx.SitesAvailable: "nginx",
+		"/opt/pot":               "jails",
+	}
+	if cfg.Self.BinaryPath != "" {
+		paths[cfg.Self.BinaryPath] = "binary"
+	}
+	for name, site := range cfg.Site {
+		if site.HasFrontend() {
+			paths[site.FrontendRoot] = "frontend:" + name
+		}
+	}
+
+	disks := []DiskUsage{}
+	for path, purpose := range paths {
+		if path == "" {
+			continue
+		}
+		var fs syscall.Statfs_t
+		if err := syscall.Statfs(path, &fs); err != nil {
+			slog.Debug("statfs", "path", path, "error", err)
+			continue
+		}
+		bsize := uint64(fs.Bsize)
+		total := uint64(fs.Blocks) * bsize
+		disks = append(disks, DiskUsage{
+			Path:       path,
+			Purpose:    purpose,
+			TotalBytes: total,
+			FreeBytes:  uint64(fs.Bavail) * bsize,
+			UsedBytes:  total - uint64(fs.Bfree)*bsize,
+		})
+	}
+
+	sort.Slice(disks, func(i, j int) bool { return disks[i].Path < disks[j].Path })
+	return disks
+}
+
+// nginxStatus counts nginx master and worker processes
+func nginxStatus() NginxStatus {
+	return NginxStatus{
+		Running: countProcesses("nginx: master process") > 0,
+		Workers: countProcesses("nginx: worker process"),
+	}
+}
+
+// countProcesses returns how many processes have a command line matching pattern
+func countProcesses(pattern string) int {
+	out, err := exec.Command("pgrep", "-f", pattern).Output()
+	if err != nil {
+		// pgrep exits 1 when nothing matches
+		return 0
+	}
+	return len(strings.Fields(string(out)))
+}
+
+// parseLoadAverage parses three load figures from fields
+func parseLoadAverage(fields []string) (*LoadAverage, error) {
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("unexpected load average %q", strings.Join(fields, " "))
+	}
+	var vals [3]float64
+	for i := range vals {
+		if _, err := fmt.Sscanf(fields[i], "%g", &vals[i]); err != nil {
+			return nil, fmt.Errorf("parse load average %q: %w", fields[i], err)
+		}
+	}
+	return &LoadAverage{Load1: vals[0], Load5: vals[1], Load15: vals[2]}, nil
+}
@@ -0,0 +1,46 @@
+//go:build freebsd
+
+package system
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// loadAverage reads vm.loadavg, formatted "{ 0.10 0.20 0.30 }"
+func loadAverage() (*LoadAverage, error) {
+	out, err := exec.Command("sysctl", "-n", "vm.loadavg").Output()
+	if err != nil {
+		return nil, fmt.Errorf("sysctl vm.loadavg: %w", err)
+	}
+	return parseLoadAverage(strings.Fields(strings.Trim(strings.TrimSpace(string(out)), "{}")))
+}
+
+// memory reads physical memory and free + inactive pages from sysctl
+func memory() (*Memory, error) {
+	names := []string{"hw.physmem", "hw.pagesize", "vm.stats.vm.v_free_count", "vm.stats.vm.v_inactive_count"}
+	out, err := exec.Command("sysctl", append([]string{"-n"}, names...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("sysctl memory: %w", err)
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) != len(names) {
+		return nil, fmt.Errorf("unexpected sysctl output %q", string(out))
+	}
+	vals := make([]uint64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", names[i], err)
+		}
+		vals[i] = v
+	}
+
+	return &Memory{
+		TotalBytes:     vals[0],
+		AvailableBytes: (vals[2] + vals[3]) * vals[1],
+	}, nil
+}
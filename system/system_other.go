@@ -0,0 +1,52 @@
+//go:build !freebsd
+
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadAverage reads /proc/loadavg (Linux development hosts)
+func loadAverage() (*LoadAverage, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, err
+	}
+	return parseLoadAverage(strings.Fields(string(data)))
+}
+
+// memory reads /proc/meminfo (Linux development hosts)
+func memory() (*Memory, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mem := &Memory{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			mem.TotalBytes = kb << 10
+		case "MemAvailable:":
+			mem.AvailableBytes = kb << 10
+		}
+	}
+	if mem.TotalBytes == 0 {
+		return nil, fmt.Errorf("MemTotal not found in /proc/meminfo")
+	}
+	return mem, scanner.Err()
+}
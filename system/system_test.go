@@ -0,0 +1,17 @@
+package system
+
+import "testing"
+
+func TestParseLoadAverage(t *testing.T) {
+	load, err := parseLoadAverage([]string{"0.52", "1.10", "2.00", "1/123", "4567"})
+	if err != nil {
+		t.Fatalf("parseLoadAverage() error = %v", err)
+	}
+	if load.Load1 != 0.52 || load.Load5 != 1.10 || load.Load15 != 2.00 {
+		t.Errorf("parseLoadAverage() = %+v", load)
+	}
+
+	if _, err := parseLoadAverage([]string{"0.52"}); err == nil {
+		t.Error("parseLoadAverage() accepted too few fields")
+	}
+}
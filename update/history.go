@@ -0,0 +1,57 @@
+package update
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/lachierussell/shipyard/jsonl"
+)
+
+// History event types
+const (
+	EventUpdate   = "update"
+	EventRollback = "rollback"
+)
+
+// Record is a single entry in the self-update history
+type Record struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	OldVersion string    `json:"old_version"`
+	OldCommit  string    `json:"old_commit,omitempty"`
+	NewVersion string    `json:"new_version"`
+	NewCommit  string    `json:"new_commit,omitempty"`
+	Initiator  string    `json:"initiator"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Forced     bool      `json:"forced,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// HistoryPath returns the history file location within a state directory
+func HistoryPath(stateDir string) string {
+	return filepath.Join(stateDir, "self-updates.jsonl")
+}
+
+// History is an append-only JSON lines log of self-updates and rollbacks
+type History struct {
+	log *jsonl.Log
+}
+
+// NewHistory creates a History stored at path
+func NewHistory(path string) *History {
+	return &History{log: jsonl.New(path, jsonl.MaxSize)}
+}
+
+// Append adds a record to the history file
+func (h *History) Append(rec Record) error {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	return h.log.Append(rec)
+}
+
+// List returns all records, newest first. A missing file is an empty history.
+func (h *History) List() ([]Record, error) {
+	return jsonl.Read[Record](h.log, nil)
+}
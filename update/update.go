@@ -1,11 +1,14 @@
 package update
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,30 +27,44 @@ func NewUpdater(binaryPath string) *Updater {
 // 1. Write incoming binary to {binaryPath}.new
 // 2. Set executable permissions (0755)
 // 3. Validate by running {binaryPath}.new version
-// 4. Rename current binary to {binaryPath}.old (backup)
-// 5. Rename .new to main path (atomic!)
-func (u *Updater) Update(newBinary io.Reader) error {
+// 4. Check the reported version against the policy
+// 5. Rename current binary to {binaryPath}.old (backup)
+// 6. Rename .new to main path (atomic!)
+// Returns the version info reported by the new binary.
+func (u *Updater) Update(newBinary io.Reader, policy Policy) (BinaryInfo, error) {
 	newPath := u.binaryPath + ".new"
 	oldPath := u.binaryPath + ".old"
 
 	// Step 1: Write new binary to temp location
 	if err := u.writeNewBinary(newPath, newBinary); err != nil {
-		return fmt.Errorf("write new binary: %w", err)
+		return BinaryInfo{}, fmt.Errorf("write new binary: %w", err)
 	}
 
 	// Step 2: Validate the new binary
-	if err := u.validateBinary(newPath); err != nil {
+	info, err := u.validateBinary(newPath)
+	if err != nil {
+		os.Remove(newPath)
+		return BinaryInfo{}, fmt.Errorf("validate binary: %w", err)
+	}
+
+	// Step 3: Enforce min/pin/downgrade rules
+	if err := policy.Check(info.Version); err != nil {
 		os.Remove(newPath)
-		return fmt.Errorf("validate binary: %w", err)
+		return info, err
 	}
 
-	// Step 3: Atomic replacement
+	// Step 4: Atomic replacement
 	if err := u.atomicReplace(newPath, oldPath); err != nil {
 		os.Remove(newPath)
-		return fmt.Errorf("atomic replace: %w", err)
+		return info, fmt.Errorf("atomic replace: %w", err)
 	}
 
-	return nil
+	// Step 5: Mark the update as unverified until the new binary confirms it serves traffic
+	if err := os.WriteFile(u.pendingPath(), []byte("0\n"), 0644); err != nil {
+		return info, fmt.Errorf("write pending marker: %w", err)
+	}
+
+	return info, nil
 }
 
 // writeNewBinary writes the binary data to the specified path with executable permissions
@@ -78,21 +95,24 @@ func (u *Updater) writeNewBinary(path string, data io.Reader) error {
 	return nil
 }
 
-// validateBinary checks that the binary at path is executable and responds to "version"
-func (u *Updater) validateBinary(path string) error {
+// validateBinary checks that the binary at path is executable and responds to "version".
+// Returns the reported version, or "unknown" if the output isn't recognised.
+func (u *Updater) validateBinary(path string) (BinaryInfo, error) {
 	// Check file exists and has executable bit
 	info, err := os.Stat(path)
 	if err != nil {
-		return fmt.Errorf("stat binary: %w", err)
+		return BinaryInfo{}, fmt.Errorf("stat binary: %w", err)
 	}
 
 	if info.Mode()&0111 == 0 {
-		return fmt.Errorf("binary is not executable")
+		return BinaryInfo{}, fmt.Errorf("binary is not executable")
 	}
 
 	// Run the binary with "version" command to verify it works
+	var stdout bytes.Buffer
 	cmd := exec.Command(path, "version")
 	cmd.Env = os.Environ()
+	cmd.Stdout = &stdout
 
 	// Set a timeout for validation
 	done := make(chan error, 1)
@@ -103,14 +123,18 @@ func (u *Updater) validateBinary(path string) error {
 	select {
 	case err := <-done:
 		if err != nil {
-			return fmt.Errorf("binary validation failed: %w", err)
+			return BinaryInfo{}, fmt.Errorf("binary validation failed: %w", err)
 		}
 	case <-time.After(10 * time.Second):
 		cmd.Process.Kill()
-		return fmt.Errorf("binary validation timed out")
+		return BinaryInfo{}, fmt.Errorf("binary validation timed out")
 	}
 
-	return nil
+	version, err := parseVersionOutput(stdout.String())
+	if err != nil {
+		return BinaryInfo{Version: "unknown", Commit: "unknown"}, nil
+	}
+	return version, nil
 }
 
 // atomicReplace performs the atomic replacement of the binary.
@@ -144,6 +168,11 @@ func (u *Updater) HasBackup() bool {
 	return err == nil
 }
 
+// BackupInfo returns the version of the .old backup binary
+func (u *Updater) BackupInfo() (BinaryInfo, error) {
+	return u.validateBinary(u.binaryPath + ".old")
+}
+
 // Rollback restores the previous binary from the .old backup
 func (u *Updater) Rollback() error {
 	oldPath := u.binaryPath + ".old"
@@ -171,5 +200,43 @@ func (u *Updater) Rollback() error {
 	// Clean up the .new file (the binary we just rolled back from)
 	os.Remove(newPath)
 
+	// The restored binary is known-good, nothing left to verify
+	os.Remove(u.pendingPath())
+
+	return nil
+}
+
+// pendingPath is the marker written by Update and removed once the new binary is verified.
+// It holds the number of times the new binary has started without verifying.
+func (u *Updater) pendingPath() string {
+	return u.binaryPath + ".pending"
+}
+
+// Pending returns true if the last update has not yet been verified
+func (u *Updater) Pending() bool {
+	_, err := os.Stat(u.pendingPath())
+	return err == nil
+}
+
+// RecordStartAttempt increments and returns the number of unverified starts of the pending update
+func (u *Updater) RecordStartAttempt() (int, error) {
+	data, err := os.ReadFile(u.pendingPath())
+	if err != nil {
+		return 0, fmt.Errorf("read pending marker: %w", err)
+	}
+	attempts, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	attempts++
+	if err := os.WriteFile(u.pendingPath(), []byte(strconv.Itoa(attempts)+"\n"), 0644); err != nil {
+		return 0, fmt.Errorf("write pending marker: %w", err)
+	}
+	return attempts, nil
+}
+
+// ConfirmUpdate marks the pending update as verified. The .old backup is kept
+// for manual rollback.
+func (u *Updater) ConfirmUpdate() error {
+	if err := os.Remove(u.pendingPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove pending marker: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,93 @@
+package update
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeBinary returns a script that reports version like shipyard does
+func fakeBinary(version string) string {
+	return "#!/bin/sh\necho 'shipyard version " + version + " (commit abc1234)'\n"
+}
+
+// installed writes the running binary and updates it to 2.0.0, leaving
+// 1.0.0 as the backup
+func installed(t *testing.T) (*Updater, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "shipyard")
+	if err := os.WriteFile(path, []byte(fakeBinary("1.0.0")), 0755); err != nil {
+		t.Fatal(err)
+	}
+	u := NewUpdater(path)
+	info, err := u.Update(strings.NewReader(fakeBinary("2.0.0")), Policy{CurrentVersion: "1.0.0"})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if info.Version != "2.0.0" {
+		t.Fatalf("Update version = %q, want 2.0.0", info.Version)
+	}
+	return u, path
+}
+
+func TestUpdater_StartAttemptsCountedOnMarker(t *testing.T) {
+	u, path := installed(t)
+	if !u.Pending() {
+		t.Fatal("update not pending after Update")
+	}
+
+	for want := 1; want <= 3; want++ {
+		got, err := u.RecordStartAttempt()
+		if err != nil || got != want {
+			t.Fatalf("RecordStartAttempt = %d, %v; want %d", got, err, want)
+		}
+	}
+	// A fresh Updater, as after a restart, reads the count back
+	if got, _ := NewUpdater(path).RecordStartAttempt(); got != 4 {
+		t.Errorf("attempts after restart = %d, want 4", got)
+	}
+}
+
+func TestUpdater_ConfirmClearsMarker(t *testing.T) {
+	u, _ := installed(t)
+	u.RecordStartAttempt()
+
+	if err := u.ConfirmUpdate(); err != nil {
+		t.Fatalf("ConfirmUpdate: %v", err)
+	}
+	if u.Pending() {
+		t.Error("still pending after ConfirmUpdate")
+	}
+	if _, err := u.RecordStartAttempt(); err == nil {
+		t.Error("RecordStartAttempt without a pending update should fail")
+	}
+	if !u.HasBackup() {
+		t.Error("ConfirmUpdate removed the backup")
+	}
+	if err := u.ConfirmUpdate(); err != nil {
+		t.Errorf("second ConfirmUpdate: %v", err)
+	}
+}
+
+func TestUpdater_RollbackRestoresPreviousBinary(t *testing.T) {
+	u, path := installed(t)
+
+	if info, err := u.BackupInfo(); err != nil || info.Version != "1.0.0" {
+		t.Fatalf("BackupInfo = %+v, %v; want 1.0.0", info, err)
+	}
+	if err := u.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	content, _ := os.ReadFile(path)
+	if !strings.Contains(string(content), "1.0.0") {
+		t.Errorf("binary after rollback = %q, want 1.0.0", content)
+	}
+	if u.Pending() || u.HasBackup() {
+		t.Error("rollback left the pending marker or the backup")
+	}
+	if err := u.Rollback(); err == nil {
+		t.Error("second Rollback should fail without a backup")
+	}
+}
@@ -0,0 +1,132 @@
+package update
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// versionOutput matches the output of "shipyard version"
+var versionOutput = regexp.MustCompile(`shipyard version (\S+) \(commit (\S+)\)`)
+
+// BinaryInfo identifies a shipyard build
+type BinaryInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+}
+
+// parseVersionOutput extracts version and commit from "shipyard version" output
+func parseVersionOutput(out string) (BinaryInfo, error) {
+	m := versionOutput.FindStringSubmatch(out)
+	if m == nil {
+		return BinaryInfo{}, fmt.Errorf("unrecognised version output: %q", strings.TrimSpace(out))
+	}
+	return BinaryInfo{Version: m[1], Commit: m[2]}, nil
+}
+
+// ParseVersion parses a semantic version such as "1.4.2" or "v1.4.2-rc1" into its
+// numeric parts and pre-release suffix
+func ParseVersion(v string) ([3]int, string, error) {
+	var parts [3]int
+	s := strings.TrimPrefix(v, "v")
+
+	pre := ""
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		pre = s[i+1:]
+		s = s[:i]
+	}
+
+	fields := strings.Split(s, ".")
+	if len(fields) == 0 || len(fields) > 3 {
+		return parts, "", fmt.Errorf("invalid version %q", v)
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, "", fmt.Errorf("invalid version %q", v)
+		}
+		parts[i] = n
+	}
+	return parts, pre, nil
+}
+
+// CompareVersions returns -1, 0 or 1 as a is older than, equal to or newer than b.
+// A pre-release sorts before its release (1.2.0-rc1 < 1.2.0).
+func CompareVersions(a, b string) (int, error) {
+	pa, prea, err := ParseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, preb, err := ParseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+
+	switch {
+	case prea == preb:
+		return 0, nil
+	case prea == "":
+		return 1, nil
+	case preb == "":
+		return -1, nil
+	case prea < preb:
+		return -1, nil
+	default:
+		return 1, nil
+	}
+}
+
+// Policy restricts which versions an update may install
+type Policy struct {
+	CurrentVersion string // running version; downgrades are refused
+	MinVersion     string // refuse anything older
+	PinVersion     string // refuse anything else
+	Force          bool   // skip all checks
+}
+
+// PolicyError is returned when an update is refused by version policy
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return "version policy: " + e.Reason
+}
+
+// Check returns a *PolicyError if version is not allowed
+func (p Policy) Check(version string) error {
+	if p.Force {
+		return nil
+	}
+
+	if p.PinVersion != "" && version != p.PinVersion {
+		return &PolicyError{Reason: fmt.Sprintf("version %s does not match pin_version %s", version, p.PinVersion)}
+	}
+
+	if p.MinVersion != "" {
+		cmp, err := CompareVersions(version, p.MinVersion)
+		if err != nil {
+			return &PolicyError{Reason: fmt.Sprintf("cannot compare %s against min_version: %v", version, err)}
+		}
+		if cmp < 0 {
+			return &PolicyError{Reason: fmt.Sprintf("version %s is older than min_version %s", version, p.MinVersion)}
+		}
+	}
+
+	// Downgrade check only applies when both sides are release versions (not "dev")
+	if cmp, err := CompareVersions(version, p.CurrentVersion); err == nil && cmp < 0 {
+		return &PolicyError{Reason: fmt.Sprintf("version %s is older than running version %s", version, p.CurrentVersion)}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package update
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.9", 1},
+		{"2.0", "1.99.99", 1},
+		{"1.2.0-rc1", "1.2.0", -1},
+		{"1.2.0-rc2", "1.2.0-rc1", 1},
+	}
+
+	for _, tt := range tests {
+		got, err := CompareVersions(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("CompareVersions(%q, %q) error: %v", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCompareVersions_Invalid(t *testing.T) {
+	if _, err := CompareVersions("dev", "1.0.0"); err == nil {
+		t.Error("expected error for non-semver version")
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		version string
+		allowed bool
+	}{
+		{"upgrade", Policy{CurrentVersion: "1.0.0"}, "1.1.0", true},
+		{"downgrade refused", Policy{CurrentVersion: "1.1.0"}, "1.0.0", false},
+		{"downgrade forced", Policy{CurrentVersion: "1.1.0", Force: true}, "1.0.0", true},
+		{"dev current skips downgrade check", Policy{CurrentVersion: "dev"}, "0.1.0", true},
+		{"below min", Policy{CurrentVersion: "dev", MinVersion: "1.2.0"}, "1.1.0", false},
+		{"unparseable with min", Policy{MinVersion: "1.2.0"}, "dev", false},
+		{"pin matches", Policy{CurrentVersion: "1.0.0", PinVersion: "1.3.0"}, "1.3.0", true},
+		{"pin mismatch", Policy{CurrentVersion: "1.0.0", PinVersion: "1.3.0"}, "1.4.0", false},
+	}
+
+	for _, tt := range tests {
+		err := tt.policy.Check(tt.version)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !tt.allowed {
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) {
+				t.Errorf("%s: error = %v, want *PolicyError", tt.name, err)
+			}
+		}
+	}
+}
+
+func TestParseVersionOutput(t *testing.T) {
+	info, err := parseVersionOutput("shipyard version 1.4.0 (commit abc1234)\n")
+	if err != nil {
+		t.Fatalf("parseVersionOutput: %v", err)
+	}
+	if info.Version != "1.4.0" || info.Commit != "abc1234" {
+		t.Errorf("got %+v", info)
+	}
+}
@@ -569,6 +569,9 @@ export class ShipyardAdmin extends LitElement {
 
     if (ok) {
       this.showToast('success', 'Site Created', `API Key: ${data.api_key}\n\nSave this key for CI/CD deployments.`);
+      if (data.ssl_status === 'dns_not_pointing_here') {
+        this.showToast('info', 'SSL Not Enabled', `${data.warnings.join('\n')}\n\nPoint the domain here, then enable SSL.`);
+      }
       this.newSiteDomain = '';
       this.showCreateForm = false;
       this.listSites();